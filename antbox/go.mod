@@ -2,6 +2,12 @@ module antbox
 
 go 1.21
 
-require github.com/sirupsen/logrus v1.9.3
+require (
+	github.com/sirupsen/logrus v1.9.3
+	github.com/unyeco/roost/pkg/wsconn v0.0.0
+)
 
 require golang.org/x/sys v0.8.0 // indirect
+
+// Shared with the server, which accepts these connections.
+replace github.com/unyeco/roost/pkg/wsconn => ../server/pkg/wsconn
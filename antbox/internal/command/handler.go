@@ -96,10 +96,20 @@ type ActiveEvent struct {
 	StartedAt  time.Time `json:"started_at"`
 }
 
+// Capturer runs the capture pipeline for started events.
+// *pipeline.Manager satisfies it.
+type Capturer interface {
+	// Start begins capturing streamURL for eventID.
+	Start(eventID, streamURL string) error
+	// Stop ends the capture for eventID.
+	Stop(eventID string) error
+}
+
 // Handler processes commands received from AntServer.
 type Handler struct {
 	hdClient  hdhomerun.Client
 	scanner   *scanner.Scanner
+	capturer  Capturer
 	logger    *logrus.Logger
 	startTime time.Time
 
@@ -118,6 +128,14 @@ func NewHandler(hdClient hdhomerun.Client, sc *scanner.Scanner, logger *logrus.L
 	}
 }
 
+// SetCapturer attaches the capture pipeline. Without one, START_EVENT only
+// tunes and records the stream URL.
+func (h *Handler) SetCapturer(c Capturer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.capturer = c
+}
+
 // Handle processes a command and returns a response.
 func (h *Handler) Handle(ctx context.Context, cmd Command) Response {
 	h.logger.WithFields(logrus.Fields{
@@ -214,6 +232,17 @@ func (h *Handler) handleStartEvent(ctx context.Context, cmd Command) Response {
 		return resp
 	}
 
+	h.mu.RLock()
+	capturer := h.capturer
+	h.mu.RUnlock()
+	if capturer != nil {
+		if err := capturer.Start(payload.EventID, streamURL); err != nil {
+			resp.Success = false
+			resp.Error = fmt.Sprintf("failed to start capture: %v", err)
+			return resp
+		}
+	}
+
 	event := &ActiveEvent{
 		EventID:    payload.EventID,
 		DeviceIP:   payload.DeviceIP,
@@ -264,8 +293,15 @@ func (h *Handler) handleStopEvent(_ context.Context, cmd Command) Response {
 		return resp
 	}
 	delete(h.activeEvents, payload.EventID)
+	capturer := h.capturer
 	h.mu.Unlock()
 
+	if capturer != nil {
+		if err := capturer.Stop(payload.EventID); err != nil {
+			h.logger.WithError(err).WithField("event_id", payload.EventID).Warn("failed to stop capture")
+		}
+	}
+
 	h.logger.WithFields(logrus.Fields{
 		"event_id": payload.EventID,
 		"duration": time.Since(event.StartedAt).String(),
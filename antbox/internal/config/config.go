@@ -12,10 +12,20 @@ type Config struct {
 	AntBoxID string
 	// ServerURL is the AntServer WebSocket/HTTP endpoint to connect to.
 	ServerURL string
+	// Token authenticates this AntBox to the server. Issued together with the
+	// AntBox ID when the device is registered.
+	Token string
 	// HeartbeatInterval is how often to send heartbeat reports to the server.
 	HeartbeatInterval time.Duration
 	// LogLevel controls logging verbosity (debug, info, warn, error).
 	LogLevel string
+	// ConfigPath is the optional YAML file with tuner and pipeline settings.
+	ConfigPath string
+	// SpoolDir is where capture pipelines buffer segments before upload.
+	SpoolDir string
+	// StateDir holds small files that must survive restarts, such as
+	// scanned DVB channel lineups.
+	StateDir string
 }
 
 // Load reads configuration from environment variables with sensible defaults.
@@ -23,8 +33,12 @@ func Load() *Config {
 	return &Config{
 		AntBoxID:          getEnv("ANTBOX_ID", defaultAntBoxID()),
 		ServerURL:         getEnv("ANTBOX_SERVER_URL", "ws://localhost:9090"),
+		Token:             getEnv("ANTBOX_TOKEN", ""),
 		HeartbeatInterval: getEnvDuration("ANTBOX_HEARTBEAT_INTERVAL", 5*time.Second),
 		LogLevel:          getEnv("ANTBOX_LOG_LEVEL", "info"),
+		ConfigPath:        getEnv("ANTBOX_CONFIG", "/etc/antbox/antbox.yaml"),
+		SpoolDir:          getEnv("ANTBOX_SPOOL_DIR", "/var/lib/antbox/spool"),
+		StateDir:          getEnv("ANTBOX_STATE_DIR", "/var/lib/antbox"),
 	}
 }
 
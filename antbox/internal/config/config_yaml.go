@@ -40,7 +40,8 @@ type YAMLTunerConfig struct {
 	DevicePath    string `yaml:"device_path"`    // e.g. /dev/dvb/adapter0/frontend0
 	AutoDiscover  bool   `yaml:"auto_discover"`  // scan all /dev/dvb/adapter*/
 	MaxConcurrent int    `yaml:"max_concurrent"` // max simultaneous streams
-	BufferSizeMB  int    `yaml:"buffer_size_mb"`
+	BufferSizeMB  int    `yaml:"buffer_size_mb"` // per-event capture ring buffer
+	Transcode     bool   `yaml:"transcode"`      // re-encode to H.264/AAC instead of remuxing
 }
 
 // YAMLLoggingConfig holds logging and log-rotation settings.
@@ -166,6 +167,8 @@ func applyYAMLField(cfg *YAMLConfig, section, key, val string) {
 			if n > 0 {
 				cfg.Tuners.BufferSizeMB = n
 			}
+		case "transcode":
+			cfg.Tuners.Transcode = val == "true"
 		}
 	case "logging":
 		switch key {
@@ -0,0 +1,651 @@
+// Package dvb drives USB ATSC/DVB-T tuners through the Linux DVB API
+// (/dev/dvb/adapterN/{frontend,demux,dvr}M) behind the same hdhomerun.Client
+// interface the rest of the daemon uses for network tuners.
+//
+// Adapters are addressed as "dvb:adapterN" in place of a device IP; the tuner
+// index selects frontendN. TuneTo locks the frontend, routes the full
+// transport stream to the DVR device and serves just the selected program on
+// a local HTTP endpoint, so the capture pipeline consumes USB and network
+// tuners the same way. Channel numbers resolve through the lineup saved by the
+// last ScanChannels for that adapter.
+package dvb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"antbox/internal/hdhomerun"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AddressPrefix marks a DVB adapter address in hdhomerun.Device.IP.
+const AddressPrefix = "dvb:"
+
+var adapterName = regexp.MustCompile(`^adapter[0-9]+$`)
+
+// Config holds DVB backend settings.
+type Config struct {
+	// Root is the DVB device tree. Default "/dev/dvb".
+	Root string
+	// Adapters restricts discovery to these adapter names (e.g. "adapter0").
+	// Empty means every adapter under Root.
+	Adapters []string
+	// StateDir is where scanned lineups are saved. Empty keeps them in memory.
+	StateDir string
+	// StreamAddr is the local listen address for tuned streams.
+	// Default "127.0.0.1:0".
+	StreamAddr string
+	// LockTimeout bounds how long a tune waits for frontend lock. Default 2s.
+	LockTimeout time.Duration
+	// TableTimeout bounds how long a scan reads tables on a locked
+	// frequency. Default 3s.
+	TableTimeout time.Duration
+}
+
+// session is one tuned frontend with its demux and DVR open.
+type session struct {
+	fe        Frontend
+	dmx       Demux
+	dvr       io.ReadCloser
+	program   uint16
+	streaming bool
+}
+
+func (s *session) close() {
+	if s.dvr != nil {
+		s.dvr.Close()
+	}
+	if s.dmx != nil {
+		s.dmx.Close()
+	}
+	s.fe.Close()
+}
+
+// Client implements hdhomerun.Client for local DVB adapters.
+type Client struct {
+	cfg    Config
+	opener Opener
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*session // "adapter0/0"
+	lineups  map[string][]hdhomerun.Channel
+
+	listenOnce sync.Once
+	listenErr  error
+	listener   net.Listener
+	server     *http.Server
+}
+
+// NewClient creates a DVB client. Use SystemOpener{} for real hardware.
+func NewClient(cfg Config, opener Opener, logger *logrus.Logger) *Client {
+	if cfg.Root == "" {
+		cfg.Root = "/dev/dvb"
+	}
+	if cfg.StreamAddr == "" {
+		cfg.StreamAddr = "127.0.0.1:0"
+	}
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = 2 * time.Second
+	}
+	if cfg.TableTimeout <= 0 {
+		cfg.TableTimeout = 3 * time.Second
+	}
+	return &Client{
+		cfg:      cfg,
+		opener:   opener,
+		logger:   logger,
+		sessions: make(map[string]*session),
+		lineups:  make(map[string][]hdhomerun.Channel),
+	}
+}
+
+// parseAddress maps "dvb:adapter0" to "adapter0".
+func parseAddress(addr string) (string, error) {
+	name := strings.TrimPrefix(addr, AddressPrefix)
+	if name == addr || !adapterName.MatchString(name) {
+		return "", fmt.Errorf("not a dvb adapter address: %q", addr)
+	}
+	return name, nil
+}
+
+func (c *Client) nodePath(adapter, kind string, index int) string {
+	return filepath.Join(c.cfg.Root, adapter, kind+strconv.Itoa(index))
+}
+
+// Discover lists DVB adapters that have at least one frontend.
+func (c *Client) Discover(_ context.Context) ([]hdhomerun.Device, error) {
+	dirs, err := filepath.Glob(filepath.Join(c.cfg.Root, "adapter*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dirs)
+
+	var devices []hdhomerun.Device
+	for _, dir := range dirs {
+		adapter := filepath.Base(dir)
+		if !adapterName.MatchString(adapter) || !c.allowed(adapter) {
+			continue
+		}
+		frontends, _ := filepath.Glob(filepath.Join(dir, "frontend[0-9]*"))
+		if len(frontends) == 0 {
+			continue
+		}
+		dev := hdhomerun.Device{
+			DeviceID:   "dvb-" + adapter,
+			IP:         AddressPrefix + adapter,
+			Model:      "DVB adapter",
+			TunerCount: len(frontends),
+		}
+		// A tuned frontend is held open by its session; only probe idle ones.
+		c.mu.Lock()
+		s := c.sessions[adapter+"/0"]
+		c.mu.Unlock()
+		if s != nil {
+			if info, err := s.fe.Info(); err == nil {
+				dev.Model = info.Name
+			}
+		} else if fe, err := c.opener.OpenFrontend(c.nodePath(adapter, "frontend", 0)); err == nil {
+			if info, err := fe.Info(); err == nil {
+				dev.Model = info.Name
+			}
+			fe.Close()
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+func (c *Client) allowed(adapter string) bool {
+	if len(c.cfg.Adapters) == 0 {
+		return true
+	}
+	for _, a := range c.cfg.Adapters {
+		if a == adapter {
+			return true
+		}
+	}
+	return false
+}
+
+// openSession opens and tunes frontend tuner on adapter and starts routing
+// the transport stream to the DVR device.
+func (c *Client) openSession(ctx context.Context, adapter string, tuner int, frequency int) (*session, error) {
+	fe, err := c.opener.OpenFrontend(c.nodePath(adapter, "frontend", tuner))
+	if err != nil {
+		return nil, fmt.Errorf("open frontend: %w", err)
+	}
+	s := &session{fe: fe}
+	info, err := fe.Info()
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("frontend info: %w", err)
+	}
+	if err := c.tune(ctx, fe, info.Type, frequency); err != nil {
+		s.close()
+		return nil, err
+	}
+	if s.dmx, err = c.opener.OpenDemux(c.nodePath(adapter, "demux", tuner)); err != nil {
+		s.close()
+		return nil, fmt.Errorf("open demux: %w", err)
+	}
+	if err := s.dmx.StreamAll(); err != nil {
+		s.close()
+		return nil, fmt.Errorf("set demux filter: %w", err)
+	}
+	if s.dvr, err = c.opener.OpenDVR(c.nodePath(adapter, "dvr", tuner)); err != nil {
+		s.close()
+		return nil, fmt.Errorf("open dvr: %w", err)
+	}
+	return s, nil
+}
+
+// tune tunes fe and waits for lock.
+func (c *Client) tune(ctx context.Context, fe Frontend, t FrontendType, frequency int) error {
+	if err := fe.Tune(TuneParams{Type: t, Frequency: frequency, BandwidthHz: bandwidthFor(t)}); err != nil {
+		return fmt.Errorf("tune %d Hz: %w", frequency, err)
+	}
+	deadline := time.Now().Add(c.cfg.LockTimeout)
+	for {
+		st, err := fe.Status()
+		if err != nil {
+			return fmt.Errorf("read frontend status: %w", err)
+		}
+		if st&HasLock != 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no lock on %d Hz", frequency)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// TuneTo tunes a frontend to a channel from the adapter's scanned lineup and
+// returns a local HTTP URL serving that program's transport stream.
+func (c *Client) TuneTo(ctx context.Context, deviceIP string, tunerIndex int, channel string) (string, error) {
+	adapter, err := parseAddress(deviceIP)
+	if err != nil {
+		return "", err
+	}
+	var ch *hdhomerun.Channel
+	for _, lc := range c.lineup(adapter) {
+		if lc.Number == channel {
+			lc := lc
+			ch = &lc
+			break
+		}
+	}
+	if ch == nil {
+		return "", fmt.Errorf("channel %s is not in the %s lineup; run a channel scan", channel, adapter)
+	}
+	addr, err := c.listen()
+	if err != nil {
+		return "", fmt.Errorf("start stream listener: %w", err)
+	}
+
+	key := fmt.Sprintf("%s/%d", adapter, tunerIndex)
+	c.closeSession(key)
+	s, err := c.openSession(ctx, adapter, tunerIndex, ch.Frequency)
+	if err != nil {
+		return "", err
+	}
+	s.program = uint16(ch.Program)
+	c.mu.Lock()
+	c.sessions[key] = s
+	c.mu.Unlock()
+
+	return fmt.Sprintf("http://%s/dvb/%s", addr, key), nil
+}
+
+func (c *Client) closeSession(key string) {
+	c.mu.Lock()
+	s := c.sessions[key]
+	delete(c.sessions, key)
+	c.mu.Unlock()
+	if s != nil {
+		s.close()
+	}
+}
+
+// GetSignalQuality reads signal strength and SNR from a frontend.
+func (c *Client) GetSignalQuality(_ context.Context, deviceIP string, tunerIndex int) (*hdhomerun.SignalQuality, error) {
+	adapter, err := parseAddress(deviceIP)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	s := c.sessions[fmt.Sprintf("%s/%d", adapter, tunerIndex)]
+	c.mu.Unlock()
+
+	fe := Frontend(nil)
+	if s != nil {
+		fe = s.fe
+	} else {
+		if fe, err = c.opener.OpenFrontend(c.nodePath(adapter, "frontend", tunerIndex)); err != nil {
+			return nil, fmt.Errorf("open frontend: %w", err)
+		}
+		defer fe.Close()
+	}
+
+	st, err := fe.Status()
+	if err != nil {
+		return nil, err
+	}
+	strength, snr, err := fe.Signal()
+	if err != nil {
+		return nil, err
+	}
+	q := &hdhomerun.SignalQuality{
+		Strength: int(strength) * 100 / 0xFFFF,
+		SNR:      int(snr) * 100 / 0xFFFF,
+	}
+	if st&HasLock != 0 {
+		q.Quality = q.SNR
+	}
+	return q, nil
+}
+
+// ScanChannels tunes frontend0 through the regional frequency plan and reads
+// PAT plus ATSC TVCT or DVB SDT on each locked frequency. The resulting
+// lineup is saved for TuneTo.
+func (c *Client) ScanChannels(ctx context.Context, deviceIP string, quick bool, progress func(hdhomerun.ScanProgress)) ([]hdhomerun.Channel, error) {
+	adapter, err := parseAddress(deviceIP)
+	if err != nil {
+		return nil, err
+	}
+	key := adapter + "/0"
+	c.mu.Lock()
+	if s := c.sessions[key]; s != nil && s.streaming {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%s tuner 0 is streaming", adapter)
+	}
+	c.mu.Unlock()
+	c.closeSession(key)
+
+	fe, err := c.opener.OpenFrontend(c.nodePath(adapter, "frontend", 0))
+	if err != nil {
+		return nil, fmt.Errorf("open frontend: %w", err)
+	}
+	s := &session{fe: fe}
+	defer s.close()
+	info, err := fe.Info()
+	if err != nil {
+		return nil, fmt.Errorf("frontend info: %w", err)
+	}
+	plan := scanPlan(info.Type, quick)
+	if len(plan) == 0 {
+		return nil, fmt.Errorf("%s: unsupported frontend type %d", adapter, info.Type)
+	}
+	if s.dmx, err = c.opener.OpenDemux(c.nodePath(adapter, "demux", 0)); err != nil {
+		return nil, fmt.Errorf("open demux: %w", err)
+	}
+	if err := s.dmx.StreamAll(); err != nil {
+		return nil, fmt.Errorf("set demux filter: %w", err)
+	}
+	if s.dvr, err = c.opener.OpenDVR(c.nodePath(adapter, "dvr", 0)); err != nil {
+		return nil, fmt.Errorf("open dvr: %w", err)
+	}
+
+	// The DVR blocks while nothing is locked, so read it in the background.
+	chunks := make(chan []byte, 64)
+	go func() {
+		defer close(chunks)
+		for {
+			buf := make([]byte, 64*PacketSize)
+			n, err := s.dvr.Read(buf)
+			if n > 0 {
+				chunks <- buf[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var channels []hdhomerun.Channel
+	for i, rf := range plan {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err := c.tune(ctx, fe, info.Type, rf.frequency); err == nil {
+			drain(chunks) // data from the previous frequency
+			found := c.collect(ctx, chunks, info.Type, rf)
+			channels = append(channels, found...)
+		}
+		if progress != nil {
+			progress(hdhomerun.ScanProgress{
+				Percent:         (i + 1) * 100 / len(plan),
+				Found:           len(channels),
+				ChannelsScanned: i + 1,
+				TotalChannels:   len(plan),
+			})
+		}
+	}
+
+	c.saveLineup(adapter, channels)
+	return channels, nil
+}
+
+func drain(ch <-chan []byte) {
+	for {
+		select {
+		case <-ch:
+		default:
+			return
+		}
+	}
+}
+
+// collect reads PSI tables from chunks until the frequency's programs and
+// names are known or TableTimeout passes.
+func (c *Client) collect(ctx context.Context, chunks <-chan []byte, t FrontendType, rf rfChannel) []hdhomerun.Channel {
+	var (
+		pat, psip, sdt sectionAssembler
+		programs       map[uint16]uint16
+		vct            []vctChannel
+		names          map[uint16]string
+		pending        []byte
+	)
+	timeout := time.After(c.cfg.TableTimeout)
+	done := func() bool {
+		return programs != nil && ((t == FrontendATSC && vct != nil) || (t == FrontendOFDM && names != nil))
+	}
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timeout:
+			goto build
+		case data, ok := <-chunks:
+			if !ok {
+				goto build
+			}
+			pending = append(pending, data...)
+			for len(pending) >= PacketSize {
+				if pending[0] != syncByte {
+					pending = pending[1:]
+					continue
+				}
+				pkt := pending[:PacketSize]
+				pending = pending[PacketSize:]
+				payload, start := packetPayload(pkt)
+				switch packetPID(pkt) {
+				case pidPAT:
+					for _, s := range pat.push(payload, start) {
+						if _, p := parsePAT(s); p != nil {
+							programs = p
+						}
+					}
+				case pidPSIP:
+					for _, s := range psip.push(payload, start) {
+						if s[0] == tableTVCT {
+							vct = append(vct, parseTVCT(s)...)
+						}
+					}
+				case pidSDT:
+					for _, s := range sdt.push(payload, start) {
+						if n := parseSDT(s); n != nil {
+							names = n
+						}
+					}
+				}
+			}
+		}
+	}
+
+build:
+	modulation := "8vsb"
+	if t == FrontendOFDM {
+		modulation = "dvbt"
+	}
+	var out []hdhomerun.Channel
+	if len(vct) > 0 {
+		for _, v := range vct {
+			if v.hidden || (v.serviceType != 0x02 && v.serviceType != 0x03) {
+				continue
+			}
+			out = append(out, hdhomerun.Channel{
+				Number:     fmt.Sprintf("%d.%d", v.major, v.minor),
+				Name:       v.name,
+				Frequency:  rf.frequency,
+				Modulation: modulation,
+				Program:    int(v.program),
+			})
+		}
+		return out
+	}
+	nums := make([]int, 0, len(programs))
+	for p := range programs {
+		nums = append(nums, int(p))
+	}
+	sort.Ints(nums)
+	for _, p := range nums {
+		out = append(out, hdhomerun.Channel{
+			Number:     fmt.Sprintf("%d.%d", rf.number, p),
+			Name:       names[uint16(p)],
+			Frequency:  rf.frequency,
+			Modulation: modulation,
+			Program:    p,
+		})
+	}
+	return out
+}
+
+// lineup returns the adapter's scanned channels, loading them from StateDir
+// on first use.
+func (c *Client) lineup(adapter string) []hdhomerun.Channel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l, ok := c.lineups[adapter]; ok {
+		return l
+	}
+	if c.cfg.StateDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.lineupPath(adapter))
+	if err != nil {
+		return nil
+	}
+	var l []hdhomerun.Channel
+	if err := json.Unmarshal(data, &l); err != nil {
+		c.logger.WithError(err).WithField("adapter", adapter).Warn("ignoring unreadable dvb lineup")
+		return nil
+	}
+	c.lineups[adapter] = l
+	return l
+}
+
+func (c *Client) saveLineup(adapter string, channels []hdhomerun.Channel) {
+	c.mu.Lock()
+	c.lineups[adapter] = channels
+	c.mu.Unlock()
+	if c.cfg.StateDir == "" {
+		return
+	}
+	data, err := json.MarshalIndent(channels, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(c.cfg.StateDir, 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(c.lineupPath(adapter), data, 0o644)
+	}
+	if err != nil {
+		c.logger.WithError(err).WithField("adapter", adapter).Warn("could not save dvb lineup")
+	}
+}
+
+func (c *Client) lineupPath(adapter string) string {
+	return filepath.Join(c.cfg.StateDir, "dvb-lineup-"+adapter+".json")
+}
+
+// listen starts the local stream server on first use and returns its address.
+func (c *Client) listen() (string, error) {
+	c.listenOnce.Do(func() {
+		c.listener, c.listenErr = net.Listen("tcp", c.cfg.StreamAddr)
+		if c.listenErr != nil {
+			return
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/dvb/", c.serveStream)
+		c.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go c.server.Serve(c.listener) //nolint:errcheck
+	})
+	if c.listenErr != nil {
+		return "", c.listenErr
+	}
+	return c.listener.Addr().String(), nil
+}
+
+// serveStream handles GET /dvb/adapterN/M: the tuned program as MPEG-TS.
+// One consumer per tuner; the tune stays up when it disconnects so a
+// restarted consumer can reconnect.
+func (c *Client) serveStream(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/dvb/")
+	c.mu.Lock()
+	s := c.sessions[key]
+	if s == nil {
+		c.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	if s.streaming {
+		c.mu.Unlock()
+		http.Error(w, "tuner stream already in use", http.StatusConflict)
+		return
+	}
+	s.streaming = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		s.streaming = false
+		c.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	filter := newProgramFilter(s.program)
+	buf := make([]byte, 348*PacketSize)
+	var pending, out []byte
+	for r.Context().Err() == nil {
+		n, err := s.dvr.Read(buf)
+		pending = append(pending, buf[:n]...)
+		for len(pending) > 0 && pending[0] != syncByte {
+			pending = pending[1:]
+		}
+		whole := len(pending) / PacketSize * PacketSize
+		out = filter.filter(out[:0], pending[:whole])
+		pending = append(pending[:0], pending[whole:]...)
+		if len(out) > 0 {
+			if _, werr := w.Write(out); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrClosed) {
+				c.logger.WithError(err).WithField("tuner", key).Warn("dvb stream read failed")
+			}
+			return
+		}
+	}
+}
+
+// Close releases every tuner and stops the stream server.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.sessions))
+	for k := range c.sessions {
+		keys = append(keys, k)
+	}
+	c.mu.Unlock()
+	for _, k := range keys {
+		c.closeSession(k)
+	}
+	if c.server != nil {
+		return c.server.Close()
+	}
+	return nil
+}
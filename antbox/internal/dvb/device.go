@@ -0,0 +1,80 @@
+package dvb
+
+import (
+	"errors"
+	"io"
+)
+
+// FrontendType is the delivery family reported by FE_GET_INFO.
+type FrontendType int
+
+const (
+	// FrontendQPSK is a satellite (DVB-S) frontend.
+	FrontendQPSK FrontendType = 0
+	// FrontendQAM is a cable (DVB-C / ClearQAM) frontend.
+	FrontendQAM FrontendType = 1
+	// FrontendOFDM is a terrestrial DVB-T frontend.
+	FrontendOFDM FrontendType = 2
+	// FrontendATSC is a North American ATSC (8VSB) frontend.
+	FrontendATSC FrontendType = 3
+)
+
+// FrontendStatus holds the FE_READ_STATUS lock bits.
+type FrontendStatus uint32
+
+const (
+	HasSignal  FrontendStatus = 0x01
+	HasCarrier FrontendStatus = 0x02
+	HasViterbi FrontendStatus = 0x04
+	HasSync    FrontendStatus = 0x08
+	HasLock    FrontendStatus = 0x10
+)
+
+// ErrUnsupported is returned on platforms without the Linux DVB API.
+var ErrUnsupported = errors.New("dvb: not supported on this platform")
+
+// FrontendInfo describes a frontend.
+type FrontendInfo struct {
+	// Name is the driver's device name (e.g. "Hauppauge WinTV-dualHD").
+	Name string
+	// Type is the delivery family.
+	Type FrontendType
+}
+
+// TuneParams are the parameters for one tune request.
+type TuneParams struct {
+	// Type selects the delivery system (ATSC or DVB-T).
+	Type FrontendType
+	// Frequency is the centre frequency in Hz.
+	Frequency int
+	// BandwidthHz is the channel bandwidth (DVB-T only).
+	BandwidthHz int
+}
+
+// Frontend is an open /dev/dvb/adapterN/frontendM.
+type Frontend interface {
+	// Info returns the frontend's name and type.
+	Info() (FrontendInfo, error)
+	// Tune starts tuning; poll Status for lock.
+	Tune(p TuneParams) error
+	// Status returns the current lock bits.
+	Status() (FrontendStatus, error)
+	// Signal returns raw signal strength and SNR (0-65535 on most drivers).
+	Signal() (strength, snr uint16, err error)
+	Close() error
+}
+
+// Demux is an open /dev/dvb/adapterN/demuxM.
+type Demux interface {
+	// StreamAll routes the complete transport stream to the DVR device.
+	StreamAll() error
+	Close() error
+}
+
+// Opener opens adapter device nodes. SystemOpener uses the kernel DVB API;
+// tests substitute fakes that work against a temporary directory tree.
+type Opener interface {
+	OpenFrontend(path string) (Frontend, error)
+	OpenDemux(path string) (Demux, error)
+	OpenDVR(path string) (io.ReadCloser, error)
+}
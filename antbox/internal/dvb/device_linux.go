@@ -0,0 +1,201 @@
+//go:build linux
+
+package dvb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl request numbers from <linux/dvb/frontend.h> and <linux/dvb/dmx.h>.
+const (
+	feGetInfo        = 0x80a86f3d // _IOR('o', 61, struct dvb_frontend_info)
+	feReadStatus     = 0x80046f45 // _IOR('o', 69, fe_status_t)
+	feReadSignal     = 0x80026f47 // _IOR('o', 71, __u16)
+	feReadSNR        = 0x80026f48 // _IOR('o', 72, __u16)
+	dmxSetPESFilter  = 0x40146f2c // _IOW('o', 44, struct dmx_pes_filter_params)
+	dmxSetBufferSize = 0x6f2d     // _IO('o', 45)
+
+	dtvTune           = 1
+	dtvClear          = 2
+	dtvFrequency      = 3
+	dtvModulation     = 4
+	dtvBandwidthHz    = 5
+	dtvInversion      = 6
+	dtvDeliverySystem = 17
+
+	sysDVBT = 3
+	sysATSC = 11
+
+	qamAuto       = 6
+	vsb8          = 7
+	inversionAuto = 2
+
+	dmxInFrontend     = 0
+	dmxOutTSTap       = 2
+	dmxPESOther       = 20
+	dmxImmediateStart = 4
+	pidAll            = 0x2000
+
+	dvrBufferSize = 4 << 20
+)
+
+// dtvPropertySize is sizeof(struct dtv_property), which is packed: cmd,
+// reserved[3], a union whose largest member ends in a pointer, and result.
+const dtvPropertySize = 4 + 12 + (32 + 4 + 12 + int(unsafe.Sizeof(uintptr(0)))) + 4
+
+// dtvProperties mirrors struct dtv_properties.
+type dtvProperties struct {
+	num   uint32
+	props unsafe.Pointer
+}
+
+// feSetProperty is _IOW('o', 82, struct dtv_properties).
+var feSetProperty = uintptr(0x40006f52 | unsafe.Sizeof(dtvProperties{})<<16)
+
+// SystemOpener opens real DVB device nodes.
+type SystemOpener struct{}
+
+// OpenFrontend opens a frontend read-write.
+func (SystemOpener) OpenFrontend(path string) (Frontend, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &linuxFrontend{f: f}, nil
+}
+
+// OpenDemux opens a demux device.
+func (SystemOpener) OpenDemux(path string) (Demux, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &linuxDemux{f: f}, nil
+}
+
+// OpenDVR opens the DVR device for reading the routed transport stream.
+func (SystemOpener) OpenDVR(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// A large kernel buffer rides out short stalls in the consumer.
+	_ = ioctl(f, dmxSetBufferSize, dvrBufferSize)
+	return &dvrReader{f: f}, nil
+}
+
+func ioctl(f *os.File, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+type linuxFrontend struct{ f *os.File }
+
+func (fe *linuxFrontend) Info() (FrontendInfo, error) {
+	var buf [168]byte // struct dvb_frontend_info
+	if err := ioctl(fe.f, feGetInfo, uintptr(unsafe.Pointer(&buf[0]))); err != nil {
+		return FrontendInfo{}, err
+	}
+	name := buf[:128]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return FrontendInfo{
+		Name: string(name),
+		Type: FrontendType(binary.NativeEndian.Uint32(buf[128:])),
+	}, nil
+}
+
+func (fe *linuxFrontend) Tune(p TuneParams) error {
+	type prop struct{ cmd, data uint32 }
+	props := []prop{{dtvClear, 0}}
+	switch p.Type {
+	case FrontendATSC:
+		props = append(props,
+			prop{dtvDeliverySystem, sysATSC},
+			prop{dtvModulation, vsb8},
+		)
+	case FrontendOFDM:
+		props = append(props,
+			prop{dtvDeliverySystem, sysDVBT},
+			prop{dtvModulation, qamAuto},
+			prop{dtvBandwidthHz, uint32(p.BandwidthHz)},
+		)
+	default:
+		return errors.New("dvb: unsupported frontend type")
+	}
+	props = append(props,
+		prop{dtvFrequency, uint32(p.Frequency)},
+		prop{dtvInversion, inversionAuto},
+		prop{dtvTune, 0},
+	)
+
+	buf := make([]byte, len(props)*dtvPropertySize)
+	for i, pr := range props {
+		b := buf[i*dtvPropertySize:]
+		binary.NativeEndian.PutUint32(b[0:], pr.cmd)
+		binary.NativeEndian.PutUint32(b[16:], pr.data)
+	}
+	args := dtvProperties{num: uint32(len(props)), props: unsafe.Pointer(&buf[0])}
+	err := ioctl(fe.f, feSetProperty, uintptr(unsafe.Pointer(&args)))
+	runtime.KeepAlive(buf)
+	return err
+}
+
+func (fe *linuxFrontend) Status() (FrontendStatus, error) {
+	var st uint32
+	err := ioctl(fe.f, feReadStatus, uintptr(unsafe.Pointer(&st)))
+	return FrontendStatus(st), err
+}
+
+func (fe *linuxFrontend) Signal() (strength, snr uint16, err error) {
+	if err = ioctl(fe.f, feReadSignal, uintptr(unsafe.Pointer(&strength))); err != nil {
+		return 0, 0, err
+	}
+	// Not every driver implements SNR; report zero rather than failing.
+	_ = ioctl(fe.f, feReadSNR, uintptr(unsafe.Pointer(&snr)))
+	return strength, snr, nil
+}
+
+func (fe *linuxFrontend) Close() error { return fe.f.Close() }
+
+type linuxDemux struct{ f *os.File }
+
+// StreamAll sets a PES filter on PID 0x2000 (the whole TS) tapped to the DVR.
+func (d *linuxDemux) StreamAll() error {
+	var params [20]byte // struct dmx_pes_filter_params
+	binary.NativeEndian.PutUint16(params[0:], pidAll)
+	binary.NativeEndian.PutUint32(params[4:], dmxInFrontend)
+	binary.NativeEndian.PutUint32(params[8:], dmxOutTSTap)
+	binary.NativeEndian.PutUint32(params[12:], dmxPESOther)
+	binary.NativeEndian.PutUint32(params[16:], dmxImmediateStart)
+	return ioctl(d.f, dmxSetPESFilter, uintptr(unsafe.Pointer(&params[0])))
+}
+
+func (d *linuxDemux) Close() error { return d.f.Close() }
+
+// dvrReader retries reads after a kernel buffer overflow; the lost data is
+// gone either way, and the stream continues with the next packet.
+type dvrReader struct{ f *os.File }
+
+func (r *dvrReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+		if errors.Is(err, syscall.EOVERFLOW) {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *dvrReader) Close() error { return r.f.Close() }
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package dvb
+
+import "io"
+
+// SystemOpener opens real DVB device nodes. Only Linux has the DVB API.
+type SystemOpener struct{}
+
+// OpenFrontend always fails off Linux.
+func (SystemOpener) OpenFrontend(string) (Frontend, error) { return nil, ErrUnsupported }
+
+// OpenDemux always fails off Linux.
+func (SystemOpener) OpenDemux(string) (Demux, error) { return nil, ErrUnsupported }
+
+// OpenDVR always fails off Linux.
+func (SystemOpener) OpenDVR(string) (io.ReadCloser, error) { return nil, ErrUnsupported }
@@ -0,0 +1,47 @@
+package dvb
+
+// rfChannel is one physical channel in a scan plan.
+type rfChannel struct {
+	number    int // RF channel number
+	frequency int // centre frequency in Hz
+}
+
+// scanPlan returns the RF channels to try for a frontend type. Quick scans
+// skip the bands that rarely carry stations (ATSC low VHF).
+func scanPlan(t FrontendType, quick bool) []rfChannel {
+	var plan []rfChannel
+	switch t {
+	case FrontendATSC:
+		// North American 6 MHz plan, post-2020 repack (UHF ends at RF 36).
+		if !quick {
+			for _, ch := range []struct{ n, mhz int }{{2, 57}, {3, 63}, {4, 69}, {5, 79}, {6, 85}} {
+				plan = append(plan, rfChannel{ch.n, ch.mhz * 1_000_000})
+			}
+		}
+		for n := 7; n <= 13; n++ {
+			plan = append(plan, rfChannel{n, (177 + 6*(n-7)) * 1_000_000})
+		}
+		for n := 14; n <= 36; n++ {
+			plan = append(plan, rfChannel{n, (473 + 6*(n-14)) * 1_000_000})
+		}
+	case FrontendOFDM:
+		// European 8 MHz UHF plan (E21–E48 after the 700 MHz clearance;
+		// the full scan continues to E69 for regions that have not cleared).
+		last := 48
+		if !quick {
+			last = 69
+		}
+		for n := 21; n <= last; n++ {
+			plan = append(plan, rfChannel{n, (474 + 8*(n-21)) * 1_000_000})
+		}
+	}
+	return plan
+}
+
+// bandwidthFor returns the channel bandwidth for a frontend type.
+func bandwidthFor(t FrontendType) int {
+	if t == FrontendOFDM {
+		return 8_000_000
+	}
+	return 6_000_000
+}
@@ -0,0 +1,52 @@
+package dvb
+
+import (
+	"context"
+	"strings"
+
+	"antbox/internal/hdhomerun"
+)
+
+// Router is an hdhomerun.Client that sends "dvb:" device addresses to a DVB
+// client and everything else to the network HDHomeRun client, so the scanner
+// and command handler see one combined set of tuners.
+type Router struct {
+	DVB     *Client
+	Network hdhomerun.Client
+}
+
+func (r *Router) pick(deviceIP string) hdhomerun.Client {
+	if strings.HasPrefix(deviceIP, AddressPrefix) {
+		return r.DVB
+	}
+	return r.Network
+}
+
+// Discover returns local adapters followed by network devices. It fails only
+// when both backends fail.
+func (r *Router) Discover(ctx context.Context) ([]hdhomerun.Device, error) {
+	local, lerr := r.DVB.Discover(ctx)
+	remote, rerr := r.Network.Discover(ctx)
+	if lerr != nil && rerr != nil {
+		return nil, rerr
+	}
+	if rerr != nil {
+		return local, nil
+	}
+	return append(local, remote...), nil
+}
+
+// TuneTo tunes on whichever backend owns deviceIP.
+func (r *Router) TuneTo(ctx context.Context, deviceIP string, tunerIndex int, channel string) (string, error) {
+	return r.pick(deviceIP).TuneTo(ctx, deviceIP, tunerIndex, channel)
+}
+
+// GetSignalQuality reads signal from whichever backend owns deviceIP.
+func (r *Router) GetSignalQuality(ctx context.Context, deviceIP string, tunerIndex int) (*hdhomerun.SignalQuality, error) {
+	return r.pick(deviceIP).GetSignalQuality(ctx, deviceIP, tunerIndex)
+}
+
+// ScanChannels scans on whichever backend owns deviceIP.
+func (r *Router) ScanChannels(ctx context.Context, deviceIP string, quick bool, progress func(hdhomerun.ScanProgress)) ([]hdhomerun.Channel, error) {
+	return r.pick(deviceIP).ScanChannels(ctx, deviceIP, quick, progress)
+}
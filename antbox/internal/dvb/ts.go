@@ -0,0 +1,329 @@
+package dvb
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+)
+
+// MPEG-TS / PSI constants.
+const (
+	PacketSize = 188
+	syncByte   = 0x47
+
+	pidPAT  = 0x0000
+	pidSDT  = 0x0011
+	pidPSIP = 0x1FFB
+
+	tablePAT  = 0x00
+	tablePMT  = 0x02
+	tableSDT  = 0x42
+	tableTVCT = 0xC8
+)
+
+// packetPID returns the PID of a TS packet.
+func packetPID(p []byte) uint16 {
+	return binary.BigEndian.Uint16(p[1:3]) & 0x1FFF
+}
+
+// packetPayload returns the payload of a TS packet and whether it starts a
+// new PES packet or section (payload_unit_start_indicator).
+func packetPayload(p []byte) (payload []byte, start bool) {
+	start = p[1]&0x40 != 0
+	afc := (p[3] >> 4) & 0x3
+	off := 4
+	if afc&0x2 != 0 { // adaptation field present
+		off += 1 + int(p[4])
+	}
+	if afc&0x1 == 0 || off >= PacketSize {
+		return nil, start
+	}
+	return p[off:], start
+}
+
+// sectionAssembler reassembles PSI sections carried on one PID.
+type sectionAssembler struct {
+	buf    []byte
+	active bool
+}
+
+// push feeds one packet payload and returns any sections it completed.
+func (a *sectionAssembler) push(payload []byte, start bool) [][]byte {
+	if len(payload) == 0 {
+		return nil
+	}
+	var out [][]byte
+	if start {
+		pointer := int(payload[0])
+		payload = payload[1:]
+		if pointer > len(payload) {
+			a.active = false
+			return nil
+		}
+		if a.active {
+			a.buf = append(a.buf, payload[:pointer]...)
+			out = append(out, a.drain()...)
+		}
+		a.buf = append(a.buf[:0], payload[pointer:]...)
+		a.active = true
+	} else if a.active {
+		a.buf = append(a.buf, payload...)
+	} else {
+		return nil
+	}
+	return append(out, a.drain()...)
+}
+
+// drain pops complete sections off the buffer.
+func (a *sectionAssembler) drain() [][]byte {
+	var out [][]byte
+	for a.active {
+		if len(a.buf) > 0 && a.buf[0] == 0xFF { // stuffing: rest of packet is padding
+			a.active = false
+			a.buf = a.buf[:0]
+			break
+		}
+		if len(a.buf) < 3 {
+			break
+		}
+		n := 3 + int(binary.BigEndian.Uint16(a.buf[1:3])&0x0FFF)
+		if len(a.buf) < n {
+			break
+		}
+		section := make([]byte, n)
+		copy(section, a.buf[:n])
+		if crc32MPEG(section) == 0 { // CRC over the whole section, including its CRC, is zero
+			out = append(out, section)
+		}
+		a.buf = a.buf[n:]
+		if len(a.buf) == 0 {
+			a.active = false
+		}
+	}
+	return out
+}
+
+// crc32MPEG computes the MPEG-2 CRC-32 (poly 0x04C11DB7, no reflection).
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// sectionBody returns the table-specific bytes of a long-form section
+// (after the 8-byte header, before the CRC) and its table id extension.
+func sectionBody(s []byte) (body []byte, ext uint16, ok bool) {
+	if len(s) < 12 {
+		return nil, 0, false
+	}
+	return s[8 : len(s)-4], binary.BigEndian.Uint16(s[3:5]), true
+}
+
+// parsePAT returns program_number → PMT PID (excluding the NIT entry).
+func parsePAT(s []byte) (tsid uint16, programs map[uint16]uint16) {
+	body, tsid, ok := sectionBody(s)
+	if !ok || s[0] != tablePAT {
+		return 0, nil
+	}
+	programs = make(map[uint16]uint16)
+	for i := 0; i+4 <= len(body); i += 4 {
+		num := binary.BigEndian.Uint16(body[i:])
+		pid := binary.BigEndian.Uint16(body[i+2:]) & 0x1FFF
+		if num != 0 {
+			programs[num] = pid
+		}
+	}
+	return tsid, programs
+}
+
+// pmtInfo is the part of a PMT the program filter needs.
+type pmtInfo struct {
+	program uint16
+	pcrPID  uint16
+	esPIDs  []uint16
+}
+
+// parsePMT extracts the PCR and elementary stream PIDs.
+func parsePMT(s []byte) (pmtInfo, bool) {
+	body, program, ok := sectionBody(s)
+	if !ok || s[0] != tablePMT || len(body) < 4 {
+		return pmtInfo{}, false
+	}
+	info := pmtInfo{program: program, pcrPID: binary.BigEndian.Uint16(body) & 0x1FFF}
+	i := 4 + int(binary.BigEndian.Uint16(body[2:])&0x0FFF)
+	for i+5 <= len(body) {
+		pid := binary.BigEndian.Uint16(body[i+1:]) & 0x1FFF
+		esLen := int(binary.BigEndian.Uint16(body[i+3:]) & 0x0FFF)
+		info.esPIDs = append(info.esPIDs, pid)
+		i += 5 + esLen
+	}
+	return info, true
+}
+
+// vctChannel is one virtual channel from an ATSC TVCT.
+type vctChannel struct {
+	major, minor int
+	name         string
+	program      uint16
+	hidden       bool
+	serviceType  byte
+}
+
+// parseTVCT parses an ATSC terrestrial virtual channel table (A/65).
+func parseTVCT(s []byte) []vctChannel {
+	body, _, ok := sectionBody(s)
+	if !ok || s[0] != tableTVCT || len(body) < 2 {
+		return nil
+	}
+	count := int(body[1])
+	var out []vctChannel
+	i := 2
+	for n := 0; n < count && i+32 <= len(body); n++ {
+		c := body[i:]
+		units := make([]uint16, 7)
+		for k := range units {
+			units[k] = binary.BigEndian.Uint16(c[2*k:])
+		}
+		out = append(out, vctChannel{
+			name:        strings.TrimRight(string(utf16.Decode(units)), "\x00 "),
+			major:       int(c[14]&0x0F)<<6 | int(c[15]>>2),
+			minor:       int(c[15]&0x03)<<8 | int(c[16]),
+			program:     binary.BigEndian.Uint16(c[24:]),
+			hidden:      c[26]&0x10 != 0,
+			serviceType: c[27] & 0x3F,
+		})
+		i += 32 + int(binary.BigEndian.Uint16(c[30:])&0x03FF)
+	}
+	return out
+}
+
+// parseSDT returns service_id → service name from a DVB SDT (actual TS).
+func parseSDT(s []byte) map[uint16]string {
+	body, _, ok := sectionBody(s)
+	if !ok || s[0] != tableSDT || len(body) < 3 {
+		return nil
+	}
+	names := make(map[uint16]string)
+	i := 3
+	for i+5 <= len(body) {
+		sid := binary.BigEndian.Uint16(body[i:])
+		descLen := int(binary.BigEndian.Uint16(body[i+3:]) & 0x0FFF)
+		desc := body[i+5 : min(i+5+descLen, len(body))]
+		for j := 0; j+2 <= len(desc); {
+			tag, l := desc[j], int(desc[j+1])
+			if j+2+l > len(desc) {
+				break
+			}
+			if tag == 0x48 && l >= 3 { // service_descriptor
+				d := desc[j+2 : j+2+l]
+				provLen := int(d[1])
+				if 2+provLen < len(d) {
+					nameLen := int(d[2+provLen])
+					if 3+provLen+nameLen <= len(d) {
+						names[sid] = dvbText(d[3+provLen : 3+provLen+nameLen])
+					}
+				}
+			}
+			j += 2 + l
+		}
+		i += 5 + descLen
+	}
+	return names
+}
+
+// dvbText decodes a DVB string, dropping a leading character-table byte.
+func dvbText(b []byte) string {
+	if len(b) > 0 && b[0] < 0x20 {
+		if b[0] == 0x10 && len(b) >= 3 {
+			b = b[3:]
+		} else {
+			b = b[1:]
+		}
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// programFilter passes through only one program of a multi-program transport
+// stream, replacing the PAT with one that lists just that program.
+type programFilter struct {
+	program uint16
+
+	pat     sectionAssembler
+	pmt     sectionAssembler
+	pmtPID  uint16
+	allowed map[uint16]bool
+	patCC   byte
+}
+
+func newProgramFilter(program uint16) *programFilter {
+	return &programFilter{program: program, pmtPID: 0xFFFF, allowed: map[uint16]bool{}}
+}
+
+// filter appends the packets of p to dst that belong to the program. p must
+// hold whole, aligned TS packets.
+func (f *programFilter) filter(dst, p []byte) []byte {
+	for len(p) >= PacketSize {
+		pkt := p[:PacketSize]
+		p = p[PacketSize:]
+		if pkt[0] != syncByte || pkt[1]&0x80 != 0 { // lost sync or transport error
+			continue
+		}
+		pid := packetPID(pkt)
+		payload, start := packetPayload(pkt)
+		switch {
+		case pid == pidPAT:
+			for _, s := range f.pat.push(payload, start) {
+				tsid, programs := parsePAT(s)
+				if pmt, ok := programs[f.program]; ok {
+					f.pmtPID = pmt
+					dst = append(dst, f.patPacket(tsid)...)
+				}
+			}
+		case pid == f.pmtPID:
+			for _, s := range f.pmt.push(payload, start) {
+				if info, ok := parsePMT(s); ok && info.program == f.program {
+					f.allowed = map[uint16]bool{info.pcrPID: true}
+					for _, es := range info.esPIDs {
+						f.allowed[es] = true
+					}
+				}
+			}
+			dst = append(dst, pkt...)
+		case f.allowed[pid]:
+			dst = append(dst, pkt...)
+		}
+	}
+	return dst
+}
+
+// patPacket builds a single-program PAT packet.
+func (f *programFilter) patPacket(tsid uint16) []byte {
+	section := []byte{
+		tablePAT, 0xB0, 13, // section_syntax_indicator + length (9 header/body + 4 CRC)
+		byte(tsid >> 8), byte(tsid),
+		0xC1, 0x00, 0x00, // version 0, current, section 0 of 0
+		byte(f.program >> 8), byte(f.program),
+		0xE0 | byte(f.pmtPID>>8), byte(f.pmtPID),
+	}
+	section = binary.BigEndian.AppendUint32(section, crc32MPEG(section))
+
+	pkt := make([]byte, PacketSize)
+	pkt[0], pkt[1], pkt[2] = syncByte, 0x40, 0x00 // PUSI, PID 0
+	pkt[3] = 0x10 | f.patCC                       // payload only
+	f.patCC = (f.patCC + 1) & 0x0F
+	pkt[4] = 0 // pointer_field
+	n := copy(pkt[5:], section)
+	for i := 5 + n; i < PacketSize; i++ {
+		pkt[i] = 0xFF
+	}
+	return pkt
+}
@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"antbox/internal/watchdog"
+)
+
+// ffmpegArgs builds the capture command line. Segments are named
+// "<unix-seconds>-<sequence>.ts" so names stay unique and ordered across
+// ffmpeg restarts; append_list keeps one playlist for the whole event.
+func ffmpegArgs(cfg Config, streamURL, dir string) []string {
+	args := []string{
+		"-hide_banner", "-nostdin", "-loglevel", "warning",
+		"-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_delay_max", "5",
+		"-i", streamURL,
+		"-map", "0:v?", "-map", "0:a?", "-sn", "-dn",
+	}
+	if cfg.Transcode {
+		args = append(args,
+			"-vf", "yadif", // broadcast video is usually interlaced
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "23",
+			"-c:a", "aac", "-b:a", "160k",
+		)
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	return append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(cfg.SegmentSeconds),
+		"-hls_list_size", "0",
+		"-hls_flags", "append_list+second_level_segment_index",
+		"-strftime", "1",
+		"-hls_segment_filename", filepath.Join(dir, "%s-%%06d.ts"),
+		filepath.Join(dir, playlistName),
+	)
+}
+
+// commandFactory starts ffmpeg so that cancellation sends SIGINT rather than
+// SIGKILL, letting ffmpeg finish the current segment and playlist entry.
+type commandFactory struct{}
+
+// Create builds an exec.Cmd from the process spec.
+func (f *commandFactory) Create(ctx context.Context, spec watchdog.ProcessSpec) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, spec.Command, spec.Args...)
+	cmd.Env = append(os.Environ(), spec.Env...)
+	cmd.Dir = spec.Dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+	return cmd
+}
@@ -0,0 +1,233 @@
+// Package pipeline captures started events and ships them to the server.
+//
+// Each active event gets one ffmpeg process, supervised by watchdog, that
+// pulls the tuner's MPEG-TS stream and writes HLS segments into a per-event
+// spool directory. An uploader follows ffmpeg's playlist and PUTs each
+// finished segment to the server's ingest endpoint:
+//
+//	PUT  {server}/antbox/events/{event_id}/segments/{name}   segment body
+//	POST {server}/antbox/events/{event_id}/complete          after the last segment
+//
+// The spool directory is a ring buffer capped at Config.BufferBytes. While the
+// server is unreachable segments accumulate and the uploader resumes from the
+// oldest one still on disk once it is back; if the buffer fills first, the
+// oldest segments are dropped.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"antbox/internal/watchdog"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSegmentSeconds = 6
+	defaultPollInterval   = time.Second
+	defaultDrainTimeout   = 2 * time.Minute
+	playlistName          = "index.m3u8"
+)
+
+// validEventID restricts event ids to names that are safe as path segments.
+var validEventID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ErrEventActive is returned by Start for an event that is already capturing.
+var ErrEventActive = errors.New("event is already capturing")
+
+// ErrEventNotFound is returned by Stop for an event that is not capturing.
+var ErrEventNotFound = errors.New("event is not capturing")
+
+// Config holds capture pipeline settings.
+type Config struct {
+	// ServerURL is the Roost server; ws(s):// is mapped to http(s)://.
+	ServerURL string
+	AntBoxID  string
+	Token     string
+	// SpoolDir holds one ring-buffer directory per active event.
+	SpoolDir string
+	// BufferBytes caps each event's spool directory.
+	BufferBytes int64
+	// FFmpegPath is the ffmpeg binary. Default "ffmpeg".
+	FFmpegPath string
+	// Transcode re-encodes to H.264/AAC instead of remuxing the broadcast
+	// streams as-is.
+	Transcode bool
+	// SegmentSeconds is the target HLS segment length. Default 6.
+	SegmentSeconds int
+	// PollInterval is how often the uploader checks for new segments. Default 1s.
+	PollInterval time.Duration
+	// DrainTimeout bounds how long a stopped event keeps trying to upload
+	// its remaining segments. Default 2m.
+	DrainTimeout time.Duration
+}
+
+// capture is one active event.
+type capture struct {
+	eventID   string
+	streamURL string
+	dir       string
+	process   string // watchdog process name
+
+	finish   chan struct{} // closed by Stop once ffmpeg has exited
+	uploaded map[string]bool
+	dropped  map[string]bool
+}
+
+// Manager runs capture pipelines. It satisfies command.Capturer.
+type Manager struct {
+	cfg        Config
+	supervisor *watchdog.Supervisor
+	client     *http.Client
+	logger     *logrus.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	events map[string]*capture
+}
+
+// New creates a Manager. Call Close on shutdown.
+func New(cfg Config, logger *logrus.Logger) *Manager {
+	if cfg.FFmpegPath == "" {
+		cfg.FFmpegPath = "ffmpeg"
+	}
+	if cfg.SegmentSeconds <= 0 {
+		cfg.SegmentSeconds = defaultSegmentSeconds
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = defaultDrainTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		cfg: cfg,
+		// Captures restart indefinitely (1s → 30s backoff) until stop_event.
+		supervisor: watchdog.NewSupervisor(&commandFactory{}, logger, 0, time.Second, 30*time.Second),
+		client:     &http.Client{Timeout: 60 * time.Second},
+		logger:     logger,
+		ctx:        ctx,
+		cancel:     cancel,
+		events:     make(map[string]*capture),
+	}
+}
+
+// Start begins capturing streamURL for eventID.
+func (m *Manager) Start(eventID, streamURL string) error {
+	if !validEventID.MatchString(eventID) {
+		return fmt.Errorf("invalid event id %q", eventID)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil {
+		return errors.New("pipeline is shut down")
+	}
+	if _, exists := m.events[eventID]; exists {
+		return ErrEventActive
+	}
+
+	c := &capture{
+		eventID:   eventID,
+		streamURL: streamURL,
+		dir:       filepath.Join(m.cfg.SpoolDir, eventID),
+		process:   "capture-" + eventID,
+		finish:    make(chan struct{}),
+		uploaded:  make(map[string]bool),
+		dropped:   make(map[string]bool),
+	}
+	// A leftover directory belongs to an earlier run of the same event id;
+	// its playlist would be appended to, so start clean.
+	if err := os.RemoveAll(c.dir); err != nil {
+		return fmt.Errorf("clear spool dir: %w", err)
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return fmt.Errorf("create spool dir: %w", err)
+	}
+
+	spec := watchdog.ProcessSpec{
+		Name:    c.process,
+		Command: m.cfg.FFmpegPath,
+		Args:    ffmpegArgs(m.cfg, streamURL, c.dir),
+		Dir:     c.dir,
+	}
+	if err := m.supervisor.Supervise(m.ctx, spec); err != nil {
+		return err
+	}
+	m.events[eventID] = c
+
+	m.wg.Add(1)
+	go m.upload(c)
+
+	m.logger.WithFields(logrus.Fields{
+		"event_id":  eventID,
+		"transcode": m.cfg.Transcode,
+		"spool_dir": c.dir,
+	}).Info("capture started")
+	return nil
+}
+
+// Stop ends the capture for eventID. ffmpeg is stopped before Stop returns;
+// the remaining segments are uploaded in the background.
+func (m *Manager) Stop(eventID string) error {
+	m.mu.Lock()
+	c, exists := m.events[eventID]
+	if exists {
+		delete(m.events, eventID)
+	}
+	m.mu.Unlock()
+	if !exists {
+		return ErrEventNotFound
+	}
+
+	if err := m.supervisor.Stop(c.process); err != nil {
+		m.logger.WithError(err).WithField("event_id", eventID).Warn("capture process was not supervised")
+	}
+	close(c.finish)
+	m.logger.WithField("event_id", eventID).Info("capture stopped; draining uploads")
+	return nil
+}
+
+// Active returns the ids of events currently capturing.
+func (m *Manager) Active() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.events))
+	for id := range m.events {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Close stops every capture, waits up to the drain timeout for uploads to
+// finish, and releases resources.
+func (m *Manager) Close() {
+	for _, id := range m.Active() {
+		_ = m.Stop(id)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(m.cfg.DrainTimeout):
+		m.logger.Warn("capture uploads did not drain before shutdown")
+	}
+	m.cancel()
+	m.wg.Wait()
+}
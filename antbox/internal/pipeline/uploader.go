@@ -0,0 +1,297 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// segment is one finished entry in ffmpeg's playlist.
+type segment struct {
+	name          string
+	duration      float64
+	discontinuity bool
+}
+
+// readPlaylist returns the segments listed in an HLS media playlist. ffmpeg
+// only lists a segment once it is complete, so every entry is safe to upload.
+func readPlaylist(path string) ([]segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		segs     []segment
+		duration float64
+		discont  bool
+	)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v := strings.TrimPrefix(line, "#EXTINF:")
+			if i := strings.IndexByte(v, ','); i >= 0 {
+				v = v[:i]
+			}
+			duration, _ = strconv.ParseFloat(v, 64)
+		case line == "#EXT-X-DISCONTINUITY":
+			discont = true
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			segs = append(segs, segment{name: filepath.Base(line), duration: duration, discontinuity: discont})
+			duration, discont = 0, false
+		}
+	}
+	return segs, sc.Err()
+}
+
+// upload follows one capture's playlist until the event is stopped and every
+// segment is uploaded (or the drain timeout passes).
+func (m *Manager) upload(c *capture) {
+	defer m.wg.Done()
+	log := m.logger.WithField("event_id", c.eventID)
+
+	var (
+		deadline time.Time
+		backoff  = m.cfg.PollInterval
+		finish   = c.finish
+	)
+	for {
+		pending, err := m.uploadPass(c)
+		m.enforceBuffer(c, log)
+
+		wait := m.cfg.PollInterval
+		if err != nil {
+			log.WithError(err).Warn("segment upload failed; will retry")
+			wait = backoff
+			backoff = min(backoff*2, 30*time.Second)
+		} else {
+			backoff = m.cfg.PollInterval
+		}
+
+		if finish == nil {
+			if pending == 0 {
+				if err := m.complete(c); err != nil {
+					log.WithError(err).Warn("could not mark event complete on server")
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				log.WithField("pending_segments", pending).Warn("drain timeout; abandoning remaining segments")
+				break
+			}
+		}
+
+		select {
+		case <-finish:
+			// ffmpeg has exited; its playlist is final. Upload what is left.
+			finish = nil
+			deadline = time.Now().Add(m.cfg.DrainTimeout)
+		case <-time.After(wait):
+		case <-m.ctx.Done():
+			return
+		}
+	}
+
+	if err := os.RemoveAll(c.dir); err != nil {
+		log.WithError(err).Warn("could not remove spool dir")
+	}
+	log.WithField("segments", len(c.uploaded)).Info("capture upload finished")
+}
+
+// uploadPass uploads listed segments in order, stopping at the first failure.
+// It returns how many listed segments are still waiting to be uploaded.
+func (m *Manager) uploadPass(c *capture) (int, error) {
+	segs, err := readPlaylist(filepath.Join(c.dir, playlistName))
+	if os.IsNotExist(err) {
+		return 0, nil // ffmpeg has not finished its first segment yet
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, s := range segs {
+		if !c.uploaded[s.name] && !c.dropped[s.name] {
+			pending++
+		}
+	}
+	for _, s := range segs {
+		if c.uploaded[s.name] || c.dropped[s.name] {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(c.dir, s.name)); os.IsNotExist(err) {
+			c.dropped[s.name] = true // evicted from the ring buffer
+			pending--
+			continue
+		}
+		permanent, err := m.putSegment(c, s)
+		if err != nil && !permanent {
+			return pending, err
+		}
+		if err != nil {
+			m.logger.WithError(err).WithFields(logrus.Fields{
+				"event_id": c.eventID,
+				"segment":  s.name,
+			}).Warn("server rejected segment; skipping")
+			c.dropped[s.name] = true
+		} else {
+			c.uploaded[s.name] = true
+		}
+		pending--
+	}
+	return pending, nil
+}
+
+// putSegment uploads one segment. permanent reports a rejection that will not
+// succeed on retry.
+func (m *Manager) putSegment(c *capture, s segment) (permanent bool, err error) {
+	f, err := os.Open(filepath.Join(c.dir, s.name))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	endpoint, err := m.endpoint(c.eventID, "segments", s.name)
+	if err != nil {
+		return true, err
+	}
+	req, err := http.NewRequestWithContext(m.ctx, http.MethodPut, endpoint, f)
+	if err != nil {
+		return true, err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "video/mp2t")
+	req.Header.Set("X-Segment-Duration", strconv.FormatFloat(s.duration, 'f', 3, 64))
+	if s.discontinuity {
+		req.Header.Set("X-Segment-Discontinuity", "1")
+	}
+	return m.do(req)
+}
+
+// complete tells the server the event has ended.
+func (m *Manager) complete(c *capture) error {
+	endpoint, err := m.endpoint(c.eventID, "complete")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(m.ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	_, err = m.do(req)
+	return err
+}
+
+// do sends an authenticated request and classifies the result.
+func (m *Manager) do(req *http.Request) (permanent bool, err error) {
+	req.Header.Set("X-AntBox-ID", m.cfg.AntBoxID)
+	req.Header.Set("Authorization", "Bearer "+m.cfg.Token)
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("server returned HTTP %d", resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false, err
+	}
+	return resp.StatusCode >= 400 && resp.StatusCode < 500, err
+}
+
+// endpoint builds {server}/antbox/events/{eventID}/{parts...}.
+func (m *Manager) endpoint(eventID string, parts ...string) (string, error) {
+	u, err := url.Parse(m.cfg.ServerURL)
+	if err != nil {
+		return "", fmt.Errorf("parse server url: %w", err)
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("unsupported server url scheme %q", u.Scheme)
+	}
+	u.Path = "/antbox/events/" + eventID + "/" + strings.Join(parts, "/")
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// enforceBuffer keeps the spool directory under BufferBytes, evicting the
+// oldest uploaded segments first and un-uploaded ones only if it must. The
+// newest file is never touched: ffmpeg may still be writing it.
+func (m *Manager) enforceBuffer(c *capture, log *logrus.Entry) {
+	if m.cfg.BufferBytes <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type file struct {
+		name string
+		size int64
+	}
+	var files []file
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".ts") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{e.Name(), info.Size()})
+		total += info.Size()
+	}
+	if total <= m.cfg.BufferBytes || len(files) < 2 {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	evictable := files[:len(files)-1]
+
+	for _, uploadedOnly := range []bool{true, false} {
+		for _, f := range evictable {
+			if total <= m.cfg.BufferBytes {
+				return
+			}
+			if uploadedOnly != c.uploaded[f.name] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(c.dir, f.name)); err != nil {
+				continue
+			}
+			total -= f.size
+			if !uploadedOnly {
+				c.dropped[f.name] = true
+				log.WithField("segment", f.name).Warn("ring buffer full; dropped segment before upload")
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Package transport connects the AntBox daemon to the Roost server over a
+// persistent, authenticated WebSocket.
+//
+// One connection carries everything in both directions as JSON text messages
+// wrapped in an envelope {"type": ..., "data": ...}:
+//
+//	hello     daemon → server  sent once after connecting (id, version)
+//	heartbeat daemon → server  heartbeat.Report from the reporter
+//	command   server → daemon  command.Command (SCAN_CHANNELS, START_EVENT, ...)
+//	response  daemon → server  command.Response for a command id
+//
+// The daemon authenticates the upgrade request with its antbox id and token
+// (X-AntBox-ID + Authorization: Bearer). Dropped connections are re-dialled
+// through recovery.Retrier with exponential backoff.
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"antbox/internal/command"
+	"antbox/internal/recovery"
+
+	"github.com/sirupsen/logrus"
+	"github.com/unyeco/roost/pkg/wsconn"
+)
+
+// Message types carried in the envelope.
+const (
+	TypeHello     = "hello"
+	TypeHeartbeat = "heartbeat"
+	TypeCommand   = "command"
+	TypeResponse  = "response"
+)
+
+// DefaultPath is appended to server URLs that have no path.
+const DefaultPath = "/antbox/connect"
+
+const (
+	// readTimeout drops a connection that has been silent this long. The
+	// server pings every 30s, so a healthy connection never hits it.
+	readTimeout = 90 * time.Second
+	// stableSession is how long a session must last before a disconnect
+	// reconnects immediately instead of continuing the backoff sequence.
+	stableSession = time.Minute
+)
+
+// ErrNotConnected is returned by Send while there is no live connection.
+var ErrNotConnected = errors.New("not connected to server")
+
+// Envelope wraps every message on the wire.
+type Envelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Hello is the first message sent after connecting.
+type Hello struct {
+	AntBoxID string `json:"antbox_id"`
+	Version  string `json:"version"`
+}
+
+// CommandHandler executes server commands. *command.Handler satisfies it.
+type CommandHandler interface {
+	Handle(ctx context.Context, cmd command.Command) command.Response
+}
+
+// Config holds transport settings.
+type Config struct {
+	ServerURL string // ws:// or wss:// URL of the Roost server
+	AntBoxID  string
+	Token     string
+	Version   string // daemon version reported in hello
+}
+
+// Client maintains the server connection. It implements heartbeat.Sender.
+type Client struct {
+	cfg     Config
+	handler CommandHandler
+	retrier *recovery.Retrier
+	logger  *logrus.Logger
+
+	mu   sync.RWMutex
+	conn *wsconn.Conn
+}
+
+// New creates a Client. Call Run to connect.
+func New(cfg Config, handler CommandHandler, retrier *recovery.Retrier, logger *logrus.Logger) *Client {
+	return &Client{cfg: cfg, handler: handler, retrier: retrier, logger: logger}
+}
+
+// Run connects and serves commands until ctx is cancelled, reconnecting with
+// backoff whenever the connection drops.
+func (c *Client) Run(ctx context.Context) {
+	c.retrier.RunForever(ctx, "server connection", c.session)
+}
+
+// Connected reports whether a server connection is currently up.
+func (c *Client) Connected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn != nil
+}
+
+// Send delivers a heartbeat payload (heartbeat.Sender).
+func (c *Client) Send(_ context.Context, data []byte) error {
+	return c.send(TypeHeartbeat, json.RawMessage(data))
+}
+
+// session runs one connection from dial to disconnect.
+func (c *Client) session(ctx context.Context) error {
+	target, err := ServerEndpoint(c.cfg.ServerURL)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-AntBox-ID", c.cfg.AntBoxID)
+	header.Set("Authorization", "Bearer "+c.cfg.Token)
+
+	conn, err := wsconn.Dial(ctx, target, header)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	started := time.Now()
+
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+	}()
+
+	// Closing the socket unblocks ReadMessage when ctx is cancelled.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := c.send(TypeHello, Hello{AntBoxID: c.cfg.AntBoxID, Version: c.cfg.Version}); err != nil {
+		return fmt.Errorf("send hello: %w", err)
+	}
+	c.logger.WithField("server", redactURL(target)).Info("connected to server")
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+		raw, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			c.logger.WithError(err).Warn("server connection lost")
+			if time.Since(started) >= stableSession {
+				return nil // reconnect straight away; backoff restarts from the initial interval
+			}
+			return fmt.Errorf("connection lost after %s: %w", time.Since(started).Round(time.Second), err)
+		}
+
+		var env Envelope
+		if err := json.Unmarshal(raw, &env); err != nil {
+			c.logger.WithError(err).Warn("ignoring malformed server message")
+			continue
+		}
+		if env.Type != TypeCommand {
+			c.logger.WithField("type", env.Type).Debug("ignoring server message")
+			continue
+		}
+		var cmd command.Command
+		if err := json.Unmarshal(env.Data, &cmd); err != nil {
+			c.logger.WithError(err).Warn("ignoring malformed command")
+			continue
+		}
+		// Commands such as scans can run for minutes; never block the read loop.
+		go c.execute(ctx, cmd)
+	}
+}
+
+// execute runs one command and sends its response.
+func (c *Client) execute(ctx context.Context, cmd command.Command) {
+	resp := c.handler.Handle(ctx, cmd)
+	if err := c.send(TypeResponse, resp); err != nil {
+		c.logger.WithError(err).WithField("command_id", cmd.ID).Warn("could not deliver command response")
+	}
+}
+
+// send wraps v in an envelope and writes it to the current connection.
+func (c *Client) send(msgType string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(Envelope{Type: msgType, Data: data})
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return conn.WriteText(payload)
+}
+
+// ServerEndpoint normalises the configured server URL: http(s) schemes become
+// ws(s) and an empty path becomes DefaultPath.
+func ServerEndpoint(serverURL string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("parse server url: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("server url scheme must be ws, wss, http or https, got %q", u.Scheme)
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = DefaultPath
+	}
+	return u.String(), nil
+}
+
+// redactURL drops any userinfo or query string before logging.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[unparseable]"
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
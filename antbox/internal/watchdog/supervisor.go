@@ -93,6 +93,8 @@ type supervisedProcess struct {
 	lastStarted  time.Time
 	lastStopped  time.Time
 	cancelFn     context.CancelFunc
+	loopCancel   context.CancelFunc // cancels the supervise loop (Stop)
+	done         chan struct{}      // closed when the supervise loop exits
 }
 
 // Supervisor monitors child processes and restarts them on crash.
@@ -145,21 +147,46 @@ func (s *Supervisor) Supervise(ctx context.Context, spec ProcessSpec) error {
 		return fmt.Errorf("process %q is already supervised", spec.Name)
 	}
 
+	loopCtx, loopCancel := context.WithCancel(ctx)
 	proc := &supervisedProcess{
-		spec:  spec,
-		state: StateStopped,
+		spec:       spec,
+		state:      StateStopped,
+		loopCancel: loopCancel,
+		done:       make(chan struct{}),
 	}
 	s.processes[spec.Name] = proc
 	s.mu.Unlock()
 
 	s.wg.Add(1)
-	go s.superviseLoop(ctx, proc)
+	go s.superviseLoop(loopCtx, proc)
+	return nil
+}
+
+// Stop terminates a supervised process, waits for it to exit, and removes it
+// so the same name can be supervised again.
+func (s *Supervisor) Stop(name string) error {
+	s.mu.RLock()
+	proc, exists := s.processes[name]
+	s.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("process %q not found", name)
+	}
+
+	proc.loopCancel()
+	<-proc.done
+
+	s.mu.Lock()
+	if s.processes[name] == proc {
+		delete(s.processes, name)
+	}
+	s.mu.Unlock()
 	return nil
 }
 
 // superviseLoop is the main supervision goroutine for a single process.
 func (s *Supervisor) superviseLoop(ctx context.Context, proc *supervisedProcess) {
 	defer s.wg.Done()
+	defer close(proc.done)
 
 	consecutiveFails := 0
 
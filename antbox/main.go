@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
+	"antbox/daemon"
 	"antbox/internal/command"
 	"antbox/internal/config"
+	"antbox/internal/dvb"
 	"antbox/internal/heartbeat"
 	"antbox/internal/hdhomerun"
+	"antbox/internal/pipeline"
+	"antbox/internal/recovery"
 	"antbox/internal/scanner"
+	"antbox/internal/transport"
 
 	"github.com/sirupsen/logrus"
 )
@@ -36,17 +43,6 @@ func (a *statusAdapter) GetScannerState() string {
 	return string(a.scanner.GetStatus().State)
 }
 
-// logSender is a stub heartbeat.Sender that logs the payload.
-// In production, this would send over WebSocket to AntServer.
-type logSender struct {
-	logger *logrus.Logger
-}
-
-func (s *logSender) Send(_ context.Context, data []byte) error {
-	s.logger.WithField("payload_size", len(data)).Debug("heartbeat payload ready")
-	return nil
-}
-
 func main() {
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
@@ -70,8 +66,31 @@ func main() {
 		"heartbeat_interval": cfg.HeartbeatInterval.String(),
 	}).Info("starting antbox daemon")
 
+	// Tuner settings come from the optional YAML file; defaults apply
+	// when it is missing.
+	tuners := config.DefaultYAMLConfig().Tuners
+	if yc, err := config.LoadYAML(cfg.ConfigPath); err == nil {
+		tuners = yc.Tuners
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logger.WithError(err).Warn("ignoring YAML config")
+	}
+
 	// Create the HDHomeRun client (real HTTP implementation).
-	hdClient := hdhomerun.NewHTTPClient()
+	var hdClient hdhomerun.Client = hdhomerun.NewHTTPClient()
+
+	// Local USB tuners sit alongside network HDHomeRuns. device_path pins a
+	// single adapter; auto_discover uses every adapter under /dev/dvb.
+	var dvbClient *dvb.Client
+	if tuners.DevicePath != "" || tuners.AutoDiscover {
+		dvbCfg := dvb.Config{StateDir: cfg.StateDir}
+		if tuners.DevicePath != "" {
+			adapterDir := filepath.Dir(tuners.DevicePath)
+			dvbCfg.Root = filepath.Dir(adapterDir)
+			dvbCfg.Adapters = []string{filepath.Base(adapterDir)}
+		}
+		dvbClient = dvb.NewClient(dvbCfg, dvb.SystemOpener{}, logger)
+		hdClient = &dvb.Router{DVB: dvbClient, Network: hdClient}
+	}
 
 	// Create the channel scanner.
 	sc := scanner.New(hdClient, logger)
@@ -79,31 +98,54 @@ func main() {
 	// Create the command handler.
 	handler := command.NewHandler(hdClient, sc, logger)
 
+	// Create the capture pipeline for started events.
+	capture := pipeline.New(pipeline.Config{
+		ServerURL:   cfg.ServerURL,
+		AntBoxID:    cfg.AntBoxID,
+		Token:       cfg.Token,
+		SpoolDir:    cfg.SpoolDir,
+		BufferBytes: int64(tuners.BufferSizeMB) << 20,
+		Transcode:   tuners.Transcode,
+	}, logger)
+	handler.SetCapturer(capture)
+
+	if cfg.Token == "" {
+		logger.Warn("ANTBOX_TOKEN is not set; the server will reject the connection")
+	}
+
+	// Create the server connection. It delivers heartbeats and feeds
+	// server commands to the handler.
+	client := transport.New(transport.Config{
+		ServerURL: cfg.ServerURL,
+		AntBoxID:  cfg.AntBoxID,
+		Token:     cfg.Token,
+		Version:   daemon.Version,
+	}, handler, recovery.NewRetrier(recovery.DefaultBackoff(), logger), logger)
+
 	// Create the heartbeat reporter.
 	adapter := &statusAdapter{handler: handler, scanner: sc}
-	sender := &logSender{logger: logger}
-	reporter := heartbeat.NewReporter(cfg.AntBoxID, cfg.HeartbeatInterval, sender, adapter, logger)
+	reporter := heartbeat.NewReporter(cfg.AntBoxID, cfg.HeartbeatInterval, client, adapter, logger)
 
 	// Set up context with cancellation for graceful shutdown.
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start the heartbeat reporter in its own goroutine.
+	// Start the server connection and heartbeat reporter in their own goroutines.
+	go client.Run(ctx)
 	go reporter.Run(ctx)
 
 	logger.Info("antbox daemon running; waiting for commands")
 
-	// In production, this would connect to AntServer via WebSocket and
-	// process incoming commands through handler.Handle(). For now, the
-	// daemon runs the heartbeat loop and waits for shutdown.
-	_ = handler // used by WebSocket command loop in production
-
 	// Wait for interrupt signal for graceful shutdown.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-quit
 
 	logger.WithField("signal", sig.String()).Info("shutting down antbox daemon")
+	capture.Close()
+	if dvbClient != nil {
+		dvbClient.Close()
+	}
 	cancel()
 
 	sent, failed := reporter.Stats()
@@ -61,6 +61,9 @@ if [ ! -f /etc/antbox/antbox.env ]; then
 # AntBox Configuration
 # Set this to your Owl server's URL (http://your-server-ip:7860)
 ANTBOX_SERVER_URL=http://localhost:7860
+# Device id and token from Admin → AntBoxes (required to connect)
+ANTBOX_ID=
+ANTBOX_TOKEN=
 ANTBOX_LOG_LEVEL=info
 ENV
   ok "Created /etc/antbox/antbox.env — edit it with your Owl server URL."
@@ -68,7 +71,7 @@ fi
 
 ok "AntBox v${ANTBOX_VERSION} installed."
 log "Next steps:"
-log "  1. Edit /etc/antbox/antbox.env: set ANTBOX_SERVER_URL"
+log "  1. Edit /etc/antbox/antbox.env: set ANTBOX_SERVER_URL, ANTBOX_ID and ANTBOX_TOKEN"
 log "  2. Plug in your USB DVB tuner"
 log "  3. systemctl start antbox"
 log "  4. systemctl enable antbox  (auto-start on boot)"
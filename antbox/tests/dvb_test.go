@@ -0,0 +1,493 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"antbox/internal/dvb"
+	"antbox/internal/hdhomerun"
+)
+
+// --- synthetic transport stream ---
+
+func mpegCRC(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// psiSection builds a long-form PSI section with a valid CRC.
+func psiSection(tableID byte, ext uint16, body []byte) []byte {
+	n := 5 + len(body) + 4
+	s := []byte{tableID, 0xB0 | byte(n>>8), byte(n), byte(ext >> 8), byte(ext), 0xC1, 0, 0}
+	s = append(s, body...)
+	return binary.BigEndian.AppendUint32(s, mpegCRC(s))
+}
+
+// psiPacket carries one section in a single TS packet.
+func psiPacket(pid uint16, section []byte) []byte {
+	pkt := make([]byte, dvb.PacketSize)
+	pkt[0], pkt[1], pkt[2], pkt[3] = 0x47, 0x40|byte(pid>>8), byte(pid), 0x10
+	n := copy(pkt[5:], section)
+	for i := 5 + n; i < len(pkt); i++ {
+		pkt[i] = 0xFF
+	}
+	return pkt
+}
+
+// esPacket is a payload-only packet on pid.
+func esPacket(pid uint16) []byte {
+	pkt := make([]byte, dvb.PacketSize)
+	pkt[0], pkt[1], pkt[2], pkt[3] = 0x47, byte(pid>>8), byte(pid), 0x10
+	return pkt
+}
+
+func pidOf(pkt []byte) uint16 { return binary.BigEndian.Uint16(pkt[1:3]) & 0x1FFF }
+
+type testVirtualChannel struct {
+	name         string
+	major, minor int
+	program      uint16
+	pmtPID       uint16
+	esPIDs       []uint16
+}
+
+// atscMux builds one cycle of an ATSC multiplex: PAT, TVCT, PMTs and one
+// packet per elementary stream.
+func atscMux(channels []testVirtualChannel) []byte {
+	var pat, vct []byte
+	vct = append(vct, 0, byte(len(channels)))
+	for _, c := range channels {
+		pat = binary.BigEndian.AppendUint16(pat, c.program)
+		pat = binary.BigEndian.AppendUint16(pat, 0xE000|c.pmtPID)
+
+		entry := make([]byte, 32)
+		name := utf16.Encode([]rune(c.name))
+		for i := 0; i < 7 && i < len(name); i++ {
+			binary.BigEndian.PutUint16(entry[2*i:], name[i])
+		}
+		entry[14] = 0xF0 | byte(c.major>>6)
+		entry[15] = byte(c.major&0x3F)<<2 | byte(c.minor>>8)
+		entry[16] = byte(c.minor)
+		entry[17] = 0x04 // 8VSB
+		binary.BigEndian.PutUint16(entry[24:], c.program)
+		entry[26] = 0x0D
+		entry[27] = 0xC0 | 0x02 // ATSC digital television
+		binary.BigEndian.PutUint16(entry[30:], 0xFC00)
+		vct = append(vct, entry...)
+	}
+	vct = append(vct, 0xFC, 0x00)
+
+	ts := psiPacket(0x0000, psiSection(0x00, 0x0001, pat))
+	ts = append(ts, psiPacket(0x1FFB, psiSection(0xC8, 0x0001, vct))...)
+	for _, c := range channels {
+		pmt := []byte{0xE0 | byte(c.esPIDs[0]>>8), byte(c.esPIDs[0]), 0xF0, 0x00}
+		for i, pid := range c.esPIDs {
+			streamType := byte(0x02)
+			if i > 0 {
+				streamType = 0x81
+			}
+			pmt = append(pmt, streamType, 0xE0|byte(pid>>8), byte(pid), 0xF0, 0x00)
+		}
+		ts = append(ts, psiPacket(c.pmtPID, psiSection(0x02, c.program, pmt))...)
+		for _, pid := range c.esPIDs {
+			ts = append(ts, esPacket(pid)...)
+		}
+	}
+	return ts
+}
+
+var testLineup = []testVirtualChannel{
+	{name: "WEWS-DT", major: 5, minor: 1, program: 3, pmtPID: 0x100, esPIDs: []uint16{0x101, 0x102}},
+	{name: "Grit", major: 5, minor: 2, program: 4, pmtPID: 0x200, esPIDs: []uint16{0x201, 0x202}},
+}
+
+// --- fake /dev/dvb ---
+
+// fakeTuner is one frontend and its DVR. It locks on frequencies that have
+// a stream and replays that stream from the DVR.
+type fakeTuner struct {
+	mu      sync.Mutex
+	freq    int
+	streams map[int][]byte
+}
+
+func (t *fakeTuner) stream() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.streams[t.freq]
+}
+
+type fakeFrontend struct {
+	t    *fakeTuner
+	info dvb.FrontendInfo
+}
+
+func (f *fakeFrontend) Info() (dvb.FrontendInfo, error) { return f.info, nil }
+
+func (f *fakeFrontend) Tune(p dvb.TuneParams) error {
+	f.t.mu.Lock()
+	f.t.freq = p.Frequency
+	f.t.mu.Unlock()
+	return nil
+}
+
+func (f *fakeFrontend) Status() (dvb.FrontendStatus, error) {
+	if f.t.stream() != nil {
+		return dvb.HasSignal | dvb.HasCarrier | dvb.HasLock, nil
+	}
+	return 0, nil
+}
+
+func (f *fakeFrontend) Signal() (uint16, uint16, error) {
+	if f.t.stream() != nil {
+		return 0xC000, 0x8000, nil
+	}
+	return 0x1000, 0, nil
+}
+
+func (f *fakeFrontend) Close() error { return nil }
+
+type fakeDemux struct{}
+
+func (fakeDemux) StreamAll() error { return nil }
+func (fakeDemux) Close() error     { return nil }
+
+type fakeDVR struct {
+	t      *fakeTuner
+	mu     sync.Mutex
+	closed bool
+}
+
+func (d *fakeDVR) Read(p []byte) (int, error) {
+	for {
+		d.mu.Lock()
+		closed := d.closed
+		d.mu.Unlock()
+		if closed {
+			return 0, os.ErrClosed
+		}
+		if s := d.t.stream(); s != nil {
+			time.Sleep(time.Millisecond)
+			return copy(p, s), nil
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (d *fakeDVR) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	return nil
+}
+
+// fakeOpener serves device nodes that exist in a temporary /dev/dvb tree.
+type fakeOpener struct {
+	root   string
+	info   dvb.FrontendInfo
+	mu     sync.Mutex
+	tuners map[string]*fakeTuner
+	freqs  map[int][]byte
+}
+
+func (o *fakeOpener) tuner(path, kind string) (*fakeTuner, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	rel, _ := filepath.Rel(o.root, path)
+	adapter, node := filepath.Split(rel)
+	key := adapter + strings.TrimPrefix(node, kind)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	t, ok := o.tuners[key]
+	if !ok {
+		t = &fakeTuner{streams: o.freqs}
+		o.tuners[key] = t
+	}
+	return t, nil
+}
+
+func (o *fakeOpener) OpenFrontend(path string) (dvb.Frontend, error) {
+	t, err := o.tuner(path, "frontend")
+	if err != nil {
+		return nil, err
+	}
+	return &fakeFrontend{t: t, info: o.info}, nil
+}
+
+func (o *fakeOpener) OpenDemux(path string) (dvb.Demux, error) {
+	if _, err := o.tuner(path, "demux"); err != nil {
+		return nil, err
+	}
+	return fakeDemux{}, nil
+}
+
+func (o *fakeOpener) OpenDVR(path string) (io.ReadCloser, error) {
+	t, err := o.tuner(path, "dvr")
+	if err != nil {
+		return nil, err
+	}
+	return &fakeDVR{t: t}, nil
+}
+
+// newFakeDVB builds /dev/dvb with adapter0 (two frontends) and adapter1
+// (no frontend), broadcasting testLineup on RF 14 (473 MHz).
+func newFakeDVB(t *testing.T) (*dvb.Client, *fakeOpener, string) {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "dvb")
+	for _, node := range []string{
+		"adapter0/frontend0", "adapter0/demux0", "adapter0/dvr0",
+		"adapter0/frontend1", "adapter0/demux1", "adapter0/dvr1",
+		"adapter1/net0",
+	} {
+		p := filepath.Join(root, node)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opener := &fakeOpener{
+		root:   root,
+		info:   dvb.FrontendInfo{Name: "Hauppauge WinTV-dualHD", Type: dvb.FrontendATSC},
+		tuners: map[string]*fakeTuner{},
+		freqs:  map[int][]byte{473_000_000: atscMux(testLineup)},
+	}
+	stateDir := t.TempDir()
+	client := dvb.NewClient(dvb.Config{
+		Root:         root,
+		StateDir:     stateDir,
+		LockTimeout:  20 * time.Millisecond,
+		TableTimeout: time.Second,
+	}, opener, newTestLogger())
+	t.Cleanup(func() { client.Close() })
+	return client, opener, stateDir
+}
+
+// --- tests ---
+
+func TestDVB_DiscoverListsAdaptersWithFrontends(t *testing.T) {
+	client, _, _ := newFakeDVB(t)
+
+	devices, err := client.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("expected 1 device, got %+v", devices)
+	}
+	d := devices[0]
+	if d.IP != "dvb:adapter0" || d.DeviceID != "dvb-adapter0" {
+		t.Errorf("unexpected address: %+v", d)
+	}
+	if d.Model != "Hauppauge WinTV-dualHD" {
+		t.Errorf("expected frontend name as model, got %q", d.Model)
+	}
+	if d.TunerCount != 2 {
+		t.Errorf("expected 2 tuners, got %d", d.TunerCount)
+	}
+}
+
+func TestDVB_ScanFindsVirtualChannels(t *testing.T) {
+	client, _, stateDir := newFakeDVB(t)
+
+	var last hdhomerun.ScanProgress
+	channels, err := client.ScanChannels(context.Background(), "dvb:adapter0", true, func(p hdhomerun.ScanProgress) {
+		last = p
+	})
+	if err != nil {
+		t.Fatalf("ScanChannels: %v", err)
+	}
+	want := []hdhomerun.Channel{
+		{Number: "5.1", Name: "WEWS-DT", Frequency: 473_000_000, Modulation: "8vsb", Program: 3},
+		{Number: "5.2", Name: "Grit", Frequency: 473_000_000, Modulation: "8vsb", Program: 4},
+	}
+	if len(channels) != len(want) {
+		t.Fatalf("expected %d channels, got %+v", len(want), channels)
+	}
+	for i := range want {
+		if channels[i] != want[i] {
+			t.Errorf("channel %d: got %+v, want %+v", i, channels[i], want[i])
+		}
+	}
+	if last.Percent != 100 || last.Found != 2 || last.ChannelsScanned != last.TotalChannels {
+		t.Errorf("unexpected final progress: %+v", last)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "dvb-lineup-adapter0.json")); err != nil {
+		t.Errorf("lineup not saved: %v", err)
+	}
+}
+
+func TestDVB_TuneStreamsOnlySelectedProgram(t *testing.T) {
+	client, _, _ := newFakeDVB(t)
+	ctx := context.Background()
+	if _, err := client.ScanChannels(ctx, "dvb:adapter0", true, nil); err != nil {
+		t.Fatalf("ScanChannels: %v", err)
+	}
+
+	url, err := client.TuneTo(ctx, "dvb:adapter0", 1, "5.2")
+	if err != nil {
+		t.Fatalf("TuneTo: %v", err)
+	}
+	if !strings.HasSuffix(url, "/dvb/adapter0/1") {
+		t.Errorf("unexpected stream URL %q", url)
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	// A second consumer on the same tuner is refused.
+	second, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("second GET: %v", err)
+	}
+	second.Body.Close()
+	if second.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for second consumer, got %d", second.StatusCode)
+	}
+
+	seen := map[uint16]int{}
+	r := bufio.NewReader(resp.Body)
+	pkt := make([]byte, dvb.PacketSize)
+	for i := 0; i < 60; i++ {
+		if _, err := io.ReadFull(r, pkt); err != nil {
+			t.Fatalf("read packet %d: %v", i, err)
+		}
+		pid := pidOf(pkt)
+		seen[pid]++
+		if pid == 0 {
+			// Rewritten PAT: one program (4) on PMT PID 0x200.
+			if got := binary.BigEndian.Uint16(pkt[13:15]); got != 4 {
+				t.Errorf("PAT lists program %d, want 4", got)
+			}
+			if got := binary.BigEndian.Uint16(pkt[15:17]) & 0x1FFF; got != 0x200 {
+				t.Errorf("PAT lists PMT PID %#x, want 0x200", got)
+			}
+		}
+	}
+	for pid := range seen {
+		switch pid {
+		case 0x0000, 0x0200, 0x0201, 0x0202:
+		default:
+			t.Errorf("unexpected PID %#x in filtered stream", pid)
+		}
+	}
+	if seen[0x201] == 0 || seen[0x202] == 0 {
+		t.Errorf("selected program's streams missing: %v", seen)
+	}
+
+	q, err := client.GetSignalQuality(ctx, "dvb:adapter0", 1)
+	if err != nil {
+		t.Fatalf("GetSignalQuality: %v", err)
+	}
+	if q.Strength != 75 || q.SNR != 50 || q.Quality != 50 {
+		t.Errorf("unexpected signal: %+v", q)
+	}
+}
+
+func TestDVB_TuneUsesSavedLineup(t *testing.T) {
+	client, opener, stateDir := newFakeDVB(t)
+	ctx := context.Background()
+
+	if _, err := client.TuneTo(ctx, "dvb:adapter0", 0, "5.1"); err == nil {
+		t.Fatal("expected error tuning before any scan")
+	}
+	if _, err := client.ScanChannels(ctx, "dvb:adapter0", true, nil); err != nil {
+		t.Fatalf("ScanChannels: %v", err)
+	}
+
+	// A restarted daemon tunes from the saved lineup without rescanning.
+	restarted := dvb.NewClient(dvb.Config{Root: opener.root, StateDir: stateDir}, opener, newTestLogger())
+	defer restarted.Close()
+	if _, err := restarted.TuneTo(ctx, "dvb:adapter0", 0, "5.1"); err != nil {
+		t.Fatalf("TuneTo after restart: %v", err)
+	}
+	if _, err := restarted.TuneTo(ctx, "dvb:adapter0", 0, "9.9"); err == nil {
+		t.Error("expected error for a channel not in the lineup")
+	}
+}
+
+func TestDVB_UnlockedSignal(t *testing.T) {
+	client, _, _ := newFakeDVB(t)
+
+	q, err := client.GetSignalQuality(context.Background(), "dvb:adapter0", 0)
+	if err != nil {
+		t.Fatalf("GetSignalQuality: %v", err)
+	}
+	if q.Quality != 0 || q.SNR != 0 {
+		t.Errorf("expected zero quality without lock, got %+v", q)
+	}
+	if _, err := client.GetSignalQuality(context.Background(), "dvb:adapter7", 0); err == nil {
+		t.Error("expected error for a missing adapter")
+	}
+}
+
+func TestDVB_RouterSplitsByAddress(t *testing.T) {
+	client, _, _ := newFakeDVB(t)
+	network := &MockClient{
+		DiscoverDevices: []hdhomerun.Device{{DeviceID: "1234ABCD", IP: "192.168.1.50", TunerCount: 4}},
+		Signal:          &hdhomerun.SignalQuality{Strength: 90, SNR: 80, Quality: 85},
+	}
+	router := &dvb.Router{DVB: client, Network: network}
+	ctx := context.Background()
+
+	devices, err := router.Discover(ctx)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	var ips []string
+	for _, d := range devices {
+		ips = append(ips, d.IP)
+	}
+	if strings.Join(ips, ",") != "dvb:adapter0,192.168.1.50" {
+		t.Errorf("unexpected devices: %v", ips)
+	}
+
+	if q, err := router.GetSignalQuality(ctx, "192.168.1.50", 0); err != nil || q.Quality != 85 {
+		t.Errorf("network signal: %+v, %v", q, err)
+	}
+	if _, err := router.GetSignalQuality(ctx, "dvb:adapter0", 0); err != nil {
+		t.Errorf("dvb signal: %v", err)
+	}
+	network.mu.Lock()
+	calls := len(network.SignalCalls)
+	network.mu.Unlock()
+	if calls != 1 {
+		t.Errorf("expected 1 network signal call, got %d", calls)
+	}
+
+	// Network discovery failing still returns local adapters.
+	network.DiscoverErr = errors.New("no route")
+	devices, err = router.Discover(ctx)
+	if err != nil || len(devices) != 1 {
+		t.Errorf("expected local adapter despite network error, got %v, %v", devices, err)
+	}
+}
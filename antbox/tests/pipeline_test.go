@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"antbox/internal/pipeline"
+)
+
+// fakeFFmpeg writes two 4-byte segments and a playlist, then on SIGINT
+// finishes a third segment and exits like ffmpeg does.
+const fakeFFmpeg = `#!/bin/sh
+for a; do pl=$a; done
+dir=$(dirname "$pl")
+printf 'aaaa' > "$dir/1000-000001.ts"
+printf 'bbbb' > "$dir/1000-000002.ts"
+printf '#EXTM3U\n#EXTINF:6.000,\n1000-000001.ts\n#EXTINF:6.000,\n1000-000002.ts\n' > "$pl"
+trap 'printf "cccc" > "$dir/1000-000003.ts"; printf "#EXTINF:2.500,\n1000-000003.ts\n" >> "$pl"; exit 0' INT
+while :; do sleep 0.05; done
+`
+
+// fakeIngest records segment uploads. While down is set it answers 503.
+type fakeIngest struct {
+	down atomic.Bool
+
+	mu        sync.Mutex
+	segments  []string
+	durations map[string]string
+	completed bool
+}
+
+func (f *fakeIngest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-AntBox-ID") != "box-1" || r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if f.down.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/antbox/events/ev1/segments/"):
+		name := strings.TrimPrefix(r.URL.Path, "/antbox/events/ev1/segments/")
+		_, _ = io.Copy(io.Discard, r.Body)
+		f.segments = append(f.segments, name)
+		f.durations[name] = r.Header.Get("X-Segment-Duration")
+	case r.Method == http.MethodPost && r.URL.Path == "/antbox/events/ev1/complete":
+		f.completed = true
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeIngest) snapshot() ([]string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.segments...), f.completed
+}
+
+func newTestPipeline(t *testing.T, ingest *fakeIngest, bufferBytes int64) (*pipeline.Manager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(script, []byte(fakeFFmpeg), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(ingest)
+	t.Cleanup(srv.Close)
+
+	spool := filepath.Join(dir, "spool")
+	m := pipeline.New(pipeline.Config{
+		ServerURL:    strings.Replace(srv.URL, "http://", "ws://", 1),
+		AntBoxID:     "box-1",
+		Token:        "secret",
+		SpoolDir:     spool,
+		BufferBytes:  bufferBytes,
+		FFmpegPath:   script,
+		PollInterval: 20 * time.Millisecond,
+		DrainTimeout: 5 * time.Second,
+	}, newTestLogger())
+	t.Cleanup(m.Close)
+	return m, spool
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPipeline_UploadsResumesAndCompletes(t *testing.T) {
+	t.Parallel()
+
+	ingest := &fakeIngest{durations: map[string]string{}}
+	ingest.down.Store(true)
+	m, spool := newTestPipeline(t, ingest, 0)
+
+	if err := m.Start("ev1", "http://192.168.1.100:5004/auto/v5.1"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := m.Start("ev1", "http://192.168.1.100:5004/auto/v5.1"); !errors.Is(err, pipeline.ErrEventActive) {
+		t.Errorf("expected ErrEventActive for duplicate start, got %v", err)
+	}
+
+	// Segments wait in the spool while the server is unreachable.
+	waitFor(t, "segments in spool", func() bool {
+		_, err := os.Stat(filepath.Join(spool, "ev1", "1000-000002.ts"))
+		return err == nil
+	})
+	time.Sleep(100 * time.Millisecond)
+	if segs, _ := ingest.snapshot(); len(segs) != 0 {
+		t.Fatalf("expected no uploads while server is down, got %v", segs)
+	}
+
+	ingest.down.Store(false)
+	waitFor(t, "first two segments", func() bool {
+		segs, _ := ingest.snapshot()
+		return len(segs) == 2
+	})
+
+	if err := m.Stop("ev1"); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	waitFor(t, "completion", func() bool {
+		_, done := ingest.snapshot()
+		return done
+	})
+
+	segs, _ := ingest.snapshot()
+	want := []string{"1000-000001.ts", "1000-000002.ts", "1000-000003.ts"}
+	if strings.Join(segs, ",") != strings.Join(want, ",") {
+		t.Errorf("uploaded %v, want %v", segs, want)
+	}
+	ingest.mu.Lock()
+	if d := ingest.durations["1000-000003.ts"]; d != "2.500" {
+		t.Errorf("expected final segment duration 2.500, got %q", d)
+	}
+	ingest.mu.Unlock()
+
+	waitFor(t, "spool cleanup", func() bool {
+		_, err := os.Stat(filepath.Join(spool, "ev1"))
+		return os.IsNotExist(err)
+	})
+	if err := m.Stop("ev1"); !errors.Is(err, pipeline.ErrEventNotFound) {
+		t.Errorf("expected ErrEventNotFound for second stop, got %v", err)
+	}
+}
+
+func TestPipeline_RingBufferDropsOldestWhenFull(t *testing.T) {
+	t.Parallel()
+
+	ingest := &fakeIngest{durations: map[string]string{}}
+	ingest.down.Store(true)
+	// Room for one 4-byte segment: the oldest un-uploaded one must go.
+	m, spool := newTestPipeline(t, ingest, 6)
+
+	if err := m.Start("ev1", "http://192.168.1.100:5004/auto/v5.1"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitFor(t, "segments in spool", func() bool {
+		_, err := os.Stat(filepath.Join(spool, "ev1", "1000-000002.ts"))
+		return err == nil
+	})
+	waitFor(t, "eviction", func() bool {
+		_, err := os.Stat(filepath.Join(spool, "ev1", "1000-000001.ts"))
+		return os.IsNotExist(err)
+	})
+
+	ingest.down.Store(false)
+	if err := m.Stop("ev1"); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	waitFor(t, "completion", func() bool {
+		_, done := ingest.snapshot()
+		return done
+	})
+	segs, _ := ingest.snapshot()
+	for _, s := range segs {
+		if s == "1000-000001.ts" {
+			t.Errorf("evicted segment was uploaded: %v", segs)
+		}
+	}
+	if len(segs) == 0 || segs[len(segs)-1] != "1000-000003.ts" {
+		t.Errorf("expected final segment to be uploaded, got %v", segs)
+	}
+}
+
+func TestPipeline_RejectsUnsafeEventID(t *testing.T) {
+	t.Parallel()
+
+	m, _ := newTestPipeline(t, &fakeIngest{durations: map[string]string{}}, 0)
+	if err := m.Start("../etc", "http://192.168.1.100:5004/auto/v5.1"); err == nil {
+		t.Error("expected error for event id with path separators")
+	}
+}
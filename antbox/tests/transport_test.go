@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"antbox/internal/command"
+	"antbox/internal/recovery"
+	"antbox/internal/transport"
+)
+
+// fakeServer accepts one AntBox WebSocket connection and exposes its
+// messages to the test.
+type fakeServer struct {
+	t        *testing.T
+	header   chan http.Header
+	messages chan transport.Envelope
+	conn     chan net.Conn
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *httptest.Server) {
+	fs := &fakeServer{
+		t:        t,
+		header:   make(chan http.Header, 1),
+		messages: make(chan transport.Envelope, 16),
+		conn:     make(chan net.Conn, 1),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fs.serve))
+	t.Cleanup(srv.Close)
+	return fs, srv
+}
+
+func (fs *fakeServer) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != transport.DefaultPath {
+		http.NotFound(w, r)
+		return
+	}
+	fs.header <- r.Header.Clone()
+
+	conn, brw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		fs.t.Errorf("hijack: %v", err)
+		return
+	}
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	_ = brw.Flush()
+	fs.conn <- conn
+
+	go func() {
+		for {
+			payload, err := readClientFrame(brw.Reader)
+			if err != nil {
+				close(fs.messages)
+				return
+			}
+			var env transport.Envelope
+			if err := json.Unmarshal(payload, &env); err == nil {
+				fs.messages <- env
+			}
+		}
+	}()
+}
+
+// readClientFrame reads one masked client frame.
+func readClientFrame(br *bufio.Reader) ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, err
+	}
+	length := uint64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(br, mask[:]); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return payload, nil
+}
+
+// writeServerText writes one unmasked text frame (payloads < 126 bytes).
+func writeServerText(t *testing.T, conn net.Conn, payload []byte) {
+	t.Helper()
+	if len(payload) >= 126 {
+		t.Fatalf("test payload too long: %d", len(payload))
+	}
+	frame := append([]byte{0x81, byte(len(payload))}, payload...)
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+}
+
+func nextMessage(t *testing.T, fs *fakeServer) transport.Envelope {
+	t.Helper()
+	select {
+	case env, ok := <-fs.messages:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		return env
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return transport.Envelope{}
+}
+
+func TestTransport_HelloHeartbeatAndCommand(t *testing.T) {
+	t.Parallel()
+
+	fs, srv := newFakeServer(t)
+	handler, _ := newTestHandler()
+	logger := newTestLogger()
+	client := transport.New(transport.Config{
+		ServerURL: srv.URL,
+		AntBoxID:  "box-1",
+		Token:     "secret",
+		Version:   "1.2.3",
+	}, handler, recovery.NewRetrier(recovery.DefaultBackoff(), logger), logger)
+
+	if err := client.Send(context.Background(), []byte(`{}`)); err != transport.ErrNotConnected {
+		t.Fatalf("Send before connect: expected ErrNotConnected, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	header := <-fs.header
+	if header.Get("X-AntBox-ID") != "box-1" || header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("unexpected auth headers: %v", header)
+	}
+	conn := <-fs.conn
+
+	hello := nextMessage(t, fs)
+	if hello.Type != transport.TypeHello {
+		t.Fatalf("expected hello, got %q", hello.Type)
+	}
+	var h transport.Hello
+	if err := json.Unmarshal(hello.Data, &h); err != nil || h.AntBoxID != "box-1" || h.Version != "1.2.3" {
+		t.Errorf("unexpected hello: %s (%v)", hello.Data, err)
+	}
+
+	if err := client.Send(ctx, []byte(`{"antbox_id":"box-1"}`)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	hb := nextMessage(t, fs)
+	if hb.Type != transport.TypeHeartbeat || !strings.Contains(string(hb.Data), "box-1") {
+		t.Errorf("unexpected heartbeat: %+v", hb)
+	}
+
+	writeServerText(t, conn, []byte(`{"type":"command","data":{"id":"cmd-1","type":"HEALTH"}}`))
+	resp := nextMessage(t, fs)
+	if resp.Type != transport.TypeResponse {
+		t.Fatalf("expected response, got %q", resp.Type)
+	}
+	var r command.Response
+	if err := json.Unmarshal(resp.Data, &r); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if r.CommandID != "cmd-1" || !r.Success {
+		t.Errorf("unexpected response: %+v", r)
+	}
+	if !client.Connected() {
+		t.Error("expected client to report connected")
+	}
+}
+
+func TestTransport_ServerEndpoint(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"ws://localhost:9090":            "ws://localhost:9090/antbox/connect",
+		"https://roost.example.com":      "wss://roost.example.com/antbox/connect",
+		"http://10.0.0.5:8080/":          "ws://10.0.0.5:8080/antbox/connect",
+		"wss://roost.example.com/custom": "wss://roost.example.com/custom",
+	}
+	for in, want := range cases {
+		got, err := transport.ServerEndpoint(in)
+		if err != nil {
+			t.Errorf("ServerEndpoint(%q): %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ServerEndpoint(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if _, err := transport.ServerEndpoint("ftp://example.com"); err == nil {
+		t.Error("expected error for ftp scheme")
+	}
+}
@@ -158,6 +158,41 @@ func TestWatchdog_DuplicateProcess(t *testing.T) {
 	sv.Wait()
 }
 
+func TestWatchdog_StopAllowsResupervise(t *testing.T) {
+	t.Parallel()
+
+	factory := &longRunningFactory{}
+	logger := newTestLogger()
+	sv := watchdog.NewSupervisor(factory, logger, 5, 50*time.Millisecond, 200*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	spec := watchdog.ProcessSpec{Name: "stop-test", Command: "sleep", Args: []string{"3600"}}
+	if err := sv.Supervise(ctx, spec); err != nil {
+		t.Fatalf("supervise failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if err := sv.Stop("stop-test"); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+	if _, err := sv.GetInfo("stop-test"); err == nil {
+		t.Error("expected stopped process to be removed")
+	}
+	if err := sv.Stop("stop-test"); err == nil {
+		t.Error("expected error stopping an unknown process")
+	}
+
+	// The same name can be supervised again after Stop.
+	if err := sv.Supervise(ctx, spec); err != nil {
+		t.Fatalf("re-supervise failed: %v", err)
+	}
+
+	cancel()
+	sv.Wait()
+}
+
 func TestWatchdog_RestartsOnCrash(t *testing.T) {
 	t.Parallel()
 
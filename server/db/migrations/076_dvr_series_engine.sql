@@ -0,0 +1,48 @@
+-- 076_dvr_series_engine.sql — Series-rule engine support for dvr_schedule.
+-- The DVR service's series engine matches EPG programs against dvr_series rows
+-- and inserts dvr_schedule entries. These columns let it recognise what it has
+-- already scheduled and enforce keep_last_n per series.
+--
+-- dvr_series.always_record selects the recording mode:
+--   TRUE  = record every airing that matches show_title
+--   FALSE = record new episodes only (first airing of each episode)
+--
+-- Rollback:
+-- DROP INDEX IF EXISTS idx_dvr_schedule_series_program;
+-- DROP INDEX IF EXISTS idx_dvr_schedule_series;
+-- ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS episode_key;
+-- ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS program_id;
+-- ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS series_id;
+-- ALTER TABLE dvr_series DROP COLUMN IF EXISTS last_matched_at;
+-- ALTER TABLE dvr_series DROP COLUMN IF EXISTS guide_channel_id;
+
+-- guide_channel_id: the channels row whose guide the rule matches. Required
+-- by POST /admin/dvr/series; the engine skips rules where it is NULL, since
+-- the recording is made from the rule's channel_id.
+ALTER TABLE dvr_series
+    ADD COLUMN IF NOT EXISTS guide_channel_id UUID REFERENCES channels(id) ON DELETE SET NULL;
+
+ALTER TABLE dvr_series
+    ADD COLUMN IF NOT EXISTS last_matched_at TIMESTAMPTZ;
+
+-- series_id: NULL for manual recordings created via POST /admin/dvr/schedule.
+ALTER TABLE dvr_schedule
+    ADD COLUMN IF NOT EXISTS series_id UUID REFERENCES dvr_series(id) ON DELETE SET NULL;
+
+-- program_id: the programs row this entry was scheduled from (no FK — programs
+-- are pruned by the EPG service 7 days after they air).
+ALTER TABLE dvr_schedule
+    ADD COLUMN IF NOT EXISTS program_id UUID;
+
+-- episode_key: normalised title + description, used by new-episodes-only rules
+-- to skip repeat airings of an episode that is already scheduled or recorded.
+ALTER TABLE dvr_schedule
+    ADD COLUMN IF NOT EXISTS episode_key TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_dvr_schedule_series
+    ON dvr_schedule(series_id, start_time)
+    WHERE series_id IS NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dvr_schedule_series_program
+    ON dvr_schedule(series_id, program_id)
+    WHERE series_id IS NOT NULL AND program_id IS NOT NULL;
@@ -0,0 +1,87 @@
+-- 077_media_library.sql — Local media library index columns on recordings.
+-- The library scanner (services/library) walks roost_storage_paths and upserts
+-- one recordings row per media file. These columns hold its classification,
+-- ffprobe results, and the catalog row (catalog_items / music_albums / games)
+-- the file was matched to.
+--
+-- file_size_bytes + file_mtime let rescans skip unchanged files.
+-- is_missing marks files that disappeared from disk; rows are kept so watch
+-- history and catalog links survive a NAS going offline.
+--
+-- Rollback:
+-- DROP INDEX IF EXISTS idx_recordings_catalog_item;
+-- DROP INDEX IF EXISTS idx_recordings_roost_path;
+-- ALTER TABLE recordings_path_duplicates DROP COLUMN moved_at;
+-- INSERT INTO recordings SELECT * FROM recordings_path_duplicates;
+-- DROP TABLE IF EXISTS recordings_path_duplicates;
+-- ALTER TABLE recordings
+--     DROP COLUMN IF EXISTS is_missing, DROP COLUMN IF EXISTS last_seen_at,
+--     DROP COLUMN IF EXISTS mb_id, DROP COLUMN IF EXISTS tmdb_id,
+--     DROP COLUMN IF EXISTS catalog_item_id, DROP COLUMN IF EXISTS bitrate,
+--     DROP COLUMN IF EXISTS height, DROP COLUMN IF EXISTS width,
+--     DROP COLUMN IF EXISTS audio_codec, DROP COLUMN IF EXISTS video_codec,
+--     DROP COLUMN IF EXISTS platform, DROP COLUMN IF EXISTS album,
+--     DROP COLUMN IF EXISTS artist, DROP COLUMN IF EXISTS release_year,
+--     DROP COLUMN IF EXISTS episode_number, DROP COLUMN IF EXISTS season_number,
+--     DROP COLUMN IF EXISTS show_title, DROP COLUMN IF EXISTS title,
+--     DROP COLUMN IF EXISTS media_kind, DROP COLUMN IF EXISTS file_mtime;
+
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS file_mtime      TIMESTAMPTZ;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS media_kind      TEXT
+    CHECK (media_kind IN ('movie', 'episode', 'music', 'game'));
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS title           TEXT;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS show_title      TEXT;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS season_number   INTEGER;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS episode_number  INTEGER;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS release_year    INTEGER;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS artist          TEXT;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS album           TEXT;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS platform        TEXT;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS video_codec     TEXT;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS audio_codec     TEXT;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS width           INTEGER;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS height          INTEGER;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS bitrate         BIGINT;
+-- catalog_item_id: catalog_items.id (movie/series), music_albums.id or games.id
+-- depending on media_kind — not a foreign key for that reason.
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS catalog_item_id UUID;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS tmdb_id         INTEGER;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS mb_id           TEXT;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS last_seen_at    TIMESTAMPTZ;
+ALTER TABLE recordings ADD COLUMN IF NOT EXISTS is_missing      BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- One row per file per roost — the scanner's upsert key. Rows that already
+-- share a path are not deleted: every copy but the oldest (the keeper, as in
+-- GET /admin/storage/duplicates) is moved to recordings_path_duplicates for
+-- the admin to review, and a NOTICE reports how many were moved. The keeper
+-- has no file_mtime yet, so the first scan re-indexes it from the file.
+CREATE TABLE IF NOT EXISTS recordings_path_duplicates (LIKE recordings INCLUDING DEFAULTS);
+ALTER TABLE recordings_path_duplicates
+    ADD COLUMN IF NOT EXISTS moved_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+DO $$
+DECLARE
+    moved INTEGER;
+BEGIN
+    WITH ranked AS (
+        SELECT id, ROW_NUMBER() OVER (
+                   PARTITION BY roost_id, file_path ORDER BY created_at ASC, id ASC) AS rn
+        FROM recordings
+        WHERE file_path IS NOT NULL
+    ), extra AS (
+        DELETE FROM recordings r USING ranked
+        WHERE r.id = ranked.id AND ranked.rn > 1
+        RETURNING r.*
+    )
+    INSERT INTO recordings_path_duplicates SELECT * FROM extra;
+    GET DIAGNOSTICS moved = ROW_COUNT;
+    IF moved > 0 THEN
+        RAISE NOTICE '077: moved % recordings row(s) sharing a (roost_id, file_path) with an older row to recordings_path_duplicates', moved;
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_recordings_roost_path
+    ON recordings(roost_id, file_path);
+
+CREATE INDEX IF NOT EXISTS idx_recordings_catalog_item
+    ON recordings(catalog_item_id) WHERE catalog_item_id IS NOT NULL;
@@ -0,0 +1,14 @@
+-- 078_stalker_ingest_provider.sql — Allow Stalker portals as ingest providers.
+-- The ingest service's "stalker" provider authenticates to Stalker/Ministra
+-- (MAG) portals by MAC address. config JSONB keys: portal, mac_enc, optional
+-- timezone. The mac is the account credential: ingest stores it sealed as
+-- mac_enc (098) and the provider never writes it to logs.
+--
+-- Rollback:
+-- ALTER TABLE ingest_providers DROP CONSTRAINT IF EXISTS ingest_providers_provider_type_check;
+-- ALTER TABLE ingest_providers ADD CONSTRAINT ingest_providers_provider_type_check
+--     CHECK (provider_type IN ('m3u', 'xtream', 'hls', 'rtsp', 'antbox', 'rtmp', 'srt'));
+
+ALTER TABLE ingest_providers DROP CONSTRAINT IF EXISTS ingest_providers_provider_type_check;
+ALTER TABLE ingest_providers ADD CONSTRAINT ingest_providers_provider_type_check
+    CHECK (provider_type IN ('m3u', 'xtream', 'hls', 'stalker', 'rtsp', 'antbox', 'rtmp', 'srt'));
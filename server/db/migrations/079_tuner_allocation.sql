@@ -0,0 +1,59 @@
+-- 079_tuner_allocation.sql — Tuner/connection capacity model for DVR and live TV.
+-- Recordings and live sessions are allocated against real capacity: each
+-- active AntBox contributes antboxes.tuner_count tuners and each IPTV source
+-- its provider connection limit (Xtream user_info.max_connections, stored by
+-- POST /admin/iptv-sources/:id/refresh). roost_source_channels records which
+-- sources carry which channel so a recording can fall back to another source
+-- when its own is full.
+--
+-- Rollback:
+-- DROP TABLE IF EXISTS roost_live_sessions;
+-- DROP TABLE IF EXISTS roost_source_channels;
+-- ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS allocated_source_id;
+-- ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS allocated_source_kind;
+-- ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS priority;
+-- ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS channel_key;
+-- ALTER TABLE iptv_sources DROP COLUMN IF EXISTS max_connections;
+
+-- max_connections: NULL = unknown, counted as a single connection.
+ALTER TABLE iptv_sources
+    ADD COLUMN IF NOT EXISTS max_connections INTEGER
+    CHECK (max_connections IS NULL OR max_connections > 0);
+
+-- Channel lineup per source. channel_key is the lowercased tvg-id / EPG
+-- channel id shared by every source carrying the channel.
+CREATE TABLE IF NOT EXISTS roost_source_channels (
+    roost_id      UUID        NOT NULL,
+    source_kind   TEXT        NOT NULL CHECK (source_kind IN ('iptv', 'antbox')),
+    source_id     UUID        NOT NULL,
+    channel_key   TEXT        NOT NULL,
+    display_name  TEXT,
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (source_kind, source_id, channel_key)
+);
+CREATE INDEX IF NOT EXISTS idx_roost_source_channels_key
+    ON roost_source_channels(roost_id, channel_key);
+
+-- channel_key: NULL = only channel_id (the requested source) may record.
+-- priority: higher wins when capacity runs out.
+-- allocated_source_*: the source the allocator picked; NULL while in conflict.
+ALTER TABLE dvr_schedule ADD COLUMN IF NOT EXISTS channel_key TEXT;
+ALTER TABLE dvr_schedule ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE dvr_schedule ADD COLUMN IF NOT EXISTS allocated_source_kind TEXT
+    CHECK (allocated_source_kind IS NULL OR allocated_source_kind IN ('iptv', 'antbox'));
+ALTER TABLE dvr_schedule ADD COLUMN IF NOT EXISTS allocated_source_id UUID;
+
+-- Live viewing sessions holding a tuner or connection. Clients keep a session
+-- alive every minute; rows not seen for two minutes no longer count.
+CREATE TABLE IF NOT EXISTS roost_live_sessions (
+    id            UUID        NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    roost_id      UUID        NOT NULL,
+    user_id       TEXT        NOT NULL,
+    channel_key   TEXT        NOT NULL,
+    source_kind   TEXT        NOT NULL CHECK (source_kind IN ('iptv', 'antbox')),
+    source_id     UUID        NOT NULL,
+    started_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_roost_live_sessions_roost
+    ON roost_live_sessions(roost_id, last_seen_at);
@@ -0,0 +1,58 @@
+-- 080_dvr_padding.sql — Pre/post padding and late-end rules for DVR recordings.
+-- padding_before_secs starts capture early; padding_after_secs keeps it running
+-- past the end. follow_epg extends an in-progress recording when its EPG
+-- programme's end_time moves later; sports_extend keeps it running while a
+-- sports event mapped to the channel is still live. Extensions are capped by
+-- the DVR service (DVR_MAX_EXTENSION_MIN, default 180).
+--
+-- dvr_series carries the same settings; the series engine copies them onto
+-- every dvr_schedule row it creates.
+--
+-- Rollback:
+-- ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS sports_extend;
+-- ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS follow_epg;
+-- ALTER TABLE dvr_series DROP COLUMN IF EXISTS sports_extend;
+-- ALTER TABLE dvr_series DROP COLUMN IF EXISTS follow_epg;
+-- ALTER TABLE dvr_series DROP COLUMN IF EXISTS padding_after_secs;
+-- ALTER TABLE dvr_series DROP COLUMN IF EXISTS padding_before_secs;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS extended_end_time;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS sports_extend;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS follow_epg;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS padding_after_secs;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS padding_before_secs;
+-- (then restore dvr_quota_usage from 018_dvr.sql)
+
+ALTER TABLE dvr_recordings
+    ADD COLUMN IF NOT EXISTS padding_before_secs INTEGER NOT NULL DEFAULT 0
+    CHECK (padding_before_secs >= 0);
+ALTER TABLE dvr_recordings
+    ADD COLUMN IF NOT EXISTS padding_after_secs INTEGER NOT NULL DEFAULT 0
+    CHECK (padding_after_secs >= 0);
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS follow_epg BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS sports_extend BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- extended_end_time: set when follow_epg / sports_extend moved the end past
+-- end_time. NULL = the recording ended on schedule.
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS extended_end_time TIMESTAMPTZ;
+
+ALTER TABLE dvr_series
+    ADD COLUMN IF NOT EXISTS padding_before_secs INTEGER NOT NULL DEFAULT 0
+    CHECK (padding_before_secs >= 0);
+ALTER TABLE dvr_series
+    ADD COLUMN IF NOT EXISTS padding_after_secs INTEGER NOT NULL DEFAULT 0
+    CHECK (padding_after_secs >= 0);
+ALTER TABLE dvr_series ADD COLUMN IF NOT EXISTS follow_epg BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE dvr_series ADD COLUMN IF NOT EXISTS sports_extend BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE dvr_schedule ADD COLUMN IF NOT EXISTS follow_epg BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE dvr_schedule ADD COLUMN IF NOT EXISTS sports_extend BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Padding is recorded time, so it counts against the subscriber's quota.
+CREATE OR REPLACE VIEW dvr_quota_usage AS
+SELECT
+    subscriber_id,
+    ROUND(SUM((EXTRACT(EPOCH FROM (end_time - start_time))
+               + padding_before_secs + padding_after_secs) / 3600)::NUMERIC, 2) AS used_hours
+FROM dvr_recordings
+WHERE status IN ('scheduled', 'recording', 'complete')
+GROUP BY subscriber_id;
@@ -0,0 +1,27 @@
+-- 081_dvr_capture_lease.sql — Lease ownership and partial results for DVR captures.
+-- The DVR instance capturing a recording holds a lease on its row and renews
+-- it while capturing. If the service dies mid-recording the lease expires and
+-- the next poll (on any instance) reclaims the row: capture resumes from the
+-- segment manifest in the scratch directory, or, when the recording's window
+-- has closed, it is finalized from what was captured. Either way the gap is
+-- recorded and the recording is flagged partial instead of hanging in
+-- 'recording' forever.
+--
+-- Rollback:
+-- DROP INDEX IF EXISTS idx_dvr_recordings_lease;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS capture_gaps;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS is_partial;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS lease_expires_at;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS lease_owner;
+
+-- lease_owner: "<hostname>:<pid>" of the capturing instance.
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS lease_owner TEXT;
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+
+-- is_partial: the capture was interrupted; capture_gaps counts the interruptions.
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS is_partial BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS capture_gaps INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_dvr_recordings_lease
+    ON dvr_recordings (lease_expires_at)
+    WHERE status = 'recording';
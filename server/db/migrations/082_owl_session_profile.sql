@@ -0,0 +1,12 @@
+-- 082_owl_session_profile.sql — Bind Owl sessions to a subscriber profile.
+-- owl_api enforces the active profile's parental controls (age_rating_limit,
+-- blocked_categories, viewing_schedule) on every content endpoint. A session
+-- starts on the profile named at POST /owl/auth (the primary profile by
+-- default) and is switched with POST /owl/v1/profile, which checks the PIN.
+--
+-- Rollback:
+-- ALTER TABLE owl_sessions DROP COLUMN IF EXISTS profile_id;
+
+-- profile_id: NULL = subscriber has no profiles yet; content is unrestricted.
+ALTER TABLE owl_sessions
+    ADD COLUMN IF NOT EXISTS profile_id UUID REFERENCES subscriber_profiles(id) ON DELETE SET NULL;
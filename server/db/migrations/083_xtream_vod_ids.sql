@@ -0,0 +1,16 @@
+-- 083_xtream_vod_ids.sql — Stable integer IDs for the Xtream Codes VOD API.
+-- Xtream players address movies, series and episodes by integer stream_id /
+-- series_id and cache them, so UUIDs cannot be used directly. Existing rows
+-- are numbered when the identity column is added; the values never change.
+--
+-- Rollback:
+-- ALTER TABLE vod_episodes DROP COLUMN IF EXISTS xtream_id;
+-- ALTER TABLE vod_catalog DROP COLUMN IF EXISTS xtream_id;
+
+-- vod_catalog.xtream_id: movie stream_id or series_id.
+ALTER TABLE vod_catalog
+    ADD COLUMN IF NOT EXISTS xtream_id BIGINT GENERATED BY DEFAULT AS IDENTITY UNIQUE;
+
+-- vod_episodes.xtream_id: episode id used in /series/ stream URLs.
+ALTER TABLE vod_episodes
+    ADD COLUMN IF NOT EXISTS xtream_id BIGINT GENERATED BY DEFAULT AS IDENTITY UNIQUE;
@@ -0,0 +1,23 @@
+-- 084_watch_party_sync.sql — Shared playback state and presence for watch parties.
+-- The watch party room service (services/watchparty) keeps participants in
+-- sync over WebSocket from owl_api and billing. The host's last play/pause/
+-- seek is stored on the party so a participant connecting to either service
+-- starts from the same state; last_seen_at tracks who is connected.
+--
+-- Rollback:
+-- ALTER TABLE watch_party_participants DROP COLUMN IF EXISTS last_seen_at;
+-- ALTER TABLE watch_parties DROP COLUMN IF EXISTS playback_updated_at;
+-- ALTER TABLE watch_parties DROP COLUMN IF EXISTS playback_position;
+-- ALTER TABLE watch_parties DROP COLUMN IF EXISTS playback_playing;
+
+-- playback_position: seconds into the content at playback_updated_at
+-- (NULL = never changed; started_at is used).
+ALTER TABLE watch_parties
+    ADD COLUMN IF NOT EXISTS playback_playing BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN IF NOT EXISTS playback_position DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS playback_updated_at TIMESTAMPTZ;
+
+-- last_seen_at: refreshed while the participant's room connection is open;
+-- NULL once it closes.
+ALTER TABLE watch_party_participants
+    ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
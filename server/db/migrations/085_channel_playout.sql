@@ -0,0 +1,18 @@
+-- 085_channel_playout.sql — Linear playout settings for family channel playlists.
+-- The channel service (services/channel) stitches a playlist into one live
+-- HLS stream anchored to the wall clock at updated_at. Bumpers play before
+-- each item; filler pads each item out to the next slot boundary and covers
+-- items whose media can't be played.
+--
+-- Rollback:
+-- ALTER TABLE channel_playlists DROP COLUMN IF EXISTS slot_minutes;
+-- ALTER TABLE channel_playlists DROP COLUMN IF EXISTS filler;
+-- ALTER TABLE channel_playlists DROP COLUMN IF EXISTS bumpers;
+
+-- bumpers, filler: PlaylistItem arrays, same shape as items.
+-- slot_minutes: programs start on multiples of this many minutes (0 = back to back).
+ALTER TABLE channel_playlists
+    ADD COLUMN IF NOT EXISTS bumpers JSONB NOT NULL DEFAULT '[]',
+    ADD COLUMN IF NOT EXISTS filler JSONB NOT NULL DEFAULT '[]',
+    ADD COLUMN IF NOT EXISTS slot_minutes INTEGER NOT NULL DEFAULT 0
+        CHECK (slot_minutes >= 0 AND slot_minutes <= 1440);
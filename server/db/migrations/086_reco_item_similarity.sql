@@ -0,0 +1,29 @@
+-- 086_reco_item_similarity.sql — Item-to-item similarity for recommendations.
+-- The recommendations service rebuilds this table in-process from household
+-- viewing (watch_progress and stream_sessions). Items are typed references:
+-- movie / series (vod_catalog.id), music (music album id) and channel
+-- (channels.id); episodes are rolled up to their series.
+-- watch_progress also accepts music so album listening can take part.
+--
+-- Rollback:
+-- DROP TABLE IF EXISTS reco_item_similarity;
+-- ALTER TABLE watch_progress DROP CONSTRAINT IF EXISTS watch_progress_content_type_check;
+-- ALTER TABLE watch_progress ADD CONSTRAINT watch_progress_content_type_check
+--     CHECK (content_type IN ('movie','episode'));
+
+CREATE TABLE IF NOT EXISTS reco_item_similarity (
+    item_type    TEXT             NOT NULL,
+    item_id      TEXT             NOT NULL,
+    similar_type TEXT             NOT NULL,
+    similar_id   TEXT             NOT NULL,
+    score        DOUBLE PRECISION NOT NULL,   -- shrunk cosine similarity, 0..1
+    updated_at   TIMESTAMPTZ      NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (item_type, item_id, similar_type, similar_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reco_item_similarity_rank
+    ON reco_item_similarity (item_type, item_id, score DESC);
+
+ALTER TABLE watch_progress DROP CONSTRAINT IF EXISTS watch_progress_content_type_check;
+ALTER TABLE watch_progress ADD CONSTRAINT watch_progress_content_type_check
+    CHECK (content_type IN ('movie','episode','music'));
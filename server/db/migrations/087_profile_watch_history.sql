@@ -0,0 +1,37 @@
+-- 087_profile_watch_history.sql — Per-profile watch history and favourites.
+-- watch_progress has been keyed by profile since 019, but the VOD service,
+-- owl_api and recommendations still read it by subscriber. They now scope
+-- progress, Continue Watching, recommendations and favourite teams to the
+-- session's profile. This migration adds the per-profile recency index and
+-- moves favourite teams saved account-wide (profile_id NULL) to the primary
+-- profile.
+--
+-- Rollback:
+-- DROP INDEX IF EXISTS idx_watch_progress_profile_recent;
+-- (favourite teams stay assigned to the primary profile)
+
+-- Continue Watching is read per profile, newest first.
+CREATE INDEX IF NOT EXISTS idx_watch_progress_profile_recent
+    ON watch_progress (profile_id, last_watched_at DESC);
+
+-- Favourite teams saved account-wide move to the primary profile. Where the
+-- primary profile already follows the team, the account-wide row is a
+-- duplicate and is deleted instead.
+DELETE FROM subscriber_sports_preferences ssp
+USING subscriber_profiles sp
+WHERE sp.subscriber_id = ssp.subscriber_id
+  AND sp.is_primary = TRUE
+  AND ssp.profile_id IS NULL
+  AND EXISTS (
+      SELECT 1 FROM subscriber_sports_preferences d
+      WHERE d.subscriber_id = ssp.subscriber_id
+        AND d.profile_id = sp.id
+        AND d.team_id = ssp.team_id
+  );
+
+UPDATE subscriber_sports_preferences ssp
+SET profile_id = sp.id
+FROM subscriber_profiles sp
+WHERE sp.subscriber_id = ssp.subscriber_id
+  AND sp.is_primary = TRUE
+  AND ssp.profile_id IS NULL;
@@ -0,0 +1,49 @@
+-- 088_scrobble.sql — Trakt / Simkl links and per-profile watchlists.
+-- scrobble_links holds one row per profile and provider: the pending
+-- device-code link while the viewer approves it, then the OAuth tokens
+-- (AES-256-GCM encrypted with SCROBBLE_TOKEN_KEY) and sync watermarks.
+-- profile_watchlist is the Roost side of the watchlist the scrobble service
+-- syncs with each linked provider.
+--
+-- Rollback:
+-- DROP TABLE IF EXISTS profile_watchlist;
+-- DROP TABLE IF EXISTS scrobble_links;
+-- DROP INDEX IF EXISTS idx_vod_catalog_tmdb;
+
+CREATE TABLE IF NOT EXISTS scrobble_links (
+    id                  UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    subscriber_id       UUID        NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE,
+    profile_id          UUID        NOT NULL REFERENCES subscriber_profiles(id) ON DELETE CASCADE,
+    provider            VARCHAR(20) NOT NULL CHECK (provider IN ('trakt', 'simkl')),
+    status              VARCHAR(20) NOT NULL DEFAULT 'pending'
+                        CHECK (status IN ('pending', 'linked', 'expired', 'error')),
+    device_code         TEXT,                 -- cleared once linked
+    user_code           VARCHAR(32),
+    verification_url    TEXT,
+    device_expires_at   TIMESTAMPTZ,
+    poll_interval_secs  INTEGER     NOT NULL DEFAULT 5,
+    access_token_enc    TEXT,                 -- base64(nonce || ciphertext)
+    refresh_token_enc   TEXT,
+    token_expires_at    TIMESTAMPTZ,          -- NULL = does not expire (Simkl)
+    history_synced_at   TIMESTAMPTZ,
+    watchlist_synced_at TIMESTAMPTZ,
+    last_error          TEXT,
+    linked_at           TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (profile_id, provider)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scrobble_links_status ON scrobble_links(status);
+
+CREATE TABLE IF NOT EXISTS profile_watchlist (
+    profile_id UUID        NOT NULL REFERENCES subscriber_profiles(id) ON DELETE CASCADE,
+    catalog_id UUID        NOT NULL REFERENCES vod_catalog(id) ON DELETE CASCADE,
+    added_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (profile_id, catalog_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_profile_watchlist_recent ON profile_watchlist(profile_id, added_at DESC);
+
+-- Provider history and watchlists are matched to the catalog by TMDB id.
+CREATE INDEX IF NOT EXISTS idx_vod_catalog_tmdb ON vod_catalog((metadata->>'tmdb_id'));
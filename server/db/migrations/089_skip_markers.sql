@@ -0,0 +1,31 @@
+-- 089_skip_markers.sql — Intro, credits and recap markers for series episodes.
+-- skip_markers holds one range per content id and kind, detected by the skip
+-- service's marker analyzer from audio fingerprints shared across a season
+-- (source 'fingerprint') or set by an admin (source 'manual'; never
+-- overwritten by detection). Content ids use the skip service format:
+-- roost:episode:<vod_episodes.id>, roost:recording:<dvr_recordings.id>.
+-- skip_marker_scans records which episodes the analyzer has processed.
+--
+-- Rollback:
+-- DROP TABLE IF EXISTS skip_marker_scans;
+-- DROP TABLE IF EXISTS skip_markers;
+
+CREATE TABLE IF NOT EXISTS skip_markers (
+    id             UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    content_id     TEXT        NOT NULL,
+    kind           VARCHAR(10) NOT NULL CHECK (kind IN ('intro', 'credits', 'recap')),
+    start_seconds  REAL        NOT NULL CHECK (start_seconds >= 0),
+    end_seconds    REAL        NOT NULL,
+    confidence     REAL        NOT NULL DEFAULT 1,   -- share of the range that matched
+    source         VARCHAR(20) NOT NULL DEFAULT 'fingerprint'
+                   CHECK (source IN ('fingerprint', 'manual')),
+    detected_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (end_seconds > start_seconds),
+    UNIQUE (content_id, kind)
+);
+
+CREATE TABLE IF NOT EXISTS skip_marker_scans (
+    content_id  TEXT        PRIMARY KEY,
+    scanned_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    error       TEXT                     -- fingerprint failure; retried after a day
+);
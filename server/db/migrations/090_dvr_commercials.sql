@@ -0,0 +1,31 @@
+-- 090_dvr_commercials.sql — Commercial breaks detected in finished DVR recordings.
+-- The DVR service's commercial processor scans each complete recording once
+-- (commercials_scanned_at; commercials_error holds a failed scan's reason)
+-- and stores its breaks in dvr_commercial_markers, served from
+-- GET /dvr/recordings/:id/markers. Clear commercials_scanned_at to rescan.
+--
+-- Rollback:
+-- DROP TABLE IF EXISTS dvr_commercial_markers;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS commercials_error;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS commercials_scanned_at;
+
+ALTER TABLE dvr_recordings
+    ADD COLUMN IF NOT EXISTS commercials_scanned_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS commercials_error      TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_dvr_recordings_commercials_pending
+    ON dvr_recordings (end_time)
+    WHERE status = 'complete' AND commercials_scanned_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS dvr_commercial_markers (
+    id             UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    recording_id   UUID        NOT NULL REFERENCES dvr_recordings(id) ON DELETE CASCADE,
+    start_seconds  REAL        NOT NULL CHECK (start_seconds >= 0),
+    end_seconds    REAL        NOT NULL,
+    confidence     REAL        NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (end_seconds > start_seconds)
+);
+
+CREATE INDEX IF NOT EXISTS idx_dvr_commercial_markers_recording
+    ON dvr_commercial_markers (recording_id, start_seconds);
@@ -0,0 +1,12 @@
+-- 091_channel_always_on.sql — Per-channel override for on-demand ingest.
+-- With INGEST_MODE=on_demand the ingest service only runs a channel's FFmpeg
+-- pipeline while viewers are watching. always_on keeps a channel running
+-- regardless (e.g. channels feeding a downstream consumer that reads
+-- segments directly). Channels with catchup enabled, or with a DVR recording
+-- running or about to start, are kept running without the flag.
+--
+-- Rollback:
+-- ALTER TABLE channels DROP COLUMN IF EXISTS always_on;
+
+ALTER TABLE channels
+    ADD COLUMN IF NOT EXISTS always_on BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,28 @@
+-- 092_channel_ingest_sources.sql — Backup upstreams for live channel ingest.
+-- channels.source_url stays the channel's primary source; rows here add
+-- failover sources. The catalog registers the sources of the channels ingest
+-- is running with the arbitrage engine, whose probe scores land in
+-- source_quality_log; the ingest service ranks a channel's sources by the
+-- latest score (then priority) and fails over down that list when a source
+-- stalls or keeps failing.
+--
+-- (channel_sources is already taken by Roost Boost's canonical-channel
+-- contributions, see 053_roost_boost.sql.)
+--
+-- Rollback:
+-- DROP TABLE IF EXISTS channel_ingest_sources;
+
+CREATE TABLE IF NOT EXISTS channel_ingest_sources (
+    id          UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    channel_id  UUID        NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    url         TEXT        NOT NULL,
+    label       TEXT        NOT NULL DEFAULT '',
+    priority    INT         NOT NULL DEFAULT 0,  -- lower = preferred when scores tie
+    is_enabled  BOOLEAN     NOT NULL DEFAULT TRUE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (channel_id, url)
+);
+
+CREATE INDEX IF NOT EXISTS idx_channel_ingest_sources_channel
+    ON channel_ingest_sources (channel_id, priority)
+    WHERE is_enabled;
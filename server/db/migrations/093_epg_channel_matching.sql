@@ -0,0 +1,42 @@
+-- 093_epg_channel_matching.sql — Fuzzy XMLTV-to-channel mapping.
+-- Channels imported from IPTV providers rarely carry an epg_channel_id, so
+-- each EPG sync scores the feed's channels against every unmapped channel's
+-- name, tvg_id, callsign and channel_number. Matches at or above the
+-- auto-apply threshold set epg_channel_id directly and are recorded in
+-- epg_channel_suggestions as accepted (reviewed_at NULL); weaker ones are
+-- stored as pending for an admin to accept or reject
+-- (GET /admin/epg/suggestions on the EPG service). A rejected pair is never
+-- matched again, and rejecting an accepted one unmaps the channel.
+--
+-- tvg_id and channel_number are filled in by the ingest provider sync;
+-- callsign is set by admins (catalog PUT /admin/channels/:id).
+--
+-- Rollback:
+-- DROP TABLE IF EXISTS epg_channel_suggestions;
+-- ALTER TABLE channels DROP COLUMN IF EXISTS channel_number;
+-- ALTER TABLE channels DROP COLUMN IF EXISTS callsign;
+-- ALTER TABLE channels DROP COLUMN IF EXISTS tvg_id;
+
+ALTER TABLE channels
+    ADD COLUMN IF NOT EXISTS tvg_id         TEXT,
+    ADD COLUMN IF NOT EXISTS callsign       TEXT,
+    ADD COLUMN IF NOT EXISTS channel_number TEXT;
+
+CREATE TABLE IF NOT EXISTS epg_channel_suggestions (
+    id                 UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    channel_id         UUID        NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    source_id          UUID        NOT NULL REFERENCES epg_sources(id) ON DELETE CASCADE,
+    xmltv_channel_id   TEXT        NOT NULL,
+    xmltv_display_name TEXT        NOT NULL DEFAULT '',
+    score              REAL        NOT NULL,
+    matched_on         TEXT        NOT NULL,              -- 'name','tvg_id','callsign','number'
+    status             TEXT        NOT NULL DEFAULT 'pending'
+                       CHECK (status IN ('pending','accepted','rejected')),
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    reviewed_at        TIMESTAMPTZ,
+    UNIQUE (channel_id, source_id, xmltv_channel_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_epg_channel_suggestions_pending
+    ON epg_channel_suggestions (score DESC)
+    WHERE status = 'pending';
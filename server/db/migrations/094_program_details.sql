@@ -0,0 +1,56 @@
+-- 094_program_details.sql — Episode, credit and airing details from XMLTV.
+-- The EPG service now keeps the <programme> fields it used to discard:
+-- sub-title, every category, episode-num (season/episode from xmltv_ns or
+-- onscreen, the onscreen text, dd_progid), credits, date, star-rating and
+-- the previously-shown / new / premiere flags, for DVR new-episode
+-- detection, series grouping and the guide UI.
+--
+-- The columns go on programs, the table the EPG sync writes and every guide
+-- reads. 102 creates that table, with these columns, so on a fresh database
+-- the ALTER TABLE IF EXISTS here is a no-op. epg_programs is no longer read
+-- (see 102); its ALTER stays so re-runs match what earlier deploys applied.
+--
+-- season_number / episode_number are 1-based. credits is a JSON array of
+-- {"role", "name", "character"} objects in feed order. air_date is the
+-- XMLTV <date> as "YYYY", "YYYY-MM" or "YYYY-MM-DD".
+--
+-- Rollback:
+-- ALTER TABLE epg_programs DROP COLUMN IF EXISTS sub_title, DROP COLUMN IF EXISTS categories,
+--     DROP COLUMN IF EXISTS season_number, DROP COLUMN IF EXISTS episode_number,
+--     DROP COLUMN IF EXISTS episode_onscreen, DROP COLUMN IF EXISTS dd_progid,
+--     DROP COLUMN IF EXISTS credits, DROP COLUMN IF EXISTS air_date,
+--     DROP COLUMN IF EXISTS previously_shown, DROP COLUMN IF EXISTS is_premiere,
+--     DROP COLUMN IF EXISTS star_rating;
+-- ALTER TABLE programs DROP COLUMN IF EXISTS sub_title, DROP COLUMN IF EXISTS categories,
+--     DROP COLUMN IF EXISTS season_number, DROP COLUMN IF EXISTS episode_number,
+--     DROP COLUMN IF EXISTS episode_onscreen, DROP COLUMN IF EXISTS dd_progid,
+--     DROP COLUMN IF EXISTS credits, DROP COLUMN IF EXISTS air_date,
+--     DROP COLUMN IF EXISTS previously_shown, DROP COLUMN IF EXISTS is_new,
+--     DROP COLUMN IF EXISTS is_premiere, DROP COLUMN IF EXISTS star_rating;
+
+ALTER TABLE IF EXISTS programs
+    ADD COLUMN IF NOT EXISTS sub_title        TEXT,
+    ADD COLUMN IF NOT EXISTS categories       TEXT[]  NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS season_number    INT,
+    ADD COLUMN IF NOT EXISTS episode_number   INT,
+    ADD COLUMN IF NOT EXISTS episode_onscreen TEXT,
+    ADD COLUMN IF NOT EXISTS dd_progid        TEXT,
+    ADD COLUMN IF NOT EXISTS credits          JSONB   NOT NULL DEFAULT '[]',
+    ADD COLUMN IF NOT EXISTS air_date         TEXT,
+    ADD COLUMN IF NOT EXISTS previously_shown BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS is_new           BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS is_premiere      BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS star_rating      TEXT;
+
+ALTER TABLE epg_programs
+    ADD COLUMN IF NOT EXISTS sub_title        TEXT,
+    ADD COLUMN IF NOT EXISTS categories       TEXT[]  NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS season_number    INT,
+    ADD COLUMN IF NOT EXISTS episode_number   INT,
+    ADD COLUMN IF NOT EXISTS episode_onscreen TEXT,
+    ADD COLUMN IF NOT EXISTS dd_progid        TEXT,
+    ADD COLUMN IF NOT EXISTS credits          JSONB   NOT NULL DEFAULT '[]',
+    ADD COLUMN IF NOT EXISTS air_date         TEXT,
+    ADD COLUMN IF NOT EXISTS previously_shown BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS is_premiere      BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS star_rating      TEXT;
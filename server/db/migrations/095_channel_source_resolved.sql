@@ -0,0 +1,11 @@
+-- 095_channel_source_resolved.sql — Record when a portal channel first plays.
+-- Stalker channels are synced with an empty source_url: create_link mints a
+-- single-use URL per play, so the ingest pipeline asks the portal for one on
+-- every start and restart. ingest sets source_resolved_at the first time
+-- create_link returns a URL (see 098 for how pending channels are handled).
+--
+-- Rollback:
+-- ALTER TABLE channels DROP COLUMN IF EXISTS source_resolved_at;
+
+ALTER TABLE channels
+    ADD COLUMN IF NOT EXISTS source_resolved_at TIMESTAMPTZ;
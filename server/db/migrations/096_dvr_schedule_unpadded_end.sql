@@ -0,0 +1,27 @@
+-- 096_dvr_schedule_unpadded_end.sql — dvr_schedule.end_time is the programme end.
+-- POST /admin/dvr/schedule and the series engine used to store end_time with
+-- padding_after_secs already added. dvr_recordings.end_time has always been
+-- the programme's end, with the DVR scheduler adding padding_after_secs when
+-- it stops capture (scheduler.CaptureEnd); dvr_schedule now means the same,
+-- and the tuner allocator holds a tuner until end_time + padding_after_secs.
+--
+-- Every deploy re-runs every migration, so existing rows are converted once
+-- only: the column comment records that the conversion has been done.
+--
+-- Rollback:
+-- UPDATE dvr_schedule SET end_time = end_time + make_interval(secs => padding_after_secs)
+--  WHERE padding_after_secs > 0;
+-- COMMENT ON COLUMN dvr_schedule.end_time IS NULL;
+
+DO $$
+BEGIN
+    IF col_description('dvr_schedule'::regclass,
+                       (SELECT attnum FROM pg_attribute
+                         WHERE attrelid = 'dvr_schedule'::regclass AND attname = 'end_time'))
+       IS DISTINCT FROM 'programme end; padding_after_secs is not included' THEN
+        UPDATE dvr_schedule
+           SET end_time = end_time - make_interval(secs => padding_after_secs)
+         WHERE padding_after_secs > 0;
+        COMMENT ON COLUMN dvr_schedule.end_time IS 'programme end; padding_after_secs is not included';
+    END IF;
+END $$;
@@ -0,0 +1,34 @@
+-- 097_dvr_recordings_series.sql — Series rules book dvr_recordings.
+-- The series engine used to insert dvr_schedule rows, which no recorder reads.
+-- It now books dvr_recordings rows (captured by the DVR scheduler) owned by
+-- the subscriber whose SSO account created the rule, on the rule's
+-- guide_channel_id. Rules with a NULL guide_channel_id are still skipped.
+--
+-- episode_key: guide numbering (dd_progid, or title + season/episode), else
+-- normalised title + description, used by new-episodes-only rules to skip
+-- repeat airings of an episode that is already scheduled, recorded or deleted.
+--
+-- Upcoming dvr_schedule rows the engine created are cancelled; the next match
+-- pass books the same airings in dvr_recordings.
+--
+-- Rollback:
+-- DROP INDEX IF EXISTS idx_dvr_recordings_series_program;
+-- DROP INDEX IF EXISTS idx_dvr_recordings_series;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS episode_key;
+-- ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS series_id;
+
+ALTER TABLE dvr_recordings
+    ADD COLUMN IF NOT EXISTS series_id UUID REFERENCES dvr_series(id) ON DELETE SET NULL;
+ALTER TABLE dvr_recordings
+    ADD COLUMN IF NOT EXISTS episode_key TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_dvr_recordings_series
+    ON dvr_recordings(series_id, start_time)
+    WHERE series_id IS NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dvr_recordings_series_program
+    ON dvr_recordings(series_id, program_id)
+    WHERE series_id IS NOT NULL AND program_id IS NOT NULL;
+
+UPDATE dvr_schedule SET status = 'cancelled'
+ WHERE series_id IS NOT NULL AND status = 'scheduled';
@@ -0,0 +1,26 @@
+-- 098_stalker_lazy_resolve.sql — Resolve Stalker channels on demand; seal MACs.
+-- Stalker channels used to be synced inactive and activated once ingest had
+-- called create_link for them, pending channel by pending channel. Calling a
+-- portal for every channel of a lineup gets MACs banned, so the provider sync
+-- now inserts them active and ingest runs them only on viewer demand,
+-- resolving a link per play and backing off a channel whose link fails.
+-- Channels still waiting for that activation are activated here. Channels
+-- that resolved before and are inactive were switched off by an admin and
+-- stay off.
+--
+-- Portal MACs are now stored sealed in config.mac_enc (AES-256-GCM keyed by
+-- ingest's INGEST_PROVIDER_KEY). Ingest seals any plaintext config.mac on
+-- startup; that needs the key, so it is not done here.
+--
+-- Rollback:
+-- (no-op: the activated channels cannot be told apart from synced ones)
+
+UPDATE channels c
+SET is_active = true, updated_at = now()
+FROM ingest_providers p
+WHERE p.id = c.provider_id
+  AND p.provider_type = 'stalker'
+  AND c.source_url = ''
+  AND c.is_active = false
+  AND c.source_resolved_at IS NULL
+  AND c.source_removed = false;
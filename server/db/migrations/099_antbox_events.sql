@@ -0,0 +1,37 @@
+-- 099_antbox_events.sql — Scheduled AntBox captures.
+-- An admin schedules an event (a broadcast channel on one of the roost's
+-- AntBoxes for a time window). owl_api sends the device START_EVENT when the
+-- window opens and STOP_EVENT when it ends; the device uploads HLS segments
+-- to /antbox/events/{id}/segments/... and marks the event complete.
+--
+-- status: scheduled → capturing → stopping → complete, or failed (never
+-- started before end_time; error holds the last reason) / cancelled.
+-- device_ip and tuner_index: the HDHomeRun tuner to use; NULL lets owl_api
+-- pick a free one from the device's last heartbeat when the event starts.
+-- channel: the HDHomeRun virtual channel number (e.g. "7.1").
+--
+-- Rollback:
+-- DROP TABLE IF EXISTS antbox_events;
+
+CREATE TABLE IF NOT EXISTS antbox_events (
+    id            UUID        NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    roost_id      UUID        NOT NULL,
+    antbox_id     UUID        NOT NULL REFERENCES antboxes(id) ON DELETE CASCADE,
+    title         TEXT        NOT NULL,
+    channel       TEXT        NOT NULL,
+    device_ip     TEXT,
+    tuner_index   INTEGER     CHECK (tuner_index IS NULL OR tuner_index >= 0),
+    start_time    TIMESTAMPTZ NOT NULL,
+    end_time      TIMESTAMPTZ NOT NULL CHECK (end_time > start_time),
+    status        TEXT        NOT NULL DEFAULT 'scheduled'
+        CHECK (status IN ('scheduled', 'capturing', 'stopping', 'complete', 'failed', 'cancelled')),
+    error         TEXT,
+    created_by    TEXT,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at    TIMESTAMPTZ,
+    completed_at  TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_antbox_events_antbox
+    ON antbox_events(antbox_id, start_time DESC);
+CREATE INDEX IF NOT EXISTS idx_antbox_events_due
+    ON antbox_events(status, start_time) WHERE status IN ('scheduled', 'capturing');
@@ -0,0 +1,17 @@
+-- 100_tuner_allocation_scope.sql — Drop the unused parts of 079.
+-- The tuner allocator now only reports capacity and conflicts for the admin
+-- DVR schedule and AntBox captures, and admits new ones; it stores nothing.
+-- No code reads dvr_schedule.allocated_source_*, and no client ever opened a
+-- roost_live_sessions row, so both go. roost_source_channels stays: the IPTV
+-- refresh still writes it and recordings fall back on it.
+--
+-- Rollback:
+-- ALTER TABLE dvr_schedule ADD COLUMN IF NOT EXISTS allocated_source_kind TEXT
+--     CHECK (allocated_source_kind IS NULL OR allocated_source_kind IN ('iptv', 'antbox'));
+-- ALTER TABLE dvr_schedule ADD COLUMN IF NOT EXISTS allocated_source_id UUID;
+-- (roost_live_sessions: re-run its CREATE TABLE / CREATE INDEX from 079)
+
+ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS allocated_source_kind;
+ALTER TABLE dvr_schedule DROP COLUMN IF EXISTS allocated_source_id;
+
+DROP TABLE IF EXISTS roost_live_sessions;
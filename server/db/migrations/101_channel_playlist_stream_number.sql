@@ -0,0 +1,12 @@
+-- 101_channel_playlist_stream_number.sql — Stable numbers for playout channels.
+-- owl_api lists family playout channels in the Xtream and HDHomeRun lineups,
+-- whose clients key channels by integer and cache them. stream_number is that
+-- integer; owl_api adds playoutNumberBase so it never meets a
+-- channels.sort_order. Existing playlists are numbered when the column is
+-- added.
+--
+-- Rollback:
+-- ALTER TABLE channel_playlists DROP COLUMN IF EXISTS stream_number;
+
+ALTER TABLE channel_playlists
+    ADD COLUMN IF NOT EXISTS stream_number INTEGER GENERATED BY DEFAULT AS IDENTITY UNIQUE;
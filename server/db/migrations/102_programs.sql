@@ -0,0 +1,45 @@
+-- 102_programs.sql — The EPG programme table.
+-- The EPG sync has always written programs, and the EPG service, DVR
+-- (dvr_recordings.program_id, dvr_schedule.program_id, the series engine)
+-- and scrobble read it, but no migration created it. owl_api's /owl/epg,
+-- /xmltv.php, Xtream EPG, catch-up listing and parental checks now read it
+-- too, instead of epg_programs, which nothing writes.
+--
+-- 094 adds its columns with ALTER TABLE IF EXISTS programs, which is a no-op
+-- on a fresh database; they are part of the table here.
+--
+-- Rollback:
+-- DROP TABLE IF EXISTS programs;
+
+CREATE TABLE IF NOT EXISTS programs (
+    id                UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    channel_id        UUID        NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    epg_source_id     UUID        REFERENCES epg_sources(id) ON DELETE SET NULL,
+    source_program_id TEXT        NOT NULL,  -- XMLTV channel id | start time
+    title             TEXT        NOT NULL,
+    description       TEXT,
+    start_time        TIMESTAMPTZ NOT NULL,
+    end_time          TIMESTAMPTZ NOT NULL,
+    genre             TEXT,                  -- first <category>
+    rating            TEXT,
+    icon_url          TEXT,
+    sub_title         TEXT,
+    categories        TEXT[]      NOT NULL DEFAULT '{}',
+    season_number     INT,
+    episode_number    INT,
+    episode_onscreen  TEXT,
+    dd_progid         TEXT,
+    credits           JSONB       NOT NULL DEFAULT '[]',
+    air_date          TEXT,
+    previously_shown  BOOLEAN     NOT NULL DEFAULT FALSE,
+    is_new            BOOLEAN     NOT NULL DEFAULT FALSE,
+    is_premiere       BOOLEAN     NOT NULL DEFAULT FALSE,
+    star_rating       TEXT,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (channel_id, source_program_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_programs_channel_time ON programs (channel_id, start_time, end_time);
+CREATE INDEX IF NOT EXISTS idx_programs_window       ON programs (start_time, end_time);
+CREATE INDEX IF NOT EXISTS idx_programs_end          ON programs (end_time);
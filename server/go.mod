@@ -10,6 +10,7 @@ require (
 	github.com/prometheus/client_golang v1.23.2
 	github.com/sirupsen/logrus v1.9.4
 	github.com/stripe/stripe-go/v76 v76.25.0
+	github.com/unyeco/roost/pkg/wsconn v0.0.0
 	golang.org/x/crypto v0.48.0
 )
 
@@ -31,3 +32,5 @@ require (
 	golang.org/x/text v0.34.0 // indirect
 	google.golang.org/protobuf v1.36.8 // indirect
 )
+
+replace github.com/unyeco/roost/pkg/wsconn => ./pkg/wsconn
@@ -0,0 +1,3 @@
+module github.com/unyeco/roost/pkg/wsconn
+
+go 1.21
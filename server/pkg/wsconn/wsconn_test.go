@@ -0,0 +1,160 @@
+// wsconn_test.go — Handshake and framing tests against a raw TCP client,
+// and a Dial round trip.
+package wsconn
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// dialRaw performs a client handshake by hand and returns the socket.
+func dialRaw(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	const key = "dGhlIHNhbXBsZSBub25jZQ=="
+	_, _ = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", got)
+	}
+	return conn, br
+}
+
+// maskedFrame builds a masked client frame.
+func maskedFrame(fin bool, opcode byte, payload []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	out := []byte{b0, 0x80 | byte(len(payload))}
+	out = append(out, mask...)
+	for i, c := range payload {
+		out = append(out, c^mask[i%4])
+	}
+	return out
+}
+
+// TestEchoFragmentedAndPing verifies reassembly, ping answers and unmasked server writes.
+func TestEchoFragmentedAndPing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		msg, err := c.ReadMessage()
+		if err != nil {
+			t.Errorf("ReadMessage: %v", err)
+			return
+		}
+		_ = c.WriteText(msg)
+	}))
+	defer srv.Close()
+
+	conn, br := dialRaw(t, srv)
+	_, _ = conn.Write(maskedFrame(false, opText, []byte("hel")))
+	_, _ = conn.Write(maskedFrame(true, opPing, []byte("p")))
+	_, _ = conn.Write(maskedFrame(true, opContinuation, []byte("lo")))
+
+	read := func() (byte, string) {
+		var hdr [2]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			t.Fatal(err)
+		}
+		if hdr[1]&0x80 != 0 {
+			t.Fatal("server frame must not be masked")
+		}
+		payload := make([]byte, hdr[1]&0x7F)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			t.Fatal(err)
+		}
+		return hdr[0] & 0x0F, string(payload)
+	}
+	if op, p := read(); op != opPong || p != "p" {
+		t.Fatalf("expected pong 'p', got op=%x %q", op, p)
+	}
+	if op, p := read(); op != opText || p != "hello" {
+		t.Fatalf("expected text 'hello', got op=%x %q", op, p)
+	}
+}
+
+// TestUpgradeRejectsPlainRequest verifies non-upgrade requests get 426.
+func TestUpgradeRejectsPlainRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = Upgrade(w, r)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("expected 426, got %d", resp.StatusCode)
+	}
+}
+
+// TestDialRoundTrip verifies a Dial client against Upgrade: headers reach the
+// server, client frames are masked and server frames are read unmasked.
+func TestDialRoundTrip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-AntBox-ID") != "box-1" {
+			http.Error(w, "missing id", http.StatusUnauthorized)
+			return
+		}
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_ = c.Ping()
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = c.WriteText(append([]byte("echo:"), msg...))
+		}
+	}))
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	if _, err := Dial(context.Background(), wsURL, nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Dial without id: want HTTP 401, got %v", err)
+	}
+	c, err := Dial(context.Background(), wsURL, http.Header{"X-Antbox-Id": {"box-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WriteText([]byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "echo:hi" {
+		t.Errorf("got %q, want echo:hi", msg)
+	}
+}
@@ -5,16 +5,22 @@
 //
 // Quality score formula:
 //   score = (bitrate_kbps / 1000) - (latency_ms / 1000)
-//   Minimum score: 0 (clamped).
+//   Minimum score: 0 (clamped). A failed probe is logged with score 0.
+//
+// source_quality_log keeps logRetention of probes; older rows are pruned
+// every hour.
 //
 // Admin route:
 //   GET /v1/admin/channels/{id}/source-quality — recent quality log for a channel
 package arbitrage
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"sync"
 	"time"
@@ -22,6 +28,9 @@ import (
 	"github.com/google/uuid"
 )
 
+// logRetention is how long probe results stay in source_quality_log.
+const logRetention = 24 * time.Hour
+
 // SourceHealth tracks real-time quality metrics for one channel source.
 type SourceHealth struct {
 	SourceID    string
@@ -109,20 +118,36 @@ func (a *Arbitrage) GetBestSource(channelID string) *SourceHealth {
 	return best
 }
 
-// probeLoop runs every 10 seconds and probes all registered sources in parallel.
+// probeLoop runs every 10 seconds and probes all registered sources in
+// parallel, pruning source_quality_log every hour.
 func (a *Arbitrage) probeLoop() {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
 	for {
 		select {
 		case <-ticker.C:
 			a.probeAll()
+		case <-prune.C:
+			a.pruneLog()
 		case <-a.quit:
 			return
 		}
 	}
 }
 
+// pruneLog deletes probe results older than logRetention.
+func (a *Arbitrage) pruneLog() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := a.db.ExecContext(ctx,
+		`DELETE FROM source_quality_log WHERE measured_at < $1`,
+		time.Now().Add(-logRetention)); err != nil {
+		log.Printf("[arbitrage] prune source_quality_log: %v", err)
+	}
+}
+
 // probeAll takes a snapshot of the source map and probes each source concurrently.
 func (a *Arbitrage) probeAll() {
 	a.mu.RLock()
@@ -147,11 +172,19 @@ func (a *Arbitrage) probeSource(channelID string, src *SourceHealth) {
 	start := time.Now()
 	client := &http.Client{Timeout: 5 * time.Second}
 	resp, err := client.Get(src.SourceURL)
+	if err == nil && resp.StatusCode >= 400 {
+		resp.Body.Close()
+		err = fmt.Errorf("status %d", resp.StatusCode)
+	}
 	if err != nil {
+		// Logged with score 0 so a dead source ranks below every live one.
 		a.mu.Lock()
 		src.Score = 0
+		src.BitrateKbps = 0
 		src.LatencyMs = 5000
+		src.LastProbed = time.Now()
 		a.mu.Unlock()
+		a.logProbe(channelID, src.SourceID, src.SourceURL, 0, 5000, 0)
 		return
 	}
 	defer resp.Body.Close()
@@ -179,14 +212,21 @@ func (a *Arbitrage) probeSource(channelID string, src *SourceHealth) {
 	src.LastProbed = time.Now()
 	a.mu.Unlock()
 
-	// Write to DB (best-effort — never block the probe goroutine on DB errors)
-	_ = a.db.QueryRow(
+	a.logProbe(channelID, src.SourceID, src.SourceURL, bitrateKbps, latencyMs, score)
+}
+
+// logProbe writes a probe result to source_quality_log (best-effort — never
+// block the probe goroutine on DB errors).
+func (a *Arbitrage) logProbe(channelID, sourceID, sourceURL string, bitrateKbps, latencyMs int, score float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = a.db.ExecContext(ctx,
 		`INSERT INTO source_quality_log
 		 (channel_id, source_id, source_url, bitrate_kbps, latency_ms, score)
 		 VALUES ($1, $2, $3, $4, $5, $6)`,
 		channelID,
-		src.SourceID,
-		src.SourceURL,
+		sourceID,
+		sourceURL,
 		bitrateKbps,
 		latencyMs,
 		score,
@@ -115,7 +115,7 @@ type ssoUserInfo struct {
 	UserID      string `json:"user_id"`
 	Email       string `json:"email"`
 	DisplayName string `json:"display_name"`
-	ExternalID string `json:"external_id"`"`
+	ExternalID  string `json:"external_id"`
 }
 
 // ── Handler: GET /auth/sso/login ────────────────────────────────────────────
@@ -3,8 +3,10 @@
 //
 // Watch parties let a group of subscribers watch the same channel in sync.
 // The host creates a party and shares an invite code. Guests join by entering
-// the code. All participants get the same signed stream URL so playback is
-// synchronized.
+// the code and get the same signed stream URL. Playback is kept in sync by the
+// party's room (services/watchparty): clients open GET /watch-party/{id}/ws
+// and receive the host's play/pause/seek/channel events, drift corrections,
+// presence and chat. owl_api serves the same rooms at /owl/v1/watch-party/{id}/ws.
 //
 // Watch party notification: when a party is created, Roost attempts to post an
 // invite notification (stubbed — graceful if provider
@@ -15,6 +17,7 @@
 //   GET    /watch-party/{id}  — get party details + participants
 //   POST   /watch-party/join  — join by invite_code
 //   DELETE /watch-party/{id}  — end party (host only)
+//   GET    /watch-party/{id}/ws — sync room (WebSocket; ?access_token= for browsers)
 package billing
 
 import (
@@ -450,6 +453,7 @@ func (s *Server) handleEndWatchParty(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.parties.End(r.Context(), partyID)
 	log.Printf("[watch_party] ended: party=%s host=%s", partyID, subscriberID)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -579,8 +583,13 @@ func (s *Server) handleWatchParty(w http.ResponseWriter, r *http.Request) {
 	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "POST required")
 }
 
-// handleWatchPartyByID dispatches GET and DELETE /watch-party/{id}.
+// handleWatchPartyByID dispatches GET and DELETE /watch-party/{id} and the
+// GET /watch-party/{id}/ws room connection.
 func (s *Server) handleWatchPartyByID(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(strings.TrimRight(r.URL.Path, "/"), "/ws") {
+		s.handleWatchPartyRoom(w, r)
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		s.handleGetWatchParty(w, r)
@@ -590,3 +599,36 @@ func (s *Server) handleWatchPartyByID(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET or DELETE required")
 	}
 }
+
+// ── Handler: GET /watch-party/{id}/ws ─────────────────────────────────────────
+
+// handleWatchPartyRoom opens the party's sync room over WebSocket. Browsers
+// can't set headers on a WebSocket, so the JWT may also be passed as
+// ?access_token=.
+func (s *Server) handleWatchPartyRoom(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.ValidateJWT(r)
+	if err != nil {
+		if tok := r.URL.Query().Get("access_token"); tok != "" {
+			claims, err = auth.ValidateAccessToken(tok)
+		}
+	}
+	if err != nil {
+		auth.WriteError(w, http.StatusUnauthorized, "unauthorized", "valid JWT required")
+		return
+	}
+	// Path: /watch-party/{id}/ws
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[1] == "" {
+		writeError(w, http.StatusBadRequest, "missing_party_id", "party ID required in path")
+		return
+	}
+	s.parties.ServeWS(w, r, parts[1], claims.Subject)
+}
+
+// nullableStr converts an empty string to nil for nullable DB columns.
+func nullableStr(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
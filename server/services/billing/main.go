@@ -4,6 +4,7 @@
 package billing
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -16,6 +17,7 @@ import (
 	"github.com/unyeco/roost/internal/r2"
 	"github.com/unyeco/roost/internal/ratelimit"
 	stripeclient "github.com/unyeco/roost/internal/stripe"
+	"github.com/unyeco/roost/services/watchparty"
 )
 
 // Server holds all shared dependencies for the billing service.
@@ -25,6 +27,7 @@ type Server struct {
 	limiter *ratelimit.Limiter
 	r2      *r2.Client // may be nil if R2 credentials are not configured
 	port    string
+	parties *watchparty.Hub
 }
 
 // NewServer creates the billing server.
@@ -32,7 +35,8 @@ type Server struct {
 // r2Client may be nil if R2 env vars are not set; avatar upload degrades gracefully.
 func NewServer(db *sql.DB, sc *stripeclient.Client, limiter *ratelimit.Limiter, r2c *r2.Client) *Server {
 	port := getEnv("BILLING_PORT", "8085")
-	return &Server{db: db, stripe: sc, limiter: limiter, r2: r2c, port: port}
+	parties := watchparty.NewHub(db, watchparty.NewPGBroker(db, postgresDSN()))
+	return &Server{db: db, stripe: sc, limiter: limiter, r2: r2c, port: port, parties: parties}
 }
 
 // Run starts the HTTP server with all billing routes registered.
@@ -97,6 +101,7 @@ func StartBillingService() {
 	srv := NewServer(db, sc, limiter, r2c)
 	// Start background goroutines
 	go srv.sportsPregameNotifier()
+	go srv.parties.Run(context.Background())
 	srv.startTrialNotifier()
 	srv.startOnboardingEmailer()
 	srv.startAnalyticsCollector()
@@ -109,9 +114,9 @@ func StartBillingService() {
 	}
 }
 
-// connectDB opens a Postgres connection from env vars.
-func connectDB() (*sql.DB, error) {
-	dsn := fmt.Sprintf(
+// postgresDSN builds the Postgres connection string from env vars.
+func postgresDSN() string {
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		getEnv("POSTGRES_HOST", "localhost"),
 		getEnv("POSTGRES_PORT", "5433"),
@@ -119,7 +124,11 @@ func connectDB() (*sql.DB, error) {
 		getEnv("POSTGRES_PASSWORD", ""),
 		getEnv("POSTGRES_DB", "roost_dev"),
 	)
-	db, err := sql.Open("postgres", dsn)
+}
+
+// connectDB opens a Postgres connection from env vars.
+func connectDB() (*sql.DB, error) {
+	db, err := sql.Open("postgres", postgresDSN())
 	if err != nil {
 		return nil, err
 	}
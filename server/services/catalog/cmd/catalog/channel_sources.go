@@ -0,0 +1,364 @@
+// channel_sources.go — Failover sources for live channels.
+//
+// channels.source_url is a channel's primary upstream; channel_ingest_sources
+// adds backups. The ingest service ranks a channel's sources by their latest
+// arbitrage probe score and fails over down the list when one stalls. Like
+// source_url, a source URL is accepted in admin input but never returned:
+// responses carry only its scheme and host.
+//
+// Admin routes (require superowner JWT):
+//
+//	GET    /admin/channels/:id/sources
+//	POST   /admin/channels/:id/sources
+//	PUT    /admin/channels/:id/sources/:source_id
+//	DELETE /admin/channels/:id/sources/:source_id
+//	GET    /admin/channels/:id/source-quality      — recent probe results
+//
+// Unless CATALOG_ARBITRAGE=false, the catalog runs the arbitrage engine and
+// registers the sources of every channel ingest is running or failing over
+// (GET INGEST_URL/internal/channels/active) with it, the primary under the
+// channel's own ID. The set is refreshed every arbitrageSyncEvery; idle
+// channels are not probed.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/unyeco/roost/services/arbitrage"
+)
+
+type channelSourceResponse struct {
+	ID        string    `json:"id"`
+	Host      string    `json:"host"` // scheme://host only — the URL is never returned
+	Label     string    `json:"label"`
+	Priority  int       `json:"priority"`
+	IsEnabled bool      `json:"is_enabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const channelSourceCols = `id, url, label, priority, is_enabled, created_at`
+
+func scanChannelSource(row interface{ Scan(...interface{}) error }) (*channelSourceResponse, error) {
+	var src channelSourceResponse
+	var rawURL string
+	if err := row.Scan(&src.ID, &rawURL, &src.Label, &src.Priority, &src.IsEnabled, &src.CreatedAt); err != nil {
+		return nil, err
+	}
+	src.Host = sourceHost(rawURL)
+	return &src, nil
+}
+
+// sourceHost reduces a stream URL to scheme://host.
+func sourceHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+}
+
+// routeChannelSources dispatches /admin/channels/:id/sources[/:source_id]
+// and /admin/channels/:id/source-quality.
+func (s *server) routeChannelSources(w http.ResponseWriter, r *http.Request, segments []string) {
+	channelID := segments[2]
+	switch {
+	case segments[3] == "source-quality" && len(segments) == 4:
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
+			return
+		}
+		s.handleChannelSourceQuality(w, r, channelID)
+	case segments[3] == "sources" && len(segments) == 4:
+		switch r.Method {
+		case http.MethodGet:
+			s.handleListChannelSources(w, r, channelID)
+		case http.MethodPost:
+			s.handleCreateChannelSource(w, r, channelID)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET or POST required")
+		}
+	case segments[3] == "sources" && len(segments) == 5:
+		switch r.Method {
+		case http.MethodPut:
+			s.handleUpdateChannelSource(w, r, channelID, segments[4])
+		case http.MethodDelete:
+			s.handleDeleteChannelSource(w, r, channelID, segments[4])
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "PUT or DELETE required")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "Not found")
+	}
+}
+
+// GET /admin/channels/:id/sources
+func (s *server) handleListChannelSources(w http.ResponseWriter, r *http.Request, channelID string) {
+	rows, err := s.db.QueryContext(r.Context(),
+		`SELECT `+channelSourceCols+` FROM channel_ingest_sources
+		 WHERE channel_id=$1 ORDER BY priority, created_at`, channelID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to list channel sources")
+		return
+	}
+	defer rows.Close()
+	sources := []*channelSourceResponse{}
+	for rows.Next() {
+		if src, err := scanChannelSource(rows); err == nil {
+			sources = append(sources, src)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sources": sources})
+}
+
+// POST /admin/channels/:id/sources
+func (s *server) handleCreateChannelSource(w http.ResponseWriter, r *http.Request, channelID string) {
+	var inp struct {
+		URL       string `json:"url"`
+		Label     string `json:"label"`
+		Priority  *int   `json:"priority"`
+		IsEnabled *bool  `json:"is_enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&inp); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	if sourceHost(inp.URL) == "" {
+		writeError(w, http.StatusBadRequest, "invalid_url", "url must be an absolute stream URL")
+		return
+	}
+	priority := 0
+	if inp.Priority != nil {
+		priority = *inp.Priority
+	}
+	enabled := true
+	if inp.IsEnabled != nil {
+		enabled = *inp.IsEnabled
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(r.Context(),
+		`SELECT EXISTS(SELECT 1 FROM channels WHERE id=$1)`, channelID).Scan(&exists); err != nil || !exists {
+		writeError(w, http.StatusNotFound, "not_found", "Channel not found")
+		return
+	}
+
+	row := s.db.QueryRowContext(r.Context(),
+		`INSERT INTO channel_ingest_sources (channel_id, url, label, priority, is_enabled)
+		 VALUES ($1,$2,$3,$4,$5) ON CONFLICT (channel_id, url) DO NOTHING
+		 RETURNING `+channelSourceCols,
+		channelID, inp.URL, inp.Label, priority, enabled)
+	src, err := scanChannelSource(row)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusConflict, "duplicate_source", "Channel already has this source")
+		return
+	}
+	if err != nil {
+		log.Printf("[catalog] create channel source: %v", err)
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to create channel source")
+		return
+	}
+	writeJSON(w, http.StatusCreated, src)
+}
+
+// PUT /admin/channels/:id/sources/:source_id
+func (s *server) handleUpdateChannelSource(w http.ResponseWriter, r *http.Request, channelID, sourceID string) {
+	var inp struct {
+		URL       *string `json:"url"`
+		Label     *string `json:"label"`
+		Priority  *int    `json:"priority"`
+		IsEnabled *bool   `json:"is_enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&inp); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	if inp.URL != nil && sourceHost(*inp.URL) == "" {
+		writeError(w, http.StatusBadRequest, "invalid_url", "url must be an absolute stream URL")
+		return
+	}
+	row := s.db.QueryRowContext(r.Context(),
+		`UPDATE channel_ingest_sources SET
+		     url        = COALESCE($3, url),
+		     label      = COALESCE($4, label),
+		     priority   = COALESCE($5, priority),
+		     is_enabled = COALESCE($6, is_enabled)
+		 WHERE id=$1 AND channel_id=$2
+		 RETURNING `+channelSourceCols,
+		sourceID, channelID, inp.URL, inp.Label, inp.Priority, inp.IsEnabled)
+	src, err := scanChannelSource(row)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "not_found", "Channel source not found")
+		return
+	}
+	if err != nil {
+		log.Printf("[catalog] update channel source: %v", err)
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to update channel source")
+		return
+	}
+	writeJSON(w, http.StatusOK, src)
+}
+
+// DELETE /admin/channels/:id/sources/:source_id
+func (s *server) handleDeleteChannelSource(w http.ResponseWriter, r *http.Request, channelID, sourceID string) {
+	res, err := s.db.ExecContext(r.Context(),
+		`DELETE FROM channel_ingest_sources WHERE id=$1 AND channel_id=$2`, sourceID, channelID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to delete channel source")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "not_found", "Channel source not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// GET /admin/channels/:id/source-quality — the 20 most recent probe results.
+func (s *server) handleChannelSourceQuality(w http.ResponseWriter, r *http.Request, channelID string) {
+	rows, err := s.db.QueryContext(r.Context(),
+		`SELECT source_id, source_url, bitrate_kbps, latency_ms, score, measured_at
+		 FROM source_quality_log
+		 WHERE channel_id = $1
+		 ORDER BY measured_at DESC LIMIT 20`, channelID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to load source quality")
+		return
+	}
+	defer rows.Close()
+
+	type qualityEntry struct {
+		SourceID    string    `json:"source_id"`
+		Host        string    `json:"host"` // scheme://host only
+		BitrateKbps int       `json:"bitrate_kbps"`
+		LatencyMs   int       `json:"latency_ms"`
+		Score       float64   `json:"score"`
+		MeasuredAt  time.Time `json:"measured_at"`
+	}
+	entries := []qualityEntry{}
+	for rows.Next() {
+		var e qualityEntry
+		var rawURL string
+		if err := rows.Scan(&e.SourceID, &rawURL, &e.BitrateKbps, &e.LatencyMs, &e.Score, &e.MeasuredAt); err != nil {
+			continue
+		}
+		e.Host = sourceHost(rawURL)
+		entries = append(entries, e)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"channel_id": channelID, "entries": entries})
+}
+
+// ---- arbitrage registration -------------------------------------------------
+
+// arbitrageSyncEvery is how often the probe pool follows ingest's running
+// channels.
+const arbitrageSyncEvery = 15 * time.Second
+
+// runArbitrageSync keeps the arbitrage engine's probe pool in step with the
+// sources of the channels ingest runs until ctx is cancelled.
+func runArbitrageSync(ctx context.Context, db *sql.DB, arb *arbitrage.Arbitrage, ingestURL string) {
+	registered := make(map[string]map[string]bool) // channelID → sourceIDs
+	ticker := time.NewTicker(arbitrageSyncEvery)
+	defer ticker.Stop()
+	for {
+		if err := syncArbitrageSources(ctx, db, arb, ingestURL, registered); err != nil {
+			log.Printf("[catalog] arbitrage source sync: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ingestActiveChannels returns the IDs of the channels ingest has a
+// pipeline for.
+func ingestActiveChannels(ctx context.Context, ingestURL string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ingestURL+"/internal/channels/active", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ingest active channels: status %d", resp.StatusCode)
+	}
+	var body struct {
+		ChannelIDs []string `json:"channel_ids"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("ingest active channels: %w", err)
+	}
+	active := make(map[string]bool, len(body.ChannelIDs))
+	for _, id := range body.ChannelIDs {
+		active[id] = true
+	}
+	return active, nil
+}
+
+// syncArbitrageSources registers the sources of ingest's running channels
+// and deregisters the rest. When ingest cannot be reached the pool is left
+// as it is.
+func syncArbitrageSources(ctx context.Context, db *sql.DB, arb *arbitrage.Arbitrage, ingestURL string, registered map[string]map[string]bool) error {
+	active, err := ingestActiveChannels(ctx, ingestURL)
+	if err != nil {
+		return err
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.id, c.id, c.source_url FROM channels c
+		WHERE c.is_active = true AND c.source_url <> ''
+		UNION ALL
+		SELECT cis.channel_id, cis.id, cis.url FROM channel_ingest_sources cis
+		JOIN channels c ON c.id = cis.channel_id AND c.is_active = true
+		WHERE cis.is_enabled = true`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	current := make(map[string]map[string]bool)
+	for rows.Next() {
+		var channelID, sourceID, sourceURL string
+		if err := rows.Scan(&channelID, &sourceID, &sourceURL); err != nil {
+			return err
+		}
+		if !active[channelID] {
+			continue
+		}
+		if current[channelID] == nil {
+			current[channelID] = make(map[string]bool)
+		}
+		current[channelID][sourceID] = true
+		arb.RegisterSource(channelID, sourceID, sourceURL)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for channelID, ids := range registered {
+		for sourceID := range ids {
+			if !current[channelID][sourceID] {
+				arb.DeregisterSource(channelID, sourceID)
+			}
+		}
+	}
+	for channelID := range registered {
+		delete(registered, channelID)
+	}
+	for channelID, ids := range current {
+		registered[channelID] = ids
+	}
+	return nil
+}
@@ -9,6 +9,7 @@
 //   PUT    /admin/channels/:id
 //   DELETE /admin/channels/:id          — soft delete (is_active=false)
 //   POST   /admin/channels/:id/logo     — multipart logo upload
+//   *      /admin/channels/:id/sources  — failover sources (see channel_sources.go)
 //   GET    /logos/:filename             — serve logo files
 //   POST   /admin/categories
 //   GET    /admin/categories
@@ -51,6 +52,7 @@ import (
 	_ "github.com/lib/pq"
 
 	rootauth "github.com/unyeco/roost/internal/auth"
+	"github.com/unyeco/roost/services/arbitrage"
 )
 
 // ---- helpers ----------------------------------------------------------------
@@ -138,7 +140,10 @@ type channelResponse struct {
 	RegionCode    *string     `json:"region_code,omitempty"`
 	BitrateConfig interface{} `json:"bitrate_config"`
 	EpgChannelID  *string     `json:"epg_channel_id"`
+	Callsign      *string     `json:"callsign"`       // used by the EPG service's channel matching
+	ChannelNumber *string     `json:"channel_number"` // likewise; set by provider sync for imported channels
 	SortOrder     int         `json:"sort_order"`
+	AlwaysOn      bool        `json:"always_on"` // keep ingest running with no viewers (on-demand mode)
 	CreatedAt     time.Time   `json:"created_at"`
 }
 
@@ -153,8 +158,11 @@ type channelInput struct {
 	CountryCode   *string     `json:"country_code"`
 	BitrateConfig interface{} `json:"bitrate_config"`
 	EpgChannelID  *string     `json:"epg_channel_id"`
+	Callsign      *string     `json:"callsign"`
+	ChannelNumber *string     `json:"channel_number"`
 	IsActive      *bool       `json:"is_active"`
 	SortOrder     *int        `json:"sort_order"`
+	AlwaysOn      *bool       `json:"always_on"`
 }
 
 // scanChannel reads a channel row into channelResponse. NEVER reads source_url.
@@ -166,7 +174,7 @@ func scanChannel(row interface {
 	err := row.Scan(
 		&c.ID, &c.Name, &c.Slug, &c.CategoryID, &c.LogoURL,
 		&c.IsActive, &c.LanguageCode, &c.RegionCode, &bitrateRaw,
-		&c.EpgChannelID, &c.SortOrder, &c.CreatedAt,
+		&c.EpgChannelID, &c.Callsign, &c.ChannelNumber, &c.SortOrder, &c.AlwaysOn, &c.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -180,7 +188,7 @@ func scanChannel(row interface {
 const channelSelectCols = `
 	id, name, slug, category_id, logo_url,
 	is_active, language_code, country_code, bitrate_config,
-	epg_channel_id, sort_order, created_at`
+	epg_channel_id, callsign, channel_number, sort_order, always_on, created_at`
 
 // ---- handlers: channels -----------------------------------------------------
 
@@ -210,6 +218,7 @@ func (s *server) handleCreateChannel(w http.ResponseWriter, r *http.Request) {
 	if inp.SortOrder != nil {
 		sortOrder = *inp.SortOrder
 	}
+	alwaysOn := inp.AlwaysOn != nil && *inp.AlwaysOn
 
 	var bitrateJSON []byte
 	if inp.BitrateConfig != nil {
@@ -218,11 +227,13 @@ func (s *server) handleCreateChannel(w http.ResponseWriter, r *http.Request) {
 
 	row := s.db.QueryRowContext(r.Context(), `
 		INSERT INTO channels (name, slug, category_id, logo_url, source_url, source_type,
-			language_code, country_code, bitrate_config, epg_channel_id, is_active, sort_order)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+			language_code, country_code, bitrate_config, epg_channel_id, callsign, channel_number,
+			is_active, sort_order, always_on)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
 		RETURNING `+channelSelectCols,
 		inp.Name, inp.Slug, inp.CategoryID, inp.LogoURL, inp.SourceURL, inp.SourceType,
-		inp.LanguageCode, inp.CountryCode, bitrateJSON, inp.EpgChannelID, isActive, sortOrder,
+		inp.LanguageCode, inp.CountryCode, bitrateJSON, inp.EpgChannelID, inp.Callsign, inp.ChannelNumber,
+		isActive, sortOrder, alwaysOn,
 	)
 	ch, err := scanChannel(row)
 	if err != nil {
@@ -379,12 +390,21 @@ func (s *server) handleUpdateChannel(w http.ResponseWriter, r *http.Request) {
 	if inp.EpgChannelID != nil {
 		sets = append(sets, fmt.Sprintf("epg_channel_id=$%d", argIdx)); args = append(args, inp.EpgChannelID); argIdx++
 	}
+	if inp.Callsign != nil {
+		sets = append(sets, fmt.Sprintf("callsign=$%d", argIdx)); args = append(args, inp.Callsign); argIdx++
+	}
+	if inp.ChannelNumber != nil {
+		sets = append(sets, fmt.Sprintf("channel_number=$%d", argIdx)); args = append(args, inp.ChannelNumber); argIdx++
+	}
 	if inp.IsActive != nil {
 		sets = append(sets, fmt.Sprintf("is_active=$%d", argIdx)); args = append(args, *inp.IsActive); argIdx++
 	}
 	if inp.SortOrder != nil {
 		sets = append(sets, fmt.Sprintf("sort_order=$%d", argIdx)); args = append(args, *inp.SortOrder); argIdx++
 	}
+	if inp.AlwaysOn != nil {
+		sets = append(sets, fmt.Sprintf("always_on=$%d", argIdx)); args = append(args, *inp.AlwaysOn); argIdx++
+	}
 
 	if len(sets) == 0 {
 		writeError(w, http.StatusBadRequest, "no_fields", "No fields to update")
@@ -1221,6 +1241,13 @@ func main() {
 
 	s := &server{db: db, logoDir: logoDir}
 
+	// Stream arbitrage — probes running channels' sources so ingest can rank them
+	if getEnv("CATALOG_ARBITRAGE", "true") != "false" {
+		arb := arbitrage.New(db)
+		defer arb.Stop()
+		go runArbitrageSync(context.Background(), db, arb, strings.TrimSuffix(getEnv("INGEST_URL", "http://localhost:8094"), "/"))
+	}
+
 	mux := http.NewServeMux()
 
 	// Health — no auth
@@ -1254,6 +1281,10 @@ func main() {
 			s.handleUploadLogo(w, r)
 			return
 		}
+		if len(segments) >= 4 && (segments[3] == "sources" || segments[3] == "source-quality") {
+			s.routeChannelSources(w, r, segments)
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			s.handleGetChannel(w, r)
@@ -1,8 +1,9 @@
 // main.go — Roost Family Channel Playlist Service.
 // Manages family-curated playlists that blend any content type (VOD, live,
 // podcast, game) into a sequential, shuffled, or round-robin schedule.
-// Generates M3U8-compatible playlists for Owl and produces a schedule endpoint
-// for the EPG grid compositor.
+// Generates M3U8-compatible playlists for Owl, produces a schedule endpoint
+// for the EPG grid compositor, and plays each playlist out as a continuous
+// live HLS channel aligned to that schedule (playout.go).
 //
 // Port: 8112 (env: CHANNEL_PORT). Internal service and owl_api.
 //
@@ -10,10 +11,12 @@
 //   POST /channel/playlists              — create playlist
 //   GET  /channel/playlists              — list family playlists
 //   GET  /channel/playlists/{id}         — get playlist with items
-//   PUT  /channel/playlists/{id}         — update playlist (name, items, schedule_type)
+//   PUT  /channel/playlists/{id}         — update playlist (name, items, schedule_type, bumpers, filler, slot_minutes)
 //   DELETE /channel/playlists/{id}       — delete playlist
 //   GET  /channel/playlists/{id}/m3u8    — generate M3U8 playlist file
 //   GET  /channel/playlists/{id}/schedule — get next-N-hours EPG schedule
+//   GET  /channel/playlists/{id}/live.m3u8 — live HLS playout of the schedule
+//   GET  /channel/playlists/{id}/live/{seq}.ts — playout segment (proxied)
 //   GET  /health
 package main
 
@@ -21,6 +24,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -93,13 +97,20 @@ type Playlist struct {
 	Description  string         `json:"description"`
 	Items        []PlaylistItem `json:"items"`
 	ScheduleType string         `json:"schedule_type"`
+	Bumpers      []PlaylistItem `json:"bumpers"`
+	Filler       []PlaylistItem `json:"filler"`
+	SlotMinutes  int            `json:"slot_minutes"`
 	CreatedAt    string         `json:"created_at"`
 	UpdatedAt    string         `json:"updated_at"`
 }
 
 // ─── server ──────────────────────────────────────────────────────────────────
 
-type server struct{ db *sql.DB }
+type server struct {
+	db         *sql.DB
+	media      *mediaResolver
+	renditions renditionCache
+}
 
 // ─── helpers ─────────────────────────────────────────────────────────────────
 
@@ -111,6 +122,16 @@ func parseItems(raw []byte) ([]PlaylistItem, error) {
 	return items, nil
 }
 
+// parseItemsOrEmpty is parseItems for API responses: bad or null JSON
+// becomes an empty list.
+func parseItemsOrEmpty(raw []byte) []PlaylistItem {
+	items, _ := parseItems(raw)
+	if items == nil {
+		items = []PlaylistItem{}
+	}
+	return items
+}
+
 func marshalItems(items []PlaylistItem) ([]byte, error) {
 	return json.Marshal(items)
 }
@@ -118,26 +139,31 @@ func marshalItems(items []PlaylistItem) ([]byte, error) {
 // applyScheduleType reorders items according to schedule_type.
 // sequential: as-stored, shuffle: random, round_robin: interleave by content_type.
 func applyScheduleType(items []PlaylistItem, scheduleType string) []PlaylistItem {
-	if len(items) == 0 {
-		return items
+	order := scheduleOrder(items, scheduleType, rand.Int63())
+	out := make([]PlaylistItem, len(order))
+	for i, idx := range order {
+		out[i] = items[idx]
 	}
+	return out
+}
+
+// scheduleOrder returns the indices of items in schedule_type order. shuffle
+// is deterministic for a given seed so the playout can replay it.
+func scheduleOrder(items []PlaylistItem, scheduleType string, seed int64) []int {
 	switch scheduleType {
 	case "shuffle":
-		out := make([]PlaylistItem, len(items))
-		copy(out, items)
-		rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
-		return out
+		return rand.New(rand.NewSource(seed)).Perm(len(items))
 	case "round_robin":
 		// Group by content_type, then interleave.
-		byType := map[string][]PlaylistItem{}
+		byType := map[string][]int{}
 		order := []string{}
-		for _, item := range items {
+		for i, item := range items {
 			if _, seen := byType[item.ContentType]; !seen {
 				order = append(order, item.ContentType)
 			}
-			byType[item.ContentType] = append(byType[item.ContentType], item)
+			byType[item.ContentType] = append(byType[item.ContentType], i)
 		}
-		out := make([]PlaylistItem, 0, len(items))
+		out := make([]int, 0, len(items))
 		maxLen := 0
 		for _, t := range order {
 			if len(byType[t]) > maxLen {
@@ -153,8 +179,25 @@ func applyScheduleType(items []PlaylistItem, scheduleType string) []PlaylistItem
 		}
 		return out
 	default:
-		return items
+		out := make([]int, len(items))
+		for i := range out {
+			out[i] = i
+		}
+		return out
+	}
+}
+
+// validatePlayout checks the playout settings of a create or update body.
+func validatePlayout(bumpers []PlaylistItem, slotMinutes int) string {
+	for _, b := range bumpers {
+		if b.DurationSec <= 0 {
+			return "bumpers need a duration_sec"
+		}
+	}
+	if slotMinutes < 0 || slotMinutes > 1440 {
+		return "slot_minutes must be between 0 and 1440"
 	}
+	return ""
 }
 
 // ─── handlers ────────────────────────────────────────────────────────────────
@@ -167,11 +210,18 @@ func (s *server) handleCreate(w http.ResponseWriter, r *http.Request) {
 		Description  string         `json:"description"`
 		Items        []PlaylistItem `json:"items"`
 		ScheduleType string         `json:"schedule_type"`
+		Bumpers      []PlaylistItem `json:"bumpers"`
+		Filler       []PlaylistItem `json:"filler"`
+		SlotMinutes  int            `json:"slot_minutes"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeError(w, http.StatusBadRequest, "bad_request", "invalid JSON body")
 		return
 	}
+	if msg := validatePlayout(body.Bumpers, body.SlotMinutes); msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg)
+		return
+	}
 	if body.Name == "" {
 		writeError(w, http.StatusBadRequest, "bad_request", "name is required")
 		return
@@ -182,18 +232,28 @@ func (s *server) handleCreate(w http.ResponseWriter, r *http.Request) {
 	if body.Items == nil {
 		body.Items = []PlaylistItem{}
 	}
+	if body.Bumpers == nil {
+		body.Bumpers = []PlaylistItem{}
+	}
+	if body.Filler == nil {
+		body.Filler = []PlaylistItem{}
+	}
 
 	itemsJSON, err := marshalItems(body.Items)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "bad_request", "invalid items")
 		return
 	}
+	bumpersJSON, _ := marshalItems(body.Bumpers)
+	fillerJSON, _ := marshalItems(body.Filler)
 
 	var id string
 	err = s.db.QueryRowContext(r.Context(),
-		`INSERT INTO channel_playlists (family_id, name, description, items, schedule_type)
-		 VALUES ($1, $2, $3, $4::jsonb, $5) RETURNING id`,
+		`INSERT INTO channel_playlists (family_id, name, description, items, schedule_type,
+		                                bumpers, filler, slot_minutes)
+		 VALUES ($1, $2, $3, $4::jsonb, $5, $6::jsonb, $7::jsonb, $8) RETURNING id`,
 		familyID, body.Name, body.Description, string(itemsJSON), body.ScheduleType,
+		string(bumpersJSON), string(fillerJSON), body.SlotMinutes,
 	).Scan(&id)
 	if err != nil {
 		log.Printf("[channel] create error: %v", err)
@@ -208,7 +268,7 @@ func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
 
 	rows, err := s.db.QueryContext(r.Context(),
 		`SELECT id, family_id, name, COALESCE(description,''), items, schedule_type,
-		        created_at::text, updated_at::text
+		        bumpers, filler, slot_minutes, created_at::text, updated_at::text
 		 FROM channel_playlists WHERE family_id = $1 ORDER BY name ASC`,
 		familyID,
 	)
@@ -221,16 +281,15 @@ func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
 	playlists := []Playlist{}
 	for rows.Next() {
 		var p Playlist
-		var itemsRaw []byte
+		var itemsRaw, bumpersRaw, fillerRaw []byte
 		if err := rows.Scan(&p.ID, &p.FamilyID, &p.Name, &p.Description,
-			&itemsRaw, &p.ScheduleType, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			&itemsRaw, &p.ScheduleType, &bumpersRaw, &fillerRaw, &p.SlotMinutes,
+			&p.CreatedAt, &p.UpdatedAt); err != nil {
 			continue
 		}
-		items, _ := parseItems(itemsRaw)
-		if items == nil {
-			items = []PlaylistItem{}
-		}
-		p.Items = items
+		p.Items = parseItemsOrEmpty(itemsRaw)
+		p.Bumpers = parseItemsOrEmpty(bumpersRaw)
+		p.Filler = parseItemsOrEmpty(fillerRaw)
 		playlists = append(playlists, p)
 	}
 	writeJSON(w, http.StatusOK, playlists)
@@ -241,14 +300,15 @@ func (s *server) handleGet(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
 	var p Playlist
-	var itemsRaw []byte
+	var itemsRaw, bumpersRaw, fillerRaw []byte
 	err := s.db.QueryRowContext(r.Context(),
 		`SELECT id, family_id, name, COALESCE(description,''), items, schedule_type,
-		        created_at::text, updated_at::text
+		        bumpers, filler, slot_minutes, created_at::text, updated_at::text
 		 FROM channel_playlists WHERE id = $1 AND family_id = $2`,
 		id, familyID,
 	).Scan(&p.ID, &p.FamilyID, &p.Name, &p.Description,
-		&itemsRaw, &p.ScheduleType, &p.CreatedAt, &p.UpdatedAt)
+		&itemsRaw, &p.ScheduleType, &bumpersRaw, &fillerRaw, &p.SlotMinutes,
+		&p.CreatedAt, &p.UpdatedAt)
 	if err == sql.ErrNoRows {
 		writeError(w, http.StatusNotFound, "not_found", "playlist not found")
 		return
@@ -257,11 +317,9 @@ func (s *server) handleGet(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
 	}
-	items, _ := parseItems(itemsRaw)
-	if items == nil {
-		items = []PlaylistItem{}
-	}
-	p.Items = items
+	p.Items = parseItemsOrEmpty(itemsRaw)
+	p.Bumpers = parseItemsOrEmpty(bumpersRaw)
+	p.Filler = parseItemsOrEmpty(fillerRaw)
 	writeJSON(w, http.StatusOK, p)
 }
 
@@ -272,13 +330,34 @@ func (s *server) handleUpdate(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		Name         string         `json:"name"`
 		Description  string         `json:"description"`
-		Items        []PlaylistItem `json:"items"`
-		ScheduleType string         `json:"schedule_type"`
+		Items        []PlaylistItem  `json:"items"`
+		ScheduleType string          `json:"schedule_type"`
+		Bumpers      *[]PlaylistItem `json:"bumpers"`
+		Filler       *[]PlaylistItem `json:"filler"`
+		SlotMinutes  *int            `json:"slot_minutes"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeError(w, http.StatusBadRequest, "bad_request", "invalid JSON body")
 		return
 	}
+	// Omitted playout settings are left unchanged.
+	var bumpers []PlaylistItem
+	var bumpersJSON, fillerJSON []byte
+	var slotMinutes sql.NullInt64
+	if body.Bumpers != nil {
+		bumpers = *body.Bumpers
+		bumpersJSON, _ = marshalItems(bumpers)
+	}
+	if body.Filler != nil {
+		fillerJSON, _ = marshalItems(*body.Filler)
+	}
+	if body.SlotMinutes != nil {
+		slotMinutes = sql.NullInt64{Int64: int64(*body.SlotMinutes), Valid: true}
+	}
+	if msg := validatePlayout(bumpers, int(slotMinutes.Int64)); msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg)
+		return
+	}
 
 	itemsJSON, err := marshalItems(body.Items)
 	if err != nil {
@@ -292,9 +371,13 @@ func (s *server) handleUpdate(w http.ResponseWriter, r *http.Request) {
 		     description = $2,
 		     items = $3::jsonb,
 		     schedule_type = COALESCE(NULLIF($4,''), schedule_type),
+		     bumpers = COALESCE($7::jsonb, bumpers),
+		     filler = COALESCE($8::jsonb, filler),
+		     slot_minutes = COALESCE($9, slot_minutes),
 		     updated_at = now()
 		 WHERE id = $5 AND family_id = $6`,
 		body.Name, body.Description, string(itemsJSON), body.ScheduleType, id, familyID,
+		nullJSON(bumpersJSON), nullJSON(fillerJSON), slotMinutes,
 	)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
@@ -308,6 +391,11 @@ func (s *server) handleUpdate(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
 }
 
+// nullJSON passes a JSON document to Postgres, or NULL when nil.
+func nullJSON(b []byte) sql.NullString {
+	return sql.NullString{String: string(b), Valid: b != nil}
+}
+
 func (s *server) handleDelete(w http.ResponseWriter, r *http.Request) {
 	familyID := r.Header.Get("X-Family-ID")
 	id := chi.URLParam(r, "id")
@@ -386,58 +474,20 @@ type ScheduleEntry struct {
 	DurationSec int    `json:"duration_sec"`
 }
 
-// handleSchedule produces a 6-hour forward-looking EPG schedule from the playlist.
+// handleSchedule produces a 6-hour forward-looking EPG schedule from the
+// playout timeline, starting with the program on air now.
 func (s *server) handleSchedule(w http.ResponseWriter, r *http.Request) {
-	familyID := r.Header.Get("X-Family-ID")
-	id := chi.URLParam(r, "id")
-
-	var itemsRaw []byte
-	var scheduleType string
-	err := s.db.QueryRowContext(r.Context(),
-		`SELECT items, schedule_type FROM channel_playlists WHERE id = $1 AND family_id = $2`,
-		id, familyID,
-	).Scan(&itemsRaw, &scheduleType)
-	if err == sql.ErrNoRows {
-		writeError(w, http.StatusNotFound, "not_found", "playlist not found")
+	p, err := s.loadPlayout(r.Context(), chi.URLParam(r, "id"), r.Header.Get("X-Family-ID"))
+	if errors.Is(err, errEmptyPlaylist) {
+		writeJSON(w, http.StatusOK, []ScheduleEntry{})
 		return
 	}
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		writePlayoutError(w, err)
 		return
 	}
-
-	items, _ := parseItems(itemsRaw)
-	items = applyScheduleType(items, scheduleType)
-	if len(items) == 0 {
-		writeJSON(w, http.StatusOK, []ScheduleEntry{})
-		return
-	}
-
 	now := time.Now().UTC()
-	limit := now.Add(6 * time.Hour)
-	cursor := now
-	entries := []ScheduleEntry{}
-	i := 0
-
-	for cursor.Before(limit) && len(entries) < 100 {
-		item := items[i%len(items)]
-		dur := item.DurationSec
-		if dur <= 0 {
-			dur = 1800 // default 30 min for items without duration
-		}
-		end := cursor.Add(time.Duration(dur) * time.Second)
-		entries = append(entries, ScheduleEntry{
-			ContentID:   item.ContentID,
-			ContentType: item.ContentType,
-			Title:       item.Title,
-			StartTime:   cursor.Format(time.RFC3339),
-			EndTime:     end.Format(time.RFC3339),
-			DurationSec: dur,
-		})
-		cursor = end
-		i++
-	}
-	writeJSON(w, http.StatusOK, entries)
+	writeJSON(w, http.StatusOK, p.schedule(now, now.Add(6*time.Hour), 100))
 }
 
 func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -453,7 +503,11 @@ func main() {
 	}
 	defer db.Close()
 
-	srv := &server{db: db}
+	srv := &server{
+		db:         db,
+		media:      newMediaResolver(db),
+		renditions: renditionCache{m: map[string]*rendition{}},
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
@@ -471,6 +525,8 @@ func main() {
 		r.Delete("/channel/playlists/{id}", srv.handleDelete)
 		r.Get("/channel/playlists/{id}/m3u8", srv.handleM3U8)
 		r.Get("/channel/playlists/{id}/schedule", srv.handleSchedule)
+		r.Get("/channel/playlists/{id}/live.m3u8", srv.handleLive)
+		r.Get("/channel/playlists/{id}/live/{seq}.ts", srv.handleLiveSegment)
 	})
 
 	port := getEnv("CHANNEL_PORT", "8112")
@@ -0,0 +1,272 @@
+// media.go — Resolves playlist items to VOD HLS segments for the playout.
+//
+// Movies and episodes play from their vod_catalog / vod_episodes source_url;
+// other items need a stream_url pointing at an HLS playlist. Only complete
+// (EXT-X-ENDLIST), unencrypted MPEG-TS playlists can be stitched; master
+// playlists are followed to their highest-bandwidth variant.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mediaCacheTTL     = 6 * time.Hour
+	mediaFailureRetry = time.Minute
+	maxPlaylistBytes  = 4 << 20
+)
+
+// mediaSegment is one segment of a VOD playlist.
+type mediaSegment struct {
+	URI      string // absolute
+	Duration float64
+}
+
+type mediaEntry struct {
+	segs []mediaSegment
+	err  error
+	at   time.Time
+}
+
+// mediaResolver caches resolved playlists; VOD playlists don't change, and
+// a stable cache keeps segment counts (and so sequence numbers) stable.
+type mediaResolver struct {
+	db     *sql.DB
+	client *http.Client
+	mu     sync.Mutex
+	cache  map[string]mediaEntry
+}
+
+func newMediaResolver(db *sql.DB) *mediaResolver {
+	return &mediaResolver{
+		db:     db,
+		client: &http.Client{Timeout: 15 * time.Second},
+		cache:  map[string]mediaEntry{},
+	}
+}
+
+// resolve returns an item's segments.
+func (m *mediaResolver) resolve(ctx context.Context, it PlaylistItem) ([]mediaSegment, error) {
+	key := it.ContentType + "|" + it.ContentID + "|" + it.StreamURL
+	m.mu.Lock()
+	e, ok := m.cache[key]
+	m.mu.Unlock()
+	if ok {
+		ttl := mediaCacheTTL
+		if e.err != nil {
+			ttl = mediaFailureRetry
+		}
+		if time.Since(e.at) < ttl {
+			return e.segs, e.err
+		}
+	}
+
+	e = mediaEntry{at: time.Now()}
+	src, err := m.sourceURL(ctx, it)
+	if err == nil {
+		e.segs, err = m.fetch(ctx, src, true)
+	}
+	e.err = err
+	m.mu.Lock()
+	m.cache[key] = e
+	m.mu.Unlock()
+	return e.segs, e.err
+}
+
+// sourceURL finds the HLS playlist behind an item.
+func (m *mediaResolver) sourceURL(ctx context.Context, it PlaylistItem) (string, error) {
+	var q string
+	switch it.ContentType {
+	case "movie":
+		q = `SELECT source_url FROM vod_catalog WHERE id::text = $1`
+	case "show_episode":
+		q = `SELECT source_url FROM vod_episodes WHERE id::text = $1`
+	default:
+		if it.StreamURL == "" {
+			return "", fmt.Errorf("%s items need a stream_url", it.ContentType)
+		}
+		return it.StreamURL, nil
+	}
+	var src string
+	if err := m.db.QueryRowContext(ctx, q, it.ContentID).Scan(&src); err != nil {
+		if err == sql.ErrNoRows && it.StreamURL != "" {
+			return it.StreamURL, nil
+		}
+		return "", err
+	}
+	return src, nil
+}
+
+// fetch loads a playlist, following one level of master playlist.
+func (m *mediaResolver) fetch(ctx context.Context, rawURL string, followVariant bool) ([]mediaSegment, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("playlist HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPlaylistBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	pl, err := parseVODPlaylist(base, body)
+	if err != nil {
+		return nil, err
+	}
+	if pl.variant != "" {
+		if !followVariant {
+			return nil, errors.New("nested master playlist")
+		}
+		return m.fetch(ctx, pl.variant, false)
+	}
+	return pl.segments, nil
+}
+
+// proxy streams a source segment to w.
+func (m *mediaResolver) proxy(ctx context.Context, w http.ResponseWriter, src string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "upstream_error", "segment unavailable")
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		writeError(w, http.StatusBadGateway, "upstream_error", "segment unavailable")
+		return fmt.Errorf("segment HTTP %d", resp.StatusCode)
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	if n := resp.Header.Get("Content-Length"); n != "" {
+		w.Header().Set("Content-Length", n)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// vodPlaylist is a parsed playlist: either a master (variant set) or a
+// complete media playlist.
+type vodPlaylist struct {
+	variant  string
+	segments []mediaSegment
+}
+
+// parseVODPlaylist parses body, resolving URIs against base.
+func parseVODPlaylist(base *url.URL, body []byte) (vodPlaylist, error) {
+	var pl vodPlaylist
+	var ended bool
+	var bestBW int64 = -1
+	var pendingBW int64 = -1
+	var pendingDur float64 = -1
+
+	resolve := func(ref string) (string, error) {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return "", err
+		}
+		return u.String(), nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			pendingBW, _ = strconv.ParseInt(hlsAttr(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"), "BANDWIDTH"), 10, 64)
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			d, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return pl, fmt.Errorf("bad EXTINF %q", line)
+			}
+			pendingDur = d
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			if m := hlsAttr(strings.TrimPrefix(line, "#EXT-X-KEY:"), "METHOD"); m != "NONE" {
+				return pl, errors.New("encrypted playlists are not supported")
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"), strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			return pl, errors.New("only MPEG-TS segment playlists are supported")
+		case line == "#EXT-X-ENDLIST":
+			ended = true
+		case strings.HasPrefix(line, "#"):
+		case pendingBW >= 0:
+			if pendingBW > bestBW {
+				u, err := resolve(line)
+				if err != nil {
+					return pl, err
+				}
+				pl.variant, bestBW = u, pendingBW
+			}
+			pendingBW = -1
+		case pendingDur >= 0:
+			u, err := resolve(line)
+			if err != nil {
+				return pl, err
+			}
+			pl.segments = append(pl.segments, mediaSegment{URI: u, Duration: pendingDur})
+			pendingDur = -1
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return pl, err
+	}
+	if pl.variant != "" {
+		return pl, nil
+	}
+	if !ended {
+		return pl, errors.New("not a VOD playlist (no EXT-X-ENDLIST)")
+	}
+	if len(pl.segments) == 0 {
+		return pl, errors.New("playlist has no segments")
+	}
+	return pl, nil
+}
+
+// hlsAttr returns a named value from an HLS attribute list, honouring quoted
+// commas.
+func hlsAttr(list, name string) string {
+	inQuote := false
+	start := 0
+	for i := 0; i <= len(list); i++ {
+		if i < len(list) && list[i] == '"' {
+			inQuote = !inQuote
+		}
+		if i < len(list) && (list[i] != ',' || inQuote) {
+			continue
+		}
+		k, v, _ := strings.Cut(list[start:i], "=")
+		if strings.TrimSpace(k) == name {
+			return strings.Trim(strings.TrimSpace(v), `"`)
+		}
+		start = i + 1
+	}
+	return ""
+}
@@ -0,0 +1,471 @@
+// playout.go — Linear playout for family channel playlists.
+//
+// A playlist plays as a repeating cycle anchored to the wall clock at the
+// playlist's updated_at. Each stored item becomes a program: its bumper (if
+// any), the item itself, then filler up to the next slot boundary. Program
+// lengths depend only on nominal durations, so /schedule and the live stream
+// always agree, and shuffle orders are seeded by playlist and cycle number so
+// every request sees the same order.
+//
+// The live stream is a sliding-window HLS media playlist built from the
+// programs' VOD segments with EXT-X-DISCONTINUITY at every clip boundary.
+// Segments are proxied through the service so source URLs stay internal.
+//
+// owl_api lists each family's playlists as channels in its lineup, EPG,
+// Xtream and HDHomeRun surfaces, fetching live.m3u8, the live segments and
+// /schedule from here with the family headers (owl_api playout.go).
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultItemSec    = 1800 // items without a duration get a 30 min slot
+	liveWindowSegs    = 6    // segments in the live media playlist
+	renditionLifetime = 5 * time.Minute
+)
+
+var errEmptyPlaylist = errors.New("playlist has no items")
+
+// playout is a playlist's wall-clock timeline.
+type playout struct {
+	ID           string
+	Version      string // changes whenever the playlist is edited
+	ScheduleType string
+	Items        []PlaylistItem
+	Bumpers      []PlaylistItem
+	Filler       []PlaylistItem
+	Slot         time.Duration
+	Epoch        time.Time
+}
+
+// itemDuration is an item's nominal length.
+func itemDuration(it PlaylistItem) time.Duration {
+	if it.DurationSec <= 0 {
+		return defaultItemSec * time.Second
+	}
+	return time.Duration(it.DurationSec) * time.Second
+}
+
+// bumperFor returns the bumper that precedes stored item i, or nil. Bumpers
+// are assigned by stored position so a program's length never depends on the
+// cycle's order.
+func (p *playout) bumperFor(i int) *PlaylistItem {
+	if len(p.Bumpers) == 0 {
+		return nil
+	}
+	return &p.Bumpers[i%len(p.Bumpers)]
+}
+
+// bumperDuration is the nominal length of item i's bumper.
+func (p *playout) bumperDuration(i int) time.Duration {
+	if b := p.bumperFor(i); b != nil && b.DurationSec > 0 {
+		return time.Duration(b.DurationSec) * time.Second
+	}
+	return 0
+}
+
+// span is the length of item i's program, rounded up to a whole slot.
+func (p *playout) span(i int) time.Duration {
+	d := p.bumperDuration(i) + itemDuration(p.Items[i])
+	if p.Slot > 0 {
+		d = (d + p.Slot - 1) / p.Slot * p.Slot
+	}
+	return d
+}
+
+// cycleSpan is the length of one pass through the playlist.
+func (p *playout) cycleSpan() time.Duration {
+	var d time.Duration
+	for i := range p.Items {
+		d += p.span(i)
+	}
+	return d
+}
+
+// order returns the stored item indices in play order for a cycle.
+func (p *playout) order(cycle int64) []int {
+	h := fnv.New64a()
+	h.Write([]byte(p.ID))
+	return scheduleOrder(p.Items, p.ScheduleType, int64(h.Sum64())+cycle)
+}
+
+// playoutPos is a program on the timeline.
+type playoutPos struct {
+	cycle int64
+	order []int
+	k     int       // index into order
+	start time.Time // program start
+}
+
+// at finds the program on air at t and how far into it t is.
+func (p *playout) at(t time.Time) (playoutPos, time.Duration) {
+	elapsed := t.Sub(p.Epoch)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	span := p.cycleSpan()
+	pos := playoutPos{cycle: int64(elapsed / span)}
+	pos.order = p.order(pos.cycle)
+	off := elapsed % span
+	pos.start = p.Epoch.Add(time.Duration(pos.cycle) * span)
+	for k, idx := range pos.order {
+		pos.k = k
+		if s := p.span(idx); off >= s && k < len(pos.order)-1 {
+			off -= s
+			pos.start = pos.start.Add(s)
+			continue
+		}
+		break
+	}
+	return pos, off
+}
+
+// next advances pos to the following program.
+func (p *playout) next(pos playoutPos) playoutPos {
+	pos.start = pos.start.Add(p.span(pos.order[pos.k]))
+	pos.k++
+	if pos.k == len(pos.order) {
+		pos.cycle++
+		pos.order = p.order(pos.cycle)
+		pos.k = 0
+	}
+	return pos
+}
+
+// schedule lists programs from the one on air at from until until.
+func (p *playout) schedule(from, until time.Time, max int) []ScheduleEntry {
+	entries := []ScheduleEntry{}
+	if len(p.Items) == 0 {
+		return entries
+	}
+	pos, _ := p.at(from)
+	for pos.start.Before(until) && len(entries) < max {
+		idx := pos.order[pos.k]
+		item := p.Items[idx]
+		span := p.span(idx)
+		entries = append(entries, ScheduleEntry{
+			ContentID:   item.ContentID,
+			ContentType: item.ContentType,
+			Title:       item.Title,
+			StartTime:   pos.start.UTC().Format(time.RFC3339),
+			EndTime:     pos.start.Add(span).UTC().Format(time.RFC3339),
+			DurationSec: int(span / time.Second),
+		})
+		pos = p.next(pos)
+	}
+	return entries
+}
+
+// ─── rendition ───────────────────────────────────────────────────────────────
+
+// liveSegment is one source segment placed in a program.
+type liveSegment struct {
+	URI      string
+	Duration float64
+	Offset   time.Duration // from program start
+	Disc     bool          // first segment of a clip
+}
+
+// render lays out stored item i's program: bumper, item (cut at its nominal
+// end), then filler clips until the program's span is used up. media returns
+// nil for clips that can't be played; if there is no usable filler the
+// stream stalls until the next program.
+func (p *playout) render(i int, media func(PlaylistItem) []mediaSegment) []liveSegment {
+	var out []liveSegment
+	var off time.Duration
+	add := func(clip []mediaSegment, until time.Duration) bool {
+		before := off
+		for n, s := range clip {
+			if off >= until {
+				break
+			}
+			out = append(out, liveSegment{URI: s.URI, Duration: s.Duration, Offset: off, Disc: n == 0})
+			off += time.Duration(s.Duration * float64(time.Second))
+		}
+		return off > before
+	}
+
+	if b := p.bumperFor(i); b != nil {
+		add(media(*b), p.bumperDuration(i))
+	}
+	add(media(p.Items[i]), p.bumperDuration(i)+itemDuration(p.Items[i]))
+	span := p.span(i)
+	for k, misses := 0, 0; off < span && misses < len(p.Filler); k++ {
+		if add(media(p.Filler[k%len(p.Filler)]), span) {
+			misses = 0
+		} else {
+			misses++
+		}
+	}
+	return out
+}
+
+// rendition is a playout resolved to media segments. Programs are rendered
+// per stored item, so every cycle has the same segment and discontinuity
+// counts and sequence numbers follow from the wall clock alone.
+type rendition struct {
+	p        *playout
+	programs [][]liveSegment // by stored item index
+	discs    []int64         // discontinuities per program
+	cycleSeg int64
+	cycleDis int64
+	target   int
+	built    time.Time
+}
+
+func newRendition(p *playout, media func(PlaylistItem) []mediaSegment) *rendition {
+	r := &rendition{p: p, target: 1, built: time.Now()}
+	for i := range p.Items {
+		segs := p.render(i, media)
+		var discs int64
+		for _, s := range segs {
+			if s.Disc {
+				discs++
+			}
+			if d := int(math.Ceil(s.Duration)); d > r.target {
+				r.target = d
+			}
+		}
+		r.programs = append(r.programs, segs)
+		r.discs = append(r.discs, discs)
+		r.cycleSeg += int64(len(segs))
+		r.cycleDis += discs
+	}
+	return r
+}
+
+// liveRef is a segment on the timeline.
+type liveRef struct {
+	pos playoutPos
+	j   int
+}
+
+func (r *rendition) seg(ref liveRef) liveSegment {
+	return r.programs[ref.pos.order[ref.pos.k]][ref.j]
+}
+
+// sequence returns a segment's media sequence number and discontinuity
+// sequence number (discontinuities up to and including it).
+func (r *rendition) sequence(ref liveRef) (msn, dsn int64) {
+	msn = ref.pos.cycle * r.cycleSeg
+	dsn = ref.pos.cycle * r.cycleDis
+	for _, idx := range ref.pos.order[:ref.pos.k] {
+		msn += int64(len(r.programs[idx]))
+		dsn += r.discs[idx]
+	}
+	msn += int64(ref.j)
+	for _, s := range r.programs[ref.pos.order[ref.pos.k]][:ref.j+1] {
+		if s.Disc {
+			dsn++
+		}
+	}
+	return msn, dsn
+}
+
+// window returns up to n segments ending with the one on air at now.
+func (r *rendition) window(now time.Time, n int) []liveRef {
+	if r.cycleSeg == 0 {
+		return nil
+	}
+	pos, off := r.p.at(now)
+	segs := r.programs[pos.order[pos.k]]
+	j := sort.Search(len(segs), func(i int) bool { return segs[i].Offset > off }) - 1
+
+	var refs []liveRef
+	for len(refs) < n {
+		if j >= 0 {
+			refs = append(refs, liveRef{pos: pos, j: j})
+			j--
+			continue
+		}
+		if pos.k == 0 {
+			if pos.cycle == 0 {
+				break
+			}
+			// pos.start is this cycle's start, i.e. the previous cycle's end.
+			pos.cycle--
+			pos.order = r.p.order(pos.cycle)
+			pos.k = len(pos.order)
+		}
+		pos.k--
+		pos.start = pos.start.Add(-r.p.span(pos.order[pos.k]))
+		j = len(r.programs[pos.order[pos.k]]) - 1
+	}
+	for a, b := 0, len(refs)-1; a < b; a, b = a+1, b-1 {
+		refs[a], refs[b] = refs[b], refs[a]
+	}
+	return refs
+}
+
+// lookup maps a media sequence number back to its segment.
+func (r *rendition) lookup(msn int64) (liveSegment, bool) {
+	if msn < 0 || r.cycleSeg == 0 {
+		return liveSegment{}, false
+	}
+	rem := msn % r.cycleSeg
+	for _, idx := range r.p.order(msn / r.cycleSeg) {
+		if n := int64(len(r.programs[idx])); rem >= n {
+			rem -= n
+			continue
+		}
+		return r.programs[idx][rem], true
+	}
+	return liveSegment{}, false
+}
+
+// livePlaylist renders the sliding-window media playlist at now.
+func (r *rendition) livePlaylist(now time.Time) string {
+	refs := r.window(now, liveWindowSegs)
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&sb, "#EXT-X-TARGETDURATION:%d\n", r.target)
+	if len(refs) == 0 {
+		sb.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+		return sb.String()
+	}
+	msn, dsn := r.sequence(refs[0])
+	fmt.Fprintf(&sb, "#EXT-X-MEDIA-SEQUENCE:%d\n", msn)
+	fmt.Fprintf(&sb, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", dsn)
+	for n, ref := range refs {
+		s := r.seg(ref)
+		if s.Disc && n > 0 {
+			sb.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if s.Disc || n == 0 {
+			at := ref.pos.start.Add(s.Offset).UTC()
+			fmt.Fprintf(&sb, "#EXT-X-PROGRAM-DATE-TIME:%s\n", at.Format("2006-01-02T15:04:05.000Z"))
+		}
+		fmt.Fprintf(&sb, "#EXTINF:%.3f,\nlive/%d.ts\n", s.Duration, msn+int64(n))
+	}
+	return sb.String()
+}
+
+// ─── server glue ─────────────────────────────────────────────────────────────
+
+// renditionCache keeps one rendition per playlist, rebuilt when the playlist
+// is edited or after renditionLifetime so media that failed to resolve is
+// retried.
+type renditionCache struct {
+	mu sync.Mutex
+	m  map[string]*rendition
+}
+
+func (s *server) loadPlayout(ctx context.Context, id, familyID string) (*playout, error) {
+	p := &playout{ID: id}
+	var itemsRaw, bumpersRaw, fillerRaw []byte
+	var slotMinutes int
+	var updated time.Time
+	err := s.db.QueryRowContext(ctx,
+		`SELECT items, schedule_type, bumpers, filler, slot_minutes, updated_at
+		 FROM channel_playlists WHERE id = $1 AND family_id = $2`,
+		id, familyID,
+	).Scan(&itemsRaw, &p.ScheduleType, &bumpersRaw, &fillerRaw, &slotMinutes, &updated)
+	if err != nil {
+		return nil, err
+	}
+	p.Items, _ = parseItems(itemsRaw)
+	p.Bumpers, _ = parseItems(bumpersRaw)
+	p.Filler, _ = parseItems(fillerRaw)
+	p.Version = updated.UTC().Format(time.RFC3339Nano)
+	p.Slot = time.Duration(slotMinutes) * time.Minute
+	p.Epoch = updated.UTC().Truncate(time.Second)
+	if p.Slot > 0 {
+		p.Epoch = p.Epoch.Truncate(p.Slot)
+	}
+	if len(p.Items) == 0 {
+		return p, errEmptyPlaylist
+	}
+	return p, nil
+}
+
+// rendition returns the cached rendition for a playlist, rebuilding it if
+// stale.
+func (s *server) rendition(ctx context.Context, id, familyID string) (*rendition, error) {
+	p, err := s.loadPlayout(ctx, id, familyID)
+	if err != nil {
+		return nil, err
+	}
+	s.renditions.mu.Lock()
+	r := s.renditions.m[id]
+	s.renditions.mu.Unlock()
+	if r != nil && r.p.Version == p.Version && time.Since(r.built) < renditionLifetime {
+		return r, nil
+	}
+
+	// Resolve outside the request's cancellation: a failure is cached and
+	// would shift sequence numbers for every viewer.
+	resolveCtx := context.WithoutCancel(ctx)
+	r = newRendition(p, func(it PlaylistItem) []mediaSegment {
+		segs, err := s.media.resolve(resolveCtx, it)
+		if err != nil {
+			log.Printf("[channel] playout %s: %s %q: %v", id, it.ContentType, it.Title, err)
+		}
+		return segs
+	})
+	s.renditions.mu.Lock()
+	s.renditions.m[id] = r
+	s.renditions.mu.Unlock()
+	return r, nil
+}
+
+// writePlayoutError maps loadPlayout errors to responses.
+func writePlayoutError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, "not_found", "playlist not found")
+	case errors.Is(err, errEmptyPlaylist):
+		writeError(w, http.StatusConflict, "empty_playlist", "playlist has no items")
+	default:
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+	}
+}
+
+// handleLive serves the channel as a live HLS media playlist.
+func (s *server) handleLive(w http.ResponseWriter, r *http.Request) {
+	rd, err := s.rendition(r.Context(), chi.URLParam(r, "id"), r.Header.Get("X-Family-ID"))
+	if err != nil {
+		writePlayoutError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, rd.livePlaylist(time.Now()))
+}
+
+// handleLiveSegment proxies the source segment behind a media sequence number.
+func (s *server) handleLiveSegment(w http.ResponseWriter, r *http.Request) {
+	msn, err := strconv.ParseInt(chi.URLParam(r, "seq"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not_found", "segment not found")
+		return
+	}
+	rd, err := s.rendition(r.Context(), chi.URLParam(r, "id"), r.Header.Get("X-Family-ID"))
+	if err != nil {
+		writePlayoutError(w, err)
+		return
+	}
+	seg, ok := rd.lookup(msn)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "segment not found")
+		return
+	}
+	if err := s.media.proxy(r.Context(), w, seg.URI); err != nil {
+		log.Printf("[channel] segment %d of %s: %v", msn, rd.p.ID, err)
+	}
+}
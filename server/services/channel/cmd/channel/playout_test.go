@@ -0,0 +1,199 @@
+// playout_test.go — Unit tests for the linear playout timeline and rendition.
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clip builds n segments of d seconds for name.
+func clip(name string, n int, d float64) []mediaSegment {
+	segs := make([]mediaSegment, n)
+	for i := range segs {
+		segs[i] = mediaSegment{URI: fmt.Sprintf("http://vod/%s/%d.ts", name, i), Duration: d}
+	}
+	return segs
+}
+
+func testPlayout() *playout {
+	return &playout{
+		ID:           "p1",
+		ScheduleType: "sequential",
+		Items: []PlaylistItem{
+			{ContentID: "a", Title: "A", DurationSec: 20 * 60},
+			{ContentID: "b", Title: "B", DurationSec: 50 * 60},
+		},
+		Bumpers: []PlaylistItem{{ContentID: "bump", DurationSec: 10}},
+		Filler:  []PlaylistItem{{ContentID: "fill"}},
+		Slot:    30 * time.Minute,
+		Epoch:   time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestPlayoutSpansPadToSlot(t *testing.T) {
+	p := testPlayout()
+	if got := p.span(0); got != 30*time.Minute {
+		t.Errorf("span(0) = %v, want 30m", got)
+	}
+	if got := p.span(1); got != 60*time.Minute {
+		t.Errorf("span(1) = %v, want 60m", got)
+	}
+	if got := p.cycleSpan(); got != 90*time.Minute {
+		t.Errorf("cycleSpan = %v, want 90m", got)
+	}
+}
+
+func TestPlayoutScheduleIsWallClockAnchored(t *testing.T) {
+	p := testPlayout()
+	// Cycle 0 is A 12:00–12:30, B 12:30–13:30, so 13:40 is inside cycle 1's A.
+	from := time.Date(2026, 1, 1, 13, 40, 0, 0, time.UTC)
+	got := p.schedule(from, from.Add(2*time.Hour), 10)
+	want := []struct{ id, start, end string }{
+		{"a", "2026-01-01T13:30:00Z", "2026-01-01T14:00:00Z"},
+		{"b", "2026-01-01T14:00:00Z", "2026-01-01T15:00:00Z"},
+		{"a", "2026-01-01T15:00:00Z", "2026-01-01T15:30:00Z"},
+		{"b", "2026-01-01T15:30:00Z", "2026-01-01T16:30:00Z"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("entries = %d, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].ContentID != w.id || got[i].StartTime != w.start || got[i].EndTime != w.end {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], w)
+		}
+	}
+	// Asking again a minute later must not move program boundaries.
+	again := p.schedule(from.Add(time.Minute), from.Add(2*time.Hour), 10)
+	if again[0].StartTime != got[0].StartTime {
+		t.Errorf("schedule drifted: %s vs %s", again[0].StartTime, got[0].StartTime)
+	}
+}
+
+func TestShuffleOrderIsStablePerCycle(t *testing.T) {
+	p := testPlayout()
+	p.ScheduleType = "shuffle"
+	for i := 0; i < 8; i++ {
+		p.Items = append(p.Items, PlaylistItem{ContentID: fmt.Sprint(i)})
+	}
+	a, b := p.order(3), p.order(3)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("order(3) not stable: %v vs %v", a, b)
+		}
+	}
+	if len(a) != len(p.Items) {
+		t.Errorf("order has %d entries, want %d", len(a), len(p.Items))
+	}
+}
+
+func TestRenderBumperItemFiller(t *testing.T) {
+	p := testPlayout()
+	media := func(it PlaylistItem) []mediaSegment {
+		switch it.ContentID {
+		case "bump":
+			return clip("bump", 2, 5)
+		case "a":
+			return clip("a", 200, 6) // 20 min
+		case "fill":
+			return clip("fill", 10, 6) // 1 min
+		}
+		return nil
+	}
+	segs := p.render(0, media)
+	if !segs[0].Disc || !strings.Contains(segs[0].URI, "bump") {
+		t.Fatalf("program must open with the bumper: %+v", segs[0])
+	}
+	if s := segs[2]; !s.Disc || !strings.Contains(s.URI, "/a/0.ts") || s.Offset != 10*time.Second {
+		t.Errorf("item should follow the bumper at 10s: %+v", s)
+	}
+	last := segs[len(segs)-1]
+	if !strings.Contains(last.URI, "fill") {
+		t.Errorf("program should end in filler: %+v", last)
+	}
+	if end := last.Offset + 6*time.Second; end < 30*time.Minute || last.Offset >= 30*time.Minute {
+		t.Errorf("filler should run to the slot end, last segment at %v", last.Offset)
+	}
+
+	// Unplayable item with no filler: only the bumper airs.
+	p.Filler = nil
+	segs = p.render(1, media)
+	if len(segs) != 2 {
+		t.Errorf("segments = %d, want just the bumper", len(segs))
+	}
+}
+
+func TestLivePlaylistSequenceAdvances(t *testing.T) {
+	p := testPlayout()
+	media := func(it PlaylistItem) []mediaSegment {
+		if it.ContentID == "bump" {
+			return clip("bump", 1, 10)
+		}
+		return clip(it.ContentID, 1000, 6)
+	}
+	r := newRendition(p, media)
+
+	at := p.Epoch.Add(3*90*time.Minute + 30*time.Minute + 10*time.Second) // cycle 3, start of B
+	refs := r.window(at, liveWindowSegs)
+	if len(refs) != liveWindowSegs {
+		t.Fatalf("window = %d segments", len(refs))
+	}
+	msn0, dsn0 := r.sequence(refs[0])
+	for n, ref := range refs[1:] {
+		if msn, _ := r.sequence(ref); msn != msn0+int64(n+1) {
+			t.Fatalf("sequence numbers not contiguous at %d", n+1)
+		}
+	}
+	// The window crosses from A's filler into B's bumper and item.
+	if s := r.seg(refs[len(refs)-1]); !strings.Contains(s.URI, "/b/0.ts") {
+		t.Errorf("live edge = %s, want B's first segment", s.URI)
+	}
+
+	later := r.window(at.Add(12*time.Second), liveWindowSegs)
+	msn1, dsn1 := r.sequence(later[0])
+	if msn1 != msn0+2 {
+		t.Errorf("media sequence advanced %d, want 2", msn1-msn0)
+	}
+	if dsn1 < dsn0 {
+		t.Errorf("discontinuity sequence went backwards")
+	}
+	for _, ref := range refs {
+		msn, _ := r.sequence(ref)
+		if seg, ok := r.lookup(msn); !ok || seg.URI != r.seg(ref).URI {
+			t.Errorf("lookup(%d) = %s, want %s", msn, seg.URI, r.seg(ref).URI)
+		}
+	}
+
+	out := r.livePlaylist(at)
+	if !strings.Contains(out, "#EXT-X-DISCONTINUITY\n") || !strings.Contains(out, fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", msn0)) {
+		t.Errorf("unexpected playlist:\n%s", out)
+	}
+	if !strings.Contains(out, "#EXT-X-PROGRAM-DATE-TIME:2026-01-01T17:00:00.000Z") {
+		t.Errorf("bumper for B should carry its wall-clock start:\n%s", out)
+	}
+}
+
+func TestParseVODPlaylist(t *testing.T) {
+	base, _ := url.Parse("http://cdn/vod/movie/1/index.m3u8")
+	pl, err := parseVODPlaylist(base, []byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.000,\nseg00000.ts\n#EXTINF:4.5,\nseg00001.ts\n#EXT-X-ENDLIST\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pl.segments) != 2 || pl.segments[1].URI != "http://cdn/vod/movie/1/seg00001.ts" || pl.segments[1].Duration != 4.5 {
+		t.Errorf("segments = %+v", pl.segments)
+	}
+
+	pl, err = parseVODPlaylist(base, []byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000,CODECS=\"avc1,mp4a\"\nlow.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=3000000\nhigh/index.m3u8\n"))
+	if err != nil || pl.variant != "http://cdn/vod/movie/1/high/index.m3u8" {
+		t.Errorf("variant = %q, err = %v", pl.variant, err)
+	}
+
+	if _, err := parseVODPlaylist(base, []byte("#EXTM3U\n#EXTINF:6,\na.ts\n")); err == nil {
+		t.Error("expected error for a live playlist")
+	}
+	if _, err := parseVODPlaylist(base, []byte("#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\"\n#EXTINF:6,\na.ts\n#EXT-X-ENDLIST\n")); err == nil {
+		t.Error("expected error for an encrypted playlist")
+	}
+}
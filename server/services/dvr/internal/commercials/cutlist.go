@@ -0,0 +1,141 @@
+// cutlist.go — Cut-list files and the commercial-free playlist.
+//
+// Breaks are exported in the formats players and post-processing tools
+// already read:
+//
+//   - EDL (Kodi/MPlayer/Comskip output_edl): "start<TAB>end<TAB>3" per break,
+//     seconds; action 3 marks a commercial break the player may skip.
+//   - Comskip .txt: frame ranges under a "FILE PROCESSING COMPLETE" header,
+//     as read by MCEBuddy, comchap and friends.
+//
+// CommercialFree derives a VOD playlist from the recording's playlist that
+// leaves out every segment inside a break, with an EXT-X-DISCONTINUITY at
+// each cut.
+package commercials
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// edlCommercial is the EDL action for a skippable commercial break.
+const edlCommercial = 3
+
+// WriteEDL writes breaks as an EDL cut-list.
+func WriteEDL(w io.Writer, breaks []Break) error {
+	for _, b := range breaks {
+		if _, err := fmt.Fprintf(w, "%.2f\t%.2f\t%d\n", b.Start, b.End, edlCommercial); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteComskip writes breaks in Comskip's .txt frame-list format. fps is the
+// recording's frame rate (29.97 for most North American broadcasts).
+func WriteComskip(w io.Writer, breaks []Break, duration, fps float64) error {
+	frame := func(sec float64) int64 { return int64(math.Round(sec * fps)) }
+	if _, err := fmt.Fprintf(w, "FILE PROCESSING COMPLETE %6d FRAMES AT %5d\n-------------------\n",
+		frame(duration), int(math.Round(fps*100))); err != nil {
+		return err
+	}
+	for _, b := range breaks {
+		if _, err := fmt.Fprintf(w, "%d\t%d\n", frame(b.Start)+1, frame(b.End)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PlaylistDuration sums the EXTINF durations of an HLS playlist.
+func PlaylistDuration(playlist []byte) float64 {
+	var total float64
+	sc := bufio.NewScanner(bytes.NewReader(playlist))
+	for sc.Scan() {
+		if d, ok := extinf(sc.Text()); ok {
+			total += d
+		}
+	}
+	return total
+}
+
+func extinf(line string) (float64, bool) {
+	if !strings.HasPrefix(line, "#EXTINF:") {
+		return 0, false
+	}
+	v := strings.TrimPrefix(line, "#EXTINF:")
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	d, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	return d, err == nil
+}
+
+// CommercialFree returns playlist without the segments that fall inside a
+// break (judged by each segment's midpoint), and the seconds removed.
+func CommercialFree(playlist []byte, breaks []Break) ([]byte, float64) {
+	inBreak := func(t float64) bool {
+		for _, b := range breaks {
+			if t >= b.Start && t < b.End {
+				return true
+			}
+		}
+		return false
+	}
+
+	var out bytes.Buffer
+	var pos, removed, segDur float64
+	var pending []string // tags since the last segment URI
+	cut := false
+	sc := bufio.NewScanner(bytes.NewReader(playlist))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+			continue
+		case line == "#EXT-X-ENDLIST":
+			out.WriteString(line + "\n")
+		case strings.HasPrefix(line, "#EXTINF:"):
+			segDur, _ = extinf(line)
+			pending = append(pending, line)
+		case strings.HasPrefix(line, "#EXTM3U"), strings.HasPrefix(line, "#EXT-X-VERSION"),
+			strings.HasPrefix(line, "#EXT-X-TARGETDURATION"), strings.HasPrefix(line, "#EXT-X-PLAYLIST-TYPE"):
+			out.WriteString(line + "\n")
+		case strings.HasPrefix(line, "#"):
+			pending = append(pending, line)
+		default: // segment URI
+			mid := pos + segDur/2
+			pos += segDur
+			if inBreak(mid) {
+				removed += segDur
+				cut = true
+				pending = pending[:0]
+				continue
+			}
+			if cut && !hasTag(pending, "#EXT-X-DISCONTINUITY") {
+				out.WriteString("#EXT-X-DISCONTINUITY\n")
+			}
+			cut = false
+			for _, tag := range pending {
+				out.WriteString(tag + "\n")
+			}
+			pending = pending[:0]
+			out.WriteString(line + "\n")
+		}
+	}
+	return out.Bytes(), removed
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
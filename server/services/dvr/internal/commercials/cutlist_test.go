@@ -0,0 +1,58 @@
+// cutlist_test.go — Unit tests for cut-list files and the commercial-free playlist.
+package commercials
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var testBreaks = []Break{{Start: 16, End: 32, Confidence: 0.9}}
+
+// TestWriteEDL verifies the Kodi/Comskip EDL layout.
+func TestWriteEDL(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteEDL(&buf, testBreaks); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "16.00\t32.00\t3\n"; got != want {
+		t.Errorf("EDL = %q, want %q", got, want)
+	}
+}
+
+// TestWriteComskip verifies frame numbering at the given frame rate.
+func TestWriteComskip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteComskip(&buf, testBreaks, 48, 25); err != nil {
+		t.Fatal(err)
+	}
+	want := "FILE PROCESSING COMPLETE   1200 FRAMES AT  2500\n-------------------\n401\t800\n"
+	if buf.String() != want {
+		t.Errorf("comskip =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+// TestCommercialFree verifies break segments are dropped with a
+// discontinuity at the cut and existing discontinuities kept.
+func TestCommercialFree(t *testing.T) {
+	src := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXTINF:8.000,\na.ts\n#EXTINF:8.000,\nb.ts\n#EXTINF:8.000,\nc.ts\n" +
+		"#EXTINF:8.000,\nd.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:8.000,\ne.ts\n#EXT-X-ENDLIST\n"
+	if d := PlaylistDuration([]byte(src)); d != 40 {
+		t.Errorf("duration = %v, want 40", d)
+	}
+	got, removed := CommercialFree([]byte(src), testBreaks)
+	if removed != 16 {
+		t.Errorf("removed = %v, want 16", removed)
+	}
+	text := string(got)
+	if strings.Contains(text, "c.ts") || strings.Contains(text, "d.ts") {
+		t.Errorf("break segments kept:\n%s", text)
+	}
+	if !strings.Contains(text, "b.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:8.000,\ne.ts") {
+		t.Errorf("cut not marked with a single discontinuity:\n%s", text)
+	}
+	if !strings.HasPrefix(text, "#EXTM3U\n") || !strings.HasSuffix(text, "#EXT-X-ENDLIST\n") {
+		t.Errorf("header or ENDLIST lost:\n%s", text)
+	}
+}
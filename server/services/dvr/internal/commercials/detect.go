@@ -0,0 +1,282 @@
+// detect.go — Commercial break detection over a finished recording.
+//
+// Uses the same FFmpeg method as the live detector in
+// services/ingest/internal/commercials (silencedetect + blackdetect, with
+// black frames coinciding with silence as the high-confidence case), but
+// runs once over the whole assembled playlist instead of per live segment.
+// That package is internal to the ingest module, so the parsing lives here.
+//
+// A break is not a single transition but a run of them: the spots inside a
+// break are each 10–60s long, so their boundaries appear as a chain of
+// transitions with short, spot-length gaps, while programme segments run for
+// minutes between transitions. findBreaks turns the transitions into breaks:
+//
+//   - Chain transitions whose gaps are ≤ MaxSpot
+//   - Keep chains of ≥ MinSpots spots lasting MinBreak..MaxBreak
+//   - Score each chain by transition strength and by how many gaps are close
+//     to a whole number of 5s (spots are sold in 10/15/30/60s lengths)
+//
+// PREREQUISITES: ffmpeg in PATH (the DVR image installs it).
+package commercials
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Break is a detected commercial break, in seconds from the recording start.
+type Break struct {
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Transition strengths, matching the live detector's confidences.
+const (
+	strengthSilence  = 0.75
+	strengthBlack    = 0.60
+	strengthCombined = 0.90
+)
+
+// transition is a silence and/or black-frame boundary between two pieces of
+// content.
+type transition struct {
+	at       float64
+	strength float64
+}
+
+// Params tunes break detection.
+type Params struct {
+	MinBreak      time.Duration // shortest break kept (default 45s)
+	MaxBreak      time.Duration // longest break kept (default 6m)
+	MaxSpot       time.Duration // longest gap between transitions inside a break (default 65s)
+	MinSpots      int           // fewest spots in a break (default 2)
+	MinConfidence float64       // breaks scoring below this are dropped (default 0.6)
+	EdgeSnap      time.Duration // breaks this close to either end extend to it (default 10s)
+}
+
+// DefaultParams suit broadcast recordings with 2–4 minute breaks.
+var DefaultParams = Params{
+	MinBreak:      45 * time.Second,
+	MaxBreak:      6 * time.Minute,
+	MaxSpot:       65 * time.Second,
+	MinSpots:      2,
+	MinConfidence: 0.6,
+	EdgeSnap:      10 * time.Second,
+}
+
+// Detector runs FFmpeg over recordings.
+type Detector struct {
+	ffmpegPath      string
+	params          Params
+	silenceDB       float64 // dB threshold for silence (default -35)
+	silenceDuration float64 // minimum silence in seconds (default 0.4; gaps between spots are short)
+	blackDuration   float64 // minimum black run in seconds (default 0.3)
+}
+
+// Option is a functional option for Detector.
+type Option func(*Detector)
+
+// WithParams replaces DefaultParams.
+func WithParams(p Params) Option {
+	return func(d *Detector) { d.params = p }
+}
+
+// WithSilenceDB sets the silence threshold in dB (negative value, e.g. -35).
+func WithSilenceDB(db float64) Option {
+	return func(d *Detector) { d.silenceDB = db }
+}
+
+// NewDetector creates a Detector. Pass "" to find ffmpeg in PATH.
+func NewDetector(ffmpegPath string, opts ...Option) (*Detector, error) {
+	if ffmpegPath == "" {
+		path, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			return nil, fmt.Errorf("commercial detector: ffmpeg not found in PATH: %w", err)
+		}
+		ffmpegPath = path
+	}
+	d := &Detector{
+		ffmpegPath:      ffmpegPath,
+		params:          DefaultParams,
+		silenceDB:       -35.0,
+		silenceDuration: 0.4,
+		blackDuration:   0.3,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d, nil
+}
+
+// Analyze decodes the recording at playlistPath in one FFmpeg pass and
+// returns its commercial breaks. duration is the recording length in seconds
+// (used to snap breaks to the ends); pass 0 if unknown.
+func (d *Detector) Analyze(ctx context.Context, playlistPath string, duration float64) ([]Break, error) {
+	cmd := exec.CommandContext(ctx, d.ffmpegPath,
+		"-hide_banner", "-nostats",
+		"-i", playlistPath,
+		"-af", fmt.Sprintf("silencedetect=n=%.0fdB:d=%.1f", d.silenceDB, d.silenceDuration),
+		"-vf", fmt.Sprintf("blackdetect=d=%.1f:pix_th=0.1", d.blackDuration),
+		"-f", "null", "-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// FFmpeg exits non-zero for benign reasons with -f null; only fail
+		// when it produced nothing to parse.
+		if !strings.Contains(stderr.String(), "Duration:") {
+			return nil, fmt.Errorf("ffmpeg: %w: %s", err, lastLine(stderr.String()))
+		}
+	}
+	return findBreaks(parseTransitions(stderr.String()), duration, d.params), nil
+}
+
+// parseTransitions extracts silence and black-frame transitions from FFmpeg
+// stderr. Filter timestamps are stream PTS, so captured live segments start
+// at the input's "start:" offset rather than 0; that offset is subtracted.
+//
+//	Duration: 00:30:00.00, start: 1.400000, bitrate: 4000 kb/s
+//	[silencedetect @ 0x...] silence_start: 12.345
+//	[silencedetect @ 0x...] silence_end: 14.789 | silence_duration: 2.444
+//	[blackdetect @ 0x...] black_start:12.5 black_end:13.1 black_duration:0.6
+func parseTransitions(output string) []transition {
+	var offset float64
+	var silences, blacks []transition
+	silenceStart, hasStart := 0.0, false
+
+	sc := bufio.NewScanner(strings.NewReader(output))
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.Contains(line, "Duration:") && strings.Contains(line, "start:"):
+			if v, ok := fieldAfter(line, "start:"); ok {
+				offset = v
+			}
+		case strings.Contains(line, "silence_start:"):
+			if v, ok := fieldAfter(line, "silence_start:"); ok {
+				silenceStart, hasStart = v, true
+			}
+		case strings.Contains(line, "silence_end:") && hasStart:
+			if v, ok := fieldAfter(line, "silence_end:"); ok {
+				silences = append(silences, transition{at: (silenceStart+v)/2 - offset, strength: strengthSilence})
+				hasStart = false
+			}
+		case strings.Contains(line, "black_start:"):
+			start, ok1 := fieldAfter(line, "black_start:")
+			end, ok2 := fieldAfter(line, "black_end:")
+			if ok1 && ok2 {
+				blacks = append(blacks, transition{at: (start+end)/2 - offset, strength: strengthBlack})
+			}
+		}
+	}
+	return mergeTransitions(silences, blacks)
+}
+
+// fieldAfter parses the number following key in line.
+func fieldAfter(line, key string) (float64, bool) {
+	i := strings.Index(line, key)
+	if i < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(line[i+len(key):])
+	if len(fields) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(fields[0], ","), 64)
+	return v, err == nil
+}
+
+// mergeTransitions combines silence and black-frame transitions; one of each
+// within 1s of each other becomes a single combined transition.
+func mergeTransitions(silences, blacks []transition) []transition {
+	used := make([]bool, len(blacks))
+	out := make([]transition, 0, len(silences)+len(blacks))
+	for _, s := range silences {
+		for j, b := range blacks {
+			if !used[j] && math.Abs(b.at-s.at) <= 1.0 {
+				used[j] = true
+				s.strength = strengthCombined
+				break
+			}
+		}
+		out = append(out, s)
+	}
+	for j, b := range blacks {
+		if !used[j] {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].at < out[j].at })
+	return out
+}
+
+// findBreaks groups transitions into commercial breaks.
+func findBreaks(ts []transition, duration float64, p Params) []Break {
+	var out []Break
+	flush := func(chain []transition) {
+		spots := len(chain) - 1
+		if spots < p.MinSpots {
+			return
+		}
+		start, end := chain[0].at, chain[len(chain)-1].at
+		if length := end - start; length < p.MinBreak.Seconds() || length > p.MaxBreak.Seconds() {
+			return
+		}
+		var strength float64
+		for _, t := range chain {
+			strength += t.strength
+		}
+		strength /= float64(len(chain))
+		fit := 0
+		for i := 1; i < len(chain); i++ {
+			gap := chain[i].at - chain[i-1].at
+			if math.Abs(gap-5*math.Round(gap/5)) <= 1.0 {
+				fit++
+			}
+		}
+		conf := 0.5*strength + 0.5*float64(fit)/float64(spots)
+		if conf < p.MinConfidence {
+			return
+		}
+		if start < p.EdgeSnap.Seconds() {
+			start = 0
+		}
+		if duration > 0 && duration-end < p.EdgeSnap.Seconds() {
+			end = duration
+		}
+		out = append(out, Break{Start: start, End: end, Confidence: math.Round(conf*100) / 100})
+	}
+
+	var chain []transition
+	for _, t := range ts {
+		if len(chain) > 0 && t.at-chain[len(chain)-1].at > p.MaxSpot.Seconds() {
+			flush(chain)
+			chain = chain[:0]
+		}
+		chain = append(chain, t)
+	}
+	if len(chain) > 0 {
+		flush(chain)
+	}
+	return out
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
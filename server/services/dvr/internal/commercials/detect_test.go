@@ -0,0 +1,69 @@
+// detect_test.go — Unit tests for FFmpeg output parsing and break grouping.
+package commercials
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseTransitionsOffsetAndMerge verifies PTS offsets are removed and
+// coinciding silence + black frames merge into one combined transition.
+func TestParseTransitionsOffsetAndMerge(t *testing.T) {
+	out := `Input #0, hls, from 'recording.m3u8':
+  Duration: 00:30:00.00, start: 100.000000, bitrate: N/A
+[silencedetect @ 0x1] silence_start: 110
+[silencedetect @ 0x1] silence_end: 111 | silence_duration: 1
+[blackdetect @ 0x2] black_start:110.2 black_end:110.8 black_duration:0.6
+[blackdetect @ 0x2] black_start:200 black_end:201 black_duration:1
+[silencedetect @ 0x1] silence_start: 300.5
+[silencedetect @ 0x1] silence_end: 301.5 | silence_duration: 1`
+	got := parseTransitions(out)
+	want := []transition{
+		{at: 10.5, strength: strengthCombined},
+		{at: 100.5, strength: strengthBlack},
+		{at: 201, strength: strengthSilence},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("transitions = %+v, want %+v", got, want)
+	}
+}
+
+func combined(at ...float64) []transition {
+	ts := make([]transition, len(at))
+	for i, a := range at {
+		ts[i] = transition{at: a, strength: strengthCombined}
+	}
+	return ts
+}
+
+// TestFindBreaks verifies spot-length chains become breaks and scene cuts do not.
+func TestFindBreaks(t *testing.T) {
+	var ts []transition
+	ts = append(ts, combined(600, 630, 645, 675, 705)...) // a break of 15/30s spots
+	ts = append(ts, combined(1000, 1015)...)              // one spot only
+	for _, at := range []float64{1200, 1217.3, 1241.1, 1253.7} {
+		ts = append(ts, transition{at: at, strength: strengthSilence}) // scene cuts
+	}
+	ts = append(ts, combined(1700, 1730, 1760, 1795)...) // break running into the end
+
+	got := findBreaks(ts, 1800, DefaultParams)
+	want := []Break{
+		{Start: 600, End: 705, Confidence: 0.95},
+		{Start: 1700, End: 1800, Confidence: 0.95},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("breaks = %+v, want %+v", got, want)
+	}
+}
+
+// TestFindBreaksRejectsLongChains verifies a run of transitions longer than
+// MaxBreak (e.g. a montage) is not taken for a break.
+func TestFindBreaksRejectsLongChains(t *testing.T) {
+	var at []float64
+	for s := 0.0; s <= 420; s += 30 {
+		at = append(at, 300+s)
+	}
+	if got := findBreaks(combined(at...), 0, DefaultParams); len(got) != 0 {
+		t.Errorf("breaks = %+v, want none", got)
+	}
+}
@@ -0,0 +1,184 @@
+// processor.go — Post-processing of completed recordings.
+//
+// Every ScanEvery the processor picks up complete recordings that have not
+// been scanned (dvr_recordings.commercials_scanned_at IS NULL), runs the
+// Detector over each assembled playlist, and replaces the recording's rows
+// in dvr_commercial_markers. Optionally it also writes, next to
+// recording.m3u8:
+//   - recording.edl                  — EDL cut-list (Config.WriteEDL)
+//   - recording.nocommercials.m3u8   — playlist without the breaks (Config.CommercialFree);
+//     removed when a rescan finds no breaks
+//
+// A failed scan is recorded in commercials_error and not retried; clearing
+// commercials_scanned_at queues the recording again.
+package commercials
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Files written next to the recording's playlist.
+const (
+	EDLName            = "recording.edl"
+	CommercialFreeName = "recording.nocommercials.m3u8"
+)
+
+// Config holds processor configuration.
+type Config struct {
+	ScanEvery      time.Duration // how often to look for new recordings (default 5m)
+	WriteEDL       bool
+	CommercialFree bool
+}
+
+// Processor scans completed recordings for commercial breaks.
+type Processor struct {
+	cfg Config
+	db  *sql.DB
+	det *Detector
+}
+
+// NewProcessor creates a Processor.
+func NewProcessor(cfg Config, db *sql.DB, det *Detector) *Processor {
+	if cfg.ScanEvery <= 0 {
+		cfg.ScanEvery = 5 * time.Minute
+	}
+	return &Processor{cfg: cfg, db: db, det: det}
+}
+
+// Run scans immediately, then every ScanEvery. Blocks until ctx is cancelled.
+func (p *Processor) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.ScanEvery)
+	defer ticker.Stop()
+	log.Printf("[dvr] commercial detection started, scanning every %s", p.cfg.ScanEvery)
+
+	p.RunOnce(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce scans every pending recording, oldest first.
+func (p *Processor) RunOnce(ctx context.Context) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT id, storage_path FROM dvr_recordings
+		WHERE status='complete' AND storage_path IS NOT NULL AND commercials_scanned_at IS NULL
+		ORDER BY end_time`)
+	if err != nil {
+		log.Printf("[dvr] commercials: load pending: %v", err)
+		return
+	}
+	type pending struct{ id, playlist string }
+	var recs []pending
+	for rows.Next() {
+		var rec pending
+		if err := rows.Scan(&rec.id, &rec.playlist); err == nil {
+			recs = append(recs, rec)
+		}
+	}
+	rows.Close()
+
+	for _, rec := range recs {
+		if ctx.Err() != nil {
+			return
+		}
+		breaks, err := p.process(ctx, rec.id, rec.playlist)
+		if ctx.Err() != nil {
+			return // interrupted by shutdown; rescanned on next start
+		}
+		if err != nil {
+			log.Printf("[dvr] commercials: recording %s: %v", rec.id, err)
+			_, _ = p.db.ExecContext(ctx, `
+				UPDATE dvr_recordings SET commercials_scanned_at=NOW(), commercials_error=$2 WHERE id=$1`,
+				rec.id, err.Error())
+			continue
+		}
+		log.Printf("[dvr] commercials: recording %s: %d break(s)", rec.id, len(breaks))
+	}
+}
+
+// process detects breaks in one recording, writes the optional files and
+// stores the markers.
+func (p *Processor) process(ctx context.Context, id, playlistPath string) ([]Break, error) {
+	playlist, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return nil, err
+	}
+	duration := PlaylistDuration(playlist)
+	breaks, err := p.det.Analyze(ctx, playlistPath, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(playlistPath)
+	if p.cfg.WriteEDL {
+		var buf bytes.Buffer
+		_ = WriteEDL(&buf, breaks)
+		if err := os.WriteFile(filepath.Join(dir, EDLName), buf.Bytes(), 0o644); err != nil {
+			return nil, err
+		}
+	}
+	freePath := filepath.Join(dir, CommercialFreeName)
+	if p.cfg.CommercialFree && len(breaks) > 0 {
+		derived, _ := CommercialFree(playlist, breaks)
+		if err := os.WriteFile(freePath, derived, 0o644); err != nil {
+			return nil, err
+		}
+	} else if len(breaks) == 0 {
+		// A rescan that finds no breaks must not leave an earlier cut behind.
+		if err := os.Remove(freePath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM dvr_commercial_markers WHERE recording_id=$1`, id); err != nil {
+		return nil, err
+	}
+	for _, b := range breaks {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO dvr_commercial_markers (recording_id, start_seconds, end_seconds, confidence)
+			VALUES ($1, $2, $3, $4)`, id, b.Start, b.End, b.Confidence); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE dvr_recordings SET commercials_scanned_at=NOW(), commercials_error=NULL WHERE id=$1`, id); err != nil {
+		return nil, err
+	}
+	return breaks, tx.Commit()
+}
+
+// Markers returns a recording's stored breaks, in order.
+func Markers(ctx context.Context, db *sql.DB, recordingID string) ([]Break, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT start_seconds, end_seconds, confidence FROM dvr_commercial_markers
+		WHERE recording_id=$1 ORDER BY start_seconds`, recordingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	breaks := []Break{}
+	for rows.Next() {
+		var b Break
+		if err := rows.Scan(&b.Start, &b.End, &b.Confidence); err != nil {
+			return nil, err
+		}
+		breaks = append(breaks, b)
+	}
+	return breaks, rows.Err()
+}
@@ -0,0 +1,94 @@
+// manifest.go — Persisted capture progress for a recording.
+//
+// Each recording's scratch directory holds manifest.txt: one copied segment
+// filename per line, in capture order, synced as each segment lands. A line
+// reading "#GAP" marks a point where capture was interrupted and resumed
+// (service restart or lease takeover); the playlist gets an
+// EXT-X-DISCONTINUITY there and the recording is finalized as partial.
+package scheduler
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	manifestName = "manifest.txt"
+	gapMarker    = "#GAP"
+)
+
+// manifest appends capture progress to a recording's scratch directory.
+type manifest struct {
+	f *os.File
+}
+
+// openManifest opens (creating if needed) the manifest in dir and returns the
+// entries already recorded, gap markers included.
+func openManifest(dir string) (*manifest, []string, error) {
+	path := filepath.Join(dir, manifestName)
+	entries, err := readManifest(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &manifest{f: f}, entries, nil
+}
+
+// readManifest returns the entries of the manifest at path. A torn final line
+// (crash mid-write) is dropped.
+func readManifest(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := string(data)
+	if i := strings.LastIndexByte(text, '\n'); i < len(text)-1 {
+		text = text[:i+1]
+	}
+	var entries []string
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, sc.Err()
+}
+
+// add records a copied segment.
+func (m *manifest) add(segment string) error {
+	return m.write(segment)
+}
+
+// gap records an interruption.
+func (m *manifest) gap() error {
+	return m.write(gapMarker)
+}
+
+func (m *manifest) write(line string) error {
+	if _, err := m.f.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	return m.f.Sync()
+}
+
+func (m *manifest) Close() error {
+	return m.f.Close()
+}
+
+// manifestSegments splits entries into segment names and the number of gaps.
+func manifestSegments(entries []string) (segments []string, gaps int) {
+	for _, e := range entries {
+		if e == gapMarker {
+			gaps++
+			continue
+		}
+		segments = append(segments, e)
+	}
+	return segments, gaps
+}
@@ -0,0 +1,76 @@
+// manifest_test.go — Unit tests for capture manifests and resumed playlists.
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestManifestSurvivesReopen verifies entries written by one capture are seen by the next.
+func TestManifestSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	m, entries, err := openManifest(dir)
+	if err != nil {
+		t.Fatalf("openManifest: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("new manifest has entries: %v", entries)
+	}
+	for _, seg := range []string{"seg001.ts", "seg002.ts"} {
+		if err := m.add(seg); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	m.Close()
+
+	m, entries, err = openManifest(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer m.Close()
+	if want := []string{"seg001.ts", "seg002.ts"}; !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %v, want %v", entries, want)
+	}
+}
+
+// TestManifestDropsTornLine verifies a partially written last line is ignored.
+func TestManifestDropsTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), manifestName)
+	if err := os.WriteFile(path, []byte("seg001.ts\n#GAP\nseg0"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readManifest(path)
+	if err != nil {
+		t.Fatalf("readManifest: %v", err)
+	}
+	if want := []string{"seg001.ts", gapMarker}; !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %v, want %v", entries, want)
+	}
+	segs, gaps := manifestSegments(entries)
+	if len(segs) != 1 || gaps != 1 {
+		t.Errorf("manifestSegments = %v, %d gaps; want 1 segment, 1 gap", segs, gaps)
+	}
+}
+
+// TestPlaylistMarksGaps verifies gaps become discontinuities, except before the first segment.
+func TestPlaylistMarksGaps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.m3u8")
+	entries := []string{gapMarker, "a.ts", "b.ts", gapMarker, "c.ts", gapMarker}
+	if err := generateVODPlaylist(path, entries); err != nil {
+		t.Fatalf("generateVODPlaylist: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	text := string(data)
+	if n := strings.Count(text, "#EXT-X-DISCONTINUITY"); n != 1 {
+		t.Errorf("got %d discontinuities, want 1:\n%s", n, text)
+	}
+	if !strings.Contains(text, "b.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:8.000,\nc.ts") {
+		t.Errorf("discontinuity not between b.ts and c.ts:\n%s", text)
+	}
+	if !strings.HasSuffix(text, "#EXT-X-ENDLIST\n") {
+		t.Errorf("playlist not terminated:\n%s", text)
+	}
+}
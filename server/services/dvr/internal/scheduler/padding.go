@@ -0,0 +1,74 @@
+// padding.go — Early-start and late-end rules for recordings.
+//
+// A recording captures [StartTime - PaddingBefore, end + PaddingAfter), where
+// end starts as EndTime and can only move later:
+//   - FollowEPG:    end follows the EPG programme's end_time when it moves
+//   - SportsExtend: while a sports event mapped to the channel is live or at
+//     halftime, end stays at least sportsExtendStep ahead of now
+//
+// Extensions never go more than Config.MaxExtension past EndTime.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// sportsExtendStep is how far past now a live sports event keeps the
+// recording running. The end is re-evaluated every Config.EndCheckEvery.
+const sportsExtendStep = 15 * time.Minute
+
+// EndSignals are the live inputs that can move a recording's end.
+type EndSignals struct {
+	EPGEnd     time.Time // current end_time of the recording's programme (zero = unknown)
+	SportsLive bool      // a sports event on the channel is still in play
+}
+
+// CaptureStart returns when capture of rec begins.
+func CaptureStart(rec Recording) time.Time {
+	return rec.StartTime.Add(-rec.PaddingBefore)
+}
+
+// CaptureEnd returns when capture of rec stops, given the signals observed at now.
+func CaptureEnd(rec Recording, sig EndSignals, now time.Time, maxExtension time.Duration) time.Time {
+	end := rec.EndTime
+	if rec.FollowEPG && sig.EPGEnd.After(end) {
+		end = sig.EPGEnd
+	}
+	if rec.SportsExtend && sig.SportsLive {
+		if live := now.Add(sportsExtendStep); live.After(end) {
+			end = live
+		}
+	}
+	if limit := rec.EndTime.Add(maxExtension); end.After(limit) {
+		end = limit
+	}
+	return end.Add(rec.PaddingAfter)
+}
+
+// endSignals reads the EPG and sports state for rec. Lookup failures leave the
+// corresponding signal unset, so the recording falls back to its scheduled end.
+func (s *Scheduler) endSignals(ctx context.Context, rec Recording) EndSignals {
+	var sig EndSignals
+	if rec.FollowEPG && rec.ProgramID != "" {
+		var end time.Time
+		if err := s.db.QueryRowContext(ctx,
+			`SELECT end_time FROM programs WHERE id = $1`, rec.ProgramID).Scan(&end); err == nil {
+			sig.EPGEnd = end
+		}
+	}
+	if rec.SportsExtend {
+		var live sql.NullBool
+		_ = s.db.QueryRowContext(ctx, `
+			SELECT EXISTS(
+				SELECT 1 FROM sports_channel_mappings cm
+				JOIN sports_events se ON se.id = cm.event_id
+				WHERE cm.channel_id = $1
+				  AND se.status IN ('live', 'halftime')
+				  AND cm.start_time < $3 AND cm.end_time > $2)`,
+			rec.ChannelID, rec.StartTime, rec.EndTime).Scan(&live)
+		sig.SportsLive = live.Valid && live.Bool
+	}
+	return sig
+}
@@ -0,0 +1,66 @@
+// padding_test.go — Unit tests for recording start/end rules.
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func paddedRec() Recording {
+	start := time.Date(2026, 3, 1, 19, 0, 0, 0, time.UTC)
+	return Recording{
+		StartTime:     start,
+		EndTime:       start.Add(3 * time.Hour),
+		PaddingBefore: 5 * time.Minute,
+		PaddingAfter:  10 * time.Minute,
+	}
+}
+
+// TestCapturePadding verifies padding widens the capture window.
+func TestCapturePadding(t *testing.T) {
+	rec := paddedRec()
+	if got, want := CaptureStart(rec), rec.StartTime.Add(-5*time.Minute); !got.Equal(want) {
+		t.Errorf("CaptureStart = %s, want %s", got, want)
+	}
+	// Signals are ignored when the recording has not opted in.
+	sig := EndSignals{EPGEnd: rec.EndTime.Add(time.Hour), SportsLive: true}
+	if got, want := CaptureEnd(rec, sig, rec.EndTime, 3*time.Hour), rec.EndTime.Add(10*time.Minute); !got.Equal(want) {
+		t.Errorf("CaptureEnd = %s, want %s", got, want)
+	}
+}
+
+// TestCaptureEndFollowsEPG verifies the end moves later with the programme, never earlier.
+func TestCaptureEndFollowsEPG(t *testing.T) {
+	rec := paddedRec()
+	rec.FollowEPG = true
+	later := rec.EndTime.Add(40 * time.Minute)
+	if got, want := CaptureEnd(rec, EndSignals{EPGEnd: later}, rec.StartTime, 3*time.Hour), later.Add(10*time.Minute); !got.Equal(want) {
+		t.Errorf("overrun: CaptureEnd = %s, want %s", got, want)
+	}
+	earlier := rec.EndTime.Add(-30 * time.Minute)
+	if got, want := CaptureEnd(rec, EndSignals{EPGEnd: earlier}, rec.StartTime, 3*time.Hour), rec.EndTime.Add(10*time.Minute); !got.Equal(want) {
+		t.Errorf("early finish: CaptureEnd = %s, want %s", got, want)
+	}
+}
+
+// TestCaptureEndSportsExtend verifies a live game keeps the recording running, up to the cap.
+func TestCaptureEndSportsExtend(t *testing.T) {
+	rec := paddedRec()
+	rec.SportsExtend = true
+	now := rec.EndTime.Add(20 * time.Minute)
+
+	got := CaptureEnd(rec, EndSignals{SportsLive: true}, now, 3*time.Hour)
+	if want := now.Add(sportsExtendStep + 10*time.Minute); !got.Equal(want) {
+		t.Errorf("live: CaptureEnd = %s, want %s", got, want)
+	}
+	// Game over: the end drops back to the schedule, so capture stops at the next check.
+	got = CaptureEnd(rec, EndSignals{}, now, 3*time.Hour)
+	if want := rec.EndTime.Add(10 * time.Minute); !got.Equal(want) {
+		t.Errorf("final: CaptureEnd = %s, want %s", got, want)
+	}
+	// Extensions stop at MaxExtension past the scheduled end.
+	got = CaptureEnd(rec, EndSignals{SportsLive: true}, rec.EndTime.Add(5*time.Hour), time.Hour)
+	if want := rec.EndTime.Add(time.Hour + 10*time.Minute); !got.Equal(want) {
+		t.Errorf("capped: CaptureEnd = %s, want %s", got, want)
+	}
+}
@@ -3,11 +3,22 @@
 // by capturing live HLS segments, then marks them complete and uploads to storage.
 //
 // Design:
-//   - Poll every 30 seconds for recordings with start_time ≤ now AND status='scheduled'
+//   - Poll every 30 seconds for recordings with start_time - padding_before ≤ now
+//     AND status='scheduled'
 //   - Spawn a capture goroutine per recording (copies segments from ingest's segment dir)
-//   - At end_time, concatenate segments into a VOD HLS playlist
+//   - At end_time + padding_after (later if follow_epg / sports_extend moved the
+//     end, see padding.go), concatenate segments into a VOD HLS playlist
 //   - Upload to object storage (Hetzner Object Storage / S3-compatible)
 //   - Update status to 'complete' with storage_path + file_size_bytes
+//
+// Restarts:
+//   - Copied segments are listed in a manifest in the scratch directory as they
+//     land (manifest.go), so progress survives the process
+//   - The capturing instance holds a lease on the row (lease_owner /
+//     lease_expires_at) and renews it every LeaseTTL/3
+//   - Each poll reclaims 'recording' rows with an expired lease: capture resumes
+//     after a gap marker, or, if the window has closed, the recording is
+//     finalized from its manifest and flagged is_partial
 package scheduler
 
 import (
@@ -15,6 +26,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -38,6 +50,12 @@ type Recording struct {
 	StartTime    time.Time
 	EndTime      time.Time
 	Status       string
+
+	ProgramID     string // programs.id; "" for manual recordings
+	PaddingBefore time.Duration
+	PaddingAfter  time.Duration
+	FollowEPG     bool
+	SportsExtend  bool
 }
 
 // QuotaInfo represents a subscriber's DVR quota usage.
@@ -65,6 +83,12 @@ type Config struct {
 	DVRDir     string // local scratch for DVR captures
 	StorageDir string // upload destination (local or S3 path prefix)
 	PollEvery  time.Duration
+
+	MaxExtension  time.Duration // cap on follow_epg / sports_extend past end_time (default 3h)
+	EndCheckEvery time.Duration // how often an extendable recording re-checks its end (default 1m)
+
+	Owner    string        // lease owner ID for this instance (default hostname:pid)
+	LeaseTTL time.Duration // how long a capture lease lasts without a heartbeat (default 90s)
 }
 
 // Scheduler watches for pending recordings and executes them.
@@ -73,10 +97,24 @@ type Scheduler struct {
 	db      *sql.DB
 	mu      sync.Mutex
 	active  map[string]context.CancelFunc // recording ID → cancel
+	wg      sync.WaitGroup                // running captures / finalizations
 }
 
 // New creates a Scheduler.
 func New(cfg Config, db *sql.DB) *Scheduler {
+	if cfg.MaxExtension <= 0 {
+		cfg.MaxExtension = 3 * time.Hour
+	}
+	if cfg.EndCheckEvery <= 0 {
+		cfg.EndCheckEvery = time.Minute
+	}
+	if cfg.Owner == "" {
+		host, _ := os.Hostname()
+		cfg.Owner = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = 90 * time.Second
+	}
 	return &Scheduler{
 		cfg:    cfg,
 		db:     db,
@@ -84,15 +122,18 @@ func New(cfg Config, db *sql.DB) *Scheduler {
 	}
 }
 
-// Run starts the scheduler's poll loop. Blocks until ctx is cancelled.
+// Run starts the scheduler's poll loop. Blocks until ctx is cancelled and every
+// capture has stopped (releasing its lease so the next start resumes it).
 func (s *Scheduler) Run(ctx context.Context) {
 	ticker := time.NewTicker(s.cfg.PollEvery)
 	defer ticker.Stop()
-	log.Printf("[dvr] scheduler started, polling every %s", s.cfg.PollEvery)
+	log.Printf("[dvr] scheduler started as %s, polling every %s", s.cfg.Owner, s.cfg.PollEvery)
+	s.reclaim(ctx)
 	for {
 		select {
 		case <-ctx.Done():
 			s.cancelAll()
+			s.wg.Wait()
 			return
 		case <-ticker.C:
 			s.poll(ctx)
@@ -100,13 +141,36 @@ func (s *Scheduler) Run(ctx context.Context) {
 	}
 }
 
-// poll queries for newly due recordings and launches capture goroutines.
+// recordingColumns selects the Recording fields; pair with scanRecording.
+const recordingColumns = `
+		r.id, r.subscriber_id, r.channel_id, c.slug, r.title, r.start_time, r.end_time,
+		COALESCE(r.program_id::text, ''), r.padding_before_secs, r.padding_after_secs,
+		r.follow_epg, r.sports_extend`
+
+func scanRecording(rows *sql.Rows) (Recording, error) {
+	var rec Recording
+	var padBefore, padAfter int
+	if err := rows.Scan(&rec.ID, &rec.SubscriberID, &rec.ChannelID, &rec.ChannelSlug,
+		&rec.Title, &rec.StartTime, &rec.EndTime,
+		&rec.ProgramID, &padBefore, &padAfter, &rec.FollowEPG, &rec.SportsExtend); err != nil {
+		return rec, err
+	}
+	rec.PaddingBefore = time.Duration(padBefore) * time.Second
+	rec.PaddingAfter = time.Duration(padAfter) * time.Second
+	return rec, nil
+}
+
+// poll reclaims orphaned recordings, then queries for newly due recordings and
+// launches capture goroutines.
 func (s *Scheduler) poll(ctx context.Context) {
+	s.reclaim(ctx)
+
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT r.id, r.subscriber_id, r.channel_id, c.slug, r.title, r.start_time, r.end_time
+		SELECT`+recordingColumns+`
 		FROM dvr_recordings r
 		JOIN channels c ON c.id = r.channel_id
-		WHERE r.status = 'scheduled' AND r.start_time <= NOW()
+		WHERE r.status = 'scheduled'
+		  AND r.start_time - make_interval(secs => r.padding_before_secs) <= NOW()
 		ORDER BY r.start_time ASC
 		LIMIT 20`)
 	if err != nil {
@@ -116,89 +180,267 @@ func (s *Scheduler) poll(ctx context.Context) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var rec Recording
-		if err := rows.Scan(&rec.ID, &rec.SubscriberID, &rec.ChannelID, &rec.ChannelSlug,
-			&rec.Title, &rec.StartTime, &rec.EndTime); err != nil {
+		rec, err := scanRecording(rows)
+		if err != nil {
 			continue
 		}
-		s.mu.Lock()
-		_, alreadyActive := s.active[rec.ID]
-		s.mu.Unlock()
-		if alreadyActive {
+		if s.isActive(rec.ID) {
 			continue
 		}
 		s.startCapture(ctx, rec)
 	}
 }
 
+func (s *Scheduler) isActive(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.active[id]
+	return ok
+}
+
 // startCapture claims a recording and launches its capture goroutine.
 func (s *Scheduler) startCapture(ctx context.Context, rec Recording) {
 	// Claim atomically: update status to 'recording' only if still 'scheduled'.
 	res, err := s.db.ExecContext(ctx, `
-		UPDATE dvr_recordings SET status='recording', updated_at=NOW()
-		WHERE id=$1 AND status='scheduled'`, rec.ID)
+		UPDATE dvr_recordings
+		SET status='recording', lease_owner=$2, lease_expires_at=NOW() + make_interval(secs => $3),
+		    updated_at=NOW()
+		WHERE id=$1 AND status='scheduled'`, rec.ID, s.cfg.Owner, s.cfg.LeaseTTL.Seconds())
 	if err != nil {
 		return
 	}
 	if n, _ := res.RowsAffected(); n == 0 {
 		return // another process claimed it
 	}
+	s.launch(ctx, rec, false)
+}
 
-	recCtx, cancel := context.WithDeadline(ctx, rec.EndTime.Add(30*time.Second))
+// reclaim takes over 'recording' rows whose lease has expired — their owner
+// crashed or was restarted. A recording whose window is still open resumes
+// capture after a gap marker; one that has already ended is finalized from
+// whatever its manifest holds.
+func (s *Scheduler) reclaim(ctx context.Context) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT`+recordingColumns+`
+		FROM dvr_recordings r
+		JOIN channels c ON c.id = r.channel_id
+		WHERE r.status = 'recording'
+		  AND (r.lease_expires_at IS NULL OR r.lease_expires_at < NOW())
+		LIMIT 20`)
+	if err != nil {
+		log.Printf("[dvr] reclaim query error: %v", err)
+		return
+	}
+	var orphans []Recording
+	for rows.Next() {
+		if rec, err := scanRecording(rows); err == nil && !s.isActive(rec.ID) {
+			orphans = append(orphans, rec)
+		}
+	}
+	rows.Close()
+
+	for _, rec := range orphans {
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE dvr_recordings
+			SET lease_owner=$2, lease_expires_at=NOW() + make_interval(secs => $3), updated_at=NOW()
+			WHERE id=$1 AND status='recording'
+			  AND (lease_expires_at IS NULL OR lease_expires_at < NOW())`,
+			rec.ID, s.cfg.Owner, s.cfg.LeaseTTL.Seconds())
+		if err != nil {
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue // another instance got there first
+		}
+
+		var sig EndSignals
+		if rec.FollowEPG || rec.SportsExtend {
+			sig = s.endSignals(ctx, rec)
+		}
+		if time.Now().Before(CaptureEnd(rec, sig, time.Now(), s.cfg.MaxExtension)) {
+			log.Printf("[dvr] resuming orphaned recording %s", rec.ID)
+			s.launch(ctx, rec, true)
+			continue
+		}
+		log.Printf("[dvr] finalizing orphaned recording %s after its end time", rec.ID)
+		s.track(rec.ID, func() {}, func() { s.finalizeOrphan(rec) })
+	}
+}
+
+// launch runs capture for a claimed recording in its own goroutine.
+func (s *Scheduler) launch(ctx context.Context, rec Recording, resume bool) {
+	// Backstop only: capture stops itself at CaptureEnd, which never passes
+	// EndTime + MaxExtension + PaddingAfter.
+	deadline := rec.EndTime.Add(s.cfg.MaxExtension + rec.PaddingAfter + 30*time.Second)
+	recCtx, cancel := context.WithDeadline(ctx, deadline)
+	s.track(rec.ID, cancel, func() {
+		err := s.capture(recCtx, rec, resume)
+		switch {
+		case err == nil:
+		case errors.Is(err, errCaptureInterrupted), errors.Is(err, errLeaseLost):
+			log.Printf("[dvr] capture of %s stopped: %v", rec.ID, err)
+		default:
+			s.fail(rec, err)
+		}
+	})
+}
+
+// track runs fn in a goroutine registered in s.active under id until it returns.
+func (s *Scheduler) track(id string, cancel context.CancelFunc, fn func()) {
 	s.mu.Lock()
-	s.active[rec.ID] = cancel
+	s.active[id] = cancel
 	s.mu.Unlock()
-
+	s.wg.Add(1)
 	go func() {
 		defer func() {
 			cancel()
 			s.mu.Lock()
-			delete(s.active, rec.ID)
+			delete(s.active, id)
 			s.mu.Unlock()
+			s.wg.Done()
 		}()
-		if err := s.capture(recCtx, rec); err != nil {
-			log.Printf("[dvr] capture failed for %s: %v", rec.ID, err)
-			_, _ = s.db.ExecContext(context.Background(), `
-				UPDATE dvr_recordings SET status='failed', updated_at=NOW() WHERE id=$1`, rec.ID)
-		}
+		fn()
 	}()
 }
 
+// finalizeOrphan completes a reclaimed recording whose window has closed.
+func (s *Scheduler) finalizeOrphan(rec Recording) {
+	scratchDir := filepath.Join(s.cfg.DVRDir, rec.ID)
+	entries, err := readManifest(filepath.Join(scratchDir, manifestName))
+	if err != nil && !os.IsNotExist(err) {
+		s.fail(rec, fmt.Errorf("read manifest: %w", err))
+		return
+	}
+	// The service was down until after the end: the tail is missing.
+	entries = append(entries, gapMarker)
+	if err := s.finalize(rec, scratchDir, entries); err != nil {
+		s.fail(rec, err)
+	}
+}
+
+// fail marks a recording failed and discards its scratch directory.
+func (s *Scheduler) fail(rec Recording, err error) {
+	log.Printf("[dvr] capture failed for %s: %v", rec.ID, err)
+	_, _ = s.db.ExecContext(context.Background(), `
+		UPDATE dvr_recordings SET status='failed', lease_owner=NULL, lease_expires_at=NULL, updated_at=NOW()
+		WHERE id=$1`, rec.ID)
+	_ = os.RemoveAll(filepath.Join(s.cfg.DVRDir, rec.ID))
+}
+
+var (
+	// errCaptureInterrupted: the service is shutting down. The row stays in
+	// 'recording' with its scratch directory so the next start resumes it.
+	errCaptureInterrupted = errors.New("interrupted by shutdown")
+	// errLeaseLost: another instance took the recording over.
+	errLeaseLost = errors.New("lease lost to another instance")
+)
+
+// renewLease extends this instance's lease on a recording. It returns false
+// when the lease now belongs to someone else.
+func (s *Scheduler) renewLease(ctx context.Context, id string) bool {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE dvr_recordings SET lease_expires_at=NOW() + make_interval(secs => $3)
+		WHERE id=$1 AND status='recording' AND lease_owner=$2`,
+		id, s.cfg.Owner, s.cfg.LeaseTTL.Seconds())
+	if err != nil {
+		return true // database hiccup: keep capturing, retry next heartbeat
+	}
+	n, _ := res.RowsAffected()
+	return n > 0
+}
+
+// releaseLease expires this instance's lease so a restart reclaims the
+// recording immediately instead of waiting out the TTL.
+func (s *Scheduler) releaseLease(id string) {
+	_, _ = s.db.ExecContext(context.Background(), `
+		UPDATE dvr_recordings SET lease_expires_at=NOW()
+		WHERE id=$1 AND status='recording' AND lease_owner=$2`, id, s.cfg.Owner)
+}
+
 // capture copies HLS segments produced by the ingest service for the duration
-// of a recording, then assembles them into a VOD HLS playlist.
-func (s *Scheduler) capture(ctx context.Context, rec Recording) error {
+// of a recording, then assembles them into a VOD HLS playlist. Progress goes to
+// the scratch directory's manifest so a resumed capture (resume = true) keeps
+// what was already copied.
+func (s *Scheduler) capture(ctx context.Context, rec Recording, resume bool) error {
 	scratchDir := filepath.Join(s.cfg.DVRDir, rec.ID)
 	if err := os.MkdirAll(scratchDir, 0o755); err != nil {
 		return fmt.Errorf("mkdir scratch: %w", err)
 	}
-	defer os.RemoveAll(scratchDir)
+	m, entries, err := openManifest(scratchDir)
+	if err != nil {
+		return fmt.Errorf("open manifest: %w", err)
+	}
+	defer m.Close()
+
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		seen[e] = true
+	}
+	if resume {
+		if err := m.gap(); err != nil {
+			return fmt.Errorf("write manifest: %w", err)
+		}
+		entries = append(entries, gapMarker)
+	}
 
 	channelSegDir := filepath.Join(s.cfg.SegmentDir, rec.ChannelSlug)
-	seen := make(map[string]bool)
+	copyNew := func() error {
+		segments, _ := filepath.Glob(filepath.Join(channelSegDir, "*.ts"))
+		sort.Strings(segments)
+		for _, seg := range segments {
+			name := filepath.Base(seg)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if err := copyFile(seg, filepath.Join(scratchDir, name)); err != nil {
+				continue
+			}
+			if err := m.add(name); err != nil {
+				return fmt.Errorf("write manifest: %w", err)
+			}
+			entries = append(entries, name)
+		}
+		return nil
+	}
+
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
-	var copiedSegments []string
+	extendable := rec.FollowEPG || rec.SportsExtend
+	var sig EndSignals
+	if extendable {
+		sig = s.endSignals(ctx, rec)
+	}
+	endAt := CaptureEnd(rec, sig, time.Now(), s.cfg.MaxExtension)
+	nextEndCheck := time.Now().Add(s.cfg.EndCheckEvery)
+	nextHeartbeat := time.Now().Add(s.cfg.LeaseTTL / 3)
 
-	// Capture loop: poll segment directory until end_time.
+	// Capture loop: poll segment directory until endAt.
 	for {
 		select {
 		case <-ctx.Done():
-			// Normal end: ctx deadline = rec.EndTime + 30s
+			if errors.Is(ctx.Err(), context.Canceled) {
+				s.releaseLease(rec.ID)
+				return errCaptureInterrupted
+			}
+			// Backstop deadline: finalize what we have.
 		case <-ticker.C:
-			segments, _ := filepath.Glob(filepath.Join(channelSegDir, "*.ts"))
-			sort.Strings(segments)
-			for _, seg := range segments {
-				if seen[seg] {
-					continue
-				}
-				seen[seg] = true
-				dst := filepath.Join(scratchDir, filepath.Base(seg))
-				if err := copyFile(seg, dst); err == nil {
-					copiedSegments = append(copiedSegments, filepath.Base(seg))
+			if err := copyNew(); err != nil {
+				return err
+			}
+			now := time.Now()
+			if !now.Before(nextHeartbeat) {
+				nextHeartbeat = now.Add(s.cfg.LeaseTTL / 3)
+				if !s.renewLease(ctx, rec.ID) {
+					return errLeaseLost
 				}
 			}
-			if time.Now().After(rec.EndTime) {
+			if extendable && !now.Before(nextEndCheck) {
+				nextEndCheck = now.Add(s.cfg.EndCheckEvery)
+				endAt = s.updateEnd(ctx, rec, endAt, now)
+			}
+			if now.After(endAt) {
 				goto done
 			}
 			continue
@@ -207,25 +449,24 @@ func (s *Scheduler) capture(ctx context.Context, rec Recording) error {
 	}
 done:
 	// Final segment sweep after end time
-	segments, _ := filepath.Glob(filepath.Join(channelSegDir, "*.ts"))
-	sort.Strings(segments)
-	for _, seg := range segments {
-		if !seen[seg] {
-			seen[seg] = true
-			dst := filepath.Join(scratchDir, filepath.Base(seg))
-			if err := copyFile(seg, dst); err == nil {
-				copiedSegments = append(copiedSegments, filepath.Base(seg))
-			}
-		}
+	if err := copyNew(); err != nil {
+		return err
 	}
+	return s.finalize(rec, scratchDir, entries)
+}
 
+// finalize assembles the captured segments into a VOD playlist, moves them to
+// storage and marks the recording complete. A recording with gaps in its
+// manifest is flagged is_partial.
+func (s *Scheduler) finalize(rec Recording, scratchDir string, entries []string) error {
+	copiedSegments, gaps := manifestSegments(entries)
 	if len(copiedSegments) == 0 {
 		return fmt.Errorf("no segments captured for recording %s", rec.ID)
 	}
 
 	// Generate VOD HLS playlist
 	playlistPath := filepath.Join(scratchDir, "recording.m3u8")
-	if err := generateVODPlaylist(playlistPath, copiedSegments, scratchDir); err != nil {
+	if err := generateVODPlaylist(playlistPath, entries); err != nil {
 		return fmt.Errorf("playlist generation: %w", err)
 	}
 
@@ -265,17 +506,41 @@ done:
 	// Mark complete in DB
 	_, err := s.db.ExecContext(context.Background(), `
 		UPDATE dvr_recordings
-		SET status='complete', storage_path=$2, file_size_bytes=$3, updated_at=NOW()
-		WHERE id=$1`, rec.ID, finalPlaylist, totalBytes)
+		SET status='complete', storage_path=$2, file_size_bytes=$3,
+		    is_partial=$4, capture_gaps=$5, lease_owner=NULL, lease_expires_at=NULL, updated_at=NOW()
+		WHERE id=$1`, rec.ID, finalPlaylist, totalBytes, gaps > 0, gaps)
 	if err != nil {
 		return fmt.Errorf("db update complete: %w", err)
 	}
+	_ = os.RemoveAll(scratchDir)
 
-	log.Printf("[dvr] recording %s complete: %d segments, %.2f MB",
-		rec.ID, len(copiedSegments), float64(totalBytes)/(1024*1024))
+	log.Printf("[dvr] recording %s complete: %d segments, %.2f MB, %d gap(s)",
+		rec.ID, len(copiedSegments), float64(totalBytes)/(1024*1024), gaps)
 	return nil
 }
 
+// updateEnd re-evaluates an extendable recording's end and records any move
+// past the scheduled end in dvr_recordings.extended_end_time.
+func (s *Scheduler) updateEnd(ctx context.Context, rec Recording, endAt, now time.Time) time.Time {
+	next := CaptureEnd(rec, s.endSignals(ctx, rec), now, s.cfg.MaxExtension)
+	if next.Equal(endAt) {
+		return endAt
+	}
+	// A live game rolls the end forward on every check; only log real jumps.
+	if shift := next.Sub(endAt); shift > 2*s.cfg.EndCheckEvery || shift < 0 {
+		log.Printf("[dvr] recording %s end moved %s → %s", rec.ID,
+			endAt.Format(time.RFC3339), next.Format(time.RFC3339))
+	}
+	var extended interface{}
+	if base := rec.EndTime.Add(rec.PaddingAfter); next.After(base) {
+		extended = next
+	}
+	_, _ = s.db.ExecContext(ctx, `
+		UPDATE dvr_recordings SET extended_end_time=$2, updated_at=NOW() WHERE id=$1`,
+		rec.ID, extended)
+	return next
+}
+
 // cancelAll cancels all active captures (called on shutdown).
 func (s *Scheduler) cancelAll() {
 	s.mu.Lock()
@@ -285,9 +550,9 @@ func (s *Scheduler) cancelAll() {
 	}
 }
 
-// generateVODPlaylist writes a HLS VOD playlist from a list of .ts filenames.
-func generateVODPlaylist(path string, segments []string, segDir string) error {
-	sort.Strings(segments)
+// generateVODPlaylist writes a HLS VOD playlist from manifest entries: .ts
+// filenames in capture order, with gap markers becoming discontinuities.
+func generateVODPlaylist(path string, entries []string) error {
 	f, err := os.Create(path)
 	if err != nil {
 		return err
@@ -299,9 +564,20 @@ func generateVODPlaylist(path string, segments []string, segDir string) error {
 	fmt.Fprintln(w, "#EXT-X-VERSION:3")
 	fmt.Fprintln(w, "#EXT-X-TARGETDURATION:10")
 	fmt.Fprintln(w, "#EXT-X-PLAYLIST-TYPE:VOD")
-	for _, seg := range segments {
+	pendingGap := false
+	written := 0
+	for _, e := range entries {
+		if e == gapMarker {
+			pendingGap = written > 0
+			continue
+		}
+		if pendingGap {
+			fmt.Fprintln(w, "#EXT-X-DISCONTINUITY")
+			pendingGap = false
+		}
 		fmt.Fprintln(w, "#EXTINF:8.000,")
-		fmt.Fprintln(w, seg)
+		fmt.Fprintln(w, e)
+		written++
 	}
 	fmt.Fprintln(w, "#EXT-X-ENDLIST")
 	return w.Flush()
@@ -349,7 +625,8 @@ func GetQuota(ctx context.Context, db *sql.DB, subscriberID string) (*QuotaInfo,
 
 	var usedHours float64
 	err := db.QueryRowContext(ctx, `
-		SELECT COALESCE(ROUND(SUM(EXTRACT(EPOCH FROM (end_time - start_time)) / 3600)::NUMERIC, 2), 0)
+		SELECT COALESCE(ROUND(SUM((EXTRACT(EPOCH FROM (end_time - start_time))
+		                           + padding_before_secs + padding_after_secs) / 3600)::NUMERIC, 2), 0)
 		FROM dvr_recordings
 		WHERE subscriber_id = $1 AND status IN ('scheduled','recording','complete')`,
 		subscriberID).Scan(&usedHours)
@@ -0,0 +1,368 @@
+// series.go — DVR series-rule engine.
+// Turns dvr_series rules into dvr_recordings rows by matching them against the
+// EPG service's programs table, and enforces each rule's keep_last_n limit.
+// The rows are picked up and captured by the scheduler like any other recording.
+//
+// Design:
+//   - Every MatchEvery (default 15 minutes) load all dvr_series rules
+//   - For each rule, find upcoming programs (within Lookahead) on the rule's
+//     guide_channel_id whose title matches show_title. That channel's ingest
+//     stream is what gets recorded; rules without one are skipped
+//   - Recordings belong to the subscriber whose SSO account created the rule
+//     (dvr_series.scheduled_by = subscribers.sso_user_id) and count against
+//     that subscriber's quota; airings that no longer fit are not booked
+//   - always_record = TRUE  → record every airing
+//     always_record = FALSE → record new episodes only: an airing is skipped
+//     when its episode key is already scheduled, recorded or deleted for the
+//     series. Failed recordings do not count, so a repeat airing retries them
+//   - Insert dvr_recordings rows with series_id + program_id (unique together,
+//     so re-running a match never double-books an airing), carrying the rule's
+//     padding and follow_epg / sports_extend settings
+//   - Delete completed recordings beyond keep_last_n, oldest first
+package series
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/unyeco/roost/services/dvr/internal/scheduler"
+)
+
+// Rule mirrors the dvr_series table row (relevant fields).
+type Rule struct {
+	ID             string
+	SubscriberID   string // subscribers.id owning the recordings; "" = rule is skipped
+	GuideChannelID string // channels.id to match programs on and record; "" = rule is skipped
+	ShowTitle      string
+	AlwaysRecord   bool
+	KeepLastN      int // 0 = keep all
+
+	PaddingBeforeSecs int
+	PaddingAfterSecs  int
+	FollowEPG         bool
+	SportsExtend      bool
+}
+
+// Program is an upcoming EPG programme that may match a Rule.
+type Program struct {
+	ID          string
+	ChannelID   string
+	Title       string
+	Description string
+	StartTime   time.Time
+	EndTime     time.Time
+
+	Season   int    // 1-based; 0 = unknown
+	Episode  int    // 1-based; 0 = unknown
+	DDProgID string // XMLTV dd_progid episode-num, "" = none
+}
+
+// Config holds series engine configuration.
+type Config struct {
+	MatchEvery time.Duration // how often rules are re-evaluated
+	Lookahead  time.Duration // how far ahead in the guide to schedule
+}
+
+// Engine evaluates series rules against the guide.
+type Engine struct {
+	cfg Config
+	db  *sql.DB
+}
+
+// New creates an Engine. Zero config values fall back to 15m / 7 days.
+func New(cfg Config, db *sql.DB) *Engine {
+	if cfg.MatchEvery <= 0 {
+		cfg.MatchEvery = 15 * time.Minute
+	}
+	if cfg.Lookahead <= 0 {
+		cfg.Lookahead = 7 * 24 * time.Hour
+	}
+	return &Engine{cfg: cfg, db: db}
+}
+
+// Run evaluates all rules immediately, then every MatchEvery. Blocks until ctx is cancelled.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.MatchEvery)
+	defer ticker.Stop()
+	log.Printf("[dvr] series engine started, matching every %s (lookahead %s)",
+		e.cfg.MatchEvery, e.cfg.Lookahead)
+
+	e.RunOnce(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce performs one match + prune pass over every series rule.
+func (e *Engine) RunOnce(ctx context.Context) {
+	rules, err := e.loadRules(ctx)
+	if err != nil {
+		log.Printf("[dvr] series: load rules: %v", err)
+		return
+	}
+	for _, rule := range rules {
+		if ctx.Err() != nil {
+			return
+		}
+		scheduled, err := e.matchRule(ctx, rule)
+		if err != nil {
+			log.Printf("[dvr] series %s (%q): match failed: %v", rule.ID, rule.ShowTitle, err)
+			continue
+		}
+		if scheduled > 0 {
+			log.Printf("[dvr] series %s (%q): scheduled %d new recording(s)", rule.ID, rule.ShowTitle, scheduled)
+		}
+		if rule.KeepLastN > 0 {
+			if pruned, err := e.pruneRule(ctx, rule); err != nil {
+				log.Printf("[dvr] series %s: prune failed: %v", rule.ID, err)
+			} else if pruned > 0 {
+				log.Printf("[dvr] series %s: pruned %d recording(s) beyond keep_last_n=%d",
+					rule.ID, pruned, rule.KeepLastN)
+			}
+		}
+	}
+}
+
+// loadRules returns every series rule.
+func (e *Engine) loadRules(ctx context.Context) ([]Rule, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT d.id, COALESCE(s.id::text, ''), COALESCE(d.guide_channel_id::text, ''), d.show_title,
+		       d.always_record, COALESCE(d.keep_last_n, 0),
+		       d.padding_before_secs, d.padding_after_secs, d.follow_epg, d.sports_extend
+		FROM dvr_series d
+		LEFT JOIN subscribers s ON s.sso_user_id = d.scheduled_by
+		ORDER BY d.created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []Rule
+	for rows.Next() {
+		var r Rule
+		if err := rows.Scan(&r.ID, &r.SubscriberID, &r.GuideChannelID, &r.ShowTitle,
+			&r.AlwaysRecord, &r.KeepLastN,
+			&r.PaddingBeforeSecs, &r.PaddingAfterSecs, &r.FollowEPG, &r.SportsExtend); err != nil {
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// matchRule schedules upcoming airings for one rule. Returns the number of
+// dvr_recordings rows inserted.
+func (e *Engine) matchRule(ctx context.Context, rule Rule) (int, error) {
+	if rule.GuideChannelID == "" {
+		return 0, errors.New("no guide_channel_id; recreate the rule with the channel whose guide it records")
+	}
+	if rule.SubscriberID == "" {
+		return 0, errors.New("scheduled_by has no subscriber account to own the recordings")
+	}
+	programs, err := e.upcomingPrograms(ctx, rule)
+	if err != nil {
+		return 0, err
+	}
+	if len(programs) == 0 {
+		return 0, nil
+	}
+
+	seen := map[string]bool{}
+	if !rule.AlwaysRecord {
+		if seen, err = e.knownEpisodes(ctx, rule.ID); err != nil {
+			return 0, err
+		}
+	}
+	quota, err := scheduler.GetQuota(ctx, e.db, rule.SubscriberID)
+	if err != nil {
+		return 0, fmt.Errorf("quota: %w", err)
+	}
+	remaining := quota.RemainingHours
+	padding := time.Duration(rule.PaddingBeforeSecs+rule.PaddingAfterSecs) * time.Second
+
+	// end_time is the programme's end; the scheduler adds padding_after_secs.
+	inserted := 0
+	for _, p := range SelectAirings(rule, programs, seen) {
+		hours := (p.EndTime.Sub(p.StartTime) + padding).Hours()
+		if hours > remaining {
+			log.Printf("[dvr] series %s: quota full (%.2fh left), not booking %q at %s",
+				rule.ID, remaining, p.Title, p.StartTime.Format(time.RFC3339))
+			break
+		}
+		res, err := e.db.ExecContext(ctx, `
+			INSERT INTO dvr_recordings
+				(subscriber_id, channel_id, program_id, title, start_time, end_time,
+				 padding_before_secs, padding_after_secs, follow_epg, sports_extend,
+				 series_id, episode_key)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			ON CONFLICT DO NOTHING`,
+			rule.SubscriberID, rule.GuideChannelID, p.ID, p.Title, p.StartTime, p.EndTime,
+			rule.PaddingBeforeSecs, rule.PaddingAfterSecs, rule.FollowEPG, rule.SportsExtend,
+			rule.ID, EpisodeKey(p))
+		if err != nil {
+			log.Printf("[dvr] series %s: insert recording for program %s: %v", rule.ID, p.ID, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			inserted++
+			remaining -= hours
+		}
+	}
+
+	_, _ = e.db.ExecContext(ctx, `UPDATE dvr_series SET last_matched_at = NOW() WHERE id = $1`, rule.ID)
+	return inserted, nil
+}
+
+// upcomingPrograms returns programs inside the lookahead window whose title matches the rule.
+func (e *Engine) upcomingPrograms(ctx context.Context, rule Rule) ([]Program, error) {
+	now := time.Now().UTC()
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT p.id, p.channel_id, p.title, COALESCE(p.description, ''), p.start_time, p.end_time,
+		       COALESCE(p.season_number, 0), COALESCE(p.episode_number, 0), COALESCE(p.dd_progid, '')
+		FROM programs p
+		JOIN channels c ON c.id = p.channel_id AND c.is_active = true
+		WHERE LOWER(p.title) = LOWER($1)
+		  AND p.start_time > $2 AND p.start_time < $3
+		  AND p.channel_id::text = $4
+		ORDER BY p.start_time ASC`,
+		rule.ShowTitle, now, now.Add(e.cfg.Lookahead), rule.GuideChannelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var programs []Program
+	for rows.Next() {
+		var p Program
+		if err := rows.Scan(&p.ID, &p.ChannelID, &p.Title, &p.Description, &p.StartTime, &p.EndTime,
+			&p.Season, &p.Episode, &p.DDProgID); err != nil {
+			continue
+		}
+		programs = append(programs, p)
+	}
+	return programs, rows.Err()
+}
+
+// knownEpisodes returns the episode keys already scheduled, recorded or
+// deleted for a series. Failed recordings are left out so they are retried.
+func (e *Engine) knownEpisodes(ctx context.Context, seriesID string) (map[string]bool, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT episode_key FROM dvr_recordings
+		WHERE series_id = $1 AND episode_key IS NOT NULL AND status <> 'failed'`, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err == nil {
+			seen[key] = true
+		}
+	}
+	return seen, rows.Err()
+}
+
+// pruneRule deletes completed recordings of the series beyond keep_last_n,
+// oldest first: their files are removed and the rows marked 'deleted', which
+// keeps their episode keys so new-episodes-only rules do not record them again.
+// Returns the number of recordings removed.
+func (e *Engine) pruneRule(ctx context.Context, rule Rule) (int, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT id, COALESCE(storage_path, '')
+		FROM dvr_recordings
+		WHERE series_id = $1 AND status = 'complete'
+		ORDER BY start_time DESC
+		OFFSET $2`, rule.ID, rule.KeepLastN)
+	if err != nil {
+		return 0, err
+	}
+	type stale struct{ id, path string }
+	var victims []stale
+	for rows.Next() {
+		var v stale
+		if err := rows.Scan(&v.id, &v.path); err == nil {
+			victims = append(victims, v)
+		}
+	}
+	rows.Close()
+
+	pruned := 0
+	for _, v := range victims {
+		// storage_path is the recording's playlist; its directory holds the segments.
+		if v.path != "" {
+			if err := os.RemoveAll(filepath.Dir(v.path)); err != nil {
+				log.Printf("[dvr] series %s: remove %s: %v", rule.ID, v.path, err)
+				continue // keep the row so the files are retried next pass
+			}
+		}
+		if _, err := e.db.ExecContext(ctx, `
+			UPDATE dvr_recordings SET status = 'deleted', storage_path = NULL, updated_at = NOW()
+			WHERE id = $1 AND status = 'complete'`, v.id); err != nil {
+			log.Printf("[dvr] series %s: delete recording %s: %v", rule.ID, v.id, err)
+			continue
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// SelectAirings picks which programs a rule should record.
+// programs must be sorted by start time. seen holds episode keys already
+// scheduled for the series and is only consulted for new-episodes-only rules;
+// it is not modified.
+func SelectAirings(rule Rule, programs []Program, seen map[string]bool) []Program {
+	if rule.AlwaysRecord {
+		return programs
+	}
+	picked := make(map[string]bool, len(programs))
+	var out []Program
+	for _, p := range programs {
+		key := EpisodeKey(p)
+		if seen[key] || picked[key] {
+			continue // repeat airing of an episode we already have
+		}
+		picked[key] = true
+		out = append(out, p)
+	}
+	return out
+}
+
+// EpisodeKey identifies an episode independent of when it airs, preferring
+// the guide's episode numbering: an episode-level dd_progid, then season and
+// episode number, then the description. Programs with none of these cannot
+// be told apart from repeats, so each airing is treated as its own episode
+// (keyed by start time).
+func EpisodeKey(p Program) string {
+	if id := strings.ToUpper(strings.TrimSpace(p.DDProgID)); id != "" && !strings.HasPrefix(id, "SH") {
+		// SH ids name the show, not an episode.
+		return "dd:" + id
+	}
+	title := normalize(p.Title)
+	if p.Season > 0 && p.Episode > 0 {
+		return fmt.Sprintf("%s|s%de%d", title, p.Season, p.Episode)
+	}
+	desc := normalize(p.Description)
+	if desc == "" {
+		return title + "|@" + p.StartTime.UTC().Format(time.RFC3339)
+	}
+	return title + "|" + desc
+}
+
+// normalize lowercases s and collapses runs of whitespace.
+func normalize(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
@@ -0,0 +1,100 @@
+// series_test.go — Unit tests for series rule matching.
+package series
+
+import (
+	"testing"
+	"time"
+)
+
+func prog(id, desc string, start time.Time) Program {
+	return Program{
+		ID:          id,
+		Title:       "The Show",
+		Description: desc,
+		StartTime:   start,
+		EndTime:     start.Add(30 * time.Minute),
+	}
+}
+
+// TestSelectAiringsAlwaysRecord verifies all-airings rules keep every program.
+func TestSelectAiringsAlwaysRecord(t *testing.T) {
+	base := time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)
+	programs := []Program{
+		prog("a", "Pilot", base),
+		prog("b", "Pilot", base.Add(24*time.Hour)),
+	}
+	got := SelectAirings(Rule{AlwaysRecord: true}, programs, map[string]bool{EpisodeKey(programs[0]): true})
+	if len(got) != 2 {
+		t.Fatalf("expected 2 airings, got %d", len(got))
+	}
+}
+
+// TestSelectAiringsNewOnly verifies repeats and already-known episodes are skipped.
+func TestSelectAiringsNewOnly(t *testing.T) {
+	base := time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)
+	programs := []Program{
+		prog("a", "Pilot", base),
+		prog("b", "  pilot ", base.Add(2*time.Hour)), // repeat, differently spaced
+		prog("c", "Episode Two", base.Add(24*time.Hour)),
+		prog("d", "Episode Three", base.Add(48*time.Hour)),
+	}
+	seen := map[string]bool{EpisodeKey(programs[2]): true}
+
+	got := SelectAirings(Rule{AlwaysRecord: false}, programs, seen)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 airings, got %d: %+v", len(got), got)
+	}
+	if got[0].ID != "a" || got[1].ID != "d" {
+		t.Errorf("expected airings a and d, got %s and %s", got[0].ID, got[1].ID)
+	}
+	if len(seen) != 1 {
+		t.Errorf("SelectAirings must not modify seen, got %d keys", len(seen))
+	}
+}
+
+// TestEpisodeKeyWithoutDescription verifies undescribed airings are never merged.
+func TestEpisodeKeyWithoutDescription(t *testing.T) {
+	base := time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)
+	a := prog("a", "", base)
+	b := prog("b", "", base.Add(time.Hour))
+	if EpisodeKey(a) == EpisodeKey(b) {
+		t.Errorf("airings without description should have distinct keys, both %q", EpisodeKey(a))
+	}
+	if EpisodeKey(prog("x", "Pilot", base)) != EpisodeKey(prog("y", "PILOT", base.Add(time.Hour))) {
+		t.Error("episode key should ignore case and air time when a description is present")
+	}
+}
+
+// TestEpisodeKeyNumbering verifies guide episode numbering takes precedence
+// over the description, and that show-level dd_progids are ignored.
+func TestEpisodeKeyNumbering(t *testing.T) {
+	base := time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)
+	a := prog("a", "Pilot", base)
+	a.Season, a.Episode = 1, 1
+	b := prog("b", "The one where it starts", base.Add(24*time.Hour))
+	b.Season, b.Episode = 1, 1
+	if EpisodeKey(a) != EpisodeKey(b) {
+		t.Errorf("same season/episode should share a key: %q vs %q", EpisodeKey(a), EpisodeKey(b))
+	}
+	c := prog("c", "Pilot", base.Add(48*time.Hour))
+	c.Season, c.Episode = 1, 2
+	if EpisodeKey(a) == EpisodeKey(c) {
+		t.Error("different episodes with the same description should have distinct keys")
+	}
+
+	d := prog("d", "", base)
+	d.DDProgID = "EP01234567.0003"
+	e := prog("e", "Different text", base.Add(time.Hour))
+	e.DDProgID = "ep01234567.0003"
+	if EpisodeKey(d) != EpisodeKey(e) {
+		t.Errorf("same dd_progid should share a key: %q vs %q", EpisodeKey(d), EpisodeKey(e))
+	}
+
+	f := prog("f", "", base)
+	f.DDProgID = "SH01234567.0000"
+	g := prog("g", "", base.Add(time.Hour))
+	g.DDProgID = "SH01234567.0000"
+	if EpisodeKey(f) == EpisodeKey(g) {
+		t.Error("show-level dd_progid must not merge undescribed airings")
+	}
+}
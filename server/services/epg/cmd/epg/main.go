@@ -12,6 +12,10 @@
 //
 // Internal routes (no external exposure, called by catalog service):
 //   POST /internal/sync-source?id=xxx    — trigger sync for one source
+//
+// Admin routes (require superowner token, see suggestions.go):
+//   GET  /admin/epg/suggestions          — fuzzy channel matches awaiting review
+//   POST /admin/epg/suggestions/:id/accept|reject
 package main
 
 import (
@@ -30,7 +34,7 @@ import (
 	"sync/atomic"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	epgsync "github.com/unyeco/roost/services/epg/internal/sync"
 )
@@ -92,6 +96,53 @@ type server struct {
 	state *syncState
 }
 
+// ---- programs ---------------------------------------------------------------
+
+// programResp is a programs row as served by /epg/json and /epg/upcoming.
+type programResp struct {
+	ID              string          `json:"id"`
+	ChannelID       string          `json:"channel_id"`
+	Title           string          `json:"title"`
+	SubTitle        *string         `json:"sub_title"`
+	Description     *string         `json:"description"`
+	StartTime       time.Time       `json:"start_time"`
+	EndTime         time.Time       `json:"end_time"`
+	Genre           *string         `json:"genre"`
+	Categories      []string        `json:"categories"`
+	Rating          *string         `json:"rating"`
+	StarRating      *string         `json:"star_rating"`
+	SeasonNumber    *int            `json:"season_number"`
+	EpisodeNumber   *int            `json:"episode_number"`
+	EpisodeOnScreen *string         `json:"episode_onscreen"`
+	DDProgID        *string         `json:"dd_progid"`
+	Credits         json.RawMessage `json:"credits"`
+	AirDate         *string         `json:"air_date"`
+	PreviouslyShown bool            `json:"previously_shown"`
+	IsNew           bool            `json:"is_new"`
+	IsPremiere      bool            `json:"is_premiere"`
+}
+
+const programCols = `id, channel_id, title, sub_title, description, start_time, end_time,
+	genre, categories, rating, star_rating, season_number, episode_number,
+	episode_onscreen, dd_progid, credits, air_date, previously_shown, is_new, is_premiere`
+
+func scanProgram(row interface{ Scan(...interface{}) error }) (programResp, error) {
+	var p programResp
+	var credits []byte
+	err := row.Scan(&p.ID, &p.ChannelID, &p.Title, &p.SubTitle, &p.Description,
+		&p.StartTime, &p.EndTime, &p.Genre, pq.Array(&p.Categories), &p.Rating, &p.StarRating,
+		&p.SeasonNumber, &p.EpisodeNumber, &p.EpisodeOnScreen, &p.DDProgID, &credits,
+		&p.AirDate, &p.PreviouslyShown, &p.IsNew, &p.IsPremiere)
+	if p.Categories == nil {
+		p.Categories = []string{}
+	}
+	p.Credits = json.RawMessage("[]")
+	if len(credits) > 0 {
+		p.Credits = credits
+	}
+	return p, err
+}
+
 // ---- handlers ---------------------------------------------------------------
 
 // GET /health
@@ -184,7 +235,10 @@ func (s *server) handleEpgXMLTV(w http.ResponseWriter, r *http.Request) {
 
 	rows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(`
 		SELECT c.id, c.name, c.slug, c.logo_url, c.epg_channel_id,
-		       p.id, p.title, p.description, p.start_time, p.end_time, p.genre, p.rating
+		       p.id, p.title, p.description, p.start_time, p.end_time, p.genre, p.rating,
+		       p.sub_title, p.categories, p.season_number, p.episode_number, p.episode_onscreen,
+		       p.dd_progid, p.credits, p.air_date, p.previously_shown, p.is_new, p.is_premiere,
+		       p.star_rating
 		FROM channels c
 		JOIN programs p ON p.channel_id = c.id
 		WHERE p.end_time >= $1 AND p.start_time <= $2
@@ -204,6 +258,7 @@ func (s *server) handleEpgXMLTV(w http.ResponseWriter, r *http.Request) {
 		EndTime     time.Time
 		Genre       string
 		Rating      string
+		Details     xmltvDetails
 	}
 	type chanItem struct {
 		ID         string
@@ -222,10 +277,26 @@ func (s *server) handleEpgXMLTV(w http.ResponseWriter, r *http.Request) {
 		var pID, pTitle string
 		var pDesc, pGenre, pRating *string
 		var pStart, pEnd time.Time
+		var d xmltvDetails
+		var subTitle, onScreen, ddProgID, airDate, starRating *string
+		var season, episode *int
+		var credits []byte
 		if err := rows.Scan(&cID, &cName, &cSlug, &cLogoURL, &cEpgID,
-			&pID, &pTitle, &pDesc, &pStart, &pEnd, &pGenre, &pRating); err != nil {
+			&pID, &pTitle, &pDesc, &pStart, &pEnd, &pGenre, &pRating,
+			&subTitle, pq.Array(&d.Categories), &season, &episode, &onScreen,
+			&ddProgID, &credits, &airDate, &d.PreviouslyShown, &d.New, &d.Premiere,
+			&starRating); err != nil {
 			continue
 		}
+		d.SubTitle, d.OnScreen, d.DDProgID = deref(subTitle), deref(onScreen), deref(ddProgID)
+		d.Date, d.StarRating = deref(airDate), deref(starRating)
+		if season != nil {
+			d.Season = *season
+		}
+		if episode != nil {
+			d.Episode = *episode
+		}
+		_ = json.Unmarshal(credits, &d.Credits)
 		if _, ok := chanMap[cID]; !ok {
 			chanMap[cID] = &chanItem{
 				ID: cID, Name: cName, Slug: cSlug, LogoURL: cLogoURL, EpgID: cEpgID,
@@ -233,7 +304,7 @@ func (s *server) handleEpgXMLTV(w http.ResponseWriter, r *http.Request) {
 			}
 			chanOrder = append(chanOrder, cID)
 		}
-		prog := progItem{Title: pTitle, StartTime: pStart, EndTime: pEnd}
+		prog := progItem{Title: pTitle, StartTime: pStart, EndTime: pEnd, Details: d}
 		if pDesc != nil {
 			prog.Desc = *pDesc
 		}
@@ -299,18 +370,33 @@ func (s *server) handleEpgXMLTV(w http.ResponseWriter, r *http.Request) {
 				{Name: xml.Name{Local: "stop"}, Value: p.EndTime.UTC().Format(xmltvFmt)},
 				{Name: xml.Name{Local: "channel"}, Value: epgID},
 			}})
+			// Child order follows the XMLTV DTD.
 			_ = enc.EncodeElement(p.Title, xml.StartElement{Name: xml.Name{Local: "title"}})
+			if p.Details.SubTitle != "" {
+				_ = enc.EncodeElement(p.Details.SubTitle, xml.StartElement{Name: xml.Name{Local: "sub-title"}})
+			}
 			if p.Desc != "" {
 				_ = enc.EncodeElement(p.Desc, xml.StartElement{Name: xml.Name{Local: "desc"}})
 			}
-			if p.Genre != "" {
-				_ = enc.EncodeElement(p.Genre, xml.StartElement{Name: xml.Name{Local: "category"}})
+			encodeCreditsAndDate(enc, p.Details)
+			categories := p.Details.Categories
+			if len(categories) == 0 && p.Genre != "" {
+				categories = []string{p.Genre}
+			}
+			for _, c := range categories {
+				_ = enc.EncodeElement(c, xml.StartElement{Name: xml.Name{Local: "category"}})
 			}
+			encodeEpisodeAndFlags(enc, p.Details)
 			if p.Rating != "" {
 				_ = enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: "rating"}})
 				_ = enc.EncodeElement(p.Rating, xml.StartElement{Name: xml.Name{Local: "value"}})
 				_ = enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "rating"}})
 			}
+			if p.Details.StarRating != "" {
+				_ = enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: "star-rating"}})
+				_ = enc.EncodeElement(p.Details.StarRating, xml.StartElement{Name: xml.Name{Local: "value"}})
+				_ = enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "star-rating"}})
+			}
 			_ = enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "programme"}})
 		}
 	}
@@ -356,7 +442,7 @@ func (s *server) handleEpgJSON(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rows, err := s.db.QueryContext(r.Context(), `
-		SELECT id, channel_id, title, description, start_time, end_time, genre, rating
+		SELECT `+programCols+`
 		FROM programs
 		WHERE channel_id = $1
 		  AND start_time >= $2 AND end_time <= $3
@@ -368,22 +454,9 @@ func (s *server) handleEpgJSON(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	type programResp struct {
-		ID          string     `json:"id"`
-		ChannelID   string     `json:"channel_id"`
-		Title       string     `json:"title"`
-		Description *string    `json:"description"`
-		StartTime   time.Time  `json:"start_time"`
-		EndTime     time.Time  `json:"end_time"`
-		Genre       *string    `json:"genre"`
-		Rating      *string    `json:"rating"`
-	}
-
 	programs := []programResp{}
 	for rows.Next() {
-		var p programResp
-		if err := rows.Scan(&p.ID, &p.ChannelID, &p.Title, &p.Description,
-			&p.StartTime, &p.EndTime, &p.Genre, &p.Rating); err == nil {
+		if p, err := scanProgram(rows); err == nil {
 			programs = append(programs, p)
 		}
 	}
@@ -406,7 +479,7 @@ func (s *server) handleEpgUpcoming(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rows, err := s.db.QueryContext(r.Context(), `
-		SELECT id, channel_id, title, description, start_time, end_time, genre, rating
+		SELECT `+programCols+`
 		FROM programs
 		WHERE channel_id = $1 AND end_time > now()
 		ORDER BY start_time
@@ -418,22 +491,9 @@ func (s *server) handleEpgUpcoming(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	type programResp struct {
-		ID          string    `json:"id"`
-		ChannelID   string    `json:"channel_id"`
-		Title       string    `json:"title"`
-		Description *string   `json:"description"`
-		StartTime   time.Time `json:"start_time"`
-		EndTime     time.Time `json:"end_time"`
-		Genre       *string   `json:"genre"`
-		Rating      *string   `json:"rating"`
-	}
-
 	programs := []programResp{}
 	for rows.Next() {
-		var p programResp
-		if err := rows.Scan(&p.ID, &p.ChannelID, &p.Title, &p.Description,
-			&p.StartTime, &p.EndTime, &p.Genre, &p.Rating); err == nil {
+		if p, err := scanProgram(rows); err == nil {
 			programs = append(programs, p)
 		}
 	}
@@ -576,6 +636,8 @@ func main() {
 	mux.HandleFunc("/epg/json", s.handleEpgJSON)
 	mux.HandleFunc("/epg/upcoming", s.handleEpgUpcoming)
 	mux.HandleFunc("/internal/sync-source", s.handleSyncSource)
+	mux.HandleFunc("/admin/epg/suggestions", s.handleSuggestions)
+	mux.HandleFunc("/admin/epg/suggestions/", s.handleSuggestions)
 
 	log.Printf("[epg] starting on :%s", port)
 	if err := http.ListenAndServe(":"+port, mux); err != nil {
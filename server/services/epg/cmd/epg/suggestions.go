@@ -0,0 +1,265 @@
+// suggestions.go — Admin review of fuzzy EPG channel matches.
+//
+// Each sync maps unmapped channels onto the feed's XMLTV channels (see
+// internal/sync/match.go). Confident matches are applied straight away and
+// recorded as accepted; the rest wait here as pending suggestions.
+//
+// Admin routes (require superowner token):
+//
+//	GET  /admin/epg/suggestions?status=pending&limit=100&offset=0
+//	POST /admin/epg/suggestions/:id/accept  — set the channel's epg_channel_id, resync the source
+//	POST /admin/epg/suggestions/:id/reject  — never suggest the pair again; undoes an accepted match
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	epgsync "github.com/unyeco/roost/services/epg/internal/sync"
+)
+
+// validateAdminToken checks the Authorization: Bearer header for a superowner token.
+func validateAdminToken(ctx context.Context, db *sql.DB, r *http.Request) (bool, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false, nil
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	var isSuperowner bool
+	err := db.QueryRowContext(ctx,
+		`SELECT is_superowner FROM subscribers WHERE api_token = $1 AND is_superowner = true`,
+		token).Scan(&isSuperowner)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isSuperowner, err
+}
+
+type suggestionResponse struct {
+	ID               string     `json:"id"`
+	ChannelID        string     `json:"channel_id"`
+	ChannelName      string     `json:"channel_name"`
+	SourceID         string     `json:"source_id"`
+	SourceName       string     `json:"source_name"`
+	XMLTVChannelID   string     `json:"xmltv_channel_id"`
+	XMLTVDisplayName string     `json:"xmltv_display_name"`
+	Score            float64    `json:"score"`
+	MatchedOn        string     `json:"matched_on"`
+	Status           string     `json:"status"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ReviewedAt       *time.Time `json:"reviewed_at"` // null on pending and auto-applied matches
+}
+
+// /admin/epg/suggestions[/:id/(accept|reject)]
+func (s *server) handleSuggestions(w http.ResponseWriter, r *http.Request) {
+	ok, err := validateAdminToken(r.Context(), s.db, r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "auth_error", "Failed to validate token")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Superowner token required")
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/epg/suggestions"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
+			return
+		}
+		s.handleListSuggestions(w, r)
+		return
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 2 || (parts[1] != "accept" && parts[1] != "reject") {
+		writeError(w, http.StatusNotFound, "not_found", "Not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "POST required")
+		return
+	}
+	if parts[1] == "accept" {
+		s.handleAcceptSuggestion(w, r, parts[0])
+	} else {
+		s.handleRejectSuggestion(w, r, parts[0])
+	}
+}
+
+// GET /admin/epg/suggestions
+func (s *server) handleListSuggestions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	if status != "pending" && status != "accepted" && status != "rejected" {
+		writeError(w, http.StatusBadRequest, "invalid_status", "status must be pending, accepted or rejected")
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	offset := 0
+	if o, err := strconv.Atoi(q.Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	var total int
+	_ = s.db.QueryRowContext(r.Context(),
+		`SELECT COUNT(*) FROM epg_channel_suggestions WHERE status=$1`, status).Scan(&total)
+
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT s.id, s.channel_id, c.name, s.source_id, es.name,
+		       s.xmltv_channel_id, s.xmltv_display_name, s.score, s.matched_on,
+		       s.status, s.created_at, s.reviewed_at
+		FROM epg_channel_suggestions s
+		JOIN channels c ON c.id = s.channel_id
+		JOIN epg_sources es ON es.id = s.source_id
+		WHERE s.status=$1
+		ORDER BY s.score DESC, c.name
+		LIMIT $2 OFFSET $3`, status, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to list suggestions")
+		return
+	}
+	defer rows.Close()
+
+	suggestions := []suggestionResponse{}
+	for rows.Next() {
+		var sg suggestionResponse
+		if err := rows.Scan(&sg.ID, &sg.ChannelID, &sg.ChannelName, &sg.SourceID, &sg.SourceName,
+			&sg.XMLTVChannelID, &sg.XMLTVDisplayName, &sg.Score, &sg.MatchedOn,
+			&sg.Status, &sg.CreatedAt, &sg.ReviewedAt); err == nil {
+			suggestions = append(suggestions, sg)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"suggestions": suggestions, "total": total})
+}
+
+// POST /admin/epg/suggestions/:id/accept
+func (s *server) handleAcceptSuggestion(w http.ResponseWriter, r *http.Request, id string) {
+	tx, err := s.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to accept suggestion")
+		return
+	}
+	defer tx.Rollback()
+
+	var channelID, sourceID, xmltvID string
+	err = tx.QueryRowContext(r.Context(), `
+		UPDATE epg_channel_suggestions SET status='accepted', reviewed_at=now()
+		WHERE id=$1 AND status='pending'
+		RETURNING channel_id, source_id, xmltv_channel_id`, id).Scan(&channelID, &sourceID, &xmltvID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "not_found", "Pending suggestion not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to accept suggestion")
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(),
+		`UPDATE channels SET epg_channel_id=$2 WHERE id=$1`, channelID, xmltvID); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to map channel")
+		return
+	}
+	// The channel is mapped now; its other candidates are moot.
+	if _, err := tx.ExecContext(r.Context(),
+		`DELETE FROM epg_channel_suggestions WHERE channel_id=$1 AND status='pending'`, channelID); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to accept suggestion")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to accept suggestion")
+		return
+	}
+
+	s.resyncSource(sourceID)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status": "accepted", "channel_id": channelID, "xmltv_channel_id": xmltvID,
+	})
+}
+
+// POST /admin/epg/suggestions/:id/reject
+func (s *server) handleRejectSuggestion(w http.ResponseWriter, r *http.Request, id string) {
+	tx, err := s.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to reject suggestion")
+		return
+	}
+	defer tx.Rollback()
+
+	var channelID, sourceID, xmltvID, prevStatus string
+	err = tx.QueryRowContext(r.Context(), `
+		SELECT channel_id, source_id, xmltv_channel_id, status
+		FROM epg_channel_suggestions WHERE id=$1 FOR UPDATE`, id).Scan(&channelID, &sourceID, &xmltvID, &prevStatus)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "not_found", "Suggestion not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to reject suggestion")
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(),
+		`UPDATE epg_channel_suggestions SET status='rejected', reviewed_at=now() WHERE id=$1`, id); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to reject suggestion")
+		return
+	}
+	if prevStatus == "accepted" {
+		// Undo the mapping (unless it has since been changed by hand) and drop
+		// the guide data it brought in.
+		res, err := tx.ExecContext(r.Context(),
+			`UPDATE channels SET epg_channel_id=NULL WHERE id=$1 AND epg_channel_id=$2`, channelID, xmltvID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", "Failed to unmap channel")
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			if _, err := tx.ExecContext(r.Context(),
+				`DELETE FROM programs WHERE channel_id=$1 AND source_program_id LIKE $2`,
+				channelID, likePrefix(xmltvID)+"|%"); err != nil {
+				writeError(w, http.StatusInternalServerError, "db_error", "Failed to remove programs")
+				return
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to reject suggestion")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "rejected", "channel_id": channelID})
+}
+
+// likePrefix escapes LIKE wildcards in s.
+func likePrefix(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// resyncSource syncs one source in the background so an accepted mapping
+// gets its guide without waiting for the next scheduled run.
+func (s *server) resyncSource(sourceID string) {
+	go func() {
+		var src epgsync.Source
+		err := s.db.QueryRow(
+			`SELECT id, name, url, priority, refresh_interval_seconds FROM epg_sources WHERE id=$1 AND is_active=true`,
+			sourceID).Scan(&src.ID, &src.Name, &src.URL, &src.Priority, &src.RefreshIntervalSeconds)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("[epg] resync source %s: %v", sourceID, err)
+			}
+			return
+		}
+		if res := epgsync.SyncSource(context.Background(), s.db, src); res.Error != nil {
+			log.Printf("[epg] resync source %s: %v", sourceID, res.Error)
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+// xmltv_details.go — Episode, credit and airing elements for /epg/xmltv.
+// Writes the programme fields the sync keeps from source feeds back out in
+// XMLTV form, in the DTD's child order.
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// xmltvDetails holds a programme's optional XMLTV fields.
+type xmltvDetails struct {
+	SubTitle        string
+	Categories      []string
+	Season          int // 1-based; 0 = unknown
+	Episode         int // 1-based; 0 = unknown
+	OnScreen        string
+	DDProgID        string
+	Credits         []xmltvCredit
+	Date            string // "YYYY", "YYYY-MM" or "YYYY-MM-DD"
+	PreviouslyShown bool
+	New             bool
+	Premiere        bool
+	StarRating      string
+}
+
+// xmltvCredit is one entry of programs.credits.
+type xmltvCredit struct {
+	Role      string `json:"role"`
+	Name      string `json:"name"`
+	Character string `json:"character,omitempty"`
+}
+
+// creditRoles is the DTD's order of <credits> children.
+var creditRoles = []string{
+	"director", "actor", "writer", "adapter", "producer",
+	"composer", "editor", "presenter", "commentator", "guest",
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// encodeCreditsAndDate writes <credits> and <date>.
+func encodeCreditsAndDate(enc *xml.Encoder, d xmltvDetails) {
+	if len(d.Credits) > 0 {
+		rank := make(map[string]int, len(creditRoles))
+		for i, role := range creditRoles {
+			rank[role] = i
+		}
+		credits := make([]xmltvCredit, 0, len(d.Credits))
+		for _, c := range d.Credits {
+			if _, ok := rank[c.Role]; ok && c.Name != "" {
+				credits = append(credits, c)
+			}
+		}
+		sort.SliceStable(credits, func(i, j int) bool { return rank[credits[i].Role] < rank[credits[j].Role] })
+		if len(credits) > 0 {
+			_ = enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: "credits"}})
+			for _, c := range credits {
+				el := xml.StartElement{Name: xml.Name{Local: c.Role}}
+				if c.Role == "actor" && c.Character != "" {
+					el.Attr = []xml.Attr{{Name: xml.Name{Local: "role"}, Value: c.Character}}
+				}
+				_ = enc.EncodeElement(c.Name, el)
+			}
+			_ = enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "credits"}})
+		}
+	}
+	if d.Date != "" {
+		_ = enc.EncodeElement(strings.ReplaceAll(d.Date, "-", ""), xml.StartElement{Name: xml.Name{Local: "date"}})
+	}
+}
+
+// encodeEpisodeAndFlags writes <episode-num> (xmltv_ns, onscreen and
+// dd_progid systems), <previously-shown>, <premiere> and <new>.
+func encodeEpisodeAndFlags(enc *xml.Encoder, d xmltvDetails) {
+	episodeNum := func(system, value string) {
+		_ = enc.EncodeElement(value, xml.StartElement{
+			Name: xml.Name{Local: "episode-num"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "system"}, Value: system}},
+		})
+	}
+	if ns := xmltvNS(d.Season, d.Episode); ns != "" {
+		episodeNum("xmltv_ns", ns)
+	}
+	onScreen := d.OnScreen
+	if onScreen == "" && d.Season > 0 && d.Episode > 0 {
+		onScreen = fmt.Sprintf("S%02dE%02d", d.Season, d.Episode)
+	}
+	if onScreen != "" {
+		episodeNum("onscreen", onScreen)
+	}
+	if d.DDProgID != "" {
+		episodeNum("dd_progid", d.DDProgID)
+	}
+	empty := func(name string) {
+		_ = enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: name}})
+		_ = enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: name}})
+	}
+	if d.PreviouslyShown {
+		empty("previously-shown")
+	}
+	if d.Premiere {
+		empty("premiere")
+	}
+	if d.New {
+		empty("new")
+	}
+}
+
+// xmltvNS formats 1-based season/episode numbers as a zero-based xmltv_ns
+// value ("1.4." for S2E5). Returns "" when both are unknown.
+func xmltvNS(season, episode int) string {
+	if season <= 0 && episode <= 0 {
+		return ""
+	}
+	part := func(n int) string {
+		if n <= 0 {
+			return ""
+		}
+		return fmt.Sprint(n - 1)
+	}
+	return part(season) + "." + part(episode) + "."
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func encodeDetails(d xmltvDetails) string {
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	encodeCreditsAndDate(enc, d)
+	encodeEpisodeAndFlags(enc, d)
+	_ = enc.Flush()
+	return buf.String()
+}
+
+func TestXMLTVNS(t *testing.T) {
+	cases := []struct {
+		season, episode int
+		want            string
+	}{
+		{2, 5, "1.4."},
+		{0, 5, ".4."},
+		{3, 0, "2.."},
+		{0, 0, ""},
+	}
+	for _, tc := range cases {
+		if got := xmltvNS(tc.season, tc.episode); got != tc.want {
+			t.Errorf("xmltvNS(%d, %d) = %q, want %q", tc.season, tc.episode, got, tc.want)
+		}
+	}
+}
+
+func TestEncodeDetails(t *testing.T) {
+	got := encodeDetails(xmltvDetails{
+		Season: 2, Episode: 5, DDProgID: "EP01234567.0005",
+		Credits: []xmltvCredit{
+			{Role: "presenter", Name: "Pat Host"},
+			{Role: "actor", Name: "Alex Actor", Character: "Sam"},
+			{Role: "director", Name: "Jane Director"},
+			{Role: "stuntman", Name: "Not In DTD"},
+		},
+		Date:            "2019-03-04",
+		PreviouslyShown: true,
+		New:             true,
+	})
+	want := `<credits><director>Jane Director</director><actor role="Sam">Alex Actor</actor>` +
+		`<presenter>Pat Host</presenter></credits><date>20190304</date>` +
+		`<episode-num system="xmltv_ns">1.4.</episode-num>` +
+		`<episode-num system="onscreen">S02E05</episode-num>` +
+		`<episode-num system="dd_progid">EP01234567.0005</episode-num>` +
+		`<previously-shown></previously-shown><new></new>`
+	if got != want {
+		t.Errorf("details XML:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestEncodeDetailsEmpty(t *testing.T) {
+	if got := encodeDetails(xmltvDetails{}); got != "" {
+		t.Errorf("empty details should write nothing, got %s", got)
+	}
+	if got := encodeDetails(xmltvDetails{OnScreen: "Ep. 12"}); !strings.Contains(got, `system="onscreen">Ep. 12<`) {
+		t.Errorf("feed onscreen text should be kept, got %s", got)
+	}
+}
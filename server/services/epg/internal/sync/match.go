@@ -0,0 +1,318 @@
+// match.go — Fuzzy mapping of XMLTV channels onto Roost channels.
+//
+// Imported IPTV channels rarely carry an epg_channel_id, so for every active
+// channel without one the matcher looks for the feed channel that best fits
+// its tvg_id, callsign, name and number:
+//
+//	tvg_id equals the XMLTV id (case-insensitive)           1.00
+//	callsign equals a display-name or the XMLTV id          0.95
+//	normalized name equals a display-name or the XMLTV id   0.92
+//	callsign is one word of a display-name                  0.80
+//	similar names (bigram overlap)                          ≤ 0.88
+//	channel number is one word of a display-name            +0.05
+//
+// Names are compared after normalization: lowercased, punctuation dropped,
+// country prefixes ("US: ", "[UK]") and quality/region suffixes ("HD",
+// "FHD", "East", a trailing country code) removed, so "US: CNN HD" and
+// "CNN.us" both become "cnn".
+//
+// Matches at AutoApplyScore or above are applied by the sync directly; those
+// between SuggestScore and AutoApplyScore are stored for admin review. A
+// best score shared by two feed channels (e.g. "HBO East" and "HBO West"
+// for "HBO HD") is ambiguous and never auto-applied.
+package sync
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/unyeco/roost/services/epg/internal/xmltv"
+)
+
+// Score thresholds.
+const (
+	AutoApplyScore = 0.9
+	SuggestScore   = 0.6
+)
+
+// Candidate is an unmapped Roost channel.
+type Candidate struct {
+	ID       string
+	Name     string
+	TvgID    string
+	Callsign string
+	Number   string
+}
+
+// Match is the best feed channel found for a Candidate.
+type Match struct {
+	ChannelID   string
+	XMLTVID     string
+	DisplayName string
+	Score       float64
+	MatchedOn   string // "tvg_id", "callsign" or "name"
+	Ambiguous   bool   // another feed channel scored the same
+}
+
+// feedEntry is one XMLTV channel with its precomputed match keys.
+type feedEntry struct {
+	id          string
+	displayName string
+	keys        []string        // compact normalized display-names and id
+	words       map[string]bool // every word of every display-name
+}
+
+// Matcher scores candidates against one feed's channels.
+type Matcher struct {
+	entries []feedEntry
+	byID    map[string][]int // lowercased XMLTV id
+	byKey   map[string][]int // compact normalized name
+	byWord  map[string][]int // single normalized word
+}
+
+// NewMatcher indexes a feed's channels.
+func NewMatcher(channels []xmltv.XMLTVChannel) *Matcher {
+	m := &Matcher{
+		byID:   make(map[string][]int),
+		byKey:  make(map[string][]int),
+		byWord: make(map[string][]int),
+	}
+	for _, ch := range channels {
+		idx := len(m.entries)
+		e := feedEntry{id: ch.ID, displayName: ch.DisplayName, words: make(map[string]bool)}
+
+		names := ch.DisplayNames
+		if len(names) == 0 && ch.DisplayName != "" {
+			names = []string{ch.DisplayName}
+		}
+		seenKey := make(map[string]bool)
+		addKey := func(tokens []string) {
+			key := strings.Join(tokens, "")
+			if key == "" || seenKey[key] {
+				return
+			}
+			seenKey[key] = true
+			e.keys = append(e.keys, key)
+			m.byKey[key] = append(m.byKey[key], idx)
+		}
+		for _, name := range names {
+			for _, w := range words(name) {
+				if !e.words[w] {
+					e.words[w] = true
+					m.byWord[w] = append(m.byWord[w], idx)
+				}
+			}
+			addKey(normalizeName(name))
+		}
+		addKey(normalizeName(trimIDSuffix(ch.ID)))
+
+		m.byID[strings.ToLower(ch.ID)] = append(m.byID[strings.ToLower(ch.ID)], idx)
+		m.entries = append(m.entries, e)
+	}
+	return m
+}
+
+// Best returns the highest-scoring feed channel for c, skipping XMLTV ids
+// for which skip returns true. ok is false when nothing reaches SuggestScore.
+func (m *Matcher) Best(c Candidate, skip func(xmltvID string) bool) (Match, bool) {
+	type scored struct {
+		score float64
+		on    string
+	}
+	scores := make(map[int]scored)
+	consider := func(idx int, score float64, on string) {
+		if skip != nil && skip(m.entries[idx].id) {
+			return
+		}
+		if cur, ok := scores[idx]; !ok || score > cur.score {
+			scores[idx] = scored{score, on}
+		}
+	}
+
+	if c.TvgID != "" {
+		for _, idx := range m.byID[strings.ToLower(c.TvgID)] {
+			consider(idx, 1.0, "tvg_id")
+		}
+	}
+
+	if cs := strings.Join(normalizeName(c.Callsign), ""); cs != "" {
+		for _, idx := range m.byKey[cs] {
+			consider(idx, 0.95, "callsign")
+		}
+		for _, idx := range m.byWord[cs] {
+			consider(idx, 0.80, "callsign")
+		}
+	}
+
+	tokens := normalizeName(c.Name)
+	if len(tokens) > 0 {
+		key := strings.Join(tokens, "")
+		nameScores := make(map[int]float64)
+		for _, idx := range m.byKey[key] {
+			nameScores[idx] = 0.92
+		}
+		// Fuzzy-compare only against feed channels sharing a word.
+		for _, tok := range tokens {
+			for _, idx := range m.byWord[tok] {
+				if _, done := nameScores[idx]; done {
+					continue
+				}
+				best := 0.0
+				for _, k := range m.entries[idx].keys {
+					if d := dice(key, k); d > best {
+						best = d
+					}
+				}
+				nameScores[idx] = 0.88 * best
+			}
+		}
+		number := strings.TrimSpace(c.Number)
+		for idx, s := range nameScores {
+			if number != "" && m.entries[idx].words[number] {
+				s += 0.05
+			}
+			consider(idx, s, "name")
+		}
+	}
+
+	var best Match
+	found := false
+	for idx, s := range scores {
+		e := m.entries[idx]
+		switch {
+		case !found || s.score > best.Score:
+			best = Match{
+				ChannelID:   c.ID,
+				XMLTVID:     e.id,
+				DisplayName: e.displayName,
+				Score:       s.score,
+				MatchedOn:   s.on,
+			}
+			found = true
+		case s.score == best.Score && e.id != best.XMLTVID:
+			best.Ambiguous = true
+			if e.id < best.XMLTVID { // deterministic pick among ties
+				best.XMLTVID, best.DisplayName, best.MatchedOn = e.id, e.displayName, s.on
+			}
+		}
+	}
+	if !found || best.Score < SuggestScore {
+		return Match{}, false
+	}
+	if best.Score > 1 {
+		best.Score = 1
+	}
+	return best, true
+}
+
+// ---- normalization ----------------------------------------------------------
+
+// countryCodes are stripped as a leading prefix or a trailing word.
+var countryCodes = map[string]bool{
+	"us": true, "usa": true, "uk": true, "gb": true, "ca": true, "au": true,
+	"nz": true, "ie": true, "de": true, "at": true, "ch": true, "fr": true,
+	"be": true, "nl": true, "es": true, "pt": true, "it": true, "br": true,
+	"mx": true, "ar": true, "in": true, "pl": true, "se": true, "no": true,
+	"dk": true, "fi": true, "tr": true, "gr": true, "ro": true, "za": true,
+}
+
+// notTrailingCodes are country codes that are also common words ("Watch It",
+// "Tune In"), so they are only stripped as a prefix.
+var notTrailingCodes = map[string]bool{"at": true, "be": true, "in": true, "it": true, "no": true}
+
+// qualityWords are dropped wherever they appear.
+var qualityWords = map[string]bool{
+	"hd": true, "fhd": true, "uhd": true, "sd": true, "hq": true, "lq": true,
+	"4k": true, "8k": true, "hevc": true, "h264": true, "h265": true,
+	"1080p": true, "1080i": true, "720p": true, "576p": true, "480p": true,
+}
+
+// regionWords are dropped at the end of a name.
+var regionWords = map[string]bool{
+	"east": true, "west": true, "pacific": true, "central": true, "mountain": true,
+}
+
+// words lowercases s and splits it into letter/digit runs, spelling out "&"
+// and "+" so "+1" timeshift channels stay distinct.
+func words(s string) []string {
+	s = strings.ToLower(s)
+	s = strings.NewReplacer("&", " and ", "+", " plus ").Replace(s)
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// normalizeName reduces a channel name to its identifying words.
+func normalizeName(s string) []string {
+	s = stripCountryPrefix(s)
+	var out []string
+	for _, w := range words(s) {
+		if !qualityWords[w] {
+			out = append(out, w)
+		}
+	}
+	for len(out) > 1 {
+		last := out[len(out)-1]
+		if !regionWords[last] && (!countryCodes[last] || notTrailingCodes[last]) {
+			break
+		}
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// stripCountryPrefix removes a leading "US:", "UK |", "DE -" or "[FR]".
+func stripCountryPrefix(s string) string {
+	t := strings.TrimSpace(s)
+	if strings.HasPrefix(t, "[") || strings.HasPrefix(t, "(") {
+		if end := strings.IndexAny(t, "])"); end > 1 && countryCodes[strings.ToLower(t[1:end])] {
+			return t[end+1:]
+		}
+		return t
+	}
+	if i := strings.IndexAny(t, ":|-"); i >= 2 && i <= 4 {
+		if code := strings.ToLower(strings.TrimSpace(t[:i])); countryCodes[code] {
+			return t[i+1:]
+		}
+	}
+	return t
+}
+
+// trimIDSuffix drops a trailing country/domain segment from an XMLTV id
+// ("CNN.us" → "CNN").
+func trimIDSuffix(id string) string {
+	i := strings.LastIndexByte(id, '.')
+	if i <= 0 || len(id)-i-1 > 3 {
+		return id
+	}
+	for _, r := range id[i+1:] {
+		if !unicode.IsLetter(r) {
+			return id
+		}
+	}
+	return id[:i]
+}
+
+// dice is the Sørensen–Dice coefficient over the character bigrams of a and b.
+func dice(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < 2 || len(rb) < 2 {
+		return 0
+	}
+	bigrams := make(map[[2]rune]int, len(ra))
+	for i := 0; i < len(ra)-1; i++ {
+		bigrams[[2]rune{ra[i], ra[i+1]}]++
+	}
+	shared := 0
+	for i := 0; i < len(rb)-1; i++ {
+		bg := [2]rune{rb[i], rb[i+1]}
+		if bigrams[bg] > 0 {
+			bigrams[bg]--
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(ra)+len(rb)-2)
+}
@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/unyeco/roost/services/epg/internal/xmltv"
+)
+
+func TestNormalizeName(t *testing.T) {
+	cases := map[string][]string{
+		"US: CNN HD":          {"cnn"},
+		"[UK] BBC One FHD":    {"bbc", "one"},
+		"UK | Sky Sports 1":   {"sky", "sports", "1"},
+		"HBO East":            {"hbo"},
+		"Fox News Channel US": {"fox", "news", "channel"},
+		"Channel 4 +1":        {"channel", "4", "plus", "1"},
+		"AT&T SportsNet":      {"at", "and", "t", "sportsnet"},
+		"Watch It":            {"watch", "it"},
+		"ABC - News":          {"abc", "news"},
+	}
+	for in, want := range cases {
+		if got := normalizeName(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("normalizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTrimIDSuffix(t *testing.T) {
+	cases := map[string]string{
+		"CNN.us":       "CNN",
+		"BBCOne.uk":    "BBCOne",
+		"wabc":         "wabc",
+		"espn.2":       "espn.2",
+		"I1.json.info": "I1.json.info",
+	}
+	for in, want := range cases {
+		if got := trimIDSuffix(in); got != want {
+			t.Errorf("trimIDSuffix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func testFeed() []xmltv.XMLTVChannel {
+	return []xmltv.XMLTVChannel{
+		{ID: "CNN.us", DisplayName: "CNN", DisplayNames: []string{"CNN"}},
+		{ID: "BBCOne.uk", DisplayName: "BBC One", DisplayNames: []string{"BBC One"}},
+		{ID: "WABC.us", DisplayName: "WABC", DisplayNames: []string{"WABC", "7 ABC New York"}},
+		{ID: "HBOEast.us", DisplayName: "HBO East", DisplayNames: []string{"HBO East"}},
+		{ID: "HBOWest.us", DisplayName: "HBO West", DisplayNames: []string{"HBO West"}},
+		{ID: "DiscoveryScience.us", DisplayName: "Discovery Science", DisplayNames: []string{"Discovery Science"}},
+		{ID: "Channel4.uk", DisplayName: "Channel 4", DisplayNames: []string{"Channel 4"}},
+		{ID: "Channel4Plus1.uk", DisplayName: "Channel 4 +1", DisplayNames: []string{"Channel 4 +1"}},
+	}
+}
+
+func TestMatcherBest(t *testing.T) {
+	m := NewMatcher(testFeed())
+	cases := []struct {
+		name      string
+		cand      Candidate
+		xmltvID   string
+		on        string
+		autoApply bool
+	}{
+		{"tvg id", Candidate{Name: "Cable News", TvgID: "cnn.US"}, "CNN.us", "tvg_id", true},
+		{"prefixed name", Candidate{Name: "US: CNN HD"}, "CNN.us", "name", true},
+		{"compact id", Candidate{Name: "UK: BBC One FHD"}, "BBCOne.uk", "name", true},
+		{"callsign", Candidate{Name: "ABC 7", Callsign: "WABC"}, "WABC.us", "callsign", true},
+		{"timeshift stays distinct", Candidate{Name: "Channel 4 +1 HD"}, "Channel4Plus1.uk", "name", true},
+		{"similar name", Candidate{Name: "Discovery Sci"}, "DiscoveryScience.us", "name", false},
+	}
+	for _, tc := range cases {
+		got, ok := m.Best(tc.cand, nil)
+		if !ok {
+			t.Errorf("%s: no match", tc.name)
+			continue
+		}
+		if got.XMLTVID != tc.xmltvID || got.MatchedOn != tc.on {
+			t.Errorf("%s: got %s via %s, want %s via %s", tc.name, got.XMLTVID, got.MatchedOn, tc.xmltvID, tc.on)
+		}
+		auto := got.Score >= AutoApplyScore && !got.Ambiguous
+		if auto != tc.autoApply {
+			t.Errorf("%s: score %.2f ambiguous=%v, want auto-apply %v", tc.name, got.Score, got.Ambiguous, tc.autoApply)
+		}
+	}
+}
+
+func TestMatcherAmbiguous(t *testing.T) {
+	m := NewMatcher(testFeed())
+	got, ok := m.Best(Candidate{Name: "HBO HD"}, nil)
+	if !ok {
+		t.Fatal("no match")
+	}
+	if !got.Ambiguous {
+		t.Errorf("HBO HD matched %s (%.2f) without ambiguity", got.XMLTVID, got.Score)
+	}
+	if got.XMLTVID != "HBOEast.us" {
+		t.Errorf("tie should pick the lowest id, got %s", got.XMLTVID)
+	}
+}
+
+func TestMatcherNumberBonus(t *testing.T) {
+	m := NewMatcher(testFeed())
+	without, _ := m.Best(Candidate{Name: "ABC New York"}, nil)
+	with, _ := m.Best(Candidate{Name: "ABC New York", Number: "7"}, nil)
+	if with.XMLTVID != "WABC.us" || with.Score <= without.Score {
+		t.Errorf("number should raise the score: %.2f → %.2f (%s)", without.Score, with.Score, with.XMLTVID)
+	}
+}
+
+func TestMatcherSkipAndNoMatch(t *testing.T) {
+	m := NewMatcher(testFeed())
+	skip := func(id string) bool { return id == "CNN.us" }
+	if got, ok := m.Best(Candidate{Name: "CNN"}, skip); ok {
+		t.Errorf("rejected pair matched again: %+v", got)
+	}
+	if got, ok := m.Best(Candidate{Name: "Totally Unrelated"}, nil); ok {
+		t.Errorf("unexpected match %+v", got)
+	}
+}
+
+func TestDice(t *testing.T) {
+	if d := dice("night", "night"); d != 1 {
+		t.Errorf("identical = %v", d)
+	}
+	if d := dice("night", "nacht"); d != 0.25 {
+		t.Errorf("night/nacht = %v, want 0.25", d)
+	}
+	if d := dice("a", "ab"); d != 0 {
+		t.Errorf("short = %v", d)
+	}
+}
@@ -9,6 +9,7 @@ package sync
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -16,6 +17,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/unyeco/roost/services/epg/internal/xmltv"
 )
 
@@ -69,7 +72,8 @@ func SyncFromSources(ctx context.Context, db *sql.DB) ([]*SyncResult, error) {
 // SyncSource performs a full sync cycle for one EPG source:
 //  1. Insert a sync log entry with status=running
 //  2. Fetch XMLTV from source URL (30s timeout)
-//  3. Parse and upsert programs matched by epg_channel_id
+//  3. Parse, map feed channels (epg_channel_id, then fuzzy matching) and
+//     upsert programs
 //  4. Delete programs older than 7 days (only on success)
 //  5. Update sync log with final status
 //
@@ -117,8 +121,9 @@ func SyncSource(ctx context.Context, db *sql.DB, src Source) *SyncResult {
 		return result
 	}
 
-	// Build XMLTV channel ID → Roost channel ID mapping via epg_channel_id
-	xmltvIDToChannelID, err := buildChannelMap(ctx, db, parsed.Channels)
+	// Build XMLTV channel ID → Roost channel IDs mapping via epg_channel_id,
+	// auto-mapping unmapped channels where the match is confident
+	xmltvIDToChannelIDs, err := buildChannelMap(ctx, db, src.ID, parsed.Channels)
 	if err != nil {
 		result.Error = fmt.Errorf("build channel map: %w", err)
 		updateLog("failed", 0, 0, result.Error)
@@ -126,7 +131,7 @@ func SyncSource(ctx context.Context, db *sql.DB, src Source) *SyncResult {
 	}
 
 	// Upsert programs
-	upserted, err := upsertPrograms(ctx, db, src.ID, parsed.Programmes, xmltvIDToChannelID)
+	upserted, err := upsertPrograms(ctx, db, src.ID, parsed.Programmes, xmltvIDToChannelIDs)
 	if err != nil {
 		result.Error = fmt.Errorf("upsert programs: %w", err)
 		updateLog("failed", upserted, 0, result.Error)
@@ -178,11 +183,15 @@ func fetchXMLTV(ctx context.Context, url string) (string, error) {
 	return string(body), nil
 }
 
-// buildChannelMap queries the DB to map XMLTV channel IDs to Roost channel UUIDs.
-// Channels are matched via channels.epg_channel_id = xmltv channel id.
-func buildChannelMap(ctx context.Context, db *sql.DB, xmltvChannels []xmltv.XMLTVChannel) (map[string]string, error) {
+// buildChannelMap maps XMLTV channel IDs to the Roost channel UUIDs that take
+// their guide. Channels are matched via channels.epg_channel_id = xmltv
+// channel id; active channels without an epg_channel_id are then run through
+// the Matcher (see match.go). Confident matches are applied and recorded as
+// accepted suggestions; weaker ones are stored as pending suggestions for
+// this source. Several Roost channels may share one XMLTV channel.
+func buildChannelMap(ctx context.Context, db *sql.DB, sourceID string, xmltvChannels []xmltv.XMLTVChannel) (map[string][]string, error) {
 	if len(xmltvChannels) == 0 {
-		return map[string]string{}, nil
+		return map[string][]string{}, nil
 	}
 
 	// Collect unique XMLTV IDs
@@ -209,18 +218,125 @@ func buildChannelMap(ctx context.Context, db *sql.DB, xmltvChannels []xmltv.XMLT
 	if err != nil {
 		return nil, fmt.Errorf("query channels: %w", err)
 	}
-	defer rows.Close()
 
-	m := map[string]string{}
+	m := map[string][]string{}
 	for rows.Next() {
 		var channelID, epgChannelID string
 		if err := rows.Scan(&channelID, &epgChannelID); err == nil {
-			m[epgChannelID] = channelID
+			m[epgChannelID] = append(m[epgChannelID], channelID)
 		}
 	}
+	rows.Close()
+
+	if err := matchUnmappedChannels(ctx, db, sourceID, xmltvChannels, m); err != nil {
+		// Non-fatal — exact mappings still sync.
+		log.Printf("[epg] match channels for source %s: %v", sourceID, err)
+	}
 	return m, nil
 }
 
+// matchUnmappedChannels scores every active channel without an
+// epg_channel_id against the feed, applies confident matches (adding them to
+// m) and refreshes this source's pending suggestions. Pairs an admin has
+// rejected are never matched again.
+func matchUnmappedChannels(ctx context.Context, db *sql.DB, sourceID string,
+	xmltvChannels []xmltv.XMLTVChannel, m map[string][]string,
+) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, name, COALESCE(tvg_id, ''), COALESCE(callsign, ''), COALESCE(channel_number, '')
+		FROM channels
+		WHERE is_active=true AND COALESCE(epg_channel_id, '')=''`)
+	if err != nil {
+		return fmt.Errorf("query unmapped channels: %w", err)
+	}
+	var candidates []Candidate
+	for rows.Next() {
+		var c Candidate
+		if err := rows.Scan(&c.ID, &c.Name, &c.TvgID, &c.Callsign, &c.Number); err == nil {
+			candidates = append(candidates, c)
+		}
+	}
+	rows.Close()
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	rejected := map[string]bool{} // channelID|xmltvID
+	rows, err = db.QueryContext(ctx, `
+		SELECT channel_id, xmltv_channel_id FROM epg_channel_suggestions
+		WHERE source_id=$1 AND status='rejected'`, sourceID)
+	if err != nil {
+		return fmt.Errorf("query rejected suggestions: %w", err)
+	}
+	for rows.Next() {
+		var channelID, xmltvID string
+		if err := rows.Scan(&channelID, &xmltvID); err == nil {
+			rejected[channelID+"|"+xmltvID] = true
+		}
+	}
+	rows.Close()
+
+	matcher := NewMatcher(xmltvChannels)
+	var pending []string // channelID|xmltvID still suggested by this feed
+	applied, suggested := 0, 0
+	for _, c := range candidates {
+		match, ok := matcher.Best(c, func(xmltvID string) bool {
+			return rejected[c.ID+"|"+xmltvID]
+		})
+		if !ok {
+			continue
+		}
+		status := "pending"
+		if match.Score >= AutoApplyScore && !match.Ambiguous {
+			res, err := db.ExecContext(ctx,
+				`UPDATE channels SET epg_channel_id=$2 WHERE id=$1 AND COALESCE(epg_channel_id, '')=''`,
+				c.ID, match.XMLTVID)
+			if err != nil {
+				log.Printf("[epg] apply match %s → %s: %v", c.ID, match.XMLTVID, err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				continue // mapped concurrently
+			}
+			m[match.XMLTVID] = append(m[match.XMLTVID], c.ID)
+			status = "accepted"
+			applied++
+		} else {
+			pending = append(pending, c.ID+"|"+match.XMLTVID)
+			suggested++
+		}
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO epg_channel_suggestions
+				(channel_id, source_id, xmltv_channel_id, xmltv_display_name, score, matched_on, status)
+			VALUES ($1,$2,$3,$4,$5,$6,$7)
+			ON CONFLICT (channel_id, source_id, xmltv_channel_id) DO UPDATE SET
+				xmltv_display_name = EXCLUDED.xmltv_display_name,
+				score              = EXCLUDED.score,
+				matched_on         = EXCLUDED.matched_on,
+				status             = EXCLUDED.status
+			WHERE epg_channel_suggestions.status='pending'`,
+			c.ID, sourceID, match.XMLTVID, match.DisplayName, match.Score, match.MatchedOn, status)
+		if err != nil {
+			log.Printf("[epg] store suggestion %s → %s: %v", c.ID, match.XMLTVID, err)
+		}
+	}
+
+	// Drop pending suggestions this feed no longer produces.
+	_, err = db.ExecContext(ctx, `
+		DELETE FROM epg_channel_suggestions
+		WHERE source_id=$1 AND status='pending'
+		  AND NOT (channel_id::text || '|' || xmltv_channel_id = ANY($2))`,
+		sourceID, pq.Array(pending))
+	if err != nil {
+		return fmt.Errorf("prune suggestions: %w", err)
+	}
+	if applied > 0 || suggested > 0 {
+		log.Printf("[epg] source %s: auto-mapped %d channel(s), %d suggestion(s) pending review",
+			sourceID, applied, suggested)
+	}
+	return nil
+}
+
 // upsertPrograms inserts or updates programs from the parsed XMLTV feed.
 // source_program_id is derived from the XMLTV channel ID + start time to provide
 // a stable natural key for conflict resolution.
@@ -228,11 +344,11 @@ func buildChannelMap(ctx context.Context, db *sql.DB, xmltvChannels []xmltv.XMLT
 func upsertPrograms(ctx context.Context, db *sql.DB,
 	sourceID string,
 	programmes []xmltv.XMLTVProgramme,
-	channelMap map[string]string,
+	channelMap map[string][]string,
 ) (int, error) {
 	upserted := 0
 	for _, prog := range programmes {
-		channelID, ok := channelMap[prog.ChannelID]
+		channelIDs, ok := channelMap[prog.ChannelID]
 		if !ok {
 			continue // no matching Roost channel for this XMLTV channel
 		}
@@ -240,33 +356,69 @@ func upsertPrograms(ctx context.Context, db *sql.DB,
 		// Natural key: channel_id + start time (ISO8601)
 		sourceProgramID := fmt.Sprintf("%s|%s", prog.ChannelID, prog.Start.UTC().Format(time.RFC3339))
 
-		_, err := db.ExecContext(ctx, `
-			INSERT INTO programs
-				(channel_id, source_program_id, title, description,
-				 start_time, end_time, genre, rating, icon_url, epg_source_id)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
-			ON CONFLICT (channel_id, source_program_id) DO UPDATE SET
-				title       = EXCLUDED.title,
-				description = COALESCE(EXCLUDED.description, programs.description),
-				end_time    = EXCLUDED.end_time,
-				genre       = COALESCE(EXCLUDED.genre, programs.genre),
-				rating      = COALESCE(EXCLUDED.rating, programs.rating),
-				icon_url    = COALESCE(EXCLUDED.icon_url, programs.icon_url),
-				epg_source_id = EXCLUDED.epg_source_id,
-				updated_at  = now()`,
-			channelID, sourceProgramID, prog.Title,
-			nullableString(prog.Description),
-			prog.Start.UTC(), prog.Stop.UTC(),
-			nullableString(prog.Category),
-			nullableString(prog.Rating),
-			nullableString(prog.IconSrc),
-			nullableString(sourceID),
-		)
-		if err != nil {
-			log.Printf("[epg] upsert program %q: %v", prog.Title, err)
-			continue
+		credits := []byte("[]")
+		if len(prog.Credits) > 0 {
+			credits, _ = json.Marshal(prog.Credits)
+		}
+		categories := prog.Categories
+		if categories == nil {
+			categories = []string{}
+		}
+
+		for _, channelID := range channelIDs {
+			_, err := db.ExecContext(ctx, `
+				INSERT INTO programs
+					(channel_id, source_program_id, title, description,
+					 start_time, end_time, genre, rating, icon_url, epg_source_id,
+					 sub_title, categories, season_number, episode_number, episode_onscreen,
+					 dd_progid, credits, air_date, previously_shown, is_new, is_premiere, star_rating)
+				VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,
+				        $11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22)
+				ON CONFLICT (channel_id, source_program_id) DO UPDATE SET
+					title       = EXCLUDED.title,
+					description = COALESCE(EXCLUDED.description, programs.description),
+					end_time    = EXCLUDED.end_time,
+					genre       = COALESCE(EXCLUDED.genre, programs.genre),
+					rating      = COALESCE(EXCLUDED.rating, programs.rating),
+					icon_url    = COALESCE(EXCLUDED.icon_url, programs.icon_url),
+					epg_source_id = EXCLUDED.epg_source_id,
+					sub_title        = COALESCE(EXCLUDED.sub_title, programs.sub_title),
+					categories       = EXCLUDED.categories,
+					season_number    = COALESCE(EXCLUDED.season_number, programs.season_number),
+					episode_number   = COALESCE(EXCLUDED.episode_number, programs.episode_number),
+					episode_onscreen = COALESCE(EXCLUDED.episode_onscreen, programs.episode_onscreen),
+					dd_progid        = COALESCE(EXCLUDED.dd_progid, programs.dd_progid),
+					credits          = EXCLUDED.credits,
+					air_date         = COALESCE(EXCLUDED.air_date, programs.air_date),
+					previously_shown = EXCLUDED.previously_shown,
+					is_new           = EXCLUDED.is_new,
+					is_premiere      = EXCLUDED.is_premiere,
+					star_rating      = COALESCE(EXCLUDED.star_rating, programs.star_rating),
+					updated_at  = now()`,
+				channelID, sourceProgramID, prog.Title,
+				nullableString(prog.Description),
+				prog.Start.UTC(), prog.Stop.UTC(),
+				nullableString(prog.Category),
+				nullableString(prog.Rating),
+				nullableString(prog.IconSrc),
+				nullableString(sourceID),
+				nullableString(prog.SubTitle),
+				pq.Array(categories),
+				nullableInt(prog.Episode.Season),
+				nullableInt(prog.Episode.Episode),
+				nullableString(prog.Episode.OnScreen),
+				nullableString(prog.Episode.DDProgID),
+				credits,
+				nullableString(prog.Date),
+				prog.PreviouslyShown, prog.New, prog.Premiere,
+				nullableString(prog.StarRating),
+			)
+			if err != nil {
+				log.Printf("[epg] upsert program %q: %v", prog.Title, err)
+				continue
+			}
+			upserted++
 		}
-		upserted++
 	}
 	return upserted, nil
 }
@@ -290,3 +442,11 @@ func nullableString(s string) *string {
 	}
 	return &s
 }
+
+// nullableInt converts 0 (unknown) to nil.
+func nullableInt(n int) *int {
+	if n == 0 {
+		return nil
+	}
+	return &n
+}
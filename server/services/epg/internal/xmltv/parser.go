@@ -2,7 +2,9 @@
 // XMLTV is the standard EPG (Electronic Program Guide) data format used
 // by most IPTV providers. This parser handles the common subset of the
 // XMLTV spec: channels with display names and icons, programmes with
-// title, description, categories, and ratings.
+// title, sub-title, description, categories, ratings, star ratings,
+// credits, episode numbers (xmltv_ns, onscreen and dd_progid systems), the
+// production date, and the previously-shown / new / premiere flags.
 //
 // XMLTV date format: YYYYMMDDHHmmss +ZZZZ (e.g. "20260223140000 +0000")
 package xmltv
@@ -11,6 +13,9 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,21 +24,47 @@ const xmltvDateLayout = "20060102150405 -0700"
 
 // XMLTVChannel represents a parsed <channel> element.
 type XMLTVChannel struct {
-	ID          string // XMLTV id attribute
-	DisplayName string // <display-name> content
-	IconSrc     string // <icon src="..."/> URL
+	ID           string   // XMLTV id attribute
+	DisplayName  string   // first <display-name> content
+	DisplayNames []string // every <display-name>, in document order
+	IconSrc      string   // <icon src="..."/> URL
 }
 
 // XMLTVProgramme represents a parsed <programme> element.
 type XMLTVProgramme struct {
-	ChannelID   string    // channel attribute (matches XMLTVChannel.ID)
-	Start       time.Time // parsed start time
-	Stop        time.Time // parsed end time
-	Title       string    // <title> content
-	Description string    // <desc> content
-	Category    string    // first <category> content
-	Rating      string    // <rating><value> or <rating system=""> content
-	IconSrc     string    // <icon src="..."/>
+	ChannelID       string     // channel attribute (matches XMLTVChannel.ID)
+	Start           time.Time  // parsed start time
+	Stop            time.Time  // parsed end time
+	Title           string     // <title> content
+	SubTitle        string     // <sub-title> content (usually the episode title)
+	Description     string     // <desc> content
+	Category        string     // first <category> content
+	Categories      []string   // every non-empty <category>, in document order
+	Rating          string     // <rating><value> or <rating system=""> content
+	StarRating      string     // first <star-rating><value>, e.g. "3/4"
+	IconSrc         string     // <icon src="..."/>
+	Episode         EpisodeNum // <episode-num> values
+	Credits         []Credit   // <credits> children, in document order
+	Date            string     // <date>: "2019", "2019-03" or "2019-03-04"
+	PreviouslyShown bool       // <previously-shown/> present
+	New             bool       // <new/> present
+	Premiere        bool       // <premiere> present
+}
+
+// EpisodeNum holds a programme's <episode-num> values. Season and Episode
+// are 1-based; 0 means unknown.
+type EpisodeNum struct {
+	Season   int
+	Episode  int
+	OnScreen string // onscreen (or "common") system text, e.g. "S02E05"
+	DDProgID string // dd_progid system, e.g. "EP01234567.0005"
+}
+
+// Credit is one child of <credits>.
+type Credit struct {
+	Role      string `json:"role"` // element name: director, actor, writer, presenter, …
+	Name      string `json:"name"`
+	Character string `json:"character,omitempty"` // <actor role="..."> attribute
 }
 
 // Result holds the parsed XMLTV document.
@@ -44,9 +75,9 @@ type Result struct {
 
 // xmlChannel is the raw XML structure for <channel>.
 type xmlChannel struct {
-	ID          string `xml:"id,attr"`
-	DisplayName string `xml:"display-name"`
-	Icon        struct {
+	ID           string   `xml:"id,attr"`
+	DisplayNames []string `xml:"display-name"`
+	Icon         struct {
 		Src string `xml:"src,attr"`
 	} `xml:"icon"`
 }
@@ -66,6 +97,25 @@ type xmlProgramme struct {
 		System string `xml:"system,attr"`
 		Value  string `xml:"value"`
 	} `xml:"rating"`
+	SubTitle    string `xml:"sub-title"`
+	EpisodeNums []struct {
+		System string `xml:"system,attr"`
+		Value  string `xml:",chardata"`
+	} `xml:"episode-num"`
+	Credits struct {
+		People []struct {
+			XMLName xml.Name
+			Role    string `xml:"role,attr"`
+			Name    string `xml:",chardata"`
+		} `xml:",any"`
+	} `xml:"credits"`
+	Date            string    `xml:"date"`
+	PreviouslyShown *struct{} `xml:"previously-shown"`
+	New             *struct{} `xml:"new"`
+	Premiere        *struct{} `xml:"premiere"`
+	StarRating      []struct {
+		Value string `xml:"value"`
+	} `xml:"star-rating"`
 }
 
 // parseXMLTVDate parses an XMLTV timestamp string into time.Time.
@@ -120,11 +170,16 @@ func ParseReader(r io.Reader) (*Result, error) {
 				if raw.ID == "" {
 					continue
 				}
-				result.Channels = append(result.Channels, XMLTVChannel{
-					ID:          raw.ID,
-					DisplayName: raw.DisplayName,
-					IconSrc:     raw.Icon.Src,
-				})
+				ch := XMLTVChannel{ID: raw.ID, IconSrc: raw.Icon.Src}
+				for _, name := range raw.DisplayNames {
+					if name = strings.TrimSpace(name); name != "" {
+						ch.DisplayNames = append(ch.DisplayNames, name)
+					}
+				}
+				if len(ch.DisplayNames) > 0 {
+					ch.DisplayName = ch.DisplayNames[0]
+				}
+				result.Channels = append(result.Channels, ch)
 
 			case "programme":
 				if !inTV {
@@ -144,9 +199,15 @@ func ParseReader(r io.Reader) (*Result, error) {
 					continue // skip programme with unparseable stop time
 				}
 
+				var categories []string
+				for _, c := range raw.Category {
+					if c = strings.TrimSpace(c); c != "" {
+						categories = append(categories, c)
+					}
+				}
 				category := ""
-				if len(raw.Category) > 0 {
-					category = raw.Category[0]
+				if len(categories) > 0 {
+					category = categories[0]
 				}
 
 				rating := ""
@@ -157,15 +218,61 @@ func ParseReader(r io.Reader) (*Result, error) {
 					}
 				}
 
+				starRating := ""
+				for _, sr := range raw.StarRating {
+					if v := strings.TrimSpace(sr.Value); v != "" {
+						starRating = v
+						break
+					}
+				}
+
+				var episode EpisodeNum
+				for _, en := range raw.EpisodeNums {
+					v := strings.TrimSpace(en.Value)
+					switch strings.ToLower(en.System) {
+					case "xmltv_ns":
+						episode.Season, episode.Episode = parseXMLTVNS(v)
+					case "onscreen", "common", "sxxexx":
+						episode.OnScreen = v
+					case "dd_progid":
+						episode.DDProgID = v
+					}
+				}
+				if episode.Season == 0 && episode.Episode == 0 && episode.OnScreen != "" {
+					episode.Season, episode.Episode = parseOnScreen(episode.OnScreen)
+				}
+
+				var credits []Credit
+				for _, p := range raw.Credits.People {
+					name := strings.TrimSpace(p.Name)
+					if name == "" {
+						continue
+					}
+					c := Credit{Role: p.XMLName.Local, Name: name}
+					if c.Role == "actor" {
+						c.Character = strings.TrimSpace(p.Role)
+					}
+					credits = append(credits, c)
+				}
+
 				result.Programmes = append(result.Programmes, XMLTVProgramme{
-					ChannelID:   raw.Channel,
-					Start:       start,
-					Stop:        stop,
-					Title:       raw.Title,
-					Description: raw.Desc,
-					Category:    category,
-					Rating:      rating,
-					IconSrc:     raw.Icon.Src,
+					ChannelID:       raw.Channel,
+					Start:           start,
+					Stop:            stop,
+					Title:           raw.Title,
+					SubTitle:        strings.TrimSpace(raw.SubTitle),
+					Description:     raw.Desc,
+					Category:        category,
+					Categories:      categories,
+					Rating:          rating,
+					StarRating:      starRating,
+					IconSrc:         raw.Icon.Src,
+					Episode:         episode,
+					Credits:         credits,
+					Date:            parseXMLTVDay(raw.Date),
+					PreviouslyShown: raw.PreviouslyShown != nil,
+					New:             raw.New != nil,
+					Premiere:        raw.Premiere != nil,
 				})
 			}
 
@@ -178,3 +285,59 @@ func ParseReader(r io.Reader) (*Result, error) {
 
 	return result, nil
 }
+
+// parseXMLTVNS reads the season and episode from an xmltv_ns episode number
+// ("season.episode.part", zero-based, each part optionally "n/total" and
+// possibly empty). Returns 1-based values, 0 when a part is missing.
+func parseXMLTVNS(s string) (season, episode int) {
+	parts := strings.Split(s, ".")
+	num := func(i int) int {
+		if i >= len(parts) {
+			return 0
+		}
+		v := strings.TrimSpace(parts[i])
+		if slash := strings.IndexByte(v, '/'); slash >= 0 {
+			v = strings.TrimSpace(v[:slash])
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0
+		}
+		return n + 1
+	}
+	return num(0), num(1)
+}
+
+// parseOnScreen reads the season and episode from an on-screen episode
+// number such as "S02E05" or "s2 e5". Returns 0s when it has another form.
+func parseOnScreen(s string) (season, episode int) {
+	m := onScreenPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0
+	}
+	season, _ = strconv.Atoi(m[1])
+	episode, _ = strconv.Atoi(m[2])
+	return season, episode
+}
+
+var onScreenPattern = regexp.MustCompile(`(?i)s\s*(\d+)\s*e\s*(\d+)`)
+
+// parseXMLTVDay normalizes a <date> value (YYYY, YYYYMM or YYYYMMDD, possibly
+// followed by a time) to "YYYY", "YYYY-MM" or "YYYY-MM-DD". Returns "" when
+// it does not start with a year.
+func parseXMLTVDay(s string) string {
+	s = strings.TrimSpace(s)
+	n := 0
+	for n < len(s) && n < 8 && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	switch {
+	case n >= 8:
+		return s[:4] + "-" + s[4:6] + "-" + s[6:8]
+	case n >= 6:
+		return s[:4] + "-" + s[4:6]
+	case n >= 4:
+		return s[:4]
+	}
+	return ""
+}
@@ -55,6 +55,32 @@ func TestParseChannelFields(t *testing.T) {
 	}
 }
 
+// TestParseMultipleDisplayNames verifies every display-name is kept and the
+// first one is used as DisplayName.
+func TestParseMultipleDisplayNames(t *testing.T) {
+	const doc = `<?xml version="1.0"?><tv>
+		<channel id="wabc.us">
+			<display-name>WABC</display-name>
+			<display-name> 7 ABC New York </display-name>
+			<display-name></display-name>
+		</channel>
+	</tv>`
+	result, err := xmltv.ParseReader(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Channels) != 1 {
+		t.Fatalf("expected 1 channel, got %d", len(result.Channels))
+	}
+	ch := result.Channels[0]
+	if ch.DisplayName != "WABC" {
+		t.Errorf("expected display name 'WABC', got %q", ch.DisplayName)
+	}
+	if len(ch.DisplayNames) != 2 || ch.DisplayNames[1] != "7 ABC New York" {
+		t.Errorf("unexpected display names %q", ch.DisplayNames)
+	}
+}
+
 // TestParseProgramCount verifies all 30 programs are parsed (10 per channel).
 func TestParseProgramCount(t *testing.T) {
 	result := loadSampleFixture(t)
@@ -174,3 +200,83 @@ func TestMalformedProgramSkipped(t *testing.T) {
 		t.Errorf("expected 'Good Program', got %q", result.Programmes[0].Title)
 	}
 }
+
+// TestParseRichProgramme verifies episode numbers, sub-title, credits,
+// flags, date, star rating and all categories are kept.
+func TestParseRichProgramme(t *testing.T) {
+	const doc = `<?xml version="1.0"?><tv>
+		<channel id="ch1"><display-name>Test</display-name></channel>
+		<programme start="20260224000000 +0000" stop="20260224010000 +0000" channel="ch1">
+			<title>The Show</title>
+			<sub-title>The One With The Pilot</sub-title>
+			<desc>It begins.</desc>
+			<credits>
+				<director>Jane Director</director>
+				<actor role="Sam">Alex Actor</actor>
+				<actor>  </actor>
+				<presenter>Pat Host</presenter>
+			</credits>
+			<date>20190304</date>
+			<category>Drama</category>
+			<category>Series</category>
+			<episode-num system="xmltv_ns">1 . 4/12 . 0/1</episode-num>
+			<episode-num system="onscreen">S02E05</episode-num>
+			<episode-num system="dd_progid">EP01234567.0005</episode-num>
+			<previously-shown start="20190304200000"/>
+			<premiere>Season premiere</premiere>
+			<new/>
+			<star-rating><value>3/4</value></star-rating>
+		</programme>
+		<programme start="20260224010000 +0000" stop="20260224020000 +0000" channel="ch1">
+			<title>Other Show</title>
+			<episode-num system="onscreen">s3 e10</episode-num>
+		</programme>
+	</tv>`
+	result, err := xmltv.ParseReader(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Programmes) != 2 {
+		t.Fatalf("expected 2 programmes, got %d", len(result.Programmes))
+	}
+	p := result.Programmes[0]
+	if p.SubTitle != "The One With The Pilot" {
+		t.Errorf("sub-title = %q", p.SubTitle)
+	}
+	if p.Episode.Season != 2 || p.Episode.Episode != 5 {
+		t.Errorf("episode = S%dE%d, want S2E5", p.Episode.Season, p.Episode.Episode)
+	}
+	if p.Episode.OnScreen != "S02E05" || p.Episode.DDProgID != "EP01234567.0005" {
+		t.Errorf("episode-num text = %+v", p.Episode)
+	}
+	if p.Category != "Drama" || len(p.Categories) != 2 || p.Categories[1] != "Series" {
+		t.Errorf("categories = %q / %q", p.Category, p.Categories)
+	}
+	want := []xmltv.Credit{
+		{Role: "director", Name: "Jane Director"},
+		{Role: "actor", Name: "Alex Actor", Character: "Sam"},
+		{Role: "presenter", Name: "Pat Host"},
+	}
+	if len(p.Credits) != len(want) {
+		t.Fatalf("credits = %+v", p.Credits)
+	}
+	for i := range want {
+		if p.Credits[i] != want[i] {
+			t.Errorf("credit %d = %+v, want %+v", i, p.Credits[i], want[i])
+		}
+	}
+	if p.Date != "2019-03-04" || p.StarRating != "3/4" {
+		t.Errorf("date %q, star rating %q", p.Date, p.StarRating)
+	}
+	if !p.PreviouslyShown || !p.New || !p.Premiere {
+		t.Errorf("flags previously-shown=%v new=%v premiere=%v", p.PreviouslyShown, p.New, p.Premiere)
+	}
+
+	q := result.Programmes[1]
+	if q.Episode.Season != 3 || q.Episode.Episode != 10 {
+		t.Errorf("onscreen fallback = S%dE%d, want S3E10", q.Episode.Season, q.Episode.Episode)
+	}
+	if q.PreviouslyShown || q.New || q.Premiere || q.Date != "" || q.Categories != nil {
+		t.Errorf("bare programme picked up fields: %+v", q)
+	}
+}
@@ -11,9 +11,13 @@
 //   GET    /compositor/sessions/:id/stream.m3u8 — HLS playlist (no auth — behind relay)
 //   GET    /compositor/sessions/:id/:segment    — serve HLS segments
 //   GET    /health
+//
+// On-demand ingest (env INGEST_URL set): sessions report viewer demand for
+// their channels to the ingest service, as the relay does for playback.
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -33,6 +37,7 @@ type config struct {
 	Port       string
 	SegmentDir string
 	OutputBase string
+	IngestURL  string // on-demand ingest; "" when every channel runs
 }
 
 func loadConfig() config {
@@ -40,6 +45,7 @@ func loadConfig() config {
 		Port:       getEnv("COMPOSITOR_PORT", "8100"),
 		SegmentDir: getEnv("SEGMENT_DIR", "/var/roost/segments"),
 		OutputBase: getEnv("COMPOSITOR_OUTPUT_DIR", "/var/roost/compositor"),
+		IngestURL:  strings.TrimSuffix(getEnv("INGEST_URL", ""), "/"),
 	}
 }
 
@@ -210,6 +216,29 @@ func pathSegment(path string, n int) string {
 	return parts[n]
 }
 
+// ingestDemand returns a DemandFunc that reports demand to the ingest service.
+func ingestDemand(ingestURL string) compositor.DemandFunc {
+	client := &http.Client{Timeout: 3 * time.Second}
+	return func(ctx context.Context, slug string) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			ingestURL+"/internal/channels/"+slug+"/demand", nil)
+		if err != nil {
+			return
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[compositor] ingest demand for %q: %v", slug, err)
+			}
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			log.Printf("[compositor] ingest demand for %q: status %d", slug, resp.StatusCode)
+		}
+	}
+}
+
 func main() {
 	cfg := loadConfig()
 	if err := os.MkdirAll(cfg.OutputBase, 0o755); err != nil {
@@ -217,6 +246,10 @@ func main() {
 	}
 
 	mgr := compositor.New(cfg.SegmentDir, cfg.OutputBase)
+	if cfg.IngestURL != "" {
+		mgr.SetDemandFunc(ingestDemand(cfg.IngestURL), 15*time.Second)
+		log.Printf("[compositor] on-demand ingest via %s", cfg.IngestURL)
+	}
 	h := &handler{cfg: cfg, mgr: mgr}
 
 	mux := http.NewServeMux()
@@ -11,6 +11,10 @@
 // Each session runs a single FFmpeg process consuming N input HLS streams
 // and producing one composite HLS stream at a fixed output resolution.
 // Output: /var/roost/compositor/{session_id}/stream.m3u8
+//
+// With on-demand ingest (SetDemandFunc) a session reports viewer demand for
+// each of its channels while it runs, so idle channels start and are not
+// stopped under it.
 package compositor
 
 import (
@@ -79,6 +83,19 @@ type Manager struct {
 	outputBase string // where compositor stores output: {outputBase}/{session_id}/
 	mu         sync.RWMutex
 	sessions   map[string]*Session
+
+	demandFn      DemandFunc
+	demandRefresh time.Duration
+}
+
+// DemandFunc reports viewer demand for a channel to the ingest service.
+type DemandFunc func(ctx context.Context, channelSlug string)
+
+// SetDemandFunc sets the function told about viewer demand, called for each
+// channel of a running session every refresh. Call before CreateSession.
+func (m *Manager) SetDemandFunc(fn DemandFunc, refresh time.Duration) {
+	m.demandFn = fn
+	m.demandRefresh = refresh
 }
 
 // New creates a compositor Manager.
@@ -98,9 +115,11 @@ func (m *Manager) CreateSession(ctx context.Context, layout Layout, channelSlugs
 		return nil, fmt.Errorf("layout %s requires exactly %d channel(s), got %d",
 			layout, required, len(channelSlugs))
 	}
+	// An on-demand channel has no stream until demand starts it; FFmpeg
+	// retries until it has.
 	for _, slug := range channelSlugs {
 		m3u8 := filepath.Join(m.segmentDir, slug, "stream.m3u8")
-		if _, err := os.Stat(m3u8); err != nil {
+		if _, err := os.Stat(m3u8); err != nil && m.demandFn == nil {
 			return nil, fmt.Errorf("channel %q has no active HLS stream at %s", slug, m3u8)
 		}
 	}
@@ -126,6 +145,9 @@ func (m *Manager) CreateSession(ctx context.Context, layout Layout, channelSlugs
 	m.sessions[id] = sess
 	m.mu.Unlock()
 
+	if m.demandFn != nil {
+		go m.reportDemand(sessCtx, sess)
+	}
 	go m.run(sessCtx, sess)
 	return sess, nil
 }
@@ -166,6 +188,22 @@ func (m *Manager) ListSessions() []*Session {
 	return out
 }
 
+// reportDemand reports demand for the session's channels until it stops.
+func (m *Manager) reportDemand(ctx context.Context, sess *Session) {
+	t := time.NewTicker(m.demandRefresh)
+	defer t.Stop()
+	for {
+		for _, slug := range sess.ChannelSlugs {
+			m.demandFn(ctx, slug)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
 // run executes FFmpeg for the session, restarting on non-context errors.
 func (m *Manager) run(ctx context.Context, sess *Session) {
 	defer close(sess.done)
@@ -2,17 +2,36 @@
 // Polls active channels from Postgres, manages FFmpeg pipelines, serves health endpoint.
 // Port: 8094 (env: INGEST_PORT). Internal service — not exposed directly to subscribers.
 //
+// INGEST_MODE=on_demand starts a channel's pipeline when a reader of its
+// segments (the relay, owl_api's HDHomeRun tuner, the grid compositor)
+// reports a viewer and stops it after INGEST_IDLE_LINGER (default 2m)
+// without one.
+// Channels with channels.always_on, catchup enabled, or a DVR recording
+// running or due within 2 minutes are kept running regardless.
+//
+// Each channel's sources are channels.source_url plus its enabled
+// channel_ingest_sources rows, ranked by the latest arbitrage probe score
+// (source_quality_log, last minute; unprobed sources last) and then
+// priority. The catalog's arbitrage engine probes only the channels listed
+// by /internal/channels/active. The pipeline fails
+// over down that list when a source stalls or keeps failing. Stalker
+// channels have no stored source_url and run only on viewer demand in either
+// mode; see providers.go.
+//
 // Endpoints:
-//   GET /health              — JSON health (no auth)
-//   GET /channels/health     — per-channel health map
-//   GET /alerts              — active stream alerts
-//   GET /metrics             — Prometheus metrics (no auth; firewall-protected)
+//   GET  /health                          — JSON health (no auth)
+//   GET  /channels/health                 — per-channel health map
+//   GET  /alerts                          — active stream alerts
+//   GET  /metrics                         — Prometheus metrics (no auth; firewall-protected)
+//   POST /internal/channels/:slug/demand  — viewer demand (on-demand mode and provider channels)
+//   GET  /internal/channels/active        — IDs of channels with a pipeline (running or failing)
 package main
 
 import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -87,6 +106,29 @@ func main() {
 		cfg.RestartWindow,
 		healthCallback,
 	)
+	mgr.SetFailover(cfg.StallTimeout, cfg.FailoverAfter)
+	resolver := newProviderResolver(db)
+	mgr.SetResolver(resolver.Resolve)
+
+	sealStoredSecrets(context.Background(), db)
+
+	mgr.SetIdleLinger(cfg.IdleLinger)
+	if cfg.Mode == "on_demand" {
+		mgr.EnableOnDemand(cfg.IdleLinger)
+		log.Printf("[ingest] on-demand mode, idle channels stop after %s", cfg.IdleLinger)
+	}
+
+	// Stops demand-started channels (every channel in on-demand mode, provider
+	// channels in either mode) once their viewers are gone.
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n := mgr.StopIdle(); n > 0 {
+				pipeline.MetricActiveChannels.Set(float64(mgr.ActiveCount()))
+			}
+		}
+	}()
 
 	// Start disk usage monitor (logs warning at 80% usage)
 	pipeline.DiskUsageMonitor(cfg.SegmentDir)
@@ -168,6 +210,31 @@ func main() {
 		json.NewEncoder(w).Encode(snap)
 	})
 
+	// Viewer demand from the relay — starts or keeps alive an on-demand pipeline.
+	mux.HandleFunc("POST /internal/channels/{slug}/demand", func(w http.ResponseWriter, r *http.Request) {
+		slug := r.PathValue("slug")
+		started, err := mgr.Demand(slug)
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, pipeline.ErrUnknownChannel) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error":"unknown_channel"}`)
+			return
+		}
+		if started {
+			pipeline.MetricActiveChannels.Set(float64(mgr.ActiveCount()))
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, `{"slug":%q,"status":"starting"}`, slug)
+			return
+		}
+		fmt.Fprintf(w, `{"slug":%q,"status":"running"}`, slug)
+	})
+
+	// Channels with a pipeline, for the catalog's arbitrage probes.
+	mux.HandleFunc("GET /internal/channels/active", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"channel_ids": mgr.ActiveChannelIDs()})
+	})
+
 	// Prometheus metrics endpoint — protected by firewall in production
 	mux.Handle("GET /metrics", promhttp.Handler())
 
@@ -200,10 +267,22 @@ func main() {
 // fetchActiveChannels queries all active channels from Postgres.
 func fetchActiveChannels(db *sql.DB) ([]pipeline.Channel, error) {
 	rows, err := db.QueryContext(context.Background(), `
-		SELECT id, slug, source_url, source_type, bitrate_config, is_active
-		FROM channels
-		WHERE is_active = true
-		ORDER BY sort_order, name
+		SELECT c.id, c.slug, c.source_url, c.source_type, c.bitrate_config, c.is_active,
+		       c.always_on
+		       OR COALESCE(cs.enabled, false)
+		       OR EXISTS (
+		           SELECT 1 FROM dvr_recordings r
+		           WHERE r.channel_id = c.id
+		             AND (r.status = 'recording'
+		                  OR (r.status = 'scheduled'
+		                      AND r.start_time - make_interval(secs => r.padding_before_secs)
+		                          <= NOW() + INTERVAL '2 minutes'))),
+		       c.source_url = '' AND COALESCE(p.provider_type, '') = 'stalker'
+		FROM channels c
+		LEFT JOIN catchup_settings cs ON cs.channel_id = c.id
+		LEFT JOIN ingest_providers p ON p.id = c.provider_id
+		WHERE c.is_active = true
+		ORDER BY c.sort_order, c.name
 	`)
 	if err != nil {
 		return nil, fmt.Errorf("fetchActiveChannels: %w", err)
@@ -214,7 +293,7 @@ func fetchActiveChannels(db *sql.DB) ([]pipeline.Channel, error) {
 	for rows.Next() {
 		var ch pipeline.Channel
 		var bitrateJSON []byte
-		err := rows.Scan(&ch.ID, &ch.Slug, &ch.SourceURL, &ch.SourceType, &bitrateJSON, &ch.IsActive)
+		err := rows.Scan(&ch.ID, &ch.Slug, &ch.SourceURL, &ch.SourceType, &bitrateJSON, &ch.IsActive, &ch.AlwaysOn, &ch.OnDemand)
 		if err != nil {
 			return nil, fmt.Errorf("scan channel: %w", err)
 		}
@@ -226,5 +305,63 @@ func fetchActiveChannels(db *sql.DB) ([]pipeline.Channel, error) {
 		}
 		channels = append(channels, ch)
 	}
-	return channels, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sources, err := fetchChannelSources(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range channels {
+		channels[i].Sources = sources[channels[i].ID]
+	}
+	return channels, nil
+}
+
+// fetchChannelSources returns each active channel's sources in failover
+// order. The channel's own source_url takes part with its channel ID as the
+// source ID (the ID the arbitrage engine probes it under). A failed probe
+// scores 0; a source without a recent probe ranks after every probed one.
+// A Stalker channel's own source has an empty URL, resolved by the pipeline
+// on each start.
+func fetchChannelSources(db *sql.DB) (map[string][]pipeline.Source, error) {
+	rows, err := db.QueryContext(context.Background(), `
+		SELECT s.channel_id, s.id, s.url
+		FROM (
+		    SELECT c.id AS channel_id, c.id, c.source_url AS url, -1 AS priority
+		    FROM channels c
+		    LEFT JOIN ingest_providers p ON p.id = c.provider_id
+		    WHERE c.is_active = true
+		      AND (c.source_url <> '' OR p.provider_type = 'stalker')
+		    UNION ALL
+		    SELECT cis.channel_id, cis.id, cis.url, cis.priority
+		    FROM channel_ingest_sources cis
+		    JOIN channels c ON c.id = cis.channel_id AND c.is_active = true
+		    WHERE cis.is_enabled = true
+		) s
+		LEFT JOIN LATERAL (
+		    SELECT q.score FROM source_quality_log q
+		    WHERE q.channel_id = s.channel_id AND q.source_id = s.id
+		      AND q.measured_at > NOW() - INTERVAL '1 minute'
+		    ORDER BY q.measured_at DESC
+		    LIMIT 1
+		) q ON true
+		ORDER BY s.channel_id, q.score DESC NULLS LAST, s.priority
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("fetchChannelSources: %w", err)
+	}
+	defer rows.Close()
+
+	sources := make(map[string][]pipeline.Source)
+	for rows.Next() {
+		var channelID string
+		var src pipeline.Source
+		if err := rows.Scan(&channelID, &src.ID, &src.URL); err != nil {
+			return nil, fmt.Errorf("scan channel source: %w", err)
+		}
+		sources[channelID] = append(sources[channelID], src)
+	}
+	return sources, rows.Err()
 }
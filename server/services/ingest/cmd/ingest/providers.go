@@ -0,0 +1,209 @@
+// providers.go — Stream URLs for channels imported from portal providers.
+//
+// Stalker channels are synced with an empty source_url: create_link URLs
+// carry single-use play tokens, so none is stored. They run only on viewer
+// demand (pipeline.Channel.OnDemand), and the pipeline calls
+// providerResolver.Resolve on every start and restart, so the portal is asked
+// for a link only while someone is watching. A channel whose link fails is
+// backed off (30s doubling to 1h) before the portal is asked again. The first
+// successful resolve stamps channels.source_resolved_at (migration 095).
+// Resolved URLs are never logged.
+//
+// Portal MACs are stored sealed as config.mac_enc (providers.SealConfig,
+// keyed by INGEST_PROVIDER_KEY). sealStoredSecrets seals any plaintext mac
+// left in ingest_providers at startup; a provider still holding one is not
+// used.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/unyeco/roost/services/ingest/internal/providers"
+)
+
+const (
+	resolveBackoffMin = 30 * time.Second
+	resolveBackoffMax = time.Hour
+)
+
+// providerResolver resolves stream URLs through the channel's ingest provider.
+// Providers are kept between calls so portal tokens are reused.
+type providerResolver struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	providers map[string]cachedProvider // ingest_providers.id → provider
+	backoff   map[string]resolveBackoff // channel id → failed-resolve backoff
+}
+
+type cachedProvider struct {
+	provider providers.IngestProvider
+	config   string // raw config JSON the provider was built from
+}
+
+// resolveBackoff holds off create_link calls for a channel that failed.
+type resolveBackoff struct {
+	until time.Time
+	delay time.Duration
+}
+
+func newProviderResolver(db *sql.DB) *providerResolver {
+	return &providerResolver{
+		db:        db,
+		providers: make(map[string]cachedProvider),
+		backoff:   make(map[string]resolveBackoff),
+	}
+}
+
+// Resolve returns a fresh stream URL for a provider channel. It satisfies
+// pipeline.Resolver.
+func (r *providerResolver) Resolve(ctx context.Context, channelID string) (string, error) {
+	if wait := r.backedOff(channelID); wait > 0 {
+		return "", fmt.Errorf("provider stream failed recently, retrying in %s", wait.Round(time.Second))
+	}
+
+	var externalID, providerID, providerType string
+	var config []byte
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COALESCE(c.source_external_id, ''), p.id, p.provider_type, p.config
+		FROM channels c
+		JOIN ingest_providers p ON p.id = c.provider_id
+		WHERE c.id = $1 AND p.is_active = true
+	`, channelID).Scan(&externalID, &providerID, &providerType, &config)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("channel has no source URL and no active provider")
+	}
+	if err != nil {
+		return "", fmt.Errorf("lookup provider: %w", err)
+	}
+
+	p, err := r.provider(providerID, providerType, config)
+	if err != nil {
+		return "", err
+	}
+	u, err := p.GetStreamURL(ctx, externalID)
+	if err != nil {
+		r.fail(channelID)
+		return "", err
+	}
+	r.succeed(channelID)
+	if _, err := r.db.ExecContext(ctx, `
+		UPDATE channels SET source_resolved_at = now(), updated_at = now()
+		WHERE id = $1 AND source_resolved_at IS NULL
+	`, channelID); err != nil {
+		log.Printf("[ingest] mark channel %s resolved: %v", channelID, err)
+	}
+	return u, nil
+}
+
+// backedOff returns how long channelID must still wait before its provider
+// is asked again, or 0.
+func (r *providerResolver) backedOff(channelID string) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.backoff[channelID]
+	if !ok {
+		return 0
+	}
+	if wait := b.until.Sub(time.Now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// fail doubles channelID's backoff, starting at resolveBackoffMin.
+func (r *providerResolver) fail(channelID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delay := resolveBackoffMin
+	if b, ok := r.backoff[channelID]; ok {
+		delay = min(b.delay*2, resolveBackoffMax)
+	}
+	r.backoff[channelID] = resolveBackoff{until: time.Now().Add(delay), delay: delay}
+}
+
+func (r *providerResolver) succeed(channelID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.backoff, channelID)
+}
+
+// provider returns the cached provider for id, rebuilding it when its
+// config has changed.
+func (r *providerResolver) provider(id, providerType string, config []byte) (providers.IngestProvider, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cp, ok := r.providers[id]; ok && cp.config == string(config) {
+		return cp.provider, nil
+	}
+	stored := map[string]string{}
+	if err := json.Unmarshal(config, &stored); err != nil {
+		return nil, fmt.Errorf("provider %s config: %w", id, err)
+	}
+	if providers.HasPlainSecrets(stored) {
+		return nil, fmt.Errorf("provider %s stores its credentials unencrypted; set INGEST_PROVIDER_KEY so ingest can seal them", id)
+	}
+	cfg, err := providers.OpenConfig(stored)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %w", id, err)
+	}
+	p, err := providers.NewProvider(providerType, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %w", id, err)
+	}
+	r.providers[id] = cachedProvider{provider: p, config: string(config)}
+	return p, nil
+}
+
+// sealStoredSecrets encrypts provider credentials still stored in plaintext.
+func sealStoredSecrets(ctx context.Context, db *sql.DB) {
+	rows, err := db.QueryContext(ctx, `SELECT id, config FROM ingest_providers`)
+	if err != nil {
+		log.Printf("[ingest] seal provider secrets: %v", err)
+		return
+	}
+	type plain struct {
+		id     string
+		config map[string]string
+	}
+	var list []plain
+	for rows.Next() {
+		var p plain
+		var raw []byte
+		if err := rows.Scan(&p.id, &raw); err != nil {
+			break
+		}
+		if json.Unmarshal(raw, &p.config) != nil || !providers.HasPlainSecrets(p.config) {
+			continue
+		}
+		list = append(list, p)
+	}
+	rows.Close()
+
+	for _, p := range list {
+		sealed, err := providers.SealConfig(p.config)
+		if errors.Is(err, providers.ErrNoProviderKey) {
+			log.Printf("[ingest] %d provider(s) store credentials unencrypted and are disabled: %v", len(list), err)
+			return
+		}
+		if err != nil {
+			log.Printf("[ingest] seal provider %s: %v", p.id, err)
+			continue
+		}
+		raw, _ := json.Marshal(sealed)
+		if _, err := db.ExecContext(ctx, `
+			UPDATE ingest_providers SET config = $2, updated_at = now() WHERE id = $1
+		`, p.id, raw); err != nil {
+			log.Printf("[ingest] seal provider %s: %v", p.id, err)
+			continue
+		}
+		log.Printf("[ingest] provider %s credentials sealed", p.id)
+	}
+}
@@ -184,6 +184,180 @@ func TestManagerSyncAndStop(t *testing.T) {
 	}
 }
 
+func TestManagerOnDemand(t *testing.T) {
+	mgr := pipeline.NewManager(t.TempDir(), 2, 1*time.Second, nil)
+	mgr.EnableOnDemand(50 * time.Millisecond)
+	defer mgr.StopAll()
+
+	passthrough := pipeline.BitrateConfig{Mode: "passthrough"}
+	mgr.Sync([]pipeline.Channel{
+		{ID: "ch-idle", Slug: "idle", SourceURL: "http://fake/idle", IsActive: true, BitrateConfig: passthrough},
+		{ID: "ch-dvr", Slug: "dvr", SourceURL: "http://fake/dvr", IsActive: true, AlwaysOn: true, BitrateConfig: passthrough},
+	})
+	if got := mgr.ActiveCount(); got != 1 {
+		t.Fatalf("ActiveCount after Sync: want 1 (always-on only), got %d", got)
+	}
+	if ids := mgr.ActiveChannelIDs(); len(ids) != 1 || ids[0] != "ch-dvr" {
+		t.Errorf("ActiveChannelIDs after Sync: want [ch-dvr], got %v", ids)
+	}
+
+	started, err := mgr.Demand("idle")
+	if err != nil || !started {
+		t.Fatalf("Demand(idle): started=%v err=%v, want started", started, err)
+	}
+	if started, _ := mgr.Demand("idle"); started {
+		t.Error("second Demand started the channel again")
+	}
+	if _, err := mgr.Demand("nope"); err != pipeline.ErrUnknownChannel {
+		t.Errorf("Demand(nope): want ErrUnknownChannel, got %v", err)
+	}
+	if got := mgr.ActiveCount(); got != 2 {
+		t.Errorf("ActiveCount after Demand: want 2, got %d", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := mgr.StopIdle(); n != 1 {
+		t.Errorf("StopIdle: want 1 stopped, got %d", n)
+	}
+	if got := mgr.ActiveCount(); got != 1 {
+		t.Errorf("ActiveCount after StopIdle: want 1 (always-on kept), got %d", got)
+	}
+}
+
+// TestManagerOnDemandChannel checks that an OnDemand channel (a Stalker
+// channel) waits for a viewer even when on-demand mode is off.
+func TestManagerOnDemandChannel(t *testing.T) {
+	mgr := pipeline.NewManager(t.TempDir(), 2, 1*time.Second, nil)
+	mgr.SetIdleLinger(50 * time.Millisecond)
+	defer mgr.StopAll()
+
+	passthrough := pipeline.BitrateConfig{Mode: "passthrough"}
+	mgr.Sync([]pipeline.Channel{
+		{Slug: "portal", IsActive: true, OnDemand: true, BitrateConfig: passthrough},
+		{Slug: "m3u", SourceURL: "http://fake/m3u", IsActive: true, BitrateConfig: passthrough},
+	})
+	if got := mgr.ActiveCount(); got != 1 {
+		t.Fatalf("ActiveCount after Sync: want 1 (non-demand only), got %d", got)
+	}
+	if started, err := mgr.Demand("m3u"); err != nil || started {
+		t.Errorf("Demand(m3u): started=%v err=%v, want already running", started, err)
+	}
+	if started, err := mgr.Demand("portal"); err != nil || !started {
+		t.Fatalf("Demand(portal): started=%v err=%v, want started", started, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := mgr.StopIdle(); n != 1 {
+		t.Errorf("StopIdle: want 1 stopped, got %d", n)
+	}
+	if got := mgr.ActiveCount(); got != 1 {
+		t.Errorf("ActiveCount after StopIdle: want 1, got %d", got)
+	}
+}
+
+// fakeFFmpeg puts an ffmpeg script on PATH that appends its -i argument to
+// the returned log file and then runs body.
+func fakeFFmpeg(t *testing.T, body string) string {
+	t.Helper()
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "inputs.log")
+	script := "#!/bin/sh\n" +
+		"for a in \"$@\"; do [ \"$prev\" = \"-i\" ] && echo \"$a\" >> " + logFile + "; prev=\"$a\"; done\n" +
+		body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return logFile
+}
+
+// waitForInputs polls the fake FFmpeg's log until it holds want.
+func waitForInputs(t *testing.T, logFile string, want []string) {
+	t.Helper()
+	var got []string
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		data, _ := os.ReadFile(logFile)
+		got = strings.Fields(string(data))
+		if len(got) >= len(want) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(got) < len(want) {
+		t.Fatalf("FFmpeg inputs: want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("FFmpeg inputs: want %v, got %v", want, got[:len(want)])
+		}
+	}
+}
+
+func failoverChannel() pipeline.Channel {
+	return pipeline.Channel{
+		Slug: "multi", SourceURL: "http://primary/live", IsActive: true,
+		BitrateConfig: pipeline.BitrateConfig{Mode: "passthrough"},
+		Sources: []pipeline.Source{
+			{ID: "1", URL: "http://primary/live"},
+			{ID: "2", URL: "http://backup/live"},
+		},
+	}
+}
+
+func TestManagerFailoverOnFailure(t *testing.T) {
+	logFile := fakeFFmpeg(t, "exit 1")
+	mgr := pipeline.NewManager(t.TempDir(), 5, 5*time.Minute, nil)
+	mgr.SetFailover(time.Minute, 1)
+	defer mgr.StopAll()
+
+	mgr.Sync([]pipeline.Channel{failoverChannel()})
+	waitForInputs(t, logFile, []string{"http://primary/live", "http://backup/live"})
+}
+
+func TestManagerFailoverOnStall(t *testing.T) {
+	// FFmpeg keeps running but never writes a playlist.
+	logFile := fakeFFmpeg(t, "exec sleep 30")
+	mgr := pipeline.NewManager(t.TempDir(), 5, 5*time.Minute, nil)
+	mgr.SetFailover(200*time.Millisecond, 10)
+	defer mgr.StopAll()
+
+	mgr.Sync([]pipeline.Channel{failoverChannel()})
+	waitForInputs(t, logFile, []string{"http://primary/live", "http://backup/live"})
+}
+
+func TestManagerResolvesEmptySource(t *testing.T) {
+	logFile := fakeFFmpeg(t, "exit 1")
+	mgr := pipeline.NewManager(t.TempDir(), 5, 5*time.Minute, nil)
+	mgr.SetFailover(time.Minute, 1)
+	var mu sync.Mutex
+	var resolved []string
+	mgr.SetResolver(func(_ context.Context, channelID string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		resolved = append(resolved, channelID)
+		return "http://portal/play?token=" + channelID, nil
+	})
+	defer mgr.StopAll()
+
+	// A Stalker channel: its own source has no stored URL.
+	mgr.Sync([]pipeline.Channel{{
+		ID: "c1", Slug: "portal", IsActive: true,
+		BitrateConfig: pipeline.BitrateConfig{Mode: "passthrough"},
+		Sources: []pipeline.Source{
+			{ID: "c1"},
+			{ID: "2", URL: "http://backup/live"},
+		},
+	}})
+	waitForInputs(t, logFile, []string{"http://portal/play?token=c1", "http://backup/live"})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(resolved) != 1 || resolved[0] != "c1" {
+		t.Errorf("resolver calls: want [c1], got %v", resolved)
+	}
+}
+
 func TestHealthCallbackFired(t *testing.T) {
 	var mu sync.Mutex
 	updates := make([]string, 0)
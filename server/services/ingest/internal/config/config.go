@@ -23,6 +23,19 @@ type Config struct {
 	ChannelPollInterval time.Duration
 	MaxRestarts         int
 	RestartWindow       time.Duration
+
+	// On-demand ingest: "always" runs every active channel; "on_demand" runs
+	// channels only while the relay reports viewers, stopping them after
+	// IdleLinger without demand (channels marked always_on, with catchup
+	// enabled or with a DVR recording due keep running).
+	Mode       string
+	IdleLinger time.Duration
+
+	// Source failover: a run whose playlist goes StallTimeout without an
+	// update counts as stalled and moves to the next source at once;
+	// otherwise FailoverAfter consecutive failures do.
+	StallTimeout  time.Duration
+	FailoverAfter int
 }
 
 // Load reads configuration from environment variables with sensible defaults.
@@ -35,6 +48,10 @@ func Load() *Config {
 		ChannelPollInterval: getDuration("CHANNEL_POLL_INTERVAL", 30*time.Second),
 		MaxRestarts:         getInt("MAX_RESTARTS", 5),
 		RestartWindow:       getDuration("RESTART_WINDOW", 5*time.Minute),
+		Mode:                getEnv("INGEST_MODE", "always"),
+		IdleLinger:          getDuration("INGEST_IDLE_LINGER", 2*time.Minute),
+		StallTimeout:        getDuration("INGEST_STALL_TIMEOUT", 20*time.Second),
+		FailoverAfter:       getInt("INGEST_FAILOVER_AFTER", 2),
 	}
 }
 
@@ -1,21 +1,48 @@
 // pipeline.go — FFmpeg pipeline manager for HLS stream ingest.
 // Manages per-channel FFmpeg processes: spawn, monitor, restart on failure,
 // and graceful shutdown. Source URLs are never logged in full.
+//
+// By default every active channel runs all the time. In on-demand mode
+// (EnableOnDemand) only AlwaysOn channels run continuously; the others start
+// on the first viewer demand (relayed from the relay's playlist requests) and
+// stop once no demand has arrived for the linger period. Channels marked
+// OnDemand behave that way in either mode.
+//
+// Source failover: a channel may carry an ordered list of sources (best
+// first, as ranked by the arbitrage engine's probe scores). The pipeline
+// moves to the next source when the current one stalls (FFmpeg running but
+// the playlist not updated for the stall timeout) or fails FailoverAfter
+// times in a row. The output directory and playlist stay the same: FFmpeg
+// runs with append_list, so the first segment from the new source continues
+// the playlist behind an EXT-X-DISCONTINUITY and viewers and DVR captures
+// keep going. There is no automatic switch back; the next failure moves on
+// down the list, wrapping around once a source has run well.
+//
+// A source with an empty URL is resolved on every start and restart through
+// the resolver set with SetResolver. Portal providers (Stalker) mint
+// single-use stream URLs, so none is stored; their channels are OnDemand so
+// the portal is only asked for a URL when someone watches.
 package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// ErrUnknownChannel is returned by Demand for a slug that is not an active channel.
+var ErrUnknownChannel = errors.New("unknown channel")
+
 // Channel represents a live channel to be ingested.
 type Channel struct {
 	ID            string
@@ -24,6 +51,31 @@ type Channel struct {
 	SourceType    string // "hls", "rtmp", "mpegts"
 	BitrateConfig BitrateConfig
 	IsActive      bool
+	AlwaysOn      bool     // keep running in on-demand mode (admin override, catchup, DVR)
+	OnDemand      bool     // run only on viewer demand, even outside on-demand mode
+	Sources       []Source // failover order, best first; empty means SourceURL only
+}
+
+// Source is one upstream for a channel.
+type Source struct {
+	ID  string
+	URL string
+}
+
+// Resolver returns a fresh stream URL for a channel whose source has none
+// stored. The URL must not be logged.
+type Resolver func(ctx context.Context, channelID string) (string, error)
+
+// sourceURLs returns the channel's sources in failover order.
+func (ch Channel) sourceURLs() []string {
+	if len(ch.Sources) == 0 {
+		return []string{ch.SourceURL}
+	}
+	urls := make([]string, len(ch.Sources))
+	for i, src := range ch.Sources {
+		urls[i] = src.URL
+	}
+	return urls
 }
 
 // BitrateConfig controls transcoding behavior.
@@ -51,9 +103,47 @@ type processState struct {
 	channel  Channel
 	restarts []time.Time // timestamps of recent restarts
 	cancel   context.CancelFunc
+	fresh    bool // remove the previous run's playlists and segments before starting
+	source   int  // index of the current source in channel.sourceURLs()
+	failures int  // consecutive failed runs on the current source
+	tried    int  // failovers since the last good run
 	mu       sync.Mutex
 }
 
+// snapshot returns the channel with SourceURL set to the current source.
+func (s *processState) snapshot() Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := s.channel
+	urls := ch.sourceURLs()
+	if s.source >= len(urls) {
+		s.source = 0
+	}
+	ch.SourceURL = urls[s.source]
+	return ch
+}
+
+// updateSources takes a re-ranked source list from Sync, keeping the
+// pipeline on its current source if it is still listed.
+func (s *processState) updateSources(ch Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	urls := s.channel.sourceURLs()
+	current := ""
+	if s.source < len(urls) {
+		current = urls[s.source]
+	}
+	s.channel.SourceURL = ch.SourceURL
+	s.channel.Sources = ch.Sources
+	s.source = 0
+	for i, u := range s.channel.sourceURLs() {
+		if u == current {
+			s.source = i
+			break
+		}
+	}
+}
+
 // Manager manages all active FFmpeg pipelines.
 type Manager struct {
 	segmentDir    string
@@ -63,8 +153,28 @@ type Manager struct {
 
 	mu       sync.RWMutex
 	channels map[string]*processState // keyed by channel slug
+
+	// On-demand mode (see EnableOnDemand).
+	onDemand bool
+	linger   time.Duration
+	known    map[string]Channel   // active channels from the last Sync
+	demand   map[string]time.Time // slug → last viewer demand
+
+	// Source failover (see SetFailover).
+	stallTimeout  time.Duration
+	failoverAfter int
+
+	resolve Resolver // see SetResolver
 }
 
+// Failover defaults.
+const (
+	defaultStallTimeout  = 20 * time.Second
+	defaultFailoverAfter = 2
+	goodRunTime          = 2 * time.Minute // a run this long resets the failure counts
+	defaultLinger        = 2 * time.Minute
+)
+
 // NewManager creates a pipeline manager.
 // setHealthFn is called whenever a channel's health status changes.
 func NewManager(segmentDir string, maxRestarts int, restartWindow time.Duration, setHealthFn func(slug, status string)) *Manager {
@@ -77,11 +187,60 @@ func NewManager(segmentDir string, maxRestarts int, restartWindow time.Duration,
 		restartWindow: restartWindow,
 		setHealth:     setHealthFn,
 		channels:      make(map[string]*processState),
+		known:         make(map[string]Channel),
+		demand:        make(map[string]time.Time),
+		linger:        defaultLinger,
+		stallTimeout:  defaultStallTimeout,
+		failoverAfter: defaultFailoverAfter,
+	}
+}
+
+// SetFailover sets how long a playlist may go without an update before the
+// run counts as stalled, and how many consecutive failures move a channel to
+// its next source. Call before the first Sync.
+func (m *Manager) SetFailover(stallTimeout time.Duration, failoverAfter int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stallTimeout > 0 {
+		m.stallTimeout = stallTimeout
+	}
+	if failoverAfter > 0 {
+		m.failoverAfter = failoverAfter
+	}
+}
+
+// SetResolver sets the function that supplies URLs for sources stored
+// without one. Call before the first Sync.
+func (m *Manager) SetResolver(resolve Resolver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resolve = resolve
+}
+
+// EnableOnDemand switches the manager to on-demand mode: channels that are
+// not AlwaysOn run only while viewers demand them, and stop after linger
+// without demand. Call before the first Sync.
+func (m *Manager) EnableOnDemand(linger time.Duration) {
+	m.mu.Lock()
+	m.onDemand = true
+	m.mu.Unlock()
+	m.SetIdleLinger(linger)
+}
+
+// SetIdleLinger sets how long a demand-started channel keeps running after
+// the last viewer demand (default 2m). It applies to OnDemand channels
+// outside on-demand mode too.
+func (m *Manager) SetIdleLinger(linger time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if linger > 0 {
+		m.linger = linger
 	}
 }
 
 // Sync reconciles the manager's running processes against the provided channel list.
-// New channels are started; channels no longer active are stopped.
+// New channels are started; channels no longer active are stopped. In
+// on-demand mode only AlwaysOn channels and channels with recent demand run.
 func (m *Manager) Sync(channels []Channel) {
 	desired := make(map[string]Channel)
 	for _, ch := range channels {
@@ -92,6 +251,7 @@ func (m *Manager) Sync(channels []Channel) {
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.known = desired
 
 	// Stop channels no longer wanted
 	for slug, state := range m.channels {
@@ -101,16 +261,80 @@ func (m *Manager) Sync(channels []Channel) {
 			delete(m.channels, slug)
 		}
 	}
+	m.stopIdleLocked(time.Now())
+
+	// Pick up re-ranked sources for running channels; they apply on the next
+	// failover or restart.
+	for slug, state := range m.channels {
+		state.updateSources(desired[slug])
+	}
 
 	// Start new channels
 	for slug, ch := range desired {
-		if _, running := m.channels[slug]; !running {
+		if _, running := m.channels[slug]; !running && m.wantedLocked(ch, time.Now()) {
 			log.Printf("[ingest] starting channel %q", slug)
 			m.startLocked(ch)
 		}
 	}
 }
 
+// Demand records viewer demand for a channel, starting its pipeline if it is
+// not running. started reports whether a pipeline was launched. Outside
+// on-demand mode it only reports whether the channel is known, unless the
+// channel is OnDemand.
+func (m *Manager) Demand(slug string) (started bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch, ok := m.known[slug]
+	if !ok {
+		return false, ErrUnknownChannel
+	}
+	if !m.onDemand && !ch.OnDemand {
+		return false, nil
+	}
+	m.demand[slug] = time.Now()
+	if _, running := m.channels[slug]; running {
+		return false, nil
+	}
+	log.Printf("[ingest] starting channel %q on demand", slug)
+	m.startLocked(ch)
+	return true, nil
+}
+
+// StopIdle stops demand-started pipelines whose last demand is older than the
+// linger period and returns how many were stopped.
+func (m *Manager) StopIdle() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stopIdleLocked(time.Now())
+}
+
+func (m *Manager) stopIdleLocked(now time.Time) int {
+	stopped := 0
+	for slug, state := range m.channels {
+		ch, ok := m.known[slug]
+		if !ok || m.wantedLocked(ch, now) {
+			continue
+		}
+		log.Printf("[ingest] stopping idle channel %q (no viewers for %s)", slug, m.linger)
+		m.stopLocked(state)
+		delete(m.channels, slug)
+		delete(m.demand, slug)
+		stopped++
+	}
+	return stopped
+}
+
+// wantedLocked reports whether ch should be running. Must hold m.mu.
+func (m *Manager) wantedLocked(ch Channel, now time.Time) bool {
+	if ch.AlwaysOn || (!m.onDemand && !ch.OnDemand) {
+		return true
+	}
+	last, ok := m.demand[ch.Slug]
+	return ok && now.Sub(last) < m.linger
+}
+
 // StopAll sends SIGTERM to every running FFmpeg process and waits up to 10s.
 func (m *Manager) StopAll() {
 	m.mu.Lock()
@@ -154,12 +378,27 @@ func (m *Manager) ActiveCount() int {
 	return len(m.channels)
 }
 
+// ActiveChannelIDs returns the IDs of the channels with a pipeline: running,
+// restarting or failing over between sources.
+func (m *Manager) ActiveChannelIDs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]string, 0, len(m.channels))
+	for _, state := range m.channels {
+		state.mu.Lock()
+		ids = append(ids, state.channel.ID)
+		state.mu.Unlock()
+	}
+	return ids
+}
+
 // startLocked starts a new FFmpeg process for the channel. Must hold m.mu.
 func (m *Manager) startLocked(ch Channel) {
 	ctx, cancel := context.WithCancel(context.Background())
 	state := &processState{
 		channel: ch,
 		cancel:  cancel,
+		fresh:   m.onDemand,
 	}
 	m.channels[ch.Slug] = state
 	go m.runLoop(ctx, state)
@@ -175,7 +414,8 @@ func (m *Manager) stopLocked(state *processState) {
 	}
 }
 
-// runLoop manages the lifecycle of a single FFmpeg process, restarting on failure.
+// runLoop manages the lifecycle of a single FFmpeg process, restarting on
+// failure and failing over between the channel's sources.
 func (m *Manager) runLoop(ctx context.Context, state *processState) {
 	slug := state.channel.Slug
 
@@ -186,7 +426,13 @@ func (m *Manager) runLoop(ctx context.Context, state *processState) {
 		default:
 		}
 
-		if m.tooManyRestarts(state) {
+		ch := state.snapshot()
+		sources := len(ch.sourceURLs())
+		// The restart limit applies once every source has been tried.
+		state.mu.Lock()
+		exhausted := state.tried >= sources-1
+		state.mu.Unlock()
+		if exhausted && m.tooManyRestarts(state) {
 			log.Printf("[ingest] channel %q exceeded restart limit (%d in %s), marking unhealthy",
 				slug, m.maxRestarts, m.restartWindow)
 			m.setHealth(slug, "unhealthy")
@@ -199,25 +445,22 @@ func (m *Manager) runLoop(ctx context.Context, state *processState) {
 			time.Sleep(5 * time.Second)
 			continue
 		}
+		if state.fresh {
+			// A pipeline stopped for idleness leaves its last playlist behind;
+			// the relay must not serve it as live, and append_list must not
+			// continue it.
+			removeHLSOutput(outDir)
+			state.fresh = false
+		}
 
-		args := BuildFFmpegArgs(state.channel, m.segmentDir)
-		cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-		// Suppress stdout/stderr to avoid leaking source URLs in logs
-		cmd.Stdout = nil
-		cmd.Stderr = nil
-
-		state.mu.Lock()
-		state.cmd = cmd
-		state.mu.Unlock()
-
-		log.Printf("[ingest] starting FFmpeg for channel %q (source: %s)", slug, safeLogURL(state.channel.SourceURL))
-		m.setHealth(slug, "starting")
-
-		if err := cmd.Start(); err != nil {
-			log.Printf("[ingest] FFmpeg start error for %q: %v", slug, err)
+		started := time.Now()
+		var stalled atomic.Bool
+		if err := m.resolveSource(ctx, &ch); err != nil {
+			// Counts as a failed run, so a portal that keeps refusing
+			// fails over and hits the restart limit like a dead source.
+			log.Printf("[ingest] cannot resolve source for %q: %v", slug, err)
 		} else {
-			m.setHealth(slug, "healthy")
-			cmd.Wait()
+			m.runFFmpeg(ctx, state, ch, outDir, started, &stalled)
 		}
 
 		select {
@@ -227,10 +470,33 @@ func (m *Manager) runLoop(ctx context.Context, state *processState) {
 		}
 
 		state.mu.Lock()
+		if time.Since(started) >= goodRunTime {
+			state.failures, state.tried = 0, 0
+		}
+		state.failures++
+		if sources > 1 && state.tried < sources-1 && (stalled.Load() || state.failures >= m.failoverAfter) {
+			state.source = (state.source + 1) % sources
+			state.failures = 0
+			state.tried++
+			state.restarts = nil // the new source gets a fresh restart budget
+			state.mu.Unlock()
+
+			reason := "repeated failures"
+			if stalled.Load() {
+				reason = "stalled"
+			}
+			log.Printf("[ingest] channel %q failing over to source %d/%d (%s)", slug, state.source+1, sources, reason)
+			m.setHealth(slug, "failover")
+			continue
+		}
 		state.restarts = append(state.restarts, time.Now())
 		state.mu.Unlock()
 
-		log.Printf("[ingest] FFmpeg exited for %q, restarting in 5s", slug)
+		if stalled.Load() {
+			log.Printf("[ingest] FFmpeg stalled for %q, restarting in 5s", slug)
+		} else {
+			log.Printf("[ingest] FFmpeg exited for %q, restarting in 5s", slug)
+		}
 		m.setHealth(slug, "restarting")
 
 		select {
@@ -241,6 +507,102 @@ func (m *Manager) runLoop(ctx context.Context, state *processState) {
 	}
 }
 
+// resolveSource fills in ch.SourceURL through the resolver when the current
+// source has no stored URL.
+func (m *Manager) resolveSource(ctx context.Context, ch *Channel) error {
+	if ch.SourceURL != "" {
+		return nil
+	}
+	m.mu.RLock()
+	resolve := m.resolve
+	m.mu.RUnlock()
+	if resolve == nil {
+		return errors.New("source has no URL and no resolver is set")
+	}
+	u, err := resolve(ctx, ch.ID)
+	if err != nil {
+		return err
+	}
+	if u == "" {
+		return errors.New("resolver returned no URL")
+	}
+	ch.SourceURL = u
+	return nil
+}
+
+// runFFmpeg runs one FFmpeg process for ch and returns when it exits.
+func (m *Manager) runFFmpeg(ctx context.Context, state *processState, ch Channel, outDir string, started time.Time, stalled *atomic.Bool) {
+	slug := ch.Slug
+	args := BuildFFmpegArgs(ch, m.segmentDir)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	// Suppress stdout/stderr to avoid leaking source URLs in logs
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+
+	log.Printf("[ingest] starting FFmpeg for channel %q (source: %s)", slug, safeLogURL(ch.SourceURL))
+	m.setHealth(slug, "starting")
+
+	// Start under the lock: stopLocked reads cmd.Process.
+	state.mu.Lock()
+	state.cmd = cmd
+	err := cmd.Start()
+	state.mu.Unlock()
+	if err != nil {
+		log.Printf("[ingest] FFmpeg start error for %q: %v", slug, err)
+		return
+	}
+	m.setHealth(slug, "healthy")
+	done := make(chan struct{})
+	go m.watchStall(cmd, outDir, started, done, stalled)
+	cmd.Wait()
+	close(done)
+}
+
+// watchStall stops cmd when the channel's playlist has not been updated for
+// the stall timeout (the first output gets one extra timeout to appear). It
+// returns once done is closed.
+func (m *Manager) watchStall(cmd *exec.Cmd, outDir string, started time.Time, done <-chan struct{}, stalled *atomic.Bool) {
+	ticker := time.NewTicker(m.stallTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		last := started.Add(m.stallTimeout)
+		for _, name := range []string{"stream.m3u8", "stream_0.m3u8"} {
+			if fi, err := os.Stat(filepath.Join(outDir, name)); err == nil && fi.ModTime().After(last) {
+				last = fi.ModTime()
+			}
+		}
+		if time.Since(last) < m.stallTimeout {
+			continue
+		}
+		stalled.Store(true)
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+		}
+		return
+	}
+}
+
+// removeHLSOutput deletes playlists and segments in dir, keeping key files.
+func removeHLSOutput(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if name := e.Name(); strings.HasSuffix(name, ".m3u8") || strings.HasSuffix(name, ".ts") {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+}
+
 // tooManyRestarts returns true if the channel has exceeded maxRestarts within restartWindow.
 func (m *Manager) tooManyRestarts(state *processState) bool {
 	state.mu.Lock()
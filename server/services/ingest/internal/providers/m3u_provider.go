@@ -175,6 +175,7 @@ func parseM3U(r io.Reader) ([]IngestChannel, error) {
 			ch.LogoURL = pendingAttrs["tvg-logo"]
 			ch.Category = pendingAttrs["group-title"]
 			ch.TvgID = pendingAttrs["tvg-id"]
+			ch.Number = pendingAttrs["tvg-chno"]
 		} else {
 			ch.ID = line
 		}
@@ -247,6 +248,7 @@ func ParseM3UFull(r io.Reader) (ParsedM3U, error) {
 			ch.LogoURL = pendingAttrs["tvg-logo"]
 			ch.Category = pendingAttrs["group-title"]
 			ch.TvgID = pendingAttrs["tvg-id"]
+			ch.Number = pendingAttrs["tvg-chno"]
 		} else {
 			ch.ID = line
 		}
@@ -4,6 +4,7 @@
 //   - M3U playlist (bulk import from URL)
 //   - Xtream Codes API (host + username + password)
 //   - Direct HLS URL (single channel or small playlist)
+//   - Stalker / Ministra portal (MAC-address authenticated MAG portals)
 //
 // Credentials are stored encrypted in the database. The registry decrypts them
 // on read and constructs stream URLs server-side — raw credentials are never
@@ -22,12 +23,13 @@ type IngestChannel struct {
 	LogoURL   string
 	Category  string
 	TvgID     string
+	Number    string // channel number (M3U tvg-chno, Xtream num, Stalker number)
 	StreamURL string // assembled server-side; never logged
 }
 
 // IngestProvider is the interface all provider implementations satisfy.
 type IngestProvider interface {
-	// Type returns the provider type string ("m3u", "xtream", "hls").
+	// Type returns the provider type string ("m3u", "xtream", "hls", "stalker").
 	Type() string
 
 	// Validate checks that the config map has all required keys and values.
@@ -43,6 +45,7 @@ type IngestProvider interface {
 	// GetStreamURL returns a playable URL for the given channel ID.
 	// For Xtream this constructs the HLS URL from credentials + stream ID.
 	// For HLS/M3U this returns the URL directly from the channel record.
+	// For Stalker this calls create_link, which mints a short-lived URL.
 	// The returned URL must not be passed to clients — it is for ingest only.
 	GetStreamURL(ctx context.Context, channelID string) (string, error)
 
@@ -52,7 +55,7 @@ type IngestProvider interface {
 }
 
 // NewProvider constructs the correct IngestProvider for the given type and config.
-// Supported types: "m3u", "xtream", "hls".
+// Supported types: "m3u", "xtream", "hls", "stalker".
 // The config map is type-specific (see individual provider docs).
 func NewProvider(providerType string, config map[string]string) (IngestProvider, error) {
 	switch providerType {
@@ -62,7 +65,9 @@ func NewProvider(providerType string, config map[string]string) (IngestProvider,
 		return newXtreamProvider(config)
 	case "hls":
 		return newHLSProvider(config)
+	case "stalker":
+		return newStalkerProvider(config)
 	default:
-		return nil, fmt.Errorf("unsupported provider type %q; supported: m3u, xtream, hls", providerType)
+		return nil, fmt.Errorf("unsupported provider type %q; supported: m3u, xtream, hls, stalker", providerType)
 	}
 }
@@ -0,0 +1,127 @@
+// secrets.go — Encryption of provider credentials stored in ingest_providers.config.
+//
+// Values listed in secretKeys are stored sealed under their "_enc" key with
+// AES-256-GCM (base64 of nonce || ciphertext), the same scheme the scrobble
+// service uses for stored tokens. The key comes from INGEST_PROVIDER_KEY
+// (64 hex characters). OpenConfig turns a stored config into the plaintext
+// map NewProvider expects; SealConfig does the reverse.
+package providers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNoProviderKey is returned when INGEST_PROVIDER_KEY is not configured.
+var ErrNoProviderKey = errors.New("INGEST_PROVIDER_KEY must be a 64-char hex string (32 bytes)")
+
+// secretKeys maps each credential config key to the key its sealed value is
+// stored under. A Stalker portal's MAC address is the account credential.
+var secretKeys = map[string]string{
+	"mac": "mac_enc",
+}
+
+// HasPlainSecrets reports whether a stored config holds a credential in plaintext.
+func HasPlainSecrets(config map[string]string) bool {
+	for plain := range secretKeys {
+		if config[plain] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// SealConfig returns a copy of config with every credential replaced by its
+// sealed "_enc" form, ready to be stored.
+func SealConfig(config map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(config))
+	for k, v := range config {
+		out[k] = v
+	}
+	for plain, enc := range secretKeys {
+		v, ok := out[plain]
+		if !ok {
+			continue
+		}
+		sealed, err := sealSecret(v)
+		if err != nil {
+			return nil, err
+		}
+		delete(out, plain)
+		out[enc] = sealed
+	}
+	return out, nil
+}
+
+// OpenConfig returns a copy of a stored config with every sealed credential
+// decrypted under its plain key.
+func OpenConfig(config map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(config))
+	for k, v := range config {
+		out[k] = v
+	}
+	for plain, enc := range secretKeys {
+		v, ok := out[enc]
+		if !ok {
+			continue
+		}
+		opened, err := openSecret(v)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %w", enc, err)
+		}
+		delete(out, enc)
+		out[plain] = opened
+	}
+	return out, nil
+}
+
+func providerAEAD() (cipher.AEAD, error) {
+	key, err := hex.DecodeString(os.Getenv("INGEST_PROVIDER_KEY"))
+	if err != nil || len(key) < 32 {
+		return nil, ErrNoProviderKey
+	}
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealSecret(plaintext string) (string, error) {
+	gcm, err := providerAEAD()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+func openSecret(ciphertext string) (string, error) {
+	gcm, err := providerAEAD()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("secret ciphertext too short")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,38 @@
+// secrets_test.go — Unit tests for provider credential encryption.
+package providers
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSealOpenConfig verifies a MAC round-trips through SealConfig and
+// OpenConfig and is not stored in plaintext.
+func TestSealOpenConfig(t *testing.T) {
+	t.Setenv("INGEST_PROVIDER_KEY", strings.Repeat("ab", 32))
+	cfg := map[string]string{"portal": "http://x/c/", "mac": "00:1A:79:00:00:01"}
+
+	sealed, err := SealConfig(cfg)
+	if err != nil {
+		t.Fatalf("SealConfig: %v", err)
+	}
+	if _, ok := sealed["mac"]; ok || sealed["mac_enc"] == "" || HasPlainSecrets(sealed) {
+		t.Fatalf("sealed config still holds the plaintext mac: %v", sealed)
+	}
+	if sealed["portal"] != cfg["portal"] || cfg["mac"] == "" {
+		t.Errorf("SealConfig changed other keys or its input: %v / %v", sealed, cfg)
+	}
+
+	opened, err := OpenConfig(sealed)
+	if err != nil {
+		t.Fatalf("OpenConfig: %v", err)
+	}
+	if opened["mac"] != cfg["mac"] {
+		t.Errorf("opened mac = %q, want %q", opened["mac"], cfg["mac"])
+	}
+
+	t.Setenv("INGEST_PROVIDER_KEY", "")
+	if _, err := OpenConfig(sealed); err == nil {
+		t.Error("OpenConfig without a key should fail")
+	}
+}
@@ -0,0 +1,498 @@
+// stalker_provider.go — Stalker / Ministra portal (MAG set-top box) ingest provider.
+//
+// Stalker portals authenticate a device by MAC address rather than a
+// username/password. The provider emulates a MAG250 box:
+//
+//  1. handshake   — obtain a bearer token for the MAC
+//  2. get_profile — activate the token (many portals reject calls without it)
+//  3. get_genres + get_all_channels — channel list, genre id → category name
+//  4. create_link — per play, exchange a channel's cmd for a short-lived URL
+//
+// Tokens expire (portal-dependent, typically minutes to hours). The provider
+// re-handshakes when a token is older than stalkerTokenTTL or when the portal
+// answers "Authorization failed", then retries the call once.
+//
+// SECURITY: The MAC address is the account credential. It is never written to
+// log output; errors identify the portal by host only.
+//
+// Config keys:
+//
+//	portal   — portal URL as given by the provider (e.g. http://portal.example.com:8080/c/)
+//	mac      — device MAC address (00:1A:79:xx:xx:xx); stored sealed as mac_enc, see secrets.go
+//	timezone — optional IANA timezone sent to the portal (default UTC)
+//
+// Stream URLs are not stored at sync time: create_link URLs embed single-use
+// play tokens, so IngestChannel.StreamURL is empty and GetStreamURL must be
+// called when ingest starts.
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	stalkerUserAgent  = "Mozilla/5.0 (QtEmbedded; U; Linux; C) AppleWebKit/533.3 (KHTML, like Gecko) MAG200 stbapp ver: 2 rev: 250 Safari/533.3"
+	stalkerXUserAgent = "Model: MAG250; Link: WiFi"
+	stalkerTokenTTL   = 30 * time.Minute
+)
+
+var stalkerMACRe = regexp.MustCompile(`^[0-9A-F]{2}(:[0-9A-F]{2}){5}$`)
+
+// stalkerProvider implements IngestProvider for Stalker/Ministra portals.
+type stalkerProvider struct {
+	portal   string // portal URL as configured
+	apiURL   string // resolved load.php / portal.php endpoint ("" until first handshake)
+	mac      string
+	timezone string
+	client   *http.Client
+
+	mu       sync.Mutex
+	token    string
+	tokenAt  time.Time
+	channels map[string]string // channel id → cmd, from the last GetChannels
+}
+
+// newStalkerProvider validates config and returns a stalkerProvider.
+func newStalkerProvider(config map[string]string) (*stalkerProvider, error) {
+	portal := strings.TrimSpace(config["portal"])
+	mac := strings.ToUpper(strings.TrimSpace(config["mac"]))
+
+	if portal == "" {
+		return nil, fmt.Errorf("stalker provider requires config key 'portal'")
+	}
+	if mac == "" {
+		return nil, fmt.Errorf("stalker provider requires config key 'mac'")
+	}
+	if !strings.HasPrefix(portal, "http://") && !strings.HasPrefix(portal, "https://") {
+		return nil, fmt.Errorf("stalker portal must start with http:// or https://")
+	}
+	if !stalkerMACRe.MatchString(mac) {
+		return nil, fmt.Errorf("stalker mac must look like 00:1A:79:XX:XX:XX")
+	}
+	tz := config["timezone"]
+	if tz == "" {
+		tz = "UTC"
+	}
+
+	return &stalkerProvider{
+		portal:   portal,
+		mac:      mac,
+		timezone: tz,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		channels: map[string]string{},
+	}, nil
+}
+
+func (p *stalkerProvider) Type() string { return "stalker" }
+
+func (p *stalkerProvider) Validate(config map[string]string) error {
+	_, err := newStalkerProvider(config)
+	return err
+}
+
+// GetChannels fetches every live channel and maps tv_genre_id to the genre title.
+func (p *stalkerProvider) GetChannels(ctx context.Context) ([]IngestChannel, error) {
+	genres, err := p.GetGenres(ctx)
+	if err != nil {
+		// Genres are non-critical — proceed with raw genre ids.
+		genres = nil
+	}
+	genreMap := make(map[string]string, len(genres))
+	for _, g := range genres {
+		genreMap[g.ID.String()] = g.Title
+	}
+
+	list, err := p.GetAllChannels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make(map[string]string, len(list))
+	channels := make([]IngestChannel, 0, len(list))
+	for _, c := range list {
+		id := c.ID.String()
+		if id == "" {
+			continue
+		}
+		cmds[id] = c.Cmd
+		ch := IngestChannel{
+			ID:      id,
+			Name:    c.Name,
+			LogoURL: p.absoluteLogo(c.Logo),
+			TvgID:   c.XMLTVID,
+			Number:  c.Number.String(),
+		}
+		if name, ok := genreMap[c.GenreID.String()]; ok {
+			ch.Category = name
+		} else {
+			ch.Category = c.GenreID.String()
+		}
+		if ch.Name == "" {
+			ch.Name = id
+		}
+		channels = append(channels, ch)
+	}
+
+	p.mu.Lock()
+	p.channels = cmds
+	p.mu.Unlock()
+	return channels, nil
+}
+
+// GetStreamURL calls create_link for the channel and returns the playable URL.
+// The URL carries a play token and must never be logged or returned to clients.
+func (p *stalkerProvider) GetStreamURL(ctx context.Context, channelID string) (string, error) {
+	if channelID == "" {
+		return "", fmt.Errorf("stalker: empty channelID")
+	}
+	cmd, err := p.channelCmd(ctx, channelID)
+	if err != nil {
+		return "", err
+	}
+
+	var link struct {
+		Cmd string `json:"cmd"`
+	}
+	if err := p.call(ctx, url.Values{
+		"type":   {"itv"},
+		"action": {"create_link"},
+		"cmd":    {cmd},
+	}, &link); err != nil {
+		return "", err
+	}
+	streamURL := stripStalkerCmd(link.Cmd)
+	if streamURL == "" {
+		return "", fmt.Errorf("stalker: create_link returned no URL for channel %s", channelID)
+	}
+	return streamURL, nil
+}
+
+// HealthCheck performs a fresh handshake and profile fetch, and reports blocked devices.
+func (p *stalkerProvider) HealthCheck(ctx context.Context) error {
+	p.mu.Lock()
+	p.token = ""
+	p.mu.Unlock()
+
+	profile, err := p.GetProfile(ctx)
+	if err != nil {
+		return err
+	}
+	if profile.Blocked.String() == "1" {
+		return fmt.Errorf("stalker device blocked by portal %s", safeXtreamHost(p.portal))
+	}
+	return nil
+}
+
+// ---------- Stalker API methods ----------------------------------------------
+
+// stalkerString decodes JSON fields portals send as either strings or numbers.
+type stalkerString string
+
+func (s *stalkerString) UnmarshalJSON(b []byte) error {
+	*s = stalkerString(strings.Trim(string(b), `"`))
+	if *s == "null" {
+		*s = ""
+	}
+	return nil
+}
+
+func (s stalkerString) String() string { return string(s) }
+
+// StalkerProfile is the subset of get_profile the provider uses.
+type StalkerProfile struct {
+	ID      stalkerString `json:"id"`
+	Name    string        `json:"name"`
+	Status  stalkerString `json:"status"`
+	Blocked stalkerString `json:"blocked"`
+}
+
+// StalkerGenre is one itv genre (category).
+type StalkerGenre struct {
+	ID    stalkerString `json:"id"`
+	Title string        `json:"title"`
+}
+
+// StalkerChannel is one entry from get_all_channels.
+type StalkerChannel struct {
+	ID      stalkerString `json:"id"`
+	Name    string        `json:"name"`
+	Number  stalkerString `json:"number"`
+	Cmd     string        `json:"cmd"`
+	Logo    string        `json:"logo"`
+	GenreID stalkerString `json:"tv_genre_id"`
+	XMLTVID string        `json:"xmltv_id"`
+}
+
+// GetProfile activates the session token and returns the device profile.
+func (p *stalkerProvider) GetProfile(ctx context.Context) (*StalkerProfile, error) {
+	var profile StalkerProfile
+	if err := p.call(ctx, p.profileParams(), &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// GetGenres fetches the itv genre list.
+func (p *stalkerProvider) GetGenres(ctx context.Context) ([]StalkerGenre, error) {
+	var genres []StalkerGenre
+	if err := p.call(ctx, url.Values{"type": {"itv"}, "action": {"get_genres"}}, &genres); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+// GetAllChannels fetches every live channel.
+func (p *stalkerProvider) GetAllChannels(ctx context.Context) ([]StalkerChannel, error) {
+	var result struct {
+		Data []StalkerChannel `json:"data"`
+	}
+	if err := p.call(ctx, url.Values{"type": {"itv"}, "action": {"get_all_channels"}}, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// ---------- internal ---------------------------------------------------------
+
+// channelCmd returns the create_link cmd for a channel, refreshing the channel
+// list when the id is unknown (e.g. a provider built just to resolve a stream).
+func (p *stalkerProvider) channelCmd(ctx context.Context, channelID string) (string, error) {
+	p.mu.Lock()
+	cmd, ok := p.channels[channelID]
+	p.mu.Unlock()
+	if ok {
+		return cmd, nil
+	}
+	if _, err := p.GetChannels(ctx); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	cmd, ok = p.channels[channelID]
+	p.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("stalker: channel %s not found on portal", channelID)
+	}
+	return cmd, nil
+}
+
+// call performs an authenticated load.php request and decodes the "js" payload
+// into dest. An expired token triggers one re-handshake and retry.
+func (p *stalkerProvider) call(ctx context.Context, params url.Values, dest interface{}) error {
+	token, err := p.ensureToken(ctx)
+	if err != nil {
+		return err
+	}
+	err = p.request(ctx, params, token, dest)
+	if err != errStalkerUnauthorized {
+		return err
+	}
+
+	p.mu.Lock()
+	p.token = ""
+	p.mu.Unlock()
+	if token, err = p.ensureToken(ctx); err != nil {
+		return err
+	}
+	return p.request(ctx, params, token, dest)
+}
+
+var errStalkerUnauthorized = fmt.Errorf("stalker: authorization failed")
+
+// ensureToken returns a valid token, performing handshake + get_profile when
+// there is none or it is older than stalkerTokenTTL.
+func (p *stalkerProvider) ensureToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	if p.token != "" && time.Since(p.tokenAt) < stalkerTokenTTL {
+		token := p.token
+		p.mu.Unlock()
+		return token, nil
+	}
+	p.mu.Unlock()
+
+	token, err := p.handshake(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// get_profile activates the token on most portals; failures here surface
+	// on the next real call, so they are not fatal.
+	var profile StalkerProfile
+	_ = p.request(ctx, p.profileParams(), token, &profile)
+
+	p.mu.Lock()
+	p.token, p.tokenAt = token, time.Now()
+	p.mu.Unlock()
+	return token, nil
+}
+
+// handshake obtains a new token. The API endpoint is discovered on first use:
+// Ministra installs answer at /stalker_portal/server/load.php, older Stalker
+// installs at /portal.php next to the /c/ client path.
+func (p *stalkerProvider) handshake(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	candidates := []string{p.apiURL}
+	p.mu.Unlock()
+	if candidates[0] == "" {
+		candidates = stalkerAPICandidates(p.portal)
+	}
+
+	var lastErr error
+	for _, api := range candidates {
+		var result struct {
+			Token string `json:"token"`
+		}
+		err := p.requestAt(ctx, api, url.Values{"type": {"stb"}, "action": {"handshake"}, "token": {""}}, "", &result)
+		if err == nil && result.Token != "" {
+			p.mu.Lock()
+			p.apiURL = api
+			p.mu.Unlock()
+			return result.Token, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("stalker handshake: portal %s returned no token", safeXtreamHost(p.portal))
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+func (p *stalkerProvider) request(ctx context.Context, params url.Values, token string, dest interface{}) error {
+	p.mu.Lock()
+	api := p.apiURL
+	p.mu.Unlock()
+	return p.requestAt(ctx, api, params, token, dest)
+}
+
+// requestAt performs one load.php call against api with MAG headers.
+func (p *stalkerProvider) requestAt(ctx context.Context, api string, params url.Values, token string, dest interface{}) error {
+	q := url.Values{}
+	for k, v := range params {
+		q[k] = v
+	}
+	q.Set("JsHttpRequest", "1-xml")
+	action := params.Get("action")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, api+"?"+q.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("stalker request: %w", err)
+	}
+	req.Header.Set("User-Agent", stalkerUserAgent)
+	req.Header.Set("X-User-Agent", stalkerXUserAgent)
+	req.Header.Set("Referer", p.portal)
+	req.Header.Set("Cookie", fmt.Sprintf("mac=%s; stb_lang=en; timezone=%s",
+		url.QueryEscape(p.mac), url.QueryEscape(p.timezone)))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("stalker call (action=%q host=%s): %w", action, safeXtreamHost(p.portal), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return errStalkerUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stalker api: HTTP %d for action=%q host=%s",
+			resp.StatusCode, action, safeXtreamHost(p.portal))
+	}
+
+	var envelope struct {
+		JS json.RawMessage `json:"js"`
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return fmt.Errorf("stalker api read (action=%q): %w", action, err)
+	}
+	// Portals answer an expired token with a plain-text body, not JSON.
+	if strings.Contains(strings.ToLower(string(raw)), "authorization failed") {
+		return errStalkerUnauthorized
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil || len(envelope.JS) == 0 {
+		return fmt.Errorf("stalker api decode (action=%q): missing js payload", action)
+	}
+	if err := json.Unmarshal(envelope.JS, dest); err != nil {
+		return fmt.Errorf("stalker api decode (action=%q): %w", action, err)
+	}
+	return nil
+}
+
+// profileParams are the get_profile query parameters a MAG250 sends.
+func (p *stalkerProvider) profileParams() url.Values {
+	return url.Values{
+		"type":     {"stb"},
+		"action":   {"get_profile"},
+		"hd":       {"1"},
+		"sn":       {stalkerSerial(p.mac)},
+		"stb_type": {"MAG250"},
+	}
+}
+
+// stalkerAPICandidates derives possible API endpoints from the configured portal URL.
+func stalkerAPICandidates(portal string) []string {
+	u, err := url.Parse(portal)
+	if err != nil {
+		return []string{portal}
+	}
+	if strings.HasSuffix(u.Path, ".php") {
+		return []string{portal}
+	}
+	base := u.Scheme + "://" + u.Host
+	path := strings.TrimSuffix(u.Path, "/")
+	path = strings.TrimSuffix(path, "/c")
+
+	if i := strings.Index(path, "/stalker_portal"); i >= 0 {
+		return []string{base + path[:i] + "/stalker_portal/server/load.php"}
+	}
+	return []string{
+		base + path + "/portal.php",
+		base + path + "/stalker_portal/server/load.php",
+		base + path + "/server/load.php",
+	}
+}
+
+// stripStalkerCmd removes the player prefix ("ffmpeg ", "ffrt ", "auto ") from a cmd.
+func stripStalkerCmd(cmd string) string {
+	cmd = strings.TrimSpace(cmd)
+	if i := strings.LastIndex(cmd, " "); i >= 0 {
+		cmd = cmd[i+1:]
+	}
+	return cmd
+}
+
+// absoluteLogo resolves relative logo paths against the portal host.
+func (p *stalkerProvider) absoluteLogo(logo string) string {
+	if logo == "" || strings.HasPrefix(logo, "http://") || strings.HasPrefix(logo, "https://") {
+		return logo
+	}
+	u, err := url.Parse(p.portal)
+	if err != nil {
+		return ""
+	}
+	if strings.HasPrefix(logo, "/") {
+		return u.Scheme + "://" + u.Host + logo
+	}
+	return u.Scheme + "://" + u.Host + "/stalker_portal/misc/logos/320/" + logo
+}
+
+// stalkerSerial derives the 13-character serial number MAG boxes report from the MAC.
+func stalkerSerial(mac string) string {
+	s := strings.ReplaceAll(mac, ":", "")
+	for len(s) < 13 {
+		s += "0"
+	}
+	return s[:13]
+}
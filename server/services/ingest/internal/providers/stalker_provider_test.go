@@ -0,0 +1,133 @@
+// stalker_provider_test.go — Stalker portal provider against a fake Ministra portal.
+package providers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeStalkerPortal serves /stalker_portal/server/load.php. Tokens issued by
+// handshake are accepted until expire() is called.
+type fakeStalkerPortal struct {
+	handshakes int32
+	valid      atomic.Value // current accepted token
+}
+
+func (f *fakeStalkerPortal) expire() { f.valid.Store("") }
+
+func (f *fakeStalkerPortal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/stalker_portal/server/load.php" {
+		http.NotFound(w, r)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Cookie"), "mac=00%3A1A%3A79%3A00%3A00%3A01") {
+		http.Error(w, "missing mac cookie", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("action") == "handshake" {
+		n := atomic.AddInt32(&f.handshakes, 1)
+		token := "tok" + string(rune('0'+n))
+		f.valid.Store(token)
+		_, _ = w.Write([]byte(`{"js":{"token":"` + token + `"}}`))
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+f.valid.Load().(string) {
+		_, _ = w.Write([]byte(`Authorization failed.`))
+		return
+	}
+	switch q.Get("action") {
+	case "get_profile":
+		_, _ = w.Write([]byte(`{"js":{"id":42,"name":"MAG","status":0,"blocked":"0"}}`))
+	case "get_genres":
+		_, _ = w.Write([]byte(`{"js":[{"id":"*","title":"All"},{"id":3,"title":"News"}]}`))
+	case "get_all_channels":
+		_, _ = w.Write([]byte(`{"js":{"data":[
+			{"id":101,"name":"World News","cmd":"ffrt http://localhost/ch/101_","logo":"news.png","tv_genre_id":"3","xmltv_id":"news.us"},
+			{"id":"102","name":"","cmd":"ffrt http://localhost/ch/102_","tv_genre_id":9}
+		]}}`))
+	case "create_link":
+		if q.Get("cmd") != "ffrt http://localhost/ch/101_" {
+			_, _ = w.Write([]byte(`{"js":{"cmd":""}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"js":{"cmd":"ffmpeg http://edge.example.com/101/index.m3u8?play_token=abc"}}`))
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+	}
+}
+
+func newTestStalker(t *testing.T, portal *fakeStalkerPortal) *stalkerProvider {
+	t.Helper()
+	srv := httptest.NewServer(portal)
+	t.Cleanup(srv.Close)
+	p, err := newStalkerProvider(map[string]string{"portal": srv.URL + "/stalker_portal/c/", "mac": "00:1a:79:00:00:01"})
+	if err != nil {
+		t.Fatalf("newStalkerProvider: %v", err)
+	}
+	return p
+}
+
+// TestStalkerGetChannels verifies handshake, genre mapping and logo resolution.
+func TestStalkerGetChannels(t *testing.T) {
+	p := newTestStalker(t, &fakeStalkerPortal{})
+	channels, err := p.GetChannels(context.Background())
+	if err != nil {
+		t.Fatalf("GetChannels: %v", err)
+	}
+	if len(channels) != 2 {
+		t.Fatalf("expected 2 channels, got %d", len(channels))
+	}
+	news := channels[0]
+	if news.ID != "101" || news.Category != "News" || news.TvgID != "news.us" || news.StreamURL != "" {
+		t.Errorf("unexpected channel: %+v", news)
+	}
+	if !strings.HasSuffix(news.LogoURL, "/stalker_portal/misc/logos/320/news.png") {
+		t.Errorf("logo not resolved against portal: %q", news.LogoURL)
+	}
+	if channels[1].Name != "102" || channels[1].Category != "9" {
+		t.Errorf("expected id/genre fallbacks, got %+v", channels[1])
+	}
+}
+
+// TestStalkerGetStreamURLRefreshesToken verifies create_link re-handshakes once on an expired token.
+func TestStalkerGetStreamURLRefreshesToken(t *testing.T) {
+	portal := &fakeStalkerPortal{}
+	p := newTestStalker(t, portal)
+	if _, err := p.GetChannels(context.Background()); err != nil {
+		t.Fatalf("GetChannels: %v", err)
+	}
+	portal.expire()
+
+	u, err := p.GetStreamURL(context.Background(), "101")
+	if err != nil {
+		t.Fatalf("GetStreamURL: %v", err)
+	}
+	if u != "http://edge.example.com/101/index.m3u8?play_token=abc" {
+		t.Errorf("unexpected stream URL %q", u)
+	}
+	if n := atomic.LoadInt32(&portal.handshakes); n != 2 {
+		t.Errorf("expected 2 handshakes, got %d", n)
+	}
+	if _, err := p.GetStreamURL(context.Background(), "999"); err == nil {
+		t.Error("expected error for unknown channel")
+	}
+}
+
+// TestStalkerValidate verifies config validation.
+func TestStalkerValidate(t *testing.T) {
+	p := &stalkerProvider{}
+	if err := p.Validate(map[string]string{"portal": "http://x/c/", "mac": "not-a-mac"}); err == nil {
+		t.Error("expected invalid mac to fail validation")
+	}
+	if err := p.Validate(map[string]string{"portal": "ftp://x/c/", "mac": "00:1A:79:00:00:01"}); err == nil {
+		t.Error("expected non-HTTP portal to fail validation")
+	}
+	if _, err := NewProvider("stalker", map[string]string{"portal": "http://x/c/", "mac": "00:1A:79:00:00:01"}); err != nil {
+		t.Errorf("NewProvider(stalker): %v", err)
+	}
+}
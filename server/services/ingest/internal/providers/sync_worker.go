@@ -10,6 +10,8 @@
 //  2. Upsert to channels table matching on (provider_id, source_external_id).
 //     - Preserve manual edits: don't overwrite `is_active`, custom name,
 //       category overrides that an admin has explicitly set.
+//     - Channels without a stream URL (Stalker: create_link per play) are
+//       inserted active; ingest resolves a link only when someone watches.
 //  3. Mark channels no longer in the provider feed as source_removed=true.
 //  4. Update provider.last_sync, provider.channel_count, provider.health_status.
 //
@@ -157,16 +159,18 @@ func (w *SyncWorker) upsertChannels(ctx context.Context, providerID string, chan
 			INSERT INTO channels (
 				name, slug, source_url, source_type, is_active,
 				provider_id, source_external_id, source_removed,
-				logo_url, category
+				logo_url, category, tvg_id, channel_number
 			) VALUES (
 				$1, $2, $3, 'hls', true,
 				$4, $5, false,
-				$6, $7
+				$6, $7, NULLIF($8, ''), NULLIF($9, '')
 			)
 			ON CONFLICT (provider_id, source_external_id) WHERE provider_id IS NOT NULL
 			DO UPDATE SET
 				source_url       = EXCLUDED.source_url,
 				logo_url         = COALESCE(NULLIF(channels.logo_url, ''), EXCLUDED.logo_url),
+				tvg_id           = EXCLUDED.tvg_id,
+				channel_number   = EXCLUDED.channel_number,
 				source_removed   = false,
 				updated_at       = now()
 			`,
@@ -177,6 +181,8 @@ func (w *SyncWorker) upsertChannels(ctx context.Context, providerID string, chan
 			ch.ID,
 			ch.LogoURL,
 			ch.Category,
+			ch.TvgID,
+			ch.Number,
 		)
 		if err != nil {
 			log.Printf("[sync_worker] upsert channel %q: %v", ch.Name, err)
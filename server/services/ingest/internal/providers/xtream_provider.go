@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -94,6 +95,9 @@ func (p *xtreamProvider) GetChannels(ctx context.Context) ([]IngestChannel, erro
 			TvgID:     s.EpgChannelID,
 			StreamURL: p.buildStreamURL(s.StreamID),
 		}
+		if s.Num > 0 {
+			ch.Number = strconv.Itoa(s.Num)
+		}
 		if catName, ok := catMap[s.CategoryID]; ok {
 			ch.Category = catName
 		} else {
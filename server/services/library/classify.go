@@ -0,0 +1,227 @@
+// Package library indexes local media files from registered storage paths.
+//
+// It classifies files into movies, TV episodes, music tracks and game ROMs,
+// probes them with ffprobe, hashes them for duplicate detection, matches
+// metadata via services/metadata (TMDB / MusicBrainz), and upserts the result
+// into the recordings index and the catalog tables that /owl/library reads.
+//
+// classify.go — filename-based media classification.
+package library
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/unyeco/roost/services/games"
+)
+
+// Kind is the media classification of a file.
+type Kind string
+
+const (
+	KindMovie   Kind = "movie"
+	KindEpisode Kind = "episode"
+	KindMusic   Kind = "music"
+	KindGame    Kind = "game"
+)
+
+// MediaFile is the result of classifying a file path.
+type MediaFile struct {
+	Path     string
+	Kind     Kind
+	Title    string // movie title, episode title (may be empty), track title, game title
+	Year     int    // 0 when unknown
+	Show     string // episodes only
+	Season   int    // episodes only
+	Episode  int    // episodes only
+	Artist   string // music only (from folder layout Artist/Album/Track)
+	Album    string // music only
+	Platform string // games only
+}
+
+var videoExtensions = map[string]bool{
+	".mkv": true, ".mp4": true, ".m4v": true, ".avi": true, ".mov": true,
+	".wmv": true, ".ts": true, ".m2ts": true, ".webm": true, ".mpg": true, ".mpeg": true,
+}
+
+var audioExtensions = map[string]bool{
+	".flac": true, ".mp3": true, ".m4a": true, ".aac": true, ".ogg": true,
+	".opus": true, ".wav": true, ".alac": true, ".wma": true,
+}
+
+// ambiguousROMExtensions are only treated as ROMs inside a games/roms folder
+// (.md is also Markdown, .bin/.iso are also disc images and firmware).
+var ambiguousROMExtensions = map[string]bool{".md": true, ".bin": true, ".iso": true}
+
+var (
+	// Show.Name.S01E02.Episode.Title.1080p.mkv, show name - s1e2, S01E02E03 (first episode wins)
+	sxxeyyRe = regexp.MustCompile(`(?i)^(.*?)[\s._-]*s(\d{1,2})[\s._-]*e(\d{1,3})(?:[\s._-]*e\d{1,3})*(.*)$`)
+	// Show Name 1x02 Episode Title.mkv
+	nxnnRe = regexp.MustCompile(`(?i)^(.*?)[\s._-]+(\d{1,2})x(\d{2,3})(.*)$`)
+	// Movie.Title.2019.1080p.BluRay.mkv / Movie Title (2019).mkv
+	// (greedy title so "Blade Runner 2049 (2017)" keeps 2049 in the title)
+	yearRe = regexp.MustCompile(`^(.*)[\s._(\[-]+((?:19|20)\d{2})(?:[\s._)\]-]|$)`)
+	// Season folder: "Season 2", "S02"
+	seasonDirRe = regexp.MustCompile(`(?i)^(?:season[\s._-]*|s)(\d{1,2})$`)
+	// Leading track number: "01 - Title", "01. Title", "1-01 Title"
+	trackNumRe = regexp.MustCompile(`^(?:\d{1,2}-)?\d{1,3}[\s._-]+(.*)$`)
+	// Release sample clip: "sample.mkv", "movie.2019-sample.mkv", "sample-movie.mkv"
+	sampleRe = regexp.MustCompile(`(?i)^sample$|^sample[\s._-]|[\s._-]sample$`)
+	// Release junk trailing a title: resolution, source, codec tags.
+	junkRe = regexp.MustCompile(`(?i)[\s._-]+(?:2160p|1080p|720p|480p|4k|uhd|hdr|bluray|blu-ray|brrip|bdrip|web-?dl|webrip|hdtv|dvdrip|x264|x265|h\.?264|h\.?265|hevc|aac|dts|proper|repack)\b.*$`)
+)
+
+// Classify inspects a path (relative to its storage root or absolute) and
+// returns its MediaFile, or ok=false when the file is not library media.
+func Classify(path string) (MediaFile, bool) {
+	ext := strings.ToLower(filepath.Ext(path))
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return MediaFile{}, false // hidden / AppleDouble files
+	}
+
+	if platform := games.DetectPlatform(path); platform != "" && (!ambiguousROMExtensions[ext] || inGamesFolder(path)) {
+		return MediaFile{
+			Path:     path,
+			Kind:     KindGame,
+			Title:    stripBracketed(base),
+			Platform: string(platform),
+		}, true
+	}
+
+	if audioExtensions[ext] {
+		return classifyMusic(path, base), true
+	}
+
+	if !videoExtensions[ext] {
+		return MediaFile{}, false
+	}
+	if isSample(path, base) {
+		return MediaFile{}, false // release sample clips
+	}
+
+	if m := sxxeyyRe.FindStringSubmatch(base); m != nil {
+		return episodeFrom(path, m), true
+	}
+	if m := nxnnRe.FindStringSubmatch(base); m != nil {
+		return episodeFrom(path, m), true
+	}
+	return classifyMovie(path, base), true
+}
+
+// isSample reports whether a video file is a release sample clip: "sample" as
+// its own word at either end of the name, or a file inside a Sample folder.
+// Titles that merely contain the word ("Free Samples", "The Sampler") are kept.
+func isSample(path, base string) bool {
+	if sampleRe.MatchString(base) {
+		return true
+	}
+	dir := strings.ToLower(filepath.Base(filepath.Dir(path)))
+	return dir == "sample" || dir == "samples"
+}
+
+// episodeFrom builds an episode MediaFile from a SxxEyy / NxNN regexp match.
+// When the filename carries no show name, it is taken from the folder layout
+// (Show/Season 1/S01E02.mkv or Show/S01E02.mkv).
+func episodeFrom(path string, m []string) MediaFile {
+	season, _ := strconv.Atoi(m[2])
+	episode, _ := strconv.Atoi(m[3])
+	show := cleanTitle(m[1])
+	if show == "" {
+		dir := filepath.Dir(path)
+		if seasonDirRe.MatchString(filepath.Base(dir)) {
+			dir = filepath.Dir(dir)
+		}
+		show = cleanTitle(filepath.Base(dir))
+	}
+	show, year := splitYear(show)
+	return MediaFile{
+		Path:    path,
+		Kind:    KindEpisode,
+		Title:   cleanTitle(junkRe.ReplaceAllString(m[4], "")),
+		Year:    year,
+		Show:    show,
+		Season:  season,
+		Episode: episode,
+	}
+}
+
+// classifyMovie extracts "Title (Year)" from a movie filename, falling back to
+// the parent folder name when the file itself is generically named.
+func classifyMovie(path, base string) MediaFile {
+	title, year := splitYear(base)
+	if year == 0 {
+		if dirTitle, dirYear := splitYear(filepath.Base(filepath.Dir(path))); dirYear != 0 {
+			title, year = dirTitle, dirYear
+		}
+	}
+	return MediaFile{Path: path, Kind: KindMovie, Title: title, Year: year}
+}
+
+// classifyMusic derives artist/album from an Artist/Album/NN - Track layout.
+// Tags embedded in the file win later when ffprobe reports them.
+func classifyMusic(path, base string) MediaFile {
+	mf := MediaFile{Path: path, Kind: KindMusic, Title: base}
+	if m := trackNumRe.FindStringSubmatch(base); m != nil && m[1] != "" {
+		mf.Title = m[1]
+	}
+	mf.Title = strings.TrimSpace(mf.Title)
+
+	albumDir := filepath.Dir(path)
+	if strings.HasPrefix(strings.ToLower(filepath.Base(albumDir)), "disc") ||
+		strings.HasPrefix(strings.ToLower(filepath.Base(albumDir)), "cd") {
+		albumDir = filepath.Dir(albumDir)
+	}
+	if album := filepath.Base(albumDir); album != "." && album != "/" {
+		mf.Album, mf.Year = splitYear(album)
+		if artist := filepath.Base(filepath.Dir(albumDir)); artist != "." && artist != "/" {
+			mf.Artist = artist
+		}
+	}
+	return mf
+}
+
+// splitYear splits "Movie.Title.2019.1080p" into ("Movie Title", 2019).
+// Returns (cleaned input, 0) when no plausible year is present.
+func splitYear(s string) (string, int) {
+	if m := yearRe.FindStringSubmatch(s); m != nil && strings.TrimSpace(m[1]) != "" {
+		year, _ := strconv.Atoi(m[2])
+		return cleanTitle(m[1]), year
+	}
+	return cleanTitle(junkRe.ReplaceAllString(s, "")), 0
+}
+
+// cleanTitle turns dotted/underscored release names into display titles.
+func cleanTitle(s string) string {
+	s = strings.NewReplacer(".", " ", "_", " ").Replace(s)
+	s = strings.Trim(s, " -([")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// inGamesFolder reports whether any parent folder looks like a ROM collection.
+func inGamesFolder(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+		p := strings.ToLower(part)
+		if strings.Contains(p, "rom") || strings.Contains(p, "game") {
+			return true
+		}
+	}
+	return false
+}
+
+// stripBracketed removes (USA), [!] style qualifiers from ROM names.
+func stripBracketed(title string) string {
+	for _, pair := range [][2]string{{"(", ")"}, {"[", "]"}} {
+		for {
+			start := strings.LastIndex(title, pair[0])
+			end := strings.LastIndex(title, pair[1])
+			if start == -1 || end == -1 || end <= start {
+				break
+			}
+			title = strings.TrimSpace(title[:start] + title[end+1:])
+		}
+	}
+	return strings.TrimSpace(title)
+}
@@ -0,0 +1,145 @@
+// classify_test.go — Unit tests for filename classification and ffprobe parsing.
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestClassifyEpisodes verifies SxxEyy and NxNN filenames and folder fallbacks.
+func TestClassifyEpisodes(t *testing.T) {
+	cases := []struct {
+		path    string
+		show    string
+		season  int
+		episode int
+	}{
+		{"TV/Breaking.Bad.S01E02.Cats.in.the.Bag.720p.mkv", "Breaking Bad", 1, 2},
+		{"TV/The Office (US) - s3e10 - A Benihana Christmas.mp4", "The Office (US)", 3, 10},
+		{"TV/Firefly 1x02 The Train Job.avi", "Firefly", 1, 2},
+		{"TV/Doctor Who (2005)/Season 2/S02E04.mkv", "Doctor Who", 2, 4},
+		{"TV/Show.Name.S01E01E02.mkv", "Show Name", 1, 1},
+	}
+	for _, tc := range cases {
+		mf, ok := Classify(tc.path)
+		if !ok || mf.Kind != KindEpisode {
+			t.Errorf("%s: expected episode, got %+v (ok=%v)", tc.path, mf, ok)
+			continue
+		}
+		if mf.Show != tc.show || mf.Season != tc.season || mf.Episode != tc.episode {
+			t.Errorf("%s: got show=%q S%dE%d, want %q S%dE%d",
+				tc.path, mf.Show, mf.Season, mf.Episode, tc.show, tc.season, tc.episode)
+		}
+	}
+}
+
+// TestClassifyMovies verifies title/year extraction from release-style names.
+func TestClassifyMovies(t *testing.T) {
+	cases := []struct {
+		path  string
+		title string
+		year  int
+	}{
+		{"Movies/The.Matrix.1999.1080p.BluRay.x264.mkv", "The Matrix", 1999},
+		{"Movies/Blade Runner 2049 (2017).mkv", "Blade Runner 2049", 2017},
+		{"Movies/Heat (1995)/movie.mkv", "Heat", 1995},
+		{"Movies/Primer.mp4", "Primer", 0},
+	}
+	for _, tc := range cases {
+		mf, ok := Classify(tc.path)
+		if !ok || mf.Kind != KindMovie {
+			t.Errorf("%s: expected movie, got %+v (ok=%v)", tc.path, mf, ok)
+			continue
+		}
+		if mf.Title != tc.title || mf.Year != tc.year {
+			t.Errorf("%s: got %q (%d), want %q (%d)", tc.path, mf.Title, mf.Year, tc.title, tc.year)
+		}
+	}
+}
+
+// TestClassifyMusic verifies Artist/Album/NN - Track layouts.
+func TestClassifyMusic(t *testing.T) {
+	mf, ok := Classify("Music/Radiohead/OK Computer (1997)/CD1/02 - Paranoid Android.flac")
+	if !ok || mf.Kind != KindMusic {
+		t.Fatalf("expected music, got %+v (ok=%v)", mf, ok)
+	}
+	if mf.Artist != "Radiohead" || mf.Album != "OK Computer" || mf.Year != 1997 || mf.Title != "Paranoid Android" {
+		t.Errorf("unexpected music fields: %+v", mf)
+	}
+}
+
+// TestClassifyGamesAndJunk verifies ROM detection and skipped files.
+func TestClassifyGamesAndJunk(t *testing.T) {
+	mf, ok := Classify("roms/snes/Super Metroid (USA) [!].sfc")
+	if !ok || mf.Kind != KindGame || mf.Title != "Super Metroid" {
+		t.Errorf("expected game Super Metroid, got %+v (ok=%v)", mf, ok)
+	}
+	if mf, ok := Classify("roms/genesis/Sonic.md"); !ok || mf.Kind != KindGame {
+		t.Errorf("expected .md inside roms/ to be a game, got %+v (ok=%v)", mf, ok)
+	}
+	for _, path := range []string{
+		"docs/README.md",
+		"Movies/The.Matrix.1999/sample.mkv",
+		"Movies/The.Matrix.1999/the.matrix.1999.1080p-sample.mkv",
+		"Movies/The.Matrix.1999/Sample/the.matrix.1999.1080p.mkv",
+		"Movies/._The.Matrix.1999.mkv",
+		"Movies/poster.jpg",
+	} {
+		if mf, ok := Classify(path); ok {
+			t.Errorf("%s: expected skip, got %+v", path, mf)
+		}
+	}
+	if mf, ok := Classify("Movies/Free.Samples.2012.1080p.mkv"); !ok || mf.Kind != KindMovie || mf.Title != "Free Samples" {
+		t.Errorf("expected movie Free Samples, got %+v (ok=%v)", mf, ok)
+	}
+}
+
+// TestParseProbe verifies cover art is ignored and tags are lower-cased.
+func TestParseProbe(t *testing.T) {
+	out := []byte(`{
+		"streams": [
+			{"codec_type": "video", "codec_name": "mjpeg", "width": 600, "height": 600},
+			{"codec_type": "video", "codec_name": "hevc", "width": 3840, "height": 2160},
+			{"codec_type": "audio", "codec_name": "eac3"}
+		],
+		"format": {"duration": "5400.25", "bit_rate": "18000000", "tags": {"TITLE": " Heat "}}
+	}`)
+	info, err := parseProbe(out)
+	if err != nil {
+		t.Fatalf("parseProbe: %v", err)
+	}
+	if info.VideoCodec != "hevc" || info.Width != 3840 || info.AudioCodec != "eac3" {
+		t.Errorf("unexpected streams: %+v", info)
+	}
+	if info.Duration != 5400.25 || info.Bitrate != 18000000 || info.Tags["title"] != "Heat" {
+		t.Errorf("unexpected format fields: %+v", info)
+	}
+}
+
+// TestContentHash verifies identical files hash equal and differing files do not.
+func TestContentHash(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	big := make([]byte, 2*hashSampleBytes+1024)
+	a := write("a.mkv", big)
+	b := write("b.mkv", big)
+	big[len(big)-1] = 1
+	c := write("c.mkv", big)
+
+	ha, _ := ContentHash(a)
+	hb, _ := ContentHash(b)
+	hc, _ := ContentHash(c)
+	if ha == "" || ha != hb {
+		t.Errorf("identical files should hash equal: %q vs %q", ha, hb)
+	}
+	if ha == hc {
+		t.Error("files differing in the tail should hash differently")
+	}
+}
@@ -0,0 +1,482 @@
+// ingest.go — per-file library ingestion.
+//
+// IngestFile is the single entry point that turns one file on disk into
+// library rows. Full scans call it for every file under a storage path;
+// incremental updates call it only for files that changed.
+//
+// Tables written:
+//   - recordings     — one row per file (path, size, hash, probe data, classification)
+//   - catalog_items  — movies and series shown by /owl/library
+//   - music_albums   — albums (one per artist + album folder)
+//   - games          — ROMs
+package library
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unyeco/roost/services/metadata"
+)
+
+// StoragePath is a registered roost_storage_paths row that can be walked locally.
+type StoragePath struct {
+	ID       string
+	RoostID  string
+	Path     string
+	PathType string // "local" | "nfs" (object-storage types are not walkable)
+}
+
+// Result reports what IngestFile did with a file.
+type Result int
+
+const (
+	ResultUnchanged Result = iota // size + mtime match the indexed row
+	ResultIndexed                 // new or changed file indexed
+	ResultSkipped                 // not library media
+)
+
+// Indexer writes scanned files into the library tables.
+// It is safe for concurrent use.
+type Indexer struct {
+	db    *sql.DB
+	tmdb  *metadata.Client   // nil when TMDB_API_KEY is unset
+	mb    *metadata.MBClient // MusicBrainz needs no key
+	probe func(ctx context.Context, path string) (*ProbeInfo, error)
+
+	mu      sync.Mutex
+	catalog map[string]string // "kind|title|year" → catalog row id (per-process cache)
+}
+
+// NewIndexer creates an Indexer. TMDB matching is disabled when TMDB_API_KEY is unset.
+func NewIndexer(db *sql.DB) *Indexer {
+	ix := &Indexer{
+		db:      db,
+		mb:      metadata.NewMBClient(),
+		probe:   Probe,
+		catalog: map[string]string{},
+	}
+	if c, err := metadata.NewClient(); err == nil {
+		ix.tmdb = c
+	} else {
+		log.Printf("[library] %v — movie/series metadata matching disabled", err)
+	}
+	return ix
+}
+
+// IngestFile indexes absPath, which must live under sp.Path.
+func (ix *Indexer) IngestFile(ctx context.Context, sp StoragePath, absPath string) (Result, error) {
+	fi, err := os.Stat(absPath)
+	if err != nil {
+		return ResultSkipped, err
+	}
+	if fi.IsDir() {
+		return ResultSkipped, nil
+	}
+
+	rel, err := filepath.Rel(sp.Path, absPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ResultSkipped, fmt.Errorf("%s is outside storage path %s", absPath, sp.Path)
+	}
+	mf, ok := Classify(rel)
+	if !ok {
+		return ResultSkipped, nil
+	}
+
+	// Unchanged files only get their last_seen_at bumped.
+	var knownSize int64
+	var knownMtime sql.NullTime
+	err = ix.db.QueryRowContext(ctx, `
+		SELECT COALESCE(file_size_bytes, 0), file_mtime FROM recordings
+		WHERE roost_id = $1 AND file_path = $2`, sp.RoostID, absPath).Scan(&knownSize, &knownMtime)
+	if err == nil && knownSize == fi.Size() && knownMtime.Valid && knownMtime.Time.Equal(fi.ModTime().UTC().Truncate(time.Microsecond)) {
+		_, _ = ix.db.ExecContext(ctx, `
+			UPDATE recordings SET last_seen_at = NOW(), is_missing = false
+			WHERE roost_id = $1 AND file_path = $2`, sp.RoostID, absPath)
+		return ResultUnchanged, nil
+	}
+
+	hash, err := ContentHash(absPath)
+	if err != nil {
+		return ResultSkipped, fmt.Errorf("hash %s: %w", absPath, err)
+	}
+
+	info, err := ix.probe(ctx, absPath)
+	if err != nil {
+		log.Printf("[library] probe %s: %v (indexing without stream details)", absPath, err)
+		info = &ProbeInfo{Tags: map[string]string{}}
+	}
+	applyTags(&mf, info)
+
+	// Catalog tables are optional on some deployments (music_albums, games);
+	// a failed catalog match still indexes the file itself.
+	catalogID, tmdbID, mbID, err := ix.upsertCatalog(ctx, mf, info)
+	if err != nil {
+		log.Printf("[library] catalog match %s: %v", absPath, err)
+	}
+
+	_, err = ix.db.ExecContext(ctx, `
+		INSERT INTO recordings
+			(roost_id, storage_path_id, file_path, file_size_bytes, file_mtime, content_hash,
+			 duration_seconds, media_kind, title, show_title, season_number, episode_number,
+			 release_year, artist, album, platform, video_codec, audio_codec, width, height,
+			 bitrate, catalog_item_id, tmdb_id, mb_id, last_seen_at, is_missing)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,NOW(),false)
+		ON CONFLICT (roost_id, file_path) DO UPDATE SET
+			storage_path_id  = EXCLUDED.storage_path_id,
+			file_size_bytes  = EXCLUDED.file_size_bytes,
+			file_mtime       = EXCLUDED.file_mtime,
+			content_hash     = EXCLUDED.content_hash,
+			duration_seconds = EXCLUDED.duration_seconds,
+			media_kind       = EXCLUDED.media_kind,
+			title            = EXCLUDED.title,
+			show_title       = EXCLUDED.show_title,
+			season_number    = EXCLUDED.season_number,
+			episode_number   = EXCLUDED.episode_number,
+			release_year     = EXCLUDED.release_year,
+			artist           = EXCLUDED.artist,
+			album            = EXCLUDED.album,
+			platform         = EXCLUDED.platform,
+			video_codec      = EXCLUDED.video_codec,
+			audio_codec      = EXCLUDED.audio_codec,
+			width            = EXCLUDED.width,
+			height           = EXCLUDED.height,
+			bitrate          = EXCLUDED.bitrate,
+			catalog_item_id  = EXCLUDED.catalog_item_id,
+			tmdb_id          = COALESCE(EXCLUDED.tmdb_id, recordings.tmdb_id),
+			mb_id            = COALESCE(EXCLUDED.mb_id, recordings.mb_id),
+			last_seen_at     = NOW(),
+			is_missing       = false`,
+		sp.RoostID, sp.ID, absPath, fi.Size(), fi.ModTime().UTC(), hash,
+		nullableFloat(info.Duration), string(mf.Kind), mf.Title, nullableString(mf.Show),
+		nullableInt(mf.Season), nullableInt(mf.Episode), nullableInt(mf.Year),
+		nullableString(mf.Artist), nullableString(mf.Album), nullableString(mf.Platform),
+		nullableString(info.VideoCodec), nullableString(info.AudioCodec),
+		nullableInt(info.Width), nullableInt(info.Height), nullableInt64(info.Bitrate),
+		nullableString(catalogID), nullableInt(tmdbID), nullableString(mbID))
+	if err != nil {
+		return ResultSkipped, fmt.Errorf("upsert recording %s: %w", absPath, err)
+	}
+
+	ix.refreshCounts(ctx, mf.Kind, catalogID)
+	return ResultIndexed, nil
+}
+
+// applyTags lets embedded container tags override folder-derived music fields.
+func applyTags(mf *MediaFile, info *ProbeInfo) {
+	if mf.Kind != KindMusic {
+		return
+	}
+	if v := info.Tags["title"]; v != "" {
+		mf.Title = v
+	}
+	if v := info.Tags["album_artist"]; v != "" {
+		mf.Artist = v
+	} else if v := info.Tags["artist"]; v != "" {
+		mf.Artist = v
+	}
+	if v := info.Tags["album"]; v != "" {
+		mf.Album = v
+	}
+	if v := info.Tags["date"]; len(v) >= 4 {
+		if y, err := strconv.Atoi(v[:4]); err == nil {
+			mf.Year = y
+		}
+	}
+}
+
+// upsertCatalog finds or creates the catalog row a file belongs to and returns
+// (catalog id, tmdb id, musicbrainz id). Metadata is fetched only when a row is
+// first created.
+func (ix *Indexer) upsertCatalog(ctx context.Context, mf MediaFile, info *ProbeInfo) (string, int, string, error) {
+	switch mf.Kind {
+	case KindMovie:
+		return ix.upsertVideoItem(ctx, "movie", mf.Title, mf.Year, int(info.Duration))
+	case KindEpisode:
+		return ix.upsertVideoItem(ctx, "series", mf.Show, mf.Year, 0)
+	case KindMusic:
+		if mf.Album == "" {
+			return "", 0, "", nil // loose track — indexed without an album
+		}
+		return ix.upsertAlbum(ctx, mf)
+	case KindGame:
+		id, err := ix.upsertGame(ctx, mf)
+		return id, 0, "", err
+	}
+	return "", 0, "", nil
+}
+
+// upsertVideoItem finds or creates a catalog_items movie/series row.
+func (ix *Indexer) upsertVideoItem(ctx context.Context, contentType, title string, year, durationSecs int) (string, int, string, error) {
+	if title == "" {
+		return "", 0, "", nil
+	}
+	cacheKey := fmt.Sprintf("%s|%s|%d", contentType, strings.ToLower(title), year)
+	if id, ok := ix.cached(cacheKey); ok {
+		return id, 0, "", nil
+	}
+
+	id, err := findVideoItem(ctx, ix.db, contentType, title, year)
+	if err == nil {
+		ix.remember(cacheKey, id)
+		return id, 0, "", nil
+	}
+	if err != sql.ErrNoRows {
+		return "", 0, "", err
+	}
+
+	// New title: match metadata before inserting.
+	var (
+		desc, cover, genres string
+		score               float64
+		tmdbID              int
+	)
+	if ix.tmdb != nil {
+		yearStr := ""
+		if year > 0 {
+			yearStr = strconv.Itoa(year)
+		}
+		if contentType == "movie" {
+			if m, err := ix.tmdb.SearchMovie(ctx, title, yearStr); err == nil {
+				desc, cover, score, tmdbID = m.Overview, m.PosterURL(), m.VoteAverage, m.ID
+				if full, err := ix.tmdb.GetMovieDetails(ctx, m.ID); err == nil {
+					genres = strings.Join(full.GenreNames(), ",")
+				}
+				if year == 0 && len(m.ReleaseDate) >= 4 {
+					year, _ = strconv.Atoi(m.ReleaseDate[:4])
+				}
+			}
+		} else if sh, err := ix.tmdb.SearchShow(ctx, title, yearStr); err == nil {
+			desc, cover, score, tmdbID = sh.Overview, sh.PosterURL(), sh.VoteAverage, sh.ID
+			if year == 0 && len(sh.FirstAirDate) >= 4 {
+				year, _ = strconv.Atoi(sh.FirstAirDate[:4])
+			}
+		}
+	}
+
+	// catalog_items has no unique key on title: serialise creation of this
+	// title per type and look again, in case another file of the same series
+	// created it while metadata was being fetched.
+	tx, err := ix.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`,
+		"catalog_items|"+contentType+"|"+strings.ToLower(title)); err != nil {
+		return "", 0, "", err
+	}
+	id, err = findVideoItem(ctx, tx, contentType, title, year)
+	if err == nil {
+		ix.remember(cacheKey, id)
+		return id, 0, "", tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return "", 0, "", err
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO catalog_items
+			(title, content_type, description, genres, release_year, cover_url, tmdb_score,
+			 duration_seconds, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, true)
+		RETURNING id`,
+		title, contentType, nullableString(desc), nullableString(genres), nullableInt(year),
+		nullableString(cover), score, nullableInt(durationSecs)).Scan(&id)
+	if err != nil {
+		return "", 0, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", 0, "", err
+	}
+	ix.remember(cacheKey, id)
+	return id, tmdbID, "", nil
+}
+
+// findVideoItem returns the catalog_items row for a title. A known year only
+// matches rows from that year (or with no year), so remakes stay apart; an
+// unknown year takes the best-known row of that title.
+func findVideoItem(ctx context.Context, q queryer, contentType, title string, year int) (string, error) {
+	var id string
+	err := q.QueryRowContext(ctx, `
+		SELECT id FROM catalog_items
+		WHERE LOWER(title) = LOWER($1) AND content_type = $2
+		  AND ($3 = 0 OR release_year IS NULL OR release_year = $3)
+		ORDER BY (release_year = $3) DESC NULLS LAST, is_active DESC
+		LIMIT 1`,
+		title, contentType, year).Scan(&id)
+	return id, err
+}
+
+// queryer is satisfied by *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// upsertAlbum finds or creates a music_albums row for the track's artist + album.
+func (ix *Indexer) upsertAlbum(ctx context.Context, mf MediaFile) (string, int, string, error) {
+	cacheKey := fmt.Sprintf("album|%s|%s", strings.ToLower(mf.Artist), strings.ToLower(mf.Album))
+	if id, ok := ix.cached(cacheKey); ok {
+		return id, 0, "", nil
+	}
+
+	var id string
+	err := ix.db.QueryRowContext(ctx, `
+		SELECT id FROM music_albums WHERE artist = $1 AND album_title = $2`,
+		mf.Artist, mf.Album).Scan(&id)
+	if err == nil {
+		ix.remember(cacheKey, id)
+		return id, 0, "", nil
+	}
+	if err != sql.ErrNoRows {
+		return "", 0, "", err
+	}
+
+	var mbID string
+	if rel, err := ix.mb.SearchRelease(ctx, mf.Album, mf.Artist); err == nil {
+		mbID = rel.ID
+	}
+	err = ix.db.QueryRowContext(ctx, `
+		INSERT INTO music_albums (artist, album_title, release_year, mb_release_id, track_count, is_active)
+		VALUES ($1, $2, $3, $4, 0, true)
+		RETURNING id`,
+		mf.Artist, mf.Album, nullableInt(mf.Year), nullableString(mbID)).Scan(&id)
+	if err != nil {
+		return "", 0, "", err
+	}
+	ix.remember(cacheKey, id)
+	return id, 0, mbID, nil
+}
+
+// upsertGame finds or creates a games row for a ROM.
+func (ix *Indexer) upsertGame(ctx context.Context, mf MediaFile) (string, error) {
+	var id string
+	err := ix.db.QueryRowContext(ctx, `
+		SELECT id FROM games WHERE title = $1 AND platform = $2`, mf.Title, mf.Platform).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	err = ix.db.QueryRowContext(ctx, `
+		INSERT INTO games (title, platform, rom_path, players, save_slots, is_active)
+		VALUES ($1, $2, $3, 1, 3, true)
+		RETURNING id`, mf.Title, mf.Platform, mf.Path).Scan(&id)
+	return id, err
+}
+
+// refreshCounts recomputes denormalised counts shown in /owl/library after a
+// file changes, and hides catalog rows whose files have all gone missing.
+func (ix *Indexer) refreshCounts(ctx context.Context, kind Kind, catalogID string) {
+	if catalogID == "" {
+		return
+	}
+	const present = `EXISTS (SELECT 1 FROM recordings WHERE catalog_item_id = $1 AND NOT is_missing)`
+	switch kind {
+	case KindMovie:
+		_, _ = ix.db.ExecContext(ctx, `
+			UPDATE catalog_items SET is_active = `+present+` WHERE id = $1`, catalogID)
+	case KindEpisode:
+		_, _ = ix.db.ExecContext(ctx, `
+			UPDATE catalog_items SET
+				episode_count = (SELECT COUNT(*) FROM recordings
+				                 WHERE catalog_item_id = $1 AND NOT is_missing),
+				season_count  = (SELECT COUNT(DISTINCT season_number) FROM recordings
+				                 WHERE catalog_item_id = $1 AND NOT is_missing),
+				is_active     = `+present+`
+			WHERE id = $1`, catalogID)
+	case KindMusic:
+		_, _ = ix.db.ExecContext(ctx, `
+			UPDATE music_albums SET
+				track_count = (SELECT COUNT(*) FROM recordings
+				               WHERE catalog_item_id = $1 AND NOT is_missing),
+				is_active   = `+present+`
+			WHERE id = $1`, catalogID)
+	case KindGame:
+		_, _ = ix.db.ExecContext(ctx, `
+			UPDATE games SET is_active = `+present+` WHERE id = $1`, catalogID)
+	}
+}
+
+// MarkMissing flags the file at absPath — or every file beneath it when absPath
+// was a directory — as missing. Rows are kept so watch history and catalog
+// links survive; a later IngestFile of the same path clears the flag.
+// Returns the number of recordings marked.
+func (ix *Indexer) MarkMissing(ctx context.Context, roostID, absPath string) (int, error) {
+	prefix := strings.TrimSuffix(absPath, string(filepath.Separator)) + string(filepath.Separator)
+	rows, err := ix.db.QueryContext(ctx, `
+		UPDATE recordings SET is_missing = true
+		WHERE roost_id = $1 AND NOT is_missing
+		  AND (file_path = $2 OR starts_with(file_path, $3))
+		RETURNING COALESCE(media_kind, ''), COALESCE(catalog_item_id::text, '')`,
+		roostID, absPath, prefix)
+	if err != nil {
+		return 0, err
+	}
+	affected := map[string]Kind{}
+	n := 0
+	for rows.Next() {
+		var kind, catalogID string
+		if err := rows.Scan(&kind, &catalogID); err != nil {
+			continue
+		}
+		n++
+		if catalogID != "" {
+			affected[catalogID] = Kind(kind)
+		}
+	}
+	rows.Close()
+
+	for catalogID, kind := range affected {
+		ix.refreshCounts(ctx, kind, catalogID)
+	}
+	return n, rows.Err()
+}
+
+func (ix *Indexer) cached(key string) (string, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	id, ok := ix.catalog[key]
+	return id, ok
+}
+
+func (ix *Indexer) remember(key, id string) {
+	ix.mu.Lock()
+	ix.catalog[key] = id
+	ix.mu.Unlock()
+}
+
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullableInt(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+func nullableInt64(n int64) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+func nullableFloat(f float64) interface{} {
+	if f == 0 {
+		return nil
+	}
+	return f
+}
@@ -0,0 +1,160 @@
+//go:build linux
+
+// inotify_linux.go — inotify-backed notifier for the library watcher.
+//
+// inotify watches are per directory, so the watcher adds one per directory
+// under each storage path. The fd is opened non-blocking and wrapped in an
+// *os.File so reads go through the Go poller and Close unblocks the reader.
+//
+// Files are reported when they are closed after writing (IN_CLOSE_WRITE) or
+// moved in (IN_MOVED_TO), never on IN_CREATE: a file copied in slowly would
+// otherwise be ingested half-written once the debounce expires between
+// writes. New directories are reported on IN_CREATE so they are watched at once.
+//
+// Limits: inotify only sees changes made through this kernel. Files written to
+// an NFS export by another machine are not reported — those paths still need
+// the periodic or manual full scan. Large trees may need
+// fs.inotify.max_user_watches raised (default 8192 on older kernels).
+package library
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO |
+	syscall.IN_MOVED_FROM | syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_ONLYDIR
+
+// inotifyEventSize is sizeof(struct inotify_event) without the trailing name.
+const inotifyEventSize = syscall.SizeofInotifyEvent
+
+type inotify struct {
+	fd     int
+	f      *os.File
+	events chan fsEvent
+
+	mu    sync.Mutex
+	wds   map[int]string // watch descriptor → directory
+	paths map[string]int // directory → watch descriptor
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	n := &inotify{
+		fd:     fd,
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan fsEvent, 256),
+		wds:    map[int]string{},
+		paths:  map[string]int{},
+	}
+	go n.readLoop()
+	return n, nil
+}
+
+func (n *inotify) Add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	n.wds[wd] = dir
+	n.paths[dir] = wd
+	n.mu.Unlock()
+	return nil
+}
+
+func (n *inotify) Remove(dir string) {
+	prefix := dir + string(filepath.Separator)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for path, wd := range n.paths {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			_, _ = syscall.InotifyRmWatch(n.fd, uint32(wd))
+			delete(n.paths, path)
+			delete(n.wds, wd)
+		}
+	}
+}
+
+func (n *inotify) Watching(dir string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, ok := n.paths[dir]
+	return ok
+}
+
+func (n *inotify) Events() <-chan fsEvent { return n.events }
+
+func (n *inotify) Close() error { return n.f.Close() }
+
+// readLoop decodes raw inotify records into fsEvents until the fd is closed.
+func (n *inotify) readLoop() {
+	defer close(n.events)
+	buf := make([]byte, 64*(inotifyEventSize+syscall.NAME_MAX+1))
+	for {
+		read, err := n.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) && err != io.EOF {
+				log.Printf("[library] inotify read: %v", err)
+			}
+			return
+		}
+		for off := 0; off+inotifyEventSize <= read; {
+			wd := int(int32(binary.NativeEndian.Uint32(buf[off:])))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			name := strings.TrimRight(string(buf[off+inotifyEventSize:off+inotifyEventSize+nameLen]), "\x00")
+			off += inotifyEventSize + nameLen
+
+			if ev, ok := n.translate(wd, mask, name); ok {
+				n.events <- ev
+			}
+		}
+	}
+}
+
+// translate maps one inotify record to an fsEvent.
+func (n *inotify) translate(wd int, mask uint32, name string) (fsEvent, bool) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return fsEvent{Op: opOverflow}, true
+	}
+
+	n.mu.Lock()
+	dir, ok := n.wds[wd]
+	if mask&syscall.IN_IGNORED != 0 && ok {
+		delete(n.wds, wd) // kernel dropped the watch (directory deleted or unmounted)
+		delete(n.paths, dir)
+	}
+	n.mu.Unlock()
+	if !ok {
+		return fsEvent{}, false
+	}
+
+	isDir := mask&syscall.IN_ISDIR != 0
+	switch {
+	case mask&syscall.IN_DELETE_SELF != 0:
+		return fsEvent{Path: dir, Op: opRemoved, IsDir: true}, true
+	case name == "":
+		return fsEvent{}, false
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		return fsEvent{Path: filepath.Join(dir, name), Op: opRemoved, IsDir: isDir}, true
+	case mask&syscall.IN_CREATE != 0 && !isDir:
+		// A new file is still being written; it is queued by the
+		// IN_CLOSE_WRITE when the writer finishes (or IN_MOVED_TO when a
+		// finished file is moved in), not on creation.
+		return fsEvent{}, false
+	case mask&(syscall.IN_CREATE|syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0:
+		return fsEvent{Path: filepath.Join(dir, name), Op: opChanged, IsDir: isDir}, true
+	}
+	return fsEvent{}, false
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+// inotify_linux_test.go — Unit tests for inotify event translation.
+package library
+
+import (
+	"syscall"
+	"testing"
+)
+
+// TestInotifyTranslateWaitsForClose verifies a new file is only reported once
+// it has been closed after writing or moved in, while a new directory is
+// reported on creation.
+func TestInotifyTranslateWaitsForClose(t *testing.T) {
+	n := &inotify{wds: map[int]string{1: "/media"}, paths: map[string]int{"/media": 1}}
+
+	if ev, ok := n.translate(1, syscall.IN_CREATE, "movie.mkv"); ok {
+		t.Errorf("IN_CREATE of a file should not queue it, got %+v", ev)
+	}
+	for _, mask := range []uint32{syscall.IN_CLOSE_WRITE, syscall.IN_MOVED_TO} {
+		ev, ok := n.translate(1, mask, "movie.mkv")
+		if !ok || ev.Op != opChanged || ev.Path != "/media/movie.mkv" || ev.IsDir {
+			t.Errorf("mask %#x: expected changed /media/movie.mkv, got %+v (ok=%v)", mask, ev, ok)
+		}
+	}
+	ev, ok := n.translate(1, syscall.IN_CREATE|syscall.IN_ISDIR, "Season 1")
+	if !ok || ev.Op != opChanged || !ev.IsDir {
+		t.Errorf("expected new directory to be reported, got %+v (ok=%v)", ev, ok)
+	}
+}
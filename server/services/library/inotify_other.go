@@ -0,0 +1,11 @@
+//go:build !linux
+
+// inotify_other.go — Filesystem watching is Linux-only; other platforms rely
+// on full scans triggered via POST /admin/storage/scan.
+package library
+
+import "errors"
+
+func newNotifier() (notifier, error) {
+	return nil, errors.New("filesystem watching requires Linux inotify")
+}
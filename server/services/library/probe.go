@@ -0,0 +1,134 @@
+// probe.go — ffprobe and content-hash helpers for scanned files.
+//
+// Requires ffprobe on PATH (or FFPROBE_PATH). Probe failures are not fatal to a
+// scan: the file is still indexed, just without codec/duration details.
+package library
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbeInfo holds the stream details ffprobe reports for a media file.
+type ProbeInfo struct {
+	VideoCodec string
+	AudioCodec string
+	Width      int
+	Height     int
+	Duration   float64           // seconds
+	Bitrate    int64             // bits/sec
+	Tags       map[string]string // lower-cased format tags (title, artist, album, date, ...)
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecName string `json:"codec_name"`
+		CodecType string `json:"codec_type"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		Duration string            `json:"duration"`
+		BitRate  string            `json:"bit_rate"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+// Probe runs ffprobe against path with a 60-second timeout.
+func Probe(ctx context.Context, path string) (*ProbeInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	ffprobePath := os.Getenv("FFPROBE_PATH")
+	if ffprobePath == "" {
+		ffprobePath = "ffprobe"
+	}
+	out, err := exec.CommandContext(ctx, ffprobePath,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_streams",
+		"-show_format",
+		path,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe exec: %w", err)
+	}
+	return parseProbe(out)
+}
+
+// parseProbe decodes ffprobe's JSON output.
+func parseProbe(out []byte) (*ProbeInfo, error) {
+	var data ffprobeOutput
+	if err := json.Unmarshal(out, &data); err != nil {
+		return nil, fmt.Errorf("ffprobe parse: %w", err)
+	}
+
+	info := &ProbeInfo{Tags: map[string]string{}}
+	for _, s := range data.Streams {
+		switch s.CodecType {
+		case "video":
+			if info.VideoCodec == "" && s.CodecName != "mjpeg" && s.CodecName != "png" { // skip cover art
+				info.VideoCodec = s.CodecName
+				info.Width, info.Height = s.Width, s.Height
+			}
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = s.CodecName
+			}
+		}
+	}
+	info.Duration, _ = strconv.ParseFloat(data.Format.Duration, 64)
+	info.Bitrate, _ = strconv.ParseInt(data.Format.BitRate, 10, 64)
+	for k, v := range data.Format.Tags {
+		info.Tags[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	return info, nil
+}
+
+// hashSampleBytes is how much of the head and tail of a file is hashed.
+const hashSampleBytes = 4 << 20
+
+// ContentHash returns a hex SHA-256 identifying a file's content.
+// Files up to 8 MB are hashed in full; larger files hash their size plus the
+// first and last 4 MB so multi-GB NAS libraries can be indexed quickly. Two
+// byte-identical copies always produce the same hash.
+func ContentHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:", fi.Size())
+
+	if fi.Size() <= 2*hashSampleBytes {
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	if _, err := io.CopyN(h, f, hashSampleBytes); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(-hashSampleBytes, io.SeekEnd); err != nil {
+		return "", err
+	}
+	if _, err := io.CopyN(h, f, hashSampleBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,374 @@
+// scanner.go — background library scan jobs.
+//
+// Design:
+//   - Manager owns a job queue; POST /admin/storage/scan enqueues, Run drains it
+//   - One scan per roost at a time — a second request while one is queued or
+//     running returns ErrScanInProgress with the active job id
+//   - Each job walks every active local/nfs storage path of the roost (or the
+//     single path requested), counts candidate files first so progress can
+//     report percent_complete, then ingests files with a small worker pool
+//   - Progress is published as JSON (ScanProgressEvent shape) to Redis channel
+//     "roost:scan_progress:{roostID}" when a publisher is configured, and to
+//     in-process subscribers for deployments without Redis
+//   - Files indexed earlier but no longer on disk are marked missing
+//   - On completion roost_storage_paths.item_count / used_bytes / last_scanned_at
+//     are refreshed
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrScanInProgress is returned by Enqueue when the roost already has a scan queued or running.
+var ErrScanInProgress = errors.New("scan already in progress")
+
+// PublishFunc delivers a progress payload to an external pub/sub channel (Redis).
+type PublishFunc func(ctx context.Context, channel, payload string) error
+
+// Progress is the state of a roost's most recent scan job.
+// JSON field names match the admin SSE ScanProgressEvent.
+type Progress struct {
+	JobID           string     `json:"job_id"`
+	Status          string     `json:"status"` // "queued" | "running" | "complete" | "error"
+	FilesScanned    int        `json:"files_scanned"`
+	FilesFound      int        `json:"files_found"`
+	Errors          int        `json:"errors"`
+	PercentComplete float64    `json:"percent_complete"`
+	CurrentPath     string     `json:"current_path,omitempty"`
+	StartedAt       time.Time  `json:"-"`
+	EstimatedFinish *time.Time `json:"estimated_finish,omitempty"`
+}
+
+// Running reports whether the job is queued or in progress.
+func (p Progress) Running() bool {
+	return p.Status == "queued" || p.Status == "running"
+}
+
+type job struct {
+	id      string
+	roostID string
+	pathID  string // "" = all paths
+}
+
+// Manager runs library scan jobs.
+type Manager struct {
+	db          *sql.DB
+	ix          *Indexer
+	publish     PublishFunc
+	concurrency int
+	jobs        chan job
+
+	pathsChanged chan struct{} // wakes the Watcher after storage paths are added/removed
+
+	mu       sync.Mutex
+	progress map[string]*Progress                // roostID → latest job
+	subs     map[string]map[chan []byte]struct{} // roostID → in-process subscribers
+}
+
+// NewManager creates a Manager. publish may be nil (in-process subscribers only).
+func NewManager(db *sql.DB, publish PublishFunc) *Manager {
+	return &Manager{
+		db:           db,
+		ix:           NewIndexer(db),
+		publish:      publish,
+		concurrency:  4,
+		jobs:         make(chan job, 32),
+		pathsChanged: make(chan struct{}, 1),
+		progress:     map[string]*Progress{},
+		subs:         map[string]map[chan []byte]struct{}{},
+	}
+}
+
+// Indexer returns the Manager's Indexer so incremental updates share its catalog cache.
+func (m *Manager) Indexer() *Indexer { return m.ix }
+
+// PathsChanged tells the Watcher to re-read roost_storage_paths now rather
+// than at its next periodic refresh. Never blocks.
+func (m *Manager) PathsChanged() {
+	select {
+	case m.pathsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes queued scan jobs until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-m.jobs:
+			m.runJob(ctx, j)
+		}
+	}
+}
+
+// Enqueue queues a scan of one storage path (pathID) or all of a roost's paths
+// (pathID == ""). Returns the job id.
+func (m *Manager) Enqueue(roostID, pathID string) (string, error) {
+	m.mu.Lock()
+	if p, ok := m.progress[roostID]; ok && p.Running() {
+		m.mu.Unlock()
+		return p.JobID, ErrScanInProgress
+	}
+	j := job{id: uuid.New().String(), roostID: roostID, pathID: pathID}
+	m.progress[roostID] = &Progress{JobID: j.id, Status: "queued", StartedAt: time.Now()}
+	m.mu.Unlock()
+
+	select {
+	case m.jobs <- j:
+		return j.id, nil
+	default:
+		m.mu.Lock()
+		delete(m.progress, roostID)
+		m.mu.Unlock()
+		return "", fmt.Errorf("scan queue full")
+	}
+}
+
+// Status returns a snapshot of the roost's latest scan job (zero value when none ran).
+func (m *Manager) Status(roostID string) Progress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.progress[roostID]; ok {
+		return *p
+	}
+	return Progress{}
+}
+
+// Subscribe returns a channel receiving JSON progress events for roostID.
+// Call cancel to unsubscribe. Slow subscribers miss intermediate events.
+func (m *Manager) Subscribe(roostID string) (<-chan []byte, func()) {
+	ch := make(chan []byte, 16)
+	m.mu.Lock()
+	if m.subs[roostID] == nil {
+		m.subs[roostID] = map[chan []byte]struct{}{}
+	}
+	m.subs[roostID][ch] = struct{}{}
+	m.mu.Unlock()
+
+	return ch, func() {
+		m.mu.Lock()
+		delete(m.subs[roostID], ch)
+		m.mu.Unlock()
+	}
+}
+
+// runJob scans the storage paths selected by j.
+func (m *Manager) runJob(ctx context.Context, j job) {
+	started := time.Now()
+	paths, err := m.storagePaths(ctx, j.roostID, j.pathID)
+	if err != nil {
+		log.Printf("[library] job %s: load storage paths: %v", j.id, err)
+		m.update(ctx, j.roostID, func(p *Progress) { p.Status = "error"; p.Errors++ })
+		return
+	}
+
+	// Pass 1: collect candidate files so progress has a denominator.
+	type candidate struct {
+		sp   StoragePath
+		path string
+	}
+	var files []candidate
+	for _, sp := range paths {
+		_ = filepath.WalkDir(sp.Path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				log.Printf("[library] job %s: walk %s: %v", j.id, path, err)
+				return nil
+			}
+			if d.IsDir() {
+				if path != sp.Path && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if _, ok := Classify(path); ok {
+				files = append(files, candidate{sp, path})
+			}
+			return ctx.Err()
+		})
+	}
+	m.update(ctx, j.roostID, func(p *Progress) {
+		p.Status = "running"
+		p.FilesFound = len(files)
+	})
+	log.Printf("[library] job %s: scanning %d file(s) across %d path(s)", j.id, len(files), len(paths))
+
+	// Pass 2: ingest.
+	work := make(chan candidate)
+	var wg sync.WaitGroup
+	for i := 0; i < m.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range work {
+				_, err := m.ix.IngestFile(ctx, c.sp, c.path)
+				if err != nil {
+					log.Printf("[library] job %s: %v", j.id, err)
+				}
+				m.update(ctx, j.roostID, func(p *Progress) {
+					p.FilesScanned++
+					p.CurrentPath = c.path
+					if err != nil {
+						p.Errors++
+					}
+				})
+			}
+		}()
+	}
+	for _, c := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- c
+	}
+	close(work)
+	wg.Wait()
+
+	for _, sp := range paths {
+		if ctx.Err() == nil {
+			m.markVanished(ctx, sp, started)
+		}
+		m.refreshPathStats(ctx, sp)
+	}
+
+	m.update(ctx, j.roostID, func(p *Progress) {
+		p.CurrentPath = ""
+		if ctx.Err() != nil {
+			p.Status = "error"
+			return
+		}
+		p.Status = "complete"
+		p.PercentComplete = 100
+	})
+	st := m.Status(j.roostID)
+	log.Printf("[library] job %s: %s — %d scanned, %d error(s)", j.id, st.Status, st.FilesScanned, st.Errors)
+}
+
+// storagePaths loads walkable storage paths: one roost's (roostID != "") or
+// every roost's, optionally narrowed to a single path id.
+func (m *Manager) storagePaths(ctx context.Context, roostID, pathID string) ([]StoragePath, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT id, roost_id, path, path_type::text
+		FROM roost_storage_paths
+		WHERE ($1 = '' OR roost_id::text = $1) AND is_active = true
+		  AND path_type IN ('local', 'nfs')
+		  AND ($2 = '' OR id::text = $2)
+		ORDER BY created_at`, roostID, pathID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []StoragePath
+	for rows.Next() {
+		var sp StoragePath
+		if err := rows.Scan(&sp.ID, &sp.RoostID, &sp.Path, &sp.PathType); err != nil {
+			continue
+		}
+		sp.Path = filepath.Clean(sp.Path)
+		paths = append(paths, sp)
+	}
+	return paths, rows.Err()
+}
+
+// markVanished marks recordings under sp that the scan did not see and whose
+// files no longer exist. Files that failed to ingest keep their old state.
+func (m *Manager) markVanished(ctx context.Context, sp StoragePath, since time.Time) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT file_path FROM recordings
+		WHERE storage_path_id = $1 AND NOT is_missing
+		  AND (last_seen_at IS NULL OR last_seen_at < $2)`, sp.ID, since)
+	if err != nil {
+		log.Printf("[library] find vanished files under %s: %v", sp.Path, err)
+		return
+	}
+	var gone []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err == nil {
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				gone = append(gone, path)
+			}
+		}
+	}
+	rows.Close()
+
+	for _, path := range gone {
+		if _, err := m.ix.MarkMissing(ctx, sp.RoostID, path); err != nil {
+			log.Printf("[library] mark missing %s: %v", path, err)
+		}
+	}
+	if len(gone) > 0 {
+		log.Printf("[library] %s: %d file(s) marked missing", sp.Path, len(gone))
+	}
+}
+
+// refreshPathStats updates the cached usage columns on roost_storage_paths.
+func (m *Manager) refreshPathStats(ctx context.Context, sp StoragePath) {
+	_, err := m.db.ExecContext(ctx, `
+		UPDATE roost_storage_paths SET
+			item_count      = s.n,
+			used_bytes      = s.bytes,
+			last_scanned_at = NOW()
+		FROM (SELECT COUNT(*) AS n, COALESCE(SUM(file_size_bytes), 0) AS bytes
+		      FROM recordings
+		      WHERE storage_path_id = $1 AND NOT is_missing) s
+		WHERE id = $1`, sp.ID)
+	if err != nil {
+		log.Printf("[library] update stats for %s: %v", sp.Path, err)
+	}
+}
+
+// update mutates the roost's progress under lock and publishes the new state.
+// Running updates are throttled to one publish per 250 files or 1% progress.
+func (m *Manager) update(ctx context.Context, roostID string, fn func(*Progress)) {
+	m.mu.Lock()
+	p, ok := m.progress[roostID]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	prevPct := p.PercentComplete
+	fn(p)
+	if p.Status == "running" && p.FilesFound > 0 {
+		p.PercentComplete = float64(p.FilesScanned) * 100 / float64(p.FilesFound)
+		if p.FilesScanned > 0 {
+			elapsed := time.Since(p.StartedAt)
+			eta := p.StartedAt.Add(time.Duration(float64(elapsed) * float64(p.FilesFound) / float64(p.FilesScanned)))
+			p.EstimatedFinish = &eta
+		}
+		if p.FilesScanned%250 != 0 && int(p.PercentComplete) == int(prevPct) {
+			m.mu.Unlock()
+			return
+		}
+	}
+	payload, _ := json.Marshal(p)
+	for ch := range m.subs[roostID] {
+		select {
+		case ch <- payload:
+		default:
+		}
+	}
+	m.mu.Unlock()
+
+	if m.publish != nil {
+		channel := fmt.Sprintf("roost:scan_progress:%s", roostID)
+		if err := m.publish(ctx, channel, string(payload)); err != nil {
+			log.Printf("[library] publish progress: %v", err)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// watch.go — incremental library updates from filesystem events.
+//
+// Design:
+//   - One notifier (inotify on Linux) watches every directory under each
+//     active local/nfs storage path
+//   - Files are queued when closed after writing or moved in, never when
+//     created, so a slow copy is not ingested half-written. Events are then
+//     debounced per file: a path is processed once it has been quiet for
+//     Debounce (default 2s), so a file rewritten in several passes is
+//     ingested once
+//   - Changed files go through Indexer.IngestFile; deleted or moved-away files
+//     (and everything under a deleted directory) are marked missing
+//   - New directories are watched immediately and their contents queued, which
+//     covers "move a finished download into the library" workflows
+//   - Watched paths are re-read from roost_storage_paths every RefreshEvery
+//     (default 5 minutes) and whenever Manager.PathsChanged is called
+//   - An inotify queue overflow means events were lost: a full scan is
+//     enqueued for every watched roost
+package library
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fsOp int
+
+const (
+	opChanged  fsOp = iota // created, written, or moved into a watched directory
+	opRemoved              // deleted or moved out of a watched directory
+	opOverflow             // kernel queue overflowed; events were dropped
+)
+
+type fsEvent struct {
+	Path  string
+	Op    fsOp
+	IsDir bool
+}
+
+// notifier is the platform filesystem-event source (see inotify_linux.go).
+type notifier interface {
+	Add(dir string) error
+	Remove(dir string) // removes dir and every watched directory beneath it
+	Watching(dir string) bool
+	Events() <-chan fsEvent
+	Close() error
+}
+
+// WatchConfig holds watcher configuration.
+type WatchConfig struct {
+	Debounce     time.Duration // quiet period before a changed path is processed
+	RefreshEvery time.Duration // how often storage paths are re-read from the DB
+}
+
+// Watcher applies filesystem events to the library as they happen.
+type Watcher struct {
+	cfg   WatchConfig
+	m     *Manager
+	n     notifier
+	roots map[string]StoragePath // storage path root → row
+
+	pending map[string]pendingEvent
+}
+
+type pendingEvent struct {
+	op fsOp
+	at time.Time
+}
+
+// NewWatcher creates a Watcher feeding m's Indexer. Zero config values fall
+// back to a 2s debounce and a 5 minute path refresh.
+func NewWatcher(cfg WatchConfig, m *Manager) *Watcher {
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = 2 * time.Second
+	}
+	if cfg.RefreshEvery <= 0 {
+		cfg.RefreshEvery = 5 * time.Minute
+	}
+	return &Watcher{
+		cfg:     cfg,
+		m:       m,
+		roots:   map[string]StoragePath{},
+		pending: map[string]pendingEvent{},
+	}
+}
+
+// Run watches storage paths until ctx is cancelled. Returns immediately when
+// the platform has no filesystem notifications.
+func (w *Watcher) Run(ctx context.Context) {
+	n, err := newNotifier()
+	if err != nil {
+		log.Printf("[library] watcher disabled: %v", err)
+		return
+	}
+	w.n = n
+	defer n.Close()
+
+	w.refreshRoots(ctx)
+	refresh := time.NewTicker(w.cfg.RefreshEvery)
+	defer refresh.Stop()
+	flush := time.NewTicker(w.cfg.Debounce / 2)
+	defer flush.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-refresh.C:
+			w.refreshRoots(ctx)
+		case <-w.m.pathsChanged:
+			w.refreshRoots(ctx)
+		case ev, ok := <-n.Events():
+			if !ok {
+				return
+			}
+			w.handle(ctx, ev)
+		case <-flush.C:
+			w.flush(ctx)
+		}
+	}
+}
+
+// refreshRoots syncs the watched set with the active local/nfs storage paths.
+func (w *Watcher) refreshRoots(ctx context.Context) {
+	paths, err := w.m.storagePaths(ctx, "", "")
+	if err != nil {
+		log.Printf("[library] watcher: load storage paths: %v", err)
+		return
+	}
+	active := map[string]StoragePath{}
+	for _, sp := range paths {
+		active[sp.Path] = sp
+	}
+
+	for root := range w.roots {
+		if _, ok := active[root]; !ok {
+			w.n.Remove(root)
+			delete(w.roots, root)
+			log.Printf("[library] watcher: stopped watching %s", root)
+		}
+	}
+	for root, sp := range active {
+		w.roots[root] = sp
+		if w.n.Watching(root) {
+			continue
+		}
+		dirs := w.watchTree(root)
+		log.Printf("[library] watcher: watching %s (%d director(ies))", root, dirs)
+	}
+}
+
+// watchTree adds a watch for dir and every directory beneath it.
+func (w *Watcher) watchTree(dir string) int {
+	added := 0
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if err := w.n.Add(path); err != nil {
+			log.Printf("[library] watcher: watch %s: %v", path, err)
+			return filepath.SkipDir
+		}
+		added++
+		return nil
+	})
+	return added
+}
+
+// handle records an event for debouncing. Directory creations are acted on
+// immediately so files written into them are not missed.
+func (w *Watcher) handle(ctx context.Context, ev fsEvent) {
+	if ev.Op == opOverflow {
+		log.Printf("[library] watcher: event queue overflowed — enqueueing full scans")
+		seen := map[string]bool{}
+		for _, sp := range w.roots {
+			if !seen[sp.RoostID] {
+				seen[sp.RoostID] = true
+				if _, err := w.m.Enqueue(sp.RoostID, ""); err != nil && err != ErrScanInProgress {
+					log.Printf("[library] watcher: enqueue scan for roost %s: %v", sp.RoostID, err)
+				}
+			}
+		}
+		return
+	}
+	if _, ok := w.rootFor(ev.Path); !ok {
+		return
+	}
+
+	if ev.IsDir {
+		switch ev.Op {
+		case opChanged:
+			w.watchTree(ev.Path)
+			_ = filepath.WalkDir(ev.Path, func(path string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					w.pending[path] = pendingEvent{op: opChanged, at: time.Now()}
+				}
+				return nil
+			})
+			return
+		case opRemoved:
+			w.n.Remove(ev.Path)
+		}
+	}
+	w.pending[ev.Path] = pendingEvent{op: ev.Op, at: time.Now()}
+}
+
+// flush processes pending paths that have been quiet for the debounce period.
+func (w *Watcher) flush(ctx context.Context) {
+	if len(w.pending) == 0 {
+		return
+	}
+	cutoff := time.Now().Add(-w.cfg.Debounce)
+	touched := map[string]StoragePath{}
+	for path, pe := range w.pending {
+		if pe.at.After(cutoff) {
+			continue
+		}
+		delete(w.pending, path)
+		sp, ok := w.rootFor(path)
+		if !ok {
+			continue
+		}
+		touched[sp.ID] = sp
+
+		fi, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+			if n, err := w.m.ix.MarkMissing(ctx, sp.RoostID, path); err != nil {
+				log.Printf("[library] watcher: mark missing %s: %v", path, err)
+			} else if n > 0 {
+				log.Printf("[library] watcher: %s removed (%d file(s) marked missing)", path, n)
+			}
+		case err != nil:
+			log.Printf("[library] watcher: stat %s: %v", path, err)
+		case fi.IsDir():
+			// Directory renamed back into place; its files were queued by handle.
+		default:
+			res, err := w.m.ix.IngestFile(ctx, sp, path)
+			if err != nil {
+				log.Printf("[library] watcher: ingest %s: %v", path, err)
+			} else if res == ResultIndexed {
+				log.Printf("[library] watcher: indexed %s", path)
+			}
+		}
+	}
+	for _, sp := range touched {
+		w.m.refreshPathStats(ctx, sp)
+	}
+}
+
+// rootFor returns the storage path containing path (longest matching root).
+func (w *Watcher) rootFor(path string) (StoragePath, bool) {
+	var best StoragePath
+	found := false
+	for root, sp := range w.roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			if !found || len(root) > len(best.Path) {
+				best, found = sp, true
+			}
+		}
+	}
+	return best, found
+}
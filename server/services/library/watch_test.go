@@ -0,0 +1,66 @@
+// watch_test.go — Unit tests for the filesystem watcher.
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestRootForLongestPrefix verifies nested storage paths resolve to the innermost root.
+func TestRootForLongestPrefix(t *testing.T) {
+	w := NewWatcher(WatchConfig{}, nil)
+	w.roots["/mnt/media"] = StoragePath{ID: "outer", Path: "/mnt/media"}
+	w.roots["/mnt/media/tv"] = StoragePath{ID: "inner", Path: "/mnt/media/tv"}
+
+	if sp, ok := w.rootFor("/mnt/media/tv/Show/S01E01.mkv"); !ok || sp.ID != "inner" {
+		t.Errorf("expected inner root, got %+v (ok=%v)", sp, ok)
+	}
+	if sp, ok := w.rootFor("/mnt/media/movies/Heat.mkv"); !ok || sp.ID != "outer" {
+		t.Errorf("expected outer root, got %+v (ok=%v)", sp, ok)
+	}
+	if _, ok := w.rootFor("/mnt/media-archive/x.mkv"); ok {
+		t.Error("sibling directory with a shared name prefix must not match")
+	}
+}
+
+// TestNotifierEvents verifies create/write/delete events are reported with full paths.
+func TestNotifierEvents(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("filesystem watching is Linux-only")
+	}
+	n, err := newNotifier()
+	if err != nil {
+		t.Fatalf("newNotifier: %v", err)
+	}
+	defer n.Close()
+
+	dir := t.TempDir()
+	if err := n.Add(dir); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	file := filepath.Join(dir, "Heat (1995).mkv")
+	if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+
+	var changed, removed bool
+	timeout := time.After(2 * time.Second)
+	for !(changed && removed) {
+		select {
+		case ev := <-n.Events():
+			if ev.Path != file {
+				t.Fatalf("unexpected event path %q", ev.Path)
+			}
+			changed = changed || ev.Op == opChanged
+			removed = removed || ev.Op == opRemoved
+		case <-timeout:
+			t.Fatalf("timed out: changed=%v removed=%v", changed, removed)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// antbox_events.go — Playback of AntBox captures for the Roost Owl Addon API.
+//
+// AntBox devices upload the events the admin scheduled (antbox_events) to
+// PUT /antbox/events/:id/segments/:name; owl_api stores them under
+// {ROOST_DATA_DIR}/antbox/{antbox_id}/{event_id}/ with an HLS EVENT playlist
+// that grows while the capture runs (handlers/admin_antbox_ingest.go).
+//
+// Endpoints:
+//
+//	GET /owl/v1/antbox/events                   — playable captures (capturing or complete)
+//	GET /owl/v1/antbox/events/:id/index.m3u8    — a capture's playlist
+//	GET /owl/v1/antbox/events/:id/:segment      — one .ts segment
+//	(/owl/antbox/... aliases)
+//
+// Players do not send the Authorization header on segment requests, so the
+// playlist is rewritten with the session token on every segment URI.
+// Broadcast captures carry no rating: rating-limited and kids profiles get
+// none, as for library movies.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// antboxSegmentName matches the segment names the AntBox uploads.
+var antboxSegmentName = regexp.MustCompile(`^[0-9]{1,20}-[0-9]{1,10}\.ts$`)
+
+// antboxPlayable are the antbox_events statuses with something to play.
+const antboxPlayable = `('capturing', 'stopping', 'complete')`
+
+// AntBoxCapture is one capture returned by GET /owl/v1/antbox/events.
+type AntBoxCapture struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Channel   string    `json:"channel"`
+	AntBox    string    `json:"antbox"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Live      bool      `json:"live"` // still capturing; the playlist keeps growing
+	StreamURL string    `json:"stream_url"`
+}
+
+// handleAntBoxEvents routes GET /owl/antbox/events[/:id/:file].
+func (s *server) handleAntBoxEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
+		return
+	}
+	rp, ok := s.sessionRestrictions(w, r)
+	if !ok {
+		return
+	}
+
+	path := r.URL.Path
+	for _, prefix := range []string{"/owl/v1/antbox/events", "/owl/antbox/events"} {
+		path = strings.TrimPrefix(path, prefix)
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "":
+		s.listAntBoxCaptures(w, r, rp)
+	case len(parts) == 2 && rp.ratingLimited():
+		writeParentalBlocked(w, rp, "rating")
+	case len(parts) == 2:
+		s.serveAntBoxCapture(w, r, parts[0], parts[1])
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "")
+	}
+}
+
+// listAntBoxCaptures handles GET /owl/v1/antbox/events.
+func (s *server) listAntBoxCaptures(w http.ResponseWriter, r *http.Request, rp *profileRestrictions) {
+	captures := []AntBoxCapture{}
+	if rp.ratingLimited() {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"events": captures})
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT e.id, e.title, e.channel, a.display_name, e.start_time, e.end_time,
+		       e.status <> 'complete'
+		FROM antbox_events e
+		JOIN antboxes a ON a.id = e.antbox_id
+		WHERE e.status IN `+antboxPlayable+`
+		ORDER BY e.start_time DESC
+		LIMIT 100
+	`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch captures")
+		return
+	}
+	defer rows.Close()
+
+	baseURL := getEnv("ROOST_BASE_URL", "https://roost.unity.dev")
+	for rows.Next() {
+		var c AntBoxCapture
+		if err := rows.Scan(&c.ID, &c.Title, &c.Channel, &c.AntBox, &c.StartTime, &c.EndTime, &c.Live); err != nil {
+			continue
+		}
+		c.StreamURL = fmt.Sprintf("%s/owl/v1/antbox/events/%s/index.m3u8", baseURL, c.ID)
+		captures = append(captures, c)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"events": captures})
+}
+
+// serveAntBoxCapture serves a capture's playlist or one of its segments.
+func (s *server) serveAntBoxCapture(w http.ResponseWriter, r *http.Request, eventID, name string) {
+	if _, err := uuid.Parse(eventID); err != nil || (name != "index.m3u8" && !antboxSegmentName.MatchString(name)) {
+		writeError(w, http.StatusNotFound, "not_found", "")
+		return
+	}
+	var antboxID string
+	err := s.db.QueryRowContext(r.Context(), `
+		SELECT antbox_id FROM antbox_events WHERE id = $1 AND status IN `+antboxPlayable,
+		eventID,
+	).Scan(&antboxID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "not_found", "No capture with that id")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to look up capture")
+		return
+	}
+	file := filepath.Join(s.adminH.RoostDataDir, "antbox", antboxID, eventID, name)
+
+	if name != "index.m3u8" {
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		http.ServeFile(w, r, file)
+		return
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		// Started, but the first segment has not arrived yet.
+		w.Header().Set("Retry-After", "6")
+		writeError(w, http.StatusServiceUnavailable, "not_ready", "Capture has no segments yet")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "read_error", "Failed to read playlist")
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(tokenizePlaylist(data, extractSessionToken(r)))
+}
+
+// tokenizePlaylist appends ?token= to every URI line of an HLS playlist.
+func tokenizePlaylist(playlist []byte, token string) []byte {
+	var out bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(playlist))
+	for sc.Scan() {
+		line := sc.Text()
+		if line != "" && !strings.HasPrefix(line, "#") && token != "" {
+			line += "?token=" + url.QueryEscape(token)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
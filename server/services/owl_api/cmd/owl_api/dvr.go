@@ -5,10 +5,15 @@
 // Routes:
 //   GET    /owl/dvr          — list subscriber's recordings + quota
 //   POST   /owl/dvr          — schedule new recording
+//   GET    /owl/dvr/:id      — single recording
 //   DELETE /owl/dvr/:id      — delete recording
 //   GET    /owl/dvr/quota    — quota info only
 //   GET    /owl/dvr/:id/play — proxy DVR recording HLS playlist
+//   POST   /owl/dvr/:id/progress — report playback position (scrobbled for the profile)
 //   GET    /owl/v1/dvr       — v1 aliases for all of the above
+//
+// Recordings carry skip_content_id and skip_markers (intro, recap and
+// credits found by the skip service) from the DVR service.
 package main
 
 import (
@@ -72,7 +77,7 @@ func (s *server) handleDVR(w http.ResponseWriter, r *http.Request) {
 	proxyResponse(w, req)
 }
 
-// handleDVRItem handles DELETE /owl/dvr/:id.
+// handleDVRItem handles GET and DELETE /owl/dvr/:id.
 func (s *server) handleDVRItem(w http.ResponseWriter, r *http.Request) {
 	subID := dvrSubscriberID(r)
 	if subID == "" {
@@ -80,8 +85,8 @@ func (s *server) handleDVRItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.Method != http.MethodDelete {
-		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "DELETE required")
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET or DELETE required")
 		return
 	}
 
@@ -99,7 +104,7 @@ func (s *server) handleDVRItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req, err := http.NewRequestWithContext(r.Context(), http.MethodDelete,
+	req, err := http.NewRequestWithContext(r.Context(), r.Method,
 		dvrServiceURL()+"/dvr/recordings/"+id, nil)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "proxy_error", "")
@@ -174,6 +179,47 @@ func (s *server) handleDVRPlay(w http.ResponseWriter, r *http.Request) {
 	_, _ = io.Copy(w, resp.Body)
 }
 
+// handleDVRProgress handles POST /owl/dvr/:id/progress — forwards the
+// player's position to the DVR service along with the session's profile, so
+// recordings scrobble to the profile's linked Trakt / Simkl accounts.
+func (s *server) handleDVRProgress(w http.ResponseWriter, r *http.Request) {
+	subID := dvrSubscriberID(r)
+	if subID == "" {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "subscriber session required")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "POST required")
+		return
+	}
+
+	path := r.URL.Path
+	for _, prefix := range []string{"/owl/v1/dvr/", "/owl/dvr/"} {
+		path = strings.TrimPrefix(path, prefix)
+	}
+	id := strings.Split(strings.Trim(path, "/"), "/")[0]
+	if id == "" || id == "progress" {
+		writeError(w, http.StatusBadRequest, "bad_request", "recording id required")
+		return
+	}
+	profileID, err := s.viewingProfile(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost,
+		dvrServiceURL()+"/dvr/recordings/"+id+"/progress", http.MaxBytesReader(w, r.Body, 4096))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "proxy_error", "")
+		return
+	}
+	req.Header.Set("X-Subscriber-ID", subID)
+	req.Header.Set("X-Profile-ID", profileID)
+	req.Header.Set("Content-Type", "application/json")
+	proxyResponse(w, req)
+}
+
 // proxyResponse executes an upstream HTTP request and writes the response to w.
 func proxyResponse(w http.ResponseWriter, req *http.Request) {
 	client := &http.Client{Timeout: 10 * time.Second}
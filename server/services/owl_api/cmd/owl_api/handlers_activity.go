@@ -12,8 +12,10 @@ import (
 
 // ── Handler: GET /owl/watch-party/:id ─────────────────────────────────────────
 
-// handleOwlWatchParty returns watch party status for Owl clients.
+// handleOwlWatchParty returns watch party status for Owl clients, or opens
+// the party's sync room (see services/watchparty for the event protocol).
 // GET /owl/watch-party/{id}
+// GET /owl/watch-party/{id}/ws  (WebSocket)
 func (s *server) handleOwlWatchParty(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
@@ -22,10 +24,13 @@ func (s *server) handleOwlWatchParty(w http.ResponseWriter, r *http.Request) {
 
 	// Extract party ID from path: /owl/watch-party/{id} or /owl/v1/watch-party/{id}
 	parts := splitPath(r.URL.Path)
-	partyID := ""
+	partyID, sub := "", ""
 	for i, part := range parts {
 		if part == "watch-party" && i+1 < len(parts) {
 			partyID = parts[i+1]
+			if i+2 < len(parts) {
+				sub = parts[i+2]
+			}
 			break
 		}
 	}
@@ -33,6 +38,10 @@ func (s *server) handleOwlWatchParty(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "missing_party_id", "party ID required")
 		return
 	}
+	if sub == "ws" {
+		s.parties.ServeWS(w, r, partyID, r.Header.Get("X-Subscriber-ID"))
+		return
+	}
 
 	var status, contentType, inviteCode string
 	var channelSlug sql.NullString
@@ -0,0 +1,689 @@
+// hdhomerun.go — HDHomeRun network tuner emulation for Plex, Jellyfin and Emby.
+//
+// Media servers that support HDHomeRun tuners can use Roost's live channels
+// without a third-party bridge. The device API is served in two forms:
+//
+//	GET /hdhr/{token}/discover.json      — device description
+//	GET /hdhr/{token}/lineup.json        — channel lineup
+//	GET /hdhr/{token}/lineup_status.json — scan status (never scanning)
+//	POST /hdhr/{token}/lineup.post       — scan request (no-op)
+//	GET /hdhr/{token}/auto/v{channel}    — live MPEG-TS stream
+//
+// and, when HDHR_TOKEN is set, the same paths at the server root
+// (/discover.json, /auto/v7, ...) for clients such as Plex that only accept
+// a bare host:port. {token} is a roost_ API token, the same credential as
+// the Xtream username. HDHR_TOKEN also enables the UDP discovery responder
+// (hdhomerun_discover.go) so the device shows up on the LAN automatically.
+//
+// The lineup is the channels table in sort_order; the guide number is the
+// channel's sort_order, the same number Xtream clients use as stream_id. The
+// family's playout channels follow, numbered from playoutNumberBase
+// (playout.go). The token owner's primary profile applies its parental
+// controls to the lineup and to every tune.
+//
+// TunerCount is the plan's concurrent stream limit. Each /auto/ stream holds
+// one of the subscriber's stream slots (ratelimit.go) for as long as it runs;
+// when all are in use the tune fails with 503 and X-HDHomeRun-Error, which
+// media servers report as "all tuners in use".
+//
+// Streams are remuxed from the ingest HLS output in SEGMENT_DIR, or from the
+// channel service for playout channels: segments are sent back to back as one
+// continuous transport stream, decrypted first when the channel uses AES-128
+// HLS encryption.
+//
+// With on-demand ingest (INGEST_URL set, as for the relay) a tune reports
+// viewer demand to ingest for as long as it streams, so an idle channel starts
+// and a watched one is not stopped; the tune waits up to hdhrStartupWait for
+// the channel's first playlist.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// hdhrLiveEdge is how many of the newest segments a tune starts with.
+	hdhrLiveEdge = 2
+	// hdhrStallTimeout ends a stream when the playlist stops advancing.
+	hdhrStallTimeout = 30 * time.Second
+	// hdhrWriteTimeout bounds each segment write to the client.
+	hdhrWriteTimeout = 30 * time.Second
+	// hdhrSlotRefresh is how often a running stream renews its slot TTL.
+	hdhrSlotRefresh = 10 * time.Minute
+	// hdhrDemandRefresh is how often a running stream reports viewer demand
+	// to on-demand ingest; well inside INGEST_IDLE_LINGER.
+	hdhrDemandRefresh = 15 * time.Second
+	// hdhrStartupWait bounds how long a tune waits for an idle on-demand
+	// channel to produce a playlist (the relay's RELAY_STARTUP_WAIT default).
+	hdhrStartupWait = 20 * time.Second
+)
+
+// ingestClient reports viewer demand to the ingest service.
+var ingestClient = &http.Client{Timeout: 3 * time.Second}
+
+type hdhrDiscover struct {
+	FriendlyName    string `json:"FriendlyName"`
+	Manufacturer    string `json:"Manufacturer"`
+	ModelNumber     string `json:"ModelNumber"`
+	FirmwareName    string `json:"FirmwareName"`
+	FirmwareVersion string `json:"FirmwareVersion"`
+	DeviceID        string `json:"DeviceID"`
+	BaseURL         string `json:"BaseURL"`
+	LineupURL       string `json:"LineupURL"`
+	TunerCount      int    `json:"TunerCount"`
+}
+
+type hdhrLineupEntry struct {
+	GuideNumber string `json:"GuideNumber"`
+	GuideName   string `json:"GuideName"`
+	URL         string `json:"URL"`
+}
+
+type hdhrLineupStatus struct {
+	ScanInProgress int      `json:"ScanInProgress"`
+	ScanPossible   int      `json:"ScanPossible"`
+	Source         string   `json:"Source"`
+	SourceList     []string `json:"SourceList"`
+}
+
+// hdhrDeviceIDChecksum is the nibble table HDHomeRun clients use to validate
+// device IDs (libhdhomerun hdhomerun_discover_validate_device_id).
+var hdhrDeviceIDChecksum = [16]uint32{0xA, 0x5, 0xF, 0x6, 0x7, 0xC, 0x1, 0xB, 0x9, 0x2, 0x8, 0xD, 0x4, 0x3, 0xE, 0x0}
+
+// hdhrDeviceID derives a stable device ID from an API token. The low nibble
+// is set so the ID passes the HDHomeRun checksum; clients discard IDs that
+// don't.
+func hdhrDeviceID(token string) uint32 {
+	sum := sha256.Sum256([]byte("hdhr:" + token))
+	id := binary.BigEndian.Uint32(sum[:4]) &^ 0xF
+	var check uint32
+	for shift := 28; shift >= 4; shift -= 4 {
+		n := (id >> uint(shift)) & 0xF
+		if shift%8 == 4 {
+			check ^= hdhrDeviceIDChecksum[n]
+		} else {
+			check ^= n
+		}
+	}
+	return id | check
+}
+
+// hdhrDeviceIDValid reports whether id passes the HDHomeRun checksum.
+func hdhrDeviceIDValid(id uint32) bool {
+	var check uint32
+	for shift := 28; shift >= 0; shift -= 4 {
+		n := (id >> uint(shift)) & 0xF
+		if shift%8 == 4 {
+			check ^= hdhrDeviceIDChecksum[n]
+		} else {
+			check ^= n
+		}
+	}
+	return check == 0
+}
+
+// hdhrBaseURL is the URL clients prefix device paths with. HDHR_BASE_URL
+// overrides the scheme and host taken from the request.
+func hdhrBaseURL(r *http.Request, token string, rootMode bool) string {
+	base := strings.TrimRight(getEnv("HDHR_BASE_URL", ""), "/")
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	if rootMode {
+		return base
+	}
+	return base + "/hdhr/" + token
+}
+
+// ---- handlers: /hdhr/{token}/... and root device paths ------------------------
+
+// handleHDHR serves /hdhr/{token}/{path}.
+func (s *server) handleHDHR(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/hdhr/")
+	token, path, _ := strings.Cut(rest, "/")
+	if token == "" || path == "" {
+		writeError(w, http.StatusNotFound, "not_found", "Expected /hdhr/:token/discover.json")
+		return
+	}
+	s.serveHDHR(w, r, token, path, false)
+}
+
+// handleHDHRRoot serves the root device paths with the HDHR_TOKEN credential.
+func (s *server) handleHDHRRoot(w http.ResponseWriter, r *http.Request) {
+	token := os.Getenv("HDHR_TOKEN")
+	if token == "" {
+		writeError(w, http.StatusNotFound, "not_found", "HDHomeRun emulation is not enabled on this server")
+		return
+	}
+	s.serveHDHR(w, r, token, strings.TrimPrefix(r.URL.Path, "/"), true)
+}
+
+func (s *server) serveHDHR(w http.ResponseWriter, r *http.Request, token, path string, rootMode bool) {
+	switch {
+	case path == "lineup.post":
+		if r.Method != http.MethodPost && r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "POST required")
+			return
+		}
+		// Channels come from the database; there is nothing to scan.
+		w.WriteHeader(http.StatusOK)
+		return
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
+		return
+	}
+
+	subscriberID, maxStreams, rp, ok := s.hdhrAuth(w, r, token)
+	if !ok {
+		return
+	}
+	base := hdhrBaseURL(r, token, rootMode)
+
+	switch {
+	case path == "discover.json":
+		writeJSON(w, http.StatusOK, hdhrDiscoverFor(token, base, maxStreams))
+	case path == "lineup.json":
+		s.hdhrLineup(w, r, subscriberID, rp, base)
+	case path == "lineup_status.json":
+		writeJSON(w, http.StatusOK, hdhrLineupStatus{
+			ScanInProgress: 0,
+			ScanPossible:   0,
+			Source:         "Cable",
+			SourceList:     []string{"Cable"},
+		})
+	case strings.HasPrefix(path, "auto/v"):
+		s.hdhrTune(w, r, subscriberID, maxStreams, rp, strings.TrimPrefix(path, "auto/v"))
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "Unknown HDHomeRun path")
+	}
+}
+
+// hdhrAuth validates the API token and loads the primary profile's
+// restrictions. The viewing schedule is not applied here so media servers can
+// still list the device and lineup outside viewing hours; hdhrTune checks it.
+func (s *server) hdhrAuth(w http.ResponseWriter, r *http.Request, token string) (subscriberID string, maxStreams int, rp *profileRestrictions, ok bool) {
+	subscriberID, plan, err := s.validateXtreamCreds(r, token)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid API token")
+		return "", 0, nil, false
+	}
+	profileID, err := resolveProfile(r.Context(), s.db, subscriberID, "", "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return "", 0, nil, false
+	}
+	if profileID != "" {
+		rp, err = loadProfileRestrictions(r.Context(), s.db, subscriberID, profileID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+			return "", 0, nil, false
+		}
+	}
+	return subscriberID, maxConcurrentStreamsForPlan(plan), rp, true
+}
+
+// hdhrDiscoverFor builds discover.json for a token's device.
+func hdhrDiscoverFor(token, base string, tuners int) hdhrDiscover {
+	return hdhrDiscover{
+		FriendlyName:    getEnv("HDHR_FRIENDLY_NAME", "Roost"),
+		Manufacturer:    "Silicondust",
+		ModelNumber:     "HDTC-2US",
+		FirmwareName:    "hdhomeruntc_atsc",
+		FirmwareVersion: "20200101",
+		DeviceID:        fmt.Sprintf("%08X", hdhrDeviceID(token)),
+		BaseURL:         base,
+		LineupURL:       base + "/lineup.json",
+		TunerCount:      tuners,
+	}
+}
+
+func (s *server) hdhrLineup(w http.ResponseWriter, r *http.Request, subscriberID string, rp *profileRestrictions, base string) {
+	args := []interface{}{}
+	where := "c.is_active = true"
+	if clause := rp.channelCategoryClause(&args); clause != "" {
+		where += " AND " + clause
+	}
+	rows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT c.sort_order, c.name
+		FROM channels c
+		WHERE %s
+		ORDER BY c.sort_order ASC
+	`, where), args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch channels")
+		return
+	}
+	defer rows.Close()
+
+	lineup := []hdhrLineupEntry{}
+	for rows.Next() {
+		var number int
+		var name string
+		if err := rows.Scan(&number, &name); err != nil {
+			continue
+		}
+		guide := strconv.Itoa(number)
+		lineup = append(lineup, hdhrLineupEntry{
+			GuideNumber: guide,
+			GuideName:   name,
+			URL:         base + "/auto/v" + guide,
+		})
+	}
+
+	playouts, err := s.familyPlayouts(r.Context(), subscriberID, rp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch playout channels")
+		return
+	}
+	for _, c := range playouts {
+		guide := strconv.Itoa(c.Number)
+		lineup = append(lineup, hdhrLineupEntry{
+			GuideNumber: guide,
+			GuideName:   c.Name,
+			URL:         base + "/auto/v" + guide,
+		})
+	}
+	writeJSON(w, http.StatusOK, lineup)
+}
+
+// writeHDHRError writes a tuner error the way an HDHomeRun device does: an
+// HTTP status plus the device error code in X-HDHomeRun-Error.
+func writeHDHRError(w http.ResponseWriter, status int, deviceErr string) {
+	w.Header().Set("X-HDHomeRun-Error", deviceErr)
+	http.Error(w, deviceErr, status)
+}
+
+// ---- handler: GET /auto/v{channel} -----------------------------------------
+
+func (s *server) hdhrTune(w http.ResponseWriter, r *http.Request, subscriberID string, maxStreams int, rp *profileRestrictions, guide string) {
+	number, err := strconv.Atoi(guide)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_channel", "Channel must be a guide number")
+		return
+	}
+	if number >= playoutNumberBase {
+		s.hdhrTunePlayout(w, r, subscriberID, maxStreams, rp, number)
+		return
+	}
+
+	var channelID, slug string
+	err = s.db.QueryRowContext(r.Context(), `
+		SELECT id, slug FROM channels WHERE sort_order = $1 AND is_active = true
+	`, number).Scan(&channelID, &slug)
+	if err == sql.ErrNoRows {
+		writeHDHRError(w, http.StatusNotFound, "805 Channel Not Found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	}
+
+	if !rp.viewingAllowed(time.Now()) {
+		writeParentalBlocked(w, rp, "time")
+		return
+	}
+	if reason, err := s.channelBlockedReason(r.Context(), rp, channelID, time.Now()); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	} else if reason != "" {
+		writeParentalBlocked(w, rp, reason)
+		return
+	}
+
+	dir := filepath.Join(getEnv("SEGMENT_DIR", "/var/roost/segments"), slug)
+	src := hlsDir{dir: dir, playlistPath: hlsPlaylistPath(dir), slug: slug, ingestURL: strings.TrimSuffix(getEnv("INGEST_URL", ""), "/")}
+	if src.playlistPath == "" && src.ingestURL == "" {
+		writeHDHRError(w, http.StatusServiceUnavailable, "807 No Video Data")
+		return
+	}
+
+	s.hdhrServe(w, r, subscriberID, maxStreams, slug, src)
+}
+
+// hdhrTunePlayout streams one of the family's playout channels from the
+// channel service.
+func (s *server) hdhrTunePlayout(w http.ResponseWriter, r *http.Request, subscriberID string, maxStreams int, rp *profileRestrictions, number int) {
+	c, err := s.familyPlayoutByNumber(r.Context(), subscriberID, number)
+	if err == sql.ErrNoRows {
+		writeHDHRError(w, http.StatusNotFound, "805 Channel Not Found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	}
+	if reason := playoutBlockedReason(rp, time.Now()); reason != "" {
+		writeParentalBlocked(w, rp, reason)
+		return
+	}
+	s.hdhrServe(w, r, subscriberID, maxStreams, c.slug(), hlsPlayout{c: c, subscriberID: subscriberID})
+}
+
+// hdhrServe streams a channel's HLS output while holding one of the
+// subscriber's stream slots, or fails with "805 All Tuners In Use".
+func (s *server) hdhrServe(w http.ResponseWriter, r *http.Request, subscriberID string, maxStreams int, slug string, src hlsSource) {
+	allowed, active, err := s.rl.acquireStreamSlot(r.Context(), subscriberID, maxStreams)
+	if !allowed {
+		log.Printf("[owl_api] hdhr tune refused: channel=%s tuners=%d/%d", slug, active, maxStreams)
+		writeHDHRError(w, http.StatusServiceUnavailable, "805 All Tuners In Use")
+		return
+	}
+	if err == nil {
+		// closeStreamSlot outlives the request context, which the client
+		// cancels by hanging up.
+		defer s.rl.closeStreamSlot(r.Context(), subscriberID)
+	}
+
+	if d, ok := src.(hlsDir); ok && d.ingestURL != "" && r.Method != http.MethodHead {
+		ctx, stop := context.WithCancel(r.Context())
+		defer stop()
+		go d.reportDemand(ctx)
+		if d.playlistPath = awaitHLSPlaylist(ctx, d.dir); d.playlistPath == "" {
+			writeHDHRError(w, http.StatusServiceUnavailable, "807 No Video Data")
+			return
+		}
+		src = d
+	}
+
+	log.Printf("[owl_api] hdhr stream start: channel=%s", slug)
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	err = s.streamHLSAsTS(r, w, subscriberID, src)
+	log.Printf("[owl_api] hdhr stream end: channel=%s err=%v", slug, err)
+}
+
+// streamHLSAsTS copies a live HLS channel's segments to w as one continuous
+// MPEG-TS stream until the client disconnects, the playlist ends, or the
+// channel stops producing segments for hdhrStallTimeout.
+func (s *server) streamHLSAsTS(r *http.Request, w http.ResponseWriter, subscriberID string, src hlsSource) error {
+	ctx := r.Context()
+	rc := http.NewResponseController(w)
+	next := int64(-1)
+	lastProgress := time.Now()
+	lastRefresh := time.Now()
+
+	for {
+		wait := time.Second
+		data, err := src.readPlaylist(ctx)
+		if err == nil {
+			pl := parseHLSPlaylist(data)
+			if pl.TargetDuration > 0 {
+				wait = pl.TargetDuration / 2
+			}
+			if n := len(pl.Segments); n > 0 && (next < 0 || pl.Segments[n-1].Seq < next-1) {
+				// First read, or ingest restarted and the sequence reset.
+				next = pl.Segments[max(0, n-hdhrLiveEdge)].Seq
+			}
+			var key []byte
+			for _, seg := range pl.Segments {
+				if seg.Seq < next {
+					continue
+				}
+				if seg.Encrypted && key == nil {
+					if key, err = src.readKey(ctx); err != nil {
+						return fmt.Errorf("read key: %w", err)
+					}
+				}
+				payload, err := src.readSegment(ctx, seg, key)
+				next = seg.Seq + 1
+				if err != nil {
+					// Rotated out between the playlist read and now; the
+					// next segment follows on without a gap large enough to
+					// break the decoder.
+					continue
+				}
+				_ = rc.SetWriteDeadline(time.Now().Add(hdhrWriteTimeout))
+				if _, err := w.Write(payload); err != nil {
+					return err
+				}
+				_ = rc.Flush()
+				lastProgress = time.Now()
+			}
+			if pl.Ended {
+				return nil
+			}
+		}
+		if time.Since(lastProgress) > hdhrStallTimeout {
+			return errors.New("channel stalled")
+		}
+		if time.Since(lastRefresh) > hdhrSlotRefresh {
+			s.rl.refreshStreamSlot(ctx, subscriberID)
+			lastRefresh = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// ---- HLS sources -------------------------------------------------------------
+
+// hlsSource is where streamHLSAsTS reads a live channel from.
+type hlsSource interface {
+	readPlaylist(ctx context.Context) ([]byte, error)
+	// readKey returns the AES-128 key of an encrypted channel.
+	readKey(ctx context.Context) ([]byte, error)
+	// readSegment returns a segment's payload, decrypted with key when the
+	// segment is encrypted.
+	readSegment(ctx context.Context, seg hlsSegment, key []byte) ([]byte, error)
+}
+
+// hlsDir reads a channel's ingest output from its SEGMENT_DIR directory.
+// ingestURL is set when ingest runs channels on demand.
+type hlsDir struct {
+	dir          string
+	playlistPath string
+	slug         string
+	ingestURL    string
+}
+
+// reportDemand tells ingest the channel is being watched, now and every
+// hdhrDemandRefresh until ctx ends.
+func (d hlsDir) reportDemand(ctx context.Context) {
+	t := time.NewTicker(hdhrDemandRefresh)
+	defer t.Stop()
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			d.ingestURL+"/internal/channels/"+d.slug+"/demand", nil)
+		if err != nil {
+			return
+		}
+		if resp, err := ingestClient.Do(req); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[owl_api] hdhr ingest demand for %q: %v", d.slug, err)
+			}
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				log.Printf("[owl_api] hdhr ingest demand for %q: status %d", d.slug, resp.StatusCode)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (d hlsDir) readPlaylist(context.Context) ([]byte, error) { return os.ReadFile(d.playlistPath) }
+
+func (d hlsDir) readKey(context.Context) ([]byte, error) {
+	return os.ReadFile(filepath.Join(d.dir, "enc.key"))
+}
+
+func (d hlsDir) readSegment(_ context.Context, seg hlsSegment, key []byte) ([]byte, error) {
+	return readHLSSegment(d.dir, seg, key)
+}
+
+// ---- HLS media playlists ----------------------------------------------------
+
+type hlsSegment struct {
+	Seq       int64
+	URI       string
+	Encrypted bool
+	IV        []byte // nil = derive from Seq
+}
+
+type hlsPlaylist struct {
+	TargetDuration time.Duration
+	Segments       []hlsSegment
+	Ended          bool
+}
+
+// hlsPlaylistPath returns the channel's media playlist: stream.m3u8 for
+// passthrough channels, the first variant for transcoded ones (same choice
+// as the relay). Empty when the channel has no output.
+func hlsPlaylistPath(dir string) string {
+	for _, name := range []string{"stream.m3u8", "stream_0.m3u8"} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// awaitHLSPlaylist waits up to hdhrStartupWait for the channel in dir to
+// have a playlist updated within hdhrStallTimeout (a stopped pipeline leaves
+// its last playlist behind), and returns its path or "".
+func awaitHLSPlaylist(ctx context.Context, dir string) string {
+	deadline := time.Now().Add(hdhrStartupWait)
+	for {
+		if path := hlsPlaylistPath(dir); path != "" {
+			if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) < hdhrStallTimeout {
+				return path
+			}
+		}
+		if time.Now().After(deadline) {
+			return ""
+		}
+		select {
+		case <-ctx.Done():
+			return ""
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// parseHLSPlaylist reads the tags of a media playlist that matter for
+// remuxing: sequence numbers, AES-128 keys and the end marker.
+func parseHLSPlaylist(data []byte) hlsPlaylist {
+	var pl hlsPlaylist
+	var seq int64
+	var encrypted bool
+	var iv []byte
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			if n, err := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:")); err == nil {
+				pl.TargetDuration = time.Duration(n) * time.Second
+			}
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			if n, err := strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64); err == nil {
+				seq = n
+			}
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := strings.TrimPrefix(line, "#EXT-X-KEY:")
+			encrypted = hlsAttr(attrs, "METHOD") == "AES-128"
+			iv = nil
+			if v := hlsAttr(attrs, "IV"); len(v) > 2 && (strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X")) {
+				iv, _ = hex.DecodeString(v[2:])
+			}
+		case line == "#EXT-X-ENDLIST":
+			pl.Ended = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			pl.Segments = append(pl.Segments, hlsSegment{Seq: seq, URI: line, Encrypted: encrypted, IV: iv})
+			seq++
+		}
+	}
+	return pl
+}
+
+// hlsAttr returns an attribute from an HLS attribute list, unquoted.
+func hlsAttr(list, name string) string {
+	inQuote := false
+	start := 0
+	for i := 0; i <= len(list); i++ {
+		if i < len(list) && list[i] == '"' {
+			inQuote = !inQuote
+		}
+		if i < len(list) && (list[i] != ',' || inQuote) {
+			continue
+		}
+		k, v, _ := strings.Cut(list[start:i], "=")
+		if strings.TrimSpace(k) == name {
+			return strings.Trim(strings.TrimSpace(v), `"`)
+		}
+		start = i + 1
+	}
+	return ""
+}
+
+// readHLSSegment reads a segment file from the channel directory and, for
+// encrypted channels, decrypts it. Only the URI's base name is used so a
+// playlist can't point outside dir.
+func readHLSSegment(dir string, seg hlsSegment, key []byte) ([]byte, error) {
+	name, _, _ := strings.Cut(seg.URI, "?")
+	data, err := os.ReadFile(filepath.Join(dir, filepath.Base(name)))
+	if err != nil || !seg.Encrypted {
+		return data, err
+	}
+	iv := seg.IV
+	if len(iv) != aes.BlockSize {
+		// No IV attribute: the IV is the media sequence number (RFC 8216 §5.2).
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(seg.Seq))
+	}
+	return decryptHLSSegment(data, key, iv)
+}
+
+// decryptHLSSegment undoes AES-128-CBC with PKCS#7 padding.
+func decryptHLSSegment(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("segment is not block aligned")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errors.New("bad segment padding")
+	}
+	return out[:len(out)-pad], nil
+}
@@ -0,0 +1,206 @@
+// hdhomerun_discover.go — HDHomeRun UDP discovery responder.
+//
+// Plex, Jellyfin and Emby find tuners by broadcasting a discover request to
+// UDP port 65001. When HDHR_TOKEN is set, owl_api answers as a single tuner
+// device whose BaseURL serves the root device paths (hdhomerun.go).
+//
+// Packet format (libhdhomerun hdhomerun_pkt.h), all integers big-endian except
+// the CRC:
+//
+//	uint16 type | uint16 payload length | payload (TLVs) | uint32 CRC-32 (LE)
+//
+// Each TLV is a one-byte tag, a 1–2 byte length (7 bits per byte, high bit
+// set on the first byte when a second follows) and the value.
+//
+// Env:
+//
+//	HDHR_DISCOVERY_ADDR — listen address (default ":65001", "off" disables)
+//	HDHR_BASE_URL       — BaseURL to advertise (default http://{LAN IP}:{OWL_API_PORT})
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	hdhrTypeDiscoverReq = 0x0002
+	hdhrTypeDiscoverRpy = 0x0003
+
+	hdhrTagDeviceType = 0x01
+	hdhrTagDeviceID   = 0x02
+	hdhrTagLineupURL  = 0x27
+	hdhrTagBaseURL    = 0x2A
+
+	hdhrDeviceTypeTuner    = 0x00000001
+	hdhrDeviceTypeWildcard = 0xFFFFFFFF
+	hdhrDeviceIDWildcard   = 0xFFFFFFFF
+)
+
+// hdhrDiscoverRequest is what a client asked for in a discover packet.
+type hdhrDiscoverRequest struct {
+	DeviceType uint32
+	DeviceID   uint32
+}
+
+// runHDHRDiscovery answers discover requests until ctx is cancelled. It is a
+// no-op unless HDHR_TOKEN is set.
+func (s *server) runHDHRDiscovery(ctx context.Context) {
+	token := os.Getenv("HDHR_TOKEN")
+	addr := getEnv("HDHR_DISCOVERY_ADDR", ":65001")
+	if token == "" || addr == "off" {
+		return
+	}
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		log.Printf("[owl_api] hdhr discovery disabled: %v", err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	log.Printf("[owl_api] hdhr discovery listening on udp %s", addr)
+
+	deviceID := hdhrDeviceID(token)
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		req, err := parseHDHRDiscoverRequest(buf[:n])
+		if err != nil || !req.matches(deviceID) {
+			continue
+		}
+		base := hdhrDiscoveryBaseURL(src, s.port)
+		if _, err := conn.WriteTo(hdhrDiscoverReply(deviceID, base), src); err != nil {
+			log.Printf("[owl_api] hdhr discovery reply to %s: %v", src, err)
+		}
+	}
+}
+
+// matches reports whether a request is addressed to this device.
+func (q hdhrDiscoverRequest) matches(deviceID uint32) bool {
+	typeOK := q.DeviceType == hdhrDeviceTypeTuner || q.DeviceType == hdhrDeviceTypeWildcard
+	idOK := q.DeviceID == deviceID || q.DeviceID == hdhrDeviceIDWildcard
+	return typeOK && idOK
+}
+
+// hdhrDiscoveryBaseURL picks the BaseURL for a reply: HDHR_BASE_URL, or the
+// address of the local interface that routes back to the requester.
+func hdhrDiscoveryBaseURL(src net.Addr, port string) string {
+	if base := getEnv("HDHR_BASE_URL", ""); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	host := "127.0.0.1"
+	if c, err := net.Dial("udp4", src.String()); err == nil {
+		if la, ok := c.LocalAddr().(*net.UDPAddr); ok {
+			host = la.IP.String()
+		}
+		c.Close()
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(host, port))
+}
+
+// parseHDHRDiscoverRequest validates a discover request packet. Missing tags
+// default to wildcards.
+func parseHDHRDiscoverRequest(pkt []byte) (hdhrDiscoverRequest, error) {
+	q := hdhrDiscoverRequest{DeviceType: hdhrDeviceTypeWildcard, DeviceID: hdhrDeviceIDWildcard}
+	if len(pkt) < 8 {
+		return q, errors.New("short packet")
+	}
+	payloadLen := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if len(pkt) != 4+payloadLen+4 {
+		return q, errors.New("length mismatch")
+	}
+	body := pkt[:4+payloadLen]
+	if binary.LittleEndian.Uint32(pkt[4+payloadLen:]) != crc32.ChecksumIEEE(body) {
+		return q, errors.New("bad crc")
+	}
+	if binary.BigEndian.Uint16(pkt[0:2]) != hdhrTypeDiscoverReq {
+		return q, errors.New("not a discover request")
+	}
+
+	payload := pkt[4 : 4+payloadLen]
+	for len(payload) > 0 {
+		tag, value, rest, err := hdhrReadTLV(payload)
+		if err != nil {
+			return q, err
+		}
+		payload = rest
+		if len(value) != 4 {
+			continue
+		}
+		switch tag {
+		case hdhrTagDeviceType:
+			q.DeviceType = binary.BigEndian.Uint32(value)
+		case hdhrTagDeviceID:
+			q.DeviceID = binary.BigEndian.Uint32(value)
+		}
+	}
+	return q, nil
+}
+
+// hdhrReadTLV splits the first TLV off b.
+func hdhrReadTLV(b []byte) (tag byte, value, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("truncated tlv")
+	}
+	tag = b[0]
+	n := int(b[1] & 0x7F)
+	hdr := 2
+	if b[1]&0x80 != 0 {
+		if len(b) < 3 {
+			return 0, nil, nil, errors.New("truncated tlv")
+		}
+		n |= int(b[2]) << 7
+		hdr = 3
+	}
+	if len(b) < hdr+n {
+		return 0, nil, nil, errors.New("truncated tlv")
+	}
+	return tag, b[hdr : hdr+n], b[hdr+n:], nil
+}
+
+// hdhrAppendTLV appends one TLV to b.
+func hdhrAppendTLV(b []byte, tag byte, value []byte) []byte {
+	b = append(b, tag)
+	if n := len(value); n < 0x80 {
+		b = append(b, byte(n))
+	} else {
+		b = append(b, byte(n&0x7F)|0x80, byte(n>>7))
+	}
+	return append(b, value...)
+}
+
+// hdhrPacket frames a payload with the type/length header and CRC.
+func hdhrPacket(typ uint16, payload []byte) []byte {
+	pkt := make([]byte, 4, 4+len(payload)+4)
+	binary.BigEndian.PutUint16(pkt[0:2], typ)
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(payload)))
+	pkt = append(pkt, payload...)
+	return binary.LittleEndian.AppendUint32(pkt, crc32.ChecksumIEEE(pkt))
+}
+
+// hdhrDiscoverReply builds the reply advertising this device. TunerCount is
+// omitted: it depends on the token's plan and clients read it from
+// discover.json.
+func hdhrDiscoverReply(deviceID uint32, base string) []byte {
+	var payload []byte
+	payload = hdhrAppendTLV(payload, hdhrTagDeviceType, binary.BigEndian.AppendUint32(nil, hdhrDeviceTypeTuner))
+	payload = hdhrAppendTLV(payload, hdhrTagDeviceID, binary.BigEndian.AppendUint32(nil, deviceID))
+	payload = hdhrAppendTLV(payload, hdhrTagBaseURL, []byte(base))
+	payload = hdhrAppendTLV(payload, hdhrTagLineupURL, []byte(base+"/lineup.json"))
+	return hdhrPacket(hdhrTypeDiscoverRpy, payload)
+}
@@ -0,0 +1,323 @@
+// hdhomerun_test.go — Unit tests for HDHomeRun tuner emulation: device IDs,
+// the UDP discovery packet format, HLS playlist parsing and segment
+// decryption, the token-less root paths, stream slot release and on-demand
+// ingest.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHDHRDeviceIDPassesChecksum(t *testing.T) {
+	for _, token := range []string{"roost_a", "roost_b", "roost_0123456789abcdef", ""} {
+		id := hdhrDeviceID(token)
+		if !hdhrDeviceIDValid(id) {
+			t.Errorf("hdhrDeviceID(%q) = %08X fails checksum", token, id)
+		}
+		if id != hdhrDeviceID(token) {
+			t.Errorf("hdhrDeviceID(%q) not stable", token)
+		}
+	}
+	// Any other check nibble must be rejected.
+	id := hdhrDeviceID("roost_a")
+	if hdhrDeviceIDValid(id ^ 0x1) {
+		t.Errorf("%08X accepted with a wrong check nibble", id^0x1)
+	}
+}
+
+// discoverRequest builds a client discover packet the way libhdhomerun does.
+func discoverRequest(deviceType, deviceID uint32) []byte {
+	var payload []byte
+	payload = hdhrAppendTLV(payload, hdhrTagDeviceType, binary.BigEndian.AppendUint32(nil, deviceType))
+	payload = hdhrAppendTLV(payload, hdhrTagDeviceID, binary.BigEndian.AppendUint32(nil, deviceID))
+	return hdhrPacket(hdhrTypeDiscoverReq, payload)
+}
+
+func TestParseHDHRDiscoverRequest(t *testing.T) {
+	const id = 0x12345678
+	q, err := parseHDHRDiscoverRequest(discoverRequest(hdhrDeviceTypeTuner, hdhrDeviceIDWildcard))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !q.matches(id) {
+		t.Error("wildcard tuner request should match")
+	}
+
+	q, _ = parseHDHRDiscoverRequest(discoverRequest(hdhrDeviceTypeTuner, 0x87654321))
+	if q.matches(id) {
+		t.Error("request for another device ID should not match")
+	}
+	q, _ = parseHDHRDiscoverRequest(discoverRequest(0x00000005, hdhrDeviceIDWildcard))
+	if q.matches(id) {
+		t.Error("request for another device type should not match")
+	}
+
+	pkt := discoverRequest(hdhrDeviceTypeTuner, hdhrDeviceIDWildcard)
+	pkt[len(pkt)-1] ^= 0xFF
+	if _, err := parseHDHRDiscoverRequest(pkt); err == nil {
+		t.Error("expected error for bad CRC")
+	}
+	if _, err := parseHDHRDiscoverRequest(hdhrDiscoverReply(id, "http://x")); err == nil {
+		t.Error("expected error for a reply packet")
+	}
+}
+
+func TestHDHRDiscoverReplyShape(t *testing.T) {
+	const id = 0x1234567A
+	pkt := hdhrDiscoverReply(id, "http://192.168.1.5:8091")
+
+	if typ := binary.BigEndian.Uint16(pkt[0:2]); typ != hdhrTypeDiscoverRpy {
+		t.Fatalf("type = %#x, want %#x", typ, hdhrTypeDiscoverRpy)
+	}
+	n := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if len(pkt) != 4+n+4 {
+		t.Fatalf("length header %d does not match packet size %d", n, len(pkt))
+	}
+	if got, want := binary.LittleEndian.Uint32(pkt[4+n:]), crc32.ChecksumIEEE(pkt[:4+n]); got != want {
+		t.Fatalf("crc = %08X, want %08X", got, want)
+	}
+
+	tags := map[byte][]byte{}
+	payload := pkt[4 : 4+n]
+	for len(payload) > 0 {
+		tag, value, rest, err := hdhrReadTLV(payload)
+		if err != nil {
+			t.Fatalf("tlv: %v", err)
+		}
+		tags[tag] = value
+		payload = rest
+	}
+	if got := binary.BigEndian.Uint32(tags[hdhrTagDeviceID]); got != id {
+		t.Errorf("device id = %08X, want %08X", got, id)
+	}
+	if got := binary.BigEndian.Uint32(tags[hdhrTagDeviceType]); got != hdhrDeviceTypeTuner {
+		t.Errorf("device type = %d, want tuner", got)
+	}
+	if string(tags[hdhrTagBaseURL]) != "http://192.168.1.5:8091" {
+		t.Errorf("base url = %q", tags[hdhrTagBaseURL])
+	}
+	if string(tags[hdhrTagLineupURL]) != "http://192.168.1.5:8091/lineup.json" {
+		t.Errorf("lineup url = %q", tags[hdhrTagLineupURL])
+	}
+}
+
+func TestHDHRTLVLongLength(t *testing.T) {
+	value := bytes.Repeat([]byte("a"), 300)
+	b := hdhrAppendTLV(nil, hdhrTagBaseURL, value)
+	tag, got, rest, err := hdhrReadTLV(b)
+	if err != nil || tag != hdhrTagBaseURL || !bytes.Equal(got, value) || len(rest) != 0 {
+		t.Fatalf("round trip failed: tag=%#x len=%d rest=%d err=%v", tag, len(got), len(rest), err)
+	}
+}
+
+func TestParseHLSPlaylist(t *testing.T) {
+	pl := parseHLSPlaylist([]byte(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:120
+#EXTINF:4.000000,
+stream120.ts
+#EXT-X-KEY:METHOD=AES-128,URI="/stream/news/key",IV=0x000102030405060708090a0b0c0d0e0f
+#EXTINF:4.000000,
+stream121.ts
+#EXT-X-KEY:METHOD=AES-128,URI="/stream/news/key"
+#EXTINF:4.000000,
+stream122.ts
+`))
+	if pl.TargetDuration != 4*time.Second {
+		t.Errorf("target duration = %v, want 4s", pl.TargetDuration)
+	}
+	if pl.Ended {
+		t.Error("live playlist reported as ended")
+	}
+	if len(pl.Segments) != 3 {
+		t.Fatalf("segments = %d, want 3", len(pl.Segments))
+	}
+	if s := pl.Segments[0]; s.Seq != 120 || s.URI != "stream120.ts" || s.Encrypted {
+		t.Errorf("segment 0 = %+v", s)
+	}
+	if s := pl.Segments[1]; s.Seq != 121 || !s.Encrypted || len(s.IV) != 16 || s.IV[15] != 0x0f {
+		t.Errorf("segment 1 = %+v", s)
+	}
+	if s := pl.Segments[2]; s.Seq != 122 || !s.Encrypted || s.IV != nil {
+		t.Errorf("segment 2 should use the sequence-number IV: %+v", s)
+	}
+}
+
+func TestHLSAttrQuotedComma(t *testing.T) {
+	list := `METHOD=AES-128,URI="/key?a=1,b=2",IV=0x01`
+	if got := hlsAttr(list, "URI"); got != "/key?a=1,b=2" {
+		t.Errorf("URI = %q", got)
+	}
+	if got := hlsAttr(list, "IV"); got != "0x01" {
+		t.Errorf("IV = %q", got)
+	}
+	if got := hlsAttr(list, "KEYFORMAT"); got != "" {
+		t.Errorf("missing attr = %q", got)
+	}
+}
+
+func TestReadHLSSegmentDecrypts(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{0x42}, 16)
+	plain := bytes.Repeat([]byte{0x47, 0x00, 0x11, 0x10}, 47) // 188 bytes, one TS packet
+
+	// Encrypt with PKCS#7 padding and the sequence-number IV, as FFmpeg does.
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint64(iv[8:], 7)
+	block, _ := aes.NewCipher(key)
+	enc := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(enc, padded)
+	if err := os.WriteFile(filepath.Join(dir, "seg7.ts"), enc, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readHLSSegment(dir, hlsSegment{Seq: 7, URI: "seg7.ts", Encrypted: true}, key)
+	if err != nil {
+		t.Fatalf("readHLSSegment: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Error("decrypted segment does not match plaintext")
+	}
+
+	// A playlist URI must not escape the channel directory.
+	if _, err := readHLSSegment(dir, hlsSegment{URI: "../../etc/passwd"}, nil); err == nil {
+		t.Error("expected error for path outside channel dir")
+	}
+}
+
+func TestHDHRRootDisabledWithoutToken(t *testing.T) {
+	t.Setenv("HDHR_TOKEN", "")
+	srv := &server{db: nil, rl: newRateLimiter(nil)}
+	rec := httptest.NewRecorder()
+	srv.handleHDHRRoot(rec, httptest.NewRequest(http.MethodGet, "/discover.json", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHDHRRejectsNonRoostToken(t *testing.T) {
+	srv := &server{db: nil, rl: newRateLimiter(nil)}
+	rec := httptest.NewRecorder()
+	srv.handleHDHR(rec, httptest.NewRequest(http.MethodGet, "/hdhr/badtoken/lineup.json", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+}
+
+func TestHDHRBaseURL(t *testing.T) {
+	t.Setenv("HDHR_BASE_URL", "")
+	r := httptest.NewRequest(http.MethodGet, "/hdhr/roost_x/discover.json", nil)
+	r.Host = "roost.lan:8091"
+	if got := hdhrBaseURL(r, "roost_x", false); got != "http://roost.lan:8091/hdhr/roost_x" {
+		t.Errorf("path-token base = %q", got)
+	}
+	if got := hdhrBaseURL(r, "roost_x", true); got != "http://roost.lan:8091" {
+		t.Errorf("root base = %q", got)
+	}
+	t.Setenv("HDHR_BASE_URL", "http://10.0.0.2:8091/")
+	if got := hdhrBaseURL(r, "roost_x", true); got != "http://10.0.0.2:8091" {
+		t.Errorf("override base = %q", got)
+	}
+}
+
+// A client hanging up mid-stream cancels the request context; the tuner slot
+// must still be released.
+func TestHDHRSlotReleasedOnDisconnect(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "seg1.ts"), bytes.Repeat([]byte{0x47}, 188), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	playlist := filepath.Join(dir, "stream.m3u8")
+	live := "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:1\n#EXTINF:2.0,\nseg1.ts\n"
+	if err := os.WriteFile(playlist, []byte(live), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMockStore()
+	srv := &server{rl: newRateLimiter(store)}
+	const key = "owl_api:stream_count:sub-1"
+	slots := func() string {
+		v, _ := store.Get(context.Background(), key)
+		return v
+	}
+
+	ctx, hangUp := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/auto/v7", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		srv.hdhrServe(httptest.NewRecorder(), r, "sub-1", 1, "ch", hlsDir{dir: dir, playlistPath: playlist})
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for slots() != "1" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := slots(); got != "1" {
+		t.Fatalf("slots while streaming = %q, want 1", got)
+	}
+
+	// The single tuner is busy.
+	busy := httptest.NewRecorder()
+	srv.hdhrServe(busy, httptest.NewRequest(http.MethodGet, "/auto/v7", nil), "sub-1", 1, "ch", hlsDir{dir: dir, playlistPath: playlist})
+	if busy.Code != http.StatusServiceUnavailable || busy.Header().Get("X-HDHomeRun-Error") != "805 All Tuners In Use" {
+		t.Errorf("second tune: status %d, error %q; want 503 805", busy.Code, busy.Header().Get("X-HDHomeRun-Error"))
+	}
+
+	hangUp()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("stream did not end after the client hung up")
+	}
+	if got := slots(); got != "0" {
+		t.Errorf("slots after disconnect = %q, want 0", got)
+	}
+}
+
+// With on-demand ingest, a tune of an idle channel reports demand and waits
+// for the pipeline's first playlist.
+func TestHDHRTuneReportsIngestDemand(t *testing.T) {
+	dir := t.TempDir()
+	demanded := make(chan string, 4)
+	ingest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		demanded <- r.Method + " " + r.URL.Path
+		// The pipeline starts: one segment, then the end of the stream.
+		_ = os.WriteFile(filepath.Join(dir, "seg1.ts"), bytes.Repeat([]byte{0x47}, 188), 0o644)
+		live := "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:1\n#EXTINF:2.0,\nseg1.ts\n#EXT-X-ENDLIST\n"
+		_ = os.WriteFile(filepath.Join(dir, "stream.m3u8"), []byte(live), 0o644)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ingest.Close()
+
+	srv := &server{rl: newRateLimiter(newMockStore())}
+	rec := httptest.NewRecorder()
+	srv.hdhrServe(rec, httptest.NewRequest(http.MethodGet, "/auto/v7", nil), "sub-1", 1, "ch",
+		hlsDir{dir: dir, slug: "ch", ingestURL: ingest.URL})
+
+	select {
+	case got := <-demanded:
+		if got != "POST /internal/channels/ch/demand" {
+			t.Errorf("demand request = %q", got)
+		}
+	default:
+		t.Fatal("no demand reported to ingest")
+	}
+	if rec.Code != http.StatusOK || rec.Body.Len() != 188 {
+		t.Errorf("tune: status %d, %d bytes; want 200 and one segment", rec.Code, rec.Body.Len())
+	}
+}
@@ -30,6 +30,10 @@
 //
 // Security:
 //   - requireSession middleware validates the session token before this handler runs.
+//   - The session profile's parental controls apply: blocked categories hide
+//     matching genres, and kids profiles and profiles with an age rating limit
+//     get no movies/series — catalog_items carries no rating, so they cannot
+//     be held to the limit.
 //   - Source URLs (m3u8, direct file, R2 keys) are NEVER returned.
 //   - Stream URLs are HMAC-signed with CF_STREAM_SIGNING_KEY, 15-min TTL.
 //   - Tables that don't exist yet (music_albums, podcasts, games) degrade gracefully.
@@ -68,6 +72,11 @@ func (s *server) handleLibrary(w http.ResponseWriter, r *http.Request) {
 
 	// ── Parse query params ────────────────────────────────────────────────────
 
+	rp, ok := s.sessionRestrictions(w, r)
+	if !ok {
+		return
+	}
+
 	typeFilter := strings.ToLower(r.URL.Query().Get("type"))
 	query := strings.TrimSpace(r.URL.Query().Get("q"))
 
@@ -112,7 +121,7 @@ func (s *server) handleLibrary(w http.ResponseWriter, r *http.Request) {
 
 	var items []LibraryItem
 	for _, t := range types {
-		fetched, err := s.fetchLibraryItems(r, t, query, perTypeBudget, offset)
+		fetched, err := s.fetchLibraryItems(r, rp, t, query, perTypeBudget, offset)
 		if err != nil {
 			// Log but continue — partial results are better than a full failure.
 			fmt.Printf("[library] fetch %s error: %v\n", t, err)
@@ -130,7 +139,7 @@ func (s *server) handleLibrary(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ── Type counts (Owl sidebar badges) ─────────────────────────────────────
-	typeCounts := s.fetchTypeCounts(r)
+	typeCounts := s.fetchTypeCounts(r, rp)
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"items":       items,
@@ -143,18 +152,24 @@ func (s *server) handleLibrary(w http.ResponseWriter, r *http.Request) {
 }
 
 // fetchLibraryItems dispatches to the appropriate type-specific fetch function.
-func (s *server) fetchLibraryItems(r *http.Request, contentType, query string, limit, offset int) ([]LibraryItem, error) {
+func (s *server) fetchLibraryItems(r *http.Request, rp *profileRestrictions, contentType, query string, limit, offset int) ([]LibraryItem, error) {
 	switch contentType {
 	case "movie":
-		return s.libFetchMovies(r, query, limit, offset)
+		if rp.ratingLimited() {
+			return nil, nil
+		}
+		return s.libFetchMovies(r, rp, query, limit, offset)
 	case "series":
-		return s.libFetchSeries(r, query, limit, offset)
+		if rp.ratingLimited() {
+			return nil, nil
+		}
+		return s.libFetchSeries(r, rp, query, limit, offset)
 	case "music":
-		return s.libFetchMusic(r, query, limit, offset)
+		return s.libFetchMusic(r, rp, query, limit, offset)
 	case "podcast":
 		return s.libFetchPodcasts(r, query, limit, offset)
 	case "game":
-		return s.libFetchGames(r, query, limit, offset)
+		return s.libFetchGames(r, rp, query, limit, offset)
 	default:
 		return nil, nil
 	}
@@ -162,7 +177,7 @@ func (s *server) fetchLibraryItems(r *http.Request, contentType, query string, l
 
 // ── Movies ────────────────────────────────────────────────────────────────────
 
-func (s *server) libFetchMovies(r *http.Request, query string, limit, offset int) ([]LibraryItem, error) {
+func (s *server) libFetchMovies(r *http.Request, rp *profileRestrictions, query string, limit, offset int) ([]LibraryItem, error) {
 	args := []interface{}{}
 	conds := []string{"is_active = true", "content_type = 'movie'"}
 
@@ -170,6 +185,9 @@ func (s *server) libFetchMovies(r *http.Request, query string, limit, offset int
 		args = append(args, "%"+strings.ToLower(query)+"%")
 		conds = append(conds, fmt.Sprintf("LOWER(title) LIKE $%d", len(args)))
 	}
+	if clause := rp.genreClause("genres", &args); clause != "" {
+		conds = append(conds, clause)
+	}
 	args = append(args, limit, offset)
 	lIdx, oIdx := len(args)-1, len(args)
 
@@ -217,7 +235,7 @@ func (s *server) libFetchMovies(r *http.Request, query string, limit, offset int
 
 // ── Series ────────────────────────────────────────────────────────────────────
 
-func (s *server) libFetchSeries(r *http.Request, query string, limit, offset int) ([]LibraryItem, error) {
+func (s *server) libFetchSeries(r *http.Request, rp *profileRestrictions, query string, limit, offset int) ([]LibraryItem, error) {
 	args := []interface{}{}
 	conds := []string{"is_active = true", "content_type = 'series'"}
 
@@ -225,6 +243,9 @@ func (s *server) libFetchSeries(r *http.Request, query string, limit, offset int
 		args = append(args, "%"+strings.ToLower(query)+"%")
 		conds = append(conds, fmt.Sprintf("LOWER(title) LIKE $%d", len(args)))
 	}
+	if clause := rp.genreClause("genres", &args); clause != "" {
+		conds = append(conds, clause)
+	}
 	args = append(args, limit, offset)
 	lIdx, oIdx := len(args)-1, len(args)
 
@@ -272,7 +293,7 @@ func (s *server) libFetchSeries(r *http.Request, query string, limit, offset int
 
 // ── Music albums ──────────────────────────────────────────────────────────────
 
-func (s *server) libFetchMusic(r *http.Request, query string, limit, offset int) ([]LibraryItem, error) {
+func (s *server) libFetchMusic(r *http.Request, rp *profileRestrictions, query string, limit, offset int) ([]LibraryItem, error) {
 	args := []interface{}{}
 	conds := []string{"is_active = true"}
 
@@ -281,6 +302,9 @@ func (s *server) libFetchMusic(r *http.Request, query string, limit, offset int)
 		conds = append(conds, fmt.Sprintf(
 			"(LOWER(album_title) LIKE $%d OR LOWER(artist) LIKE $%d)", len(args), len(args)))
 	}
+	if clause := rp.genreClause("genre", &args); clause != "" {
+		conds = append(conds, clause)
+	}
 	args = append(args, limit, offset)
 	lIdx, oIdx := len(args)-1, len(args)
 
@@ -373,7 +397,7 @@ func (s *server) libFetchPodcasts(r *http.Request, query string, limit, offset i
 
 // ── Games ─────────────────────────────────────────────────────────────────────
 
-func (s *server) libFetchGames(r *http.Request, query string, limit, offset int) ([]LibraryItem, error) {
+func (s *server) libFetchGames(r *http.Request, rp *profileRestrictions, query string, limit, offset int) ([]LibraryItem, error) {
 	args := []interface{}{}
 	conds := []string{"is_active = true"}
 
@@ -381,6 +405,9 @@ func (s *server) libFetchGames(r *http.Request, query string, limit, offset int)
 		args = append(args, "%"+strings.ToLower(query)+"%")
 		conds = append(conds, fmt.Sprintf("LOWER(title) LIKE $%d", len(args)))
 	}
+	if clause := rp.genreClause("genre", &args); clause != "" {
+		conds = append(conds, clause)
+	}
 	args = append(args, limit, offset)
 	lIdx, oIdx := len(args)-1, len(args)
 
@@ -429,8 +456,9 @@ func (s *server) libFetchGames(r *http.Request, query string, limit, offset int)
 
 // fetchTypeCounts returns the active item count for each content type.
 // Used by Owl to display badge counts in the library sidebar.
-// Tables that don't exist yet return 0 gracefully.
-func (s *server) fetchTypeCounts(r *http.Request) map[string]int {
+// Tables that don't exist yet return 0 gracefully. Counts ignore genre filters;
+// movie/series are reported as 0 for rating-limited profiles, which never list them.
+func (s *server) fetchTypeCounts(r *http.Request, rp *profileRestrictions) map[string]int {
 	counts := map[string]int{
 		"movie":   0,
 		"series":  0,
@@ -442,9 +470,9 @@ func (s *server) fetchTypeCounts(r *http.Request) map[string]int {
 	// Movies + series from catalog_items.
 	catRows, err := s.db.QueryContext(r.Context(), `
 		SELECT content_type, COUNT(*) FROM catalog_items
-		WHERE is_active = true AND content_type IN ('movie', 'series')
+		WHERE is_active = true AND content_type IN ('movie', 'series') AND NOT $1
 		GROUP BY content_type
-	`)
+	`, rp.ratingLimited())
 	if err == nil {
 		defer catRows.Close()
 		for catRows.Next() {
@@ -258,3 +258,119 @@ func TestVODPlaceholderResponse(t *testing.T) {
 		t.Error("VOD placeholder should mention VOD in message")
 	}
 }
+
+// ---- parental controls ------------------------------------------------------
+
+// TestProfileRatingAllowed verifies TV and movie ratings are held to the profile limit.
+func TestProfileRatingAllowed(t *testing.T) {
+	pg := &profileRestrictions{RatingLimit: "TV-PG"}
+	kids := &profileRestrictions{IsKids: true}
+	cases := []struct {
+		p      *profileRestrictions
+		rating string
+		want   bool
+	}{
+		{nil, "TV-MA", true},
+		{pg, "TV-PG", true},
+		{pg, "TV-14", false},
+		{pg, "pg-13", false}, // movie rating, normalised to TV-14
+		{pg, "R", false},
+		{pg, "PG", true},
+		{pg, "", true}, // unrated is allowed for non-kids profiles
+		{kids, "TV-Y7", true},
+		{kids, "G", true},
+		{kids, "TV-PG", false},
+		{kids, "", false},
+	}
+	for _, tc := range cases {
+		if got := tc.p.ratingAllowed(tc.rating); got != tc.want {
+			t.Errorf("ratingAllowed(%q) with %+v = %v, want %v", tc.rating, tc.p, got, tc.want)
+		}
+	}
+}
+
+// TestProfileRatingClause verifies the SQL filter agrees with ratingAllowed.
+func TestProfileRatingClause(t *testing.T) {
+	var args []interface{}
+	if clause := (&profileRestrictions{}).ratingClause("ep.rating", &args); clause != "" || len(args) != 0 {
+		t.Errorf("unrestricted profile: clause=%q args=%v, want none", clause, args)
+	}
+
+	args = []interface{}{"from", "to"}
+	clause := (&profileRestrictions{RatingLimit: "TV-14"}).ratingClause("ep.rating", &args)
+	if clause != "upper(coalesce(ep.rating, '')) <> ALL($3)" {
+		t.Errorf("TV-14 clause = %q", clause)
+	}
+	if got := fmt.Sprint(args[2]); !strings.Contains(got, "NC-17") || !strings.Contains(got, "TV-MA") || strings.Contains(got, "TV-14") {
+		t.Errorf("TV-14 blocked ratings = %s", got)
+	}
+
+	args = nil
+	clause = (&profileRestrictions{IsKids: true}).ratingClause("rating", &args)
+	if clause != "upper(coalesce(rating, '')) = ANY($1)" {
+		t.Errorf("kids clause = %q", clause)
+	}
+}
+
+// TestProfileRatingLimited verifies which profiles lose unrated library titles.
+func TestProfileRatingLimited(t *testing.T) {
+	cases := []struct {
+		p    *profileRestrictions
+		want bool
+	}{
+		{nil, false},
+		{&profileRestrictions{}, false},
+		{&profileRestrictions{BlockedCategories: []string{"horror"}}, false},
+		{&profileRestrictions{RatingLimit: "TV-14"}, true},
+		{&profileRestrictions{IsKids: true}, true},
+	}
+	for _, tc := range cases {
+		if got := tc.p.ratingLimited(); got != tc.want {
+			t.Errorf("ratingLimited() with %+v = %v, want %v", tc.p, got, tc.want)
+		}
+	}
+}
+
+// TestProfileCategoryBlocked verifies category matching is case-insensitive and nil-safe.
+func TestProfileCategoryBlocked(t *testing.T) {
+	p := &profileRestrictions{BlockedCategories: []string{"horror", "news"}}
+	if !p.categoryBlocked("Action", " Horror ") {
+		t.Error("Horror should be blocked")
+	}
+	if p.categoryBlocked("Sports", "") {
+		t.Error("Sports should not be blocked")
+	}
+	var none *profileRestrictions
+	if none.categoryBlocked("horror") || !none.viewingAllowed(time.Now()) {
+		t.Error("nil restrictions must allow everything")
+	}
+	if got := blockedReason(p, []string{"News"}, "TV-MA", true); got != "category" {
+		t.Errorf("blockedReason = %q, want category", got)
+	}
+}
+
+// TestParentalBlockedEnvelope verifies the 403 body clients use to prompt for a PIN.
+func TestParentalBlockedEnvelope(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeParentalBlocked(w, &profileRestrictions{ProfileID: "p1"}, "rating")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+	var resp map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp["error"] != "parental_blocked" || resp["reason"] != "rating" || resp["profile_id"] != "p1" {
+		t.Errorf("unexpected body: %v", resp)
+	}
+}
+
+// TestTokenizePlaylist verifies AntBox capture playlists carry the session
+// token on segment URIs only.
+func TestTokenizePlaylist(t *testing.T) {
+	in := "#EXTM3U\n#EXT-X-TARGETDURATION:12\n#EXTINF:6.000,\n1-1.ts\n#EXT-X-ENDLIST\n"
+	want := "#EXTM3U\n#EXT-X-TARGETDURATION:12\n#EXTINF:6.000,\n1-1.ts?token=a%2Bb\n#EXT-X-ENDLIST\n"
+	if got := string(tokenizePlaylist([]byte(in), "a+b")); got != want {
+		t.Errorf("tokenizePlaylist =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,477 @@
+// parental.go — Per-profile parental controls for Owl content endpoints.
+//
+// Every Owl session is bound to one subscriber profile (owl_sessions.profile_id).
+// Content endpoints load that profile's restrictions and apply them:
+//
+//   - viewing_schedule: outside the allowed hours every content endpoint
+//     returns 403 parental_blocked (reason "time").
+//   - age_rating_limit / is_kids_profile: listings hide programmes and titles
+//     above the limit; kids profiles only see TV-Y, TV-Y7 and TV-G.
+//   - blocked_categories: channel categories (id, slug or name), EPG
+//     categories and VOD/library genres, case-insensitive.
+//
+// Listings are filtered silently. Requesting a single blocked item (stream,
+// VOD title) returns 403 with error "parental_blocked" and a reason, so the
+// client can prompt for a PIN and switch profile via POST /owl/v1/profile.
+// The rules themselves live in the billing service (handlers_parental.go).
+//
+// Endpoints:
+//
+//	GET  /owl/v1/profile — active profile + the subscriber's profiles (for a picker)
+//	POST /owl/v1/profile — {"profile_id": "...", "pin": "1234"} switch the session's profile
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/unyeco/roost/services/billing"
+)
+
+var (
+	errProfileNotFound = errors.New("profile not found or inactive")
+	errPINRequired     = errors.New("profile requires a PIN")
+	errInvalidPIN      = errors.New("incorrect PIN")
+)
+
+// contentRatings maps every rating owl_api understands to its TV equivalent,
+// so movie ratings in vod_catalog are held to the same profile limit.
+var contentRatings = map[string]string{
+	"TV-Y": "TV-Y", "TV-Y7": "TV-Y7", "TV-G": "TV-G",
+	"TV-PG": "TV-PG", "TV-14": "TV-14", "TV-MA": "TV-MA",
+	"G": "TV-G", "PG": "TV-PG", "PG-13": "TV-14", "R": "TV-MA", "NC-17": "TV-MA",
+}
+
+// profileRestrictions is the parental-control context of a session's profile.
+// A nil *profileRestrictions means unrestricted; all methods accept nil.
+type profileRestrictions struct {
+	ProfileID         string
+	RatingLimit       string
+	IsKids            bool
+	BlockedCategories []string // lowercased
+	Schedule          *string  // viewing_schedule JSON, nil = any time
+}
+
+// loadProfileRestrictions reads an active profile's parental controls.
+func loadProfileRestrictions(ctx context.Context, db *sql.DB, subscriberID, profileID string) (*profileRestrictions, error) {
+	p := &profileRestrictions{ProfileID: profileID}
+	var blockedJSON string
+	var schedule sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT coalesce(age_rating_limit, ''), is_kids_profile,
+		       coalesce(blocked_categories::text, '[]'), viewing_schedule::text
+		FROM subscriber_profiles
+		WHERE id = $1 AND subscriber_id = $2 AND is_active = TRUE
+	`, profileID, subscriberID).Scan(&p.RatingLimit, &p.IsKids, &blockedJSON, &schedule)
+	if err == sql.ErrNoRows {
+		return nil, errProfileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var blocked []string
+	_ = json.Unmarshal([]byte(blockedJSON), &blocked)
+	for _, c := range blocked {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			p.BlockedCategories = append(p.BlockedCategories, c)
+		}
+	}
+	if schedule.Valid {
+		p.Schedule = &schedule.String
+	}
+	return p, nil
+}
+
+// viewingAllowed reports whether the profile may watch anything at t.
+func (p *profileRestrictions) viewingAllowed(t time.Time) bool {
+	return p == nil || billing.IsViewingAllowed(p.Schedule, t)
+}
+
+// ratingAllowed reports whether content with the given rating may be shown.
+func (p *profileRestrictions) ratingAllowed(rating string) bool {
+	if p == nil {
+		return true
+	}
+	rating = strings.ToUpper(strings.TrimSpace(rating))
+	if tv, ok := contentRatings[rating]; ok {
+		rating = tv
+	}
+	return billing.IsContentAllowedByRating(p.RatingLimit, rating, p.IsKids)
+}
+
+// ratingLimited reports whether the profile restricts ratings at all. Content
+// that carries no rating (library movies and series) is hidden from such
+// profiles, since it cannot be held to the limit.
+func (p *profileRestrictions) ratingLimited() bool {
+	return p != nil && (p.IsKids || p.RatingLimit != "")
+}
+
+// categoryBlocked reports whether any of the given category ids, slugs,
+// names or genres is blocked for the profile.
+func (p *profileRestrictions) categoryBlocked(categories ...string) bool {
+	if p == nil {
+		return false
+	}
+	for _, c := range categories {
+		c = strings.ToLower(strings.TrimSpace(c))
+		for _, b := range p.BlockedCategories {
+			if c != "" && c == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ratingClause returns a WHERE condition limiting the rating column col to
+// what the profile may watch, appending its argument to args. Returns "" when
+// the profile has no rating restriction.
+func (p *profileRestrictions) ratingClause(col string, args *[]interface{}) string {
+	if !p.ratingLimited() {
+		return ""
+	}
+	var allowed, blocked []string
+	for r := range contentRatings {
+		if p.ratingAllowed(r) {
+			allowed = append(allowed, r)
+		} else {
+			blocked = append(blocked, r)
+		}
+	}
+	sort.Strings(allowed)
+	sort.Strings(blocked)
+	expr := fmt.Sprintf("upper(coalesce(%s, ''))", col)
+	if p.IsKids {
+		// Unrated content is hidden from kids profiles.
+		*args = append(*args, pq.Array(allowed))
+		return fmt.Sprintf("%s = ANY($%d)", expr, len(*args))
+	}
+	if len(blocked) == 0 {
+		return ""
+	}
+	*args = append(*args, pq.Array(blocked))
+	return fmt.Sprintf("%s <> ALL($%d)", expr, len(*args))
+}
+
+// channelCategoryClause returns a WHERE condition excluding channels (table
+// alias c) whose category id, slug or name is blocked. "" = nothing blocked.
+func (p *profileRestrictions) channelCategoryClause(args *[]interface{}) string {
+	if p == nil || len(p.BlockedCategories) == 0 {
+		return ""
+	}
+	*args = append(*args, pq.Array(p.BlockedCategories))
+	n := len(*args)
+	return fmt.Sprintf(`NOT (lower(coalesce(c.category, '')) = ANY($%[1]d)
+		OR coalesce(c.category_id::text, '') = ANY($%[1]d)
+		OR EXISTS (SELECT 1 FROM channel_categories cc
+		           WHERE cc.id = c.category_id AND lower(cc.slug) = ANY($%[1]d)))`, n)
+}
+
+// genreClause returns a WHERE condition excluding rows whose comma-separated
+// genre column col contains a blocked category. "" = nothing blocked.
+func (p *profileRestrictions) genreClause(col string, args *[]interface{}) string {
+	if p == nil || len(p.BlockedCategories) == 0 {
+		return ""
+	}
+	*args = append(*args, pq.Array(p.BlockedCategories))
+	return fmt.Sprintf(`NOT (regexp_split_to_array(lower(coalesce(%s, '')), '\s*,\s*') && $%d::text[])`,
+		col, len(*args))
+}
+
+// programCategoryClause returns a WHERE condition excluding programmes whose
+// category column col is blocked. "" = nothing blocked.
+func (p *profileRestrictions) programCategoryClause(col string, args *[]interface{}) string {
+	if p == nil || len(p.BlockedCategories) == 0 {
+		return ""
+	}
+	*args = append(*args, pq.Array(p.BlockedCategories))
+	return fmt.Sprintf("lower(coalesce(%s, '')) <> ALL($%d)", col, len(*args))
+}
+
+// programClauses returns the WHERE conditions for a programs (alias ep)
+// listing joined to channels (alias c).
+func (p *profileRestrictions) programClauses(args *[]interface{}) []string {
+	var clauses []string
+	for _, clause := range []string{
+		p.channelCategoryClause(args),
+		p.ratingClause("ep.rating", args),
+		p.programCategoryClause("ep.genre", args),
+	} {
+		if clause != "" {
+			clauses = append(clauses, clause)
+		}
+	}
+	return clauses
+}
+
+// channelBlockedReason reports why the profile may not watch a channel at
+// time at: "category" when the channel or the programme airing then is in a
+// blocked category, "rating" when that programme is above the profile's
+// rating, "" when allowed.
+func (s *server) channelBlockedReason(ctx context.Context, p *profileRestrictions, channelID string, at time.Time) (string, error) {
+	if p == nil {
+		return "", nil
+	}
+	var categoryID, category, categorySlug string
+	var progRating, progCategory sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT coalesce(c.category_id::text, ''), coalesce(c.category, ''), coalesce(cc.slug, ''),
+		       ep.rating, ep.category
+		FROM channels c
+		LEFT JOIN channel_categories cc ON cc.id = c.category_id
+		LEFT JOIN LATERAL (
+			SELECT coalesce(rating, '') AS rating, coalesce(genre, '') AS category
+			FROM programs
+			WHERE channel_id = c.id AND start_time <= $2 AND end_time > $2
+			ORDER BY start_time DESC
+			LIMIT 1
+		) ep ON true
+		WHERE c.id = $1
+	`, channelID, at).Scan(&categoryID, &category, &categorySlug, &progRating, &progCategory)
+	if err != nil {
+		return "", err
+	}
+	return blockedReason(p, []string{categoryID, category, categorySlug, progCategory.String},
+		progRating.String, progRating.Valid), nil
+}
+
+// channelCategoryBlocked reports whether a channel's category is blocked,
+// regardless of what is airing on it.
+func (s *server) channelCategoryBlocked(ctx context.Context, p *profileRestrictions, channelID string) (bool, error) {
+	args := []interface{}{channelID}
+	clause := p.channelCategoryClause(&args)
+	if clause == "" {
+		return false, nil
+	}
+	var blocked bool
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(
+		`SELECT NOT (%s) FROM channels c WHERE c.id = $1`, clause), args...).Scan(&blocked)
+	return blocked, err
+}
+
+// blockedReason applies the category and rating rules to one item. rated is
+// false when there is nothing to rate (a channel with no current programme).
+func blockedReason(p *profileRestrictions, categories []string, rating string, rated bool) string {
+	if p.categoryBlocked(categories...) {
+		return "category"
+	}
+	if rated && !p.ratingAllowed(rating) {
+		return "rating"
+	}
+	return ""
+}
+
+// sessionRestrictions loads the restrictions for the session's profile and
+// rejects the request when the profile is outside its viewing hours. When ok
+// is false a response has already been written.
+func (s *server) sessionRestrictions(w http.ResponseWriter, r *http.Request) (p *profileRestrictions, ok bool) {
+	profileID := r.Header.Get("X-Profile-ID")
+	if profileID == "" {
+		return nil, true
+	}
+	return s.checkProfile(w, r, r.Header.Get("X-Subscriber-ID"), profileID)
+}
+
+// viewingProfile returns the profile a request's watch progress, history and
+// favourites belong to: the session's profile, or the primary profile for
+// sessions created before the subscriber had profiles. It returns "" when
+// the subscriber has no profile at all.
+func (s *server) viewingProfile(r *http.Request) (string, error) {
+	if id := r.Header.Get("X-Profile-ID"); id != "" {
+		return id, nil
+	}
+	return resolveProfile(r.Context(), s.db, r.Header.Get("X-Subscriber-ID"), "", "")
+}
+
+// checkProfile is sessionRestrictions for an explicit subscriber + profile.
+func (s *server) checkProfile(w http.ResponseWriter, r *http.Request, subscriberID, profileID string) (*profileRestrictions, bool) {
+	p, err := loadProfileRestrictions(r.Context(), s.db, subscriberID, profileID)
+	if err == errProfileNotFound {
+		writeError(w, http.StatusForbidden, "profile_unavailable",
+			"The active profile was removed or deactivated. Select a profile via POST /owl/v1/profile.")
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return nil, false
+	}
+	if !p.viewingAllowed(time.Now()) {
+		writeParentalBlocked(w, p, "time")
+		return nil, false
+	}
+	return p, true
+}
+
+// writeParentalBlocked writes the 403 returned for content the profile may not
+// watch. reason is "time", "rating" or "category" (same as billing's
+// parental-check endpoint).
+func writeParentalBlocked(w http.ResponseWriter, p *profileRestrictions, reason string) {
+	msg := "This content is not available on the current profile."
+	if reason == "time" {
+		msg = "Viewing is not allowed on the current profile at this time."
+	}
+	writeJSON(w, http.StatusForbidden, map[string]interface{}{
+		"error":      "parental_blocked",
+		"message":    msg,
+		"reason":     reason,
+		"profile_id": p.ProfileID,
+		"request_id": newRequestID(),
+	})
+}
+
+// resolveProfile returns the id of the subscriber's active profile named by
+// profileID (the primary profile when empty), verifying pin when the profile
+// has one. The primary profile is not PIN-checked when selected by default:
+// the caller has already presented the account owner's API token.
+func resolveProfile(ctx context.Context, db *sql.DB, subscriberID, profileID, pin string) (string, error) {
+	var id string
+	var pinHash sql.NullString
+	var err error
+	if profileID == "" {
+		err = db.QueryRowContext(ctx, `
+			SELECT id FROM subscriber_profiles
+			WHERE subscriber_id = $1 AND is_primary = TRUE AND is_active = TRUE
+		`, subscriberID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return "", nil // no profiles yet — session stays unrestricted
+		}
+		return id, err
+	}
+	err = db.QueryRowContext(ctx, `
+		SELECT id, pin_hash FROM subscriber_profiles
+		WHERE id::text = $1 AND subscriber_id = $2 AND is_active = TRUE
+	`, profileID, subscriberID).Scan(&id, &pinHash)
+	if err == sql.ErrNoRows {
+		return "", errProfileNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := checkPIN(pinHash, pin); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// checkPIN verifies pin against a profile's bcrypt pin_hash (NULL = no PIN).
+func checkPIN(pinHash sql.NullString, pin string) error {
+	if !pinHash.Valid || pinHash.String == "" {
+		return nil
+	}
+	if pin == "" {
+		return errPINRequired
+	}
+	if bcrypt.CompareHashAndPassword([]byte(pinHash.String), []byte(pin)) != nil {
+		return errInvalidPIN
+	}
+	return nil
+}
+
+// writeProfileError maps resolveProfile errors to API errors.
+func writeProfileError(w http.ResponseWriter, err error) {
+	switch err {
+	case errProfileNotFound:
+		writeError(w, http.StatusNotFound, "profile_not_found", "Profile not found or inactive")
+	case errPINRequired:
+		writeError(w, http.StatusForbidden, "pin_required", "This profile requires a PIN")
+	case errInvalidPIN:
+		writeError(w, http.StatusForbidden, "invalid_pin", "Incorrect PIN")
+	default:
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+	}
+}
+
+// ---- handler: GET|POST /owl/v1/profile --------------------------------------
+
+type profileSwitchRequest struct {
+	ProfileID string `json:"profile_id"`
+	PIN       string `json:"pin"`
+}
+
+func (s *server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	subscriberID := r.Header.Get("X-Subscriber-ID")
+	switch r.Method {
+	case http.MethodGet:
+		s.listProfiles(w, r, subscriberID, r.Header.Get("X-Profile-ID"))
+	case http.MethodPost:
+		var req profileSwitchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_json", "Request body must be valid JSON")
+			return
+		}
+		if req.ProfileID == "" {
+			writeError(w, http.StatusBadRequest, "missing_profile_id", "profile_id field required")
+			return
+		}
+		profileID, err := resolveProfile(r.Context(), s.db, subscriberID, req.ProfileID, req.PIN)
+		if err != nil {
+			writeProfileError(w, err)
+			return
+		}
+		if _, err := s.db.ExecContext(r.Context(), `
+			UPDATE owl_sessions SET profile_id = $1 WHERE session_token = $2
+		`, profileID, extractSessionToken(r)); err != nil {
+			writeError(w, http.StatusInternalServerError, "session_error", "Failed to switch profile")
+			return
+		}
+		s.listProfiles(w, r, subscriberID, profileID)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET or POST required")
+	}
+}
+
+// listProfiles writes the session's active profile and the subscriber's profiles.
+func (s *server) listProfiles(w http.ResponseWriter, r *http.Request, subscriberID, active string) {
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT id, name, is_primary, is_kids_profile, coalesce(age_rating_limit, ''),
+		       coalesce(pin_hash, '') != '', coalesce(avatar_url, avatar_preset, '')
+		FROM subscriber_profiles
+		WHERE subscriber_id = $1 AND is_active = TRUE
+		ORDER BY is_primary DESC, created_at ASC
+	`, subscriberID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch profiles")
+		return
+	}
+	defer rows.Close()
+
+	type profile struct {
+		ID             string `json:"id"`
+		Name           string `json:"name"`
+		IsPrimary      bool   `json:"is_primary"`
+		IsKids         bool   `json:"is_kids_profile"`
+		AgeRatingLimit string `json:"age_rating_limit,omitempty"`
+		HasPIN         bool   `json:"has_pin"`
+		Avatar         string `json:"avatar,omitempty"`
+	}
+	profiles := []profile{}
+	for rows.Next() {
+		var p profile
+		if err := rows.Scan(&p.ID, &p.Name, &p.IsPrimary, &p.IsKids, &p.AgeRatingLimit,
+			&p.HasPIN, &p.Avatar); err != nil {
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+
+	resp := map[string]interface{}{
+		"active_profile_id": active,
+		"profiles":          profiles,
+	}
+	if active != "" {
+		if p, err := loadProfileRestrictions(r.Context(), s.db, subscriberID, active); err == nil {
+			resp["viewing_allowed"] = p.viewingAllowed(time.Now())
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
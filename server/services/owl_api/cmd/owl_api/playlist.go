@@ -13,6 +13,8 @@
 //   - One #EXTINF entry per active channel with tvg-id, tvg-name, tvg-logo, group-title
 //   - Stream URL for each channel: /owl/v1/stream/{slug}?token=SESSION_TOKEN
 //     (pointing back to our relay — never the source URL)
+//   - The family's playout channels last, streamed from
+//     /owl/v1/playout/{id}/live.m3u8?token=SESSION_TOKEN (see playout.go)
 //
 // The stream URLs embed the session token as a query parameter so the player
 // authenticates automatically when it requests each channel stream. Session tokens
@@ -37,7 +39,8 @@ import (
 
 // handlePlaylistM3U8 generates and returns a full M3U8 channel playlist for the
 // authenticated session. The token is validated by the requireSession middleware
-// before this handler is called.
+// before this handler is called. Channels in a category the session's profile
+// blocks are left out.
 //
 // M3U8 format reference:
 //   https://github.com/iptv-org/iptv/blob/master/CONTRIBUTING.md#m3u8-format
@@ -47,6 +50,11 @@ func (s *server) handlePlaylistM3U8(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rp, ok := s.sessionRestrictions(w, r)
+	if !ok {
+		return
+	}
+
 	// Extract the session token — needed to embed in per-channel stream URLs
 	// so the player can authenticate each stream request automatically.
 	sessionToken := extractSessionToken(r)
@@ -56,15 +64,21 @@ func (s *server) handlePlaylistM3U8(w http.ResponseWriter, r *http.Request) {
 	// XMLTV EPG source URL (stub — included so players configure it now)
 	epgURL := fmt.Sprintf("%s/owl/xmltv.xml?token=%s", baseURL, url.QueryEscape(sessionToken))
 
-	// Fetch all active channels ordered by sort_order (stable channel order)
+	// Fetch all active channels ordered by sort_order (stable channel order),
+	// without the categories the profile blocks (as /owl/live).
+	args := []interface{}{}
+	where := "c.is_active = true"
+	if clause := rp.channelCategoryClause(&args); clause != "" {
+		where += " AND " + clause
+	}
 	rows, err := s.db.QueryContext(r.Context(), `
 		SELECT c.slug, c.name, coalesce(c.logo_url,''), coalesce(c.category,''),
 		       coalesce(c.country_code,''), coalesce(c.language_code,'en'),
 		       coalesce(c.epg_channel_id, c.slug), c.sort_order
 		FROM channels c
-		WHERE c.is_active = true
+		WHERE `+where+`
 		ORDER BY c.sort_order ASC, c.name ASC
-	`)
+	`, args...)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch channels")
 		return
@@ -130,11 +144,23 @@ func (s *server) handlePlaylistM3U8(w http.ResponseWriter, r *http.Request) {
 		sb.WriteString("\n")
 	}
 
+	playouts, err := s.familyPlayouts(r.Context(), r.Header.Get("X-Subscriber-ID"), rp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch playout channels")
+		return
+	}
+	for _, c := range playouts {
+		sb.WriteString(fmt.Sprintf("#EXTINF:-1 tvg-id=\"%s\" tvg-name=\"%s\" group-title=\"%s\",%s\n",
+			c.slug(), m3uEscape(c.Name), playoutCategory, c.Name))
+		sb.WriteString(playoutStreamURL(baseURL, c.ID, sessionToken))
+		sb.WriteString("\n")
+	}
+
 	// Write response
 	w.Header().Set("Content-Type", "application/x-mpegurl")
 	w.Header().Set("Content-Disposition", "attachment; filename=\"roost.m3u8\"")
 	w.Header().Set("Cache-Control", "private, max-age=3600")
-	w.Header().Set("X-Channel-Count", fmt.Sprintf("%d", len(channels)))
+	w.Header().Set("X-Channel-Count", fmt.Sprintf("%d", len(channels)+len(playouts)))
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, sb.String())
 }
@@ -0,0 +1,365 @@
+// playout.go — Family playout channels for the Roost Owl Addon API.
+//
+// The channel service (services/channel) plays each family playlist out as a
+// live HLS channel. owl_api lists the playlists of the subscriber's family
+// (channel_playlists.family_id = subscribers.sso_external_id) next to the
+// broadcast channels: in /owl/live, /owl/epg, the M3U playlist, the Xtream
+// live streams, EPG and XMLTV guide, and the HDHomeRun lineup. Playlists
+// with no items are left out.
+//
+// Endpoints:
+//
+//	GET /owl/v1/playout/:id/live.m3u8      — the playout's live playlist
+//	GET /owl/v1/playout/:id/live/:seq.ts   — one segment
+//	(/owl/playout/... aliases)
+//
+// A playout channel's slug is "playout-{playlist id}"; its Xtream stream_id
+// and HDHomeRun guide number is playoutNumberBase + channel_playlists.
+// stream_number (migration 101), above any channels.sort_order. Streams and
+// schedules are fetched from the channel service with the family headers it
+// requires, so the service itself stays internal. Its schedule covers the
+// next six hours, which bounds the guide for playout channels.
+//
+// Playlist items carry no rating: rating-limited and kids profiles get no
+// playout channels, as for library movies.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// playoutNumberBase offsets channel_playlists.stream_number into the
+	// Xtream stream_id / HDHomeRun guide number space.
+	playoutNumberBase = 100000
+	// playoutSlugPrefix prefixes the playlist id in a playout channel's slug.
+	playoutSlugPrefix = "playout-"
+	// playoutCategory is the category playout channels are listed under.
+	playoutCategory = "Family Channels"
+)
+
+// playoutSegmentName matches the segment names in the channel service's
+// live playlist.
+var playoutSegmentName = regexp.MustCompile(`^[0-9]{1,19}\.ts$`)
+
+// playoutClient talks to the channel service. Segments are proxied whole, so
+// the timeout covers a full segment transfer.
+var playoutClient = &http.Client{Timeout: 60 * time.Second}
+
+// channelServiceURL returns the base URL of the internal channel service.
+func channelServiceURL() string {
+	if v := os.Getenv("CHANNEL_SERVICE_URL"); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	return "http://localhost:8112"
+}
+
+// playoutChannel is one family playlist as a live channel.
+type playoutChannel struct {
+	ID       string // channel_playlists.id
+	FamilyID string
+	Name     string
+	Number   int // Xtream stream_id and HDHomeRun guide number
+}
+
+func (c playoutChannel) slug() string { return playoutSlugPrefix + c.ID }
+
+// playoutProgramme is one entry of the channel service's schedule.
+type playoutProgramme struct {
+	ContentID   string    `json:"content_id"`
+	ContentType string    `json:"content_type"`
+	Title       string    `json:"title"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+}
+
+// playoutBlockedReason is the parental_blocked reason for playing a playout
+// channel on the profile, or "" when it may play.
+func playoutBlockedReason(rp *profileRestrictions, at time.Time) string {
+	switch {
+	case rp.ratingLimited():
+		return "rating"
+	case !rp.viewingAllowed(at):
+		return "time"
+	}
+	return ""
+}
+
+const playoutSelect = `
+	SELECT cp.id, cp.family_id, cp.name, cp.stream_number
+	FROM channel_playlists cp
+	JOIN subscribers s ON s.sso_external_id = cp.family_id::text
+	WHERE s.id = $1 AND jsonb_array_length(cp.items) > 0`
+
+// familyPlayouts lists the playout channels of the subscriber's family that
+// the profile may see, in stream number order.
+func (s *server) familyPlayouts(ctx context.Context, subscriberID string, rp *profileRestrictions) ([]playoutChannel, error) {
+	if rp.ratingLimited() || subscriberID == "" {
+		return nil, nil
+	}
+	rows, err := s.db.QueryContext(ctx, playoutSelect+` ORDER BY cp.stream_number`, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []playoutChannel
+	for rows.Next() {
+		c, err := scanPlayout(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// familyPlayoutByID returns one of the family's playout channels by playlist
+// id, or sql.ErrNoRows.
+func (s *server) familyPlayoutByID(ctx context.Context, subscriberID, id string) (playoutChannel, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return playoutChannel{}, sql.ErrNoRows
+	}
+	return scanPlayout(s.db.QueryRowContext(ctx, playoutSelect+` AND cp.id = $2`, subscriberID, id))
+}
+
+// familyPlayoutByNumber returns one of the family's playout channels by its
+// stream number, or sql.ErrNoRows.
+func (s *server) familyPlayoutByNumber(ctx context.Context, subscriberID string, number int) (playoutChannel, error) {
+	return scanPlayout(s.db.QueryRowContext(ctx,
+		playoutSelect+` AND cp.stream_number = $2`, subscriberID, number-playoutNumberBase))
+}
+
+func scanPlayout(row interface{ Scan(...interface{}) error }) (playoutChannel, error) {
+	var c playoutChannel
+	if err := row.Scan(&c.ID, &c.FamilyID, &c.Name, &c.Number); err != nil {
+		return playoutChannel{}, err
+	}
+	c.Number += playoutNumberBase
+	return c, nil
+}
+
+// channelServiceGet requests a playout path from the channel service as the
+// subscriber's family.
+func channelServiceGet(ctx context.Context, c playoutChannel, subscriberID, file string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		channelServiceURL()+"/channel/playlists/"+c.ID+"/"+file, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Family-ID", c.FamilyID)
+	req.Header.Set("X-User-ID", subscriberID)
+	return playoutClient.Do(req)
+}
+
+// channelServiceRead reads a playout path whole; non-200 answers are errors.
+func channelServiceRead(ctx context.Context, c playoutChannel, subscriberID, file string) ([]byte, error) {
+	resp, err := channelServiceGet(ctx, c, subscriberID, file)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("channel service: %s %s", file, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// playoutSchedule fetches the programmes of a playout channel from now on.
+func playoutSchedule(ctx context.Context, c playoutChannel, subscriberID string) ([]playoutProgramme, error) {
+	data, err := channelServiceRead(ctx, c, subscriberID, "schedule")
+	if err != nil {
+		return nil, err
+	}
+	var progs []playoutProgramme
+	if err := json.Unmarshal(data, &progs); err != nil {
+		return nil, err
+	}
+	return progs, nil
+}
+
+// playoutNowPlaying returns the programme on air at now, if any.
+func playoutNowPlaying(progs []playoutProgramme, now time.Time) *playoutProgramme {
+	for i := range progs {
+		if !progs[i].StartTime.After(now) && progs[i].EndTime.After(now) {
+			return &progs[i]
+		}
+	}
+	return nil
+}
+
+// rebasePlaylist rewrites the segment URIs of a channel service live
+// playlist ("live/{seq}.ts") to prefix + "{seq}.ts".
+func rebasePlaylist(playlist []byte, prefix string) []byte {
+	var out strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(string(playlist), "\n"), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			line = prefix + path.Base(line)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return []byte(out.String())
+}
+
+// servePlayout proxies a playout's live playlist (file "live.m3u8", passed
+// through rewrite) or one of its segments ("live/{seq}.ts").
+func servePlayout(w http.ResponseWriter, r *http.Request, c playoutChannel, subscriberID, file string, rewrite func([]byte) []byte) {
+	if file == "live.m3u8" {
+		data, err := channelServiceRead(r.Context(), c, subscriberID, file)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, "channel_unavailable", "Playout channel unavailable")
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(rewrite(data))
+		return
+	}
+	if !playoutSegmentName.MatchString(strings.TrimPrefix(file, "live/")) {
+		writeError(w, http.StatusNotFound, "not_found", "")
+		return
+	}
+	resp, err := channelServiceGet(r.Context(), c, subscriberID, file)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "channel_unavailable", "Playout channel unavailable")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		writeError(w, http.StatusNotFound, "not_found", "")
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// ---- handler: GET /owl/v1/playout/:id/... ----------------------------------
+
+func (s *server) handlePlayout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
+		return
+	}
+	rp, ok := s.sessionRestrictions(w, r)
+	if !ok {
+		return
+	}
+
+	rest := r.URL.Path
+	for _, prefix := range []string{"/owl/v1/playout/", "/owl/playout/"} {
+		rest = strings.TrimPrefix(rest, prefix)
+	}
+	id, file, _ := strings.Cut(rest, "/")
+	if file != "live.m3u8" && !strings.HasPrefix(file, "live/") {
+		writeError(w, http.StatusNotFound, "not_found", "")
+		return
+	}
+	if reason := playoutBlockedReason(rp, time.Now()); reason != "" {
+		writeParentalBlocked(w, rp, reason)
+		return
+	}
+
+	subscriberID := r.Header.Get("X-Subscriber-ID")
+	c, err := s.familyPlayoutByID(r.Context(), subscriberID, id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "channel_unavailable", "No playout channel with that id")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	}
+	// Segment URIs stay relative (live/{seq}.ts); players don't send the
+	// Authorization header for them, so each carries the session token.
+	token := extractSessionToken(r)
+	servePlayout(w, r, c, subscriberID, file, func(data []byte) []byte {
+		return tokenizePlaylist(data, token)
+	})
+}
+
+// playoutStream answers /owl/stream/playout-{id} the way handleStream does
+// for broadcast channels. The URL carries the session token and lasts as long
+// as the session.
+func (s *server) playoutStream(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, id string) {
+	if reason := playoutBlockedReason(rp, time.Now()); reason != "" {
+		writeParentalBlocked(w, rp, reason)
+		return
+	}
+	c, err := s.familyPlayoutByID(r.Context(), r.Header.Get("X-Subscriber-ID"), id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "channel_unavailable",
+			"This channel is temporarily unavailable.")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	}
+	token := extractSessionToken(r)
+	var expiresAt time.Time
+	if err := s.db.QueryRowContext(r.Context(),
+		`SELECT expires_at FROM owl_sessions WHERE session_token = $1`, token,
+	).Scan(&expiresAt); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Session lookup failed")
+		return
+	}
+
+	log.Printf("[owl_api] stream request: channel=%s", c.slug())
+	baseURL := getEnv("ROOST_BASE_URL", "https://roost.unity.dev")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"stream_url": playoutStreamURL(baseURL, c.ID, token),
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+		"quality":    "auto",
+		"format":     "hls",
+		"drm":        nil,
+	})
+}
+
+// playoutStreamURL is the session-authenticated live playlist of a playout.
+func playoutStreamURL(baseURL, id, sessionToken string) string {
+	u := fmt.Sprintf("%s/owl/v1/playout/%s/live.m3u8", baseURL, id)
+	if sessionToken != "" {
+		u += "?token=" + url.QueryEscape(sessionToken)
+	}
+	return u
+}
+
+// ---- HDHomeRun source --------------------------------------------------------
+
+// hlsPlayout reads a playout channel from the channel service for
+// streamHLSAsTS. Playout segments are never encrypted.
+type hlsPlayout struct {
+	c            playoutChannel
+	subscriberID string
+}
+
+func (p hlsPlayout) readPlaylist(ctx context.Context) ([]byte, error) {
+	return channelServiceRead(ctx, p.c, p.subscriberID, "live.m3u8")
+}
+
+func (p hlsPlayout) readKey(context.Context) ([]byte, error) {
+	return nil, fmt.Errorf("playout %s: segments are not encrypted", p.c.ID)
+}
+
+func (p hlsPlayout) readSegment(ctx context.Context, seg hlsSegment, _ []byte) ([]byte, error) {
+	name := path.Base(seg.URI)
+	if !playoutSegmentName.MatchString(name) {
+		return nil, fmt.Errorf("playout %s: unexpected segment %q", p.c.ID, seg.URI)
+	}
+	return channelServiceRead(ctx, p.c, p.subscriberID, "live/"+name)
+}
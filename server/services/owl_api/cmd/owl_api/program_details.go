@@ -0,0 +1,157 @@
+// program_details.go — Episode, credit and airing fields of EPG programmes.
+// Shared by GET /owl/epg (JSON) and /xmltv.php (XMLTV), which read the
+// programs table the EPG sync writes from the source feed's <programme>
+// elements; see migrations 094 and 102.
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// programDetails holds the optional XMLTV fields of a programs row.
+type programDetails struct {
+	SubTitle        string          `json:"sub_title,omitempty"`
+	Categories      []string        `json:"categories"`
+	SeasonNumber    int             `json:"season_number,omitempty"`  // 1-based
+	EpisodeNumber   int             `json:"episode_number,omitempty"` // 1-based
+	EpisodeOnScreen string          `json:"episode_onscreen,omitempty"`
+	DDProgID        string          `json:"dd_progid,omitempty"`
+	Credits         []programCredit `json:"credits"`
+	AirDate         string          `json:"air_date,omitempty"` // "YYYY", "YYYY-MM" or "YYYY-MM-DD"
+	PreviouslyShown bool            `json:"previously_shown"`
+	IsPremiere      bool            `json:"is_premiere"`
+	StarRating      string          `json:"star_rating,omitempty"`
+}
+
+type programCredit struct {
+	Role      string `json:"role"` // director, actor, writer, presenter, …
+	Name      string `json:"name"`
+	Character string `json:"character,omitempty"`
+}
+
+// programDetailCols selects programDetails from programs (alias ep), in
+// the order of programDetailsScan.
+const programDetailCols = `coalesce(ep.sub_title, ''), ep.categories,
+	coalesce(ep.season_number, 0), coalesce(ep.episode_number, 0),
+	coalesce(ep.episode_onscreen, ''), coalesce(ep.dd_progid, ''), ep.credits,
+	coalesce(ep.air_date, ''), ep.previously_shown, ep.is_premiere,
+	coalesce(ep.star_rating, '')`
+
+// programDetailsScan returns Scan destinations for programDetailCols and a
+// func to call after a successful Scan.
+func programDetailsScan(d *programDetails) ([]interface{}, func()) {
+	var credits []byte
+	dest := []interface{}{
+		&d.SubTitle, pq.Array(&d.Categories), &d.SeasonNumber, &d.EpisodeNumber,
+		&d.EpisodeOnScreen, &d.DDProgID, &credits, &d.AirDate, &d.PreviouslyShown,
+		&d.IsPremiere, &d.StarRating,
+	}
+	return dest, func() {
+		if d.Categories == nil {
+			d.Categories = []string{}
+		}
+		d.Credits = []programCredit{}
+		_ = json.Unmarshal(credits, &d.Credits)
+	}
+}
+
+// ---- XMLTV ------------------------------------------------------------------
+
+type xmltvEpisodeNum struct {
+	System string `xml:"system,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type xmltvPerson struct {
+	XMLName xml.Name // director, actor, …
+	Role    string   `xml:"role,attr,omitempty"`
+	Name    string   `xml:",chardata"`
+}
+
+type xmltvCredits struct {
+	People []xmltvPerson
+}
+
+type xmltvStarRating struct {
+	Value string `xml:"value"`
+}
+
+// creditRoles is the DTD's order of <credits> children.
+var creditRoles = []string{
+	"director", "actor", "writer", "adapter", "producer",
+	"composer", "editor", "presenter", "commentator", "guest",
+}
+
+// applyXMLTVDetails fills p's optional elements from d.
+func applyXMLTVDetails(p *xmltvProgramme, d programDetails, lang string) {
+	if d.SubTitle != "" {
+		p.SubTitle = &xmltvText{Lang: lang, Value: d.SubTitle}
+	}
+	if len(d.Categories) > 0 {
+		p.Categories = p.Categories[:0]
+		for _, c := range d.Categories {
+			p.Categories = append(p.Categories, xmltvText{Lang: lang, Value: c})
+		}
+	}
+
+	rank := make(map[string]int, len(creditRoles))
+	for i, role := range creditRoles {
+		rank[role] = i
+	}
+	var people []xmltvPerson
+	for _, c := range d.Credits {
+		if _, ok := rank[c.Role]; !ok || c.Name == "" {
+			continue
+		}
+		person := xmltvPerson{XMLName: xml.Name{Local: c.Role}, Name: c.Name}
+		if c.Role == "actor" {
+			person.Role = c.Character
+		}
+		people = append(people, person)
+	}
+	if len(people) > 0 {
+		sort.SliceStable(people, func(i, j int) bool {
+			return rank[people[i].XMLName.Local] < rank[people[j].XMLName.Local]
+		})
+		p.Credits = &xmltvCredits{People: people}
+	}
+	p.Date = strings.ReplaceAll(d.AirDate, "-", "")
+
+	if d.SeasonNumber > 0 || d.EpisodeNumber > 0 {
+		part := func(n int) string {
+			if n <= 0 {
+				return ""
+			}
+			return fmt.Sprint(n - 1)
+		}
+		p.EpisodeNums = append(p.EpisodeNums, xmltvEpisodeNum{
+			System: "xmltv_ns", Value: part(d.SeasonNumber) + "." + part(d.EpisodeNumber) + ".",
+		})
+	}
+	onScreen := d.EpisodeOnScreen
+	if onScreen == "" && d.SeasonNumber > 0 && d.EpisodeNumber > 0 {
+		onScreen = fmt.Sprintf("S%02dE%02d", d.SeasonNumber, d.EpisodeNumber)
+	}
+	if onScreen != "" {
+		p.EpisodeNums = append(p.EpisodeNums, xmltvEpisodeNum{System: "onscreen", Value: onScreen})
+	}
+	if d.DDProgID != "" {
+		p.EpisodeNums = append(p.EpisodeNums, xmltvEpisodeNum{System: "dd_progid", Value: d.DDProgID})
+	}
+
+	if d.PreviouslyShown {
+		p.PreviouslyShown = &struct{}{}
+	}
+	if d.IsPremiere {
+		p.Premiere = &struct{}{}
+	}
+	if d.StarRating != "" {
+		p.StarRating = &xmltvStarRating{Value: d.StarRating}
+	}
+}
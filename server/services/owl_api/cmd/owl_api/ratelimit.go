@@ -9,9 +9,11 @@
 //     the full token in Redis; provides sufficient uniqueness for rate limiting).
 //
 //  2. Concurrent stream limit: max 5 simultaneous stream requests per subscriber.
-//     Applied specifically to /owl/stream/ and /live/ endpoints.
-//     Redis key: "owl_api:streams:{subscriber_id}" — a sorted set with stream slot
-//     timestamps; stale entries (>30min) are pruned on every check.
+//     Applied specifically to /owl/stream/ and /live/ endpoints, and held for
+//     the whole stream by HDHomeRun tunes (/auto/v{channel}, hdhomerun.go).
+//     Redis key: "owl_api:stream_count:{subscriber_id}" — a counter taken with
+//     INCR and given back with DECR; it expires 35min after the first slot so
+//     slots of crashed clients are dropped.
 //
 // Graceful degradation: when the Store is nil (no Redis configured, dev/test
 // environments), all limits are disabled — requests pass through. This matches
@@ -38,6 +40,8 @@ import (
 type RateLimitStore interface {
 	// Incr atomically increments a counter key and returns the new count.
 	Incr(ctx context.Context, key string) (int64, error)
+	// Decr atomically decrements a counter key and returns the new count.
+	Decr(ctx context.Context, key string) (int64, error)
 	// Expire sets the TTL on a key (only when count == 1, to avoid resetting window).
 	Expire(ctx context.Context, key string, ttl time.Duration) error
 	// Get retrieves a string value by key.
@@ -137,7 +141,9 @@ func (rl *rateLimiter) apiRateLimit(next http.HandlerFunc) http.HandlerFunc {
 
 // ---- Concurrent stream limit -----------------------------------------------
 
-//lint:ignore U1000 plan-based stream slot enforcement — called by admission control (planned)
+// streamSlotReleaseTimeout bounds the Redis call that gives a slot back.
+const streamSlotReleaseTimeout = 2 * time.Second
+
 // maxConcurrentStreamsForPlan returns the max concurrent stream slots from plan limits.
 // This defers to the existing planLimits function to keep them in sync.
 func maxConcurrentStreamsForPlan(plan string) int {
@@ -145,69 +151,61 @@ func maxConcurrentStreamsForPlan(plan string) int {
 	return maxStreams
 }
 
-// checkStreamSlot verifies whether a subscriber can open another concurrent stream.
-// Uses a Redis sorted set "owl_api:streams:{subscriberID}" where each member is
-// a stream slot key and the score is the Unix timestamp it was opened.
-// Stale slots (>30min) are pruned on every check to handle client crashes.
+// acquireStreamSlot takes one of the subscriber's concurrent stream slots.
+// The counter "owl_api:stream_count:{subscriberID}" is incremented first and
+// the result compared to maxStreams, so two simultaneous requests cannot both
+// take the last slot; a request over the limit gives its increment back.
+// The counter expires after 35 minutes (slightly longer than max stream URL
+// TTL of 15min, accounting for session renewal) so a crashed client's slots
+// are dropped. Release a granted slot with closeStreamSlot.
 //
 // Returns (allowed bool, activeCount int, err error).
-// On Redis error, returns (true, 0, err) — fail open.
-func (rl *rateLimiter) checkStreamSlot(ctx context.Context, subscriberID string, maxStreams int) (bool, int, error) {
+// On Redis error, returns (true, 0, err) — fail open, with no slot taken.
+func (rl *rateLimiter) acquireStreamSlot(ctx context.Context, subscriberID string, maxStreams int) (bool, int, error) {
 	if rl.store == nil {
 		return true, 0, nil
 	}
-
-	// We simulate a sorted set via individual keys with TTL.
-	// Each open stream: key "owl_api:streams:{subID}:{slotID}" with 30-min TTL.
-	// Count active slots by scanning for keys matching the pattern.
-	// NOTE: In production with go-redis, prefer ZADD/ZCOUNT for O(log N) operations.
-	// This implementation uses a counter key for simplicity and correctness.
 	counterKey := fmt.Sprintf("owl_api:stream_count:%s", subscriberID)
-
-	countStr, err := rl.store.Get(ctx, counterKey)
+	count, err := rl.store.Incr(ctx, counterKey)
 	if err != nil {
-		// Key doesn't exist yet or Redis error — treat as 0 active streams
-		return true, 0, nil
+		return true, 0, err
 	}
-
-	count, _ := strconv.Atoi(countStr)
-	if count >= maxStreams {
-		return false, count, nil
+	// TTL: 35 min max. If client crashes without calling close, slot auto-expires.
+	if count == 1 {
+		rl.store.Expire(ctx, counterKey, 35*time.Minute)
+	}
+	if count > int64(maxStreams) {
+		rl.store.Decr(ctx, counterKey)
+		return false, int(count - 1), nil
 	}
-	return true, count, nil
+	return true, int(count), nil
 }
 
-// openStreamSlot increments the subscriber's concurrent stream counter.
-// The slot expires after 35 minutes (slightly longer than max stream URL TTL of 15min,
-// accounting for session renewal. Clients decrement via closeStreamSlot on end).
-func (rl *rateLimiter) openStreamSlot(ctx context.Context, subscriberID string) {
+// refreshStreamSlot renews the counter TTL for a long-running stream (e.g. an
+// HDHomeRun tune) so its slot isn't dropped while the stream is still open.
+func (rl *rateLimiter) refreshStreamSlot(ctx context.Context, subscriberID string) {
 	if rl.store == nil {
 		return
 	}
 	counterKey := fmt.Sprintf("owl_api:stream_count:%s", subscriberID)
-	count, _ := rl.store.Incr(ctx, counterKey)
-	// TTL: 35 min max. If client crashes without calling close, slot auto-expires.
-	if count == 1 {
-		rl.store.Expire(ctx, counterKey, 35*time.Minute)
-	}
+	rl.store.Expire(ctx, counterKey, 35*time.Minute)
 }
 
 // closeStreamSlot decrements the subscriber's concurrent stream counter.
 // Called when a stream request completes (or via explicit client close).
+// It runs detached from ctx's cancellation: a client disconnecting cancels
+// the request context, and the slot must still be given back.
 func (rl *rateLimiter) closeStreamSlot(ctx context.Context, subscriberID string) {
 	if rl.store == nil {
 		return
 	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), streamSlotReleaseTimeout)
+	defer cancel()
 	counterKey := fmt.Sprintf("owl_api:stream_count:%s", subscriberID)
-	countStr, err := rl.store.Get(ctx, counterKey)
-	if err != nil {
-		return
-	}
-	count, _ := strconv.Atoi(countStr)
-	if count <= 1 {
+	count, err := rl.store.Decr(ctx, counterKey)
+	if err == nil && count < 0 {
+		// The counter expired while the stream ran.
 		rl.store.Del(ctx, counterKey)
-	} else {
-		rl.store.Set(ctx, counterKey, strconv.Itoa(count-1), 35*time.Minute)
 	}
 }
 
@@ -222,7 +220,7 @@ func (rl *rateLimiter) streamRateLimit(maxStreams int, next http.HandlerFunc) ht
 			return
 		}
 
-		allowed, activeCount, err := rl.checkStreamSlot(r.Context(), subscriberID, maxStreams)
+		allowed, activeCount, err := rl.acquireStreamSlot(r.Context(), subscriberID, maxStreams)
 		if err != nil {
 			// Redis error — fail open
 			next(w, r)
@@ -237,8 +235,6 @@ func (rl *rateLimiter) streamRateLimit(maxStreams int, next http.HandlerFunc) ht
 			return
 		}
 
-		// Open stream slot, track count for this subscriber
-		rl.openStreamSlot(r.Context(), subscriberID)
 		// Close slot when request finishes (stream URL has been issued)
 		// The actual stream is served by Cloudflare CDN — we only track URL issuance.
 		defer rl.closeStreamSlot(r.Context(), subscriberID)
@@ -272,6 +268,10 @@ func (a *goRedisRateLimitAdapter) Incr(ctx context.Context, key string) (int64,
 	return a.c.Incr(ctx, key).Result()
 }
 
+func (a *goRedisRateLimitAdapter) Decr(ctx context.Context, key string) (int64, error) {
+	return a.c.Decr(ctx, key).Result()
+}
+
 func (a *goRedisRateLimitAdapter) Expire(ctx context.Context, key string, ttl time.Duration) error {
 	return a.c.Expire(ctx, key, ttl).Err()
 }
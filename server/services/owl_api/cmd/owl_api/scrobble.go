@@ -0,0 +1,276 @@
+// scrobble.go — Trakt / Simkl linking and the profile watchlist for Owl clients.
+// Links belong to the session's profile. Linking uses the provider's device
+// flow: POST returns a user code for the viewer to enter at verification_url,
+// and the scrobble service finishes the link once it is approved (clients
+// poll GET /owl/v1/scrobble/{provider} for the status).
+//
+// Routes (session auth; /owl/v1 aliases for each):
+//
+//	GET    /owl/scrobble            — configured providers + this profile's links
+//	GET    /owl/scrobble/:provider  — one link's status
+//	POST   /owl/scrobble/:provider  — start linking; returns user_code + verification_url
+//	DELETE /owl/scrobble/:provider  — unlink
+//	GET    /owl/watchlist           — profile watchlist (synced with linked providers)
+//	POST   /owl/watchlist/:id       — add a vod_catalog title
+//	DELETE /owl/watchlist/:id       — remove a title
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/unyeco/roost/services/scrobble"
+)
+
+// scrobbleLinkJSON is a link as returned to clients (never the tokens).
+type scrobbleLinkJSON struct {
+	Provider        string     `json:"provider"`
+	Status          string     `json:"status"` // unlinked | pending | linked | expired | error
+	UserCode        string     `json:"user_code,omitempty"`
+	VerificationURL string     `json:"verification_url,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	LinkedAt        *time.Time `json:"linked_at,omitempty"`
+	LastSyncedAt    *time.Time `json:"last_synced_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+func toScrobbleLinkJSON(l scrobble.Link) scrobbleLinkJSON {
+	out := scrobbleLinkJSON{Provider: l.Provider, Status: l.Status, LastError: l.LastError}
+	if l.Status == "pending" {
+		out.UserCode = l.UserCode
+		out.VerificationURL = l.VerificationURL
+		out.ExpiresAt = &l.DeviceExpiresAt
+	}
+	if !l.LinkedAt.IsZero() {
+		out.LinkedAt = &l.LinkedAt
+	}
+	if !l.HistorySyncedAt.IsZero() {
+		out.LastSyncedAt = &l.HistorySyncedAt
+	}
+	return out
+}
+
+// handleScrobble serves /owl/scrobble and /owl/scrobble/:provider.
+func (s *server) handleScrobble(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	for _, prefix := range []string{"/owl/v1/scrobble", "/owl/scrobble"} {
+		if strings.HasPrefix(path, prefix) {
+			path = strings.TrimPrefix(path, prefix)
+			break
+		}
+	}
+	provider := strings.Trim(path, "/")
+
+	profileID, err := s.viewingProfile(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return
+	}
+	if profileID == "" {
+		writeError(w, http.StatusConflict, "no_profile", "Create a profile before linking a scrobbling account")
+		return
+	}
+
+	links, err := scrobble.ProfileLinks(r.Context(), s.db, profileID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "link lookup failed")
+		return
+	}
+	byProvider := map[string]scrobble.Link{}
+	for _, l := range links {
+		byProvider[l.Provider] = l
+	}
+
+	if provider == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
+			return
+		}
+		out := []scrobbleLinkJSON{}
+		for _, name := range scrobble.ProviderNames(s.scrobblers) {
+			if l, ok := byProvider[name]; ok {
+				out = append(out, toScrobbleLinkJSON(l))
+			} else {
+				out = append(out, scrobbleLinkJSON{Provider: name, Status: "unlinked"})
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"providers": out})
+		return
+	}
+
+	p, ok := s.scrobblers[provider]
+	if !ok {
+		writeError(w, http.StatusNotFound, "provider_unavailable", "Scrobbling provider not configured: "+provider)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		l, ok := byProvider[provider]
+		if !ok {
+			writeJSON(w, http.StatusOK, scrobbleLinkJSON{Provider: provider, Status: "unlinked"})
+			return
+		}
+		writeJSON(w, http.StatusOK, toScrobbleLinkJSON(l))
+
+	case http.MethodPost:
+		l, err := scrobble.StartLink(r.Context(), s.db, p, r.Header.Get("X-Subscriber-ID"), profileID)
+		if errors.Is(err, scrobble.ErrNoTokenKey) {
+			writeError(w, http.StatusServiceUnavailable, "scrobble_unavailable", "Scrobbling is not configured on this server")
+			return
+		}
+		if err != nil {
+			log.Printf("[owl_api] scrobble link %s: %v", provider, err)
+			writeError(w, http.StatusBadGateway, "provider_error", "Could not start linking with "+provider)
+			return
+		}
+		writeJSON(w, http.StatusOK, toScrobbleLinkJSON(*l))
+
+	case http.MethodDelete:
+		removed, err := scrobble.Unlink(r.Context(), s.db, profileID, provider)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", "unlink failed")
+			return
+		}
+		if !removed {
+			writeError(w, http.StatusNotFound, "not_linked", "Profile is not linked to "+provider)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET, POST or DELETE required")
+	}
+}
+
+// handleWatchlist serves /owl/watchlist and /owl/watchlist/:id.
+func (s *server) handleWatchlist(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	for _, prefix := range []string{"/owl/v1/watchlist", "/owl/watchlist"} {
+		if strings.HasPrefix(path, prefix) {
+			path = strings.TrimPrefix(path, prefix)
+			break
+		}
+	}
+	id := strings.Trim(path, "/")
+
+	rp, ok := s.sessionRestrictions(w, r)
+	if !ok {
+		return
+	}
+	profileID, err := s.viewingProfile(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return
+	}
+	if profileID == "" {
+		writeError(w, http.StatusConflict, "no_profile", "Create a profile before using the watchlist")
+		return
+	}
+
+	if id == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
+			return
+		}
+		s.listWatchlist(w, r, rp, profileID)
+		return
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "content id must be a UUID")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var rating, genre sql.NullString
+		err := s.db.QueryRowContext(r.Context(), `
+			SELECT rating, genre FROM vod_catalog WHERE id = $1 AND is_active = TRUE`, id).Scan(&rating, &genre)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "not_found", "content not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", "catalog lookup failed")
+			return
+		}
+		if !rp.ratingAllowed(rating.String) {
+			writeParentalBlocked(w, rp, "rating")
+			return
+		}
+		if rp.categoryBlocked(strings.Split(genre.String, ",")...) {
+			writeParentalBlocked(w, rp, "category")
+			return
+		}
+		if _, err := s.db.ExecContext(r.Context(), `
+			INSERT INTO profile_watchlist (profile_id, catalog_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, profileID, id); err != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", "watchlist update failed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "in_watchlist": true})
+
+	case http.MethodDelete:
+		if _, err := s.db.ExecContext(r.Context(), `
+			DELETE FROM profile_watchlist WHERE profile_id = $1 AND catalog_id = $2`,
+			profileID, id); err != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", "watchlist update failed")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "POST or DELETE required")
+	}
+}
+
+func (s *server) listWatchlist(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, profileID string) {
+	args := []interface{}{profileID}
+	where := []string{"wl.profile_id = $1", "c.is_active = TRUE"}
+	if clause := rp.ratingClause("c.rating", &args); clause != "" {
+		where = append(where, clause)
+	}
+	if clause := rp.genreClause("c.genre", &args); clause != "" {
+		where = append(where, clause)
+	}
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT c.id, c.title, c.slug, c.type, c.poster_url, wl.added_at
+		FROM profile_watchlist wl
+		JOIN vod_catalog c ON c.id = wl.catalog_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY wl.added_at DESC
+		LIMIT 200`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "watchlist query failed")
+		return
+	}
+	defer rows.Close()
+
+	type entry struct {
+		ID        string    `json:"id"`
+		Title     string    `json:"title"`
+		Slug      string    `json:"slug"`
+		Type      string    `json:"type"`
+		PosterURL *string   `json:"poster_url,omitempty"`
+		AddedAt   time.Time `json:"added_at"`
+	}
+	items := []entry{}
+	for rows.Next() {
+		var e entry
+		var poster sql.NullString
+		if err := rows.Scan(&e.ID, &e.Title, &e.Slug, &e.Type, &poster, &e.AddedAt); err != nil {
+			continue
+		}
+		if poster.Valid {
+			e.PosterURL = &poster.String
+		}
+		items = append(items, e)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items, "count": len(items)})
+}
@@ -1,5 +1,8 @@
 // sports_stream.go — Sports-aware streaming endpoints for the Owl Addon API.
 // P15-T04: Subscriber sports preferences and game-annotated stream responses.
+//
+// Favourite teams belong to the session's profile. Rows without a profile
+// (set account-wide through the billing API) apply to every profile.
 package main
 
 import (
@@ -67,6 +70,11 @@ func (s *server) handleSportsEvents(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusUnauthorized, "unauthorized", "Session required")
 		return
 	}
+	profileID, err := s.viewingProfile(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return
+	}
 
 	rows, err := s.db.QueryContext(r.Context(), `
 		SELECT DISTINCT se.id, sl.abbreviation,
@@ -82,11 +90,12 @@ func (s *server) handleSportsEvents(w http.ResponseWriter, r *http.Request) {
 		LEFT JOIN sports_channel_mappings scm ON scm.event_id = se.id AND scm.is_primary = true
 		LEFT JOIN channels c ON c.id = scm.channel_id
 		WHERE ssp.subscriber_id = $1
+		  AND (ssp.profile_id::text = $2 OR ssp.profile_id IS NULL)
 		  AND se.scheduled_time >= now() - interval '3 hours'
 		  AND se.scheduled_time <= now() + interval '7 days'
 		  AND se.status != 'cancelled'
 		ORDER BY se.scheduled_time
-		LIMIT 50`, subscriberID)
+		LIMIT 50`, subscriberID, profileID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", "Failed to get sports events")
 		return
@@ -192,6 +201,11 @@ func (s *server) handleGetSportsTeams(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusUnauthorized, "unauthorized", "Session required")
 		return
 	}
+	profileID, err := s.viewingProfile(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return
+	}
 
 	rows, err := s.db.QueryContext(r.Context(), `
 		SELECT ssp.id, ssp.team_id, st.name, st.abbreviation, st.logo_url,
@@ -200,7 +214,8 @@ func (s *server) handleGetSportsTeams(w http.ResponseWriter, r *http.Request) {
 		JOIN sports_teams st ON st.id = ssp.team_id
 		JOIN sports_leagues sl ON sl.id = st.league_id
 		WHERE ssp.subscriber_id = $1
-		ORDER BY sl.sort_order, st.name`, subscriberID)
+		  AND (ssp.profile_id::text = $2 OR ssp.profile_id IS NULL)
+		ORDER BY sl.sort_order, st.name`, subscriberID, profileID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", "Failed to get favourite teams")
 		return
@@ -231,13 +246,18 @@ func (s *server) handleSportsTeamsFavorite(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-// POST /owl/sports/teams/:id/favorite — add a team to subscriber's favourites.
+// POST /owl/sports/teams/:id/favorite — add a team to the profile's favourites.
 func (s *server) handleAddFavoriteTeam(w http.ResponseWriter, r *http.Request) {
 	subscriberID := r.Header.Get("X-Subscriber-ID")
 	if subscriberID == "" {
 		writeError(w, http.StatusUnauthorized, "unauthorized", "Session required")
 		return
 	}
+	profileID, err := s.viewingProfile(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return
+	}
 	teamID := extractSportsTeamID(r.URL.Path)
 
 	var body struct {
@@ -253,12 +273,12 @@ func (s *server) handleAddFavoriteTeam(w http.ResponseWriter, r *http.Request) {
 		autoDVR = *body.AutoDVR
 	}
 
-	_, err := s.db.ExecContext(r.Context(), `
-		INSERT INTO subscriber_sports_preferences (subscriber_id, team_id, notification_level, auto_dvr)
-		VALUES ($1, $2, $3, $4)
+	_, err = s.db.ExecContext(r.Context(), `
+		INSERT INTO subscriber_sports_preferences (subscriber_id, profile_id, team_id, notification_level, auto_dvr)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5)
 		ON CONFLICT (subscriber_id, COALESCE(profile_id, '00000000-0000-0000-0000-000000000000'::uuid), team_id)
 		DO UPDATE SET notification_level = EXCLUDED.notification_level, auto_dvr = EXCLUDED.auto_dvr`,
-		subscriberID, teamID, body.NotificationLevel, autoDVR)
+		subscriberID, profileID, teamID, body.NotificationLevel, autoDVR)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", "Failed to add favourite team")
 		return
@@ -266,18 +286,24 @@ func (s *server) handleAddFavoriteTeam(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "added", "team_id": teamID})
 }
 
-// DELETE /owl/sports/teams/:id/favorite — remove a team from subscriber's favourites.
+// DELETE /owl/sports/teams/:id/favorite — remove a team from the profile's favourites.
 func (s *server) handleRemoveFavoriteTeam(w http.ResponseWriter, r *http.Request) {
 	subscriberID := r.Header.Get("X-Subscriber-ID")
 	if subscriberID == "" {
 		writeError(w, http.StatusUnauthorized, "unauthorized", "Session required")
 		return
 	}
+	profileID, err := s.viewingProfile(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return
+	}
 	teamID := extractSportsTeamID(r.URL.Path)
 
-	_, err := s.db.ExecContext(r.Context(), `
+	_, err = s.db.ExecContext(r.Context(), `
 		DELETE FROM subscriber_sports_preferences
-		WHERE subscriber_id = $1 AND team_id = $2`, subscriberID, teamID)
+		WHERE subscriber_id = $1 AND team_id = $2
+		  AND (profile_id::text = $3 OR profile_id IS NULL)`, subscriberID, teamID, profileID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", "Failed to remove favourite team")
 		return
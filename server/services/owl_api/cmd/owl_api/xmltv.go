@@ -0,0 +1,228 @@
+// xmltv.go — XMLTV guide export for Xtream Codes players.
+//
+//	GET /xmltv.php?username=X&password=Y
+//
+// Returns every active channel and its programmes from xmltvPast ago to
+// xmltvAhead from now. Channel ids match epg_channel_id in get_live_streams
+// (the channel's EPG id, or its slug when it has none) so players can join
+// the guide to the channel list. The family's playout channels follow under
+// their slug, with the channel service's schedule as programmes. Auth and
+// profile selection are the same as the other Xtream routes; the profile's
+// parental controls filter both channels and programmes. The document is
+// streamed, not built in memory.
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	xmltvPast  = 24 * time.Hour
+	xmltvAhead = 7 * 24 * time.Hour
+
+	xmltvTimeFormat = "20060102150405 -0700"
+)
+
+type xmltvText struct {
+	Lang  string `xml:"lang,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type xmltvIcon struct {
+	Src string `xml:"src,attr"`
+}
+
+type xmltvChannel struct {
+	XMLName     xml.Name   `xml:"channel"`
+	ID          string     `xml:"id,attr"`
+	DisplayName xmltvText  `xml:"display-name"`
+	Icon        *xmltvIcon `xml:"icon,omitempty"`
+}
+
+type xmltvRating struct {
+	System string `xml:"system,attr,omitempty"`
+	Value  string `xml:"value"`
+}
+
+// xmltvProgramme fields are in the DTD's child order.
+type xmltvProgramme struct {
+	XMLName         xml.Name          `xml:"programme"`
+	Start           string            `xml:"start,attr"`
+	Stop            string            `xml:"stop,attr"`
+	Channel         string            `xml:"channel,attr"`
+	Title           xmltvText         `xml:"title"`
+	SubTitle        *xmltvText        `xml:"sub-title,omitempty"`
+	Desc            *xmltvText        `xml:"desc,omitempty"`
+	Credits         *xmltvCredits     `xml:"credits,omitempty"`
+	Date            string            `xml:"date,omitempty"`
+	Categories      []xmltvText       `xml:"category"`
+	EpisodeNums     []xmltvEpisodeNum `xml:"episode-num"`
+	PreviouslyShown *struct{}         `xml:"previously-shown,omitempty"`
+	Premiere        *struct{}         `xml:"premiere,omitempty"`
+	New             *struct{}         `xml:"new,omitempty"`
+	Rating          *xmltvRating      `xml:"rating,omitempty"`
+	StarRating      *xmltvStarRating  `xml:"star-rating,omitempty"`
+}
+
+// xmltvChannelID is the guide id of a channel: its EPG id, or its slug.
+func xmltvChannelID(epgChannelID, slug string) string {
+	if epgChannelID != "" {
+		return epgChannelID
+	}
+	return slug
+}
+
+// ---- handler: GET /xmltv.php ------------------------------------------------
+
+func (s *server) handleXMLTV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
+		return
+	}
+	q := r.URL.Query()
+	subscriberID, rp, ok := s.xtreamAuth(w, r, q.Get("username"), q.Get("password"))
+	if !ok {
+		return
+	}
+
+	chArgs := []interface{}{}
+	chWhere := "c.is_active = true"
+	if clause := rp.channelCategoryClause(&chArgs); clause != "" {
+		chWhere += " AND " + clause
+	}
+	chRows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT c.slug, c.name, coalesce(c.logo_url, ''), coalesce(c.epg_channel_id, '')
+		FROM channels c
+		WHERE %s
+		ORDER BY c.sort_order ASC
+	`, chWhere), chArgs...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch channels")
+		return
+	}
+	var channels []xmltvChannel
+	for chRows.Next() {
+		var slug, name, logo, epgID string
+		if err := chRows.Scan(&slug, &name, &logo, &epgID); err != nil {
+			continue
+		}
+		ch := xmltvChannel{ID: xmltvChannelID(epgID, slug), DisplayName: xmltvText{Value: name}}
+		if logo != "" {
+			ch.Icon = &xmltvIcon{Src: logo}
+		}
+		channels = append(channels, ch)
+	}
+	chRows.Close()
+
+	playouts, err := s.familyPlayouts(r.Context(), subscriberID, rp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch channels")
+		return
+	}
+	var playoutProgs []xmltvProgramme
+	for _, c := range playouts {
+		channels = append(channels, xmltvChannel{ID: c.slug(), DisplayName: xmltvText{Value: c.Name}})
+		progs, err := playoutSchedule(r.Context(), c, subscriberID)
+		if err != nil {
+			log.Printf("[owl_api] xmltv: playout %s schedule: %v", c.ID, err)
+			continue
+		}
+		for _, p := range progs {
+			playoutProgs = append(playoutProgs, xmltvProgrammeFor(c.slug(), p.Title, "", playoutCategory, "", "en",
+				p.StartTime, p.EndTime, false, programDetails{}))
+		}
+	}
+
+	now := time.Now().UTC()
+	args := []interface{}{now.Add(-xmltvPast), now.Add(xmltvAhead)}
+	where := []string{"ep.end_time > $1", "ep.start_time < $2", "c.is_active = true"}
+	where = append(where, rp.programClauses(&args)...)
+	rows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT c.slug, coalesce(c.epg_channel_id, ''), ep.title, coalesce(ep.description, ''),
+		       ep.start_time, ep.end_time, coalesce(ep.genre, ''), coalesce(ep.rating, ''),
+		       'en', ep.is_new,
+		       `+programDetailCols+`
+		FROM programs ep
+		JOIN channels c ON c.id = ep.channel_id
+		WHERE %s
+		ORDER BY c.sort_order ASC, ep.start_time ASC
+	`, strings.Join(where, " AND ")), args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch programmes")
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	_, _ = w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	tv := xml.StartElement{
+		Name: xml.Name{Local: "tv"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "generator-info-name"}, Value: "Roost"}},
+	}
+	if err := enc.EncodeToken(tv); err != nil {
+		return
+	}
+	for _, ch := range channels {
+		if err := enc.Encode(ch); err != nil {
+			return // client went away
+		}
+	}
+	for rows.Next() {
+		var slug, epgID, title, desc, cat, rating, lang string
+		var start, end time.Time
+		var isNew bool
+		var details programDetails
+		detailDest, scanned := programDetailsScan(&details)
+		dest := append([]interface{}{&slug, &epgID, &title, &desc, &start, &end, &cat, &rating, &lang, &isNew}, detailDest...)
+		if err := rows.Scan(dest...); err != nil {
+			continue
+		}
+		scanned()
+		if err := enc.Encode(xmltvProgrammeFor(xmltvChannelID(epgID, slug), title, desc, cat, rating, lang, start, end, isNew, details)); err != nil {
+			return
+		}
+	}
+	for _, p := range playoutProgs {
+		if err := enc.Encode(p); err != nil {
+			return
+		}
+	}
+	_ = enc.EncodeToken(tv.End())
+	_ = enc.Flush()
+}
+
+// xmltvProgrammeFor builds one <programme> element. details.Categories,
+// when set, replaces the single category.
+func xmltvProgrammeFor(channelID, title, desc, category, rating, lang string, start, end time.Time, isNew bool, details programDetails) xmltvProgramme {
+	p := xmltvProgramme{
+		Start:   start.UTC().Format(xmltvTimeFormat),
+		Stop:    end.UTC().Format(xmltvTimeFormat),
+		Channel: channelID,
+		Title:   xmltvText{Lang: lang, Value: title},
+	}
+	if desc != "" {
+		p.Desc = &xmltvText{Lang: lang, Value: desc}
+	}
+	if category != "" {
+		p.Categories = []xmltvText{{Lang: lang, Value: category}}
+	}
+	if rating != "" {
+		system := "MPAA"
+		if strings.HasPrefix(strings.ToUpper(rating), "TV-") {
+			system = "VCHIP"
+		}
+		p.Rating = &xmltvRating{System: system, Value: rating}
+	}
+	if isNew {
+		p.New = &struct{}{}
+	}
+	applyXMLTVDetails(&p, details, lang)
+	return p
+}
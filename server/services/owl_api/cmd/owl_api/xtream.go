@@ -6,19 +6,35 @@
 // Owl app requirement.
 //
 // Authentication: Xtream players send the subscriber's Roost API token as the
-// "username" field. The password field is accepted but ignored — the API token
-// is the only credential verified. The token must start with "roost_" and exist
-// as an active record in api_tokens joined to an active subscriber.
+// "username" field. The token must start with "roost_" and exist as an active
+// record in api_tokens joined to an active subscriber.
+//
+// Profiles: the password field selects the subscriber profile whose parental
+// controls apply — "<profile name or id>" or "<profile name or id>:<pin>" for
+// a PIN-protected profile. Any other password selects the primary profile, so
+// players configured with a placeholder password keep working.
 //
 // Endpoints added:
 //   GET  /player_api.php?username=X&password=Y&action=get_live_categories
 //   GET  /player_api.php?username=X&password=Y&action=get_live_streams
 //   GET  /player_api.php?username=X&password=Y&action=get_epg_info_id&stream_id=N
 //   GET  /live/:username/:password/:stream_id.m3u8
+//   GET  /live/:username/:password/:stream_id/:seq.ts   (playout channel segments)
+//   GET  /timeshift/:username/:password/:duration_min/:start/:stream_id.m3u8
+//   GET  /streaming/timeshift.php?username=X&password=Y&stream=N&start=S&duration=M
+// Movies and series are in xtream_vod.go, the XMLTV guide in xmltv.go.
+//
+// Timeshift: start is "YYYY-MM-DD:HH-MM" in UTC (server_info.timezone). The
+// request is redirected to the catchup service's time-range playlist, the
+// same URL /owl/catchup returns. Channels with catchup enabled report
+// tv_archive=1 and their retention in tv_archive_duration (days).
 //
 // Stream IDs in Xtream format are integer channel IDs, mapped from our UUID-based
 // channel table via a stable integer sort_order column. Xtream players cache these
-// IDs so they must be stable across restarts.
+// IDs so they must be stable across restarts. The family's playout channels
+// follow in their own category, with stream IDs from playoutNumberBase up; their
+// /live/ playlist is served here, with segments under
+// /live/:username/:password/:stream_id/:seq.ts (playout.go).
 //
 // Security: source stream URLs are NEVER returned. The /live/ endpoint validates
 // the token and redirects to the Cloudflare-signed relay URL (15-min expiry).
@@ -30,12 +46,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	rootauth "github.com/unyeco/roost/internal/auth"
 )
 
+// xtreamPlayoutCategory is the live category id of playout channels, clear of
+// the 1..n numbering of channel categories.
+const xtreamPlayoutCategory = playoutNumberBase
+
 // ---- Xtream auth / types ---------------------------------------------------
 
 // xtreamUserInfo is the subscriber profile returned on login.
@@ -140,6 +162,86 @@ func (s *server) validateXtreamCreds(r *http.Request, username string) (subscrib
 	return subscriberID, plan, nil
 }
 
+// splitXtreamPassword splits an Xtream password into a profile selector and PIN.
+func splitXtreamPassword(password string) (profile, pin string) {
+	profile, pin, _ = strings.Cut(password, ":")
+	return strings.TrimSpace(profile), strings.TrimSpace(pin)
+}
+
+// xtreamProfileID resolves the profile selected by an Xtream password (see
+// the file comment). Returns "" when the subscriber has no profiles.
+func (s *server) xtreamProfileID(r *http.Request, subscriberID, password string) (string, error) {
+	selector, pin := splitXtreamPassword(password)
+	if selector != "" {
+		var id string
+		var pinHash sql.NullString
+		err := s.db.QueryRowContext(r.Context(), `
+			SELECT id, pin_hash FROM subscriber_profiles
+			WHERE subscriber_id = $1 AND is_active = TRUE
+			  AND (id::text = $2 OR lower(name) = lower($2))
+		`, subscriberID, selector).Scan(&id, &pinHash)
+		if err == nil {
+			if err := checkPIN(pinHash, pin); err != nil {
+				return "", err
+			}
+			return id, nil
+		}
+		if err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+	return resolveProfile(r.Context(), s.db, subscriberID, "", "")
+}
+
+// writeXtreamAuthFailed writes the auth=0 login shape Xtream players expect
+// in place of an HTTP error status.
+func writeXtreamAuthFailed(w http.ResponseWriter, username, password, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_info": xtreamUserInfo{
+			Username: username,
+			Password: password,
+			Auth:     0,
+			Status:   "Disabled",
+			Message:  msg,
+		},
+	})
+}
+
+// xtreamAuth validates Xtream credentials on the stream routes and loads the
+// selected profile's restrictions. When ok is false an error has been written.
+func (s *server) xtreamAuth(w http.ResponseWriter, r *http.Request, username, password string) (subscriberID string, rp *profileRestrictions, ok bool) {
+	subscriberID, _, err := s.validateXtreamCreds(r, username)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid username/password")
+		return "", nil, false
+	}
+	profileID, err := s.xtreamProfileID(r, subscriberID, password)
+	if err == errPINRequired || err == errInvalidPIN {
+		writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid username/password")
+		return "", nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return "", nil, false
+	}
+	if profileID == "" {
+		return subscriberID, nil, true
+	}
+	rp, ok = s.checkProfile(w, r, subscriberID, profileID)
+	return subscriberID, rp, ok
+}
+
+// xtreamStreamIDPart strips the container extension from the last path
+// segment of a stream URL ("42.m3u8", "42.ts", "7.mp4" → "42", "42", "7").
+func xtreamStreamIDPart(segment string) string {
+	if i := strings.LastIndexByte(segment, '.'); i > 0 {
+		return segment[:i]
+	}
+	return segment
+}
+
 // ---- handler: GET /player_api.php ------------------------------------------
 
 // handlePlayerAPI is the main Xtream Codes dispatch endpoint.
@@ -159,29 +261,48 @@ func (s *server) handlePlayerAPI(w http.ResponseWriter, r *http.Request) {
 	// Validate credentials for all actions
 	subscriberID, plan, err := s.validateXtreamCreds(r, username)
 	if err != nil {
-		// Xtream players expect auth=0 in JSON, not an HTTP error status
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(w).Encode(map[string]interface{}{
-			"user_info": xtreamUserInfo{
-				Username: username,
-				Password: password,
-				Auth:     0,
-				Status:   "Disabled",
-				Message:  "Invalid credentials",
-			},
-		})
+		writeXtreamAuthFailed(w, username, password, "Invalid credentials")
+		return
+	}
+	profileID, err := s.xtreamProfileID(r, subscriberID, password)
+	if err == errPINRequired || err == errInvalidPIN {
+		writeXtreamAuthFailed(w, username, password, "Invalid profile PIN")
 		return
 	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Profile lookup failed")
+		return
+	}
+
+	// Content actions carry the profile's parental controls; the login check does not.
+	var rp *profileRestrictions
+	if action != "" && profileID != "" {
+		var ok bool
+		if rp, ok = s.checkProfile(w, r, subscriberID, profileID); !ok {
+			return
+		}
+	}
 
 	switch action {
 	case "get_live_categories":
-		s.xtreamLiveCategories(w, r)
+		s.xtreamLiveCategories(w, r, rp, subscriberID)
 	case "get_live_streams":
-		s.xtreamLiveStreams(w, r, subscriberID, username)
-	case "get_epg_info_id":
+		s.xtreamLiveStreams(w, r, rp, subscriberID, username)
+	case "get_epg_info_id", "get_short_epg", "get_simple_data_table":
 		streamIDStr := q.Get("stream_id")
-		s.xtreamEPGByStreamID(w, r, streamIDStr)
+		s.xtreamEPGByStreamID(w, r, rp, subscriberID, streamIDStr)
+	case "get_vod_categories":
+		s.xtreamVODCategories(w, r, rp, "movie")
+	case "get_vod_streams":
+		s.xtreamVODStreams(w, r, rp, q.Get("category_id"))
+	case "get_vod_info":
+		s.xtreamVODInfo(w, r, rp, q.Get("vod_id"))
+	case "get_series_categories":
+		s.xtreamVODCategories(w, r, rp, "series")
+	case "get_series":
+		s.xtreamSeriesList(w, r, rp, q.Get("category_id"))
+	case "get_series_info":
+		s.xtreamSeriesInfo(w, r, rp, q.Get("series_id"))
 	default:
 		// No action = login check — return user_info + server_info
 		s.xtreamLoginResponse(w, r, username, password, subscriberID, plan)
@@ -225,14 +346,23 @@ func (s *server) xtreamLoginResponse(w http.ResponseWriter, r *http.Request, use
 }
 
 // xtreamLiveCategories returns all distinct channel categories in Xtream format.
-func (s *server) xtreamLiveCategories(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.QueryContext(r.Context(), `
-		SELECT DISTINCT category, count(*) as cnt
-		FROM channels
-		WHERE is_active = true AND category IS NOT NULL AND category != ''
-		GROUP BY category
-		ORDER BY category ASC
-	`)
+// Category IDs are numbered over every category so they match
+// xtreamLiveStreams for any profile; categories the profile cannot see (no
+// allowed channels) are left out. Playout channels, when the family has any,
+// are listed last under xtreamPlayoutCategory.
+func (s *server) xtreamLiveCategories(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, subscriberID string) {
+	args := []interface{}{}
+	visible := rp.channelCategoryClause(&args)
+	if visible == "" {
+		visible = "true"
+	}
+	rows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT c.category, count(*) FILTER (WHERE %s) as cnt
+		FROM channels c
+		WHERE c.is_active = true AND c.category IS NOT NULL AND c.category != ''
+		GROUP BY c.category
+		ORDER BY c.category ASC
+	`, visible), args...)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch categories")
 		return
@@ -247,6 +377,10 @@ func (s *server) xtreamLiveCategories(w http.ResponseWriter, r *http.Request) {
 		if err := rows.Scan(&cat, &cnt); err != nil {
 			continue
 		}
+		if cnt == 0 {
+			i++
+			continue
+		}
 		cats = append(cats, xtreamCategory{
 			CategoryID:   fmt.Sprintf("%d", i),
 			CategoryName: cat,
@@ -255,6 +389,19 @@ func (s *server) xtreamLiveCategories(w http.ResponseWriter, r *http.Request) {
 		i++
 	}
 
+	playouts, err := s.familyPlayouts(r.Context(), subscriberID, rp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch categories")
+		return
+	}
+	if len(playouts) > 0 {
+		cats = append(cats, xtreamCategory{
+			CategoryID:   strconv.Itoa(xtreamPlayoutCategory),
+			CategoryName: playoutCategory,
+			ParentID:     0,
+		})
+	}
+
 	if cats == nil {
 		cats = []xtreamCategory{}
 	}
@@ -266,9 +413,7 @@ func (s *server) xtreamLiveCategories(w http.ResponseWriter, r *http.Request) {
 // xtreamLiveStreams returns all active channels in Xtream format.
 // Stream IDs are stable integers from the sort_order column.
 // Stream URLs are served via /live/:username/:password/:stream_id.m3u8 (redirect endpoint).
-func (s *server) xtreamLiveStreams(w http.ResponseWriter, r *http.Request, subscriberID, username string) {
-	_ = subscriberID // available for future per-subscriber channel filtering
-
+func (s *server) xtreamLiveStreams(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, subscriberID, username string) {
 	// Build a stable category_id lookup from the active channel set
 	catRows, err := s.db.QueryContext(r.Context(), `
 		SELECT DISTINCT category FROM channels
@@ -290,13 +435,20 @@ func (s *server) xtreamLiveStreams(w http.ResponseWriter, r *http.Request, subsc
 	}
 	catRows.Close()
 
-	rows, err := s.db.QueryContext(r.Context(), `
+	args := []interface{}{}
+	where := "c.is_active = true"
+	if clause := rp.channelCategoryClause(&args); clause != "" {
+		where += " AND " + clause
+	}
+	rows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(`
 		SELECT c.sort_order, c.name, c.slug, coalesce(c.logo_url,''),
-		       coalesce(c.epg_channel_id,''), coalesce(c.category,'')
+		       coalesce(c.epg_channel_id,''), coalesce(c.category,''),
+		       coalesce(cs.enabled, false), coalesce(cs.retention_days, 0)
 		FROM channels c
-		WHERE c.is_active = true
+		LEFT JOIN catchup_settings cs ON cs.channel_id = c.id
+		WHERE %s
 		ORDER BY c.sort_order ASC
-	`)
+	`, where), args...)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch streams")
 		return
@@ -306,11 +458,16 @@ func (s *server) xtreamLiveStreams(w http.ResponseWriter, r *http.Request, subsc
 	var streams []xtreamStream
 	num := 1
 	for rows.Next() {
-		var sortOrder int
+		var sortOrder, archiveDays int
 		var name, slug, logo, epgID, category string
-		if err := rows.Scan(&sortOrder, &name, &slug, &logo, &epgID, &category); err != nil {
+		var archive bool
+		if err := rows.Scan(&sortOrder, &name, &slug, &logo, &epgID, &category,
+			&archive, &archiveDays); err != nil {
 			continue
 		}
+		if !archive {
+			archiveDays = 0
+		}
 
 		catID := catMap[category]
 		catIDInt := 0
@@ -324,15 +481,36 @@ func (s *server) xtreamLiveStreams(w http.ResponseWriter, r *http.Request, subsc
 			StreamType:        "live",
 			StreamID:          sortOrder,
 			StreamIcon:        logo,
-			EPGChannelID:      epgID,
+			EPGChannelID:      xmltvChannelID(epgID, slug),
 			Added:             fmt.Sprintf("%d", time.Now().Unix()),
 			IsAdult:           "0",
 			CategoryID:        catID,
 			CategoryIds:       []int{catIDInt},
 			CustomSID:         slug,
-			TVArchive:         0,
+			TVArchive:         boolToInt(archive),
 			DirectSource:      "", // NEVER expose source URL
-			TVArchiveDuration: 0,
+			TVArchiveDuration: archiveDays,
+		})
+		num++
+	}
+
+	playouts, err := s.familyPlayouts(r.Context(), subscriberID, rp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch streams")
+		return
+	}
+	for _, c := range playouts {
+		streams = append(streams, xtreamStream{
+			Num:          num,
+			Name:         c.Name,
+			StreamType:   "live",
+			StreamID:     c.Number,
+			EPGChannelID: c.slug(),
+			Added:        fmt.Sprintf("%d", time.Now().Unix()),
+			IsAdult:      "0",
+			CategoryID:   strconv.Itoa(xtreamPlayoutCategory),
+			CategoryIds:  []int{xtreamPlayoutCategory},
+			CustomSID:    c.slug(),
 		})
 		num++
 	}
@@ -347,7 +525,7 @@ func (s *server) xtreamLiveStreams(w http.ResponseWriter, r *http.Request, subsc
 
 // xtreamEPGByStreamID returns EPG programs for a specific channel by stream_id (sort_order).
 // Returns the last 4h and next 48h of programming (up to 100 entries).
-func (s *server) xtreamEPGByStreamID(w http.ResponseWriter, r *http.Request, streamIDStr string) {
+func (s *server) xtreamEPGByStreamID(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, subscriberID, streamIDStr string) {
 	if streamIDStr == "" {
 		writeError(w, http.StatusBadRequest, "missing_stream_id", "stream_id required")
 		return
@@ -358,6 +536,10 @@ func (s *server) xtreamEPGByStreamID(w http.ResponseWriter, r *http.Request, str
 		writeError(w, http.StatusBadRequest, "invalid_stream_id", "stream_id must be integer")
 		return
 	}
+	if streamID >= playoutNumberBase {
+		s.xtreamPlayoutEPG(w, r, rp, subscriberID, streamID)
+		return
+	}
 
 	// Look up channel by sort_order (= Xtream stream_id)
 	var channelID string
@@ -374,19 +556,33 @@ func (s *server) xtreamEPGByStreamID(w http.ResponseWriter, r *http.Request, str
 		return
 	}
 
+	if blocked, err := s.channelCategoryBlocked(r.Context(), rp, channelID); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	} else if blocked {
+		writeParentalBlocked(w, rp, "category")
+		return
+	}
+
 	from := time.Now().UTC().Add(-4 * time.Hour)
 	to := time.Now().UTC().Add(48 * time.Hour)
 
-	rows, err := s.db.QueryContext(r.Context(), `
+	args := []interface{}{channelID, from, to}
+	where := []string{"ep.channel_id = $1", "ep.start_time >= $2", "ep.end_time <= $3"}
+	if clause := rp.ratingClause("ep.rating", &args); clause != "" {
+		where = append(where, clause)
+	}
+	if clause := rp.programCategoryClause("ep.genre", &args); clause != "" {
+		where = append(where, clause)
+	}
+	rows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(`
 		SELECT ep.id, ep.title, coalesce(ep.description,''), ep.start_time, ep.end_time,
-		       coalesce(ep.language_code, 'en')
-		FROM epg_programs ep
-		WHERE ep.channel_id = $1
-		  AND ep.start_time >= $2
-		  AND ep.end_time <= $3
+		       'en'
+		FROM programs ep
+		WHERE %s
 		ORDER BY ep.start_time ASC
 		LIMIT 100
-	`, channelID, from, to)
+	`, strings.Join(where, " AND ")), args...)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "query_error", "EPG query failed")
 		return
@@ -440,6 +636,51 @@ func (s *server) xtreamEPGByStreamID(w http.ResponseWriter, r *http.Request, str
 	})
 }
 
+// xtreamPlayoutEPG returns a playout channel's schedule in the
+// get_epg_info_id format.
+func (s *server) xtreamPlayoutEPG(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, subscriberID string, streamID int) {
+	c, err := s.familyPlayoutByNumber(r.Context(), subscriberID, streamID)
+	if err == sql.ErrNoRows || (err == nil && rp.ratingLimited()) {
+		writeError(w, http.StatusNotFound, "channel_not_found", "Channel not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	}
+	progs, err := playoutSchedule(r.Context(), c, subscriberID)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "channel_unavailable", "Playout schedule unavailable")
+		return
+	}
+
+	programs := []xtreamEPGProgram{}
+	now := time.Now().UTC()
+	for _, p := range progs {
+		nowPlaying := 0
+		if p.StartTime.Before(now) && p.EndTime.After(now) {
+			nowPlaying = 1
+		}
+		programs = append(programs, xtreamEPGProgram{
+			ID:             fmt.Sprintf("%s-%d", c.slug(), p.StartTime.Unix()),
+			EPGListingID:   c.slug(),
+			Title:          p.Title,
+			Lang:           "en",
+			Start:          p.StartTime.UTC().Format("2006-01-02 15:04:05"),
+			End:            p.EndTime.UTC().Format("2006-01-02 15:04:05"),
+			ChannelID:      c.slug(),
+			StartTimestamp: p.StartTime.Unix(),
+			StopTimestamp:  p.EndTime.Unix(),
+			NowPlaying:     nowPlaying,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"epg_listings": programs,
+	})
+}
+
 // ---- handler: GET /live/:username/:password/:stream_id.m3u8 ----------------
 
 // handleXtreamStream validates the Xtream credentials, looks up the channel
@@ -460,28 +701,32 @@ func (s *server) handleXtreamStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username := parts[1]
-	// parts[2] is password — accepted for Xtream compatibility but not checked
-	streamIDRaw := strings.TrimSuffix(parts[3], ".m3u8")
-	streamIDRaw = strings.TrimSuffix(streamIDRaw, ".ts")
-
+	// parts[1] is the API token, parts[2] selects the profile — see xtreamProfileID
 	var streamID int
-	if _, err := fmt.Sscanf(streamIDRaw, "%d", &streamID); err != nil {
+	if _, err := fmt.Sscanf(xtreamStreamIDPart(parts[3]), "%d", &streamID); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid_stream_id", "stream_id must be integer")
 		return
 	}
 
 	// Validate API token (Xtream username field)
-	if _, _, err := s.validateXtreamCreds(r, username); err != nil {
-		writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid username/password")
+	subscriberID, rp, ok := s.xtreamAuth(w, r, parts[1], parts[2])
+	if !ok {
+		return
+	}
+	if streamID >= playoutNumberBase {
+		segment := ""
+		if len(parts) > 4 {
+			segment = parts[4]
+		}
+		s.xtreamPlayoutStream(w, r, rp, subscriberID, streamID, segment)
 		return
 	}
 
 	// Look up channel slug by sort_order (= Xtream stream_id)
-	var slug string
+	var channelID, slug string
 	err := s.db.QueryRowContext(r.Context(), `
-		SELECT slug FROM channels WHERE sort_order = $1 AND is_active = true
-	`, streamID).Scan(&slug)
+		SELECT id, slug FROM channels WHERE sort_order = $1 AND is_active = true
+	`, streamID).Scan(&channelID, &slug)
 	if err == sql.ErrNoRows {
 		writeError(w, http.StatusNotFound, "channel_unavailable", "Channel not found or unavailable")
 		return
@@ -491,9 +736,165 @@ func (s *server) handleXtreamStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if reason, err := s.channelBlockedReason(r.Context(), rp, channelID, time.Now()); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	} else if reason != "" {
+		writeParentalBlocked(w, rp, reason)
+		return
+	}
+
 	// Generate Cloudflare-signed CDN relay URL (15-min expiry)
 	streamURL, _ := signedStreamURL(slug)
 
 	// 302-redirect to signed CDN relay — player follows, source never exposed
 	http.Redirect(w, r, streamURL, http.StatusFound)
 }
+
+// xtreamPlayoutStream serves a playout channel's live playlist, or one of its
+// segments (/live/:username/:password/:stream_id/:seq.ts). Players send no
+// other credentials, so the segment URIs carry the same path ones.
+func (s *server) xtreamPlayoutStream(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, subscriberID string, streamID int, segment string) {
+	c, err := s.familyPlayoutByNumber(r.Context(), subscriberID, streamID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "channel_unavailable", "Channel not found or unavailable")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	}
+	if reason := playoutBlockedReason(rp, time.Now()); reason != "" {
+		writeParentalBlocked(w, rp, reason)
+		return
+	}
+
+	file := "live.m3u8"
+	if segment != "" {
+		file = "live/" + segment
+	}
+	prefix := strconv.Itoa(streamID) + "/"
+	servePlayout(w, r, c, subscriberID, file, func(data []byte) []byte {
+		return rebasePlaylist(data, prefix)
+	})
+}
+
+// ---- handler: GET /timeshift/:username/:password/:duration/:start/:id.m3u8 --
+// ---- handler: GET /streaming/timeshift.php ---------------------------------
+
+// maxTimeshift matches the catchup service's longest time-range playlist.
+const maxTimeshift = 8 * time.Hour
+
+// parseTimeshiftStart parses an Xtream timeshift start ("2006-01-02:15-04",
+// with optional seconds) as UTC.
+func parseTimeshiftStart(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02:15-04", "2006-01-02:15-04-05", "2006-01-02 15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid start %q", s)
+}
+
+// handleXtreamTimeshift validates the credentials and catchup availability,
+// then 302-redirects to the catchup service's time-range playlist.
+func (s *server) handleXtreamTimeshift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
+		return
+	}
+
+	var username, password, durationStr, startStr, streamIDStr string
+	if strings.HasPrefix(r.URL.Path, "/streaming/") {
+		q := r.URL.Query()
+		username, password = q.Get("username"), q.Get("password")
+		durationStr, startStr, streamIDStr = q.Get("duration"), q.Get("start"), q.Get("stream")
+	} else {
+		// /timeshift/{username}/{password}/{duration}/{start}/{stream_id}.m3u8
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) < 6 {
+			writeError(w, http.StatusBadRequest, "invalid_path",
+				"Expected /timeshift/:username/:password/:duration/:start/:stream_id.m3u8")
+			return
+		}
+		username, password = parts[1], parts[2]
+		durationStr, startStr, streamIDStr = parts[3], parts[4], xtreamStreamIDPart(parts[5])
+	}
+
+	streamID, err := strconv.Atoi(streamIDStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_stream_id", "stream_id must be integer")
+		return
+	}
+	minutes, err := strconv.Atoi(durationStr)
+	if err != nil || minutes <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_duration", "duration must be a positive number of minutes")
+		return
+	}
+	duration := time.Duration(minutes) * time.Minute
+	if duration > maxTimeshift {
+		duration = maxTimeshift
+	}
+	start, err := parseTimeshiftStart(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_start", "start must be YYYY-MM-DD:HH-MM")
+		return
+	}
+	if start.After(time.Now()) {
+		writeError(w, http.StatusBadRequest, "invalid_start", "start is in the future")
+		return
+	}
+
+	_, rp, ok := s.xtreamAuth(w, r, username, password)
+	if !ok {
+		return
+	}
+
+	var channelID, slug string
+	var enabled bool
+	var retentionDays int
+	err = s.db.QueryRowContext(r.Context(), `
+		SELECT c.id, c.slug, coalesce(cs.enabled, false), coalesce(cs.retention_days, 7)
+		FROM channels c
+		LEFT JOIN catchup_settings cs ON cs.channel_id = c.id
+		WHERE c.sort_order = $1 AND c.is_active = true
+	`, streamID).Scan(&channelID, &slug, &enabled, &retentionDays)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "channel_unavailable", "Channel not found or unavailable")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	}
+	if !enabled {
+		writeError(w, http.StatusNotFound, "catchup_unavailable", "Catchup is not enabled for this channel")
+		return
+	}
+	if start.Before(time.Now().AddDate(0, 0, -retentionDays)) {
+		writeError(w, http.StatusGone, "expired", "Content outside retention window")
+		return
+	}
+
+	if reason, err := s.channelBlockedReason(r.Context(), rp, channelID, start); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Channel lookup failed")
+		return
+	} else if reason != "" {
+		writeParentalBlocked(w, rp, reason)
+		return
+	}
+
+	q := url.Values{}
+	q.Set("start", start.Format(time.RFC3339))
+	q.Set("end", start.Add(duration).Format(time.RFC3339))
+	playlistURL := fmt.Sprintf("%s/catchup/%s/playlist.m3u8?%s",
+		getEnv("ROOST_BASE_URL", "https://roost.unity.dev"), slug, q.Encode())
+	http.Redirect(w, r, playlistURL, http.StatusFound)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -7,10 +7,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -257,48 +260,222 @@ func TestXtreamStreamPathParsing(t *testing.T) {
 	}
 }
 
+// TestSplitXtreamPassword verifies the password field's profile[:pin] form.
+func TestSplitXtreamPassword(t *testing.T) {
+	cases := []struct{ password, profile, pin string }{
+		{"x", "x", ""},
+		{"Kids", "Kids", ""},
+		{"Kids:1234", "Kids", "1234"},
+		{"", "", ""},
+	}
+	for _, tc := range cases {
+		profile, pin := splitXtreamPassword(tc.password)
+		if profile != tc.profile || pin != tc.pin {
+			t.Errorf("splitXtreamPassword(%q) = %q, %q; want %q, %q", tc.password, profile, pin, tc.profile, tc.pin)
+		}
+	}
+}
+
+// TestXtreamStreamIDPart verifies container extensions are stripped from stream URLs.
+func TestXtreamStreamIDPart(t *testing.T) {
+	for seg, want := range map[string]string{"100.m3u8": "100", "42.ts": "42", "7.mp4": "7", "9": "9"} {
+		if got := xtreamStreamIDPart(seg); got != want {
+			t.Errorf("xtreamStreamIDPart(%q) = %q, want %q", seg, got, want)
+		}
+	}
+}
+
+// TestRebasePlaylist verifies playout segment URIs resolve under the Xtream
+// stream path, next to the playlist.
+func TestRebasePlaylist(t *testing.T) {
+	in := "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:41\n#EXTINF:6.000,\nlive/41.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:6.000,\nlive/42.ts\n"
+	want := "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:41\n#EXTINF:6.000,\n100003/41.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:6.000,\n100003/42.ts\n"
+	if got := string(rebasePlaylist([]byte(in), "100003/")); got != want {
+		t.Errorf("rebasePlaylist =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestParseTimeshiftStart verifies the Xtream timeshift start formats, read as UTC.
+func TestParseTimeshiftStart(t *testing.T) {
+	want := time.Date(2026, 3, 1, 20, 30, 0, 0, time.UTC)
+	for _, in := range []string{"2026-03-01:20-30", "2026-03-01:20-30-00", "2026-03-01 20:30"} {
+		got, err := parseTimeshiftStart(in)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseTimeshiftStart(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseTimeshiftStart("yesterday"); err == nil {
+		t.Error("expected error for invalid start")
+	}
+}
+
+// TestXtreamDuration verifies the HH:MM:SS duration shown by players.
+func TestXtreamDuration(t *testing.T) {
+	if got := xtreamDuration(5425); got != "01:30:25" {
+		t.Errorf("xtreamDuration(5425) = %q, want 01:30:25", got)
+	}
+}
+
+// TestXMLTVProgrammeShape verifies the XMLTV programme element.
+func TestXMLTVProgrammeShape(t *testing.T) {
+	start := time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)
+	p := xmltvProgrammeFor(xmltvChannelID("", "espn"), "Game <Night>", "", "Sports", "TV-PG", "en",
+		start, start.Add(time.Hour), true, programDetails{})
+	b, err := xml.Marshal(p)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		`<programme start="20260301200000 +0000" stop="20260301210000 +0000" channel="espn">`,
+		`<title lang="en">Game &lt;Night&gt;</title>`,
+		`<category lang="en">Sports</category>`,
+		`<rating system="VCHIP"><value>TV-PG</value></rating>`,
+		`<new></new>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("programme XML missing %s:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "<desc") {
+		t.Errorf("empty description should be omitted:\n%s", got)
+	}
+	if xmltvChannelID("espn.us", "espn") != "espn.us" {
+		t.Error("EPG channel id should win over slug")
+	}
+}
+
+// TestXMLTVProgrammeDetails verifies episode, credit and flag elements, in
+// DTD order.
+func TestXMLTVProgrammeDetails(t *testing.T) {
+	start := time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)
+	p := xmltvProgrammeFor("wabc.us", "The Show", "It begins.", "Drama", "", "en",
+		start, start.Add(time.Hour), true, programDetails{
+			SubTitle:      "Pilot",
+			Categories:    []string{"Drama", "Series"},
+			SeasonNumber:  2,
+			EpisodeNumber: 5,
+			DDProgID:      "EP01234567.0005",
+			Credits: []programCredit{
+				{Role: "presenter", Name: "Pat Host"},
+				{Role: "actor", Name: "Alex Actor", Character: "Sam"},
+			},
+			AirDate:         "2019-03-04",
+			PreviouslyShown: true,
+			StarRating:      "3/4",
+		})
+	b, err := xml.Marshal(p)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	got := string(b)
+	want := []string{
+		`<title lang="en">The Show</title>`,
+		`<sub-title lang="en">Pilot</sub-title>`,
+		`<desc lang="en">It begins.</desc>`,
+		`<credits><actor role="Sam">Alex Actor</actor><presenter>Pat Host</presenter></credits>`,
+		`<date>20190304</date>`,
+		`<category lang="en">Drama</category><category lang="en">Series</category>`,
+		`<episode-num system="xmltv_ns">1.4.</episode-num>`,
+		`<episode-num system="onscreen">S02E05</episode-num>`,
+		`<episode-num system="dd_progid">EP01234567.0005</episode-num>`,
+		`<previously-shown></previously-shown>`,
+		`<new></new>`,
+		`<star-rating><value>3/4</value></star-rating>`,
+	}
+	last := -1
+	for _, w := range want {
+		i := strings.Index(got, w)
+		if i < 0 {
+			t.Errorf("programme XML missing %s:\n%s", w, got)
+			continue
+		}
+		if i < last {
+			t.Errorf("%s out of DTD order:\n%s", w, got)
+		}
+		last = i
+	}
+	if strings.Contains(got, "<premiere") {
+		t.Errorf("premiere flag not set but written:\n%s", got)
+	}
+}
+
 // ---- Rate limiter tests -----------------------------------------------------
 
-// mockRateLimitStore is an in-memory implementation of RateLimitStore for testing.
+// mockRateLimitStore is an in-memory implementation of RateLimitStore for
+// testing. Like Redis, it fails calls made with a cancelled context.
 type mockRateLimitStore struct {
-	counts map[string]int64
-	ttls   map[string]time.Duration
-	vals   map[string]string
+	mu   sync.Mutex
+	ttls map[string]time.Duration
+	vals map[string]string
 }
 
 func newMockStore() *mockRateLimitStore {
 	return &mockRateLimitStore{
-		counts: map[string]int64{},
-		ttls:   map[string]time.Duration{},
-		vals:   map[string]string{},
+		ttls: map[string]time.Duration{},
+		vals: map[string]string{},
+	}
+}
+
+func (m *mockRateLimitStore) add(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, _ := strconv.ParseInt(m.vals[key], 10, 64)
+	n += delta
+	m.vals[key] = strconv.FormatInt(n, 10)
+	return n, nil
 }
 
-func (m *mockRateLimitStore) Incr(_ context.Context, key string) (int64, error) {
-	m.counts[key]++
-	return m.counts[key], nil
+func (m *mockRateLimitStore) Incr(ctx context.Context, key string) (int64, error) {
+	return m.add(ctx, key, 1)
 }
 
-func (m *mockRateLimitStore) Expire(_ context.Context, key string, ttl time.Duration) error {
+func (m *mockRateLimitStore) Decr(ctx context.Context, key string) (int64, error) {
+	return m.add(ctx, key, -1)
+}
+
+func (m *mockRateLimitStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.ttls[key] = ttl
 	return nil
 }
 
-func (m *mockRateLimitStore) Get(_ context.Context, key string) (string, error) {
+func (m *mockRateLimitStore) Get(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if v, ok := m.vals[key]; ok {
 		return v, nil
 	}
 	return "", fmt.Errorf("key not found")
 }
 
-func (m *mockRateLimitStore) Set(_ context.Context, key string, value interface{}, _ time.Duration) error {
+func (m *mockRateLimitStore) Set(ctx context.Context, key string, value interface{}, _ time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.vals[key] = fmt.Sprintf("%v", value)
 	return nil
 }
 
-func (m *mockRateLimitStore) Del(_ context.Context, keys ...string) error {
+func (m *mockRateLimitStore) Del(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for _, k := range keys {
-		delete(m.counts, k)
 		delete(m.vals, k)
 		delete(m.ttls, k)
 	}
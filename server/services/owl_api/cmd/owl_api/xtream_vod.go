@@ -0,0 +1,490 @@
+// xtream_vod.go — Xtream Codes movie and series endpoints.
+//
+// Movies and series come from vod_catalog; episodes from vod_series +
+// vod_episodes. Xtream IDs are the integer xtream_id columns (migration 083).
+// Categories are vod_catalog.genre values, numbered over every active genre
+// of that type so IDs agree across profiles and requests.
+//
+// Endpoints:
+//
+//	GET /player_api.php?...&action=get_vod_categories
+//	GET /player_api.php?...&action=get_vod_streams[&category_id=N]
+//	GET /player_api.php?...&action=get_vod_info&vod_id=N
+//	GET /player_api.php?...&action=get_series_categories
+//	GET /player_api.php?...&action=get_series[&category_id=N]
+//	GET /player_api.php?...&action=get_series_info&series_id=N
+//	GET /movie/:username/:password/:vod_id.:ext
+//	GET /series/:username/:password/:episode_id.:ext
+//
+// The session profile's parental controls apply as on /owl/vod. Stream routes
+// redirect to the signed relay URL, exactly like /live/.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xtreamVODStream is one movie in get_vod_streams.
+type xtreamVODStream struct {
+	Num                int    `json:"num"`
+	Name               string `json:"name"`
+	StreamType         string `json:"stream_type"`
+	StreamID           int64  `json:"stream_id"`
+	StreamIcon         string `json:"stream_icon"`
+	Rating             string `json:"rating"`
+	Added              string `json:"added"`
+	CategoryID         string `json:"category_id"`
+	ContainerExtension string `json:"container_extension"`
+	CustomSID          string `json:"custom_sid"`
+	DirectSource       string `json:"direct_source"` // always empty — never expose source
+}
+
+// xtreamSeries is one show in get_series.
+type xtreamSeries struct {
+	Num          int      `json:"num"`
+	Name         string   `json:"name"`
+	SeriesID     int64    `json:"series_id"`
+	Cover        string   `json:"cover"`
+	Plot         string   `json:"plot"`
+	Genre        string   `json:"genre"`
+	ReleaseDate  string   `json:"releaseDate"`
+	LastModified string   `json:"last_modified"`
+	Rating       string   `json:"rating"`
+	BackdropPath []string `json:"backdrop_path"`
+	CategoryID   string   `json:"category_id"`
+}
+
+// xtreamEpisode is one episode in get_series_info.
+type xtreamEpisode struct {
+	ID                 string            `json:"id"`
+	EpisodeNum         int               `json:"episode_num"`
+	Title              string            `json:"title"`
+	ContainerExtension string            `json:"container_extension"`
+	Season             int               `json:"season"`
+	Added              string            `json:"added"`
+	DirectSource       string            `json:"direct_source"`
+	Info               xtreamEpisodeInfo `json:"info"`
+}
+
+type xtreamEpisodeInfo struct {
+	Plot         string `json:"plot,omitempty"`
+	MovieImage   string `json:"movie_image,omitempty"`
+	ReleaseDate  string `json:"releasedate,omitempty"`
+	DurationSecs int    `json:"duration_secs"`
+	Duration     string `json:"duration"`
+}
+
+// xtreamDuration formats seconds as the HH:MM:SS string Xtream players show.
+func xtreamDuration(secs int) string {
+	return fmt.Sprintf("%02d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+}
+
+// vodVisibleClause combines the profile's rating and genre filters for
+// vod_catalog. Returns "true" when the profile sees everything.
+func vodVisibleClause(rp *profileRestrictions, args *[]interface{}) string {
+	var clauses []string
+	for _, c := range []string{rp.ratingClause("rating", args), rp.genreClause("genre", args)} {
+		if c != "" {
+			clauses = append(clauses, c)
+		}
+	}
+	if len(clauses) == 0 {
+		return "true"
+	}
+	return strings.Join(clauses, " AND ")
+}
+
+// xtreamGenreCategories numbers the active genres of a vod_catalog type and
+// reports which have at least one title visible to the profile.
+func (s *server) xtreamGenreCategories(r *http.Request, rp *profileRestrictions, vodType string) (ids map[string]string, cats []xtreamCategory, err error) {
+	args := []interface{}{vodType}
+	visible := vodVisibleClause(rp, &args)
+	rows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT genre, count(*) FILTER (WHERE %s)
+		FROM vod_catalog
+		WHERE is_active = true AND type = $1 AND genre IS NOT NULL AND genre != ''
+		GROUP BY genre
+		ORDER BY genre ASC
+	`, visible), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	ids = map[string]string{}
+	cats = []xtreamCategory{}
+	i := 1
+	for rows.Next() {
+		var genre string
+		var cnt int
+		if err := rows.Scan(&genre, &cnt); err != nil {
+			continue
+		}
+		id := strconv.Itoa(i)
+		ids[genre] = id
+		if cnt > 0 {
+			cats = append(cats, xtreamCategory{CategoryID: id, CategoryName: genre})
+		}
+		i++
+	}
+	return ids, cats, rows.Err()
+}
+
+// xtreamVODCategories serves get_vod_categories and get_series_categories.
+func (s *server) xtreamVODCategories(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, vodType string) {
+	_, cats, err := s.xtreamGenreCategories(r, rp, vodType)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch categories")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(cats)
+}
+
+// xtreamCatalogRows queries visible vod_catalog rows of a type, optionally
+// limited to one Xtream category. The returned map resolves genre → category_id.
+func (s *server) xtreamCatalogRows(r *http.Request, rp *profileRestrictions, vodType, categoryID string) (*sql.Rows, map[string]string, error) {
+	ids, _, err := s.xtreamGenreCategories(r, rp, vodType)
+	if err != nil {
+		return nil, nil, err
+	}
+	args := []interface{}{vodType}
+	where := "is_active = true AND type = $1 AND xtream_id IS NOT NULL AND " + vodVisibleClause(rp, &args)
+	if categoryID != "" {
+		genre := ""
+		for g, id := range ids {
+			if id == categoryID {
+				genre = g
+			}
+		}
+		args = append(args, genre)
+		where += fmt.Sprintf(" AND genre = $%d", len(args))
+	}
+	rows, err := s.db.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT xtream_id, title, slug, coalesce(genre, ''), coalesce(description, ''),
+		       coalesce(poster_url, ''), coalesce(backdrop_url, ''),
+		       coalesce(release_year, 0), created_at, updated_at
+		FROM vod_catalog
+		WHERE %s
+		ORDER BY sort_order ASC, title ASC
+	`, where), args...)
+	return rows, ids, err
+}
+
+// xtreamVODStreams serves get_vod_streams.
+func (s *server) xtreamVODStreams(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, categoryID string) {
+	rows, ids, err := s.xtreamCatalogRows(r, rp, "movie", categoryID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch movies")
+		return
+	}
+	defer rows.Close()
+
+	streams := []xtreamVODStream{}
+	for rows.Next() {
+		var id int64
+		var title, slug, genre, desc, poster, backdrop string
+		var year int
+		var created, updated time.Time
+		if err := rows.Scan(&id, &title, &slug, &genre, &desc, &poster, &backdrop,
+			&year, &created, &updated); err != nil {
+			continue
+		}
+		streams = append(streams, xtreamVODStream{
+			Num:                len(streams) + 1,
+			Name:               title,
+			StreamType:         "movie",
+			StreamID:           id,
+			StreamIcon:         poster,
+			Added:              strconv.FormatInt(created.Unix(), 10),
+			CategoryID:         ids[genre],
+			ContainerExtension: "m3u8",
+			CustomSID:          slug,
+			DirectSource:       "", // NEVER expose source URL
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(streams)
+}
+
+// xtreamSeriesList serves get_series.
+func (s *server) xtreamSeriesList(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, categoryID string) {
+	rows, ids, err := s.xtreamCatalogRows(r, rp, "series", categoryID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch series")
+		return
+	}
+	defer rows.Close()
+
+	series := []xtreamSeries{}
+	for rows.Next() {
+		var id int64
+		var title, slug, genre, desc, poster, backdrop string
+		var year int
+		var created, updated time.Time
+		if err := rows.Scan(&id, &title, &slug, &genre, &desc, &poster, &backdrop,
+			&year, &created, &updated); err != nil {
+			continue
+		}
+		sr := xtreamSeries{
+			Num:          len(series) + 1,
+			Name:         title,
+			SeriesID:     id,
+			Cover:        poster,
+			Plot:         desc,
+			Genre:        genre,
+			LastModified: strconv.FormatInt(updated.Unix(), 10),
+			BackdropPath: []string{},
+			CategoryID:   ids[genre],
+		}
+		if year > 0 {
+			sr.ReleaseDate = fmt.Sprintf("%d-01-01", year)
+		}
+		if backdrop != "" {
+			sr.BackdropPath = []string{backdrop}
+		}
+		series = append(series, sr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(series)
+}
+
+// xtreamCatalogItem loads one visible vod_catalog row by xtream_id. found is
+// false (and a response written) when it is missing or blocked.
+func (s *server) xtreamCatalogItem(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, vodType, idStr string) (id string, found bool) {
+	xid, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "id must be integer")
+		return "", false
+	}
+	var rating, genre sql.NullString
+	err = s.db.QueryRowContext(r.Context(), `
+		SELECT id, rating, genre FROM vod_catalog
+		WHERE xtream_id = $1 AND type = $2 AND is_active = true
+	`, xid, vodType).Scan(&id, &rating, &genre)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "not_found", "Content not found")
+		return "", false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Content lookup failed")
+		return "", false
+	}
+	if reason := blockedReason(rp, libSplitGenres(genre.String), rating.String, true); reason != "" {
+		writeParentalBlocked(w, rp, reason)
+		return "", false
+	}
+	return id, true
+}
+
+// xtreamVODInfo serves get_vod_info.
+func (s *server) xtreamVODInfo(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, vodIDStr string) {
+	id, ok := s.xtreamCatalogItem(w, r, rp, "movie", vodIDStr)
+	if !ok {
+		return
+	}
+	var xid int64
+	var title, desc, genre, rating, poster, backdrop string
+	var year, dur int
+	var created time.Time
+	err := s.db.QueryRowContext(r.Context(), `
+		SELECT xtream_id, title, coalesce(description, ''), coalesce(genre, ''), coalesce(rating, ''),
+		       coalesce(poster_url, ''), coalesce(backdrop_url, ''),
+		       coalesce(release_year, 0), coalesce(duration_seconds, 0), created_at
+		FROM vod_catalog WHERE id = $1
+	`, id).Scan(&xid, &title, &desc, &genre, &rating, &poster, &backdrop, &year, &dur, &created)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Content lookup failed")
+		return
+	}
+
+	info := map[string]interface{}{
+		"name":          title,
+		"plot":          desc,
+		"genre":         genre,
+		"age":           rating,
+		"movie_image":   poster,
+		"backdrop_path": []string{},
+		"duration_secs": dur,
+		"duration":      xtreamDuration(dur),
+	}
+	if backdrop != "" {
+		info["backdrop_path"] = []string{backdrop}
+	}
+	if year > 0 {
+		info["releasedate"] = fmt.Sprintf("%d-01-01", year)
+	}
+	ids, _, _ := s.xtreamGenreCategories(r, rp, "movie")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"info": info,
+		"movie_data": map[string]interface{}{
+			"stream_id":           xid,
+			"name":                title,
+			"added":               strconv.FormatInt(created.Unix(), 10),
+			"category_id":         ids[genre],
+			"container_extension": "m3u8",
+			"direct_source":       "",
+		},
+	})
+}
+
+// xtreamSeriesInfo serves get_series_info: seasons plus episodes keyed by season number.
+func (s *server) xtreamSeriesInfo(w http.ResponseWriter, r *http.Request, rp *profileRestrictions, seriesIDStr string) {
+	id, ok := s.xtreamCatalogItem(w, r, rp, "series", seriesIDStr)
+	if !ok {
+		return
+	}
+	var title, desc, genre, poster, backdrop string
+	var year int
+	err := s.db.QueryRowContext(r.Context(), `
+		SELECT title, coalesce(description, ''), coalesce(genre, ''),
+		       coalesce(poster_url, ''), coalesce(backdrop_url, ''), coalesce(release_year, 0)
+		FROM vod_catalog WHERE id = $1
+	`, id).Scan(&title, &desc, &genre, &poster, &backdrop, &year)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Content lookup failed")
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT vs.season_number, coalesce(vs.title, ''), coalesce(vs.poster_url, ''),
+		       e.xtream_id, e.episode_number, e.title, coalesce(e.description, ''),
+		       e.duration_seconds, coalesce(e.thumbnail_url, ''),
+		       coalesce(to_char(e.air_date, 'YYYY-MM-DD'), ''), e.created_at
+		FROM vod_series vs
+		JOIN vod_episodes e ON e.series_id = vs.id
+		WHERE vs.catalog_id = $1 AND e.xtream_id IS NOT NULL
+		ORDER BY vs.season_number, e.episode_number
+	`, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_error", "Failed to fetch episodes")
+		return
+	}
+	defer rows.Close()
+
+	type season struct {
+		SeasonNumber int    `json:"season_number"`
+		Name         string `json:"name"`
+		EpisodeCount int    `json:"episode_count"`
+		Cover        string `json:"cover"`
+	}
+	var seasons []season
+	episodes := map[string][]xtreamEpisode{}
+	for rows.Next() {
+		var sn int
+		var seasonTitle, seasonPoster string
+		var ep xtreamEpisode
+		var xid int64
+		var added time.Time
+		if err := rows.Scan(&sn, &seasonTitle, &seasonPoster, &xid, &ep.EpisodeNum, &ep.Title,
+			&ep.Info.Plot, &ep.Info.DurationSecs, &ep.Info.MovieImage, &ep.Info.ReleaseDate, &added); err != nil {
+			continue
+		}
+		if len(seasons) == 0 || seasons[len(seasons)-1].SeasonNumber != sn {
+			if seasonTitle == "" {
+				seasonTitle = fmt.Sprintf("Season %d", sn)
+			}
+			seasons = append(seasons, season{SeasonNumber: sn, Name: seasonTitle, Cover: seasonPoster})
+		}
+		seasons[len(seasons)-1].EpisodeCount++
+
+		ep.ID = strconv.FormatInt(xid, 10)
+		ep.Season = sn
+		ep.ContainerExtension = "m3u8"
+		ep.Added = strconv.FormatInt(added.Unix(), 10)
+		ep.Info.Duration = xtreamDuration(ep.Info.DurationSecs)
+		key := strconv.Itoa(sn)
+		episodes[key] = append(episodes[key], ep)
+	}
+	if seasons == nil {
+		seasons = []season{}
+	}
+
+	info := map[string]interface{}{
+		"name":          title,
+		"cover":         poster,
+		"plot":          desc,
+		"genre":         genre,
+		"backdrop_path": []string{},
+	}
+	if backdrop != "" {
+		info["backdrop_path"] = []string{backdrop}
+	}
+	if year > 0 {
+		info["releaseDate"] = fmt.Sprintf("%d-01-01", year)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"seasons":  seasons,
+		"info":     info,
+		"episodes": episodes,
+	})
+}
+
+// ---- handler: GET /movie/:username/:password/:vod_id.:ext -------------------
+// ---- handler: GET /series/:username/:password/:episode_id.:ext --------------
+
+// handleXtreamVODStream validates the credentials, resolves the movie or
+// episode and 302-redirects to its signed relay URL.
+func (s *server) handleXtreamVODStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET required")
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 4 {
+		writeError(w, http.StatusBadRequest, "invalid_path", "Expected /movie|series/:username/:password/:id.:ext")
+		return
+	}
+	_, rp, ok := s.xtreamAuth(w, r, parts[1], parts[2])
+	if !ok {
+		return
+	}
+	rawID := xtreamStreamIDPart(parts[3])
+
+	var contentID string
+	if parts[0] == "movie" {
+		if contentID, ok = s.xtreamCatalogItem(w, r, rp, "movie", rawID); !ok {
+			return
+		}
+	} else {
+		xid, err := strconv.ParseInt(rawID, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_stream_id", "stream_id must be integer")
+			return
+		}
+		var rating, genre sql.NullString
+		err = s.db.QueryRowContext(r.Context(), `
+			SELECT e.id, vc.rating, vc.genre
+			FROM vod_episodes e
+			JOIN vod_series vs ON vs.id = e.series_id
+			JOIN vod_catalog vc ON vc.id = vs.catalog_id AND vc.is_active = true
+			WHERE e.xtream_id = $1
+		`, xid).Scan(&contentID, &rating, &genre)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "not_found", "Episode not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal_error", "Episode lookup failed")
+			return
+		}
+		if reason := blockedReason(rp, libSplitGenres(genre.String), rating.String, true); reason != "" {
+			writeParentalBlocked(w, rp, reason)
+			return
+		}
+	}
+
+	// Same signed relay URL as /owl/vod/:id — source never exposed
+	streamURL, _ := signedStreamURL(contentID)
+	http.Redirect(w, r, streamURL, http.StatusFound)
+}
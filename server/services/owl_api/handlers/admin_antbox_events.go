@@ -0,0 +1,431 @@
+// admin_antbox_events.go — Scheduled AntBox captures (antbox_events).
+//
+// An admin schedules an event on an AntBox: a channel and a time window.
+// RunAntBoxEvents sends START_EVENT when the window opens and STOP_EVENT when
+// it ends, over the device's /antbox/connect WebSocket. The device then
+// uploads the capture (admin_antbox_ingest.go).
+//
+// A scheduled event whose device is offline, or has no free tuner, is retried
+// on every sweep until its window ends, then marked failed with the last
+// reason. Cancelling a capturing event ends its window now so the next sweep
+// stops it.
+//
+// Scheduling is refused (409 schedule_conflict) when the box's tuners are
+// already taken for part of the window by other events (admin_tuners.go).
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/unyeco/roost/services/owl_api/audit"
+	"github.com/unyeco/roost/services/owl_api/middleware"
+)
+
+// antboxCommandTimeout bounds one START_EVENT / STOP_EVENT round trip.
+const antboxCommandTimeout = 30 * time.Second
+
+// AntBoxEventRow is one event returned by GET /admin/antboxes/:id/events.
+type AntBoxEventRow struct {
+	ID          string     `json:"id"`
+	AntBoxID    string     `json:"antbox_id"`
+	Title       string     `json:"title"`
+	Channel     string     `json:"channel"`
+	DeviceIP    *string    `json:"device_ip,omitempty"`
+	TunerIndex  *int       `json:"tuner_index,omitempty"`
+	StartTime   time.Time  `json:"start_time"`
+	EndTime     time.Time  `json:"end_time"`
+	Status      string     `json:"status"`
+	Error       *string    `json:"error,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// ScheduleAntBoxEventRequest is the POST /admin/antboxes/:id/events body.
+// DeviceIP and TunerIndex are optional; without them a free tuner is picked
+// from the device's last heartbeat when the event starts.
+type ScheduleAntBoxEventRequest struct {
+	Title      string    `json:"title"`
+	Channel    string    `json:"channel"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	DeviceIP   *string   `json:"device_ip,omitempty"`
+	TunerIndex *int      `json:"tuner_index,omitempty"`
+}
+
+// parseAntBoxEventsPath splits /admin/antboxes/{id}/events[/{event_id}].
+func parseAntBoxEventsPath(path string) (boxID, eventID string, ok bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/admin/antboxes/"), "/"), "/")
+	if len(parts) < 2 || parts[1] != "events" || !isValidUUID(parts[0]) {
+		return "", "", false
+	}
+	switch len(parts) {
+	case 2:
+		return parts[0], "", true
+	case 3:
+		if isValidUUID(parts[2]) {
+			return parts[0], parts[2], true
+		}
+	}
+	return "", "", false
+}
+
+// AntBoxEventsAdmin routes GET/POST /admin/antboxes/:id/events and
+// DELETE /admin/antboxes/:id/events/:event_id.
+func (h *AdminHandlers) AntBoxEventsAdmin(w http.ResponseWriter, r *http.Request, al *audit.Logger) {
+	boxID, eventID, ok := parseAntBoxEventsPath(r.URL.Path)
+	if !ok {
+		http.Error(w, `{"error":"invalid antbox or event id"}`, http.StatusBadRequest)
+		return
+	}
+	switch {
+	case eventID == "" && r.Method == http.MethodGet:
+		h.listAntBoxEvents(w, r, boxID)
+	case eventID == "" && r.Method == http.MethodPost:
+		h.scheduleAntBoxEvent(w, r, al, boxID)
+	case eventID != "" && r.Method == http.MethodDelete:
+		h.cancelAntBoxEvent(w, r, al, boxID, eventID)
+	default:
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// listAntBoxEvents handles GET /admin/antboxes/:id/events.
+func (h *AdminHandlers) listAntBoxEvents(w http.ResponseWriter, r *http.Request, boxID string) {
+	claims := middleware.AdminClaimsFromCtx(r.Context())
+
+	rows, err := h.DB.QueryContext(r.Context(),
+		`SELECT id, antbox_id, title, channel, device_ip, tuner_index, start_time, end_time,
+		        status, error, started_at, completed_at
+		   FROM antbox_events
+		  WHERE antbox_id = $1 AND roost_id = $2
+		  ORDER BY start_time DESC
+		  LIMIT 200`,
+		boxID, claims.RoostID,
+	)
+	if err != nil {
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	events := []AntBoxEventRow{}
+	for rows.Next() {
+		var e AntBoxEventRow
+		if err := rows.Scan(&e.ID, &e.AntBoxID, &e.Title, &e.Channel, &e.DeviceIP, &e.TunerIndex,
+			&e.StartTime, &e.EndTime, &e.Status, &e.Error, &e.StartedAt, &e.CompletedAt); err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+	writeAdminJSON(w, http.StatusOK, events)
+}
+
+// scheduleAntBoxEvent handles POST /admin/antboxes/:id/events.
+func (h *AdminHandlers) scheduleAntBoxEvent(w http.ResponseWriter, r *http.Request, al *audit.Logger, boxID string) {
+	claims := middleware.AdminClaimsFromCtx(r.Context())
+
+	var req ScheduleAntBoxEventRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, `{"error":"invalid_body"}`, http.StatusBadRequest)
+		return
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	req.Channel = strings.TrimSpace(req.Channel)
+	if req.Title == "" || req.Channel == "" || req.StartTime.IsZero() || !req.EndTime.After(req.StartTime) {
+		http.Error(w, `{"error":"title, channel, start_time and a later end_time required"}`, http.StatusBadRequest)
+		return
+	}
+	if !req.EndTime.After(time.Now()) {
+		http.Error(w, `{"error":"end_time is in the past"}`, http.StatusBadRequest)
+		return
+	}
+	if req.TunerIndex != nil && *req.TunerIndex < 0 {
+		http.Error(w, `{"error":"tuner_index must not be negative"}`, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if err := lockTuners(r.Context(), tx, claims.RoostID); err != nil {
+		slog.Error("antbox events: tuner lock", "err", err)
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	var exists bool
+	if err := tx.QueryRowContext(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM antboxes WHERE id = $1 AND roost_id = $2 AND is_active = TRUE)`,
+		boxID, claims.RoostID,
+	).Scan(&exists); err != nil {
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, `{"error":"antbox not found"}`, http.StatusNotFound)
+		return
+	}
+	conflict, _, err := admitTunerDemand(r.Context(), tx, claims.RoostID, tunerDemand{
+		ID: "new-event", Kind: tunerDemandCapture, Title: req.Title,
+		Start: req.StartTime, End: req.EndTime,
+		SourceKind: tunerKindAntBox, SourceID: boxID,
+	})
+	if err != nil {
+		slog.Error("antbox events: tuner admission", "err", err)
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	if conflict != nil {
+		conflict.ID = ""
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"error":    "schedule_conflict",
+			"conflict": conflict,
+		})
+		return
+	}
+
+	var eventID string
+	err = tx.QueryRowContext(r.Context(),
+		`INSERT INTO antbox_events
+		        (roost_id, antbox_id, title, channel, device_ip, tuner_index, start_time, end_time, created_by)
+		 VALUES ($2, $1, $3, $4, NULLIF($5, ''), $6, $7, $8, $9)
+		 RETURNING id`,
+		boxID, claims.RoostID, req.Title, req.Channel, derefString(req.DeviceIP), req.TunerIndex,
+		req.StartTime, req.EndTime, claims.UserID,
+	).Scan(&eventID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		slog.Error("antbox events: schedule failed", "err", err)
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	al.Log(r, claims.RoostID, claims.UserID, "antbox.event_schedule", eventID,
+		map[string]any{"antbox_id": boxID, "channel": req.Channel, "title": req.Title},
+	)
+	writeAdminJSON(w, http.StatusCreated, map[string]string{"id": eventID, "status": "scheduled"})
+}
+
+// cancelAntBoxEvent handles DELETE /admin/antboxes/:id/events/:event_id.
+// A scheduled event is cancelled; a capturing one has its window ended now
+// so the next sweep sends STOP_EVENT.
+func (h *AdminHandlers) cancelAntBoxEvent(w http.ResponseWriter, r *http.Request, al *audit.Logger, boxID, eventID string) {
+	claims := middleware.AdminClaimsFromCtx(r.Context())
+
+	var status string
+	err := h.DB.QueryRowContext(r.Context(),
+		`UPDATE antbox_events
+		    SET status   = CASE WHEN status = 'scheduled' THEN 'cancelled' ELSE status END,
+		        end_time = CASE WHEN status = 'capturing' THEN GREATEST(start_time + INTERVAL '1 second', NOW())
+		                        ELSE end_time END
+		  WHERE id = $1 AND antbox_id = $2 AND roost_id = $3
+		    AND status IN ('scheduled', 'capturing')
+		 RETURNING status`,
+		eventID, boxID, claims.RoostID,
+	).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, `{"error":"no scheduled or capturing event with that id"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	al.Log(r, claims.RoostID, claims.UserID, "antbox.event_cancel", eventID,
+		map[string]any{"antbox_id": boxID})
+	writeAdminJSON(w, http.StatusOK, map[string]string{"id": eventID, "status": status})
+}
+
+// pickAntBoxTuner chooses the tuner for an event from a heartbeat: the
+// requested device and tuner when set, otherwise the first idle tuner on an
+// online device (limited to the requested device when only that is set).
+func pickAntBoxTuner(report *AntBoxReport, deviceIP string, tunerIndex *int) (string, int, error) {
+	for _, d := range report.Devices {
+		if !d.Online || d.IP == "" || (deviceIP != "" && d.IP != deviceIP) {
+			continue
+		}
+		if tunerIndex != nil {
+			return d.IP, *tunerIndex, nil
+		}
+		for _, t := range d.Tuners {
+			if !t.Active {
+				return d.IP, t.Index, nil
+			}
+		}
+	}
+	if deviceIP != "" {
+		return "", 0, fmt.Errorf("device %s offline or has no idle tuner", deviceIP)
+	}
+	return "", 0, fmt.Errorf("no idle tuner")
+}
+
+// RunAntBoxEvents starts and stops scheduled AntBox events each interval.
+// Blocks until ctx is cancelled.
+func (h *AdminHandlers) RunAntBoxEvents(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		h.sweepAntBoxEvents(ctx)
+	}
+}
+
+// antboxEventDue is an event the sweep must start or stop.
+type antboxEventDue struct {
+	id, roostID, antboxID, channel, deviceIP string
+	tunerIndex                               *int
+}
+
+func (h *AdminHandlers) sweepAntBoxEvents(ctx context.Context) {
+	if h.AntBoxes == nil {
+		return
+	}
+
+	// Events whose window ended before they could start.
+	if _, err := h.DB.ExecContext(ctx,
+		`UPDATE antbox_events
+		    SET status = 'failed', error = COALESCE(error, 'not started before end_time')
+		  WHERE status = 'scheduled' AND end_time <= NOW()`,
+	); err != nil {
+		slog.Warn("antbox events: expire failed", "err", err)
+	}
+
+	for _, e := range h.dueAntBoxEvents(ctx, `status = 'scheduled' AND start_time <= NOW()`) {
+		h.startAntBoxEvent(ctx, e)
+	}
+	for _, e := range h.dueAntBoxEvents(ctx, `status = 'capturing' AND end_time <= NOW()`) {
+		h.stopAntBoxEvent(ctx, e)
+	}
+}
+
+// dueAntBoxEvents returns the events matching where.
+func (h *AdminHandlers) dueAntBoxEvents(ctx context.Context, where string) []antboxEventDue {
+	rows, err := h.DB.QueryContext(ctx,
+		`SELECT id, roost_id, antbox_id, channel, COALESCE(device_ip, ''), tuner_index
+		   FROM antbox_events
+		  WHERE `+where+`
+		  ORDER BY start_time`)
+	if err != nil {
+		slog.Warn("antbox events: query failed", "err", err)
+		return nil
+	}
+	defer rows.Close()
+	var due []antboxEventDue
+	for rows.Next() {
+		var e antboxEventDue
+		if err := rows.Scan(&e.id, &e.roostID, &e.antboxID, &e.channel, &e.deviceIP, &e.tunerIndex); err != nil {
+			continue
+		}
+		due = append(due, e)
+	}
+	return due
+}
+
+// startAntBoxEvent sends START_EVENT. Failures are stored on the event and
+// retried by the next sweep.
+func (h *AdminHandlers) startAntBoxEvent(ctx context.Context, e antboxEventDue) {
+	fail := func(reason string) {
+		if _, err := h.DB.ExecContext(ctx,
+			`UPDATE antbox_events SET error = $2 WHERE id = $1 AND status = 'scheduled'`,
+			e.id, reason,
+		); err != nil {
+			slog.Warn("antbox events: store error failed", "event_id", e.id, "err", err)
+		}
+	}
+
+	report, _, ok := h.AntBoxes.LastReport(e.roostID, e.antboxID)
+	if !ok {
+		fail("antbox offline")
+		return
+	}
+	deviceIP, tuner, err := pickAntBoxTuner(report, e.deviceIP, e.tunerIndex)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, antboxCommandTimeout)
+	defer cancel()
+	resp, err := h.AntBoxes.SendCommand(cctx, e.roostID, e.antboxID, AntBoxCmdStartEvent, map[string]any{
+		"event_id":    e.id,
+		"device_ip":   deviceIP,
+		"tuner_index": tuner,
+		"channel":     e.channel,
+	})
+	if err != nil {
+		fail("start_event: " + err.Error())
+		return
+	}
+	// A retried START_EVENT the device already acted on is a success.
+	if !resp.Success && !strings.Contains(resp.Error, "already active") {
+		slog.Warn("antbox events: device refused start", "event_id", e.id, "error", resp.Error)
+		fail(resp.Error)
+		return
+	}
+
+	if _, err := h.DB.ExecContext(ctx,
+		`UPDATE antbox_events
+		    SET status = 'capturing', started_at = NOW(), error = NULL,
+		        device_ip = $2, tuner_index = $3
+		  WHERE id = $1 AND status = 'scheduled'`,
+		e.id, deviceIP, tuner,
+	); err != nil {
+		slog.Error("antbox events: mark capturing failed", "event_id", e.id, "err", err)
+		return
+	}
+	slog.Info("antbox events: capture started", "event_id", e.id, "antbox_id", e.antboxID, "channel", e.channel)
+}
+
+// stopAntBoxEvent sends STOP_EVENT. The event stays capturing, and is retried
+// by the next sweep, until the device has acknowledged it.
+func (h *AdminHandlers) stopAntBoxEvent(ctx context.Context, e antboxEventDue) {
+	cctx, cancel := context.WithTimeout(ctx, antboxCommandTimeout)
+	defer cancel()
+	resp, err := h.AntBoxes.SendCommand(cctx, e.roostID, e.antboxID, AntBoxCmdStopEvent,
+		map[string]any{"event_id": e.id})
+	if err != nil {
+		slog.Warn("antbox events: stop_event failed", "event_id", e.id, "err", err)
+		return
+	}
+	// "not active": the device lost the event (e.g. it restarted); it will
+	// not upload more, so there is nothing left to stop.
+	if !resp.Success && !strings.Contains(resp.Error, "not active") {
+		slog.Warn("antbox events: device refused stop", "event_id", e.id, "error", resp.Error)
+		return
+	}
+
+	if _, err := h.DB.ExecContext(ctx,
+		`UPDATE antbox_events SET status = 'stopping' WHERE id = $1 AND status = 'capturing'`,
+		e.id,
+	); err != nil {
+		slog.Error("antbox events: mark stopping failed", "event_id", e.id, "err", err)
+		return
+	}
+	slog.Info("antbox events: capture stopped", "event_id", e.id, "antbox_id", e.antboxID)
+}
+
+// derefString returns *s, or "" for nil.
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
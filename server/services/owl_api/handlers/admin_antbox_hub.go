@@ -0,0 +1,396 @@
+// admin_antbox_hub.go — Persistent AntBox device connections.
+//
+// Each AntBox daemon holds one WebSocket open to GET /antbox/connect,
+// authenticated with X-AntBox-ID (antboxes.id) and Authorization: Bearer
+// <token>, checked against the bcrypt hash in antboxes.antbox_token.
+//
+// Messages are JSON envelopes {"type": ..., "data": ...}:
+//
+//	hello     device → server  {antbox_id, version} → antboxes.firmware_version
+//	heartbeat device → server  status report → antboxes.last_seen_at / tuner_count
+//	command   server → device  {id, type, payload, timestamp}
+//	response  device → server  {command_id, success, data, error, timestamp}
+//
+// SCAN_CHANNELS is sent by POST /admin/antboxes/scan-channels, START_EVENT and
+// STOP_EVENT by the event sweep (admin_antbox_events.go).
+//
+// The latest heartbeat is kept in memory so the admin panel can read tuner
+// signal without a round trip to the device.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/unyeco/roost/pkg/wsconn"
+)
+
+// AntBox command types understood by the daemon.
+const (
+	AntBoxCmdScanChannels = "SCAN_CHANNELS"
+	AntBoxCmdStartEvent   = "START_EVENT"
+	AntBoxCmdStopEvent    = "STOP_EVENT"
+	AntBoxCmdHealth       = "HEALTH"
+	AntBoxCmdUpdate       = "UPDATE"
+)
+
+const (
+	antboxPingInterval = 30 * time.Second
+	// antboxReadTimeout drops a device that sent nothing (not even a pong)
+	// for this long.
+	antboxReadTimeout = 90 * time.Second
+)
+
+// ErrAntBoxOffline is returned by SendCommand when the device is not connected.
+var ErrAntBoxOffline = errors.New("antbox offline")
+
+// errAntBoxUnauthorized is returned by authenticateAntBox for bad credentials.
+var errAntBoxUnauthorized = errors.New("antbox unauthorized")
+
+// AntBoxTunerStatus is one tuner in an AntBox heartbeat.
+type AntBoxTunerStatus struct {
+	Index          int    `json:"index"`
+	Active         bool   `json:"active"`
+	Channel        string `json:"channel,omitempty"`
+	SignalStrength int    `json:"signal_strength"`
+}
+
+// AntBoxDeviceStatus is one HDHomeRun device in an AntBox heartbeat.
+type AntBoxDeviceStatus struct {
+	DeviceID string              `json:"device_id"`
+	IP       string              `json:"ip"`
+	Online   bool                `json:"online"`
+	Tuners   []AntBoxTunerStatus `json:"tuners"`
+}
+
+// AntBoxReport is the heartbeat payload sent by the daemon.
+type AntBoxReport struct {
+	AntBoxID     string               `json:"antbox_id"`
+	Timestamp    time.Time            `json:"timestamp"`
+	Uptime       string               `json:"uptime"`
+	Devices      []AntBoxDeviceStatus `json:"devices"`
+	ActiveEvents int                  `json:"active_events"`
+	ScannerState string               `json:"scanner_state"`
+}
+
+// tunerCount returns the total number of tuners across online devices.
+func (r *AntBoxReport) tunerCount() int {
+	n := 0
+	for _, d := range r.Devices {
+		if d.Online {
+			n += len(d.Tuners)
+		}
+	}
+	return n
+}
+
+// AntBoxResponse is a device's reply to a command.
+type AntBoxResponse struct {
+	CommandID string          `json:"command_id"`
+	Success   bool            `json:"success"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+type antboxEnvelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type antboxCommand struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// antboxConn is one live device connection.
+type antboxConn struct {
+	id      string
+	roostID string
+	ws      *wsconn.Conn
+
+	mu       sync.Mutex
+	pending  map[string]chan AntBoxResponse
+	report   *AntBoxReport
+	reportAt time.Time
+}
+
+// AntBoxHub tracks connected AntBox devices.
+type AntBoxHub struct {
+	mu    sync.RWMutex
+	conns map[string]*antboxConn // keyed by antboxes.id
+}
+
+// NewAntBoxHub creates an empty hub.
+func NewAntBoxHub() *AntBoxHub {
+	return &AntBoxHub{conns: make(map[string]*antboxConn)}
+}
+
+// get returns the live connection for an antbox in roostID, or nil.
+func (hub *AntBoxHub) get(roostID, antboxID string) *antboxConn {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	c := hub.conns[antboxID]
+	if c == nil || c.roostID != roostID {
+		return nil
+	}
+	return c
+}
+
+// Connected returns the ids of connected antboxes in roostID.
+func (hub *AntBoxHub) Connected(roostID string) []string {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	var ids []string
+	for id, c := range hub.conns {
+		if c.roostID == roostID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// LastReport returns the latest heartbeat from a connected antbox.
+func (hub *AntBoxHub) LastReport(roostID, antboxID string) (*AntBoxReport, time.Time, bool) {
+	c := hub.get(roostID, antboxID)
+	if c == nil {
+		return nil, time.Time{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.report == nil {
+		return nil, time.Time{}, false
+	}
+	return c.report, c.reportAt, true
+}
+
+// SendCommand sends a command to a connected antbox and waits for its
+// response or ctx cancellation.
+func (hub *AntBoxHub) SendCommand(ctx context.Context, roostID, antboxID, cmdType string, payload any) (AntBoxResponse, error) {
+	c := hub.get(roostID, antboxID)
+	if c == nil {
+		return AntBoxResponse{}, ErrAntBoxOffline
+	}
+
+	var raw json.RawMessage
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return AntBoxResponse{}, err
+		}
+		raw = b
+	}
+	cmd := antboxCommand{ID: newUUID(), Type: cmdType, Payload: raw, Timestamp: time.Now().UTC()}
+	ch := make(chan AntBoxResponse, 1)
+	c.mu.Lock()
+	c.pending[cmd.ID] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, cmd.ID)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send("command", cmd); err != nil {
+		return AntBoxResponse{}, fmt.Errorf("send command: %w", err)
+	}
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return AntBoxResponse{}, ErrAntBoxOffline
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return AntBoxResponse{}, ctx.Err()
+	}
+}
+
+// send writes one envelope to the device.
+func (c *antboxConn) send(msgType string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(antboxEnvelope{Type: msgType, Data: data})
+	if err != nil {
+		return err
+	}
+	return c.ws.WriteText(payload)
+}
+
+// register installs c, closing any previous connection for the same device.
+func (hub *AntBoxHub) register(c *antboxConn) {
+	hub.mu.Lock()
+	old := hub.conns[c.id]
+	hub.conns[c.id] = c
+	hub.mu.Unlock()
+	if old != nil {
+		old.ws.Close()
+	}
+}
+
+// unregister removes c and fails its pending commands.
+func (hub *AntBoxHub) unregister(c *antboxConn) {
+	hub.mu.Lock()
+	if hub.conns[c.id] == c {
+		delete(hub.conns, c.id)
+	}
+	hub.mu.Unlock()
+
+	c.mu.Lock()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+}
+
+// authenticateAntBox checks the device id and bearer token against antboxes.
+// Returns the device's roost_id.
+func authenticateAntBox(ctx context.Context, db *sql.DB, r *http.Request) (string, error) {
+	id := r.Header.Get("X-AntBox-ID")
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !isValidUUID(id) || !ok || token == "" {
+		return "", errAntBoxUnauthorized
+	}
+	var roostID, hash string
+	err := db.QueryRowContext(ctx,
+		`SELECT roost_id, antbox_token FROM antboxes WHERE id = $1 AND is_active = TRUE`,
+		id,
+	).Scan(&roostID, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errAntBoxUnauthorized
+	}
+	if err != nil {
+		return "", err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(token)) != nil {
+		return "", errAntBoxUnauthorized
+	}
+	return roostID, nil
+}
+
+// AntBoxConnect handles GET /antbox/connect (WebSocket, device-authenticated).
+func (h *AdminHandlers) AntBoxConnect(w http.ResponseWriter, r *http.Request) {
+	if h.AntBoxes == nil {
+		http.Error(w, `{"error":"antbox connections not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+	roostID, err := authenticateAntBox(r.Context(), h.DB, r)
+	if errors.Is(err, errAntBoxUnauthorized) {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		slog.Error("antbox connect: db error", "err", err)
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	ws, err := wsconn.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	c := &antboxConn{
+		id:      r.Header.Get("X-AntBox-ID"),
+		roostID: roostID,
+		ws:      ws,
+		pending: make(map[string]chan AntBoxResponse),
+	}
+	h.AntBoxes.register(c)
+	slog.Info("antbox connected", "antbox_id", c.id, "remote", r.RemoteAddr)
+	defer func() {
+		h.AntBoxes.unregister(c)
+		ws.Close()
+		slog.Info("antbox disconnected", "antbox_id", c.id)
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(antboxPingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := ws.Ping(); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	h.markAntBoxSeen(c.id, nil)
+	for {
+		_ = ws.SetReadDeadline(time.Now().Add(antboxReadTimeout))
+		raw, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var env antboxEnvelope
+		if err := json.Unmarshal(raw, &env); err != nil {
+			continue
+		}
+		switch env.Type {
+		case "hello":
+			var hello struct {
+				Version string `json:"version"`
+			}
+			if json.Unmarshal(env.Data, &hello) == nil && hello.Version != "" {
+				_, _ = h.DB.Exec(`UPDATE antboxes SET firmware_version = $1 WHERE id = $2`, hello.Version, c.id)
+			}
+		case "heartbeat":
+			var report AntBoxReport
+			if err := json.Unmarshal(env.Data, &report); err != nil {
+				continue
+			}
+			c.mu.Lock()
+			c.report = &report
+			c.reportAt = time.Now()
+			c.mu.Unlock()
+			h.markAntBoxSeen(c.id, &report)
+		case "response":
+			var resp AntBoxResponse
+			if err := json.Unmarshal(env.Data, &resp); err != nil {
+				continue
+			}
+			c.mu.Lock()
+			ch := c.pending[resp.CommandID]
+			delete(c.pending, resp.CommandID)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- resp
+			}
+		}
+	}
+}
+
+// markAntBoxSeen bumps last_seen_at and, given a report, tuner_count.
+func (h *AdminHandlers) markAntBoxSeen(id string, report *AntBoxReport) {
+	var err error
+	if report != nil {
+		_, err = h.DB.Exec(
+			`UPDATE antboxes SET last_seen_at = NOW(), tuner_count = $1 WHERE id = $2`,
+			report.tunerCount(), id,
+		)
+	} else {
+		_, err = h.DB.Exec(`UPDATE antboxes SET last_seen_at = NOW() WHERE id = $1`, id)
+	}
+	if err != nil {
+		slog.Warn("antbox: last_seen update failed", "antbox_id", id, "err", err)
+	}
+}
@@ -0,0 +1,252 @@
+// admin_antbox_ingest.go — Receives HLS segments captured by AntBox devices.
+//
+// The AntBox capture pipeline uploads each finished segment of a started event
+// and then marks the event complete:
+//
+//	PUT  /antbox/events/{event_id}/segments/{name}   body = MPEG-TS segment
+//	POST /antbox/events/{event_id}/complete
+//
+// Both are authenticated like /antbox/connect and only accepted for an event
+// scheduled on the uploading device (antbox_events, admin_antbox_events.go)
+// that was not cancelled or failed; complete marks the event complete.
+// Segments are stored under {RoostDataDir}/antbox/{antbox_id}/{event_id}/ next
+// to an EVENT playlist (index.m3u8) that grows as segments arrive, so the
+// capture is playable through /owl/v1/antbox/events while it is still
+// running. Uploads are idempotent: a segment re-sent after a
+// network drop replaces the file without adding a second playlist entry.
+// complete is idempotent too; once the playlist has #EXT-X-ENDLIST, new
+// segments are refused with 409 (re-sent ones are accepted and ignored).
+// Segments may be at most antboxTargetDuration seconds long, the playlist's
+// fixed #EXT-X-TARGETDURATION.
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	antboxMaxSegmentBytes = 256 << 20
+
+	// antboxTargetDuration is the event playlist's #EXT-X-TARGETDURATION.
+	// Broadcast remuxes split on keyframes, so it leaves generous headroom
+	// over the capture's 6s target; longer segments are rejected.
+	antboxTargetDuration = 12
+)
+
+// errAntBoxEventEnded is returned by appendAntBoxPlaylist once the playlist
+// has #EXT-X-ENDLIST.
+var errAntBoxEventEnded = errors.New("antbox event already complete")
+
+var (
+	antboxEventIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	antboxSegmentRe = regexp.MustCompile(`^[0-9]{1,20}-[0-9]{1,10}\.ts$`)
+
+	// antboxPlaylistMu serialises playlist appends; uploads for one event
+	// arrive in order from a single device, so one lock is plenty.
+	antboxPlaylistMu sync.Mutex
+)
+
+// parseAntBoxEventPath splits /antbox/events/{event_id}/{rest} and validates
+// the event id. rest is "complete" or "segments/{name}".
+func parseAntBoxEventPath(path string) (eventID, action, segment string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/antbox/events/"), "/")
+	if len(parts) < 2 || !antboxEventIDRe.MatchString(parts[0]) {
+		return "", "", "", false
+	}
+	switch {
+	case len(parts) == 2 && parts[1] == "complete":
+		return parts[0], "complete", "", true
+	case len(parts) == 3 && parts[1] == "segments" && antboxSegmentRe.MatchString(parts[2]):
+		return parts[0], "segments", parts[2], true
+	}
+	return "", "", "", false
+}
+
+// AntBoxEvents handles PUT /antbox/events/:id/segments/:name and
+// POST /antbox/events/:id/complete.
+func (h *AdminHandlers) AntBoxEvents(w http.ResponseWriter, r *http.Request) {
+	eventID, action, segment, ok := parseAntBoxEventPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := authenticateAntBox(r.Context(), h.DB, r); err != nil {
+		if errors.Is(err, errAntBoxUnauthorized) {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		slog.Error("antbox ingest: db error", "err", err)
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	boxID := r.Header.Get("X-AntBox-ID")
+	if !isValidUUID(eventID) {
+		http.Error(w, `{"error":"unknown event"}`, http.StatusNotFound)
+		return
+	}
+	var status string
+	err := h.DB.QueryRowContext(r.Context(),
+		`SELECT status FROM antbox_events WHERE id = $1 AND antbox_id = $2`,
+		eventID, boxID,
+	).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, `{"error":"unknown event"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("antbox ingest: db error", "err", err)
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	if status == "cancelled" || status == "failed" {
+		http.Error(w, `{"error":"event `+status+`"}`, http.StatusConflict)
+		return
+	}
+	dir := filepath.Join(h.RoostDataDir, "antbox", boxID, eventID)
+
+	switch {
+	case action == "segments" && r.Method == http.MethodPut:
+		h.putAntBoxSegment(w, r, dir, segment)
+	case action == "complete" && r.Method == http.MethodPost:
+		err := appendAntBoxPlaylist(dir, "#EXT-X-ENDLIST\n")
+		if errors.Is(err, errAntBoxEventEnded) {
+			w.WriteHeader(http.StatusNoContent) // repeated complete
+			return
+		}
+		if err != nil {
+			slog.Error("antbox ingest: finalise playlist failed", "event_id", eventID, "err", err)
+			http.Error(w, `{"error":"storage_error"}`, http.StatusInternalServerError)
+			return
+		}
+		if _, err := h.DB.ExecContext(r.Context(),
+			`UPDATE antbox_events SET status = 'complete', completed_at = NOW()
+			  WHERE id = $1 AND status IN ('scheduled', 'capturing', 'stopping')`,
+			eventID,
+		); err != nil {
+			slog.Error("antbox ingest: mark complete failed", "event_id", eventID, "err", err)
+		}
+		slog.Info("antbox ingest: event complete", "event_id", eventID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// putAntBoxSegment stores one segment and, on first receipt, appends it to
+// the event playlist.
+func (h *AdminHandlers) putAntBoxSegment(w http.ResponseWriter, r *http.Request, dir, name string) {
+	duration, err := strconv.ParseFloat(r.Header.Get("X-Segment-Duration"), 64)
+	if err != nil || duration <= 0 {
+		http.Error(w, `{"error":"invalid X-Segment-Duration"}`, http.StatusBadRequest)
+		return
+	}
+	// HLS requires every EXTINF, rounded, to be at most the target duration.
+	if math.Round(duration) > antboxTargetDuration {
+		http.Error(w, fmt.Sprintf(`{"error":"segment longer than %ds"}`, antboxTargetDuration), http.StatusBadRequest)
+		return
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		slog.Error("antbox ingest: mkdir failed", "dir", dir, "err", err)
+		http.Error(w, `{"error":"storage_error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	final := filepath.Join(dir, name)
+	_, statErr := os.Stat(final)
+	seen := statErr == nil
+	if antboxEventEnded(dir) {
+		if seen {
+			w.WriteHeader(http.StatusNoContent) // re-sent after complete
+			return
+		}
+		http.Error(w, `{"error":"event complete"}`, http.StatusConflict)
+		return
+	}
+
+	// Write to a temp file and rename so a dropped upload never leaves a
+	// truncated segment behind.
+	tmp, err := os.CreateTemp(dir, name+".part-*")
+	if err != nil {
+		http.Error(w, `{"error":"storage_error"}`, http.StatusInternalServerError)
+		return
+	}
+	n, err := io.Copy(tmp, io.LimitReader(r.Body, antboxMaxSegmentBytes+1))
+	closeErr := tmp.Close()
+	if err != nil || closeErr != nil || n > antboxMaxSegmentBytes {
+		os.Remove(tmp.Name())
+		if n > antboxMaxSegmentBytes {
+			http.Error(w, `{"error":"segment too large"}`, http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, `{"error":"upload interrupted"}`, http.StatusBadRequest)
+		return
+	}
+	if err := os.Rename(tmp.Name(), final); err != nil {
+		os.Remove(tmp.Name())
+		http.Error(w, `{"error":"storage_error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if !seen {
+		entry := fmt.Sprintf("#EXTINF:%.3f,\n%s\n", duration, name)
+		if r.Header.Get("X-Segment-Discontinuity") == "1" {
+			entry = "#EXT-X-DISCONTINUITY\n" + entry
+		}
+		err := appendAntBoxPlaylist(dir, entry)
+		if errors.Is(err, errAntBoxEventEnded) {
+			// complete arrived while this segment was uploading.
+			os.Remove(final)
+			http.Error(w, `{"error":"event complete"}`, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			slog.Error("antbox ingest: playlist append failed", "dir", dir, "err", err)
+			http.Error(w, `{"error":"storage_error"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// antboxEventEnded reports whether dir/index.m3u8 has #EXT-X-ENDLIST.
+func antboxEventEnded(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	return err == nil && strings.Contains(string(data), "#EXT-X-ENDLIST")
+}
+
+// appendAntBoxPlaylist appends lines to dir/index.m3u8, writing the header
+// first if the playlist is new. It returns errAntBoxEventEnded, writing
+// nothing, once the playlist has been ended.
+func appendAntBoxPlaylist(dir, lines string) error {
+	antboxPlaylistMu.Lock()
+	defer antboxPlaylistMu.Unlock()
+
+	path := filepath.Join(dir, "index.m3u8")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		lines = fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n",
+			antboxTargetDuration) + lines
+	} else if antboxEventEnded(dir) {
+		return errAntBoxEventEnded
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(lines); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
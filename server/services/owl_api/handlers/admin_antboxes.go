@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 const (
 	antboxOnlineThreshold = 2 * time.Minute
 	antboxStaleThreshold  = 10 * time.Minute
+	antboxScanTimeout     = 15 * time.Minute
 )
 
 // AntBoxRow is one antbox returned by GET /admin/antboxes.
@@ -141,6 +144,9 @@ func (h *AdminHandlers) DeleteAntBox(w http.ResponseWriter, r *http.Request, al
 }
 
 // TriggerAntBoxChannelScan handles POST /admin/antboxes/scan-channels.
+// Sends SCAN_CHANNELS for every online HDHomeRun device reported by the target
+// antbox (or every connected antbox when antbox_id is omitted). Scans run for
+// minutes, so results are logged rather than returned.
 func (h *AdminHandlers) TriggerAntBoxChannelScan(w http.ResponseWriter, r *http.Request, al *audit.Logger) {
 	claims := middleware.AdminClaimsFromCtx(r.Context())
 
@@ -153,32 +159,91 @@ func (h *AdminHandlers) TriggerAntBoxChannelScan(w http.ResponseWriter, r *http.
 	targetID := "all"
 	if body.AntBoxID != nil {
 		targetID = *body.AntBoxID
+		if !isValidUUID(targetID) {
+			http.Error(w, `{"error":"invalid antbox id"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if h.AntBoxes == nil {
+		http.Error(w, `{"error":"antbox connections not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	boxes := h.AntBoxes.Connected(claims.RoostID)
+	if body.AntBoxID != nil {
+		boxes = nil
+		if _, _, ok := h.AntBoxes.LastReport(claims.RoostID, targetID); ok {
+			boxes = []string{targetID}
+		}
 	}
 
-	// Enqueue scan command via Redis: roost:antbox_scan:{roost_id}
-	// When Redis is wired, publish: h.Redis.LPush(ctx, key, jobPayload)
-	_ = targetID // used when Redis is wired
+	dispatched := 0
+	for _, boxID := range boxes {
+		report, _, ok := h.AntBoxes.LastReport(claims.RoostID, boxID)
+		if !ok {
+			continue
+		}
+		for _, dev := range report.Devices {
+			if !dev.Online || dev.IP == "" {
+				continue
+			}
+			dispatched++
+			go h.runAntBoxScan(claims.RoostID, boxID, dev.IP, jobID)
+		}
+	}
+	if dispatched == 0 {
+		http.Error(w, `{"error":"antbox_offline"}`, http.StatusConflict)
+		return
+	}
 
 	al.Log(r, claims.RoostID, claims.UserID, "antbox.scan_channels_triggered", targetID,
-		map[string]any{"job_id": jobID},
+		map[string]any{"job_id": jobID, "devices": dispatched},
 	)
 
-	writeAdminJSON(w, http.StatusAccepted, map[string]string{"job_id": jobID})
+	writeAdminJSON(w, http.StatusAccepted, map[string]any{"job_id": jobID, "devices": dispatched})
+}
+
+// runAntBoxScan sends one SCAN_CHANNELS command and logs the outcome.
+func (h *AdminHandlers) runAntBoxScan(roostID, boxID, deviceIP, jobID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), antboxScanTimeout)
+	defer cancel()
+	resp, err := h.AntBoxes.SendCommand(ctx, roostID, boxID, AntBoxCmdScanChannels,
+		map[string]any{"device_ip": deviceIP, "quick": false})
+	switch {
+	case err != nil:
+		slog.Warn("antbox scan: command failed", "job_id", jobID, "antbox_id", boxID, "device_ip", deviceIP, "err", err)
+	case !resp.Success:
+		slog.Warn("antbox scan: device reported failure", "job_id", jobID, "antbox_id", boxID, "device_ip", deviceIP, "error", resp.Error)
+	default:
+		slog.Info("antbox scan: complete", "job_id", jobID, "antbox_id", boxID, "device_ip", deviceIP)
+	}
 }
 
 // AntBoxSignal handles GET /admin/antboxes/:id/signal.
+// Returns per-tuner signal from the device's most recent heartbeat.
 func (h *AdminHandlers) AntBoxSignal(w http.ResponseWriter, r *http.Request) {
-	_ = middleware.AdminClaimsFromCtx(r.Context())
+	claims := middleware.AdminClaimsFromCtx(r.Context())
 	boxID := extractPathID(r.URL.Path, "/admin/antboxes/", "/signal")
 	if !isValidUUID(boxID) {
 		http.Error(w, `{"error":"invalid antbox id"}`, http.StatusBadRequest)
 		return
 	}
 
-	// Read from Redis key antbox:signal:{antbox_id}
-	// When Redis is wired: val, err := h.Redis.Get(ctx, "antbox:signal:"+boxID).Result()
-	// For now: return antbox_offline (device not connected)
-	writeAdminJSON(w, http.StatusAccepted, map[string]string{
-		"error": "antbox_offline",
+	var report *AntBoxReport
+	var reportedAt time.Time
+	ok := false
+	if h.AntBoxes != nil {
+		report, reportedAt, ok = h.AntBoxes.LastReport(claims.RoostID, boxID)
+	}
+	if !ok {
+		writeAdminJSON(w, http.StatusAccepted, map[string]string{
+			"error": "antbox_offline",
+		})
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"antbox_id":   boxID,
+		"reported_at": reportedAt.UTC(),
+		"devices":     report.Devices,
 	})
 }
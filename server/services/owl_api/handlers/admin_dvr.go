@@ -22,6 +22,8 @@ type ScheduleItem struct {
 	PaddingAfterSecs  int       `json:"padding_after_secs"`
 	StoragePathID     *string   `json:"storage_path_id,omitempty"`
 	Status            string    `json:"status"`
+	ChannelKey        *string   `json:"channel_key,omitempty"`
+	Priority          int       `json:"priority"`
 }
 
 // ListDVRSchedule handles GET /admin/dvr/schedule.
@@ -31,7 +33,7 @@ func (h *AdminHandlers) ListDVRSchedule(w http.ResponseWriter, r *http.Request)
 	rows, err := h.DB.QueryContext(r.Context(),
 		`SELECT d.id, d.title, d.channel_id::text, i.display_name,
 		        d.start_time, d.end_time, d.padding_before_secs, d.padding_after_secs,
-		        d.storage_path_id::text, d.status
+		        d.storage_path_id::text, d.status, d.channel_key, d.priority
 		   FROM dvr_schedule d
 		   JOIN iptv_sources i ON d.channel_id = i.id
 		  WHERE d.roost_id = $1
@@ -53,7 +55,7 @@ func (h *AdminHandlers) ListDVRSchedule(w http.ResponseWriter, r *http.Request)
 		if err := rows.Scan(
 			&s.ID, &s.Title, &s.ChannelID, &s.ChannelName,
 			&s.StartTime, &s.EndTime, &s.PaddingBeforeSecs, &s.PaddingAfterSecs,
-			&s.StoragePathID, &s.Status,
+			&s.StoragePathID, &s.Status, &s.ChannelKey, &s.Priority,
 		); err != nil {
 			continue
 		}
@@ -66,6 +68,9 @@ func (h *AdminHandlers) ListDVRSchedule(w http.ResponseWriter, r *http.Request)
 }
 
 // CreateScheduleRequest is the POST /admin/dvr/schedule body.
+// ChannelKey (tvg-id / EPG channel id) lets the allocator record from another
+// source carrying the same channel when channel_id's source is full.
+// Priority decides who keeps a tuner when capacity runs out (higher wins).
 type CreateScheduleRequest struct {
 	ChannelID          string    `json:"channel_id"`
 	Title              string    `json:"title"`
@@ -74,18 +79,14 @@ type CreateScheduleRequest struct {
 	PaddingBeforeSecs  int       `json:"padding_before_secs"`
 	PaddingAfterSecs   int       `json:"padding_after_secs"`
 	StoragePathID      *string   `json:"storage_path_id,omitempty"`
-}
-
-// ScheduleConflict is returned in 409 responses.
-type ScheduleConflict struct {
-	ConflictingID    string `json:"conflicting_id"`
-	ConflictingTitle string `json:"conflicting_title"`
-	StartTime        time.Time `json:"start_time"`
-	EndTime          time.Time `json:"end_time"`
-	Suggestion       string `json:"suggestion"`
+	ChannelKey         string    `json:"channel_key,omitempty"`
+	Priority           int       `json:"priority"`
 }
 
 // CreateDVRSchedule handles POST /admin/dvr/schedule.
+// The recording is admitted only if the tuner allocator can place it; a
+// higher-priority recording may displace lower-priority ones, which are
+// returned so the admin can see what lost its tuner.
 func (h *AdminHandlers) CreateDVRSchedule(w http.ResponseWriter, r *http.Request, al *audit.Logger) {
 	claims := middleware.AdminClaimsFromCtx(r.Context())
 
@@ -99,6 +100,7 @@ func (h *AdminHandlers) CreateDVRSchedule(w http.ResponseWriter, r *http.Request
 		http.Error(w, `{"error":"title, channel_id, duration_secs required"}`, http.StatusBadRequest)
 		return
 	}
+	req.ChannelKey = normalizeChannelKey(req.ChannelKey)
 
 	// Validate channel belongs to this roost
 	var channelOwner string
@@ -111,40 +113,82 @@ func (h *AdminHandlers) CreateDVRSchedule(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	endTime := req.StartTime.Add(time.Duration(req.DurationSecs+req.PaddingAfterSecs) * time.Second)
+	// end_time is the programme's end, as in dvr_recordings; the tuner
+	// allocator adds the padding (migration 096).
+	endTime := req.StartTime.Add(time.Duration(req.DurationSecs) * time.Second)
 
-	// Conflict detection — Allen interval overlap: A.start < B.end AND A.end > B.start
-	conflicts, err := h.detectScheduleConflicts(r, claims.RoostID, req.ChannelID, req.StartTime, endTime, "")
-	if err == nil && len(conflicts) > 0 {
+	// Admit the recording and insert it under the tuner lock, so a
+	// concurrent request cannot take the same tuner.
+	tx, err := h.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if err := lockTuners(r.Context(), tx, claims.RoostID); err != nil {
+		slog.Error("dvr/schedule: tuner lock", "err", err)
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	const pendingID = "new-recording"
+	conflict, displaced, err := admitTunerDemand(r.Context(), tx, claims.RoostID, tunerDemand{
+		ID: pendingID, Kind: tunerDemandRecording, Title: req.Title, ChannelKey: req.ChannelKey,
+		Start:      req.StartTime.Add(-time.Duration(req.PaddingBeforeSecs) * time.Second),
+		End:        endTime.Add(time.Duration(req.PaddingAfterSecs) * time.Second),
+		Priority:   req.Priority,
+		SourceKind: tunerKindIPTV, SourceID: req.ChannelID,
+	})
+	if err != nil {
+		slog.Error("dvr/schedule: tuner admission", "err", err)
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	if conflict != nil {
+		conflict.ID = ""
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusConflict)
 		_ = json.NewEncoder(w).Encode(map[string]any{
-			"error":     "schedule_conflict",
-			"conflicts": conflicts,
+			"error":    "schedule_conflict",
+			"conflict": conflict,
 		})
 		return
 	}
 
+	var channelKey interface{}
+	if req.ChannelKey != "" {
+		channelKey = req.ChannelKey
+	}
 	var rowID string
-	err = h.DB.QueryRowContext(r.Context(),
+	err = tx.QueryRowContext(r.Context(),
 		`INSERT INTO dvr_schedule
-		     (roost_id, channel_id, title, start_time, end_time, padding_before_secs, padding_after_secs, storage_path_id, scheduled_by)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		     (roost_id, channel_id, title, start_time, end_time, padding_before_secs, padding_after_secs, storage_path_id, scheduled_by,
+		      channel_key, priority)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
 		claims.RoostID, req.ChannelID, req.Title,
 		req.StartTime, endTime, req.PaddingBeforeSecs, req.PaddingAfterSecs,
-		req.StoragePathID, claims.UserID,
+		req.StoragePathID, claims.UserID, channelKey, req.Priority,
 	).Scan(&rowID)
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		slog.Error("dvr/schedule: db error", "err", err)
 		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
 		return
 	}
+	for _, c := range displaced {
+		for i := range c.BlockedBy {
+			if c.BlockedBy[i].ID == pendingID {
+				c.BlockedBy[i].ID = rowID
+			}
+		}
+	}
 
 	al.Log(r, claims.RoostID, claims.UserID, "dvr.schedule_recording", rowID,
-		map[string]any{"title": req.Title, "channel_id": req.ChannelID},
+		map[string]any{"title": req.Title, "channel_id": req.ChannelID, "priority": req.Priority, "displaced": len(displaced)},
 	)
 
-	writeAdminJSON(w, http.StatusCreated, map[string]string{"id": rowID, "status": "scheduled"})
+	writeAdminJSON(w, http.StatusCreated, map[string]any{"id": rowID, "status": "scheduled", "displaced": displaced})
 }
 
 // CancelDVRSchedule handles DELETE /admin/dvr/schedule/:id.
@@ -178,14 +222,28 @@ func (h *AdminHandlers) CancelDVRSchedule(w http.ResponseWriter, r *http.Request
 }
 
 // CreateSeriesRuleRequest is the POST /admin/dvr/series body.
+// AlwaysRecord true records every airing; false records new episodes only.
+// GuideChannelID is the channels row whose guide the rule matches; the DVR
+// service records that channel's stream, for the subscriber account of the
+// admin creating the rule.
+// Padding and FollowEPG / SportsExtend are copied onto every recording the rule schedules.
 type CreateSeriesRuleRequest struct {
 	ChannelID      string  `json:"channel_id"`
 	ShowTitle      string  `json:"show_title"`
 	AlwaysRecord   bool    `json:"always_record"`
 	KeepLastN      *int    `json:"keep_last_n,omitempty"`
 	StoragePathID  *string `json:"storage_path_id,omitempty"`
+	GuideChannelID string  `json:"guide_channel_id"`
+
+	PaddingBeforeSecs int  `json:"padding_before_secs"`
+	PaddingAfterSecs  int  `json:"padding_after_secs"`
+	FollowEPG         bool `json:"follow_epg"`
+	SportsExtend      bool `json:"sports_extend"`
 }
 
+// maxSeriesPaddingSecs bounds series-rule padding (matches the DVR service's limit).
+const maxSeriesPaddingSecs = 2 * 60 * 60
+
 // CreateSeriesRule handles POST /admin/dvr/series.
 func (h *AdminHandlers) CreateSeriesRule(w http.ResponseWriter, r *http.Request, al *audit.Logger) {
 	claims := middleware.AdminClaimsFromCtx(r.Context())
@@ -206,11 +264,24 @@ func (h *AdminHandlers) CreateSeriesRule(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	if !isValidUUID(req.GuideChannelID) {
+		http.Error(w, `{"error":"guide_channel_id required"}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.PaddingBeforeSecs < 0 || req.PaddingAfterSecs < 0 ||
+		req.PaddingBeforeSecs > maxSeriesPaddingSecs || req.PaddingAfterSecs > maxSeriesPaddingSecs {
+		http.Error(w, `{"error":"padding must be between 0 and 7200 seconds"}`, http.StatusBadRequest)
+		return
+	}
+
 	var rowID string
 	err := h.DB.QueryRowContext(r.Context(),
-		`INSERT INTO dvr_series (roost_id, channel_id, show_title, always_record, keep_last_n, storage_path_id, scheduled_by)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
-		claims.RoostID, req.ChannelID, req.ShowTitle, req.AlwaysRecord, req.KeepLastN, req.StoragePathID, claims.UserID,
+		`INSERT INTO dvr_series (roost_id, channel_id, show_title, always_record, keep_last_n, storage_path_id, guide_channel_id, scheduled_by,
+		                         padding_before_secs, padding_after_secs, follow_epg, sports_extend)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`,
+		claims.RoostID, req.ChannelID, req.ShowTitle, req.AlwaysRecord, req.KeepLastN, req.StoragePathID, req.GuideChannelID, claims.UserID,
+		req.PaddingBeforeSecs, req.PaddingAfterSecs, req.FollowEPG, req.SportsExtend,
 	).Scan(&rowID)
 	if err != nil {
 		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
@@ -307,85 +378,17 @@ func (h *AdminHandlers) DeleteDVRRecording(w http.ResponseWriter, r *http.Reques
 }
 
 // ListDVRConflicts handles GET /admin/dvr/conflicts.
+// Re-runs tuner allocation over upcoming recordings and AntBox captures and
+// returns the demands that could not get a tuner or connection on any source
+// carrying their channel, with the demands holding those tuners.
 func (h *AdminHandlers) ListDVRConflicts(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.AdminClaimsFromCtx(r.Context())
 
-	// Find all scheduled recordings that overlap with other scheduled recordings
-	rows, err := h.DB.QueryContext(r.Context(),
-		`SELECT a.id, a.title, a.start_time, a.end_time, a.channel_id::text,
-		        b.id, b.title, b.start_time, b.end_time
-		   FROM dvr_schedule a
-		   JOIN dvr_schedule b
-		     ON a.channel_id = b.channel_id
-		    AND a.id < b.id  -- prevent duplicate pairs
-		    AND a.start_time < b.end_time
-		    AND a.end_time   > b.start_time
-		  WHERE a.roost_id = $1
-		    AND a.status = 'scheduled'
-		    AND b.status = 'scheduled'`,
-		claims.RoostID,
-	)
+	alloc, err := computeTuners(r.Context(), h.DB, claims.RoostID, time.Now())
 	if err != nil {
+		slog.Error("dvr/conflicts: allocation failed", "err", err)
 		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	type conflictPair struct {
-		RecordingA ScheduleConflict `json:"recording_a"`
-		RecordingB ScheduleConflict `json:"recording_b"`
-	}
-
-	var pairs []conflictPair
-	for rows.Next() {
-		var a, b ScheduleConflict
-		if err := rows.Scan(
-			&a.ConflictingID, &a.ConflictingTitle, &a.StartTime, &a.EndTime, nil,
-			&b.ConflictingID, &b.ConflictingTitle, &b.StartTime, &b.EndTime,
-		); err != nil {
-			continue
-		}
-		a.Suggestion = "Consider adding a second IPTV source or rescheduling one recording"
-		b.Suggestion = "Consider adding a second IPTV source or rescheduling one recording"
-		pairs = append(pairs, conflictPair{RecordingA: a, RecordingB: b})
-	}
-	if pairs == nil {
-		pairs = []conflictPair{}
-	}
-	writeAdminJSON(w, http.StatusOK, pairs)
-}
-
-// detectScheduleConflicts finds existing scheduled recordings that overlap the given window.
-func (h *AdminHandlers) detectScheduleConflicts(
-	r *http.Request,
-	roostID, channelID string,
-	startTime, endTime time.Time,
-	excludeID string,
-) ([]ScheduleConflict, error) {
-	rows, err := h.DB.QueryContext(r.Context(),
-		`SELECT id, title, start_time, end_time
-		   FROM dvr_schedule
-		  WHERE roost_id = $1
-		    AND channel_id = $2
-		    AND status = 'scheduled'
-		    AND start_time < $4
-		    AND end_time   > $3
-		    AND id != COALESCE($5::uuid, '00000000-0000-0000-0000-000000000000'::uuid)`,
-		roostID, channelID, startTime, endTime, excludeID,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var conflicts []ScheduleConflict
-	for rows.Next() {
-		var c ScheduleConflict
-		if err := rows.Scan(&c.ConflictingID, &c.ConflictingTitle, &c.StartTime, &c.EndTime); err != nil {
-			continue
-		}
-		c.Suggestion = "Consider adding a second IPTV source or adjusting the recording time"
-		conflicts = append(conflicts, c)
-	}
-	return conflicts, nil
+	writeAdminJSON(w, http.StatusOK, alloc.conflicts)
 }
@@ -189,9 +189,10 @@ func (h *AdminHandlers) RefreshIPTVSource(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Enqueue channel-list refresh job
+	// Refresh connection limit and channel lineup in the background.
 	jobID := newUUID()
 	slog.Info("iptv source refresh enqueued", "source_id", sourceID, "job_id", jobID)
+	go h.syncIPTVSource(claims.RoostID, sourceID, jobID)
 
 	al.Log(r, claims.RoostID, claims.UserID, "iptv_source.refresh_triggered", sourceID, nil)
 	writeAdminJSON(w, http.StatusAccepted, map[string]string{"job_id": jobID})
@@ -226,23 +227,28 @@ func (h *AdminHandlers) DeleteIPTVSource(w http.ResponseWriter, r *http.Request,
 
 // ── Encryption helpers ────────────────────────────────────────────────────────
 
-// encryptField encrypts a plaintext string using AES-256-GCM.
-// Key is read from ROOST_ENCRYPTION_KEY env var (must be 32 bytes base64-encoded).
-func encryptField(plaintext string) (string, error) {
+// roostGCM returns the AES-256-GCM cipher keyed by ROOST_ENCRYPTION_KEY
+// (must be 32 bytes base64-encoded).
+func roostGCM() (cipher.AEAD, error) {
 	keyB64 := os.Getenv("ROOST_ENCRYPTION_KEY")
 	if keyB64 == "" {
-		return "", fmt.Errorf("ROOST_ENCRYPTION_KEY not set")
+		return nil, fmt.Errorf("ROOST_ENCRYPTION_KEY not set")
 	}
 	key, err := base64.StdEncoding.DecodeString(keyB64)
 	if err != nil || len(key) != 32 {
-		return "", fmt.Errorf("ROOST_ENCRYPTION_KEY must be 32-byte base64-encoded value")
+		return nil, fmt.Errorf("ROOST_ENCRYPTION_KEY must be 32-byte base64-encoded value")
 	}
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	gcm, err := cipher.NewGCM(block)
+	return cipher.NewGCM(block)
+}
+
+// encryptField encrypts a plaintext string using AES-256-GCM.
+func encryptField(plaintext string) (string, error) {
+	gcm, err := roostGCM()
 	if err != nil {
 		return "", err
 	}
@@ -256,6 +262,23 @@ func encryptField(plaintext string) (string, error) {
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
+// decryptField reverses encryptField.
+func decryptField(encoded string) (string, error) {
+	gcm, err := roostGCM()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted field")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
 // isValidHTTPSURL returns true if s is a valid HTTP or HTTPS URL.
 func isValidHTTPSURL(s string) bool {
 	u, err := url.ParseRequestURI(s)
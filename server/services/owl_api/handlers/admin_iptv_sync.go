@@ -0,0 +1,215 @@
+// admin_iptv_sync.go — Background refresh of an IPTV source's capacity and lineup.
+//
+// POST /admin/iptv-sources/:id/refresh starts syncIPTVSource, which stores:
+//   - iptv_sources.max_connections from Xtream user_info (the provider's
+//     concurrent stream limit, used by the tuner allocator)
+//   - iptv_sources.channel_count / last_refreshed_at
+//   - roost_source_channels: the channels the source carries, keyed by
+//     lowercased tvg-id / epg_channel_id so recordings can fall back between
+//     sources carrying the same channel
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const iptvSyncTimeout = 2 * time.Minute
+
+var iptvSyncClient = &http.Client{Timeout: 60 * time.Second}
+
+// syncIPTVSource refreshes one source. Failures are logged; the previous
+// lineup and limit stay in place.
+func (h *AdminHandlers) syncIPTVSource(roostID, sourceID, jobID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), iptvSyncTimeout)
+	defer cancel()
+
+	var sourceType string
+	var configRaw []byte
+	err := h.DB.QueryRowContext(ctx,
+		`SELECT source_type::text, config FROM iptv_sources
+		  WHERE id = $1 AND roost_id = $2 AND is_active = TRUE`,
+		sourceID, roostID,
+	).Scan(&sourceType, &configRaw)
+	if err != nil {
+		slog.Warn("iptv sync: source not found", "job_id", jobID, "source_id", sourceID, "err", err)
+		return
+	}
+	var cfg map[string]string
+	if err := json.Unmarshal(configRaw, &cfg); err != nil {
+		slog.Warn("iptv sync: unreadable config", "job_id", jobID, "source_id", sourceID, "err", err)
+		return
+	}
+
+	var maxConns *int
+	var channels map[string]string
+	switch sourceType {
+	case "xtream":
+		var n int
+		n, channels, err = fetchXtreamLineup(ctx, cfg)
+		if n > 0 {
+			maxConns = &n
+		}
+	case "m3u":
+		channels, err = fetchM3ULineup(ctx, cfg["url"])
+	default:
+		slog.Info("iptv sync: no lineup API for source type", "job_id", jobID, "source_id", sourceID, "type", sourceType)
+		return
+	}
+	if err != nil {
+		slog.Warn("iptv sync: fetch failed", "job_id", jobID, "source_id", sourceID, "err", err)
+		return
+	}
+
+	if _, err := h.DB.ExecContext(ctx,
+		`UPDATE iptv_sources
+		    SET channel_count = $3, last_refreshed_at = NOW(),
+		        max_connections = COALESCE($4, max_connections)
+		  WHERE id = $1 AND roost_id = $2`,
+		sourceID, roostID, len(channels), maxConns,
+	); err != nil {
+		slog.Warn("iptv sync: update source failed", "job_id", jobID, "source_id", sourceID, "err", err)
+		return
+	}
+	if err := replaceSourceChannels(ctx, h.DB, roostID, tunerKindIPTV, sourceID, channels); err != nil {
+		slog.Warn("iptv sync: store lineup failed", "job_id", jobID, "source_id", sourceID, "err", err)
+		return
+	}
+	slog.Info("iptv sync: complete", "job_id", jobID, "source_id", sourceID,
+		"channels", len(channels), "max_connections", maxConns)
+}
+
+// flexInt decodes an integer that providers send either as a number or as a
+// quoted string ("max_connections": "2").
+type flexInt int
+
+func (f *flexInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*f = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("not an integer: %s", b)
+	}
+	*f = flexInt(n)
+	return nil
+}
+
+// fetchXtreamLineup reads user_info.max_connections and the live stream list.
+func fetchXtreamLineup(ctx context.Context, cfg map[string]string) (int, map[string]string, error) {
+	password, err := decryptField(cfg["password"])
+	if err != nil {
+		return 0, nil, fmt.Errorf("decrypt password: %w", err)
+	}
+	host := strings.TrimRight(cfg["host"], "/")
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	base := fmt.Sprintf("%s/player_api.php?username=%s&password=%s",
+		host, url.QueryEscape(cfg["username"]), url.QueryEscape(password))
+
+	var info struct {
+		UserInfo struct {
+			Status         string  `json:"status"`
+			MaxConnections flexInt `json:"max_connections"`
+		} `json:"user_info"`
+	}
+	if err := getIPTVJSON(ctx, base, &info); err != nil {
+		return 0, nil, fmt.Errorf("user_info: %w", err)
+	}
+	if strings.EqualFold(info.UserInfo.Status, "Expired") || strings.EqualFold(info.UserInfo.Status, "Banned") {
+		return 0, nil, fmt.Errorf("account %s", strings.ToLower(info.UserInfo.Status))
+	}
+
+	var streams []struct {
+		Name         string `json:"name"`
+		EpgChannelID string `json:"epg_channel_id"`
+	}
+	if err := getIPTVJSON(ctx, base+"&action=get_live_streams", &streams); err != nil {
+		return 0, nil, fmt.Errorf("get_live_streams: %w", err)
+	}
+	channels := make(map[string]string, len(streams))
+	for _, s := range streams {
+		if key := normalizeChannelKey(s.EpgChannelID); key != "" {
+			channels[key] = s.Name
+		}
+	}
+	return int(info.UserInfo.MaxConnections), channels, nil
+}
+
+// getIPTVJSON GETs u and decodes the JSON body. u carries credentials and is
+// never logged.
+func getIPTVJSON(ctx context.Context, u string, dest any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := iptvSyncClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// fetchM3ULineup downloads an M3U playlist and returns its tvg-id channels.
+func fetchM3ULineup(ctx context.Context, playlistURL string) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, playlistURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := iptvSyncClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return parseM3UChannels(resp.Body)
+}
+
+var m3uTvgID = regexp.MustCompile(`tvg-id="([^"]*)"`)
+
+// parseM3UChannels returns channel_key → name for every #EXTINF entry with a
+// tvg-id. Entries without one cannot be matched across sources and are skipped.
+func parseM3UChannels(r io.Reader) (map[string]string, error) {
+	channels := map[string]string{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "#EXTINF") {
+			continue
+		}
+		m := m3uTvgID.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		key := normalizeChannelKey(m[1])
+		if key == "" {
+			continue
+		}
+		name := ""
+		if i := strings.LastIndex(line, ","); i >= 0 {
+			name = strings.TrimSpace(line[i+1:])
+		}
+		channels[key] = name
+	}
+	return channels, sc.Err()
+}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	goredis "github.com/redis/go-redis/v9"
+	"github.com/unyeco/roost/services/library"
 	"github.com/unyeco/roost/services/owl_api/middleware"
 )
 
@@ -26,6 +27,8 @@ type AdminHandlers struct {
 	Redis        *goredis.Client // optional — nil disables Redis-backed features (dev mode)
 	RoostDataDir string          // absolute path to Roost data directory for disk stats
 	Version      string          // build-time version constant
+	Scanner      *library.Manager // optional — nil disables POST /admin/storage/scan
+	AntBoxes     *AntBoxHub       // optional — nil disables /antbox/connect and device commands
 	startTime    time.Time       // process start time for uptime calculation
 }
 
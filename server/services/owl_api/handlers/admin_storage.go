@@ -2,13 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/unyeco/roost/services/library"
 	"github.com/unyeco/roost/services/owl_api/audit"
 	"github.com/unyeco/roost/services/owl_api/middleware"
 )
@@ -116,6 +117,14 @@ func (h *AdminHandlers) AddStoragePath(w http.ResponseWriter, r *http.Request, a
 		map[string]any{"path_type": req.PathType, "display_name": req.DisplayName},
 	)
 
+	// Index existing content once, then let the watcher pick up changes.
+	if h.Scanner != nil && (req.PathType == "local" || req.PathType == "nfs") {
+		h.Scanner.PathsChanged()
+		if _, err := h.Scanner.Enqueue(claims.RoostID, rowID); err != nil {
+			slog.Warn("admin/storage/add: initial scan not queued", "path_id", rowID, "err", err)
+		}
+	}
+
 	writeAdminJSON(w, http.StatusCreated, map[string]string{"id": rowID})
 }
 
@@ -162,6 +171,10 @@ func (h *AdminHandlers) RemoveStoragePath(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if h.Scanner != nil {
+		h.Scanner.PathsChanged()
+	}
+
 	al.Log(r, claims.RoostID, claims.UserID, "storage.remove", pathID, nil)
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -177,21 +190,38 @@ type ScanJobResponse struct {
 }
 
 // TriggerScan handles POST /admin/storage/scan.
-// Enqueues a background scan job and responds 202 Accepted.
+// Enqueues a background library scan job and responds 202 Accepted.
+// Responds 409 with the active job id when a scan is already queued or running.
 func (h *AdminHandlers) TriggerScan(w http.ResponseWriter, r *http.Request, al *audit.Logger) {
 	claims := middleware.AdminClaimsFromCtx(r.Context())
 
 	var req TriggerScanRequest
 	_ = json.NewDecoder(r.Body).Decode(&req)
 
-	jobID := uuid.New().String()
+	if h.Scanner == nil {
+		http.Error(w, `{"error":"library scanner not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
 
-	// Enqueue scan job — write to Redis key roost:scan_jobs:{roost_id}
-	// When Redis is wired, replace with: h.Redis.LPush(ctx, key, payload)
-	// For now, log the job intent and proceed
 	targetID := "all"
+	pathID := ""
 	if req.PathID != nil {
-		targetID = *req.PathID
+		if !isValidUUID(*req.PathID) {
+			http.Error(w, `{"error":"invalid path_id"}`, http.StatusBadRequest)
+			return
+		}
+		targetID, pathID = *req.PathID, *req.PathID
+	}
+
+	jobID, err := h.Scanner.Enqueue(claims.RoostID, pathID)
+	if errors.Is(err, library.ErrScanInProgress) {
+		writeAdminJSON(w, http.StatusConflict, ScanJobResponse{JobID: jobID})
+		return
+	}
+	if err != nil {
+		slog.Error("scan enqueue failed", "roost_id", claims.RoostID, "err", err)
+		http.Error(w, `{"error":"scan queue full"}`, http.StatusServiceUnavailable)
+		return
 	}
 
 	slog.Info("scan job enqueued",
@@ -219,18 +249,25 @@ type ScanStatusResponse struct {
 }
 
 // ScanStatus handles GET /admin/storage/scan/status.
+// Reports the roost's most recent scan job (zero values when none has run).
 func (h *AdminHandlers) ScanStatus(w http.ResponseWriter, r *http.Request) {
-	_ = middleware.AdminClaimsFromCtx(r.Context())
-
-	// Read from Redis key roost:scan_status:{roost_id}
-	// When Redis is wired, replace with real Redis read.
-	resp := ScanStatusResponse{
-		Running:         false,
-		JobID:           "",
-		FilesScanned:    0,
-		FilesFound:      0,
-		Errors:          0,
-		PercentComplete: 0,
+	claims := middleware.AdminClaimsFromCtx(r.Context())
+
+	var resp ScanStatusResponse
+	if h.Scanner != nil {
+		p := h.Scanner.Status(claims.RoostID)
+		resp = ScanStatusResponse{
+			Running:         p.Running(),
+			JobID:           p.JobID,
+			FilesScanned:    p.FilesScanned,
+			FilesFound:      p.FilesFound,
+			Errors:          p.Errors,
+			PercentComplete: p.PercentComplete,
+		}
+		if p.Running() && p.EstimatedFinish != nil {
+			eta := p.EstimatedFinish.UTC().Format(time.RFC3339)
+			resp.EstimatedFinish = &eta
+		}
 	}
 	writeAdminJSON(w, http.StatusOK, resp)
 }
@@ -265,7 +302,7 @@ func (h *AdminHandlers) ListDuplicates(w http.ResponseWriter, r *http.Request) {
 		            ) ORDER BY created_at ASC
 		        ) AS copies
 		   FROM recordings
-		  WHERE roost_id = $1 AND content_hash IS NOT NULL
+		  WHERE roost_id = $1 AND content_hash IS NOT NULL AND NOT is_missing
 		  GROUP BY content_hash
 		 HAVING COUNT(*) > 1
 		  ORDER BY COUNT(*) DESC`,
@@ -20,13 +20,15 @@ type ScanProgressEvent struct {
 	Errors          int     `json:"errors"`
 	PercentComplete float64 `json:"percent_complete"`
 	CurrentPath     string  `json:"current_path,omitempty"`
+	EstimatedFinish string  `json:"estimated_finish,omitempty"` // RFC 3339, while running
 }
 
 // StorageScanStream handles GET /admin/storage/scan/stream.
 // Streams Server-Sent Events with real-time scan progress.
 // When Redis is configured (h.Redis != nil), events arrive via Redis pub/sub on
-// channel "roost:scan_progress:{roostID}". Without Redis, the handler sends
-// periodic heartbeats so clients stay connected without error.
+// channel "roost:scan_progress:{roostID}". Without Redis, events come from the
+// in-process library scanner; with neither, the handler sends periodic
+// heartbeats so clients stay connected without error.
 // Maximum connection lifetime is 30 minutes to prevent resource leaks.
 func (h *AdminHandlers) StorageScanStream(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.AdminClaimsFromCtx(r.Context())
@@ -86,10 +88,35 @@ func (h *AdminHandlers) StorageScanStream(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	// No-Redis fallback: heartbeat-only mode. Clients will not receive scan events
-	// but the connection stays alive without error. Log at WARN so operators know
-	// Redis is not configured.
-	slog.Warn("StorageScanStream: Redis not configured — scan events unavailable, heartbeat only",
+	// No-Redis fallback: progress comes straight from the in-process scanner.
+	if h.Scanner != nil {
+		events, unsubscribe := h.Scanner.Subscribe(claims.RoostID)
+		defer unsubscribe()
+
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fmt.Fprintf(w, ": heartbeat\n\n")
+				flusher.Flush()
+			case payload := <-events:
+				fmt.Fprintf(w, "data: %s\n\n", payload)
+				flusher.Flush()
+				if strings.Contains(string(payload), `"status":"complete"`) ||
+					strings.Contains(string(payload), `"status":"error"`) {
+					return
+				}
+			}
+		}
+	}
+
+	// No scanner either: heartbeat-only mode. Clients will not receive scan
+	// events but the connection stays alive without error. Log at WARN so
+	// operators know scanning is not configured.
+	slog.Warn("StorageScanStream: no Redis or scanner configured — scan events unavailable, heartbeat only",
 		"roost_id", claims.RoostID)
 
 	ticker := time.NewTicker(5 * time.Second)
@@ -18,9 +18,13 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -129,6 +133,127 @@ func TestComputeAntBoxStatus(t *testing.T) {
 	}
 }
 
+// ── parseAntBoxEventPath ──────────────────────────────────────────────────────
+
+func TestParseAntBoxEventPath(t *testing.T) {
+	tests := []struct {
+		path          string
+		event, action string
+		segment       string
+		ok            bool
+	}{
+		{"/antbox/events/ev-1/segments/1760000000-000001.ts", "ev-1", "segments", "1760000000-000001.ts", true},
+		{"/antbox/events/ev_2/complete", "ev_2", "complete", "", true},
+		{"/antbox/events/ev-1/segments/../../etc/passwd", "", "", "", false},
+		{"/antbox/events/../segments/1-1.ts", "", "", "", false},
+		{"/antbox/events/ev-1/segments/index.m3u8", "", "", "", false},
+		{"/antbox/events/ev-1", "", "", "", false},
+	}
+	for _, tc := range tests {
+		event, action, segment, ok := parseAntBoxEventPath(tc.path)
+		if event != tc.event || action != tc.action || segment != tc.segment || ok != tc.ok {
+			t.Errorf("parseAntBoxEventPath(%q) = (%q, %q, %q, %v), want (%q, %q, %q, %v)",
+				tc.path, event, action, segment, ok, tc.event, tc.action, tc.segment, tc.ok)
+		}
+	}
+}
+
+// ── AntBox events ────────────────────────────────────────────────────────────
+
+func TestParseAntBoxEventsPath(t *testing.T) {
+	box := "11111111-1111-1111-1111-111111111111"
+	ev := "22222222-2222-2222-2222-222222222222"
+	tests := []struct {
+		path       string
+		box, event string
+		ok         bool
+	}{
+		{"/admin/antboxes/" + box + "/events", box, "", true},
+		{"/admin/antboxes/" + box + "/events/" + ev, box, ev, true},
+		{"/admin/antboxes/" + box + "/events/nope", "", "", false},
+		{"/admin/antboxes/nope/events", "", "", false},
+		{"/admin/antboxes/" + box + "/signal", "", "", false},
+	}
+	for _, tc := range tests {
+		gotBox, gotEvent, ok := parseAntBoxEventsPath(tc.path)
+		if gotBox != tc.box || gotEvent != tc.event || ok != tc.ok {
+			t.Errorf("parseAntBoxEventsPath(%q) = (%q, %q, %v), want (%q, %q, %v)",
+				tc.path, gotBox, gotEvent, ok, tc.box, tc.event, tc.ok)
+		}
+	}
+}
+
+func TestPickAntBoxTuner(t *testing.T) {
+	report := &AntBoxReport{Devices: []AntBoxDeviceStatus{
+		{IP: "10.0.0.2", Online: false, Tuners: []AntBoxTunerStatus{{Index: 0}}},
+		{IP: "10.0.0.3", Online: true, Tuners: []AntBoxTunerStatus{{Index: 0, Active: true}, {Index: 1}}},
+		{IP: "10.0.0.4", Online: true, Tuners: []AntBoxTunerStatus{{Index: 0, Active: true}}},
+	}}
+
+	ip, tuner, err := pickAntBoxTuner(report, "", nil)
+	if err != nil || ip != "10.0.0.3" || tuner != 1 {
+		t.Errorf("any idle tuner: got (%q, %d, %v), want (10.0.0.3, 1)", ip, tuner, err)
+	}
+	if _, _, err := pickAntBoxTuner(report, "10.0.0.4", nil); err == nil {
+		t.Error("busy device: want an error")
+	}
+	if _, _, err := pickAntBoxTuner(report, "10.0.0.2", nil); err == nil {
+		t.Error("offline device: want an error")
+	}
+	two := 2
+	ip, tuner, err = pickAntBoxTuner(report, "10.0.0.4", &two)
+	if err != nil || ip != "10.0.0.4" || tuner != 2 {
+		t.Errorf("pinned tuner: got (%q, %d, %v), want (10.0.0.4, 2)", ip, tuner, err)
+	}
+}
+
+// ── putAntBoxSegment / complete ──────────────────────────────────────────────
+
+func TestAntBoxSegmentsAfterComplete(t *testing.T) {
+	h := &AdminHandlers{}
+	dir := t.TempDir()
+	put := func(name, duration string) int {
+		r := httptest.NewRequest(http.MethodPut, "/antbox/events/ev-1/segments/"+name, strings.NewReader("ts"))
+		r.Header.Set("X-Segment-Duration", duration)
+		rec := httptest.NewRecorder()
+		h.putAntBoxSegment(rec, r, dir, name)
+		return rec.Code
+	}
+
+	if code := put("1-1.ts", "6.006"); code != http.StatusNoContent {
+		t.Fatalf("first segment: status %d", code)
+	}
+	if code := put("1-2.ts", "13"); code != http.StatusBadRequest {
+		t.Errorf("segment over the target duration: status %d, want 400", code)
+	}
+	for i := 0; i < 2; i++ {
+		if err := appendAntBoxPlaylist(dir, "#EXT-X-ENDLIST\n"); err != nil && !errors.Is(err, errAntBoxEventEnded) {
+			t.Fatal(err)
+		}
+	}
+	if code := put("1-1.ts", "6.006"); code != http.StatusNoContent {
+		t.Errorf("re-sent segment after complete: status %d, want 204", code)
+	}
+	if code := put("1-3.ts", "6.006"); code != http.StatusConflict {
+		t.Errorf("new segment after complete: status %d, want 409", code)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlist := string(data)
+	if n := strings.Count(playlist, "#EXT-X-ENDLIST"); n != 1 {
+		t.Errorf("ENDLIST written %d times:\n%s", n, playlist)
+	}
+	if strings.Contains(playlist, "1-2.ts") || strings.Contains(playlist, "1-3.ts") {
+		t.Errorf("rejected segments in playlist:\n%s", playlist)
+	}
+	if !strings.Contains(playlist, "#EXT-X-TARGETDURATION:12\n") {
+		t.Errorf("unexpected target duration:\n%s", playlist)
+	}
+}
+
 // ── isValidUUID ───────────────────────────────────────────────────────────────
 
 func TestIsValidUUID(t *testing.T) {
@@ -453,3 +578,121 @@ func TestScanStatusReturnsRequiredFields(t *testing.T) {
 		}
 	}
 }
+
+// ── allocateTuners ────────────────────────────────────────────────────────────
+
+func testTunerSource(kind, id string, capacity int, channels ...string) *TunerSource {
+	s := &TunerSource{Kind: kind, ID: id, Name: id, Capacity: capacity, channels: map[string]bool{}}
+	for _, c := range channels {
+		s.channels[c] = true
+	}
+	return s
+}
+
+func testRecording(id string, start time.Time, hours int, priority int, sourceID, channelKey string) tunerDemand {
+	return tunerDemand{
+		ID: id, Kind: tunerDemandRecording, Title: id, ChannelKey: channelKey,
+		Start: start, End: start.Add(time.Duration(hours) * time.Hour), Priority: priority,
+		SourceKind: tunerKindIPTV, SourceID: sourceID,
+	}
+}
+
+func TestAllocateTunersFallsBackToSourceCarryingChannel(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC)
+	sources := []*TunerSource{
+		testTunerSource(tunerKindIPTV, "a", 1, "bbc1.uk"),
+		testTunerSource(tunerKindIPTV, "b", 1, "bbc1.uk"),
+	}
+	demands := []tunerDemand{
+		testRecording("r1", t0, 1, 0, "a", "bbc1.uk"),
+		testRecording("r2", t0, 1, 0, "a", "bbc1.uk"),
+	}
+	got, conflicts := allocateTuners(sources, demands)
+	if len(conflicts) != 0 {
+		t.Fatalf("conflicts = %+v, want none", conflicts)
+	}
+	if got["r1"].SourceID != "a" || got["r1"].Fallback {
+		t.Errorf("r1 = %+v, want requested source a", got["r1"])
+	}
+	if got["r2"].SourceID != "b" || !got["r2"].Fallback {
+		t.Errorf("r2 = %+v, want fallback to b", got["r2"])
+	}
+}
+
+func TestAllocateTunersUsesFullCapacity(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC)
+	sources := []*TunerSource{testTunerSource(tunerKindIPTV, "a", 2)}
+	demands := []tunerDemand{
+		testRecording("r1", t0, 2, 0, "a", ""),
+		testRecording("r2", t0.Add(time.Hour), 2, 0, "a", ""),
+		testRecording("r3", t0.Add(2*time.Hour), 1, 0, "a", ""), // starts as r1 ends
+	}
+	got, conflicts := allocateTuners(sources, demands)
+	if len(conflicts) != 0 {
+		t.Fatalf("conflicts = %+v, want none", conflicts)
+	}
+	if len(got) != 3 {
+		t.Errorf("assigned %d demands, want 3", len(got))
+	}
+}
+
+func TestAllocateTunersHigherPriorityWins(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC)
+	sources := []*TunerSource{testTunerSource(tunerKindIPTV, "a", 1)}
+	demands := []tunerDemand{
+		testRecording("low", t0, 1, 0, "a", ""),
+		testRecording("high", t0.Add(30*time.Minute), 1, 5, "a", ""),
+	}
+	got, conflicts := allocateTuners(sources, demands)
+	if _, ok := got["high"]; !ok {
+		t.Fatal("high-priority recording was not allocated")
+	}
+	if len(conflicts) != 1 || conflicts[0].ID != "low" {
+		t.Fatalf("conflicts = %+v, want only low", conflicts)
+	}
+	if len(conflicts[0].BlockedBy) != 1 || conflicts[0].BlockedBy[0].ID != "high" {
+		t.Errorf("BlockedBy = %+v, want high", conflicts[0].BlockedBy)
+	}
+	if conflicts[0].Suggestion == "" {
+		t.Error("conflict has no suggestion")
+	}
+}
+
+func TestAllocateTunersKeepsPinnedCapture(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC)
+	sources := []*TunerSource{testTunerSource(tunerKindAntBox, "box", 1)}
+	capture := tunerDemand{
+		ID: "ev1", Kind: tunerDemandCapture,
+		Start: t0, End: t0.Add(time.Hour),
+		SourceKind: tunerKindAntBox, SourceID: "box", Pinned: true,
+	}
+	rec := testRecording("rec", t0, 1, 10, "box", "")
+	rec.SourceKind = tunerKindAntBox
+	got, conflicts := allocateTuners(sources, []tunerDemand{rec, capture})
+	if _, ok := got["ev1"]; !ok {
+		t.Fatal("running capture was displaced")
+	}
+	if len(conflicts) != 1 || conflicts[0].ID != "rec" {
+		t.Fatalf("conflicts = %+v, want rec", conflicts)
+	}
+}
+
+// ── parseM3UChannels ──────────────────────────────────────────────────────────
+
+func TestParseM3UChannels(t *testing.T) {
+	playlist := `#EXTM3U
+#EXTINF:-1 tvg-id="BBC1.uk" tvg-name="BBC One" group-title="UK",BBC One HD
+http://example.test/1.ts
+#EXTINF:-1 tvg-id="" ,No Guide
+http://example.test/2.ts
+#EXTINF:-1 group-title="Misc",Untagged
+http://example.test/3.ts
+`
+	got, err := parseM3UChannels(strings.NewReader(playlist))
+	if err != nil {
+		t.Fatalf("parseM3UChannels: %v", err)
+	}
+	if len(got) != 1 || got["bbc1.uk"] != "BBC One HD" {
+		t.Errorf("got %v, want map[bbc1.uk:BBC One HD]", got)
+	}
+}
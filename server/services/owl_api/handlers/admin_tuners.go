@@ -0,0 +1,479 @@
+// admin_tuners.go — Tuner and provider-connection capacity for the admin DVR
+// schedule and AntBox captures.
+//
+// Capacity comes from two kinds of source:
+//
+//	antbox  antboxes.tuner_count (kept current by device heartbeats)
+//	iptv    iptv_sources.max_connections (Xtream user_info; NULL counts as 1)
+//
+// Demands are the admin DVR schedule (dvr_schedule) and AntBox captures
+// (antbox_events). A scheduled recording asks for its own source
+// (dvr_schedule.channel_id) first and, when it is full, may fall back to any
+// other source whose lineup (roost_source_channels, written by the IPTV
+// refresh) carries the same channel_key. A capture needs a tuner on its own
+// AntBox. When nothing is free, higher priority wins; the losers are reported
+// as conflicts together with the demands holding the tuners they needed.
+//
+// New demands are admitted (admitTunerDemand) under a per-roost advisory lock
+// (lockTuners), so two requests cannot both take the last tuner. Nothing is
+// stored: the allocation is recomputed for every view. Recordings made by the
+// dvr service (dvr_recordings) are not counted here — they record from the
+// ingest pipeline's segments, which hold one provider connection per channel
+// however many recordings share it.
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/unyeco/roost/services/owl_api/middleware"
+)
+
+// Tuner source kinds (roost_source_channels.source_kind).
+const (
+	tunerKindIPTV   = "iptv"
+	tunerKindAntBox = "antbox"
+)
+
+// Demand kinds.
+const (
+	tunerDemandRecording = "recording"
+	tunerDemandCapture   = "antbox_event"
+)
+
+// TunerSource is one pool of tuners or provider connections.
+type TunerSource struct {
+	Kind     string `json:"kind"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Capacity int    `json:"capacity"`
+	InUse    int    `json:"in_use"`
+
+	channels map[string]bool
+}
+
+func (s *TunerSource) carries(channelKey string) bool {
+	return channelKey != "" && s.channels[channelKey]
+}
+
+// tunerDemand is a recording or AntBox capture that needs one unit of
+// capacity for [Start, End).
+type tunerDemand struct {
+	ID         string
+	Kind       string
+	Title      string
+	ChannelKey string
+	Start, End time.Time
+	Priority   int
+
+	// Requested source. For pinned demands (captures already running) this is
+	// where the demand is and it is never moved.
+	SourceKind string
+	SourceID   string
+	Pinned     bool
+}
+
+// tunerAssignment is where the allocator placed a demand.
+type tunerAssignment struct {
+	SourceKind string
+	SourceID   string
+	Fallback   bool // not the requested source
+}
+
+// TunerBlocker is a demand holding capacity another demand needed.
+type TunerBlocker struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Title     string    `json:"title,omitempty"`
+	SourceID  string    `json:"source_id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Priority  int       `json:"priority"`
+}
+
+// TunerConflict is a demand the allocator could not place.
+type TunerConflict struct {
+	ID         string         `json:"id"`
+	Kind       string         `json:"kind"`
+	Title      string         `json:"title,omitempty"`
+	ChannelKey string         `json:"channel_key,omitempty"`
+	StartTime  time.Time      `json:"start_time"`
+	EndTime    time.Time      `json:"end_time"`
+	Priority   int            `json:"priority"`
+	BlockedBy  []TunerBlocker `json:"blocked_by"`
+	Suggestion string         `json:"suggestion"`
+}
+
+func sourceKey(kind, id string) string { return kind + ":" + id }
+
+// allocateTuners places demands on sources. Pinned demands are placed first
+// and always kept; the rest go in priority order (highest first, captures
+// before recordings on ties, then earliest start) onto the first candidate
+// source with a free unit for the whole window.
+func allocateTuners(sources []*TunerSource, demands []tunerDemand) (map[string]tunerAssignment, []TunerConflict) {
+	byKey := make(map[string]*TunerSource, len(sources))
+	for _, s := range sources {
+		byKey[sourceKey(s.Kind, s.ID)] = s
+	}
+
+	order := make([]tunerDemand, len(demands))
+	copy(order, demands)
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if (a.Kind == tunerDemandCapture) != (b.Kind == tunerDemandCapture) {
+			return a.Kind == tunerDemandCapture
+		}
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return a.ID < b.ID
+	})
+
+	placed := make(map[string][]tunerDemand) // source key → demands on it
+	assigned := make(map[string]tunerAssignment, len(order))
+	var conflicts []TunerConflict
+
+	for _, d := range order {
+		requested := sourceKey(d.SourceKind, d.SourceID)
+		if d.Pinned {
+			placed[requested] = append(placed[requested], d)
+			assigned[d.ID] = tunerAssignment{SourceKind: d.SourceKind, SourceID: d.SourceID}
+			continue
+		}
+
+		var candidates []*TunerSource
+		if s, ok := byKey[requested]; ok {
+			candidates = append(candidates, s)
+		}
+		for _, s := range sources {
+			if sourceKey(s.Kind, s.ID) != requested && s.carries(d.ChannelKey) {
+				candidates = append(candidates, s)
+			}
+		}
+
+		ok := false
+		for _, s := range candidates {
+			key := sourceKey(s.Kind, s.ID)
+			if peakUsage(placed[key], d.Start, d.End) < s.Capacity {
+				placed[key] = append(placed[key], d)
+				assigned[d.ID] = tunerAssignment{SourceKind: s.Kind, SourceID: s.ID, Fallback: key != requested}
+				ok = true
+				break
+			}
+		}
+		if ok {
+			continue
+		}
+
+		c := TunerConflict{
+			ID: d.ID, Kind: d.Kind, Title: d.Title, ChannelKey: d.ChannelKey,
+			StartTime: d.Start, EndTime: d.End, Priority: d.Priority,
+			BlockedBy: []TunerBlocker{},
+		}
+		for _, s := range candidates {
+			for _, o := range placed[sourceKey(s.Kind, s.ID)] {
+				if o.Start.Before(d.End) && o.End.After(d.Start) {
+					c.BlockedBy = append(c.BlockedBy, TunerBlocker{
+						ID: o.ID, Kind: o.Kind, Title: o.Title, SourceID: s.ID,
+						StartTime: o.Start, EndTime: o.End, Priority: o.Priority,
+					})
+				}
+			}
+		}
+		switch {
+		case len(candidates) == 0:
+			c.Suggestion = "Its source is no longer active; pick another source for the channel"
+		case d.ChannelKey == "":
+			c.Suggestion = "Set a channel_key so it can fall back to another source carrying the channel"
+		case len(candidates) == 1:
+			c.Suggestion = "No other source carries this channel; add a source or tuner, or raise its priority"
+		default:
+			c.Suggestion = "Every source carrying this channel is busy; raise its priority or reschedule"
+		}
+		conflicts = append(conflicts, c)
+	}
+	return assigned, conflicts
+}
+
+// peakUsage returns the most demands in ds active at one instant within [start, end).
+func peakUsage(ds []tunerDemand, start, end time.Time) int {
+	peak := 0
+	for _, probe := range ds {
+		t := probe.Start
+		if t.Before(start) {
+			t = start
+		}
+		if !t.Before(end) || !probe.End.After(t) {
+			continue
+		}
+		n := 0
+		for _, o := range ds {
+			if !o.Start.After(t) && o.End.After(t) {
+				n++
+			}
+		}
+		if n > peak {
+			peak = n
+		}
+	}
+	return peak
+}
+
+// tunerQuerier is satisfied by *sql.DB and *sql.Tx.
+type tunerQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// lockTuners takes the roost's tuner allocation lock until tx ends.
+func lockTuners(ctx context.Context, tx *sql.Tx, roostID string) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('tuners:' || $1))`, roostID)
+	return err
+}
+
+// loadTunerSources returns the roost's active sources with their lineups.
+func loadTunerSources(ctx context.Context, q tunerQuerier, roostID string) ([]*TunerSource, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT 'iptv', id::text, display_name, COALESCE(max_connections, 1), created_at
+		   FROM iptv_sources
+		  WHERE roost_id = $1 AND is_active = TRUE
+		 UNION ALL
+		 SELECT 'antbox', id::text, display_name, tuner_count, created_at
+		   FROM antboxes
+		  WHERE roost_id = $1 AND is_active = TRUE
+		  ORDER BY 5`,
+		roostID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var sources []*TunerSource
+	byKey := map[string]*TunerSource{}
+	for rows.Next() {
+		s := &TunerSource{channels: map[string]bool{}}
+		var created time.Time
+		if err := rows.Scan(&s.Kind, &s.ID, &s.Name, &s.Capacity, &created); err != nil {
+			continue
+		}
+		sources = append(sources, s)
+		byKey[sourceKey(s.Kind, s.ID)] = s
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.QueryContext(ctx,
+		`SELECT source_kind, source_id::text, channel_key
+		   FROM roost_source_channels
+		  WHERE roost_id = $1`,
+		roostID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, id, key string
+		if err := rows.Scan(&kind, &id, &key); err != nil {
+			continue
+		}
+		if s, ok := byKey[sourceKey(kind, id)]; ok {
+			s.channels[key] = true
+		}
+	}
+	return sources, rows.Err()
+}
+
+// loadTunerDemands returns scheduled recordings and AntBox captures that have
+// not ended. A recording holds its tuner from start_time - padding_before_secs
+// to end_time + padding_after_secs; a running capture is pinned to its AntBox.
+func loadTunerDemands(ctx context.Context, q tunerQuerier, roostID string, now time.Time) ([]tunerDemand, error) {
+	var demands []tunerDemand
+
+	rows, err := q.QueryContext(ctx,
+		`SELECT id::text, title, COALESCE(channel_key, ''), channel_id::text,
+		        start_time - make_interval(secs => padding_before_secs),
+		        end_time + make_interval(secs => padding_after_secs),
+		        priority
+		   FROM dvr_schedule
+		  WHERE roost_id = $1
+		    AND status IN ('scheduled', 'recording')
+		    AND end_time + make_interval(secs => padding_after_secs) > $2`,
+		roostID, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		d := tunerDemand{Kind: tunerDemandRecording, SourceKind: tunerKindIPTV}
+		if err := rows.Scan(&d.ID, &d.Title, &d.ChannelKey, &d.SourceID, &d.Start, &d.End, &d.Priority); err != nil {
+			continue
+		}
+		demands = append(demands, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.QueryContext(ctx,
+		`SELECT id::text, title, antbox_id::text, start_time, end_time, status
+		   FROM antbox_events
+		  WHERE roost_id = $1
+		    AND status IN ('scheduled', 'capturing', 'stopping')
+		    AND end_time > $2`,
+		roostID, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d := tunerDemand{Kind: tunerDemandCapture, SourceKind: tunerKindAntBox}
+		var status string
+		if err := rows.Scan(&d.ID, &d.Title, &d.SourceID, &d.Start, &d.End, &status); err != nil {
+			continue
+		}
+		d.Pinned = status != "scheduled"
+		demands = append(demands, d)
+	}
+	return demands, rows.Err()
+}
+
+// admitTunerDemand allocates the roost's demands together with d, which must
+// have an ID no stored demand uses. It returns d's conflict (nil when d gets a
+// tuner) and the conflicts of existing demands d displaces, with d's ID in
+// their BlockedBy. tx must hold lockTuners until d is stored.
+func admitTunerDemand(ctx context.Context, tx *sql.Tx, roostID string, d tunerDemand) (*TunerConflict, []TunerConflict, error) {
+	sources, err := loadTunerSources(ctx, tx, roostID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load sources: %w", err)
+	}
+	demands, err := loadTunerDemands(ctx, tx, roostID, time.Now())
+	if err != nil {
+		return nil, nil, fmt.Errorf("load demands: %w", err)
+	}
+	_, conflicts := allocateTuners(sources, append(demands, d))
+	displaced := []TunerConflict{}
+	for _, c := range conflicts {
+		if c.ID == d.ID {
+			c := c
+			return &c, nil, nil
+		}
+		for _, b := range c.BlockedBy {
+			if b.ID == d.ID {
+				displaced = append(displaced, c)
+				break
+			}
+		}
+	}
+	return nil, displaced, nil
+}
+
+// tunerAllocation is a roost's computed allocation.
+type tunerAllocation struct {
+	sources   []*TunerSource // InUse counts demands placed on each at now
+	demands   []tunerDemand
+	assigned  map[string]tunerAssignment
+	conflicts []TunerConflict // never nil
+}
+
+// computeTuners loads a roost's sources and demands and allocates them, for
+// the read-only views.
+func computeTuners(ctx context.Context, q tunerQuerier, roostID string, now time.Time) (*tunerAllocation, error) {
+	sources, err := loadTunerSources(ctx, q, roostID)
+	if err != nil {
+		return nil, fmt.Errorf("load sources: %w", err)
+	}
+	demands, err := loadTunerDemands(ctx, q, roostID, now)
+	if err != nil {
+		return nil, fmt.Errorf("load demands: %w", err)
+	}
+	assigned, conflicts := allocateTuners(sources, demands)
+
+	byKey := make(map[string]*TunerSource, len(sources))
+	for _, s := range sources {
+		byKey[sourceKey(s.Kind, s.ID)] = s
+	}
+	for _, d := range demands {
+		a, ok := assigned[d.ID]
+		if !ok || d.Start.After(now) || !d.End.After(now) {
+			continue
+		}
+		if s := byKey[sourceKey(a.SourceKind, a.SourceID)]; s != nil {
+			s.InUse++
+		}
+	}
+	if conflicts == nil {
+		conflicts = []TunerConflict{}
+	}
+	return &tunerAllocation{sources: sources, demands: demands, assigned: assigned, conflicts: conflicts}, nil
+}
+
+// normalizeChannelKey lowercases and trims a tvg-id / EPG channel id.
+func normalizeChannelKey(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// ListTuners handles GET /admin/tuners.
+// Returns every source with its capacity and current use, plus the number of
+// unresolved conflicts.
+func (h *AdminHandlers) ListTuners(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.AdminClaimsFromCtx(r.Context())
+
+	alloc, err := computeTuners(r.Context(), h.DB, claims.RoostID, time.Now())
+	if err != nil {
+		slog.Error("tuners: allocation failed", "err", err)
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+	sources := alloc.sources
+	if sources == nil {
+		sources = []*TunerSource{}
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"sources":   sources,
+		"conflicts": len(alloc.conflicts),
+	})
+}
+
+// replaceSourceChannels swaps a source's lineup for channels (channel_key →
+// display name).
+func replaceSourceChannels(ctx context.Context, db *sql.DB, roostID, kind, sourceID string, channels map[string]string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM roost_source_channels WHERE source_kind = $1 AND source_id = $2`,
+		kind, sourceID,
+	); err != nil {
+		return err
+	}
+	for key, name := range channels {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO roost_source_channels (roost_id, source_kind, source_id, channel_key, display_name)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			roostID, kind, sourceID, key, name,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -1,12 +1,14 @@
 // main.go — Roost Recommendations Service.
 // Calculates personalized content recommendations using genre affinity,
-// popularity, and collaborative filtering — all SQL-based, no ML required.
+// popularity, and item-to-item collaborative filtering (similarity.go) — all
+// computed in-process, no ML service required.
 // Port: 8099 (env: RECO_PORT). Internal service — called by owl_api.
 //
 // Routes:
-//   GET /recommendations/:subscriber_id  — personalized recommendations
+//   GET /recommendations/:subscriber_id  — personalized recommendations (?profile_id= scopes to one profile)
 //   GET /recommendations/trending        — site-wide trending (no auth)
-//   POST /internal/reco/refresh          — force genre affinity recalculation
+//   GET /recommendations/similar/{type}/{id} — "more like this" (movie|series|episode|music|channel)
+//   POST /internal/reco/refresh          — rebuild item similarity now
 //   GET /health
 package main
 
@@ -63,29 +65,67 @@ type recItem struct {
 	Score     float64 `json:"score,omitempty"`
 }
 
-type server struct{ db *sql.DB }
+// viewerInteractions and viewerProgress restrict interactions and
+// watch_progress rows to the requested profile ($2). With no profile the
+// whole household counts; channel interactions always do, since live viewing
+// isn't recorded per profile.
+const (
+	viewerInteractions = `($2 = '' OR profile_id = $2 OR profile_id IS NULL)`
+	viewerProgress     = `($2 = '' OR profile_id::text = $2)`
+)
+
+// cfBlendWeight is the share of the personalized score that comes from
+// collaborative filtering when the household has neighbours to draw on.
+const cfBlendWeight = 0.5
+
+type server struct {
+	db  *sql.DB
+	sim *similarityJob
+}
+
+// personalized returns scored recommendations for one profile (or the whole
+// household when profileID is empty): the content-based score blended with
+// collaborative filtering (blendScores). A collaborative failure degrades to
+// content-based results.
+func (s *server) personalized(ctx context.Context, subscriberID, profileID string) ([]recItem, error) {
+	content, err := s.contentBased(ctx, subscriberID, profileID)
+	if err != nil {
+		return nil, err
+	}
+	collab, err := s.collaborative(ctx, subscriberID, profileID)
+	if err != nil {
+		log.Printf("[reco] collaborative scores for %s: %v", subscriberID, err)
+		collab = nil
+	}
+	return blendScores(content, collab, 20), nil
+}
 
-// personalized returns scored recommendations for one subscriber.
+// contentBased scores catalog titles for one profile.
 // Score = genre_affinity(0.4) + popularity(0.3) + recency(0.2) + rating_match(0.1).
-// Excludes already-completed items. Handles cold-start (no history) by returning trending.
-func (s *server) personalized(ctx context.Context, subscriberID string) ([]recItem, error) {
+// Episodes count toward their series' genre and popularity. Excludes
+// completed movies and series already being watched.
+func (s *server) contentBased(ctx context.Context, subscriberID, profileID string) ([]recItem, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		WITH total_watches AS (
-			SELECT COUNT(*)::float AS n FROM watch_progress
+		WITH interactions AS (`+interactionsSQL+`),
+		total_watches AS (
+			SELECT COUNT(*)::float AS n FROM interactions WHERE item_type IN ('movie','series')
 		),
 		genre_affinity AS (
 			SELECT c.genre,
 			       SUM(wp.position_seconds)::float /
 			           GREATEST(SUM(SUM(wp.position_seconds)) OVER (), 1) AS score
 			FROM watch_progress wp
-			JOIN vod_catalog c ON c.id = wp.content_id AND wp.content_type = 'movie'
-			WHERE wp.subscriber_id = $1 AND c.genre IS NOT NULL
+			LEFT JOIN vod_episodes e ON wp.content_type = 'episode' AND e.id = wp.content_id
+			LEFT JOIN vod_series vs ON vs.id = e.series_id
+			JOIN vod_catalog c ON c.id = CASE WHEN wp.content_type = 'movie'
+			                                  THEN wp.content_id ELSE vs.catalog_id END
+			WHERE wp.subscriber_id = $1 AND `+viewerProgress+` AND c.genre IS NOT NULL
 			GROUP BY c.genre
 		),
 		content_popularity AS (
-			SELECT content_id,
+			SELECT item_id,
 			       COUNT(*)::float / GREATEST((SELECT n FROM total_watches), 1) AS pop_score
-			FROM watch_progress GROUP BY content_id
+			FROM interactions WHERE item_type IN ('movie','series') GROUP BY item_id
 		),
 		scored AS (
 			SELECT vc.id, vc.title, vc.type, vc.genre, vc.poster_url,
@@ -97,17 +137,22 @@ func (s *server) personalized(ctx context.Context, subscriberID string) ([]recIt
 			            ELSE 0.0 END AS rec_score
 			FROM vod_catalog vc
 			LEFT JOIN genre_affinity ga ON ga.genre = vc.genre
-			LEFT JOIN content_popularity cp ON cp.content_id = vc.id
+			LEFT JOIN content_popularity cp ON cp.item_id = vc.id::text
 			WHERE vc.is_active = true
 			  AND NOT EXISTS (
 			      SELECT 1 FROM watch_progress wp2
-			      WHERE wp2.subscriber_id = $1
+			      WHERE wp2.subscriber_id = $1 AND `+viewerProgress+`
 			        AND wp2.content_id = vc.id
 			        AND wp2.completed = true
 			  )
+			  AND NOT EXISTS (
+			      SELECT 1 FROM interactions i
+			      WHERE i.subscriber_id = $1::text AND `+viewerInteractions+`
+			        AND i.item_type = 'series' AND i.item_id = vc.id::text
+			  )
 		)
 		SELECT id, title, type, genre, poster_url, rec_score
-		FROM scored ORDER BY rec_score DESC LIMIT 20`, subscriberID)
+		FROM scored ORDER BY rec_score DESC LIMIT 50`, subscriberID, profileID)
 	if err != nil {
 		return nil, err
 	}
@@ -133,15 +178,16 @@ func (s *server) trending(ctx context.Context) ([]recItem, error) {
 	return scanItems(rows, true)
 }
 
-// becauseYouWatched returns similar genre items based on the last completed item.
-func (s *server) becauseYouWatched(ctx context.Context, subscriberID string) (string, []recItem, error) {
+// becauseYouWatched returns similar genre items based on the profile's last
+// completed item.
+func (s *server) becauseYouWatched(ctx context.Context, subscriberID, profileID string) (string, []recItem, error) {
 	var triggerTitle string
 	var triggerGenre sql.NullString
 	err := s.db.QueryRowContext(ctx, `
 		SELECT c.title, c.genre FROM watch_progress wp
 		JOIN vod_catalog c ON c.id = wp.content_id AND wp.content_type = 'movie'
-		WHERE wp.subscriber_id = $1 AND wp.completed = true
-		ORDER BY wp.last_watched_at DESC LIMIT 1`, subscriberID).
+		WHERE wp.subscriber_id = $1 AND `+viewerProgress+` AND wp.completed = true
+		ORDER BY wp.last_watched_at DESC LIMIT 1`, subscriberID, profileID).
 		Scan(&triggerTitle, &triggerGenre)
 	if err != nil || !triggerGenre.Valid {
 		return "", nil, nil
@@ -150,11 +196,12 @@ func (s *server) becauseYouWatched(ctx context.Context, subscriberID string) (st
 	rows, err := s.db.QueryContext(ctx, `
 		SELECT id, title, type, genre, poster_url, 0.0 AS score
 		FROM vod_catalog
-		WHERE genre = $1 AND is_active = true
+		WHERE genre = $3 AND is_active = true
 		  AND id NOT IN (
-		      SELECT content_id FROM watch_progress WHERE subscriber_id = $2
+		      SELECT content_id FROM watch_progress
+		      WHERE subscriber_id = $1 AND `+viewerProgress+`
 		  )
-		ORDER BY sort_order ASC LIMIT 10`, triggerGenre.String, subscriberID)
+		ORDER BY sort_order ASC LIMIT 10`, subscriberID, profileID, triggerGenre.String)
 	if err != nil {
 		return triggerTitle, nil, nil
 	}
@@ -198,14 +245,15 @@ func (s *server) handleRecommendations(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	subscriberID := parts[1]
+	profileID := r.URL.Query().Get("profile_id")
 
-	forYou, err := s.personalized(r.Context(), subscriberID)
+	forYou, err := s.personalized(r.Context(), subscriberID, profileID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
 	}
 	trend, _ := s.trending(r.Context())
-	triggerTitle, because, _ := s.becauseYouWatched(r.Context(), subscriberID)
+	triggerTitle, because, _ := s.becauseYouWatched(r.Context(), subscriberID, profileID)
 
 	if forYou == nil { forYou = []recItem{} }
 	if trend == nil { trend = []recItem{} }
@@ -238,14 +286,50 @@ func (s *server) handleTrending(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
 }
 
+// handleSimilar serves "more like this" for one item. Episodes resolve to
+// their series.
+func (s *server) handleSimilar(w http.ResponseWriter, r *http.Request) {
+	ref := itemRef{Type: r.PathValue("type"), ID: r.PathValue("id")}
+	switch ref.Type {
+	case "movie", "series", "music", "channel":
+	case "episode":
+		err := s.db.QueryRowContext(r.Context(), `
+			SELECT vs.catalog_id::text FROM vod_episodes e
+			JOIN vod_series vs ON vs.id = e.series_id
+			WHERE e.id::text = $1`, ref.ID).Scan(&ref.ID)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "not_found", "episode not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		ref.Type = "series"
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "type must be movie, series, episode, music or channel")
+		return
+	}
+	items, err := s.similar(r.Context(), ref, 20)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	if items == nil { items = []recItem{} }
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+}
+
 func (s *server) handleRefresh(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "POST required")
 		return
 	}
-	// In future: trigger async genre affinity score recalculation
-	// For now: no-op (scores are computed per-request from live data)
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "note": "scores computed on demand"})
+	// Content-based scores are computed per request; only the similarity
+	// table is precomputed. The rebuild runs in the background.
+	if s.sim != nil {
+		s.sim.trigger()
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "note": "similarity rebuild queued"})
 }
 
 func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -261,10 +345,13 @@ func main() {
 	}
 	defer db.Close()
 
-	srv := &server{db: db}
+	srv := &server{db: db, sim: newSimilarityJob(db)}
+	go srv.sim.run(context.Background())
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /health", srv.handleHealth)
 	mux.HandleFunc("GET /recommendations/trending", srv.handleTrending)
+	mux.HandleFunc("GET /recommendations/similar/{type}/{id}", srv.handleSimilar)
 	mux.HandleFunc("GET /recommendations/", srv.handleRecommendations)
 	mux.HandleFunc("POST /internal/reco/refresh", srv.handleRefresh)
 
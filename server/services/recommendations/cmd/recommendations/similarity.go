@@ -0,0 +1,436 @@
+// similarity.go — Item-to-item collaborative filtering.
+//
+// A background job rebuilds reco_item_similarity from household viewing:
+// movies and music from watch_progress, episodes rolled up to their series,
+// and live channels from stream_sessions. Two items are similar when the
+// same households watch both; the score is cosine similarity over binary
+// interactions, shrunk toward zero for pairs only a few households share.
+//
+// Neighbour lists serve "more like this" and are blended into personalized
+// recommendations. Everything runs in-process; no external ML service.
+//
+// Env:
+//
+//	RECO_SIMILARITY_INTERVAL — rebuild period (default 6h)
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	simWindowDays = 180 // interactions older than this are ignored
+	simMaxPerUser = 200 // most recent items per household
+	simMinCo      = 2   // households that must share a pair
+	simShrinkage  = 5.0 // damps pairs with little co-occurrence
+	simTopK       = 50  // neighbours kept per item
+)
+
+// interactionsSQL lists (subscriber_id, profile_id, item_type, item_id,
+// last_at) for every item a profile engaged with: movies and music played
+// past 10% (or 10 minutes), series with any episode watched, and channels
+// watched for at least 10 minutes in total. Live viewing isn't tracked per
+// profile, so channel rows have a NULL profile_id and count for the whole
+// household.
+const interactionsSQL = `
+	SELECT wp.subscriber_id::text AS subscriber_id, wp.profile_id::text AS profile_id,
+	       wp.content_type AS item_type, wp.content_id::text AS item_id,
+	       wp.last_watched_at AS last_at
+	FROM watch_progress wp
+	WHERE wp.content_type IN ('movie','music')
+	  AND (wp.completed OR wp.position_seconds >= LEAST(600, wp.duration_seconds / 10))
+	UNION ALL
+	SELECT wp.subscriber_id::text, wp.profile_id::text, 'series', vs.catalog_id::text,
+	       MAX(wp.last_watched_at)
+	FROM watch_progress wp
+	JOIN vod_episodes e ON e.id = wp.content_id
+	JOIN vod_series vs ON vs.id = e.series_id
+	WHERE wp.content_type = 'episode'
+	GROUP BY wp.subscriber_id, wp.profile_id, vs.catalog_id
+	UNION ALL
+	SELECT ss.subscriber_id::text, NULL::text, 'channel', c.id::text, MAX(ss.started_at)
+	FROM stream_sessions ss
+	JOIN channels c ON c.slug = ss.channel_slug
+	WHERE ss.ended_at IS NOT NULL
+	GROUP BY ss.subscriber_id, c.id
+	HAVING SUM(EXTRACT(EPOCH FROM ss.ended_at - ss.started_at)) >= 600`
+
+// itemRef identifies a recommendable item.
+type itemRef struct{ Type, ID string }
+
+// neighbour is one similar item and its score.
+type neighbour struct {
+	Item  itemRef
+	Score float64
+}
+
+// computeSimilarity builds the top-k neighbour list for every item from
+// per-household histories.
+func computeSimilarity(histories map[string][]itemRef, topK int) map[itemRef][]neighbour {
+	index := map[itemRef]int32{}
+	var refs []itemRef
+	counts := []int32{}
+	co := map[uint64]int32{}
+
+	for _, hist := range histories {
+		seen := map[int32]bool{}
+		ids := make([]int32, 0, len(hist))
+		for _, ref := range hist {
+			i, ok := index[ref]
+			if !ok {
+				i = int32(len(refs))
+				index[ref] = i
+				refs = append(refs, ref)
+				counts = append(counts, 0)
+			}
+			if !seen[i] {
+				seen[i] = true
+				ids = append(ids, i)
+				counts[i]++
+			}
+		}
+		sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+		for a := 0; a < len(ids); a++ {
+			for b := a + 1; b < len(ids); b++ {
+				co[uint64(ids[a])<<32|uint64(ids[b])]++
+			}
+		}
+	}
+
+	out := map[itemRef][]neighbour{}
+	for key, n := range co {
+		if n < simMinCo {
+			continue
+		}
+		i, j := int32(key>>32), int32(key&0xFFFFFFFF)
+		cos := float64(n) / math.Sqrt(float64(counts[i])*float64(counts[j]))
+		score := cos * float64(n) / (float64(n) + simShrinkage)
+		out[refs[i]] = append(out[refs[i]], neighbour{Item: refs[j], Score: score})
+		out[refs[j]] = append(out[refs[j]], neighbour{Item: refs[i], Score: score})
+	}
+	for ref, ns := range out {
+		sort.Slice(ns, func(a, b int) bool {
+			if ns[a].Score != ns[b].Score {
+				return ns[a].Score > ns[b].Score
+			}
+			return ns[a].Item.ID < ns[b].Item.ID
+		})
+		if len(ns) > topK {
+			out[ref] = ns[:topK]
+		}
+	}
+	return out
+}
+
+// similarityJob rebuilds reco_item_similarity on a timer or on demand.
+type similarityJob struct {
+	db   *sql.DB
+	kick chan struct{}
+}
+
+func newSimilarityJob(db *sql.DB) *similarityJob {
+	return &similarityJob{db: db, kick: make(chan struct{}, 1)}
+}
+
+// trigger asks for a rebuild without waiting for it.
+func (j *similarityJob) trigger() {
+	select {
+	case j.kick <- struct{}{}:
+	default:
+	}
+}
+
+// run rebuilds at startup and then every RECO_SIMILARITY_INTERVAL.
+func (j *similarityJob) run(ctx context.Context) {
+	interval, err := time.ParseDuration(getEnv("RECO_SIMILARITY_INTERVAL", "6h"))
+	if err != nil || interval <= 0 {
+		interval = 6 * time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		if n, err := j.rebuild(ctx); err != nil {
+			log.Printf("[reco] similarity rebuild failed: %v", err)
+		} else {
+			log.Printf("[reco] similarity rebuilt: %d items in %s", n, time.Since(start).Round(time.Millisecond))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-j.kick:
+		}
+	}
+}
+
+// rebuild recomputes and replaces the similarity table. It returns the
+// number of items that got neighbours.
+func (j *similarityJob) rebuild(ctx context.Context) (int, error) {
+	rows, err := j.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT subscriber_id, item_type, item_id FROM (%s) i
+		WHERE i.last_at > NOW() - make_interval(days => $1)
+		ORDER BY subscriber_id, last_at DESC`, interactionsSQL), simWindowDays)
+	if err != nil {
+		return 0, fmt.Errorf("load interactions: %w", err)
+	}
+	histories := map[string][]itemRef{}
+	for rows.Next() {
+		var sub string
+		var ref itemRef
+		if err := rows.Scan(&sub, &ref.Type, &ref.ID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if len(histories[sub]) < simMaxPerUser {
+			histories[sub] = append(histories[sub], ref)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sims := computeSimilarity(histories, simTopK)
+
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM reco_item_similarity`); err != nil {
+		return 0, err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("reco_item_similarity",
+		"item_type", "item_id", "similar_type", "similar_id", "score"))
+	if err != nil {
+		return 0, err
+	}
+	for ref, ns := range sims {
+		for _, n := range ns {
+			if _, err := stmt.ExecContext(ctx, ref.Type, ref.ID, n.Item.Type, n.Item.ID, n.Score); err != nil {
+				stmt.Close()
+				return 0, err
+			}
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return 0, err
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, err
+	}
+	return len(sims), tx.Commit()
+}
+
+// ---- serving ----------------------------------------------------------------
+
+// describe loads display fields for items. Items that are missing or
+// inactive are left out; music is skipped on deployments without
+// music_albums.
+func (s *server) describe(ctx context.Context, refs []itemRef) (map[itemRef]recItem, error) {
+	var vodIDs, channelIDs, musicIDs []string
+	for _, ref := range refs {
+		switch ref.Type {
+		case "movie", "series":
+			vodIDs = append(vodIDs, ref.ID)
+		case "channel":
+			channelIDs = append(channelIDs, ref.ID)
+		case "music":
+			musicIDs = append(musicIDs, ref.ID)
+		}
+	}
+
+	out := map[itemRef]recItem{}
+	load := func(query string, ids []string) error {
+		if len(ids) == 0 {
+			return nil
+		}
+		rows, err := s.db.QueryContext(ctx, query, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		items, err := scanItems(rows, true)
+		for _, it := range items {
+			out[itemRef{Type: it.Type, ID: it.ID}] = it
+		}
+		return err
+	}
+	if err := load(`
+		SELECT id::text, title, type, genre, poster_url, 0.0
+		FROM vod_catalog WHERE id::text = ANY($1) AND is_active = true`, vodIDs); err != nil {
+		return nil, err
+	}
+	if err := load(`
+		SELECT id::text, name, 'channel', NULL::text, logo_url, 0.0
+		FROM channels WHERE id::text = ANY($1) AND is_active = true`, channelIDs); err != nil {
+		return nil, err
+	}
+	_ = load(`
+		SELECT id::text, artist || ' — ' || album_title, 'music', NULL::text, NULL::text, 0.0
+		FROM music_albums WHERE id::text = ANY($1) AND is_active = true`, musicIDs)
+	return out, nil
+}
+
+// similar returns "more like this" for an item. Movies and series with no
+// neighbours yet fall back to the same genre.
+func (s *server) similar(ctx context.Context, ref itemRef, limit int) ([]recItem, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT similar_type, similar_id, score FROM reco_item_similarity
+		WHERE item_type = $1 AND item_id = $2
+		ORDER BY score DESC LIMIT $3`, ref.Type, ref.ID, limit*2)
+	if err != nil {
+		return nil, err
+	}
+	var ns []neighbour
+	for rows.Next() {
+		var n neighbour
+		if err := rows.Scan(&n.Item.Type, &n.Item.ID, &n.Score); err == nil {
+			ns = append(ns, n)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ns) == 0 {
+		if ref.Type != "movie" && ref.Type != "series" {
+			return nil, nil
+		}
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT vc.id::text, vc.title, vc.type, vc.genre, vc.poster_url, 0.0
+			FROM vod_catalog vc
+			JOIN vod_catalog src ON src.id::text = $1 AND src.genre = vc.genre
+			WHERE vc.is_active = true AND vc.id <> src.id
+			ORDER BY vc.sort_order ASC LIMIT $2`, ref.ID, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		return scanItems(rows, true)
+	}
+
+	refs := make([]itemRef, len(ns))
+	for i, n := range ns {
+		refs[i] = n.Item
+	}
+	info, err := s.describe(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+	var items []recItem
+	for _, n := range ns {
+		it, ok := info[n.Item]
+		if !ok {
+			continue
+		}
+		it.Score = n.Score
+		items = append(items, it)
+		if len(items) == limit {
+			break
+		}
+	}
+	return items, nil
+}
+
+// collaborative scores items similar to what the profile watched recently,
+// excluding anything it already watched. Scores are normalised to 0..1.
+func (s *server) collaborative(ctx context.Context, subscriberID, profileID string) ([]recItem, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		WITH interactions AS (%s),
+		seeds AS (
+			SELECT item_type, item_id FROM interactions
+			WHERE subscriber_id = $1 AND `+viewerInteractions+`
+			ORDER BY last_at DESC LIMIT 50
+		)
+		SELECT rs.similar_type, rs.similar_id, SUM(rs.score) AS score
+		FROM reco_item_similarity rs
+		JOIN seeds sd ON sd.item_type = rs.item_type AND sd.item_id = rs.item_id
+		WHERE NOT EXISTS (
+		    SELECT 1 FROM interactions i
+		    WHERE i.subscriber_id = $1 AND `+viewerInteractions+`
+		      AND i.item_type = rs.similar_type AND i.item_id = rs.similar_id
+		)
+		GROUP BY rs.similar_type, rs.similar_id
+		ORDER BY score DESC LIMIT 50`, interactionsSQL), subscriberID, profileID)
+	if err != nil {
+		return nil, err
+	}
+	var ns []neighbour
+	for rows.Next() {
+		var n neighbour
+		if err := rows.Scan(&n.Item.Type, &n.Item.ID, &n.Score); err == nil {
+			ns = append(ns, n)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(ns) == 0 {
+		return nil, err
+	}
+
+	refs := make([]itemRef, len(ns))
+	for i, n := range ns {
+		refs[i] = n.Item
+	}
+	info, err := s.describe(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+	max := ns[0].Score
+	var items []recItem
+	for _, n := range ns {
+		if it, ok := info[n.Item]; ok {
+			it.Score = n.Score / max
+			items = append(items, it)
+		}
+	}
+	return items, nil
+}
+
+// blendScores merges content-based and collaborative candidates:
+// score = (1-w)·content + w·collaborative, where w is cfBlendWeight when the
+// household has collaborative results and 0 otherwise.
+func blendScores(content, collab []recItem, limit int) []recItem {
+	w := 0.0
+	if len(collab) > 0 {
+		w = cfBlendWeight
+	}
+	merged := map[itemRef]*recItem{}
+	var order []itemRef
+	add := func(items []recItem, weight float64) {
+		for _, it := range items {
+			ref := itemRef{Type: it.Type, ID: it.ID}
+			if m, ok := merged[ref]; ok {
+				m.Score += weight * it.Score
+				continue
+			}
+			cp := it
+			cp.Score = weight * it.Score
+			merged[ref] = &cp
+			order = append(order, ref)
+		}
+	}
+	add(content, 1-w)
+	add(collab, w)
+
+	out := make([]recItem, 0, len(order))
+	for _, ref := range order {
+		out = append(out, *merged[ref])
+	}
+	sort.SliceStable(out, func(a, b int) bool { return out[a].Score > out[b].Score })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
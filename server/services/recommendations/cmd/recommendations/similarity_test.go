@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ---- item-to-item similarity -----------------------------------------------
+
+func TestComputeSimilarityRanksSharedAudience(t *testing.T) {
+	a := itemRef{"series", "a"}
+	b := itemRef{"series", "b"}
+	c := itemRef{"movie", "c"}
+	news := itemRef{"channel", "news"}
+
+	histories := map[string][]itemRef{
+		"h1": {a, b, news},
+		"h2": {a, b},
+		"h3": {a, b, c},
+		"h4": {c, news},
+		"h5": {a, c, a}, // duplicates count once
+	}
+	sims := computeSimilarity(histories, 10)
+
+	ns := sims[a]
+	if len(ns) == 0 || ns[0].Item != b {
+		t.Fatalf("a's best neighbour should be b, got %+v", ns)
+	}
+	for _, n := range ns {
+		if n.Item == news {
+			t.Error("pairs shared by one household must be dropped")
+		}
+		if n.Score <= 0 || n.Score > 1 {
+			t.Errorf("score out of range: %+v", n)
+		}
+	}
+	// Symmetric.
+	var ba float64
+	for _, n := range sims[b] {
+		if n.Item == a {
+			ba = n.Score
+		}
+	}
+	if ba != ns[0].Score {
+		t.Errorf("sim(b,a) = %v, want %v", ba, ns[0].Score)
+	}
+}
+
+func TestComputeSimilarityTopK(t *testing.T) {
+	histories := map[string][]itemRef{}
+	for _, h := range []string{"h1", "h2"} {
+		for _, id := range []string{"a", "b", "c", "d"} {
+			histories[h] = append(histories[h], itemRef{"movie", id})
+		}
+	}
+	if got := len(computeSimilarity(histories, 2)[itemRef{"movie", "a"}]); got != 2 {
+		t.Errorf("neighbours = %d, want 2", got)
+	}
+}
+
+func TestBlendScores(t *testing.T) {
+	content := []recItem{
+		{ID: "m1", Type: "movie", Score: 0.8},
+		{ID: "m2", Type: "movie", Score: 0.4},
+	}
+	collab := []recItem{
+		{ID: "m2", Type: "movie", Score: 1.0},
+		{ID: "news", Type: "channel", Score: 0.5},
+	}
+	got := blendScores(content, collab, 10)
+	if len(got) != 3 {
+		t.Fatalf("items = %d, want 3", len(got))
+	}
+	// m2: 0.5*0.4 + 0.5*1.0 = 0.7; m1: 0.4; news: 0.25.
+	if got[0].ID != "m2" || got[1].ID != "m1" || got[2].ID != "news" {
+		t.Errorf("order = %s, %s, %s", got[0].ID, got[1].ID, got[2].ID)
+	}
+
+	// Without collaborative results content scores pass through unchanged.
+	got = blendScores(content, nil, 1)
+	if len(got) != 1 || got[0].Score != 0.8 {
+		t.Errorf("content-only blend = %+v", got)
+	}
+}
+
+func TestSimilarRejectsUnknownType(t *testing.T) {
+	srv := &server{db: nil}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /recommendations/similar/{type}/{id}", srv.handleSimilar)
+
+	req := httptest.NewRequest(http.MethodGet, "/recommendations/similar/podcast/x", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown type, got %d", rec.Code)
+	}
+}
@@ -2,6 +2,10 @@
 // Creates stream_sessions rows on first playlist request and updates bytes_transferred
 // on each segment request. Concurrent stream enforcement via an in-memory tracker
 // (Redis-backed in production via the ConcurrencyGuard).
+//
+// With a DemandFunc set, viewer activity on a channel is reported to the ingest
+// service so on-demand channels start on the first playlist request and stay
+// up while anyone watches.
 package sessions
 
 import (
@@ -35,8 +39,15 @@ type Manager struct {
 	mu       sync.Mutex
 	sessions map[string]*Session // key: subscriberID:channelSlug:deviceID
 	streams  map[uuid.UUID]map[string]time.Time // subscriberID -> deviceID -> lastActive
+
+	demandFn      DemandFunc
+	demandRefresh time.Duration
+	demanded      map[string]time.Time // channelSlug -> last demand report
 }
 
+// DemandFunc reports viewer demand for a channel to the ingest service.
+type DemandFunc func(ctx context.Context, channelSlug string)
+
 // NewManager creates a session manager.
 // maxStreams is the maximum concurrent streams per subscriber (plan-based; default 2).
 func NewManager(db *sql.DB, maxStreams int) *Manager {
@@ -49,16 +60,60 @@ func NewManager(db *sql.DB, maxStreams int) *Manager {
 		idleTimeout: 60 * time.Second,
 		sessions:    make(map[string]*Session),
 		streams:     make(map[uuid.UUID]map[string]time.Time),
+		demanded:    make(map[string]time.Time),
 	}
 	// Background goroutine to expire idle sessions
 	go m.expiryLoop()
 	return m
 }
 
+// SetDemandFunc sets the function told about viewer demand. It is called
+// synchronously for a channel's first request (so the pipeline starts before
+// the relay waits for its playlist) and in the background at most once per
+// refresh after that.
+func (m *Manager) SetDemandFunc(fn DemandFunc, refresh time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.demandFn = fn
+	m.demandRefresh = refresh
+}
+
 // OnPlaylistRequest handles a subscriber requesting a channel's m3u8 playlist.
 // Creates a new session if this is the first request for this subscriber+channel+device.
 // Returns 429-equivalent error if concurrent stream limit is exceeded.
 func (m *Manager) OnPlaylistRequest(ctx context.Context, subscriberID uuid.UUID, channelSlug, deviceID string) (*Session, error) {
+	sess, err := m.admit(ctx, subscriberID, channelSlug, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	m.reportDemand(ctx, channelSlug, true)
+	return sess, nil
+}
+
+// reportDemand calls the DemandFunc if the channel is due a report; with
+// wait set, a channel's first report is made before returning.
+func (m *Manager) reportDemand(ctx context.Context, channelSlug string, wait bool) {
+	m.mu.Lock()
+	fn := m.demandFn
+	last, seen := m.demanded[channelSlug]
+	now := time.Now()
+	due := fn != nil && (!seen || now.Sub(last) >= m.demandRefresh)
+	if due {
+		m.demanded[channelSlug] = now
+	}
+	m.mu.Unlock()
+
+	switch {
+	case !due:
+	case !seen && wait:
+		fn(ctx, channelSlug)
+	default:
+		go fn(context.Background(), channelSlug)
+	}
+}
+
+// admit enforces the concurrent stream limit and returns the session.
+func (m *Manager) admit(ctx context.Context, subscriberID uuid.UUID, channelSlug, deviceID string) (*Session, error) {
 	key := fmt.Sprintf("%s:%s:%s", subscriberID, channelSlug, deviceID)
 
 	m.mu.Lock()
@@ -106,6 +161,10 @@ func (m *Manager) OnSegmentRequest(subscriberID uuid.UUID, channelSlug, deviceID
 		return
 	}
 	sess.lastActivity = time.Now()
+	if m.demandFn != nil {
+		// Variant playlists and segments keep an on-demand channel alive too.
+		go m.reportDemand(context.Background(), channelSlug, false)
+	}
 
 	// Update streams activity tracker
 	if m.streams[subscriberID] == nil {
@@ -184,6 +243,14 @@ func (m *Manager) expireIdle() {
 	for subID := range m.streams {
 		m.cleanStreamsLocked(subID)
 	}
+
+	// Forget demand reports for channels nobody has watched for a while, so
+	// the next viewer's first request is reported synchronously again.
+	for slug, last := range m.demanded {
+		if now.Sub(last) > m.idleTimeout+m.demandRefresh {
+			delete(m.demanded, slug)
+		}
+	}
 }
 
 // activeStreamsLocked returns the number of active streams for a subscriber,
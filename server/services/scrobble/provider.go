@@ -0,0 +1,239 @@
+// Package scrobble links Roost profiles to Trakt and Simkl.
+//
+// Each profile links an account with the OAuth device-code flow: Roost shows
+// a short user code, the viewer approves it on the provider's site, and the
+// sync loop polls for the token. Once linked, playback events from the VOD
+// and DVR services are scrobbled (start / pause / stop) and watched history
+// and watchlists are synced both ways. Titles are matched through the TMDB
+// ids vod_catalog.metadata carries (filled by services/metadata).
+//
+// Env:
+//
+//	TRAKT_CLIENT_ID, TRAKT_CLIENT_SECRET — Trakt API app (provider disabled when unset)
+//	TRAKT_API_URL                        — default https://api.trakt.tv
+//	SIMKL_CLIENT_ID, SIMKL_CLIENT_SECRET — Simkl API app (provider disabled when unset)
+//	SIMKL_API_URL                        — default https://api.simkl.com
+//	SCROBBLE_TOKEN_KEY                   — 64-char hex AES-256 key for stored tokens
+//
+// The API URLs can point at a local stand-in for testing.
+package scrobble
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// Action is a scrobble transition.
+type Action string
+
+const (
+	ActionStart Action = "start"
+	ActionPause Action = "pause"
+	ActionStop  Action = "stop"
+)
+
+// Item is a title as the providers know it: a movie or show by TMDB id, or
+// one episode of a show.
+type Item struct {
+	Kind      string // "movie", "show" or "episode"
+	TMDB      int    // movie id, or the show id for shows and episodes
+	Season    int    // episodes only
+	Number    int    // episodes only
+	WatchedAt time.Time
+}
+
+// DeviceCode is the start of a device-code link.
+type DeviceCode struct {
+	DeviceCode      string
+	UserCode        string
+	VerificationURL string
+	ExpiresIn       time.Duration
+	Interval        time.Duration
+}
+
+// Token is an OAuth access token. ExpiresAt is zero for tokens that don't
+// expire (Simkl).
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// Device-code polling outcomes other than success.
+var (
+	ErrAuthPending  = errors.New("authorization pending")
+	ErrSlowDown     = errors.New("polling too fast")
+	ErrCodeExpired  = errors.New("device code expired")
+	ErrAccessDenied = errors.New("user denied access")
+	ErrUnauthorized = errors.New("token rejected")
+)
+
+// Provider is a scrobbling service.
+type Provider interface {
+	// Name returns "trakt" or "simkl".
+	Name() string
+
+	// RequestDeviceCode starts a device-code link.
+	RequestDeviceCode(ctx context.Context) (*DeviceCode, error)
+	// PollDeviceToken exchanges an approved device code for a token, or
+	// returns one of the ErrAuthPending family.
+	PollDeviceToken(ctx context.Context, dc *DeviceCode) (*Token, error)
+	// RefreshToken renews an expiring token.
+	RefreshToken(ctx context.Context, refresh string) (*Token, error)
+
+	// Scrobble reports playback at progress percent (0–100).
+	Scrobble(ctx context.Context, token string, action Action, it Item, progress float64) error
+
+	// History lists movies and episodes watched since since (zero = all).
+	History(ctx context.Context, token string, since time.Time) ([]Item, error)
+	// AddHistory marks items watched at their WatchedAt.
+	AddHistory(ctx context.Context, token string, items []Item) error
+	// Watchlist lists watchlisted movies and shows.
+	Watchlist(ctx context.Context, token string) ([]Item, error)
+	// AddWatchlist adds movies and shows to the watchlist.
+	AddWatchlist(ctx context.Context, token string, items []Item) error
+}
+
+// Providers returns the providers configured in the environment, by name.
+func Providers() map[string]Provider {
+	client := &http.Client{Timeout: 15 * time.Second}
+	ps := map[string]Provider{}
+	if id := os.Getenv("TRAKT_CLIENT_ID"); id != "" {
+		ps["trakt"] = &Trakt{
+			BaseURL:      getEnv("TRAKT_API_URL", "https://api.trakt.tv"),
+			ClientID:     id,
+			ClientSecret: os.Getenv("TRAKT_CLIENT_SECRET"),
+			HTTP:         client,
+		}
+	}
+	if id := os.Getenv("SIMKL_CLIENT_ID"); id != "" {
+		ps["simkl"] = &Simkl{
+			BaseURL:      getEnv("SIMKL_API_URL", "https://api.simkl.com"),
+			ClientID:     id,
+			ClientSecret: os.Getenv("SIMKL_CLIENT_SECRET"),
+			HTTP:         client,
+		}
+	}
+	return ps
+}
+
+// ProviderNames returns the names of ps, sorted.
+func ProviderNames(ps map[string]Provider) []string {
+	names := make([]string, 0, len(ps))
+	for n := range ps {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// ---- request bodies shared by Trakt and Simkl -------------------------------
+
+type ids struct {
+	TMDB int `json:"tmdb"`
+}
+
+type mediaRef struct {
+	IDs       ids    `json:"ids"`
+	WatchedAt string `json:"watched_at,omitempty"`
+	To        string `json:"to,omitempty"` // Simkl list name
+}
+
+type episodeRef struct {
+	Season    int    `json:"season,omitempty"`
+	Number    int    `json:"number"`
+	WatchedAt string `json:"watched_at,omitempty"`
+}
+
+type seasonRef struct {
+	Number   int          `json:"number"`
+	Episodes []episodeRef `json:"episodes"`
+}
+
+type showRef struct {
+	IDs     ids         `json:"ids"`
+	Seasons []seasonRef `json:"seasons,omitempty"`
+	To      string      `json:"to,omitempty"`
+}
+
+// syncBody is the {"movies":[...],"shows":[...]} body both providers accept
+// for history and list writes.
+type syncBody struct {
+	Movies []mediaRef `json:"movies,omitempty"`
+	Shows  []showRef  `json:"shows,omitempty"`
+}
+
+// buildSyncBody groups items into a syncBody; episodes nest under their show
+// and season. list is set as each entry's "to" (Simkl), or "" to omit it.
+func buildSyncBody(items []Item, list string) syncBody {
+	var b syncBody
+	shows := map[int]int{} // tmdb → index in b.Shows
+	show := func(tmdb int) *showRef {
+		i, ok := shows[tmdb]
+		if !ok {
+			i = len(b.Shows)
+			shows[tmdb] = i
+			b.Shows = append(b.Shows, showRef{IDs: ids{tmdb}, To: list})
+		}
+		return &b.Shows[i]
+	}
+	for _, it := range items {
+		switch it.Kind {
+		case "movie":
+			b.Movies = append(b.Movies, mediaRef{IDs: ids{it.TMDB}, WatchedAt: formatTime(it.WatchedAt), To: list})
+		case "show":
+			show(it.TMDB)
+		case "episode":
+			s := show(it.TMDB)
+			var season *seasonRef
+			for i := range s.Seasons {
+				if s.Seasons[i].Number == it.Season {
+					season = &s.Seasons[i]
+				}
+			}
+			if season == nil {
+				s.Seasons = append(s.Seasons, seasonRef{Number: it.Season})
+				season = &s.Seasons[len(s.Seasons)-1]
+			}
+			season.Episodes = append(season.Episodes, episodeRef{Number: it.Number, WatchedAt: formatTime(it.WatchedAt)})
+		}
+	}
+	return b
+}
+
+// scrobbleBody is the body of a scrobble call.
+type scrobbleBody struct {
+	Progress float64     `json:"progress"`
+	Movie    *mediaRef   `json:"movie,omitempty"`
+	Show     *showRef    `json:"show,omitempty"`
+	Episode  *episodeRef `json:"episode,omitempty"`
+}
+
+func buildScrobbleBody(it Item, progress float64) scrobbleBody {
+	b := scrobbleBody{Progress: progress}
+	if it.Kind == "episode" {
+		b.Show = &showRef{IDs: ids{it.TMDB}}
+		b.Episode = &episodeRef{Season: it.Season, Number: it.Number}
+	} else {
+		b.Movie = &mediaRef{IDs: ids{it.TMDB}}
+	}
+	return b
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,268 @@
+package scrobble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildSyncBodyGroupsEpisodes(t *testing.T) {
+	at := time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)
+	b := buildSyncBody([]Item{
+		{Kind: "movie", TMDB: 603, WatchedAt: at},
+		{Kind: "episode", TMDB: 1396, Season: 1, Number: 1, WatchedAt: at},
+		{Kind: "episode", TMDB: 1396, Season: 1, Number: 2},
+		{Kind: "episode", TMDB: 1396, Season: 2, Number: 1},
+		{Kind: "show", TMDB: 1399},
+	}, "")
+
+	if len(b.Movies) != 1 || b.Movies[0].IDs.TMDB != 603 || b.Movies[0].WatchedAt != "2026-03-01T20:00:00Z" {
+		t.Fatalf("movies = %+v", b.Movies)
+	}
+	if len(b.Shows) != 2 {
+		t.Fatalf("want 2 shows, got %+v", b.Shows)
+	}
+	bb := b.Shows[0]
+	if bb.IDs.TMDB != 1396 || len(bb.Seasons) != 2 {
+		t.Fatalf("show 1396 = %+v", bb)
+	}
+	if got := len(bb.Seasons[0].Episodes); bb.Seasons[0].Number != 1 || got != 2 {
+		t.Errorf("season 1 has %d episodes, want 2", got)
+	}
+	if b.Shows[1].IDs.TMDB != 1399 || len(b.Shows[1].Seasons) != 0 {
+		t.Errorf("show 1399 = %+v", b.Shows[1])
+	}
+
+	wl := buildSyncBody([]Item{{Kind: "movie", TMDB: 603}, {Kind: "show", TMDB: 1399}}, "plantowatch")
+	if wl.Movies[0].To != "plantowatch" || wl.Shows[0].To != "plantowatch" {
+		t.Errorf("list not set: %+v", wl)
+	}
+}
+
+// standIn serves canned responses by "METHOD path" and records request bodies.
+type standIn struct {
+	t      *testing.T
+	routes map[string]func(w http.ResponseWriter, r *http.Request)
+	bodies map[string]map[string]interface{}
+}
+
+func newStandIn(t *testing.T) (*standIn, *httptest.Server) {
+	s := &standIn{t: t, routes: map[string]func(http.ResponseWriter, *http.Request){}, bodies: map[string]map[string]interface{}{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+		if r.Body != nil && r.Method == http.MethodPost {
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			s.bodies[key] = body
+		}
+		h, ok := s.routes[key]
+		if !ok {
+			t.Errorf("unexpected request %s", key)
+			http.NotFound(w, r)
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func reply(status int, body string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+func TestTraktDeviceFlow(t *testing.T) {
+	api, srv := newStandIn(t)
+	tr := &Trakt{BaseURL: srv.URL, ClientID: "cid", ClientSecret: "secret", HTTP: srv.Client()}
+	ctx := context.Background()
+
+	api.routes["POST /oauth/device/code"] = func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("trakt-api-key") != "cid" || r.Header.Get("trakt-api-version") != "2" {
+			t.Errorf("missing Trakt headers: %v", r.Header)
+		}
+		reply(200, `{"device_code":"dev1","user_code":"ABCD1234","verification_url":"https://trakt.tv/activate","expires_in":600,"interval":5}`)(w, r)
+	}
+	dc, err := tr.RequestDeviceCode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.UserCode != "ABCD1234" || dc.Interval != 5*time.Second || dc.ExpiresIn != 10*time.Minute {
+		t.Fatalf("device code = %+v", dc)
+	}
+
+	for status, want := range map[int]error{
+		400: ErrAuthPending, 429: ErrSlowDown, 410: ErrCodeExpired, 418: ErrAccessDenied,
+	} {
+		api.routes["POST /oauth/device/token"] = reply(status, "")
+		if _, err := tr.PollDeviceToken(ctx, dc); !errors.Is(err, want) {
+			t.Errorf("HTTP %d: got %v, want %v", status, err, want)
+		}
+	}
+
+	created := time.Now().Unix()
+	api.routes["POST /oauth/device/token"] = reply(200,
+		`{"access_token":"at","refresh_token":"rt","expires_in":7776000,"created_at":`+jsonInt(created)+`}`)
+	tok, err := tr.PollDeviceToken(ctx, dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "at" || tok.RefreshToken != "rt" {
+		t.Errorf("token = %+v", tok)
+	}
+	if want := time.Unix(created, 0).Add(90 * 24 * time.Hour); !tok.ExpiresAt.Equal(want) {
+		t.Errorf("expires %v, want %v", tok.ExpiresAt, want)
+	}
+	if got := api.bodies["POST /oauth/device/token"]["code"]; got != "dev1" {
+		t.Errorf("polled with code %v", got)
+	}
+}
+
+func TestTraktScrobbleAndSync(t *testing.T) {
+	api, srv := newStandIn(t)
+	tr := &Trakt{BaseURL: srv.URL, ClientID: "cid", HTTP: srv.Client()}
+	ctx := context.Background()
+
+	api.routes["POST /scrobble/start"] = func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("authorization = %q", r.Header.Get("Authorization"))
+		}
+		reply(201, `{}`)(w, r)
+	}
+	ep := Item{Kind: "episode", TMDB: 1396, Season: 2, Number: 3}
+	if err := tr.Scrobble(ctx, "tok", ActionStart, ep, 12.5); err != nil {
+		t.Fatal(err)
+	}
+	body := api.bodies["POST /scrobble/start"]
+	if body["progress"] != 12.5 {
+		t.Errorf("progress = %v", body["progress"])
+	}
+	if got := body["episode"]; !reflect.DeepEqual(got, map[string]interface{}{"season": 2.0, "number": 3.0}) {
+		t.Errorf("episode = %v", got)
+	}
+
+	api.routes["POST /scrobble/stop"] = reply(409, `{"watched_at":"2026-03-01T20:00:00.000Z"}`)
+	if err := tr.Scrobble(ctx, "tok", ActionStop, ep, 95); err != nil {
+		t.Errorf("409 on stop should be ignored, got %v", err)
+	}
+	api.routes["POST /scrobble/pause"] = reply(401, ``)
+	if err := tr.Scrobble(ctx, "tok", ActionPause, ep, 50); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("401: got %v", err)
+	}
+
+	api.routes["GET /sync/history/movies"] = reply(200,
+		`[{"watched_at":"2026-03-01T20:00:00.000Z","movie":{"ids":{"trakt":1,"tmdb":603}}},{"movie":{"ids":{"trakt":2}}}]`)
+	api.routes["GET /sync/history/episodes"] = reply(200,
+		`[{"watched_at":"2026-03-02T20:00:00.000Z","show":{"ids":{"tmdb":1396}},"episode":{"season":1,"number":4}}]`)
+	hist, err := tr.History(ctx, "tok", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Item{
+		{Kind: "movie", TMDB: 603, WatchedAt: time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)},
+		{Kind: "episode", TMDB: 1396, Season: 1, Number: 4, WatchedAt: time.Date(2026, 3, 2, 20, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(hist, want) {
+		t.Errorf("history = %+v, want %+v", hist, want)
+	}
+
+	api.routes["GET /sync/watchlist/movies"] = reply(200, `[{"movie":{"ids":{"tmdb":680}}}]`)
+	api.routes["GET /sync/watchlist/shows"] = reply(200, `[{"show":{"ids":{"tmdb":1399}}}]`)
+	wl, err := tr.Watchlist(ctx, "tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(wl, []Item{{Kind: "movie", TMDB: 680}, {Kind: "show", TMDB: 1399}}) {
+		t.Errorf("watchlist = %+v", wl)
+	}
+
+	api.routes["POST /sync/history"] = reply(201, `{"added":{"movies":1}}`)
+	if err := tr.AddHistory(ctx, "tok", want[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if movies, _ := api.bodies["POST /sync/history"]["movies"].([]interface{}); len(movies) != 1 {
+		t.Errorf("history body = %v", api.bodies["POST /sync/history"])
+	}
+}
+
+func TestSimklPinFlowAndSync(t *testing.T) {
+	api, srv := newStandIn(t)
+	sk := &Simkl{BaseURL: srv.URL, ClientID: "cid", HTTP: srv.Client()}
+	ctx := context.Background()
+
+	api.routes["GET /oauth/pin"] = reply(200,
+		`{"result":"OK","device_code":"DEVICE_CODE","user_code":"A1B2C","verification_url":"https://simkl.com/pin/","expires_in":900,"interval":5}`)
+	dc, err := sk.RequestDeviceCode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.UserCode != "A1B2C" {
+		t.Fatalf("device code = %+v", dc)
+	}
+
+	api.routes["GET /oauth/pin/A1B2C"] = reply(200, `{"result":"KO","message":"Authorization pending"}`)
+	if _, err := sk.PollDeviceToken(ctx, dc); !errors.Is(err, ErrAuthPending) {
+		t.Errorf("pending: got %v", err)
+	}
+	api.routes["GET /oauth/pin/A1B2C"] = reply(200, `{"result":"KO","message":"Slow down"}`)
+	if _, err := sk.PollDeviceToken(ctx, dc); !errors.Is(err, ErrSlowDown) {
+		t.Errorf("slow down: got %v", err)
+	}
+	api.routes["GET /oauth/pin/A1B2C"] = reply(200, `{"result":"OK","access_token":"simkl-token"}`)
+	tok, err := sk.PollDeviceToken(ctx, dc)
+	if err != nil || tok.AccessToken != "simkl-token" || !tok.ExpiresAt.IsZero() {
+		t.Fatalf("token = %+v, %v", tok, err)
+	}
+
+	api.routes["GET /sync/all-items/"] = func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("simkl-api-key") != "cid" {
+			t.Errorf("simkl-api-key = %q", r.Header.Get("simkl-api-key"))
+		}
+		reply(200, `{
+			"movies":[
+				{"status":"completed","last_watched_at":"2026-03-01T20:00:00Z","movie":{"ids":{"tmdb":"603"}}},
+				{"status":"plantowatch","movie":{"ids":{"tmdb":680}}}
+			],
+			"shows":[
+				{"status":"watching","show":{"ids":{"tmdb":"1396"}},
+				 "seasons":[{"number":1,"episodes":[{"number":1,"watched_at":"2026-03-02T20:00:00Z"},{"number":2}]}]},
+				{"status":"plantowatch","show":{"ids":{"tmdb":"1399"}}}
+			]}`)(w, r)
+	}
+	hist, err := sk.History(ctx, "simkl-token", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hist) != 2 || hist[0].TMDB != 603 || hist[1].Kind != "episode" || hist[1].Number != 1 {
+		t.Errorf("history = %+v", hist)
+	}
+	wl, err := sk.Watchlist(ctx, "simkl-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(wl, []Item{{Kind: "movie", TMDB: 680}, {Kind: "show", TMDB: 1399}}) {
+		t.Errorf("watchlist = %+v", wl)
+	}
+
+	api.routes["POST /sync/add-to-list"] = reply(201, ``)
+	if err := sk.AddWatchlist(ctx, "simkl-token", wl); err != nil {
+		t.Fatal(err)
+	}
+	movies, _ := api.bodies["POST /sync/add-to-list"]["movies"].([]interface{})
+	if len(movies) != 1 || movies[0].(map[string]interface{})["to"] != "plantowatch" {
+		t.Errorf("add-to-list body = %v", api.bodies["POST /sync/add-to-list"])
+	}
+}
+
+func jsonInt(n int64) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
@@ -0,0 +1,106 @@
+// resolve.go — Maps Roost content to provider items and back via TMDB ids.
+package scrobble
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+)
+
+// errUnmatched means the content has no TMDB id to scrobble with.
+var errUnmatched = errors.New("no TMDB match")
+
+// catalogTMDB extracts vod_catalog c's TMDB id, NULL when absent or not numeric.
+const catalogTMDB = `CASE WHEN c.metadata->>'tmdb_id' ~ '^[0-9]+$' THEN (c.metadata->>'tmdb_id')::int END`
+
+// itemFor resolves a playback event's content to a provider item.
+// A recording whose guide programme has season and episode numbers is that
+// episode of the catalog series with the same title; other recordings match
+// a catalog movie with the same title.
+func itemFor(ctx context.Context, db *sql.DB, ev Event) (Item, error) {
+	var tmdb sql.NullInt64
+	var err error
+	it := Item{Kind: "movie"}
+	switch ev.ContentType {
+	case "movie":
+		err = db.QueryRowContext(ctx, `
+			SELECT `+catalogTMDB+` FROM vod_catalog c
+			WHERE c.id::text = $1 AND c.type = 'movie'`, ev.ContentID).Scan(&tmdb)
+	case "episode":
+		it.Kind = "episode"
+		err = db.QueryRowContext(ctx, `
+			SELECT `+catalogTMDB+`, vs.season_number, e.episode_number
+			FROM vod_episodes e
+			JOIN vod_series vs ON vs.id = e.series_id
+			JOIN vod_catalog c ON c.id = vs.catalog_id
+			WHERE e.id::text = $1`, ev.ContentID).Scan(&tmdb, &it.Season, &it.Number)
+	case "recording":
+		var season, number sql.NullInt64
+		err = db.QueryRowContext(ctx, `
+			SELECT `+catalogTMDB+`, p.season_number, p.episode_number
+			FROM dvr_recordings r
+			LEFT JOIN programs p ON p.id = r.program_id
+			JOIN vod_catalog c ON LOWER(c.title) = LOWER(r.title)
+			 AND c.type = CASE WHEN p.season_number IS NOT NULL AND p.episode_number IS NOT NULL
+			                   THEN 'series' ELSE 'movie' END
+			WHERE r.id::text = $1 AND r.subscriber_id::text = $2
+			  AND c.metadata->>'tmdb_id' ~ '^[0-9]+$'
+			LIMIT 1`, ev.ContentID, ev.SubscriberID).Scan(&tmdb, &season, &number)
+		if season.Valid && number.Valid {
+			it.Kind, it.Season, it.Number = "episode", int(season.Int64), int(number.Int64)
+		}
+	default:
+		return it, errUnmatched
+	}
+	if err == sql.ErrNoRows || (err == nil && !tmdb.Valid) {
+		return it, errUnmatched
+	}
+	if err != nil {
+		return it, err
+	}
+	it.TMDB = int(tmdb.Int64)
+	return it, nil
+}
+
+// catalogEntry is the Roost title a provider item maps to.
+type catalogEntry struct {
+	ContentType string // movie | episode | series
+	ContentID   string
+	Duration    int
+}
+
+// catalogFor resolves a provider item to Roost content; ok is false when
+// the title isn't in the catalog.
+func catalogFor(ctx context.Context, db *sql.DB, it Item) (e catalogEntry, ok bool, err error) {
+	tmdb := strconv.Itoa(it.TMDB)
+	switch it.Kind {
+	case "movie":
+		e.ContentType = "movie"
+		err = db.QueryRowContext(ctx, `
+			SELECT c.id, COALESCE(c.duration_seconds, 0) FROM vod_catalog c
+			WHERE c.type = 'movie' AND c.is_active = TRUE AND c.metadata->>'tmdb_id' = $1
+			LIMIT 1`, tmdb).Scan(&e.ContentID, &e.Duration)
+	case "episode":
+		e.ContentType = "episode"
+		err = db.QueryRowContext(ctx, `
+			SELECT e.id, COALESCE(e.duration_seconds, 0)
+			FROM vod_catalog c
+			JOIN vod_series vs ON vs.catalog_id = c.id AND vs.season_number = $2
+			JOIN vod_episodes e ON e.series_id = vs.id AND e.episode_number = $3
+			WHERE c.type = 'series' AND c.is_active = TRUE AND c.metadata->>'tmdb_id' = $1
+			LIMIT 1`, tmdb, it.Season, it.Number).Scan(&e.ContentID, &e.Duration)
+	case "show":
+		e.ContentType = "series"
+		err = db.QueryRowContext(ctx, `
+			SELECT c.id FROM vod_catalog c
+			WHERE c.type = 'series' AND c.is_active = TRUE AND c.metadata->>'tmdb_id' = $1
+			LIMIT 1`, tmdb).Scan(&e.ContentID)
+	default:
+		return e, false, nil
+	}
+	if err == sql.ErrNoRows {
+		return e, false, nil
+	}
+	return e, err == nil, err
+}
@@ -0,0 +1,194 @@
+// scrobbler.go — Turns playback progress reports into scrobble calls.
+package scrobble
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// WatchedPercent is the progress at which playback counts as watched
+	// and the scrobble is stopped, matching Trakt's own threshold.
+	WatchedPercent = 90.0
+
+	// restartAfter re-sends start during long playback so the provider's
+	// "watching now" doesn't expire.
+	restartAfter = 10 * time.Minute
+
+	// idleAfter is how long a session may go without a report before it is
+	// stopped at its last position (player closed without a final report).
+	idleAfter = 5 * time.Minute
+)
+
+// Event is a playback progress report from the VOD or DVR service.
+type Event struct {
+	SubscriberID    string `json:"subscriber_id"`
+	ProfileID       string `json:"profile_id"`
+	ContentType     string `json:"content_type"` // movie | episode | recording
+	ContentID       string `json:"content_id"`
+	PositionSeconds int    `json:"position_seconds"`
+	DurationSeconds int    `json:"duration_seconds"`
+	State           string `json:"state"` // playing | paused | stopped
+}
+
+func (ev Event) key() string {
+	return ev.ProfileID + "|" + ev.ContentType + "|" + ev.ContentID
+}
+
+func (ev Event) progress() float64 {
+	if ev.DurationSeconds <= 0 {
+		return 0
+	}
+	pct := float64(ev.PositionSeconds) * 100 / float64(ev.DurationSeconds)
+	if pct > 100 {
+		pct = 100
+	}
+	return pct
+}
+
+// session is the scrobble state of one profile playing one title.
+type session struct {
+	last     Action // last action sent, "" before the first
+	lastSent time.Time
+	seen     time.Time // last report
+	progress float64
+	done     bool // stopped at >= WatchedPercent; later reports are ignored
+	ev       Event
+}
+
+// step decides which action, if any, a report calls for and updates s.
+func (s *session) step(ev Event, now time.Time) (Action, bool) {
+	s.seen = now
+	s.ev = ev
+	s.progress = ev.progress()
+	if s.done {
+		return "", false
+	}
+	var a Action
+	switch {
+	case ev.State == "stopped" || s.progress >= WatchedPercent:
+		a = ActionStop
+		s.done = s.progress >= WatchedPercent
+	case ev.State == "paused":
+		if s.last != ActionStart {
+			return "", false
+		}
+		a = ActionPause
+	default:
+		if s.last == ActionStart && now.Sub(s.lastSent) < restartAfter {
+			return "", false
+		}
+		a = ActionStart
+	}
+	s.last, s.lastSent = a, now
+	return a, true
+}
+
+// Scrobbler sends each profile's playback to its linked providers.
+type Scrobbler struct {
+	db        *sql.DB
+	providers map[string]Provider
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// NewScrobbler creates a Scrobbler.
+func NewScrobbler(db *sql.DB, providers map[string]Provider) *Scrobbler {
+	return &Scrobbler{db: db, providers: providers, sessions: map[string]*session{}}
+}
+
+// Handle applies one progress report and scrobbles the resulting action.
+func (s *Scrobbler) Handle(ctx context.Context, ev Event) error {
+	if ev.ProfileID == "" || ev.ContentID == "" {
+		return nil
+	}
+	now := time.Now()
+	s.mu.Lock()
+	sess, ok := s.sessions[ev.key()]
+	if !ok {
+		sess = &session{}
+		s.sessions[ev.key()] = sess
+	}
+	action, send := sess.step(ev, now)
+	progress := sess.progress // later reports for the session update it
+	if action == ActionStop && !sess.done {
+		// a finished session is kept until idle so reports during the
+		// credits don't start a new one
+		delete(s.sessions, ev.key())
+	}
+	s.mu.Unlock()
+	if !send {
+		return nil
+	}
+	return s.send(ctx, ev, action, progress)
+}
+
+// Run stops idle sessions until ctx is cancelled.
+func (s *Scrobbler) Run(ctx context.Context) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			for _, sess := range s.sweep(now) {
+				if err := s.send(ctx, sess.ev, ActionStop, sess.progress); err != nil {
+					log.Printf("[scrobble] idle stop %s: %v", sess.ev.key(), err)
+				}
+			}
+		}
+	}
+}
+
+// sweep removes sessions idle for idleAfter and returns those still needing
+// a stop.
+func (s *Scrobbler) sweep(now time.Time) []*session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var stale []*session
+	for k, sess := range s.sessions {
+		if now.Sub(sess.seen) < idleAfter {
+			continue
+		}
+		delete(s.sessions, k)
+		if !sess.done && sess.last != ActionStop && sess.last != "" {
+			stale = append(stale, sess)
+		}
+	}
+	return stale
+}
+
+// send scrobbles action to every provider the profile is linked to.
+func (s *Scrobbler) send(ctx context.Context, ev Event, action Action, progress float64) error {
+	links, err := LinkedFor(ctx, s.db, ev.ProfileID)
+	if err != nil || len(links) == 0 {
+		return err
+	}
+	it, err := itemFor(ctx, s.db, ev)
+	if errors.Is(err, errUnmatched) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		p, ok := s.providers[l.Provider]
+		if !ok {
+			continue
+		}
+		err := p.Scrobble(ctx, l.Token.AccessToken, action, it, progress)
+		switch {
+		case errors.Is(err, ErrUnauthorized):
+			_ = setStatus(ctx, s.db, l.ID, "error", "token rejected; relink required")
+		case err != nil:
+			log.Printf("[scrobble] %s %s %s: %v", l.Provider, action, ev.key(), err)
+		}
+	}
+	return nil
+}
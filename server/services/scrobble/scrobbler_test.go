@@ -0,0 +1,99 @@
+package scrobble
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionStep(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)
+	ev := func(pos int, state string) Event {
+		return Event{ProfileID: "p", ContentType: "movie", ContentID: "m", PositionSeconds: pos, DurationSeconds: 6000, State: state}
+	}
+	steps := []struct {
+		at    time.Duration
+		ev    Event
+		want  Action
+		sends bool
+	}{
+		{0, ev(0, "playing"), ActionStart, true},
+		{10 * time.Second, ev(10, "playing"), "", false}, // still playing
+		{time.Minute, ev(60, "paused"), ActionPause, true},
+		{2 * time.Minute, ev(60, "paused"), "", false}, // already paused
+		{3 * time.Minute, ev(70, "playing"), ActionStart, true},
+		{14 * time.Minute, ev(700, "playing"), ActionStart, true}, // re-sent after restartAfter
+		{20 * time.Minute, ev(5500, "playing"), ActionStop, true}, // ≥ 90%
+		{21 * time.Minute, ev(5600, "playing"), "", false},        // credits after completion
+		{22 * time.Minute, ev(5900, "stopped"), "", false},
+	}
+	var s session
+	for i, st := range steps {
+		got, sends := s.step(st.ev, t0.Add(st.at))
+		if got != st.want || sends != st.sends {
+			t.Errorf("step %d: got (%q, %v), want (%q, %v)", i, got, sends, st.want, st.sends)
+		}
+	}
+	if !s.done {
+		t.Error("session not marked done after reaching WatchedPercent")
+	}
+}
+
+func TestSessionStopBeforeWatched(t *testing.T) {
+	var s session
+	now := time.Now()
+	s.step(Event{PositionSeconds: 0, DurationSeconds: 100, State: "playing"}, now)
+	a, ok := s.step(Event{PositionSeconds: 30, DurationSeconds: 100, State: "stopped"}, now.Add(time.Minute))
+	if a != ActionStop || !ok || s.done {
+		t.Errorf("got (%q, %v) done=%v; want stop at 30%% without completing", a, ok, s.done)
+	}
+	if p := s.progress; p != 30 {
+		t.Errorf("progress = %v, want 30", p)
+	}
+}
+
+func TestScrobblerSweep(t *testing.T) {
+	sc := NewScrobbler(nil, nil)
+	now := time.Now()
+	idle := &session{last: ActionStart, seen: now.Add(-idleAfter - time.Second)}
+	sc.sessions["a"] = idle
+	sc.sessions["b"] = &session{last: ActionStart, seen: now}
+	sc.sessions["c"] = &session{last: ActionStop, done: true, seen: now.Add(-time.Hour)}
+
+	stale := sc.sweep(now)
+	if len(stale) != 1 || stale[0] != idle {
+		t.Errorf("stale = %v, want only the idle playing session", stale)
+	}
+	if _, ok := sc.sessions["b"]; !ok || len(sc.sessions) != 1 {
+		t.Errorf("sessions left = %v", sc.sessions)
+	}
+}
+
+func TestTokenSealRoundTrip(t *testing.T) {
+	t.Setenv("SCROBBLE_TOKEN_KEY", "")
+	if _, err := sealToken("x"); err != ErrNoTokenKey {
+		t.Fatalf("without key: got %v, want ErrNoTokenKey", err)
+	}
+
+	t.Setenv("SCROBBLE_TOKEN_KEY", strings.Repeat("ab", 32))
+	sealed, err := sealToken("access-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "access-token") {
+		t.Fatal("token stored in plaintext")
+	}
+	again, _ := sealToken("access-token")
+	if again == sealed {
+		t.Error("nonce reused")
+	}
+	plain, err := openToken(sealed)
+	if err != nil || plain != "access-token" {
+		t.Fatalf("open = %q, %v", plain, err)
+	}
+
+	t.Setenv("SCROBBLE_TOKEN_KEY", strings.Repeat("cd", 32))
+	if _, err := openToken(sealed); err == nil {
+		t.Error("opened with the wrong key")
+	}
+}
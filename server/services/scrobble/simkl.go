@@ -0,0 +1,257 @@
+// simkl.go — Simkl API client.
+package scrobble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Simkl implements Provider for simkl.com. Simkl tokens don't expire, and its
+// device flow ("PIN") is polled by user code.
+type Simkl struct {
+	BaseURL      string
+	ClientID     string
+	ClientSecret string
+	HTTP         *http.Client
+}
+
+// Name implements Provider.
+func (s *Simkl) Name() string { return "simkl" }
+
+// RequestDeviceCode implements Provider.
+func (s *Simkl) RequestDeviceCode(ctx context.Context) (*DeviceCode, error) {
+	var out struct {
+		Result          string `json:"result"`
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURL string `json:"verification_url"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	q := url.Values{"client_id": {s.ClientID}}
+	if err := s.do(ctx, http.MethodGet, "/oauth/pin?"+q.Encode(), "", nil, &out); err != nil {
+		return nil, err
+	}
+	if out.Result != "OK" || out.UserCode == "" {
+		return nil, fmt.Errorf("simkl pin: result %q", out.Result)
+	}
+	return &DeviceCode{
+		DeviceCode:      out.DeviceCode,
+		UserCode:        out.UserCode,
+		VerificationURL: out.VerificationURL,
+		ExpiresIn:       time.Duration(out.ExpiresIn) * time.Second,
+		Interval:        time.Duration(out.Interval) * time.Second,
+	}, nil
+}
+
+// PollDeviceToken implements Provider.
+func (s *Simkl) PollDeviceToken(ctx context.Context, dc *DeviceCode) (*Token, error) {
+	var out struct {
+		Result      string `json:"result"`
+		Message     string `json:"message"`
+		AccessToken string `json:"access_token"`
+	}
+	q := url.Values{"client_id": {s.ClientID}}
+	if err := s.do(ctx, http.MethodGet, "/oauth/pin/"+url.PathEscape(dc.UserCode)+"?"+q.Encode(), "", nil, &out); err != nil {
+		return nil, err
+	}
+	if out.Result == "OK" && out.AccessToken != "" {
+		return &Token{AccessToken: out.AccessToken}, nil
+	}
+	if strings.Contains(strings.ToLower(out.Message), "slow down") {
+		return nil, ErrSlowDown
+	}
+	return nil, ErrAuthPending
+}
+
+// RefreshToken implements Provider. Simkl tokens are long-lived; there is
+// nothing to refresh.
+func (s *Simkl) RefreshToken(ctx context.Context, refresh string) (*Token, error) {
+	return nil, fmt.Errorf("simkl tokens cannot be refreshed")
+}
+
+// Scrobble implements Provider. Simkl's scrobble endpoints take the same
+// body as Trakt's.
+func (s *Simkl) Scrobble(ctx context.Context, token string, action Action, it Item, progress float64) error {
+	return s.do(ctx, http.MethodPost, "/scrobble/"+string(action), token, buildScrobbleBody(it, progress), nil)
+}
+
+// simklItems is the response of /sync/all-items.
+type simklItems struct {
+	Movies []struct {
+		Status        string    `json:"status"`
+		LastWatchedAt time.Time `json:"last_watched_at"`
+		Movie         struct {
+			IDs simklIDs `json:"ids"`
+		} `json:"movie"`
+	} `json:"movies"`
+	Shows []struct {
+		Status string `json:"status"`
+		Show   struct {
+			IDs simklIDs `json:"ids"`
+		} `json:"show"`
+		Seasons []struct {
+			Number   int `json:"number"`
+			Episodes []struct {
+				Number    int       `json:"number"`
+				WatchedAt time.Time `json:"watched_at"`
+			} `json:"episodes"`
+		} `json:"seasons"`
+	} `json:"shows"`
+}
+
+func (s *Simkl) allItems(ctx context.Context, token string, since time.Time) (*simklItems, error) {
+	q := url.Values{"episode_watched_at": {"yes"}}
+	if !since.IsZero() {
+		q.Set("date_from", formatTime(since))
+	}
+	var out simklItems
+	if err := s.do(ctx, http.MethodGet, "/sync/all-items/?"+q.Encode(), token, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// History implements Provider.
+func (s *Simkl) History(ctx context.Context, token string, since time.Time) ([]Item, error) {
+	all, err := s.allItems(ctx, token, since)
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, m := range all.Movies {
+		if m.Status == "completed" && m.Movie.IDs.TMDB > 0 {
+			items = append(items, Item{Kind: "movie", TMDB: int(m.Movie.IDs.TMDB), WatchedAt: m.LastWatchedAt})
+		}
+	}
+	for _, sh := range all.Shows {
+		if sh.Show.IDs.TMDB == 0 {
+			continue
+		}
+		for _, se := range sh.Seasons {
+			for _, ep := range se.Episodes {
+				if ep.WatchedAt.IsZero() || (!since.IsZero() && ep.WatchedAt.Before(since)) {
+					continue
+				}
+				items = append(items, Item{Kind: "episode", TMDB: int(sh.Show.IDs.TMDB),
+					Season: se.Number, Number: ep.Number, WatchedAt: ep.WatchedAt})
+			}
+		}
+	}
+	return items, nil
+}
+
+// AddHistory implements Provider.
+func (s *Simkl) AddHistory(ctx context.Context, token string, items []Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return s.do(ctx, http.MethodPost, "/sync/history", token, buildSyncBody(items, ""), nil)
+}
+
+// Watchlist implements Provider. Simkl's watchlist is the "plantowatch" list.
+func (s *Simkl) Watchlist(ctx context.Context, token string) ([]Item, error) {
+	all, err := s.allItems(ctx, token, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, m := range all.Movies {
+		if m.Status == "plantowatch" && m.Movie.IDs.TMDB > 0 {
+			items = append(items, Item{Kind: "movie", TMDB: int(m.Movie.IDs.TMDB)})
+		}
+	}
+	for _, sh := range all.Shows {
+		if sh.Status == "plantowatch" && sh.Show.IDs.TMDB > 0 {
+			items = append(items, Item{Kind: "show", TMDB: int(sh.Show.IDs.TMDB)})
+		}
+	}
+	return items, nil
+}
+
+// AddWatchlist implements Provider.
+func (s *Simkl) AddWatchlist(ctx context.Context, token string, items []Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return s.do(ctx, http.MethodPost, "/sync/add-to-list", token, buildSyncBody(items, "plantowatch"), nil)
+}
+
+// simklIDs holds Simkl's ids object; Simkl sends TMDB ids as strings.
+type simklIDs struct {
+	TMDB flexInt `json:"tmdb"`
+}
+
+// flexInt decodes a JSON number or numeric string.
+type flexInt int
+
+func (f *flexInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*f = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		*f = 0 // non-numeric ids are not matchable; ignore rather than fail the page
+		return nil
+	}
+	*f = flexInt(n)
+	return nil
+}
+
+// do sends a Simkl request and decodes a 2xx JSON response into out. An
+// empty body (Simkl's "nothing changed") leaves out untouched.
+func (s *Simkl) do(ctx context.Context, method, path, token string, body, out interface{}) error {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.BaseURL+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("simkl-api-key", s.ClientID)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := s.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("simkl %s %s: HTTP %d: %s", method, path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 || string(bytes.TrimSpace(data)) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("simkl %s: decode: %w", path, err)
+	}
+	return nil
+}
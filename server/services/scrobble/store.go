@@ -0,0 +1,249 @@
+// store.go — Profile links (scrobble_links) and token encryption.
+package scrobble
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Link is one profile's connection to a provider.
+type Link struct {
+	ID                string
+	SubscriberID      string
+	ProfileID         string
+	Provider          string
+	Status            string // pending | linked | expired | error
+	UserCode          string
+	VerificationURL   string
+	DeviceCode        string
+	DeviceExpiresAt   time.Time
+	PollInterval      time.Duration
+	Token             Token // decrypted; empty unless loaded with tokens
+	HistorySyncedAt   time.Time
+	WatchlistSyncedAt time.Time
+	LastError         string
+	LinkedAt          time.Time
+}
+
+// ErrNoTokenKey is returned when SCROBBLE_TOKEN_KEY is not configured;
+// tokens are never stored in plaintext.
+var ErrNoTokenKey = errors.New("SCROBBLE_TOKEN_KEY must be a 64-char hex string (32 bytes)")
+
+// StartLink requests a device code from p and records a pending link for the
+// profile, replacing any earlier link to the same provider.
+func StartLink(ctx context.Context, db *sql.DB, p Provider, subscriberID, profileID string) (*Link, error) {
+	if _, err := tokenKey(); err != nil {
+		return nil, err
+	}
+	dc, err := p.RequestDeviceCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	interval := dc.Interval
+	if interval < time.Second {
+		interval = 5 * time.Second
+	}
+	l := &Link{
+		SubscriberID:    subscriberID,
+		ProfileID:       profileID,
+		Provider:        p.Name(),
+		Status:          "pending",
+		UserCode:        dc.UserCode,
+		VerificationURL: dc.VerificationURL,
+		DeviceCode:      dc.DeviceCode,
+		DeviceExpiresAt: time.Now().Add(dc.ExpiresIn),
+		PollInterval:    interval,
+	}
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO scrobble_links
+			(subscriber_id, profile_id, provider, status, device_code, user_code,
+			 verification_url, device_expires_at, poll_interval_secs)
+		VALUES ($1, $2, $3, 'pending', $4, $5, $6, $7, $8)
+		ON CONFLICT (profile_id, provider) DO UPDATE SET
+			status             = 'pending',
+			device_code        = EXCLUDED.device_code,
+			user_code          = EXCLUDED.user_code,
+			verification_url   = EXCLUDED.verification_url,
+			device_expires_at  = EXCLUDED.device_expires_at,
+			poll_interval_secs = EXCLUDED.poll_interval_secs,
+			access_token_enc   = NULL,
+			refresh_token_enc  = NULL,
+			token_expires_at   = NULL,
+			last_error         = NULL,
+			updated_at         = NOW()
+		RETURNING id`,
+		subscriberID, profileID, l.Provider, dc.DeviceCode, dc.UserCode,
+		dc.VerificationURL, l.DeviceExpiresAt, int(interval/time.Second)).Scan(&l.ID)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ProfileLinks lists a profile's links without their tokens.
+func ProfileLinks(ctx context.Context, db *sql.DB, profileID string) ([]Link, error) {
+	return queryLinks(ctx, db, false, `WHERE profile_id::text = $1 ORDER BY provider`, profileID)
+}
+
+// LinkedFor returns a profile's linked providers with decrypted tokens.
+func LinkedFor(ctx context.Context, db *sql.DB, profileID string) ([]Link, error) {
+	return queryLinks(ctx, db, true, `WHERE profile_id::text = $1 AND status = 'linked'`, profileID)
+}
+
+// Unlink removes a profile's link to provider. Tokens are discarded; the
+// provider-side app authorization stays until the user revokes it there.
+func Unlink(ctx context.Context, db *sql.DB, profileID, provider string) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		DELETE FROM scrobble_links WHERE profile_id::text = $1 AND provider = $2`,
+		profileID, provider)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+const linkColumns = `
+	SELECT id, subscriber_id, profile_id, provider, status,
+	       COALESCE(user_code, ''), COALESCE(verification_url, ''), COALESCE(device_code, ''),
+	       device_expires_at, poll_interval_secs,
+	       COALESCE(access_token_enc, ''), COALESCE(refresh_token_enc, ''), token_expires_at,
+	       history_synced_at, watchlist_synced_at, COALESCE(last_error, ''), linked_at
+	FROM scrobble_links `
+
+func queryLinks(ctx context.Context, db *sql.DB, withTokens bool, where string, args ...interface{}) ([]Link, error) {
+	rows, err := db.QueryContext(ctx, linkColumns+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var links []Link
+	for rows.Next() {
+		var l Link
+		var devExp, tokExp, histAt, wlAt, linkedAt sql.NullTime
+		var pollSecs int
+		var access, refresh string
+		if err := rows.Scan(&l.ID, &l.SubscriberID, &l.ProfileID, &l.Provider, &l.Status,
+			&l.UserCode, &l.VerificationURL, &l.DeviceCode, &devExp, &pollSecs,
+			&access, &refresh, &tokExp, &histAt, &wlAt, &l.LastError, &linkedAt); err != nil {
+			return nil, err
+		}
+		l.DeviceExpiresAt = devExp.Time
+		l.PollInterval = time.Duration(pollSecs) * time.Second
+		l.Token.ExpiresAt = tokExp.Time
+		l.HistorySyncedAt = histAt.Time
+		l.WatchlistSyncedAt = wlAt.Time
+		l.LinkedAt = linkedAt.Time
+		if withTokens && access != "" {
+			if l.Token.AccessToken, err = openToken(access); err != nil {
+				return nil, fmt.Errorf("link %s: %w", l.ID, err)
+			}
+			if refresh != "" {
+				if l.Token.RefreshToken, err = openToken(refresh); err != nil {
+					return nil, fmt.Errorf("link %s: %w", l.ID, err)
+				}
+			}
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// saveToken stores tok (encrypted) and marks the link linked.
+func saveToken(ctx context.Context, db *sql.DB, linkID string, tok *Token) error {
+	access, err := sealToken(tok.AccessToken)
+	if err != nil {
+		return err
+	}
+	var refresh interface{}
+	if tok.RefreshToken != "" {
+		s, err := sealToken(tok.RefreshToken)
+		if err != nil {
+			return err
+		}
+		refresh = s
+	}
+	var expires interface{}
+	if !tok.ExpiresAt.IsZero() {
+		expires = tok.ExpiresAt
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE scrobble_links SET
+			status = 'linked', access_token_enc = $2, refresh_token_enc = $3,
+			token_expires_at = $4, device_code = NULL, last_error = NULL,
+			linked_at = COALESCE(linked_at, NOW()), updated_at = NOW()
+		WHERE id = $1`, linkID, access, refresh, expires)
+	return err
+}
+
+// setStatus records a link's status and last error ("" clears it).
+func setStatus(ctx context.Context, db *sql.DB, linkID, status, lastError string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE scrobble_links SET status = $2, last_error = NULLIF($3, ''), updated_at = NOW()
+		WHERE id = $1`, linkID, status, lastError)
+	return err
+}
+
+// ---- token encryption (AES-256-GCM, same scheme as auth's TOTP secrets) ----
+
+func tokenKey() ([]byte, error) {
+	key, err := hex.DecodeString(os.Getenv("SCROBBLE_TOKEN_KEY"))
+	if err != nil || len(key) < 32 {
+		return nil, ErrNoTokenKey
+	}
+	return key[:32], nil
+}
+
+func tokenAEAD() (cipher.AEAD, error) {
+	key, err := tokenKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealToken(plaintext string) (string, error) {
+	gcm, err := tokenAEAD()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+func openToken(ciphertext string) (string, error) {
+	gcm, err := tokenAEAD()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("token ciphertext too short")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
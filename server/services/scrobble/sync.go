@@ -0,0 +1,308 @@
+// sync.go — Device-code polling and two-way history / watchlist sync.
+package scrobble
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// refreshBefore is how long before expiry a token is renewed. Trakt tokens
+// last three months.
+const refreshBefore = 7 * 24 * time.Hour
+
+// Syncer finishes pending links and keeps linked profiles in sync.
+//
+// History sync is additive both ways: titles a profile completed in Roost
+// since the last sync are added to the provider's history, and titles the
+// provider has watched are marked completed in watch_progress. The watchlist
+// works the same way against profile_watchlist. Removals are not synced, so
+// neither side can wipe the other.
+type Syncer struct {
+	db        *sql.DB
+	providers map[string]Provider
+	every     time.Duration
+
+	nextPoll map[string]time.Time // link id → earliest next device-token poll
+	trigger  chan struct{}
+}
+
+// NewSyncer creates a Syncer that syncs every linked profile each interval.
+func NewSyncer(db *sql.DB, providers map[string]Provider, every time.Duration) *Syncer {
+	return &Syncer{
+		db:        db,
+		providers: providers,
+		every:     every,
+		nextPoll:  map[string]time.Time{},
+		trigger:   make(chan struct{}, 1),
+	}
+}
+
+// Trigger requests a sync of every linked profile as soon as possible.
+func (s *Syncer) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run polls pending links and syncs linked ones until ctx is cancelled.
+func (s *Syncer) Run(ctx context.Context) {
+	poll := time.NewTicker(5 * time.Second)
+	defer poll.Stop()
+	full := time.NewTicker(s.every)
+	defer full.Stop()
+	s.SyncAll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			s.pollPending(ctx)
+		case <-full.C:
+			s.SyncAll(ctx)
+		case <-s.trigger:
+			s.SyncAll(ctx)
+		}
+	}
+}
+
+// pollPending asks each provider whether its pending device codes have been
+// approved, honouring the poll interval the provider asked for.
+func (s *Syncer) pollPending(ctx context.Context) {
+	links, err := queryLinks(ctx, s.db, false, `WHERE status = 'pending'`)
+	if err != nil {
+		log.Printf("[scrobble] pending links: %v", err)
+		return
+	}
+	now := time.Now()
+	pending := map[string]bool{}
+	for _, l := range links {
+		pending[l.ID] = true
+		p, ok := s.providers[l.Provider]
+		if !ok || now.Before(s.nextPoll[l.ID]) {
+			continue
+		}
+		if now.After(l.DeviceExpiresAt) {
+			_ = setStatus(ctx, s.db, l.ID, "expired", "device code expired")
+			continue
+		}
+		s.nextPoll[l.ID] = now.Add(l.PollInterval)
+		tok, err := p.PollDeviceToken(ctx, &DeviceCode{DeviceCode: l.DeviceCode, UserCode: l.UserCode})
+		switch {
+		case errors.Is(err, ErrAuthPending):
+		case errors.Is(err, ErrSlowDown):
+			s.nextPoll[l.ID] = now.Add(l.PollInterval + 5*time.Second)
+		case errors.Is(err, ErrCodeExpired):
+			_ = setStatus(ctx, s.db, l.ID, "expired", err.Error())
+		case errors.Is(err, ErrAccessDenied):
+			_ = setStatus(ctx, s.db, l.ID, "error", err.Error())
+		case err != nil:
+			log.Printf("[scrobble] poll %s link %s: %v", l.Provider, l.ID, err)
+		default:
+			if err := saveToken(ctx, s.db, l.ID, tok); err != nil {
+				log.Printf("[scrobble] save token for link %s: %v", l.ID, err)
+				continue
+			}
+			log.Printf("[scrobble] profile %s linked %s", l.ProfileID, l.Provider)
+			s.Trigger()
+		}
+	}
+	for id := range s.nextPoll {
+		if !pending[id] {
+			delete(s.nextPoll, id)
+		}
+	}
+}
+
+// SyncAll syncs every linked profile.
+func (s *Syncer) SyncAll(ctx context.Context) {
+	links, err := queryLinks(ctx, s.db, true, `WHERE status = 'linked'`)
+	if err != nil {
+		log.Printf("[scrobble] linked profiles: %v", err)
+		return
+	}
+	for _, l := range links {
+		p, ok := s.providers[l.Provider]
+		if !ok {
+			continue
+		}
+		if err := s.syncLink(ctx, p, l); err != nil {
+			status := "linked"
+			if errors.Is(err, ErrUnauthorized) {
+				status = "error"
+			}
+			log.Printf("[scrobble] sync %s link %s: %v", l.Provider, l.ID, err)
+			_ = setStatus(ctx, s.db, l.ID, status, err.Error())
+		}
+	}
+}
+
+// syncLink refreshes l's token if needed and syncs its history and watchlist.
+func (s *Syncer) syncLink(ctx context.Context, p Provider, l Link) error {
+	if !l.Token.ExpiresAt.IsZero() && l.Token.RefreshToken != "" &&
+		time.Until(l.Token.ExpiresAt) < refreshBefore {
+		tok, err := p.RefreshToken(ctx, l.Token.RefreshToken)
+		if err != nil {
+			return fmt.Errorf("refresh token: %w", err)
+		}
+		if err := saveToken(ctx, s.db, l.ID, tok); err != nil {
+			return err
+		}
+		l.Token = *tok
+	}
+	var errs []string
+	if err := s.syncHistory(ctx, p, l); err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return err
+		}
+		errs = append(errs, "history: "+err.Error())
+	}
+	if err := s.syncWatchlist(ctx, p, l); err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return err
+		}
+		errs = append(errs, "watchlist: "+err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	if l.LastError != "" {
+		return setStatus(ctx, s.db, l.ID, "linked", "")
+	}
+	return nil
+}
+
+// syncHistory exchanges completed titles with the provider. The push list is
+// read before pulling so titles just imported aren't echoed back.
+func (s *Syncer) syncHistory(ctx context.Context, p Provider, l Link) error {
+	started := time.Now()
+	push, err := s.completedSince(ctx, l.ProfileID, l.HistorySyncedAt)
+	if err != nil {
+		return err
+	}
+	pulled, err := p.History(ctx, l.Token.AccessToken, l.HistorySyncedAt)
+	if err != nil {
+		return err
+	}
+	for _, it := range pulled {
+		e, ok, err := catalogFor(ctx, s.db, it)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		watched := it.WatchedAt
+		if watched.IsZero() || watched.After(started) {
+			watched = started
+		}
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO watch_progress
+				(subscriber_id, profile_id, content_type, content_id,
+				 position_seconds, duration_seconds, completed, last_watched_at)
+			VALUES ($1, $2, $3, $4, $5, $5, TRUE, $6)
+			ON CONFLICT (profile_id, content_type, content_id) DO UPDATE SET
+				completed       = TRUE,
+				last_watched_at = GREATEST(watch_progress.last_watched_at, EXCLUDED.last_watched_at)
+			WHERE NOT watch_progress.completed`,
+			l.SubscriberID, l.ProfileID, e.ContentType, e.ContentID, e.Duration, watched); err != nil {
+			return err
+		}
+	}
+	if err := p.AddHistory(ctx, l.Token.AccessToken, push); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE scrobble_links SET history_synced_at = $2, updated_at = NOW() WHERE id = $1`,
+		l.ID, started)
+	return err
+}
+
+// completedSince lists the profile's titles completed after since that have
+// a TMDB id.
+func (s *Syncer) completedSince(ctx context.Context, profileID string, since time.Time) ([]Item, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT wp.content_type, `+catalogTMDB+`,
+		       COALESCE(vs.season_number, 0), COALESCE(e.episode_number, 0), wp.last_watched_at
+		FROM watch_progress wp
+		LEFT JOIN vod_episodes e ON wp.content_type = 'episode' AND e.id = wp.content_id
+		LEFT JOIN vod_series vs  ON vs.id = e.series_id
+		JOIN vod_catalog c ON c.id = CASE WHEN wp.content_type = 'movie'
+		                                  THEN wp.content_id ELSE vs.catalog_id END
+		WHERE wp.profile_id::text = $1 AND wp.completed = TRUE
+		  AND wp.content_type IN ('movie', 'episode')
+		  AND wp.last_watched_at > $2
+		  AND c.metadata->>'tmdb_id' ~ '^[0-9]+$'`, profileID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Item
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.Kind, &it.TMDB, &it.Season, &it.Number, &it.WatchedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// syncWatchlist exchanges watchlist additions with the provider.
+func (s *Syncer) syncWatchlist(ctx context.Context, p Provider, l Link) error {
+	started := time.Now()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT CASE WHEN c.type = 'movie' THEN 'movie' ELSE 'show' END, `+catalogTMDB+`
+		FROM profile_watchlist w
+		JOIN vod_catalog c ON c.id = w.catalog_id
+		WHERE w.profile_id::text = $1 AND w.added_at > $2
+		  AND c.metadata->>'tmdb_id' ~ '^[0-9]+$'`, l.ProfileID, l.WatchlistSyncedAt)
+	if err != nil {
+		return err
+	}
+	var push []Item
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.Kind, &it.TMDB); err != nil {
+			rows.Close()
+			return err
+		}
+		push = append(push, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	pulled, err := p.Watchlist(ctx, l.Token.AccessToken)
+	if err != nil {
+		return err
+	}
+	for _, it := range pulled {
+		e, ok, err := catalogFor(ctx, s.db, it)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO profile_watchlist (profile_id, catalog_id, added_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING`, l.ProfileID, e.ContentID, started); err != nil {
+			return err
+		}
+	}
+	if err := p.AddWatchlist(ctx, l.Token.AccessToken, push); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE scrobble_links SET watchlist_synced_at = $2, updated_at = NOW() WHERE id = $1`,
+		l.ID, started)
+	return err
+}
@@ -0,0 +1,250 @@
+// trakt.go — Trakt API v2 client.
+package scrobble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// traktPageLimit bounds history pages fetched per sync.
+const traktPageLimit = 20
+
+// Trakt implements Provider for trakt.tv.
+type Trakt struct {
+	BaseURL      string
+	ClientID     string
+	ClientSecret string
+	HTTP         *http.Client
+}
+
+// Name implements Provider.
+func (t *Trakt) Name() string { return "trakt" }
+
+type traktToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+func (tt traktToken) token() *Token {
+	tok := &Token{AccessToken: tt.AccessToken, RefreshToken: tt.RefreshToken}
+	if tt.ExpiresIn > 0 {
+		created := time.Unix(tt.CreatedAt, 0)
+		if tt.CreatedAt == 0 {
+			created = time.Now()
+		}
+		tok.ExpiresAt = created.Add(time.Duration(tt.ExpiresIn) * time.Second)
+	}
+	return tok
+}
+
+// RequestDeviceCode implements Provider.
+func (t *Trakt) RequestDeviceCode(ctx context.Context) (*DeviceCode, error) {
+	var out struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURL string `json:"verification_url"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	if _, err := t.do(ctx, http.MethodPost, "/oauth/device/code", "",
+		map[string]string{"client_id": t.ClientID}, &out); err != nil {
+		return nil, err
+	}
+	return &DeviceCode{
+		DeviceCode:      out.DeviceCode,
+		UserCode:        out.UserCode,
+		VerificationURL: out.VerificationURL,
+		ExpiresIn:       time.Duration(out.ExpiresIn) * time.Second,
+		Interval:        time.Duration(out.Interval) * time.Second,
+	}, nil
+}
+
+// PollDeviceToken implements Provider. Trakt signals the polling state with
+// status codes.
+func (t *Trakt) PollDeviceToken(ctx context.Context, dc *DeviceCode) (*Token, error) {
+	var out traktToken
+	status, err := t.do(ctx, http.MethodPost, "/oauth/device/token", "", map[string]string{
+		"code":          dc.DeviceCode,
+		"client_id":     t.ClientID,
+		"client_secret": t.ClientSecret,
+	}, &out)
+	switch status {
+	case http.StatusBadRequest:
+		return nil, ErrAuthPending
+	case http.StatusTooManyRequests:
+		return nil, ErrSlowDown
+	case http.StatusNotFound, http.StatusConflict, http.StatusGone:
+		return nil, ErrCodeExpired
+	case 418:
+		return nil, ErrAccessDenied
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.token(), nil
+}
+
+// RefreshToken implements Provider.
+func (t *Trakt) RefreshToken(ctx context.Context, refresh string) (*Token, error) {
+	var out traktToken
+	if _, err := t.do(ctx, http.MethodPost, "/oauth/token", "", map[string]string{
+		"refresh_token": refresh,
+		"client_id":     t.ClientID,
+		"client_secret": t.ClientSecret,
+		"redirect_uri":  "urn:ietf:wg:oauth:2.0:oob",
+		"grant_type":    "refresh_token",
+	}, &out); err != nil {
+		return nil, err
+	}
+	return out.token(), nil
+}
+
+// Scrobble implements Provider.
+func (t *Trakt) Scrobble(ctx context.Context, token string, action Action, it Item, progress float64) error {
+	status, err := t.do(ctx, http.MethodPost, "/scrobble/"+string(action), token, buildScrobbleBody(it, progress), nil)
+	if status == http.StatusConflict {
+		return nil // already scrobbled in the last few minutes
+	}
+	return err
+}
+
+// History implements Provider.
+func (t *Trakt) History(ctx context.Context, token string, since time.Time) ([]Item, error) {
+	var items []Item
+	for _, kind := range []string{"movies", "episodes"} {
+		for page := 1; page <= traktPageLimit; page++ {
+			q := url.Values{"page": {strconv.Itoa(page)}, "limit": {"100"}}
+			if !since.IsZero() {
+				q.Set("start_at", formatTime(since))
+			}
+			var out []struct {
+				WatchedAt time.Time `json:"watched_at"`
+				Movie     *struct {
+					IDs traktIDs `json:"ids"`
+				} `json:"movie"`
+				Show *struct {
+					IDs traktIDs `json:"ids"`
+				} `json:"show"`
+				Episode *struct {
+					Season int `json:"season"`
+					Number int `json:"number"`
+				} `json:"episode"`
+			}
+			if _, err := t.do(ctx, http.MethodGet, "/sync/history/"+kind+"?"+q.Encode(), token, nil, &out); err != nil {
+				return nil, err
+			}
+			for _, h := range out {
+				switch {
+				case h.Movie != nil && h.Movie.IDs.TMDB > 0:
+					items = append(items, Item{Kind: "movie", TMDB: h.Movie.IDs.TMDB, WatchedAt: h.WatchedAt})
+				case h.Show != nil && h.Episode != nil && h.Show.IDs.TMDB > 0:
+					items = append(items, Item{Kind: "episode", TMDB: h.Show.IDs.TMDB,
+						Season: h.Episode.Season, Number: h.Episode.Number, WatchedAt: h.WatchedAt})
+				}
+			}
+			if len(out) < 100 {
+				break
+			}
+		}
+	}
+	return items, nil
+}
+
+// AddHistory implements Provider.
+func (t *Trakt) AddHistory(ctx context.Context, token string, items []Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	_, err := t.do(ctx, http.MethodPost, "/sync/history", token, buildSyncBody(items, ""), nil)
+	return err
+}
+
+// Watchlist implements Provider.
+func (t *Trakt) Watchlist(ctx context.Context, token string) ([]Item, error) {
+	var items []Item
+	for _, kind := range []string{"movies", "shows"} {
+		var out []struct {
+			Movie *struct {
+				IDs traktIDs `json:"ids"`
+			} `json:"movie"`
+			Show *struct {
+				IDs traktIDs `json:"ids"`
+			} `json:"show"`
+		}
+		if _, err := t.do(ctx, http.MethodGet, "/sync/watchlist/"+kind, token, nil, &out); err != nil {
+			return nil, err
+		}
+		for _, w := range out {
+			switch {
+			case w.Movie != nil && w.Movie.IDs.TMDB > 0:
+				items = append(items, Item{Kind: "movie", TMDB: w.Movie.IDs.TMDB})
+			case w.Show != nil && w.Show.IDs.TMDB > 0:
+				items = append(items, Item{Kind: "show", TMDB: w.Show.IDs.TMDB})
+			}
+		}
+	}
+	return items, nil
+}
+
+// AddWatchlist implements Provider.
+func (t *Trakt) AddWatchlist(ctx context.Context, token string, items []Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	_, err := t.do(ctx, http.MethodPost, "/sync/watchlist", token, buildSyncBody(items, ""), nil)
+	return err
+}
+
+type traktIDs struct {
+	TMDB int `json:"tmdb"`
+}
+
+// do sends a Trakt request and decodes a 2xx JSON response into out. It
+// returns the HTTP status so callers can interpret non-2xx codes.
+func (t *Trakt) do(ctx context.Context, method, path, token string, body, out interface{}) (int, error) {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.BaseURL+path, rd)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("trakt-api-version", "2")
+	req.Header.Set("trakt-api-key", t.ClientID)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := t.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return resp.StatusCode, ErrUnauthorized
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("trakt %s %s: HTTP %d: %s", method, path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("trakt %s: decode: %w", path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
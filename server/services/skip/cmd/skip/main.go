@@ -6,6 +6,7 @@
 //   GET  /skip/v1/:content_id          — fetch .skip sidecar (approved scenes)
 //   GET  /skip/v1/scenes/:id           — scene detail + vote count
 //   GET  /skip/v1/stats                — contribution stats leaderboard
+//   GET  /skip/v1/markers/:content_id  — intro / credits / recap markers
 //
 // Authenticated routes (Bearer token required):
 //   POST   /skip/v1/scenes             — submit a new scene entry
@@ -16,6 +17,12 @@
 //   GET  /skip/v1/admin/disputed       — list disputed scenes for review
 //   POST /skip/v1/admin/approve/:id    — force-approve a scene
 //   POST /skip/v1/admin/reject/:id     — reject + delete a scene
+//   POST /skip/v1/admin/analyze        — scan for new episodes to mark now
+//
+// Intro, credits and recap markers for VOD episodes and DVR series recordings
+// are detected in the background from Chromaprint audio fingerprints (see
+// package markers). Requires fpcalc (env FPCALC_PATH, else PATH); scans run
+// every SKIP_ANALYZE_INTERVAL (default 1h). SKIP_ANALYZE=false disables them.
 
 package main
 
@@ -37,6 +44,7 @@ import (
 
 	rootauth "github.com/unyeco/roost/internal/auth"
 	"github.com/unyeco/roost/internal/ratelimit"
+	"github.com/unyeco/roost/services/skip/markers"
 )
 
 // ── helpers ───────────────────────────────────────────────────────────────────
@@ -80,9 +88,10 @@ var reContentID = regexp.MustCompile(`^[a-z]+:[a-z0-9_:.\-]+$`)
 // ── server ────────────────────────────────────────────────────────────────────
 
 type server struct {
-	db      *sql.DB
-	redis   *goredis.Client
-	limiter *ratelimit.Limiter
+	db       *sql.DB
+	redis    *goredis.Client
+	limiter  *ratelimit.Limiter
+	analyzer *markers.Analyzer // nil when fpcalc is unavailable or analysis is disabled
 }
 
 // ── auth middleware ───────────────────────────────────────────────────────────
@@ -152,6 +161,7 @@ type sidecarResponse struct {
 	Contributors int             `json:"contributors"`
 	GeneratedAt string           `json:"generated_at"`
 	Scenes      []sceneResponse  `json:"scenes"`
+	Markers     []markers.Marker `json:"markers"`
 }
 
 var validCategories = map[string]bool{
@@ -229,12 +239,18 @@ func (s *server) handleFetchSidecar(w http.ResponseWriter, r *http.Request) {
 		`SELECT COUNT(DISTINCT submitted_by) FROM skip_scenes WHERE content_id = $1`, contentID,
 	).Scan(&contributors)
 
+	ms, err := markers.Load(r.Context(), s.db, contentID)
+	if err != nil {
+		ms = []markers.Marker{}
+	}
+
 	resp := sidecarResponse{
 		ContentID:    contentID,
 		Version:      1,
 		Contributors: contributors,
 		GeneratedAt:  time.Now().UTC().Format(time.RFC3339),
 		Scenes:       scenes,
+		Markers:      ms,
 	}
 
 	// Cache for 1hr.
@@ -248,6 +264,26 @@ func (s *server) handleFetchSidecar(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// ── route: GET /skip/v1/markers/:content_id ──────────────────────────────────
+
+func (s *server) handleMarkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "GET only")
+		return
+	}
+	contentID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/skip/v1/markers/"), "/")
+	if !reContentID.MatchString(contentID) {
+		writeError(w, http.StatusBadRequest, "invalid_content_id", "Content ID must match {source}:{id} format")
+		return
+	}
+	ms, err := markers.Load(r.Context(), s.db, contentID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", "Failed to fetch markers")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"content_id": contentID, "markers": ms})
+}
+
 // ── route: POST /skip/v1/scenes ───────────────────────────────────────────────
 
 func (s *server) handleSubmitScene(w http.ResponseWriter, r *http.Request) {
@@ -586,6 +622,23 @@ func (s *server) handleAdminReject(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "rejected"})
 }
 
+func (s *server) handleAdminAnalyze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "POST only")
+		return
+	}
+	if _, err := s.requireSuperowner(r); err != nil {
+		writeError(w, http.StatusForbidden, "forbidden", "Superowner access required")
+		return
+	}
+	if s.analyzer == nil {
+		writeError(w, http.StatusServiceUnavailable, "analyzer_disabled", "Marker analysis is not running")
+		return
+	}
+	s.analyzer.Trigger()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "scheduled"})
+}
+
 // ── routing helpers ───────────────────────────────────────────────────────────
 
 // extractSceneID parses /{...}/scenes/{uuid}/{suffix} and returns the UUID.
@@ -633,6 +686,25 @@ func main() {
 
 	srv := &server{db: db, redis: rdb, limiter: limiter}
 
+	if getEnv("SKIP_ANALYZE", "true") != "false" {
+		if fp, err := markers.NewFingerprinter(getEnv("FPCALC_PATH", "")); err != nil {
+			log.Printf("[skip] marker analysis disabled: %v", err)
+		} else {
+			every, err := time.ParseDuration(getEnv("SKIP_ANALYZE_INTERVAL", "1h"))
+			if err != nil || every <= 0 {
+				every = time.Hour
+			}
+			srv.analyzer = markers.NewAnalyzer(db, fp, every)
+			srv.analyzer.Published = func(contentID string) {
+				if rdb != nil {
+					_ = rdb.Del(context.Background(), "skip:sidecar:"+contentID).Err()
+				}
+			}
+			go srv.analyzer.Run(context.Background())
+			log.Printf("[skip] marker analysis every %s", every)
+		}
+	}
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -645,6 +717,8 @@ func main() {
 	mux.HandleFunc("/skip/v1/admin/disputed", srv.handleAdminDisputed)
 	mux.HandleFunc("/skip/v1/admin/approve/", srv.handleAdminApprove)
 	mux.HandleFunc("/skip/v1/admin/reject/", srv.handleAdminReject)
+	mux.HandleFunc("/skip/v1/admin/analyze", srv.handleAdminAnalyze)
+	mux.HandleFunc("/skip/v1/markers/", srv.handleMarkers)
 
 	// Scene CRUD.
 	mux.HandleFunc("/skip/v1/scenes", srv.dispatchScenes) // POST
@@ -0,0 +1,289 @@
+// analyzer.go — Finds unscanned episodes, runs detection and publishes markers.
+package markers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Content ids markers are published under, in the skip service's
+// {source}:{id} format.
+const (
+	EpisodePrefix   = "roost:episode:"   // + vod_episodes.id
+	RecordingPrefix = "roost:recording:" // + dvr_recordings.id
+)
+
+// retryFailedAfter is how long an episode whose fingerprint failed waits
+// before it is tried again.
+const retryFailedAfter = 24 * time.Hour
+
+// member is one episode of a season group.
+type member struct {
+	contentID string
+	number    int
+	input     string // fpcalc input: HLS URL or local playlist
+	scanned   bool
+}
+
+// fingerprinter is implemented by *Fingerprinter.
+type fingerprinter interface {
+	Fingerprint(ctx context.Context, input string) ([]uint32, error)
+}
+
+// Analyzer periodically scans VOD seasons and DVR series recordings.
+//
+// A VOD group is one season (vod_series row). A DVR group is the completed
+// recordings of one title on one channel, ordered by air time; recordings of
+// the same airing share an episode number. Groups are analyzed once they
+// have at least two episodes and one of them has not been scanned.
+// DVR recordings are read from their storage_path, so the skip service needs
+// the DVR storage volume mounted at the same path.
+type Analyzer struct {
+	db      *sql.DB
+	fp      fingerprinter
+	params  Params
+	every   time.Duration
+	trigger chan struct{}
+
+	// Published, when set, is called with each content id whose markers
+	// changed (the skip service drops its cached sidecar).
+	Published func(contentID string)
+}
+
+// NewAnalyzer creates an Analyzer that scans every interval.
+func NewAnalyzer(db *sql.DB, fp *Fingerprinter, every time.Duration) *Analyzer {
+	return &Analyzer{db: db, fp: fp, params: DefaultParams, every: every, trigger: make(chan struct{}, 1)}
+}
+
+// Trigger requests a scan as soon as possible.
+func (a *Analyzer) Trigger() {
+	select {
+	case a.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run scans until ctx is cancelled.
+func (a *Analyzer) Run(ctx context.Context) {
+	t := time.NewTicker(a.every)
+	defer t.Stop()
+	for {
+		if err := a.ScanAll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("[skip] marker scan: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-a.trigger:
+		}
+	}
+}
+
+// ScanAll analyzes every group with unscanned episodes.
+func (a *Analyzer) ScanAll(ctx context.Context) error {
+	groups, err := a.groups(ctx)
+	if err != nil {
+		return err
+	}
+	for key, members := range groups {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := a.analyzeGroup(ctx, members); err != nil {
+			log.Printf("[skip] markers for %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// groupsSQL lists every episode of each group that has a pending episode.
+// $1 is the retry cutoff for failed scans.
+const groupsSQL = `
+WITH eps AS (
+	SELECT 'vod:' || e.series_id::text AS group_key,
+	       '` + EpisodePrefix + `' || e.id::text AS content_id,
+	       e.episode_number AS number,
+	       e.source_url AS input
+	FROM vod_episodes e
+	JOIN vod_series vs ON vs.id = e.series_id
+	JOIN vod_catalog c ON c.id = vs.catalog_id AND c.is_active = TRUE
+	UNION ALL
+	SELECT 'dvr:' || r.channel_id::text || ':' || LOWER(r.title),
+	       '` + RecordingPrefix + `' || r.id::text,
+	       DENSE_RANK() OVER (PARTITION BY r.channel_id, LOWER(r.title) ORDER BY r.start_time)::int,
+	       r.storage_path
+	FROM dvr_recordings r
+	WHERE r.status = 'complete' AND r.storage_path IS NOT NULL
+),
+marked AS (
+	SELECT eps.*,
+	       s.content_id IS NOT NULL AND (s.error IS NULL OR s.scanned_at > $1) AS scanned
+	FROM eps
+	LEFT JOIN skip_marker_scans s ON s.content_id = eps.content_id
+)
+SELECT group_key, content_id, number, input, scanned
+FROM marked
+WHERE group_key IN (
+	SELECT group_key FROM marked GROUP BY group_key
+	HAVING COUNT(DISTINCT number) >= 2 AND bool_or(NOT scanned)
+)
+ORDER BY group_key, number`
+
+func (a *Analyzer) groups(ctx context.Context) (map[string][]member, error) {
+	rows, err := a.db.QueryContext(ctx, groupsSQL, time.Now().Add(-retryFailedAfter))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := map[string][]member{}
+	for rows.Next() {
+		var key string
+		var m member
+		if err := rows.Scan(&key, &m.contentID, &m.number, &m.input, &m.scanned); err != nil {
+			return nil, err
+		}
+		groups[key] = append(groups[key], m)
+	}
+	return groups, rows.Err()
+}
+
+// analyzeGroup fingerprints the pending episodes of one group and the
+// neighbours they are compared with, then publishes what Detect finds.
+func (a *Analyzer) analyzeGroup(ctx context.Context, members []member) error {
+	targets := map[string]bool{}
+	var eps []Episode
+	for _, m := range members {
+		eps = append(eps, Episode{ContentID: m.contentID, Number: m.number})
+		if !m.scanned {
+			targets[m.contentID] = true
+		}
+	}
+
+	// Only targets and the episodes within MaxPairs of one are fingerprinted;
+	// neighbours are picked by number, as nothing is fingerprinted yet.
+	need := map[int]bool{}
+	for i, e := range eps {
+		if !targets[e.ContentID] {
+			continue
+		}
+		need[i] = true
+		for n, j := range neighbours(eps, i) {
+			if n >= a.params.MaxPairs+1 {
+				break
+			}
+			need[j] = true
+		}
+	}
+	fpErrs := map[string]error{}
+	for i := range eps {
+		if !need[i] {
+			continue
+		}
+		points, err := a.fp.Fingerprint(ctx, members[i].input)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fpErrs[eps[i].ContentID] = err
+			continue
+		}
+		eps[i].Points = points
+	}
+
+	found := Detect(eps, targets, a.params)
+	for _, e := range eps {
+		ms := found[e.ContentID]
+		if targets[e.ContentID] {
+			if err := a.publish(ctx, e.ContentID, ms, true); err != nil {
+				return err
+			}
+			if err := a.recordScan(ctx, e.ContentID, fpErrs[e.ContentID]); err != nil {
+				return err
+			}
+		} else if len(ms) > 0 {
+			if err := a.publish(ctx, e.ContentID, ms, false); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("[skip] scanned %d episode(s): %d with markers", len(targets), countWith(found, targets))
+	return nil
+}
+
+func countWith(found map[string][]Marker, targets map[string]bool) int {
+	n := 0
+	for id := range targets {
+		if len(found[id]) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// publish stores markers for contentID. Detected markers never replace
+// markers set by an admin; with replace=false they are only added where the
+// episode has no marker of that kind.
+func (a *Analyzer) publish(ctx context.Context, contentID string, ms []Marker, replace bool) error {
+	if len(ms) == 0 {
+		return nil
+	}
+	onConflict := `DO NOTHING`
+	if replace {
+		onConflict = `DO UPDATE SET
+			start_seconds = EXCLUDED.start_seconds,
+			end_seconds   = EXCLUDED.end_seconds,
+			confidence    = EXCLUDED.confidence,
+			detected_at   = NOW()
+		WHERE skip_markers.source = 'fingerprint'`
+	}
+	for _, m := range ms {
+		if _, err := a.db.ExecContext(ctx, `
+			INSERT INTO skip_markers (content_id, kind, start_seconds, end_seconds, confidence, source)
+			VALUES ($1, $2, $3, $4, $5, 'fingerprint')
+			ON CONFLICT (content_id, kind) `+onConflict,
+			contentID, m.Kind, m.Start, m.End, m.Confidence); err != nil {
+			return err
+		}
+	}
+	if a.Published != nil {
+		a.Published(contentID)
+	}
+	return nil
+}
+
+func (a *Analyzer) recordScan(ctx context.Context, contentID string, fpErr error) error {
+	var errText interface{}
+	if fpErr != nil {
+		errText = fpErr.Error()
+	}
+	_, err := a.db.ExecContext(ctx, `
+		INSERT INTO skip_marker_scans (content_id, scanned_at, error)
+		VALUES ($1, NOW(), $2)
+		ON CONFLICT (content_id) DO UPDATE SET scanned_at = NOW(), error = EXCLUDED.error`,
+		contentID, errText)
+	return err
+}
+
+// Load returns the published markers for contentID, ordered by start.
+func Load(ctx context.Context, db *sql.DB, contentID string) ([]Marker, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT kind, start_seconds, end_seconds, confidence
+		FROM skip_markers WHERE content_id = $1
+		ORDER BY start_seconds`, contentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ms := []Marker{}
+	for rows.Next() {
+		var m Marker
+		if err := rows.Scan(&m.Kind, &m.Start, &m.End, &m.Confidence); err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, rows.Err()
+}
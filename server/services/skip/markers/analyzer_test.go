@@ -0,0 +1,118 @@
+package markers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// execLog is a database/sql driver that records every Exec and returns no
+// rows for queries.
+type execLog struct {
+	mu    sync.Mutex
+	execs [][]driver.Value
+}
+
+func (l *execLog) Open(string) (driver.Conn, error) { return logConn{l}, nil }
+
+type logConn struct{ l *execLog }
+
+func (c logConn) Prepare(query string) (driver.Stmt, error) { return logStmt{c.l}, nil }
+func (c logConn) Close() error                              { return nil }
+func (c logConn) Begin() (driver.Tx, error)                 { return nil, fmt.Errorf("no transactions") }
+
+type logStmt struct{ l *execLog }
+
+func (s logStmt) Close() error  { return nil }
+func (s logStmt) NumInput() int { return -1 }
+func (s logStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.l.mu.Lock()
+	s.l.execs = append(s.l.execs, args)
+	s.l.mu.Unlock()
+	return driver.RowsAffected(1), nil
+}
+func (s logStmt) Query([]driver.Value) (driver.Rows, error) { return noRows{}, nil }
+
+type noRows struct{}
+
+func (noRows) Columns() []string         { return nil }
+func (noRows) Close() error              { return nil }
+func (noRows) Next([]driver.Value) error { return io.EOF }
+
+// fakeFP returns canned fingerprints by input and records what was asked.
+type fakeFP struct {
+	points map[string][]uint32
+	asked  []string
+}
+
+func (f *fakeFP) Fingerprint(_ context.Context, input string) ([]uint32, error) {
+	f.asked = append(f.asked, input)
+	pts, ok := f.points[input]
+	if !ok {
+		return nil, fmt.Errorf("no fingerprint for %s", input)
+	}
+	return pts, nil
+}
+
+func TestAnalyzeGroupNewEpisodeInScannedSeason(t *testing.T) {
+	s := synth{rng: rand.New(rand.NewSource(4))}
+	intro := s.random(40 * time.Second)
+	fp := &fakeFP{points: map[string][]uint32{}}
+	var members []member
+	for n := 1; n <= 8; n++ {
+		pts := s.random(20 * time.Minute)
+		s.paste(pts, 15*time.Second, intro)
+		input := fmt.Sprintf("ep%d.m3u8", n)
+		fp.points[input] = pts
+		members = append(members, member{
+			contentID: fmt.Sprintf("%s%d", EpisodePrefix, n),
+			number:    n,
+			input:     input,
+			scanned:   n < 8,
+		})
+	}
+
+	log := &execLog{}
+	name := fmt.Sprintf("markers-test-%p", log)
+	sql.Register(name, log)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	a := &Analyzer{db: db, fp: fp, params: DefaultParams}
+
+	if err := a.analyzeGroup(context.Background(), members); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new episode and the MaxPairs+1 scanned episodes nearest it are
+	// fingerprinted; episodes 1 and 2 are too far away.
+	want := "[ep3.m3u8 ep4.m3u8 ep5.m3u8 ep6.m3u8 ep7.m3u8 ep8.m3u8]"
+	if got := fmt.Sprint(fp.asked); got != want {
+		t.Errorf("fingerprinted %s, want %s", got, want)
+	}
+
+	newID := EpisodePrefix + "8"
+	var intros, scans int
+	for _, args := range log.execs {
+		if fmt.Sprint(args[0]) != newID {
+			continue
+		}
+		switch {
+		case len(args) == 5 && args[1] == KindIntro:
+			intros++
+		case len(args) == 2 && args[1] == nil:
+			scans++
+		}
+	}
+	if intros != 1 || scans != 1 {
+		t.Errorf("new episode: %d intro marker(s) and %d clean scan(s) written, want 1 and 1", intros, scans)
+	}
+}
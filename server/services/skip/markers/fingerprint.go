@@ -0,0 +1,70 @@
+// fingerprint.go — Chromaprint audio fingerprints via fpcalc.
+package markers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// maxFingerprintSeconds caps how much audio fpcalc decodes per episode.
+const maxFingerprintSeconds = 4 * 60 * 60
+
+// Fingerprinter runs fpcalc (from the chromaprint tools package).
+type Fingerprinter struct {
+	fpcalcPath string
+}
+
+// NewFingerprinter creates a Fingerprinter. Pass "" to find fpcalc in PATH.
+func NewFingerprinter(fpcalcPath string) (*Fingerprinter, error) {
+	if fpcalcPath == "" {
+		path, err := exec.LookPath("fpcalc")
+		if err != nil {
+			return nil, fmt.Errorf("markers: fpcalc not found in PATH: %w", err)
+		}
+		fpcalcPath = path
+	}
+	return &Fingerprinter{fpcalcPath: fpcalcPath}, nil
+}
+
+// Fingerprint returns the raw fingerprint of the whole input, which may be a
+// local file, an HLS playlist or a URL fpcalc's FFmpeg build can open.
+func (f *Fingerprinter) Fingerprint(ctx context.Context, input string) ([]uint32, error) {
+	cmd := exec.CommandContext(ctx, f.fpcalcPath,
+		"-raw", "-json",
+		"-length", strconv.Itoa(maxFingerprintSeconds),
+		input,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("fpcalc: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return parseFpcalcJSON(stdout.Bytes())
+}
+
+// parseFpcalcJSON parses `fpcalc -raw -json` output:
+//
+//	{"duration": 1325.42, "fingerprint": [3635932163, 3635940355, ...]}
+//
+// Older fpcalc builds print the points as signed 32-bit integers.
+func parseFpcalcJSON(data []byte) ([]uint32, error) {
+	var out struct {
+		Fingerprint []int64 `json:"fingerprint"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("fpcalc output: %w", err)
+	}
+	if len(out.Fingerprint) == 0 {
+		return nil, fmt.Errorf("fpcalc output: empty fingerprint")
+	}
+	points := make([]uint32, len(out.Fingerprint))
+	for i, v := range out.Fingerprint {
+		points[i] = uint32(v)
+	}
+	return points, nil
+}
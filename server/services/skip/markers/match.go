@@ -0,0 +1,371 @@
+// Package markers finds intro, credits and recap ranges in series episodes.
+//
+// Episodes of the same season share their opening titles and end credits, so
+// the audio of those ranges is identical from one episode to the next while
+// the rest of the episode is not. The analyzer fingerprints each episode's
+// audio with Chromaprint (fpcalc -raw), then compares episode pairs:
+//
+//  1. An inverted index over one episode's fingerprint points gives candidate
+//     alignments (time shifts) between the two episodes.
+//  2. For each candidate shift the aligned points are compared by Hamming
+//     distance; points within MaxBitDiff bits match.
+//  3. The longest run of matching points, allowing short gaps, is the shared
+//     range. Inside the first IntroWindow it is the intro; inside the last
+//     CreditsWindow it is the credits.
+//
+// A recap ("Previously on…") replays short clips of earlier episodes before
+// the intro, so it is found as several short matches between the pre-intro
+// audio and the body of the previous episode.
+//
+// Results are published as skip markers (skip_markers) that the skip service
+// and owl_api return to Owl for "Skip Intro", "Skip Recap" and "Next Episode".
+package markers
+
+import (
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// ItemSeconds is the audio duration of one Chromaprint fingerprint point.
+const ItemSeconds = 0.1238
+
+// Marker kinds.
+const (
+	KindIntro   = "intro"
+	KindCredits = "credits"
+	KindRecap   = "recap"
+)
+
+// Marker is a detected range within one episode.
+type Marker struct {
+	Kind       string  `json:"kind"`
+	Start      float64 `json:"start"` // seconds
+	End        float64 `json:"end"`
+	Confidence float64 `json:"confidence"` // share of points in the range that matched
+}
+
+// Episode is one episode's fingerprint. Number orders episodes in the season
+// (episode number, or air order for recordings); episodes with the same
+// Number are the same airing and are never compared.
+type Episode struct {
+	ContentID string
+	Number    int
+	Points    []uint32
+}
+
+// Params tunes detection.
+type Params struct {
+	IntroWindow   time.Duration // search the first … of each episode for the intro
+	CreditsWindow time.Duration // search the last … for the credits
+	MinIntro      time.Duration
+	MaxIntro      time.Duration
+	MinCredits    time.Duration
+	MaxCredits    time.Duration
+	MinRecap      time.Duration // total matched recap clips
+	MaxBitDiff    int           // Hamming distance at which two points match
+	MaxGap        time.Duration // unmatched stretch tolerated inside a range
+	MaxPairs      int           // other episodes tried per episode and kind
+}
+
+// DefaultParams are tuned for typical 20–60 minute episodes.
+var DefaultParams = Params{
+	IntroWindow:   10 * time.Minute,
+	CreditsWindow: 6 * time.Minute,
+	MinIntro:      15 * time.Second,
+	MaxIntro:      150 * time.Second,
+	MinCredits:    15 * time.Second,
+	MaxCredits:    6 * time.Minute,
+	MinRecap:      8 * time.Second,
+	MaxBitDiff:    6,
+	MaxGap:        3500 * time.Millisecond,
+	MaxPairs:      4,
+}
+
+func items(d time.Duration) int { return int(d.Seconds() / ItemSeconds) }
+
+func seconds(i int) float64 { return float64(i) * ItemSeconds }
+
+// span is a matched range: a[aStart:aEnd] plays as b[aStart+shift:aEnd+shift].
+type span struct {
+	aStart, aEnd int
+	shift        int
+	matched      int
+}
+
+func (s span) len() int { return s.aEnd - s.aStart }
+
+func (s span) density() float64 {
+	if s.len() == 0 {
+		return 0
+	}
+	return float64(s.matched) / float64(s.len())
+}
+
+// candidateShifts returns the shifts (b index − a index) with the most exact
+// point matches between a[aLo:aHi] and b[bLo:bHi], best first.
+func candidateShifts(a []uint32, aLo, aHi int, b []uint32, bLo, bHi, limit int) []int {
+	index := make(map[uint32][]int, bHi-bLo)
+	for j := bLo; j < bHi; j++ {
+		index[b[j]] = append(index[b[j]], j)
+	}
+	votes := map[int]int{}
+	for i := aLo; i < aHi; i++ {
+		for _, j := range index[a[i]] {
+			votes[j-i]++
+		}
+	}
+	shifts := make([]int, 0, len(votes))
+	for s, v := range votes {
+		if v >= 2 {
+			shifts = append(shifts, s)
+		}
+	}
+	sort.Slice(shifts, func(x, y int) bool {
+		if votes[shifts[x]] != votes[shifts[y]] {
+			return votes[shifts[x]] > votes[shifts[y]]
+		}
+		return shifts[x] < shifts[y]
+	})
+	if len(shifts) > limit {
+		shifts = shifts[:limit]
+	}
+	return shifts
+}
+
+// runs returns the ranges of a[aLo:aHi] that match b[bLo:bHi] at shift,
+// joining matches separated by at most maxGap unmatched points.
+func runs(a []uint32, aLo, aHi int, b []uint32, bLo, bHi, shift, maxBits, maxGap int) []span {
+	lo, hi := aLo, aHi
+	if bLo-shift > lo {
+		lo = bLo - shift
+	}
+	if bHi-shift < hi {
+		hi = bHi - shift
+	}
+	var out []span
+	cur := span{aStart: -1, shift: shift}
+	last := -1
+	for i := lo; i < hi; i++ {
+		if bits.OnesCount32(a[i]^b[i+shift]) > maxBits {
+			continue
+		}
+		if cur.aStart >= 0 && i-last-1 > maxGap {
+			out = append(out, cur)
+			cur = span{aStart: -1, shift: shift}
+		}
+		if cur.aStart < 0 {
+			cur.aStart = i
+		}
+		cur.aEnd = i + 1
+		cur.matched++
+		last = i
+	}
+	if cur.aStart >= 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// longestShared finds the longest range of a[aLo:aHi] also found in
+// b[bLo:bHi] whose length is within [minLen, maxLen] points.
+func longestShared(a []uint32, aLo, aHi int, b []uint32, bLo, bHi int, minLen, maxLen int, p Params) (span, bool) {
+	var best span
+	found := false
+	for _, shift := range candidateShifts(a, aLo, aHi, b, bLo, bHi, 10) {
+		for _, r := range runs(a, aLo, aHi, b, bLo, bHi, shift, p.MaxBitDiff, items(p.MaxGap)) {
+			if r.len() < minLen || r.len() > maxLen {
+				continue
+			}
+			if !found || r.len() > best.len() {
+				best, found = r, true
+			}
+		}
+	}
+	return best, found
+}
+
+// recapClips finds short clips of a[aLo:aHi] replayed from b[bLo:bHi] and
+// returns the covered range of a and the total clip length.
+func recapClips(a []uint32, aLo, aHi int, b []uint32, bLo, bHi int, p Params) (start, end, total int, ok bool) {
+	minClip := items(2 * time.Second)
+	covered := make([]bool, aHi-aLo)
+	for _, shift := range candidateShifts(a, aLo, aHi, b, bLo, bHi, 40) {
+		for _, r := range runs(a, aLo, aHi, b, bLo, bHi, shift, p.MaxBitDiff, items(time.Second)) {
+			if r.len() < minClip {
+				continue
+			}
+			for i := r.aStart; i < r.aEnd; i++ {
+				covered[i-aLo] = true
+			}
+		}
+	}
+	start, end = -1, -1
+	for i, c := range covered {
+		if !c {
+			continue
+		}
+		if start < 0 {
+			start = i + aLo
+		}
+		end = i + aLo + 1
+		total++
+	}
+	return start, end, total, start >= 0 && total >= items(p.MinRecap)
+}
+
+// window bounds for an episode of n points.
+func (p Params) introRange(n int) (int, int) {
+	hi := items(p.IntroWindow)
+	if hi > n/2 {
+		hi = n / 2
+	}
+	return 0, hi
+}
+
+func (p Params) creditsRange(n int) (int, int) {
+	lo := n - items(p.CreditsWindow)
+	if lo < n/2 {
+		lo = n / 2
+	}
+	return lo, n
+}
+
+// Detect finds markers for the targets among eps. Each target is compared
+// with up to MaxPairs other episodes, nearest episode number first.
+//
+// Because a shared range is found on both sides of a pair, the result also
+// carries markers for the non-target episodes that were compared; callers
+// should only use those where the episode has no marker of that kind yet.
+func Detect(eps []Episode, targets map[string]bool, p Params) map[string][]Marker {
+	sorted := append([]Episode(nil), eps...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+
+	out := map[string][]Marker{}
+	have := map[string]map[string]bool{}
+	add := func(id string, m Marker) {
+		if have[id] == nil {
+			have[id] = map[string]bool{}
+		}
+		if have[id][m.Kind] {
+			return
+		}
+		have[id][m.Kind] = true
+		out[id] = append(out[id], m)
+	}
+
+	for ti, t := range sorted {
+		if !targets[t.ContentID] || len(t.Points) == 0 {
+			continue
+		}
+		others := nearest(sorted, ti)
+
+		for _, kind := range []string{KindIntro, KindCredits} {
+			if have[t.ContentID][kind] {
+				continue
+			}
+			rangeOf, minD, maxD := p.introRange, p.MinIntro, p.MaxIntro
+			if kind == KindCredits {
+				rangeOf, minD, maxD = p.creditsRange, p.MinCredits, p.MaxCredits
+			}
+			tLo, tHi := rangeOf(len(t.Points))
+			for n, oi := range others {
+				if n >= p.MaxPairs {
+					break
+				}
+				o := sorted[oi]
+				oLo, oHi := rangeOf(len(o.Points))
+				s, ok := longestShared(t.Points, tLo, tHi, o.Points, oLo, oHi, items(minD), items(maxD), p)
+				if !ok {
+					continue
+				}
+				conf := s.density()
+				add(t.ContentID, Marker{Kind: kind, Start: seconds(s.aStart), End: seconds(s.aEnd), Confidence: conf})
+				add(o.ContentID, Marker{Kind: kind, Start: seconds(s.aStart + s.shift), End: seconds(s.aEnd + s.shift), Confidence: conf})
+				break
+			}
+		}
+
+		// Recap: clips of the previous episode played before this one's intro.
+		// The intro may have been found from the other side of an earlier pair.
+		intro, ok := findMarker(out[t.ContentID], KindIntro)
+		if !ok || intro.Start < p.MinRecap.Seconds() {
+			continue
+		}
+		introStart := int(intro.Start / ItemSeconds)
+		prev := ti - 1
+		for prev >= 0 && sorted[prev].Number == t.Number {
+			prev--
+		}
+		if prev < 0 {
+			continue
+		}
+		pp := sorted[prev].Points
+		bLo, bHi := 0, len(pp)
+		if m, ok := findMarker(out[sorted[prev].ContentID], KindIntro); ok {
+			bLo = int(m.End / ItemSeconds)
+		}
+		if m, ok := findMarker(out[sorted[prev].ContentID], KindCredits); ok {
+			bHi = int(m.Start / ItemSeconds)
+		}
+		if bLo >= bHi {
+			continue
+		}
+		start, end, total, ok := recapClips(t.Points, 0, introStart, pp, bLo, bHi, p)
+		if !ok {
+			continue
+		}
+		// Recaps run from the top of the episode up to the intro; snap small
+		// gaps at either end to those boundaries.
+		if seconds(start) < 5 {
+			start = 0
+		}
+		if seconds(introStart-end) < 5 {
+			end = introStart
+		}
+		add(t.ContentID, Marker{Kind: KindRecap, Start: seconds(start), End: seconds(end),
+			Confidence: float64(total) / float64(end-start)})
+	}
+	return out
+}
+
+// nearest returns the fingerprinted neighbours of episode i, nearest
+// episode number first.
+func nearest(sorted []Episode, i int) []int {
+	var idx []int
+	for _, j := range neighbours(sorted, i) {
+		if len(sorted[j].Points) > 0 {
+			idx = append(idx, j)
+		}
+	}
+	return idx
+}
+
+// neighbours returns the indexes of the episodes with a different number
+// than episode i, nearest number first, whether fingerprinted or not.
+func neighbours(sorted []Episode, i int) []int {
+	var idx []int
+	for j := range sorted {
+		if j != i && sorted[j].Number != sorted[i].Number {
+			idx = append(idx, j)
+		}
+	}
+	dist := func(j int) int {
+		d := sorted[j].Number - sorted[i].Number
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	sort.SliceStable(idx, func(x, y int) bool { return dist(idx[x]) < dist(idx[y]) })
+	return idx
+}
+
+func findMarker(ms []Marker, kind string) (Marker, bool) {
+	for _, m := range ms {
+		if m.Kind == kind {
+			return m, true
+		}
+	}
+	return Marker{}, false
+}
@@ -0,0 +1,140 @@
+package markers
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// synth builds fingerprints for a season: random audio per episode with the
+// shared blocks copied in at the given offsets. Copies get a flipped bit on
+// every third point, as re-encoded audio never fingerprints identically.
+type synth struct {
+	rng *rand.Rand
+}
+
+func (s synth) random(d time.Duration) []uint32 {
+	p := make([]uint32, items(d))
+	for i := range p {
+		p[i] = s.rng.Uint32()
+	}
+	return p
+}
+
+func (s synth) paste(dst []uint32, at time.Duration, block []uint32) {
+	off := items(at)
+	for i, v := range block {
+		if i%3 == 0 {
+			v ^= 1 << uint(s.rng.Intn(32))
+		}
+		dst[off+i] = v
+	}
+}
+
+func near(got, want float64) bool { return math.Abs(got-want) <= 1 }
+
+func checkMarker(t *testing.T, ms []Marker, kind string, start, end float64) {
+	t.Helper()
+	m, ok := findMarker(ms, kind)
+	if !ok {
+		t.Errorf("no %s marker in %+v", kind, ms)
+		return
+	}
+	if !near(m.Start, start) || !near(m.End, end) {
+		t.Errorf("%s = %.1f–%.1f, want %.1f–%.1f", kind, m.Start, m.End, start, end)
+	}
+	if m.Confidence < 0.9 {
+		t.Errorf("%s confidence = %.2f", kind, m.Confidence)
+	}
+}
+
+func TestDetectIntroCreditsRecap(t *testing.T) {
+	s := synth{rng: rand.New(rand.NewSource(1))}
+	const length = 22 * time.Minute
+	intro := s.random(60 * time.Second)
+	credits := s.random(50 * time.Second)
+	creditsAt := length - 50*time.Second
+
+	// Episode 1 opens cold for 40s; episode 2 opens with a 21s recap of
+	// three clips from episode 1; episode 3 goes straight to the intro.
+	introAt := []time.Duration{40 * time.Second, 21 * time.Second, 5 * time.Second}
+	eps := make([]Episode, 3)
+	for i := range eps {
+		pts := s.random(length)
+		s.paste(pts, introAt[i], intro)
+		s.paste(pts, creditsAt, credits)
+		eps[i] = Episode{ContentID: string(rune('a' + i)), Number: i + 1, Points: pts}
+	}
+	for i, from := range []time.Duration{5 * time.Minute, 9 * time.Minute, 17 * time.Minute} {
+		clip := eps[0].Points[items(from) : items(from)+items(7*time.Second)]
+		s.paste(eps[1].Points, time.Duration(i)*7*time.Second, clip)
+	}
+
+	got := Detect(eps, map[string]bool{"a": true, "b": true, "c": true}, DefaultParams)
+	for i, e := range eps {
+		ms := got[e.ContentID]
+		start := introAt[i].Seconds()
+		checkMarker(t, ms, KindIntro, start, start+60)
+		checkMarker(t, ms, KindCredits, creditsAt.Seconds(), length.Seconds())
+	}
+	checkMarker(t, got["b"], KindRecap, 0, 21)
+	for _, id := range []string{"a", "c"} {
+		if m, ok := findMarker(got[id], KindRecap); ok {
+			t.Errorf("episode %s: unexpected recap %+v", id, m)
+		}
+	}
+}
+
+func TestDetectOnlyTargets(t *testing.T) {
+	s := synth{rng: rand.New(rand.NewSource(2))}
+	intro := s.random(30 * time.Second)
+	eps := make([]Episode, 3)
+	for i := range eps {
+		pts := s.random(20 * time.Minute)
+		s.paste(pts, 10*time.Second, intro)
+		eps[i] = Episode{ContentID: string(rune('a' + i)), Number: i + 1, Points: pts}
+	}
+	got := Detect(eps, map[string]bool{"c": true}, DefaultParams)
+	if _, ok := got["c"]; !ok {
+		t.Fatal("no markers for the target")
+	}
+	// The target's pair partner gets markers too; the far episode does not.
+	if _, ok := got["b"]; !ok {
+		t.Error("no markers for the compared episode")
+	}
+	if ms, ok := got["a"]; ok {
+		t.Errorf("markers for an episode that was not compared: %+v", ms)
+	}
+}
+
+func TestDetectSkipsSameAiring(t *testing.T) {
+	s := synth{rng: rand.New(rand.NewSource(3))}
+	pts := s.random(20 * time.Minute)
+	eps := []Episode{
+		{ContentID: "a", Number: 1, Points: pts},
+		{ContentID: "b", Number: 1, Points: append([]uint32(nil), pts...)},
+	}
+	if got := Detect(eps, map[string]bool{"a": true, "b": true}, DefaultParams); len(got) != 0 {
+		t.Errorf("two recordings of one airing produced markers: %+v", got)
+	}
+}
+
+func TestParseFpcalcJSON(t *testing.T) {
+	pts, err := parseFpcalcJSON([]byte(`{"duration": 12.5, "fingerprint": [3635932163, -1, 7]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{3635932163, 0xFFFFFFFF, 7}
+	if len(pts) != len(want) {
+		t.Fatalf("got %v, want %v", pts, want)
+	}
+	for i := range want {
+		if pts[i] != want[i] {
+			t.Errorf("point %d = %d, want %d", i, pts[i], want[i])
+		}
+	}
+	if _, err := parseFpcalcJSON([]byte(`{"duration": 0, "fingerprint": []}`)); err == nil {
+		t.Error("empty fingerprint accepted")
+	}
+}
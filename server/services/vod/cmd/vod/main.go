@@ -28,7 +28,16 @@
 //   GET  /stream/vod/:id/stream.m3u8                        — signed HLS stream redirect
 //   GET  /vod/progress/:type/:id                            — get watch progress
 //   PUT  /vod/progress/:type/:id                            — upsert watch progress
+//                                                             (type: movie | episode | music)
+//                                                             (optional "state": playing|paused|stopped)
 //   GET  /vod/continue-watching                             — incomplete items ordered by recency
+//   POST /vod/playback/:id                                  — negotiate direct play / remux / transcode
+//                                                             (see playback.go, transcode.go)
+//
+// Watch progress is kept per profile: the session's profile, or the primary
+// profile for sessions that predate profile selection. Each movie and episode
+// progress save is also forwarded to the scrobble service (SCROBBLE_URL) for
+// the profile's linked Trakt / Simkl accounts.
 //
 // Artwork:
 //   GET  /artwork/:filename                                 — serve uploaded artwork
@@ -38,12 +47,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -122,8 +133,10 @@ func validateAdminToken(ctx context.Context, db *sql.DB, r *http.Request) (bool,
 	return isSuperowner, err
 }
 
-// validateSessionToken looks up an owl_sessions row.
-func validateSessionToken(ctx context.Context, db *sql.DB, r *http.Request) (string, error) {
+// validateSessionToken looks up an owl_sessions row, returning the subscriber
+// and the profile watch progress is recorded against ("" if the subscriber
+// has no profiles).
+func validateSessionToken(ctx context.Context, db *sql.DB, r *http.Request) (string, string, error) {
 	tok := r.Header.Get("X-Session-Token")
 	if tok == "" {
 		auth := r.Header.Get("Authorization")
@@ -132,16 +145,20 @@ func validateSessionToken(ctx context.Context, db *sql.DB, r *http.Request) (str
 		}
 	}
 	if tok == "" {
-		return "", nil
-	}
-	var subID string
-	err := db.QueryRowContext(ctx,
-		`SELECT subscriber_id FROM owl_sessions WHERE session_token = $1 AND expires_at > NOW()`,
-		tok).Scan(&subID)
+		return "", "", nil
+	}
+	var subID, profileID string
+	err := db.QueryRowContext(ctx, `
+		SELECT os.subscriber_id, COALESCE(os.profile_id::text, sp.id::text, '')
+		FROM owl_sessions os
+		LEFT JOIN subscriber_profiles sp ON sp.subscriber_id = os.subscriber_id
+		    AND sp.is_primary = TRUE AND sp.is_active = TRUE
+		WHERE os.session_token = $1 AND os.expires_at > NOW()`,
+		tok).Scan(&subID, &profileID)
 	if err == sql.ErrNoRows {
-		return "", nil
+		return "", "", nil
 	}
-	return subID, err
+	return subID, profileID, err
 }
 
 // ---- signed stream URL ------------------------------------------------------
@@ -164,12 +181,31 @@ func signedStreamURL(vodID string) (string, time.Time) {
 type server struct {
 	db        *sql.DB
 	artworkDir string
+	scrobbleURL string // scrobble service base URL; "" = scrobbling disabled
+	probes     *prober
+	transcodes *transcodeManager // nil = playback negotiation disabled
 }
 
 func newServer(db *sql.DB) *server {
 	artDir := getEnv("ARTWORK_DIR", "/var/roost/artwork")
 	_ = os.MkdirAll(artDir, 0o755)
-	return &server{db: db, artworkDir: artDir}
+	transcodeDir := getEnv("VOD_TRANSCODE_DIR", "/var/roost/transcode")
+	_ = os.MkdirAll(transcodeDir, 0o755)
+	maxTranscodes, err := strconv.Atoi(getEnv("VOD_MAX_TRANSCODES", "4"))
+	if err != nil {
+		maxTranscodes = 4
+	}
+	idle, err := time.ParseDuration(getEnv("VOD_TRANSCODE_IDLE", "2m"))
+	if err != nil {
+		idle = 2 * time.Minute
+	}
+	return &server{
+		db:          db,
+		artworkDir:  artDir,
+		scrobbleURL: getEnv("SCROBBLE_URL", ""),
+		probes:      newProber(getEnv("FFPROBE_PATH", "ffprobe")),
+		transcodes:  newTranscodeManager(getEnv("FFMPEG_PATH", "ffmpeg"), transcodeDir, maxTranscodes, idle),
+	}
 }
 
 func (s *server) routes() http.Handler {
@@ -212,6 +248,9 @@ func (s *server) routes() http.Handler {
 	mux.HandleFunc("GET /vod/progress/",  s.sessionRequired(s.handleGetProgress))
 	mux.HandleFunc("PUT /vod/progress/",  s.sessionRequired(s.handleUpsertProgress))
 	mux.HandleFunc("GET /vod/continue-watching", s.sessionRequired(s.handleContinueWatching))
+	mux.HandleFunc("POST /vod/playback/{id}", s.sessionRequired(s.handlePlayback))
+	mux.HandleFunc("GET /vod/playback/sessions/{sid}/{file}", s.handlePlaybackFile)
+	mux.HandleFunc("DELETE /vod/playback/sessions/{sid}", s.sessionRequired(s.handleStopPlayback))
 
 	return mux
 }
@@ -234,11 +273,14 @@ func (s *server) adminRequired(next http.HandlerFunc) http.HandlerFunc {
 }
 
 type ctxKey string
-const ctxSubscriberID ctxKey = "subscriber_id"
+const (
+	ctxSubscriberID ctxKey = "subscriber_id"
+	ctxProfileID    ctxKey = "profile_id"
+)
 
 func (s *server) sessionRequired(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		subID, err := validateSessionToken(r.Context(), s.db, r)
+		subID, profileID, err := validateSessionToken(r.Context(), s.db, r)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, "db_error", "Session check failed")
 			return
@@ -248,6 +290,7 @@ func (s *server) sessionRequired(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 		ctx := context.WithValue(r.Context(), ctxSubscriberID, subID)
+		ctx = context.WithValue(ctx, ctxProfileID, profileID)
 		next(w, r.WithContext(ctx))
 	}
 }
@@ -1184,11 +1227,11 @@ func (s *server) handleCatalogItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	subID, _ := r.Context().Value(ctxSubscriberID).(string)
+	profileID, _ := r.Context().Value(ctxProfileID).(string)
 
 	if vodType == "series" {
 		// Return series with seasons/episodes + per-episode watch progress
-		s.getSeriesForSubscriber(w, r, id, subID)
+		s.getSeriesForProfile(w, r, id, profileID)
 		return
 	}
 
@@ -1227,8 +1270,8 @@ func (s *server) handleCatalogItem(w http.ResponseWriter, r *http.Request) {
 	var completed bool
 	_ = s.db.QueryRowContext(r.Context(), `
 		SELECT position_seconds, completed FROM watch_progress
-		WHERE subscriber_id = $1 AND content_type = 'movie' AND content_id = $2`,
-		subID, id).Scan(&posSeconds, &completed)
+		WHERE profile_id::text = $1 AND content_type = 'movie' AND content_id = $2`,
+		profileID, id).Scan(&posSeconds, &completed)
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"content":          item,
@@ -1239,7 +1282,7 @@ func (s *server) handleCatalogItem(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (s *server) getSeriesForSubscriber(w http.ResponseWriter, r *http.Request, id, subID string) {
+func (s *server) getSeriesForProfile(w http.ResponseWriter, r *http.Request, id, profileID string) {
 	var catalog vodItem
 	var desc, genre, rat, poster, backdrop, trailer sql.NullString
 	var yr sql.NullInt64
@@ -1306,9 +1349,9 @@ func (s *server) getSeriesForSubscriber(w http.ResponseWriter, r *http.Request,
 			       COALESCE(wp.position_seconds, 0), COALESCE(wp.completed, false)
 			FROM vod_episodes e
 			LEFT JOIN watch_progress wp ON wp.content_type = 'episode'
-			    AND wp.content_id = e.id AND wp.subscriber_id = $2
+			    AND wp.content_id = e.id AND wp.profile_id::text = $2
 			WHERE e.series_id = $1
-			ORDER BY e.episode_number ASC`, se.ID, subID)
+			ORDER BY e.episode_number ASC`, se.ID, profileID)
 		if err == nil {
 			defer epRows.Close()
 			for epRows.Next() {
@@ -1351,18 +1394,10 @@ func (s *server) handleStreamRedirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify content exists and is active
-	var sourceURL string
-	err := s.db.QueryRowContext(r.Context(),
-		`SELECT source_url FROM vod_catalog WHERE id = $1 AND is_active = true`, vodID).Scan(&sourceURL)
-	if err == sql.ErrNoRows {
-		// Try episode ID
-		err2 := s.db.QueryRowContext(r.Context(),
-			`SELECT source_url FROM vod_episodes WHERE id = $1`, vodID).Scan(&sourceURL)
-		if err2 != nil {
-			writeError(w, http.StatusNotFound, "not_found", "Content not found")
-			return
-		}
+	sourceURL, err := s.sourceURL(r.Context(), vodID)
+	if err == errContentNotFound {
+		writeError(w, http.StatusNotFound, "not_found", "Content not found")
+		return
 	} else if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
@@ -1373,8 +1408,31 @@ func (s *server) handleStreamRedirect(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, sourceURL, http.StatusTemporaryRedirect)
 }
 
+var errContentNotFound = errors.New("content not found")
+
+// sourceURL returns the source of an active title or an episode.
+func (s *server) sourceURL(ctx context.Context, vodID string) (string, error) {
+	var sourceURL string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT source_url FROM vod_catalog WHERE id = $1 AND is_active = true`, vodID).Scan(&sourceURL)
+	if err == sql.ErrNoRows {
+		// Try episode ID
+		err = s.db.QueryRowContext(ctx,
+			`SELECT source_url FROM vod_episodes WHERE id = $1`, vodID).Scan(&sourceURL)
+		if err != nil {
+			return "", errContentNotFound
+		}
+	}
+	return sourceURL, err
+}
+
 // ---- subscriber: watch progress ---------------------------------------------
 
+// progressTypes are the watch_progress content types: vod_catalog movies,
+// vod_episodes, and music albums (music_albums.id), whose plays feed the
+// recommendations service.
+var progressTypes = map[string]bool{"movie": true, "episode": true, "music": true}
+
 func (s *server) handleGetProgress(w http.ResponseWriter, r *http.Request) {
 	// /vod/progress/{type}/{id}
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
@@ -1384,10 +1442,10 @@ func (s *server) handleGetProgress(w http.ResponseWriter, r *http.Request) {
 	}
 	contentType := parts[2]
 	contentID := parts[3]
-	subID, _ := r.Context().Value(ctxSubscriberID).(string)
+	profileID, _ := r.Context().Value(ctxProfileID).(string)
 
-	if contentType != "movie" && contentType != "episode" {
-		writeError(w, http.StatusBadRequest, "bad_request", "type must be 'movie' or 'episode'")
+	if !progressTypes[contentType] {
+		writeError(w, http.StatusBadRequest, "bad_request", "type must be 'movie', 'episode' or 'music'")
 		return
 	}
 
@@ -1397,8 +1455,8 @@ func (s *server) handleGetProgress(w http.ResponseWriter, r *http.Request) {
 	err := s.db.QueryRowContext(r.Context(), `
 		SELECT position_seconds, duration_seconds, completed, last_watched_at
 		FROM watch_progress
-		WHERE subscriber_id = $1 AND content_type = $2 AND content_id = $3`,
-		subID, contentType, contentID).Scan(&pos, &dur, &completed, &lastWatched)
+		WHERE profile_id::text = $1 AND content_type = $2 AND content_id = $3`,
+		profileID, contentType, contentID).Scan(&pos, &dur, &completed, &lastWatched)
 	if err == sql.ErrNoRows {
 		writeJSON(w, http.StatusOK, map[string]interface{}{
 			"position_seconds": 0, "duration_seconds": 0,
@@ -1428,15 +1486,21 @@ func (s *server) handleUpsertProgress(w http.ResponseWriter, r *http.Request) {
 	contentType := parts[2]
 	contentID := parts[3]
 	subID, _ := r.Context().Value(ctxSubscriberID).(string)
+	profileID, _ := r.Context().Value(ctxProfileID).(string)
 
-	if contentType != "movie" && contentType != "episode" {
-		writeError(w, http.StatusBadRequest, "bad_request", "type must be 'movie' or 'episode'")
+	if !progressTypes[contentType] {
+		writeError(w, http.StatusBadRequest, "bad_request", "type must be 'movie', 'episode' or 'music'")
+		return
+	}
+	if profileID == "" {
+		writeError(w, http.StatusConflict, "no_profile", "Subscriber has no active profile to record progress against")
 		return
 	}
 
 	var input struct {
-		PositionSeconds int `json:"position_seconds"`
-		DurationSeconds int `json:"duration_seconds"`
+		PositionSeconds int    `json:"position_seconds"`
+		DurationSeconds int    `json:"duration_seconds"`
+		State           string `json:"state"` // playing (default) | paused | stopped
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		writeError(w, http.StatusBadRequest, "bad_request", "Invalid JSON")
@@ -1446,35 +1510,83 @@ func (s *server) handleUpsertProgress(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "validation_error", "duration_seconds required")
 		return
 	}
+	switch input.State {
+	case "":
+		input.State = "playing"
+	case "playing", "paused", "stopped":
+	default:
+		writeError(w, http.StatusBadRequest, "validation_error", "state must be playing, paused or stopped")
+		return
+	}
 	completed := input.PositionSeconds > 0 &&
 		float64(input.PositionSeconds)/float64(input.DurationSeconds) >= 0.9
 
 	_, err := s.db.ExecContext(r.Context(), `
 		INSERT INTO watch_progress
-			(subscriber_id, content_type, content_id, position_seconds, duration_seconds,
-			 completed, last_watched_at)
-		VALUES ($1, $2, $3, $4, $5, $6, NOW())
-		ON CONFLICT (subscriber_id, content_type, content_id)
+			(subscriber_id, profile_id, content_type, content_id, position_seconds,
+			 duration_seconds, completed, last_watched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (profile_id, content_type, content_id)
 		DO UPDATE SET
 			position_seconds = EXCLUDED.position_seconds,
 			duration_seconds = EXCLUDED.duration_seconds,
 			completed        = EXCLUDED.completed,
 			last_watched_at  = NOW()`,
-		subID, contentType, contentID,
+		subID, profileID, contentType, contentID,
 		input.PositionSeconds, input.DurationSeconds, completed,
 	)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
 	}
+	if contentType != "music" { // Trakt and Simkl don't track music
+		s.notifyScrobble(map[string]interface{}{
+			"subscriber_id":    subID,
+			"profile_id":       profileID,
+			"content_type":     contentType,
+			"content_id":       contentID,
+			"position_seconds": input.PositionSeconds,
+			"duration_seconds": input.DurationSeconds,
+			"state":            input.State,
+		})
+	}
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"position_seconds": input.PositionSeconds,
 		"completed":        completed,
 	})
 }
 
+// notifyScrobble forwards a progress report to the scrobble service, which
+// scrobbles it to the profile's linked Trakt / Simkl accounts. It returns
+// immediately; a slow or unavailable scrobbler never delays the save.
+func (s *server) notifyScrobble(ev map[string]interface{}) {
+	if s.scrobbleURL == "" {
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			s.scrobbleURL+"/internal/scrobble/events", bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Printf("[vod] scrobble notify: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
 func (s *server) handleContinueWatching(w http.ResponseWriter, r *http.Request) {
-	subID, _ := r.Context().Value(ctxSubscriberID).(string)
+	profileID, _ := r.Context().Value(ctxProfileID).(string)
 
 	rows, err := s.db.QueryContext(r.Context(), `
 		SELECT wp.content_type, wp.content_id, wp.position_seconds, wp.duration_seconds,
@@ -1485,11 +1597,11 @@ func (s *server) handleContinueWatching(w http.ResponseWriter, r *http.Request)
 		FROM watch_progress wp
 		LEFT JOIN vod_catalog c ON wp.content_type = 'movie' AND c.id = wp.content_id
 		LEFT JOIN vod_episodes e ON wp.content_type = 'episode' AND e.id = wp.content_id
-		WHERE wp.subscriber_id = $1
+		WHERE wp.profile_id::text = $1
 		  AND wp.completed = false
 		  AND (c.is_active = true OR e.id IS NOT NULL)
 		ORDER BY wp.last_watched_at DESC
-		LIMIT 20`, subID)
+		LIMIT 20`, profileID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
 		return
@@ -1560,6 +1672,7 @@ func main() {
 	defer db.Close()
 
 	srv := newServer(db)
+	go srv.transcodes.Run(context.Background())
 	port := getEnv("VOD_PORT", "8097")
 	addr := ":" + port
 
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -171,6 +172,43 @@ func TestSubscriberRoutesRequireSession(t *testing.T) {
 	}
 }
 
+// ---- progress is recorded per profile ---------------------------------------
+
+func TestUpsertProgressRequiresProfile(t *testing.T) {
+	srv := &server{}
+	req := httptest.NewRequest("PUT", "/vod/progress/movie/abc",
+		bytes.NewBufferString(`{"position_seconds":60,"duration_seconds":600}`))
+	ctx := context.WithValue(req.Context(), ctxSubscriberID, "sub-1")
+	ctx = context.WithValue(ctx, ctxProfileID, "")
+	rec := httptest.NewRecorder()
+	srv.handleUpsertProgress(rec, req.WithContext(ctx))
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 without a profile, got %d", rec.Code)
+	}
+}
+
+// Music plays are recorded like movies and episodes; other types are refused
+// before anything else is checked.
+func TestUpsertProgressContentTypes(t *testing.T) {
+	srv := &server{}
+	for typ, want := range map[string]int{
+		"music": http.StatusConflict, // accepted; stops at the missing profile
+		"movie": http.StatusConflict,
+		"game":  http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest("PUT", "/vod/progress/"+typ+"/abc",
+			bytes.NewBufferString(`{"position_seconds":60,"duration_seconds":600}`))
+		ctx := context.WithValue(req.Context(), ctxSubscriberID, "sub-1")
+		ctx = context.WithValue(ctx, ctxProfileID, "")
+		rec := httptest.NewRecorder()
+		srv.handleUpsertProgress(rec, req.WithContext(ctx))
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", typ, rec.Code, want)
+		}
+	}
+}
+
 // ---- watch progress calculation --------------------------------------------
 
 func TestCompletedCalculation(t *testing.T) {
@@ -0,0 +1,366 @@
+// playback.go — Playback negotiation: direct play, remux or transcode.
+//
+// A client posts what it can decode; Roost probes the source with ffprobe
+// and picks the cheapest way to get it playing:
+//
+//   - direct_play: container, codecs, bitrate and resolution all fit. The
+//     client gets the signed stream URL, as the catalog returns it.
+//   - remux: codecs fit but the container does not (MKV, AVI). Streams are
+//     copied into HLS.
+//   - audio_transcode: video fits, audio does not (DTS, TrueHD). Video is
+//     copied, audio encoded to stereo AAC.
+//   - transcode: video codec, bitrate or resolution does not fit. H.264/AAC,
+//     scaled and capped to the client's limits.
+//
+// Anything but direct_play runs in a transcode session (transcode.go).
+//
+// Routes:
+//
+//	POST   /vod/playback/:id                        — negotiate (session token)
+//	GET    /vod/playback/sessions/:sid/index.m3u8   — session playlist
+//	GET    /vod/playback/sessions/:sid/seg_N.ts     — session segment
+//	DELETE /vod/playback/sessions/:sid              — stop a session (session token)
+//
+// Session playlists and segments are fetched by players that cannot attach
+// the session token; the random session ID in the URL is the credential, as
+// the signature is for signed stream URLs.
+//
+// The ffprobe wrapper in the vod package is not importable from here (that
+// package is its own build unit with the ingest pipeline), so the few fields
+// the decision needs are probed locally.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Playback methods, cheapest first.
+const (
+	methodDirectPlay     = "direct_play"
+	methodRemux          = "remux"
+	methodAudioTranscode = "audio_transcode"
+	methodTranscode      = "transcode"
+)
+
+// clientCaps is what a client reports it can play.
+type clientCaps struct {
+	Containers   []string `json:"containers"`    // e.g. ["hls","mp4"]
+	VideoCodecs  []string `json:"video_codecs"`  // e.g. ["h264","hevc"]
+	AudioCodecs  []string `json:"audio_codecs"`  // e.g. ["aac","ac3","eac3"]
+	MaxBitrate   int64    `json:"max_bitrate"`   // bits/s; 0 = no limit
+	MaxHeight    int      `json:"max_height"`    // pixels; 0 = no limit
+	StartSeconds float64  `json:"start_seconds"` // resume position
+}
+
+// withDefaults fills unreported lists with what every Owl client plays.
+func (c clientCaps) withDefaults() clientCaps {
+	if len(c.Containers) == 0 {
+		c.Containers = []string{"hls", "mp4"}
+	}
+	if len(c.VideoCodecs) == 0 {
+		c.VideoCodecs = []string{"h264"}
+	}
+	if len(c.AudioCodecs) == 0 {
+		c.AudioCodecs = []string{"aac"}
+	}
+	return c
+}
+
+// mediaInfo is the part of an ffprobe result the decision needs.
+type mediaInfo struct {
+	Container  string  // normalised: mp4, mkv, webm, ts, hls, avi, …
+	VideoCodec string  // "" for audio-only
+	AudioCodec string  // "" for silent video
+	Height     int     // pixels
+	Bitrate    int64   // bits/s; 0 if unknown
+	Duration   float64 // seconds; 0 if unknown
+}
+
+// plan is a negotiated playback method and, for sessions, its output limits.
+type plan struct {
+	Method     string
+	Reasons    []string // why anything short of direct play was needed
+	MaxHeight  int
+	MaxBitrate int64
+}
+
+// decide picks the playback method for media on a client.
+func decide(m mediaInfo, c clientCaps) plan {
+	c = c.withDefaults()
+	p := plan{MaxHeight: c.MaxHeight, MaxBitrate: c.MaxBitrate}
+
+	videoOK := m.VideoCodec == "" || containsFold(c.VideoCodecs, m.VideoCodec)
+	audioOK := m.AudioCodec == "" || containsFold(c.AudioCodecs, m.AudioCodec)
+	if !videoOK {
+		p.Reasons = append(p.Reasons, "video codec "+m.VideoCodec+" not supported")
+	}
+	if c.MaxHeight > 0 && m.Height > c.MaxHeight {
+		videoOK = false
+		p.Reasons = append(p.Reasons, fmt.Sprintf("%dp exceeds %dp", m.Height, c.MaxHeight))
+	}
+	if c.MaxBitrate > 0 && m.Bitrate > c.MaxBitrate {
+		videoOK = false
+		p.Reasons = append(p.Reasons, fmt.Sprintf("bitrate %d exceeds %d", m.Bitrate, c.MaxBitrate))
+	}
+	if !audioOK {
+		p.Reasons = append(p.Reasons, "audio codec "+m.AudioCodec+" not supported")
+	}
+	containerOK := containsFold(c.Containers, m.Container)
+	if !containerOK {
+		p.Reasons = append(p.Reasons, "container "+m.Container+" not supported")
+	}
+
+	switch {
+	case !videoOK:
+		p.Method = methodTranscode
+	case !audioOK:
+		p.Method = methodAudioTranscode
+	case !containerOK:
+		p.Method = methodRemux
+	default:
+		p.Method = methodDirectPlay
+	}
+	return p
+}
+
+func containsFold(list []string, v string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// ---- probing ----------------------------------------------------------------
+
+// prober runs ffprobe and caches results per source for an hour.
+type prober struct {
+	ffprobePath string
+
+	mu    sync.Mutex
+	cache map[string]probeEntry
+}
+
+type probeEntry struct {
+	info mediaInfo
+	at   time.Time
+}
+
+func newProber(ffprobePath string) *prober {
+	return &prober{ffprobePath: ffprobePath, cache: make(map[string]probeEntry)}
+}
+
+func (p *prober) probe(ctx context.Context, source string) (mediaInfo, error) {
+	p.mu.Lock()
+	if e, ok := p.cache[source]; ok && time.Since(e.at) < time.Hour {
+		p.mu.Unlock()
+		return e.info, nil
+	}
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, p.ffprobePath,
+		"-v", "quiet", "-print_format", "json", "-show_streams", "-show_format", source,
+	).Output()
+	if err != nil {
+		return mediaInfo{}, fmt.Errorf("ffprobe: %w", err)
+	}
+	info, err := parseProbe(out)
+	if err != nil {
+		return mediaInfo{}, err
+	}
+
+	p.mu.Lock()
+	p.cache[source] = probeEntry{info: info, at: time.Now()}
+	p.mu.Unlock()
+	return info, nil
+}
+
+// parseProbe reads ffprobe -show_streams -show_format JSON.
+func parseProbe(data []byte) (mediaInfo, error) {
+	var out struct {
+		Streams []struct {
+			CodecName string `json:"codec_name"`
+			CodecType string `json:"codec_type"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return mediaInfo{}, fmt.Errorf("ffprobe parse: %w", err)
+	}
+	var m mediaInfo
+	for _, s := range out.Streams {
+		switch {
+		case s.CodecType == "video" && m.VideoCodec == "" && s.CodecName != "mjpeg" && s.CodecName != "png":
+			m.VideoCodec, m.Height = s.CodecName, s.Height // skip embedded cover art
+		case s.CodecType == "audio" && m.AudioCodec == "":
+			m.AudioCodec = s.CodecName
+		}
+	}
+	if m.VideoCodec == "" && m.AudioCodec == "" {
+		return mediaInfo{}, errors.New("ffprobe: no audio or video streams")
+	}
+	m.Container = containerName(out.Format.FormatName)
+	m.Duration, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	m.Bitrate, _ = strconv.ParseInt(out.Format.BitRate, 10, 64)
+	return m, nil
+}
+
+// containerName maps ffprobe's demuxer list to the names clients report.
+func containerName(formatName string) string {
+	switch {
+	case strings.HasPrefix(formatName, "mov,mp4"):
+		return "mp4"
+	case strings.HasPrefix(formatName, "matroska"):
+		return "mkv" // ffprobe reports MKV and WebM alike
+	case formatName == "mpegts":
+		return "ts"
+	case formatName == "hls" || formatName == "applehttp":
+		return "hls"
+	}
+	if i := strings.IndexByte(formatName, ','); i >= 0 {
+		return formatName[:i]
+	}
+	return formatName
+}
+
+// ---- handlers ---------------------------------------------------------------
+
+// POST /vod/playback/{id}
+func (s *server) handlePlayback(w http.ResponseWriter, r *http.Request) {
+	if s.transcodes == nil {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "Playback negotiation is not configured")
+		return
+	}
+	vodID := r.PathValue("id")
+	var caps clientCaps
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&caps); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_json", "Invalid capabilities")
+			return
+		}
+	}
+
+	source, err := s.sourceURL(r.Context(), vodID)
+	if err == errContentNotFound {
+		writeError(w, http.StatusNotFound, "not_found", "Content not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+
+	info, err := s.probes.probe(r.Context(), source)
+	if err != nil {
+		// Without a probe there is nothing to decide on; hand out the source
+		// as before negotiation existed.
+		s.writeDirectPlay(w, vodID, plan{Method: methodDirectPlay, Reasons: []string{"probe failed"}})
+		return
+	}
+	p := decide(info, caps)
+	if p.Method == methodDirectPlay {
+		s.writeDirectPlay(w, vodID, p)
+		return
+	}
+
+	subID, _ := r.Context().Value(ctxSubscriberID).(string)
+	sess, err := s.transcodes.Start(subID, vodID, source, info, p, caps.StartSeconds)
+	if err == errTooManyTranscodes {
+		writeError(w, http.StatusServiceUnavailable, "transcoder_busy", "No transcode slots free, try again shortly")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "transcode_failed", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"method":     p.Method,
+		"reasons":    p.Reasons,
+		"session_id": sess.ID,
+		"stream_url": "/vod/playback/sessions/" + sess.ID + "/index.m3u8",
+		"duration":   info.Duration,
+	})
+}
+
+func (s *server) writeDirectPlay(w http.ResponseWriter, vodID string, p plan) {
+	streamURL, expiresAt := signedStreamURL(vodID)
+	reasons := p.Reasons
+	if reasons == nil {
+		reasons = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"method":     methodDirectPlay,
+		"reasons":    reasons,
+		"stream_url": streamURL,
+		"expires_at": expiresAt.Format(time.RFC3339),
+	})
+}
+
+// GET /vod/playback/sessions/{sid}/{file}
+func (s *server) handlePlaybackFile(w http.ResponseWriter, r *http.Request) {
+	if s.transcodes == nil {
+		writeError(w, http.StatusNotFound, "not_found", "Session not found")
+		return
+	}
+	sess := s.transcodes.Get(r.PathValue("sid"))
+	if sess == nil {
+		writeError(w, http.StatusNotFound, "not_found", "Session not found")
+		return
+	}
+	file := r.PathValue("file")
+	if file == "index.m3u8" {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(sess.Playlist())
+		return
+	}
+	idx, ok := segmentIndex(file)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "No such file")
+		return
+	}
+	path, err := s.transcodes.Segment(r.Context(), sess, idx)
+	if err == errSegmentRange {
+		writeError(w, http.StatusNotFound, "not_found", "Segment out of range")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "segment_unavailable", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeFile(w, r, path)
+}
+
+// DELETE /vod/playback/sessions/{sid}
+func (s *server) handleStopPlayback(w http.ResponseWriter, r *http.Request) {
+	subID, _ := r.Context().Value(ctxSubscriberID).(string)
+	var sess *transcodeSession
+	if s.transcodes != nil {
+		sess = s.transcodes.Get(r.PathValue("sid"))
+	}
+	if sess == nil || sess.SubscriberID != subID {
+		writeError(w, http.StatusNotFound, "not_found", "Session not found")
+		return
+	}
+	s.transcodes.Stop(sess.ID)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "stopped"})
+}
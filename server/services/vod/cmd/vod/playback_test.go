@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ---- playback decision ------------------------------------------------------
+
+func TestDecide(t *testing.T) {
+	phone := clientCaps{
+		Containers:  []string{"hls", "mp4"},
+		VideoCodecs: []string{"h264", "hevc"},
+		AudioCodecs: []string{"aac", "ac3"},
+		MaxHeight:   1080,
+		MaxBitrate:  20_000_000,
+	}
+	cases := []struct {
+		name  string
+		media mediaInfo
+		caps  clientCaps
+		want  string
+	}{
+		{"mp4 h264 aac", mediaInfo{Container: "mp4", VideoCodec: "h264", AudioCodec: "aac", Height: 1080, Bitrate: 8_000_000}, phone, methodDirectPlay},
+		{"mkv h264 ac3", mediaInfo{Container: "mkv", VideoCodec: "h264", AudioCodec: "ac3", Height: 720}, phone, methodRemux},
+		{"mkv hevc dts", mediaInfo{Container: "mkv", VideoCodec: "hevc", AudioCodec: "dts", Height: 1080}, phone, methodAudioTranscode},
+		{"4k hevc", mediaInfo{Container: "mp4", VideoCodec: "hevc", AudioCodec: "aac", Height: 2160}, phone, methodTranscode},
+		{"over bitrate", mediaInfo{Container: "mp4", VideoCodec: "h264", AudioCodec: "aac", Height: 1080, Bitrate: 40_000_000}, phone, methodTranscode},
+		{"hevc on default caps", mediaInfo{Container: "mp4", VideoCodec: "hevc", AudioCodec: "aac"}, clientCaps{}, methodTranscode},
+		{"audio only", mediaInfo{Container: "mp4", AudioCodec: "aac"}, clientCaps{}, methodDirectPlay},
+	}
+	for _, tc := range cases {
+		p := decide(tc.media, tc.caps)
+		if p.Method != tc.want {
+			t.Errorf("%s: method = %s, want %s (reasons %v)", tc.name, p.Method, tc.want, p.Reasons)
+		}
+		if p.Method != methodDirectPlay && len(p.Reasons) == 0 {
+			t.Errorf("%s: no reasons for %s", tc.name, p.Method)
+		}
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	data := []byte(`{
+		"streams": [
+			{"codec_name": "mjpeg", "codec_type": "video", "height": 600},
+			{"codec_name": "hevc", "codec_type": "video", "height": 2160},
+			{"codec_name": "dts", "codec_type": "audio"},
+			{"codec_name": "subrip", "codec_type": "subtitle"}
+		],
+		"format": {"format_name": "matroska,webm", "duration": "5400.250000", "bit_rate": "25000000"}
+	}`)
+	m, err := parseProbe(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mediaInfo{Container: "mkv", VideoCodec: "hevc", AudioCodec: "dts", Height: 2160, Bitrate: 25_000_000, Duration: 5400.25}
+	if m != want {
+		t.Errorf("parseProbe = %+v, want %+v", m, want)
+	}
+	if _, err := parseProbe([]byte(`{"streams": [], "format": {}}`)); err == nil {
+		t.Error("no streams accepted")
+	}
+}
+
+// ---- transcode sessions -----------------------------------------------------
+
+func TestVODPlaylist(t *testing.T) {
+	pl := string(vodPlaylist(13))
+	if got := strings.Count(pl, "#EXTINF:"); got != 3 {
+		t.Fatalf("13s: %d segments, want 3\n%s", got, pl)
+	}
+	for _, want := range []string{"#EXTINF:6.000,\nseg_00000.ts", "#EXTINF:1.000,\nseg_00002.ts", "#EXT-X-PLAYLIST-TYPE:VOD", "#EXT-X-ENDLIST"} {
+		if !strings.Contains(pl, want) {
+			t.Errorf("playlist missing %q\n%s", want, pl)
+		}
+	}
+}
+
+func TestFFmpegArgs(t *testing.T) {
+	args := ffmpegArgs("/media/film.mkv", plan{Method: methodRemux}, 5, "/tmp/s")
+	for _, want := range [][]string{{"-ss", "30"}, {"-output_ts_offset", "30"}, {"-start_number", "5"}, {"-c:v", "copy"}, {"-c:a", "copy"}} {
+		if i := slices.Index(args, want[0]); i < 0 || i+1 >= len(args) || args[i+1] != want[1] {
+			t.Errorf("remux args missing %v: %v", want, args)
+		}
+	}
+	if slices.Index(args, "-ss") > slices.Index(args, "-i") {
+		t.Error("-ss must come before -i for a fast seek")
+	}
+
+	args = ffmpegArgs("/media/film.mkv", plan{Method: methodTranscode, MaxHeight: 720, MaxBitrate: 4_000_000}, 0, "/tmp/s")
+	if slices.Contains(args, "-ss") || slices.Contains(args, "-output_ts_offset") {
+		t.Errorf("start at 0 should not seek: %v", args)
+	}
+	for _, want := range []string{"libx264", "-force_key_frames", "-maxrate", "aac"} {
+		if !slices.Contains(args, want) {
+			t.Errorf("transcode args missing %s: %v", want, args)
+		}
+	}
+}
+
+func TestSegmentIndex(t *testing.T) {
+	if i, ok := segmentIndex("seg_00042.ts"); !ok || i != 42 {
+		t.Errorf("seg_00042.ts = %d, %v", i, ok)
+	}
+	for _, bad := range []string{"seg_42.ts", "seg_00042.ts.tmp", "ffmpeg.m3u8", "../seg_00001.ts"} {
+		if _, ok := segmentIndex(bad); ok {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+// fakeFFmpeg writes a script that logs its -start_number, writes three
+// segments from there, lists them in its playlist as cut at a 10s keyframe
+// interval and then keeps running.
+func fakeFFmpeg(t *testing.T) (path, logFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	logFile = filepath.Join(dir, "starts.log")
+	script := `#!/bin/sh
+while [ $# -gt 1 ]; do
+  case "$1" in
+    -start_number) n=$2 ;;
+    -hls_segment_filename) pat=$2 ;;
+  esac
+  shift
+done
+echo "$n" >> ` + logFile + `
+i=$n
+list="#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-MEDIA-SEQUENCE:$n
+#EXT-X-PLAYLIST-TYPE:EVENT"
+while [ $i -lt $((n+3)) ]; do
+  echo x > "$(printf "$pat" $i)"
+  list="$list
+#EXTINF:10.010,
+$(basename "$(printf "$pat" $i)")"
+  i=$((i+1))
+done
+echo "$list" > "$1"
+exec sleep 30
+`
+	path = filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path, logFile
+}
+
+func TestTranscodeSessionSeek(t *testing.T) {
+	ffmpeg, logFile := fakeFFmpeg(t)
+	root := t.TempDir()
+	m := newTranscodeManager(ffmpeg, root, 1, time.Minute)
+	info := mediaInfo{Duration: 120} // 20 segments
+	p := plan{Method: methodTranscode}
+
+	s, err := m.Start("sub", "film", "/media/film.mkv", info, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := m.Segment(ctx, s, 1); err != nil {
+		t.Fatalf("segment 1: %v", err)
+	}
+	// Far past the job's progress: the job restarts there.
+	path, err := m.Segment(ctx, s, 15)
+	if err != nil {
+		t.Fatalf("segment 15: %v", err)
+	}
+	if filepath.Base(path) != "seg_00015.ts" {
+		t.Errorf("segment 15 path = %s", path)
+	}
+	if _, err := m.Segment(ctx, s, 20); err != errSegmentRange {
+		t.Errorf("segment 20: want errSegmentRange, got %v", err)
+	}
+	data, _ := os.ReadFile(logFile)
+	if starts := strings.Fields(string(data)); !slices.Equal(starts, []string{"0", "15"}) {
+		t.Errorf("job starts = %v, want [0 15]", starts)
+	}
+
+	if _, err := m.Start("other", "film", "/media/film.mkv", info, p, 0); err != errTooManyTranscodes {
+		t.Errorf("second subscriber: want errTooManyTranscodes, got %v", err)
+	}
+	// The same subscriber and title replaces the session.
+	s2, err := m.Start("sub", "film", "/media/film.mkv", info, p, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Get(s.ID) != nil {
+		t.Error("replaced session still registered")
+	}
+	if _, err := os.Stat(s.dir); !os.IsNotExist(err) {
+		t.Error("replaced session's directory not removed")
+	}
+	m.Stop(s2.ID)
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("files left after Stop: %v", entries)
+	}
+}
+
+// TestCopySessionKeyframePlaylist checks that copied video, cut at the
+// source's 10s keyframes, is listed with FFmpeg's real segment durations
+// rather than the fixed 6s playlist, and is never restarted to seek.
+func TestCopySessionKeyframePlaylist(t *testing.T) {
+	ffmpeg, logFile := fakeFFmpeg(t)
+	m := newTranscodeManager(ffmpeg, t.TempDir(), 0, time.Minute)
+	info := mediaInfo{Duration: 120}
+
+	s, err := m.Start("sub", "film", "/media/film.mkv", info, plan{Method: methodRemux}, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(s.ID)
+	ctx := context.Background()
+	if _, err := m.Segment(ctx, s, 5); err != nil {
+		t.Fatalf("segment 5: %v", err)
+	}
+	pl := string(s.Playlist())
+	if strings.Contains(pl, "#EXTINF:6.000,") || strings.Contains(pl, "#EXT-X-ENDLIST") {
+		t.Errorf("copy session served the fixed playlist:\n%s", pl)
+	}
+	for _, want := range []string{"#EXT-X-TARGETDURATION:10", "#EXTINF:10.010,\nseg_00005.ts"} {
+		if !strings.Contains(pl, want) {
+			t.Errorf("playlist missing %q\n%s", want, pl)
+		}
+	}
+
+	// Past the job's progress: waited for, not restarted.
+	segCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if _, err := m.Segment(segCtx, s, 15); err == nil {
+		t.Error("segment 15 served before the job reached it")
+	}
+	data, _ := os.ReadFile(logFile)
+	if starts := strings.Fields(string(data)); !slices.Equal(starts, []string{"5"}) {
+		t.Errorf("job starts = %v, want [5]", starts)
+	}
+
+	// A full transcode keeps the fixed, seekable playlist.
+	s2, err := m.Start("sub", "show", "/media/show.mkv", info, plan{Method: methodTranscode}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(s2.ID)
+	if pl := string(s2.Playlist()); !strings.Contains(pl, "#EXTINF:6.000,\nseg_00000.ts") || !strings.Contains(pl, "#EXT-X-ENDLIST") {
+		t.Errorf("transcode playlist:\n%s", pl)
+	}
+}
+
+// TestTranscodeStartReservesSlot checks that concurrent Starts cannot exceed
+// the session limit.
+func TestTranscodeStartReservesSlot(t *testing.T) {
+	ffmpeg, _ := fakeFFmpeg(t)
+	m := newTranscodeManager(ffmpeg, t.TempDir(), 2, time.Minute)
+	info := mediaInfo{Duration: 60}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var started []*transcodeSession
+	ready := make(chan struct{})
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-ready
+			s, err := m.Start(fmt.Sprint("sub", i), "film", "/media/film.mkv", info, plan{Method: methodTranscode}, 0)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			started = append(started, s)
+			mu.Unlock()
+		}(i)
+	}
+	close(ready)
+	wg.Wait()
+	for _, s := range started {
+		m.Stop(s.ID)
+	}
+	if len(started) != 2 {
+		t.Errorf("%d sessions started, want 2", len(started))
+	}
+}
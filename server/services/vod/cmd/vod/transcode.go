@@ -0,0 +1,444 @@
+// transcode.go — Session-scoped FFmpeg jobs producing HLS on demand.
+//
+// Each negotiated remux/transcode gets a session with its own directory
+// under VOD_TRANSCODE_DIR. A full transcode with a known duration serves a
+// complete VOD playlist of fixed 6s segments up front (keyframes are forced
+// on the boundaries), so players can seek anywhere; FFmpeg fills the
+// segments in behind it:
+//
+//   - A requested segment that exists is served at once (segments are
+//     written to a temp file and renamed, so an existing one is complete).
+//   - One just ahead of the running job is waited for.
+//   - One behind the job's start or well ahead of it restarts the job there
+//     (-ss at the segment's start, -start_number, timestamps offset to match).
+//   - A job more than 10 segments ahead of the last request is paused
+//     (SIGSTOP) and resumed once the player is within 4.
+//   - A session without requests for VOD_TRANSCODE_IDLE (default 2m) is
+//     stopped and its directory removed.
+//
+// Copied video (remux, audio_transcode) can only be cut at the source's
+// keyframes, so segment lengths follow the source's keyframe interval and
+// cannot be listed ahead of time. Those sessions, and any with an unknown
+// duration, serve FFmpeg's own event playlist with the real segment
+// durations; they start at the resume position but cannot seek past what
+// has been produced.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	segmentSeconds = 6
+	seekAhead      = 3  // segments beyond the job's progress worth waiting for
+	throttleAhead  = 10 // pause the job this many segments ahead of the player
+	resumeAhead    = 4  // resume it once the player is this close
+	segmentWait    = 20 * time.Second
+)
+
+var (
+	errTooManyTranscodes = errors.New("too many transcode sessions")
+	errSegmentRange      = errors.New("segment out of range")
+)
+
+// transcodeSession is one player's transcode of one title.
+type transcodeSession struct {
+	ID           string
+	SubscriberID string
+	ContentID    string
+
+	plan     plan
+	source   string
+	duration float64
+	segments int // total segments of the fixed playlist; 0 = FFmpeg's event playlist
+	dir      string
+
+	mu            sync.Mutex
+	cmd           *exec.Cmd
+	done          chan struct{} // closed when cmd exits
+	startSeg      int           // first segment of the running job
+	produced      int           // first segment at or after startSeg not yet written
+	lastRequested int
+	lastAccess    time.Time
+	paused        bool
+}
+
+// transcodeManager owns every transcode session.
+type transcodeManager struct {
+	ffmpegPath  string
+	root        string
+	maxSessions int
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*transcodeSession
+	starting int // slots reserved by Start calls still launching a job
+}
+
+func newTranscodeManager(ffmpegPath, root string, maxSessions int, idleTimeout time.Duration) *transcodeManager {
+	return &transcodeManager{
+		ffmpegPath:  ffmpegPath,
+		root:        root,
+		maxSessions: maxSessions,
+		idleTimeout: idleTimeout,
+		sessions:    make(map[string]*transcodeSession),
+	}
+}
+
+// Run clears session directories left by a previous process, then throttles
+// jobs and reaps idle sessions until ctx is cancelled.
+func (m *transcodeManager) Run(ctx context.Context) {
+	if entries, err := os.ReadDir(m.root); err == nil {
+		for _, e := range entries {
+			os.RemoveAll(filepath.Join(m.root, e.Name()))
+		}
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.mu.Lock()
+			ids := make([]string, 0, len(m.sessions))
+			for id := range m.sessions {
+				ids = append(ids, id)
+			}
+			m.mu.Unlock()
+			for _, id := range ids {
+				m.Stop(id)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		sessions := make([]*transcodeSession, 0, len(m.sessions))
+		for _, s := range m.sessions {
+			sessions = append(sessions, s)
+		}
+		m.mu.Unlock()
+
+		for _, s := range sessions {
+			s.mu.Lock()
+			idle := time.Since(s.lastAccess) > m.idleTimeout
+			if !idle {
+				s.throttleLocked()
+			}
+			s.mu.Unlock()
+			if idle {
+				log.Printf("[vod] transcode session %s idle, stopping", s.ID)
+				m.Stop(s.ID)
+			}
+		}
+	}
+}
+
+// Start creates a session and launches its job at startSeconds. A
+// subscriber's earlier session for the same title is replaced.
+func (m *transcodeManager) Start(subscriberID, contentID, source string, info mediaInfo, p plan, startSeconds float64) (*transcodeSession, error) {
+	// The slot is reserved under the lock so concurrent Starts cannot all
+	// pass the limit check before any of them registers its session.
+	m.mu.Lock()
+	var replaced *transcodeSession
+	for id, s := range m.sessions {
+		if s.SubscriberID == subscriberID && s.ContentID == contentID {
+			replaced = s
+			delete(m.sessions, id)
+		}
+	}
+	if m.maxSessions > 0 && len(m.sessions)+m.starting >= m.maxSessions {
+		m.mu.Unlock()
+		return nil, errTooManyTranscodes
+	}
+	m.starting++
+	m.mu.Unlock()
+	if replaced != nil {
+		replaced.stop()
+	}
+
+	s, err := m.launch(subscriberID, contentID, source, info, p, startSeconds)
+	m.mu.Lock()
+	m.starting--
+	if err == nil {
+		m.sessions[s.ID] = s
+	}
+	m.mu.Unlock()
+	return s, err
+}
+
+// launch creates a session's directory and starts its job.
+func (m *transcodeManager) launch(subscriberID, contentID, source string, info mediaInfo, p plan, startSeconds float64) (*transcodeSession, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	s := &transcodeSession{
+		ID:           hex.EncodeToString(buf),
+		SubscriberID: subscriberID,
+		ContentID:    contentID,
+		plan:         p,
+		source:       source,
+		duration:     info.Duration,
+		lastAccess:   time.Now(),
+	}
+	total := 0
+	if info.Duration > 0 {
+		total = int((info.Duration + segmentSeconds - 1) / segmentSeconds)
+	}
+	if p.Method == methodTranscode {
+		s.segments = total
+	}
+	s.dir = filepath.Join(m.root, s.ID)
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	startSeg := 0
+	if total > 0 && startSeconds > 0 {
+		startSeg = min(int(startSeconds)/segmentSeconds, total-1)
+	}
+	s.mu.Lock()
+	err := m.startJobLocked(s, startSeg)
+	s.mu.Unlock()
+	if err != nil {
+		os.RemoveAll(s.dir)
+		return nil, err
+	}
+	log.Printf("[vod] transcode session %s: %s of %s from segment %d", s.ID, p.Method, contentID, startSeg)
+	return s, nil
+}
+
+// Get returns a session, or nil.
+func (m *transcodeManager) Get(id string) *transcodeSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[id]
+}
+
+// Stop kills a session's job and removes its files.
+func (m *transcodeManager) Stop(id string) {
+	m.mu.Lock()
+	s := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	if s != nil {
+		s.stop()
+	}
+}
+
+// stop kills the session's job and removes its files.
+func (s *transcodeSession) stop() {
+	s.mu.Lock()
+	s.killLocked()
+	s.mu.Unlock()
+	os.RemoveAll(s.dir)
+}
+
+// Segment returns the path of segment idx once it has been written,
+// restarting the job at idx if it is not near the job's progress.
+func (m *transcodeManager) Segment(ctx context.Context, s *transcodeSession, idx int) (string, error) {
+	if idx < 0 || (s.segments > 0 && idx >= s.segments) {
+		return "", errSegmentRange
+	}
+	path := filepath.Join(s.dir, segmentName(idx))
+
+	s.mu.Lock()
+	s.lastAccess = time.Now()
+	s.lastRequested = idx
+	if !fileExists(path) {
+		s.advanceLocked()
+		if s.segments > 0 && (!s.runningLocked() || idx < s.startSeg || idx > s.produced+seekAhead) {
+			if err := m.startJobLocked(s, idx); err != nil {
+				s.mu.Unlock()
+				return "", err
+			}
+		}
+	}
+	s.throttleLocked()
+	done := s.done
+	s.mu.Unlock()
+
+	deadline := time.NewTimer(segmentWait)
+	defer deadline.Stop()
+	tick := time.NewTicker(200 * time.Millisecond)
+	defer tick.Stop()
+	for !fileExists(path) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-deadline.C:
+			return "", errors.New("segment not ready")
+		case <-done:
+			if fileExists(path) {
+				return path, nil
+			}
+			return "", errors.New("transcode ended before the segment")
+		case <-tick.C:
+		}
+	}
+	return path, nil
+}
+
+// Playlist returns the session's HLS playlist.
+func (s *transcodeSession) Playlist() []byte {
+	s.mu.Lock()
+	s.lastAccess = time.Now()
+	s.mu.Unlock()
+	if s.segments == 0 {
+		data, _ := os.ReadFile(filepath.Join(s.dir, "ffmpeg.m3u8"))
+		return data
+	}
+	return vodPlaylist(s.duration)
+}
+
+// vodPlaylist lists every segment of a title of the given duration. Only
+// valid for full transcodes, whose keyframes are forced every segmentSeconds.
+func vodPlaylist(duration float64) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", segmentSeconds)
+	for i := 0; float64(i*segmentSeconds) < duration; i++ {
+		d := min(float64(segmentSeconds), duration-float64(i*segmentSeconds))
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", d, segmentName(i))
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return []byte(b.String())
+}
+
+// startJobLocked (re)starts the session's FFmpeg job at segment seg. Must
+// hold s.mu.
+func (m *transcodeManager) startJobLocked(s *transcodeSession, seg int) error {
+	s.killLocked()
+	cmd := exec.Command(m.ffmpegPath, ffmpegArgs(s.source, s.plan, seg, s.dir)...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	s.cmd, s.done = cmd, done
+	s.startSeg, s.produced, s.paused = seg, seg, false
+	return nil
+}
+
+// killLocked stops the running job, if any, and waits for it. Must hold s.mu.
+func (s *transcodeSession) killLocked() {
+	if s.cmd == nil {
+		return
+	}
+	s.cmd.Process.Kill() // also ends a stopped (paused) process
+	<-s.done
+	s.cmd = nil
+}
+
+func (s *transcodeSession) runningLocked() bool {
+	if s.cmd == nil {
+		return false
+	}
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// advanceLocked moves produced past the segments the job has written.
+func (s *transcodeSession) advanceLocked() {
+	for fileExists(filepath.Join(s.dir, segmentName(s.produced))) {
+		s.produced++
+	}
+}
+
+// throttleLocked pauses a job running far ahead of the player and resumes it
+// when the player catches up. Must hold s.mu.
+func (s *transcodeSession) throttleLocked() {
+	if !s.runningLocked() {
+		return
+	}
+	s.advanceLocked()
+	ahead := s.produced - s.lastRequested
+	switch {
+	case !s.paused && ahead > throttleAhead:
+		s.cmd.Process.Signal(syscall.SIGSTOP)
+		s.paused = true
+	case s.paused && ahead <= resumeAhead:
+		s.cmd.Process.Signal(syscall.SIGCONT)
+		s.paused = false
+	}
+}
+
+// ffmpegArgs builds the job for a plan, starting at segment startSeg.
+func ffmpegArgs(source string, p plan, startSeg int, dir string) []string {
+	offset := fmt.Sprint(startSeg * segmentSeconds)
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin"}
+	if startSeg > 0 {
+		args = append(args, "-ss", offset)
+	}
+	args = append(args, "-i", source, "-map", "0:v:0?", "-map", "0:a:0?", "-sn")
+
+	if p.Method == methodTranscode {
+		args = append(args,
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+			// Keyframes on segment boundaries so segments match the playlist.
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentSeconds),
+		)
+		if p.MaxHeight > 0 {
+			args = append(args, "-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", p.MaxHeight))
+		}
+		if p.MaxBitrate > 0 {
+			video := max(p.MaxBitrate-192_000, 500_000)
+			args = append(args, "-maxrate", fmt.Sprint(video), "-bufsize", fmt.Sprint(2*video))
+		}
+	} else {
+		args = append(args, "-c:v", "copy")
+	}
+	if p.Method == methodRemux {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", "192k", "-ac", "2")
+	}
+
+	if startSeg > 0 {
+		args = append(args, "-output_ts_offset", offset)
+	}
+	return append(args,
+		"-f", "hls",
+		"-hls_time", fmt.Sprint(segmentSeconds),
+		"-hls_list_size", "0",
+		"-hls_playlist_type", "event",
+		"-hls_flags", "temp_file",
+		"-start_number", fmt.Sprint(startSeg),
+		"-hls_segment_filename", filepath.Join(dir, "seg_%05d.ts"),
+		filepath.Join(dir, "ffmpeg.m3u8"),
+	)
+}
+
+func segmentName(i int) string { return fmt.Sprintf("seg_%05d.ts", i) }
+
+// segmentIndex parses a segment file name.
+func segmentIndex(name string) (int, bool) {
+	var i int
+	if _, err := fmt.Sscanf(name, "seg_%05d.ts", &i); err != nil || segmentName(i) != name {
+		return 0, false
+	}
+	return i, true
+}
+
+func fileExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,73 @@
+// broker.go — Cross-replica fan-out for watch party rooms.
+package watchparty
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// notifyChannel is the Postgres NOTIFY channel every room event goes through.
+const notifyChannel = "watch_party_events"
+
+// Broker carries room events between processes. Every published payload must
+// be delivered to every subscribed process, including the publisher.
+type Broker interface {
+	// Publish sends one JSON-encoded Event.
+	Publish(ctx context.Context, payload []byte) error
+	// Run calls deliver for each payload until ctx is cancelled.
+	Run(ctx context.Context, deliver func(payload []byte))
+}
+
+// PGBroker is a Broker over Postgres LISTEN/NOTIFY, so owl_api and billing
+// share rooms without any infrastructure beyond the database they already use.
+type PGBroker struct {
+	db  *sql.DB
+	dsn string
+}
+
+// NewPGBroker creates a PGBroker. Publish uses db; Run opens its own
+// listening connection to dsn.
+func NewPGBroker(db *sql.DB, dsn string) *PGBroker {
+	return &PGBroker{db: db, dsn: dsn}
+}
+
+// Publish implements Broker.
+func (b *PGBroker) Publish(ctx context.Context, payload []byte) error {
+	_, err := b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, notifyChannel, string(payload))
+	return err
+}
+
+// Run implements Broker. The listener reconnects on its own; events
+// published while it is disconnected are lost, and clients catch up from
+// the next event or their next sync correction.
+func (b *PGBroker) Run(ctx context.Context, deliver func(payload []byte)) {
+	l := pq.NewListener(b.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("[watchparty] listener: %v", err)
+		}
+	})
+	defer l.Close()
+	if err := l.Listen(notifyChannel); err != nil {
+		log.Printf("[watchparty] listen %s: %v", notifyChannel, err)
+		return
+	}
+
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-l.Notify:
+			if n != nil { // nil after a reconnect
+				deliver([]byte(n.Extra))
+			}
+		case <-ping.C:
+			go l.Ping()
+		}
+	}
+}
@@ -0,0 +1,672 @@
+// Package watchparty keeps watch party participants in sync.
+//
+// Each party is a room. Participants hold a WebSocket open to the room
+// (owl_api: GET /owl/v1/watch-party/{id}/ws, billing: GET /watch-party/{id}/ws)
+// and exchange JSON events {"type": ...}:
+//
+//	play, pause, seek  host → room   {position}          playback control
+//	channel            host → room   {channel_slug}      switch live channel
+//	sync               any  → server {position}          periodic drift report
+//	chat               any  → room   {text}
+//	ping               any  → server {client_time}       clock offset probe
+//
+//	welcome     server → client   state, participants, server_time
+//	correction  server → client   {position, drift}   guest off by > DriftTolerance
+//	presence    server → room     {from, display_name, status: joined|left}
+//	pong        server → client   {client_time, server_time}
+//	ended       server → room     party ended by the host
+//	error       server → client   {code, message}
+//
+// Every event the server emits carries server_time (Unix ms). Positions are
+// seconds into the content as reported by the host's player; the shared state
+// is (playing, position, updated_at), so the expected position at any moment
+// is position + elapsed while playing. Guests report their position with sync
+// every few seconds and get a correction when they have drifted. The host's
+// sync reports move the reference instead, so buffering on the host doesn't
+// pull every guest ahead of it.
+//
+// Each connection has its own writer goroutine draining a bounded send queue,
+// so one slow participant never holds up delivery to the rest of the room: a
+// client whose queue fills up, or whose socket write fails, is disconnected.
+// Chat is rate limited per connection (chatBurst, then one per chatInterval),
+// since every room event is a Postgres NOTIFY.
+//
+// Room events fan out through a Broker so participants connected to different
+// services (owl_api, billing) or replicas share one room. Playback state and
+// presence are also written to watch_parties / watch_party_participants so a
+// participant connecting anywhere gets the current state in its welcome.
+package watchparty
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/unyeco/roost/pkg/wsconn"
+)
+
+const (
+	// DriftTolerance is how far a guest may be from the host before it is
+	// sent a correction.
+	DriftTolerance = 2 * time.Second
+
+	// MaxChatLength caps a chat message (runes). It also keeps every event
+	// well under the 8000-byte Postgres NOTIFY payload limit.
+	MaxChatLength = 500
+
+	pingInterval = 30 * time.Second
+	// readTimeout drops a client that sent nothing (not even a pong) for
+	// this long.
+	readTimeout = 90 * time.Second
+	// seenInterval throttles last_seen_at writes per connection.
+	seenInterval = 20 * time.Second
+	// presenceWindow is how recently a participant must have been seen to
+	// be listed as present in a welcome.
+	presenceWindow = 60 * time.Second
+	// sendQueueSize is how many events may wait for a client's writer
+	// before the client is dropped as too slow.
+	sendQueueSize = 64
+	// chatBurst messages may be sent at once; after that, one per
+	// chatInterval.
+	chatBurst    = 5
+	chatInterval = time.Second
+)
+
+var (
+	// ErrPartyNotFound is returned for unknown or ended parties.
+	ErrPartyNotFound = errors.New("watch party not found")
+	// ErrNotParticipant is returned when the subscriber hasn't joined the party.
+	ErrNotParticipant = errors.New("not a participant in this watch party")
+)
+
+// Event is one message on a room connection and between replicas.
+type Event struct {
+	Type        string   `json:"type"`
+	PartyID     string   `json:"party_id,omitempty"`
+	From        string   `json:"from,omitempty"` // sender subscriber ID
+	DisplayName string   `json:"display_name,omitempty"`
+	Position    *float64 `json:"position,omitempty"`
+	Playing     *bool    `json:"playing,omitempty"`
+	ChannelSlug string   `json:"channel_slug,omitempty"`
+	Text        string   `json:"text,omitempty"`
+	Status      string   `json:"status,omitempty"` // presence: joined | left
+	Drift       *float64 `json:"drift,omitempty"`  // correction: seconds ahead (+) or behind (-)
+	ClientTime  int64    `json:"client_time,omitempty"`
+	ServerTime  int64    `json:"server_time,omitempty"`
+	Code        string   `json:"code,omitempty"`
+	Message     string   `json:"message,omitempty"`
+}
+
+// hostSync is the replica-only event that moves the reference position.
+// It is applied to room state but never sent to clients.
+const hostSync = "host_sync"
+
+// State is a party's shared playback state.
+type State struct {
+	Playing     bool      `json:"playing"`
+	Position    float64   `json:"position"`
+	UpdatedAt   time.Time `json:"-"`
+	ChannelSlug string    `json:"channel_slug,omitempty"`
+}
+
+// PositionAt is the expected playback position at t.
+func (st State) PositionAt(t time.Time) float64 {
+	if !st.Playing || st.UpdatedAt.IsZero() || t.Before(st.UpdatedAt) {
+		return st.Position
+	}
+	return st.Position + t.Sub(st.UpdatedAt).Seconds()
+}
+
+// apply folds a room event into the state.
+func (st *State) apply(ev Event) {
+	at := time.UnixMilli(ev.ServerTime)
+	switch ev.Type {
+	case "play", "pause", "seek", hostSync:
+		if ev.Position != nil {
+			st.Position = *ev.Position
+		}
+		if ev.Playing != nil {
+			st.Playing = *ev.Playing
+		}
+		st.UpdatedAt = at
+	case "channel":
+		st.ChannelSlug = ev.ChannelSlug
+		st.Position = 0
+		st.Playing = true
+		st.UpdatedAt = at
+	}
+}
+
+// Participant is one present member of a room.
+type Participant struct {
+	SubscriberID string `json:"subscriber_id"`
+	DisplayName  string `json:"display_name"`
+	IsHost       bool   `json:"is_host"`
+}
+
+type welcome struct {
+	Type         string        `json:"type"`
+	PartyID      string        `json:"party_id"`
+	You          string        `json:"you"`
+	IsHost       bool          `json:"is_host"`
+	State        State         `json:"state"`
+	UpdatedAt    int64         `json:"state_updated_at"`
+	Participants []Participant `json:"participants"`
+	ServerTime   int64         `json:"server_time"`
+}
+
+type client struct {
+	ws           *wsconn.Conn
+	subscriberID string
+	displayName  string
+	isHost       bool
+	seenAt       time.Time
+	chatAt       time.Time // chat rate limit: when the next message is free
+
+	queue     chan []byte // nil entry: close after the events before it
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(ws *wsconn.Conn, subscriberID, displayName string, isHost bool) *client {
+	return &client{
+		ws: ws, subscriberID: subscriberID, displayName: displayName, isHost: isHost,
+		queue: make(chan []byte, sendQueueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+type room struct {
+	id      string
+	mu      sync.Mutex
+	state   State
+	clients map[*client]struct{}
+}
+
+// Hub holds this process's rooms.
+type Hub struct {
+	db     *sql.DB
+	broker Broker
+
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+// NewHub creates a Hub. broker may be nil (rooms are local to this process).
+func NewHub(db *sql.DB, broker Broker) *Hub {
+	return &Hub{db: db, broker: broker, rooms: map[string]*room{}}
+}
+
+// Run delivers events from other replicas until ctx is cancelled. It returns
+// immediately when the Hub has no broker.
+func (h *Hub) Run(ctx context.Context) {
+	if h.broker == nil {
+		return
+	}
+	h.broker.Run(ctx, h.deliver)
+}
+
+// End tells every participant the party is over and closes their connections.
+func (h *Hub) End(ctx context.Context, partyID string) {
+	h.publish(ctx, Event{Type: "ended", PartyID: partyID, ServerTime: nowMillis()})
+}
+
+// ServeWS authorizes subscriberID for the party, upgrades the request and
+// runs the connection until it closes. On failure before the upgrade it
+// writes an HTTP error response.
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request, partyID, subscriberID string) {
+	m, err := h.loadMember(r.Context(), partyID, subscriberID)
+	switch {
+	case errors.Is(err, ErrPartyNotFound):
+		http.Error(w, `{"error":"party_not_found"}`, http.StatusNotFound)
+		return
+	case errors.Is(err, ErrNotParticipant):
+		http.Error(w, `{"error":"not_participant"}`, http.StatusForbidden)
+		return
+	case err != nil:
+		log.Printf("[watchparty] load party %s: %v", partyID, err)
+		http.Error(w, `{"error":"db_error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	ws, err := wsconn.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	c := newClient(ws, subscriberID, m.displayName, m.isHost)
+	go c.writeLoop()
+	rm := h.join(partyID, m.state, c)
+	ctx := context.Background()
+	defer func() {
+		h.leave(rm, c)
+		c.close()
+		h.setSeen(ctx, partyID, subscriberID, false)
+		h.publish(ctx, Event{Type: "presence", PartyID: partyID, From: subscriberID,
+			DisplayName: c.displayName, Status: "left", ServerTime: nowMillis()})
+	}()
+
+	h.setSeen(ctx, partyID, subscriberID, true)
+	c.seenAt = time.Now()
+	participants, err := h.presentParticipants(ctx, partyID)
+	if err != nil {
+		log.Printf("[watchparty] participants %s: %v", partyID, err)
+	}
+	rm.mu.Lock()
+	st := rm.state
+	rm.mu.Unlock()
+	c.send(welcome{
+		Type:         "welcome",
+		PartyID:      partyID,
+		You:          subscriberID,
+		IsHost:       c.isHost,
+		State:        st,
+		UpdatedAt:    st.UpdatedAt.UnixMilli(),
+		Participants: participants,
+		ServerTime:   nowMillis(),
+	})
+	h.publish(ctx, Event{Type: "presence", PartyID: partyID, From: subscriberID,
+		DisplayName: c.displayName, Status: "joined", ServerTime: nowMillis()})
+
+	for {
+		_ = ws.SetReadDeadline(time.Now().Add(readTimeout))
+		raw, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if time.Since(c.seenAt) > seenInterval {
+			h.setSeen(ctx, partyID, subscriberID, true)
+			c.seenAt = time.Now()
+		}
+		var ev Event
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			c.sendError("invalid_json", "Messages must be JSON objects")
+			continue
+		}
+		h.handle(ctx, rm, c, ev)
+	}
+}
+
+// handle processes one client event.
+func (h *Hub) handle(ctx context.Context, rm *room, c *client, ev Event) {
+	now := time.Now()
+	out := Event{Type: ev.Type, PartyID: rm.id, From: c.subscriberID, DisplayName: c.displayName, ServerTime: now.UnixMilli()}
+
+	switch ev.Type {
+	case "play", "pause", "seek":
+		if !c.isHost {
+			c.sendError("not_host", "Only the host controls playback")
+			return
+		}
+		if ev.Position == nil || *ev.Position < 0 {
+			c.sendError("invalid_position", "position (seconds, >= 0) is required")
+			return
+		}
+		rm.mu.Lock()
+		playing := rm.state.Playing
+		rm.mu.Unlock()
+		switch ev.Type {
+		case "play":
+			playing = true
+		case "pause":
+			playing = false
+		}
+		out.Position, out.Playing = ev.Position, &playing
+		if err := h.saveState(ctx, rm.id, playing, *ev.Position); err != nil {
+			log.Printf("[watchparty] save state %s: %v", rm.id, err)
+		}
+		h.publish(ctx, out)
+
+	case "channel":
+		if !c.isHost {
+			c.sendError("not_host", "Only the host can change the channel")
+			return
+		}
+		slug := strings.TrimSpace(ev.ChannelSlug)
+		if slug == "" {
+			c.sendError("invalid_channel", "channel_slug is required")
+			return
+		}
+		err := h.saveChannel(ctx, rm.id, slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.sendError("channel_not_found", "Channel not found, or this is not a live party")
+			return
+		}
+		if err != nil {
+			log.Printf("[watchparty] change channel %s: %v", rm.id, err)
+			c.sendError("internal_error", "Channel change failed")
+			return
+		}
+		out.ChannelSlug = slug
+		h.publish(ctx, out)
+
+	case "sync":
+		if ev.Position == nil {
+			c.sendError("invalid_position", "position (seconds) is required")
+			return
+		}
+		rm.mu.Lock()
+		st := rm.state
+		rm.mu.Unlock()
+		if c.isHost {
+			out.Type = hostSync
+			out.Position, out.Playing = ev.Position, &st.Playing
+			h.publish(ctx, out)
+			return
+		}
+		if corr, ok := correction(st, *ev.Position, now); ok {
+			c.send(corr)
+		}
+
+	case "chat":
+		text := strings.TrimSpace(ev.Text)
+		if text == "" {
+			return
+		}
+		if utf8.RuneCountInString(text) > MaxChatLength {
+			c.sendError("chat_too_long", "Chat messages are limited to 500 characters")
+			return
+		}
+		if !c.chatAllowed(now) {
+			c.sendError("chat_rate_limited", "Too many chat messages; wait a moment")
+			return
+		}
+		out.Text = text
+		h.publish(ctx, out)
+
+	case "ping":
+		c.send(Event{Type: "pong", ClientTime: ev.ClientTime, ServerTime: now.UnixMilli()})
+
+	default:
+		c.sendError("unknown_type", "Unknown event type "+ev.Type)
+	}
+}
+
+// correction returns the event that moves a guest reporting position back to
+// the room's expected position, if it has drifted beyond DriftTolerance.
+func correction(st State, position float64, now time.Time) (Event, bool) {
+	expected := st.PositionAt(now)
+	drift := position - expected
+	if math.Abs(drift) <= DriftTolerance.Seconds() {
+		return Event{}, false
+	}
+	playing := st.Playing
+	return Event{
+		Type:       "correction",
+		Position:   &expected,
+		Playing:    &playing,
+		Drift:      &drift,
+		ServerTime: now.UnixMilli(),
+	}, true
+}
+
+// publish sends a room event to every replica (or straight to this process's
+// room when there is no broker).
+func (h *Hub) publish(ctx context.Context, ev Event) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	if h.broker == nil {
+		h.deliver(payload)
+		return
+	}
+	if err := h.broker.Publish(ctx, payload); err != nil {
+		log.Printf("[watchparty] publish %s: %v", ev.Type, err)
+		// Local participants still get the event.
+		h.deliver(payload)
+	}
+}
+
+// deliver applies a published event to the local room and fans it out.
+func (h *Hub) deliver(payload []byte) {
+	var ev Event
+	if err := json.Unmarshal(payload, &ev); err != nil || ev.PartyID == "" {
+		return
+	}
+	h.mu.Lock()
+	rm := h.rooms[ev.PartyID]
+	h.mu.Unlock()
+	if rm == nil {
+		return
+	}
+
+	rm.mu.Lock()
+	rm.state.apply(ev)
+	clients := make([]*client, 0, len(rm.clients))
+	for c := range rm.clients {
+		clients = append(clients, c)
+	}
+	rm.mu.Unlock()
+
+	if ev.Type == hostSync {
+		return
+	}
+	for _, c := range clients {
+		if ev.Type == "presence" && c.subscriberID == ev.From {
+			continue // own join/leave
+		}
+		c.send(ev)
+		if ev.Type == "ended" {
+			c.closeAfterQueued()
+		}
+	}
+}
+
+func (h *Hub) join(partyID string, st State, c *client) *room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rm := h.rooms[partyID]
+	if rm == nil {
+		rm = &room{id: partyID, state: st, clients: map[*client]struct{}{}}
+		h.rooms[partyID] = rm
+	}
+	rm.mu.Lock()
+	rm.clients[c] = struct{}{}
+	rm.mu.Unlock()
+	return rm
+}
+
+func (h *Hub) leave(rm *room, c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rm.mu.Lock()
+	delete(rm.clients, c)
+	empty := len(rm.clients) == 0
+	rm.mu.Unlock()
+	if empty && h.rooms[rm.id] == rm {
+		delete(h.rooms, rm.id)
+	}
+}
+
+// send queues v for the client's writer without blocking. A client whose
+// queue is full is closed.
+func (c *client) send(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	select {
+	case c.queue <- b:
+	default:
+		log.Printf("[watchparty] %s: send queue full, disconnecting", c.subscriberID)
+		c.close()
+	}
+}
+
+// closeAfterQueued closes the client once the events already queued are written.
+func (c *client) closeAfterQueued() {
+	select {
+	case c.queue <- nil:
+	default:
+		c.close()
+	}
+}
+
+// close stops the client's writer, which closes the socket; the read loop in
+// ServeWS then ends and the client leaves its room.
+func (c *client) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// writeLoop writes queued events and keepalive pings until the client is
+// closed or a write fails.
+func (c *client) writeLoop() {
+	t := time.NewTicker(pingInterval)
+	defer t.Stop()
+	defer c.ws.Close()
+	defer c.close()
+	for {
+		select {
+		case b := <-c.queue:
+			if b == nil {
+				return
+			}
+			if err := c.ws.WriteText(b); err != nil {
+				return
+			}
+		case <-t.C:
+			if err := c.ws.Ping(); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// chatAllowed reports whether the client may send a chat message at now,
+// allowing chatBurst at once and then one per chatInterval.
+func (c *client) chatAllowed(now time.Time) bool {
+	if c.chatAt.Before(now) {
+		c.chatAt = now
+	}
+	if c.chatAt.Sub(now) >= chatBurst*chatInterval {
+		return false
+	}
+	c.chatAt = c.chatAt.Add(chatInterval)
+	return true
+}
+
+func (c *client) sendError(code, msg string) {
+	c.send(Event{Type: "error", Code: code, Message: msg, ServerTime: nowMillis()})
+}
+
+func nowMillis() int64 { return time.Now().UnixMilli() }
+
+// ---- persistence -----------------------------------------------------------
+
+type member struct {
+	isHost      bool
+	displayName string
+	state       State
+}
+
+// loadMember checks the subscriber belongs to the active party and loads the
+// party's playback state.
+func (h *Hub) loadMember(ctx context.Context, partyID, subscriberID string) (member, error) {
+	var m member
+	if _, err := uuid.Parse(partyID); err != nil {
+		return m, ErrPartyNotFound
+	}
+	var hostID string
+	var memberID sql.NullString
+	var updatedAt time.Time
+	err := h.db.QueryRowContext(ctx, `
+		SELECT wp.host_subscriber_id, coalesce(c.slug, ''), wp.playback_playing, wp.playback_position,
+		       coalesce(wp.playback_updated_at, wp.started_at),
+		       wpp.subscriber_id, coalesce(s.display_name, '')
+		FROM watch_parties wp
+		LEFT JOIN channels c ON c.id = wp.channel_id
+		LEFT JOIN watch_party_participants wpp
+		       ON wpp.party_id = wp.id AND wpp.subscriber_id = $2 AND wpp.left_at IS NULL
+		LEFT JOIN subscribers s ON s.id = wpp.subscriber_id
+		WHERE wp.id = $1 AND wp.status = 'active'
+	`, partyID, subscriberID).Scan(&hostID, &m.state.ChannelSlug, &m.state.Playing, &m.state.Position,
+		&updatedAt, &memberID, &m.displayName)
+	if err == sql.ErrNoRows {
+		return m, ErrPartyNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+	if !memberID.Valid {
+		return m, ErrNotParticipant
+	}
+	m.state.UpdatedAt = updatedAt
+	m.isHost = hostID == subscriberID
+	return m, nil
+}
+
+func (h *Hub) saveState(ctx context.Context, partyID string, playing bool, position float64) error {
+	_, err := h.db.ExecContext(ctx, `
+		UPDATE watch_parties
+		SET playback_playing = $2, playback_position = $3, playback_updated_at = NOW()
+		WHERE id = $1
+	`, partyID, playing, position)
+	return err
+}
+
+// saveChannel switches a live party's channel. sql.ErrNoRows means the
+// channel doesn't exist or the party isn't live.
+func (h *Hub) saveChannel(ctx context.Context, partyID, slug string) error {
+	res, err := h.db.ExecContext(ctx, `
+		UPDATE watch_parties wp
+		SET channel_id = c.id, playback_playing = TRUE, playback_position = 0, playback_updated_at = NOW()
+		FROM channels c
+		WHERE wp.id = $1 AND wp.content_type = 'live' AND c.slug = $2 AND c.is_active = TRUE
+	`, partyID, slug)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// setSeen marks a participant present (last_seen_at = now) or gone (NULL).
+func (h *Hub) setSeen(ctx context.Context, partyID, subscriberID string, present bool) {
+	q := `UPDATE watch_party_participants SET last_seen_at = NOW() WHERE party_id = $1 AND subscriber_id = $2`
+	if !present {
+		q = `UPDATE watch_party_participants SET last_seen_at = NULL WHERE party_id = $1 AND subscriber_id = $2`
+	}
+	if _, err := h.db.ExecContext(ctx, q, partyID, subscriberID); err != nil {
+		log.Printf("[watchparty] last_seen %s: %v", partyID, err)
+	}
+}
+
+// presentParticipants lists participants seen within presenceWindow, on any replica.
+func (h *Hub) presentParticipants(ctx context.Context, partyID string) ([]Participant, error) {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT wpp.subscriber_id, coalesce(s.display_name, ''), wpp.subscriber_id = wp.host_subscriber_id
+		FROM watch_party_participants wpp
+		JOIN watch_parties wp ON wp.id = wpp.party_id
+		JOIN subscribers s ON s.id = wpp.subscriber_id
+		WHERE wpp.party_id = $1 AND wpp.left_at IS NULL
+		  AND wpp.last_seen_at > NOW() - make_interval(secs => $2)
+		ORDER BY wpp.joined_at ASC
+	`, partyID, presenceWindow.Seconds())
+	if err != nil {
+		return []Participant{}, err
+	}
+	defer rows.Close()
+	participants := []Participant{}
+	for rows.Next() {
+		var p Participant
+		if err := rows.Scan(&p.SubscriberID, &p.DisplayName, &p.IsHost); err != nil {
+			return participants, err
+		}
+		participants = append(participants, p)
+	}
+	return participants, rows.Err()
+}
@@ -0,0 +1,137 @@
+// room_test.go — Unit tests for watch party playback state and drift correction.
+package watchparty
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+// TestPositionAtAdvancesOnlyWhilePlaying verifies expected position math.
+func TestPositionAtAdvancesOnlyWhilePlaying(t *testing.T) {
+	t0 := time.Unix(1_700_000_000, 0)
+	st := State{Playing: true, Position: 100, UpdatedAt: t0}
+	if got := st.PositionAt(t0.Add(30 * time.Second)); got != 130 {
+		t.Errorf("playing: got %v, want 130", got)
+	}
+	st.Playing = false
+	if got := st.PositionAt(t0.Add(30 * time.Second)); got != 100 {
+		t.Errorf("paused: got %v, want 100", got)
+	}
+	st.Playing = true
+	if got := st.PositionAt(t0.Add(-time.Second)); got != 100 {
+		t.Errorf("before update: got %v, want 100", got)
+	}
+}
+
+// TestStateApply verifies host events move the shared state.
+func TestStateApply(t *testing.T) {
+	var st State
+	at := time.Unix(1_700_000_000, 0)
+	st.apply(Event{Type: "play", Position: ptr(42.0), Playing: ptr(true), ServerTime: at.UnixMilli()})
+	if !st.Playing || st.Position != 42 || !st.UpdatedAt.Equal(at) {
+		t.Fatalf("after play: %+v", st)
+	}
+	st.apply(Event{Type: "pause", Position: ptr(50.0), Playing: ptr(false), ServerTime: at.Add(8 * time.Second).UnixMilli()})
+	if st.Playing || st.Position != 50 {
+		t.Fatalf("after pause: %+v", st)
+	}
+	st.apply(Event{Type: "channel", ChannelSlug: "news", ServerTime: at.Add(time.Minute).UnixMilli()})
+	if st.ChannelSlug != "news" || st.Position != 0 || !st.Playing {
+		t.Fatalf("after channel: %+v", st)
+	}
+	st.apply(Event{Type: "chat", Text: "hi", ServerTime: at.Add(2 * time.Minute).UnixMilli()})
+	if !st.UpdatedAt.Equal(at.Add(time.Minute)) {
+		t.Error("chat must not touch playback state")
+	}
+}
+
+// TestCorrection verifies guests inside DriftTolerance are left alone and
+// guests outside it are sent the expected position.
+func TestCorrection(t *testing.T) {
+	t0 := time.Unix(1_700_000_000, 0)
+	st := State{Playing: true, Position: 60, UpdatedAt: t0}
+	now := t0.Add(10 * time.Second) // expected 70
+
+	if _, ok := correction(st, 71.5, now); ok {
+		t.Error("1.5s ahead is within tolerance")
+	}
+	if _, ok := correction(st, 68.5, now); ok {
+		t.Error("1.5s behind is within tolerance")
+	}
+	ev, ok := correction(st, 64, now)
+	if !ok {
+		t.Fatal("6s behind should be corrected")
+	}
+	if ev.Type != "correction" || *ev.Position != 70 || math.Abs(*ev.Drift+6) > 1e-9 || !*ev.Playing {
+		t.Errorf("unexpected correction %+v", ev)
+	}
+	if ev.ServerTime != now.UnixMilli() {
+		t.Error("correction must carry server_time")
+	}
+}
+
+// TestDeliverWithoutBroker verifies local delivery updates the room state and
+// that replica-only host syncs are applied.
+func TestDeliverWithoutBroker(t *testing.T) {
+	h := NewHub(nil, nil)
+	rm := &room{id: "p1", clients: map[*client]struct{}{}}
+	h.rooms["p1"] = rm
+
+	at := time.Now()
+	payload, _ := json.Marshal(Event{Type: hostSync, PartyID: "p1", Position: ptr(12.0), Playing: ptr(true), ServerTime: at.UnixMilli()})
+	h.deliver(payload)
+	if rm.state.Position != 12 || !rm.state.Playing {
+		t.Errorf("host sync not applied: %+v", rm.state)
+	}
+
+	// Events for rooms with no local participants are dropped.
+	payload, _ = json.Marshal(Event{Type: "seek", PartyID: "other", Position: ptr(1.0), ServerTime: at.UnixMilli()})
+	h.deliver(payload)
+	if _, ok := h.rooms["other"]; ok {
+		t.Error("deliver must not create rooms")
+	}
+}
+
+// TestSendDropsSlowClient verifies a client whose queue is full is closed
+// instead of blocking delivery.
+func TestSendDropsSlowClient(t *testing.T) {
+	c := newClient(nil, "s1", "", false)
+	for i := 0; i < sendQueueSize; i++ {
+		c.send(Event{Type: "chat", Text: "hi"})
+	}
+	select {
+	case <-c.done:
+		t.Fatal("client closed before its queue was full")
+	default:
+	}
+	c.send(Event{Type: "chat", Text: "one too many"})
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("client with a full queue was not closed")
+	}
+}
+
+// TestChatAllowed verifies the chat burst and the steady rate after it.
+func TestChatAllowed(t *testing.T) {
+	c := newClient(nil, "s1", "", false)
+	t0 := time.Unix(1_700_000_000, 0)
+	for i := 0; i < chatBurst; i++ {
+		if !c.chatAllowed(t0) {
+			t.Fatalf("message %d of the burst refused", i+1)
+		}
+	}
+	if c.chatAllowed(t0) {
+		t.Error("message past the burst allowed")
+	}
+	if !c.chatAllowed(t0.Add(chatInterval)) {
+		t.Error("message after chatInterval refused")
+	}
+	if c.chatAllowed(t0.Add(chatInterval)) {
+		t.Error("second message in the same interval allowed")
+	}
+}